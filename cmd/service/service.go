@@ -8,21 +8,44 @@
 // @name Authorization
 // @securityDefinitions.oauth2.application OAuth2Application
 // @tokenUrl /api/oauth/token
+// @scope.openid         OpenID Connect 登入，核發 id_token
+// @scope.profile        讀取使用者名稱
+// @scope.email          讀取使用者 email
+// @scope.users:read     讀取使用者資料
+// @scope.users:write    修改或刪除使用者資料
+// @scope.clients:manage 管理使用者的 OAuth client
+// @scope.keys:manage    輪替 JWT 簽章金鑰（僅限管理員）
 // @securityDefinitions.oauth2.password OAuth2Password
 // @tokenUrl /api/oauth/token
+// @scope.openid         OpenID Connect 登入，核發 id_token
+// @scope.profile        讀取使用者名稱
+// @scope.email          讀取使用者 email
+// @scope.users:read     讀取使用者資料
+// @scope.users:write    修改或刪除使用者資料
+// @scope.clients:manage 管理使用者的 OAuth client
+// @scope.keys:manage    輪替 JWT 簽章金鑰（僅限管理員）
 package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
+	"time"
 
 	"life-is-hard/internal/cache"
 	"life-is-hard/internal/database"
+	"life-is-hard/internal/mailer"
 	"life-is-hard/internal/router"
+	"life-is-hard/internal/service"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
@@ -49,8 +72,10 @@ var (
 	newRedisClient  = cache.NewRedisClient
 	runMigrationsFn = database.RunMigrations
 	startServer     = func(e *echo.Echo, addr string) error { return e.Start(addr) }
+	startTLSServer  = func(e *echo.Echo, s *http.Server) error { return e.StartServer(s) }
 	spawnWorkers    = defaultSpawnWorkers
 	exitFunc        = os.Exit
+	ensureKeyStore  = (*service.KeyStore).Ensure
 )
 
 func run() error {
@@ -90,6 +115,11 @@ func run() error {
 		return fmt.Errorf("環境變數 REDIS_PASSWORD 未設定")
 	}
 
+	// issuer 出現在 token 與 discovery 中，不可由請求的 Host header 推得
+	if os.Getenv("OAUTH_ISSUER") == "" {
+		return fmt.Errorf("環境變數 OAUTH_ISSUER 未設定")
+	}
+
 	db, err := newPgxPool(context.Background(), dbURL)
 	if err != nil {
 		return fmt.Errorf("DB 連線失敗: %v", err)
@@ -106,6 +136,31 @@ func run() error {
 		return fmt.Errorf("Migration 執行失敗: %v", err)
 	}
 
+	if err := setupSigningKeys(db); err != nil {
+		return fmt.Errorf("簽章金鑰初始化失敗: %v", err)
+	}
+
+	tlsConfig, err := setupTLS()
+	if err != nil {
+		return fmt.Errorf("TLS 初始化失敗: %v", err)
+	}
+
+	if err := setupTokenLifetimes(); err != nil {
+		return fmt.Errorf("token 效期設定失敗: %v", err)
+	}
+	if err := setupJWTBearerIssuers(db); err != nil {
+		return fmt.Errorf("JWT bearer 簽發者設定失敗: %v", err)
+	}
+	if err := setupMailer(); err != nil {
+		return fmt.Errorf("寄信設定失敗: %v", err)
+	}
+	if err := setupMFA(); err != nil {
+		return fmt.Errorf("MFA 設定失敗: %v", err)
+	}
+	if err := setupWebAuthn(); err != nil {
+		return fmt.Errorf("WebAuthn 設定失敗: %v", err)
+	}
+
 	e := echo.New()
 	e.Validator = &CustomValidator{validator: validator.New()}
 	e.Debug = true
@@ -115,6 +170,9 @@ func run() error {
 	router.Setup(e, db, redis)
 
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
+	if tlsConfig != nil {
+		return startTLSServer(e, &http.Server{Addr: ":8080", TLSConfig: tlsConfig})
+	}
 	return startServer(e, ":8080")
 }
 
@@ -125,6 +183,195 @@ func main() {
 	}
 }
 
+// setupSigningKeys 在設定 JWT_SIGNING_KEY_ENCRYPTION_KEY 時改以資料庫中的非對稱金鑰簽發 token，
+// 否則沿用 JWT_SECRET (HS256)
+func setupSigningKeys(db database.DB) error {
+	encoded := os.Getenv("JWT_SIGNING_KEY_ENCRYPTION_KEY")
+	if encoded == "" {
+		service.UseKeyStore(nil)
+		return nil
+	}
+	encryptionKey, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("無效的 JWT_SIGNING_KEY_ENCRYPTION_KEY: %v", err)
+	}
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" {
+		alg = service.SigningAlgRS256
+	}
+	ks, err := service.NewKeyStore(db, encryptionKey, alg)
+	if err != nil {
+		return err
+	}
+	if err := ensureKeyStore(ks, context.Background()); err != nil {
+		return err
+	}
+	service.UseKeyStore(ks)
+	return nil
+}
+
+// setupTLS 在設定 TLS_CERT_FILE 與 TLS_KEY_FILE 時由服務自行終止 TLS，並在握手時要求 client 出示憑證供
+// RFC 8705 mTLS 認證；TLS_CLIENT_CA_FILE 為 tls_client_auth 信任的 CA。未設定憑證時維持 HTTP
+func setupTLS() (*tls.Config, error) {
+	certFile := os.Getenv("TLS_CERT_FILE")
+	keyFile := os.Getenv("TLS_KEY_FILE")
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if certFile == "" && keyFile == "" {
+		if caFile != "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE 需搭配 TLS_CERT_FILE 與 TLS_KEY_FILE")
+		}
+		service.UseClientCAs(nil)
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("無效的 TLS_CERT_FILE 或 TLS_KEY_FILE: %v", err)
+	}
+	var clientCAs *x509.CertPool
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("無法讀取 TLS_CLIENT_CA_FILE: %v", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("無效的 TLS_CLIENT_CA_FILE: 找不到 PEM 憑證")
+		}
+	}
+	service.UseClientCAs(clientCAs)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// 自簽憑證無法通過 CA 驗證，握手時只要求出示，是否信任由 client 認證與 token 綁定判斷
+		ClientAuth: tls.RequestClientCert,
+	}, nil
+}
+
+// setupTokenLifetimes 讀取伺服器預設的 token 效期（time.ParseDuration 格式）與 access token 的 aud；
+// 未設定者沿用 service.DefaultTokenLifetimes，client 可再各自覆寫
+func setupTokenLifetimes() error {
+	var l service.TokenLifetimes
+	for env, d := range map[string]*time.Duration{
+		"OAUTH_ACCESS_TOKEN_TTL":           &l.AccessTokenTTL,
+		"OAUTH_REFRESH_TOKEN_TTL":          &l.RefreshTokenTTL,
+		"OAUTH_REFRESH_TOKEN_ABSOLUTE_TTL": &l.RefreshTokenAbsoluteTTL,
+		"OAUTH_REFRESH_TOKEN_IDLE_TIMEOUT": &l.RefreshTokenIdleTimeout,
+		"OAUTH_ID_TOKEN_TTL":               &l.IDTokenTTL,
+	} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("無效的 %s: %q", env, v)
+		}
+		*d = parsed
+	}
+	for _, aud := range strings.Split(os.Getenv("OAUTH_ACCESS_TOKEN_AUDIENCE"), ",") {
+		if aud = strings.TrimSpace(aud); aud != "" {
+			l.Audience = append(l.Audience, aud)
+		}
+	}
+	if err := l.Validate(); err != nil {
+		return fmt.Errorf("無效的 token 效期設定: %w", err)
+	}
+	service.UseTokenLifetimes(l)
+	return nil
+}
+
+// setupJWTBearerIssuers 讀取 OAUTH_JWT_BEARER_ISSUERS_FILE 指定的信任簽發者設定；未設定時停用 JWT bearer grant。
+// 對應規則中的 client 須已註冊
+func setupJWTBearerIssuers(db database.DB) error {
+	path := os.Getenv("OAUTH_JWT_BEARER_ISSUERS_FILE")
+	if path == "" {
+		service.UseTrustedIssuers(nil)
+		return nil
+	}
+	issuers, err := service.LoadTrustedIssuers(path)
+	if err != nil {
+		return fmt.Errorf("無效的 OAUTH_JWT_BEARER_ISSUERS_FILE: %v", err)
+	}
+	if err := service.CheckTrustedIssuerClients(context.Background(), db, issuers); err != nil {
+		return fmt.Errorf("無效的 OAUTH_JWT_BEARER_ISSUERS_FILE: %v", err)
+	}
+	service.UseTrustedIssuers(issuers)
+	return nil
+}
+
+// setupMailer 設定 SMTP_ADDR 時經由 SMTP 寄信，否則將郵件寫入 MAIL_OUTBOX_DIR（留空僅保存在記憶體），供本機開發使用
+func setupMailer() error {
+	service.UseEmailVerificationURL(os.Getenv("EMAIL_VERIFICATION_URL"))
+	service.UsePasswordResetURL(os.Getenv("PASSWORD_RESET_URL"))
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		service.UseMailer(mailer.NewOutbox(os.Getenv("MAIL_OUTBOX_DIR")))
+		return nil
+	}
+	m, err := mailer.NewSMTPMailer(addr, os.Getenv("SMTP_FROM"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	if err != nil {
+		return fmt.Errorf("無效的 SMTP 設定: %v", err)
+	}
+	service.UseMailer(m)
+	return nil
+}
+
+// setupMFA 讀取 otpauth URI 的 issuer、加密 TOTP 金鑰的 MFA_ENCRYPTION_KEY（base64 編碼的 32 bytes，必填）
+// 與 MFA_REQUIRED_FOR_ADMINS（管理員須以多重因素登入才能使用管理功能）
+func setupMFA() error {
+	service.UseMFAIssuer(os.Getenv("MFA_ISSUER"))
+
+	encoded := os.Getenv("MFA_ENCRYPTION_KEY")
+	if encoded == "" {
+		return fmt.Errorf("環境變數 MFA_ENCRYPTION_KEY 未設定")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("無效的 MFA_ENCRYPTION_KEY: %v", err)
+	}
+	if err := service.UseMFAEncryptionKey(key); err != nil {
+		return fmt.Errorf("無效的 MFA_ENCRYPTION_KEY: %v", err)
+	}
+
+	required := false
+	if v := os.Getenv("MFA_REQUIRED_FOR_ADMINS"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("無效的 MFA_REQUIRED_FOR_ADMINS: %q", v)
+		}
+		required = parsed
+	}
+	service.UseAdminMFARequired(required)
+	return nil
+}
+
+// setupWebAuthn 讀取 passkey 綁定的網域 WEBAUTHN_RP_ID、顯示名稱 WEBAUTHN_RP_NAME 與以逗號分隔的前端 origin
+// WEBAUTHN_ORIGINS；origin 的主機須為 RP ID 或其子網域，全部留空時使用本機開發的預設值
+func setupWebAuthn() error {
+	rp := service.WebAuthnRelyingParty{
+		ID:   os.Getenv("WEBAUTHN_RP_ID"),
+		Name: os.Getenv("WEBAUTHN_RP_NAME"),
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			rp.Origins = append(rp.Origins, origin)
+		}
+	}
+	service.UseWebAuthnRelyingParty(rp)
+
+	rp = service.WebAuthnRP()
+	for _, origin := range rp.Origins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("無效的 WEBAUTHN_ORIGINS: %q", origin)
+		}
+		if host := u.Hostname(); host != rp.ID && !strings.HasSuffix(host, "."+rp.ID) {
+			return fmt.Errorf("無效的 WEBAUTHN_ORIGINS: %q 不屬於 WEBAUTHN_RP_ID %q", origin, rp.ID)
+		}
+	}
+	return nil
+}
+
 func defaultSpawnWorkers(n int) error {
 	for i := 0; i < n; i++ {
 		cmd := exec.Command(os.Args[0])
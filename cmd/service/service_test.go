@@ -2,24 +2,55 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
 
 	"life-is-hard/internal/cache"
 	"life-is-hard/internal/database"
+	"life-is-hard/internal/service"
 )
 
+// testMFAKey 為啟動服務所需的 MFA_ENCRYPTION_KEY
+var testMFAKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
 func restoreGlobals() {
 	newPgxPool = database.NewPgxPool
 	newRedisClient = cache.NewRedisClient
 	runMigrationsFn = database.RunMigrations
 	startServer = func(e *echo.Echo, addr string) error { return e.Start(addr) }
+	startTLSServer = func(e *echo.Echo, s *http.Server) error { return e.StartServer(s) }
 	spawnWorkers = defaultSpawnWorkers
 	exitFunc = func(code int) {}
+	ensureKeyStore = (*service.KeyStore).Ensure
+	service.UseKeyStore(nil)
+	service.UseClientCAs(nil)
+	service.UseTokenLifetimes(service.DefaultTokenLifetimes)
+	service.UseTrustedIssuers(nil)
+	service.UseMailer(nil)
+	service.UseEmailVerificationURL("")
+	service.UsePasswordResetURL("")
+	service.UseMFAIssuer("")
+	service.UseAdminMFARequired(false)
+	service.UseWebAuthnRelyingParty(service.WebAuthnRelyingParty{})
 }
 
 func TestCustomValidator(t *testing.T) {
@@ -52,6 +83,8 @@ func TestRunSuccess(t *testing.T) {
 	t.Setenv("REDIS_ADDR", "127")
 	t.Setenv("REDIS_DB", "1")
 	t.Setenv("REDIS_PASSWORD", "pw")
+	t.Setenv("OAUTH_ISSUER", "http://localhost:8080")
+	t.Setenv("MFA_ENCRYPTION_KEY", testMFAKey)
 
 	require.NoError(t, run())
 	require.True(t, called["pgx"])
@@ -70,6 +103,7 @@ func TestRunSpawnWorkers(t *testing.T) {
 	t.Setenv("REDIS_ADDR", "127")
 	t.Setenv("REDIS_DB", "1")
 	t.Setenv("REDIS_PASSWORD", "pw")
+	t.Setenv("OAUTH_ISSUER", "http://localhost:8080")
 	t.Setenv("WORKER_PROCESSES", "3")
 	require.NoError(t, run())
 	require.Equal(t, 3, called)
@@ -98,6 +132,10 @@ func TestRunErrors(t *testing.T) {
 	require.Error(t, run())
 
 	t.Setenv("REDIS_PASSWORD", "pw")
+	t.Setenv("OAUTH_ISSUER", "")
+	require.ErrorContains(t, run(), "OAUTH_ISSUER")
+
+	t.Setenv("OAUTH_ISSUER", "http://localhost:8080")
 	newPgxPool = func(context.Context, string) (database.DB, error) { return nil, errors.New("db") }
 	require.Error(t, run())
 
@@ -110,10 +148,252 @@ func TestRunErrors(t *testing.T) {
 	require.Error(t, run())
 
 	runMigrationsFn = func(string) error { return nil }
+	t.Setenv("JWT_SIGNING_KEY_ENCRYPTION_KEY", "!")
+	require.Error(t, run())
+
+	t.Setenv("JWT_SIGNING_KEY_ENCRYPTION_KEY", "")
+	t.Setenv("TLS_CERT_FILE", "missing.pem")
+	require.Error(t, run())
+
+	t.Setenv("TLS_CERT_FILE", "")
+	t.Setenv("OAUTH_ACCESS_TOKEN_TTL", "bad")
+	require.Error(t, run())
+
+	t.Setenv("OAUTH_ACCESS_TOKEN_TTL", "")
+	t.Setenv("OAUTH_JWT_BEARER_ISSUERS_FILE", "missing.json")
+	require.Error(t, run())
+
+	t.Setenv("OAUTH_JWT_BEARER_ISSUERS_FILE", "")
+	t.Setenv("SMTP_ADDR", "no-port")
+	require.Error(t, run())
+
+	t.Setenv("SMTP_ADDR", "")
 	startServer = func(*echo.Echo, string) error { return errors.New("start") }
 	require.Error(t, run())
 }
 
+// writeTestCertificate 將自簽憑證與私鑰寫入暫存目錄，回傳兩者的路徑
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestSetupTLS(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	certFile, keyFile := writeTestCertificate(t)
+
+	cfg, err := setupTLS()
+	require.NoError(t, err)
+	require.Nil(t, cfg)
+
+	t.Setenv("TLS_CLIENT_CA_FILE", certFile)
+	_, err = setupTLS()
+	require.Error(t, err)
+
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", certFile)
+	_, err = setupTLS()
+	require.Error(t, err)
+
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	t.Setenv("TLS_CLIENT_CA_FILE", filepath.Join(t.TempDir(), "missing.pem"))
+	_, err = setupTLS()
+	require.Error(t, err)
+
+	t.Setenv("TLS_CLIENT_CA_FILE", keyFile)
+	_, err = setupTLS()
+	require.Error(t, err)
+
+	t.Setenv("TLS_CLIENT_CA_FILE", certFile)
+	cfg, err = setupTLS()
+	require.NoError(t, err)
+	require.Len(t, cfg.Certificates, 1)
+	require.Equal(t, tls.RequestClientCert, cfg.ClientAuth)
+
+	t.Setenv("TLS_CLIENT_CA_FILE", "")
+	cfg, err = setupTLS()
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+}
+
+func TestRunTLS(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	certFile, keyFile := writeTestCertificate(t)
+	newPgxPool = func(context.Context, string) (database.DB, error) { return &database.FakeDB{}, nil }
+	newRedisClient = func(string, string, int) (cache.Cache, error) { return &cache.FakeCache{}, nil }
+	runMigrationsFn = func(string) error { return nil }
+	startServer = func(*echo.Echo, string) error { return errors.New("plain http") }
+	var server *http.Server
+	startTLSServer = func(_ *echo.Echo, s *http.Server) error { server = s; return nil }
+	t.Setenv("DATABASE_URL", "d")
+	t.Setenv("REDIS_ADDR", "a")
+	t.Setenv("REDIS_DB", "0")
+	t.Setenv("REDIS_PASSWORD", "p")
+	t.Setenv("OAUTH_ISSUER", "http://localhost:8080")
+	t.Setenv("MFA_ENCRYPTION_KEY", testMFAKey)
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+
+	require.NoError(t, run())
+	require.Equal(t, ":8080", server.Addr)
+	require.NotNil(t, server.TLSConfig)
+}
+
+func TestSetupTokenLifetimes(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	require.NoError(t, setupTokenLifetimes())
+	require.Equal(t, service.DefaultTokenLifetimes, service.ServerTokenLifetimes())
+
+	t.Setenv("OAUTH_ACCESS_TOKEN_TTL", "15m")
+	t.Setenv("OAUTH_REFRESH_TOKEN_ABSOLUTE_TTL", "2160h")
+	t.Setenv("OAUTH_REFRESH_TOKEN_IDLE_TIMEOUT", "168h")
+	t.Setenv("OAUTH_ACCESS_TOKEN_AUDIENCE", "life-is-hard, api ,")
+	require.NoError(t, setupTokenLifetimes())
+	l := service.ServerTokenLifetimes()
+	require.Equal(t, 15*time.Minute, l.AccessTokenTTL)
+	require.Equal(t, service.DefaultTokenLifetimes.RefreshTokenTTL, l.RefreshTokenTTL)
+	require.Equal(t, 2160*time.Hour, l.RefreshTokenAbsoluteTTL)
+	require.Equal(t, 168*time.Hour, l.RefreshTokenIdleTimeout)
+	require.Equal(t, []string{"life-is-hard", "api"}, l.Audience)
+
+	for _, v := range []string{"30", "-1h", "25h"} {
+		t.Setenv("OAUTH_ID_TOKEN_TTL", v)
+		require.Error(t, setupTokenLifetimes(), v)
+	}
+}
+
+func TestSetupJWTBearerIssuers(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	var rowErr error
+	db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row { return clientRow{err: rowErr} }}
+	require.NoError(t, setupJWTBearerIssuers(db))
+
+	certFile, _ := writeTestCertificate(t)
+	t.Setenv("OAUTH_JWT_BEARER_ISSUERS_FILE", certFile)
+	require.ErrorContains(t, setupJWTBearerIssuers(db), "OAUTH_JWT_BEARER_ISSUERS_FILE")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	keyFile, path := filepath.Join(dir, "ci.pem"), filepath.Join(dir, "issuers.json")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	cfg := fmt.Sprintf(`{"issuers":[{"issuer":"https://ci.example.com","public_key_file":%q,"subjects":[{"subject":"repo:*","client_id":"ci"}]}]}`, keyFile)
+	require.NoError(t, os.WriteFile(path, []byte(cfg), 0o600))
+	t.Setenv("OAUTH_JWT_BEARER_ISSUERS_FILE", path)
+	require.NoError(t, setupJWTBearerIssuers(db))
+
+	// 對應規則中的 client 尚未註冊時拒絕啟動
+	service.UseTrustedIssuers(nil)
+	rowErr = pgx.ErrNoRows
+	require.ErrorContains(t, setupJWTBearerIssuers(db), `client_id "ci" is not registered`)
+	require.False(t, service.ReservedByTrustedIssuer("ci"))
+}
+
+// clientRow 模擬查詢 client 的結果；err 為 nil 時視為 client 存在
+type clientRow struct{ err error }
+
+func (r clientRow) Scan(...any) error { return r.err }
+
+func TestSetupMailer(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	require.NoError(t, setupMailer())
+
+	t.Setenv("SMTP_ADDR", "smtp.example.com:587")
+	require.ErrorContains(t, setupMailer(), "SMTP")
+	t.Setenv("SMTP_FROM", "noreply@example.com")
+	require.NoError(t, setupMailer())
+}
+
+func TestSetupMFA(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	t.Setenv("MFA_ENCRYPTION_KEY", "")
+	require.ErrorContains(t, setupMFA(), "MFA_ENCRYPTION_KEY 未設定")
+
+	t.Setenv("MFA_ENCRYPTION_KEY", "not base64")
+	require.ErrorContains(t, setupMFA(), "MFA_ENCRYPTION_KEY")
+	t.Setenv("MFA_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString([]byte("short")))
+	require.ErrorContains(t, setupMFA(), "MFA_ENCRYPTION_KEY")
+	t.Setenv("MFA_ENCRYPTION_KEY", testMFAKey)
+	require.NoError(t, setupMFA())
+
+	t.Setenv("MFA_REQUIRED_FOR_ADMINS", "maybe")
+	require.ErrorContains(t, setupMFA(), "MFA_REQUIRED_FOR_ADMINS")
+	t.Setenv("MFA_REQUIRED_FOR_ADMINS", "true")
+	require.NoError(t, setupMFA())
+	require.False(t, service.AdminMFASatisfied(&service.CustomClaims{IsAdmin: true}))
+	require.True(t, service.AdminMFASatisfied(&service.CustomClaims{IsAdmin: true, Authentication: service.MFAAuthentication()}))
+}
+
+func TestSetupWebAuthn(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	require.NoError(t, setupWebAuthn())
+	require.Equal(t, service.DefaultWebAuthnRelyingParty, service.WebAuthnRP())
+
+	t.Setenv("WEBAUTHN_RP_ID", "example.com")
+	t.Setenv("WEBAUTHN_RP_NAME", "Example")
+	require.NoError(t, setupWebAuthn())
+	require.Equal(t, []string{"https://example.com"}, service.WebAuthnRP().Origins)
+
+	t.Setenv("WEBAUTHN_ORIGINS", "https://example.com, https://login.example.com:8443")
+	require.NoError(t, setupWebAuthn())
+	require.Equal(t, service.WebAuthnRelyingParty{
+		ID:      "example.com",
+		Name:    "Example",
+		Origins: []string{"https://example.com", "https://login.example.com:8443"},
+	}, service.WebAuthnRP())
+
+	for _, origins := range []string{"example.com", "https://example.com/login", "https://evil.com", "https://notexample.com"} {
+		t.Setenv("WEBAUTHN_ORIGINS", origins)
+		require.ErrorContains(t, setupWebAuthn(), "WEBAUTHN_ORIGINS", origins)
+	}
+}
+
+func TestSetupSigningKeys(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	db := &database.FakeDB{}
+	validKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+	t.Setenv("JWT_SIGNING_KEY_ENCRYPTION_KEY", "")
+	require.NoError(t, setupSigningKeys(db))
+
+	t.Setenv("JWT_SIGNING_KEY_ENCRYPTION_KEY", "not base64")
+	require.Error(t, setupSigningKeys(db))
+
+	t.Setenv("JWT_SIGNING_KEY_ENCRYPTION_KEY", validKey)
+	t.Setenv("JWT_SIGNING_ALG", "HS256")
+	require.Error(t, setupSigningKeys(db))
+
+	t.Setenv("JWT_SIGNING_ALG", "")
+	ensureKeyStore = func(*service.KeyStore, context.Context) error { return errors.New("ensure") }
+	require.Error(t, setupSigningKeys(db))
+
+	var ensured bool
+	ensureKeyStore = func(*service.KeyStore, context.Context) error { ensured = true; return nil }
+	require.NoError(t, setupSigningKeys(db))
+	require.True(t, ensured)
+}
+
 func TestMainFunction(t *testing.T) {
 	t.Cleanup(restoreGlobals)
 	startServer = func(*echo.Echo, string) error { return nil }
@@ -124,6 +404,8 @@ func TestMainFunction(t *testing.T) {
 	t.Setenv("REDIS_ADDR", "a")
 	t.Setenv("REDIS_DB", "0")
 	t.Setenv("REDIS_PASSWORD", "p")
+	t.Setenv("OAUTH_ISSUER", "http://localhost:8080")
+	t.Setenv("MFA_ENCRYPTION_KEY", testMFAKey)
 	main()
 }
 
@@ -136,6 +418,7 @@ func TestMainExit(t *testing.T) {
 	t.Setenv("REDIS_ADDR", "a")
 	t.Setenv("REDIS_DB", "0")
 	t.Setenv("REDIS_PASSWORD", "p")
+	t.Setenv("OAUTH_ISSUER", "http://localhost:8080")
 	main()
 	require.Equal(t, 1, exitCode)
 }
@@ -15,9 +15,86 @@ REDIS_ADDR ?= $(REDIS_HOST):$(REDIS_PORT)
 REDIS_DB ?= 0
 
 JWT_SECRET ?= jwt-secret-dev
+# 設定 base64 編碼的 32 bytes 金鑰後改用資料庫中的非對稱簽章金鑰，留空則使用 JWT_SECRET (HS256)；
+# 留空時不提供 openid scope，因為 client 無法驗證以 JWT_SECRET 簽署的 id_token
+JWT_SIGNING_KEY_ENCRYPTION_KEY ?=
+JWT_SIGNING_ALG ?= RS256
+
+# 設定憑證與私鑰後服務自行終止 TLS 並要求 client 出示憑證（RFC 8705）；TLS_CLIENT_CA_FILE 為 tls_client_auth 信任的 CA
+TLS_CERT_FILE ?=
+TLS_KEY_FILE ?=
+TLS_CLIENT_CA_FILE ?=
+
+# OpenID issuer（服務對外的網址，不含 /api），用於 token 的 iss、discovery 文件與 client assertion 的 aud；必填
+OAUTH_ISSUER ?= http://localhost:8080
+
+# 設為 true 時 /api/oauth/register 允許未帶 initial access token 的匿名註冊（RFC 7591）
+OAUTH_OPEN_REGISTRATION ?= false
+
+# 伺服器預設的 token 效期（如 15m、720h），留空使用內建預設；client 可再各自覆寫
+OAUTH_ACCESS_TOKEN_TTL ?=
+OAUTH_REFRESH_TOKEN_TTL ?=
+OAUTH_REFRESH_TOKEN_ABSOLUTE_TTL ?=
+OAUTH_REFRESH_TOKEN_IDLE_TIMEOUT ?=
+OAUTH_ID_TOKEN_TTL ?=
+# access token 的 aud（以逗號分隔）；本服務只接受未限定 aud 或 aud 含其中之一的 token
+OAUTH_ACCESS_TOKEN_AUDIENCE ?=
+
+# JWT bearer grant（RFC 7523）信任的簽發者設定檔（JSON），留空停用；subjects 對應的 client_id 須已註冊
+OAUTH_JWT_BEARER_ISSUERS_FILE ?=
+
+# 寄信設定：留空 SMTP_ADDR 時郵件寫入 MAIL_OUTBOX_DIR（留空則只保存在記憶體），供本機開發使用
+SMTP_ADDR ?=
+SMTP_FROM ?=
+SMTP_USERNAME ?=
+SMTP_PASSWORD ?=
+MAIL_OUTBOX_DIR ?=
+# 驗證信中的連結（如前端的驗證頁），token 以查詢參數附加；留空時信中只附 token
+EMAIL_VERIFICATION_URL ?=
+# 重設密碼信中的連結（如前端的重設密碼頁），token 以查詢參數附加；留空時信中只附 token
+PASSWORD_RESET_URL ?=
+
+# MFA 設定：MFA_ISSUER 為驗證器 App 顯示的服務名稱（留空為 life-is-hard）；MFA_ENCRYPTION_KEY 為加密 TOTP 金鑰的
+# base64 編碼 32 bytes 金鑰，必填（預設值僅供本機開發）；MFA_REQUIRED_FOR_ADMINS=true 時管理員須以多重因素登入才能使用管理功能
+MFA_ISSUER ?=
+MFA_ENCRYPTION_KEY ?= bWZhLWVuY3J5cHRpb24ta2V5LWRldi0wMDAwMDAwMDA=
+MFA_REQUIRED_FOR_ADMINS ?=
+
+# WebAuthn 設定：WEBAUTHN_RP_ID 為 passkey 綁定的網域（留空為 localhost）；WEBAUTHN_RP_NAME 為瀏覽器顯示的服務名稱；
+# WEBAUTHN_ORIGINS 為以逗號分隔、允許發起 passkey 登入的前端 origin，留空時為 https://<WEBAUTHN_RP_ID>
+WEBAUTHN_RP_ID ?=
+WEBAUTHN_RP_NAME ?=
+WEBAUTHN_ORIGINS ?=
 
 export DATABASE_URL
 export REDIS_ADDR
 export REDIS_DB
 export REDIS_PASSWORD
 export JWT_SECRET
+export JWT_SIGNING_KEY_ENCRYPTION_KEY
+export JWT_SIGNING_ALG
+export TLS_CERT_FILE
+export TLS_KEY_FILE
+export TLS_CLIENT_CA_FILE
+export OAUTH_ISSUER
+export OAUTH_OPEN_REGISTRATION
+export OAUTH_ACCESS_TOKEN_TTL
+export OAUTH_REFRESH_TOKEN_TTL
+export OAUTH_REFRESH_TOKEN_ABSOLUTE_TTL
+export OAUTH_REFRESH_TOKEN_IDLE_TIMEOUT
+export OAUTH_ID_TOKEN_TTL
+export OAUTH_ACCESS_TOKEN_AUDIENCE
+export OAUTH_JWT_BEARER_ISSUERS_FILE
+export SMTP_ADDR
+export SMTP_FROM
+export SMTP_USERNAME
+export SMTP_PASSWORD
+export MAIL_OUTBOX_DIR
+export EMAIL_VERIFICATION_URL
+export PASSWORD_RESET_URL
+export MFA_ISSUER
+export MFA_ENCRYPTION_KEY
+export MFA_REQUIRED_FOR_ADMINS
+export WEBAUTHN_RP_ID
+export WEBAUTHN_RP_NAME
+export WEBAUTHN_ORIGINS
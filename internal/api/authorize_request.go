@@ -0,0 +1,14 @@
+package api
+
+// swagger:model api.AuthorizeRequest
+type AuthorizeRequest struct {
+	ResponseType        string `query:"response_type" validate:"required_without=RequestURI" example:"code"`
+	ClientID            string `query:"client_id" validate:"required" example:"my-client"`
+	RedirectURI         string `query:"redirect_uri" example:"https://app.example.com/callback"`
+	Scope               string `query:"scope" example:"openid users:read"`
+	State               string `query:"state" example:"xyz"`
+	CodeChallenge       string `query:"code_challenge" example:"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"`
+	CodeChallengeMethod string `query:"code_challenge_method" example:"S256"`
+	Nonce               string `query:"nonce" example:"n-0S6_WzA2Mj"`
+	RequestURI          string `query:"request_uri" example:"urn:ietf:params:oauth:request_uri:6esc_11ACC5bwc014ltc14eY22c"`
+}
@@ -0,0 +1,7 @@
+package api
+
+// swagger:model api.ConsentRequest
+type ConsentRequest struct {
+	ConsentChallenge string `json:"consent_challenge" form:"consent_challenge" validate:"required" example:"q8Jp3m1N0dW2vXk5Zr7tYg"`
+	Action           string `json:"action" form:"action" validate:"required,oneof=approve deny" example:"approve"`
+}
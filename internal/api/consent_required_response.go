@@ -0,0 +1,11 @@
+package api
+
+// swagger:model api.ConsentRequiredResponse
+type ConsentRequiredResponse struct {
+	ConsentChallenge string `json:"consent_challenge" example:"q8Jp3m1N0dW2vXk5Zr7tYg"`
+	ClientID         string `json:"client_id" example:"my-client"`
+	ClientName       string `json:"client_name" example:"My App"`
+	LogoURI          string `json:"logo_uri,omitempty" example:"https://app.example.com/logo.png"`
+	Scope            string `json:"scope" example:"openid users:read"`
+	ExpiresIn        int    `json:"expires_in" example:"600"`
+}
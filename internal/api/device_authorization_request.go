@@ -0,0 +1,6 @@
+package api
+
+// swagger:model api.DeviceAuthorizationRequest
+type DeviceAuthorizationRequest struct {
+	Scope string `form:"scope" example:"openid users:read"`
+}
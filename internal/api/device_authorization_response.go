@@ -0,0 +1,11 @@
+package api
+
+// swagger:model api.DeviceAuthorizationResponse
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code" example:"GmRhmhcxhwAzkoEqiMEg_DnyEysNkuNhszIySk9eS"`
+	UserCode                string `json:"user_code" example:"WDJB-MJHT"`
+	VerificationURI         string `json:"verification_uri" example:"https://auth.example.com/api/oauth/device"`
+	VerificationURIComplete string `json:"verification_uri_complete" example:"https://auth.example.com/api/oauth/device?user_code=WDJB-MJHT"`
+	ExpiresIn               int    `json:"expires_in" example:"600"`
+	Interval                int    `json:"interval" example:"5"`
+}
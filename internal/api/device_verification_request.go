@@ -0,0 +1,7 @@
+package api
+
+// swagger:model api.DeviceVerificationRequest
+type DeviceVerificationRequest struct {
+	UserCode string `json:"user_code" form:"user_code" query:"user_code" validate:"required" example:"WDJB-MJHT"`
+	Action   string `json:"action" form:"action" validate:"omitempty,oneof=approve deny" example:"approve"`
+}
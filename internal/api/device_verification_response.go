@@ -0,0 +1,10 @@
+package api
+
+// swagger:model api.DeviceVerificationResponse
+type DeviceVerificationResponse struct {
+	UserCode   string `json:"user_code" example:"WDJB-MJHT"`
+	ClientID   string `json:"client_id" example:"my-cli"`
+	ClientName string `json:"client_name" example:"My CLI"`
+	Scope      string `json:"scope" example:"openid users:read"`
+	Status     string `json:"status" example:"pending"`
+}
@@ -0,0 +1,6 @@
+package api
+
+// swagger:model api.ForgotPasswordRequest
+type ForgotPasswordRequest struct {
+	Email string `form:"email" validate:"required,email" example:"alice@example.com"`
+}
@@ -0,0 +1,29 @@
+package api
+
+// swagger:model api.IntrospectResponse
+type IntrospectResponse struct {
+	Active    bool               `json:"active" example:"true"`
+	TokenType string             `json:"token_type,omitempty" example:"access_token"`
+	Sub       string             `json:"sub,omitempty" example:"42"`
+	ClientID  string             `json:"client_id,omitempty" example:"my-client"`
+	Scope     string             `json:"scope,omitempty" example:"users:read"`
+	Exp       int64              `json:"exp,omitempty" example:"1700086400"`
+	Iat       int64              `json:"iat,omitempty" example:"1700000000"`
+	IsAdmin   bool               `json:"is_admin,omitempty" example:"false"`
+	Cnf       *TokenConfirmation `json:"cnf,omitempty"`
+	Aud       []string           `json:"aud,omitempty" example:"billing-service"`
+	Act       *TokenActor        `json:"act,omitempty"`
+}
+
+// swagger:model api.TokenConfirmation
+type TokenConfirmation struct {
+	X5tS256 string `json:"x5t#S256,omitempty" example:"bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2"`
+	JKT     string `json:"jkt,omitempty" example:"0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"`
+}
+
+// swagger:model api.TokenActor
+type TokenActor struct {
+	Sub      string      `json:"sub" example:"orders-service"`
+	ClientID string      `json:"client_id,omitempty" example:"orders-service"`
+	Act      *TokenActor `json:"act,omitempty"`
+}
@@ -0,0 +1,19 @@
+package api
+
+// swagger:model api.JSONWebKey
+type JSONWebKey struct {
+	Kty string `json:"kty" example:"EC"`
+	Kid string `json:"kid" example:"3q2-7wXyZ..."`
+	Use string `json:"use" example:"sig"`
+	Alg string `json:"alg" example:"ES256"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty" example:"AQAB"`
+	Crv string `json:"crv,omitempty" example:"P-256"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// swagger:model api.JWKSResponse
+type JWKSResponse struct {
+	Keys []JSONWebKey `json:"keys"`
+}
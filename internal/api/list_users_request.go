@@ -0,0 +1,15 @@
+package api
+
+import "time"
+
+// swagger:model api.ListUsersRequest
+type ListUsersRequest struct {
+	IsAdmin       string    `query:"is_admin" validate:"omitempty,oneof=true false" example:"true"`
+	EmailDomain   string    `query:"email_domain" example:"example.com"`
+	NamePrefix    string    `query:"name_prefix" example:"al"`
+	CreatedAfter  time.Time `query:"created_after" example:"2025-01-01T00:00:00Z"`
+	CreatedBefore time.Time `query:"created_before" example:"2026-01-01T00:00:00Z"`
+	Sort          string    `query:"sort" validate:"omitempty,oneof=id -id name -name email -email created_at -created_at" example:"-created_at"`
+	Limit         int       `query:"limit" validate:"omitempty,min=1,max=100" example:"20"`
+	Cursor        string    `query:"cursor" example:"eyJzb3J0IjoiaWQiLCJpZCI6MjB9"`
+}
@@ -3,4 +3,5 @@ package api
 // swagger:model api.LoginResponse
 type LoginResponse struct {
 	AccessToken string `json:"access_token" example:"eyJhbGciOi..."`
+	ExpiresIn   int    `json:"expires_in" example:"86400"`
 }
@@ -0,0 +1,7 @@
+package api
+
+// swagger:model api.MFAChallengeResponse
+type MFAChallengeResponse struct {
+	MFAToken  string `json:"mfa_token" example:"Yb2x6Q1nG0p8c3l5vQx9u7k2m4s6w8z0a1d3f5h7j9E"`
+	ExpiresIn int    `json:"expires_in" example:"300"`
+}
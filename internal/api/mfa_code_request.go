@@ -0,0 +1,6 @@
+package api
+
+// swagger:model api.MFACodeRequest
+type MFACodeRequest struct {
+	Code string `form:"code" validate:"required" example:"123456"`
+}
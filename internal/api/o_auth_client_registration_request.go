@@ -0,0 +1,19 @@
+package api
+
+import "encoding/json"
+
+// swagger:model api.OAuthClientRegistrationRequest
+type OAuthClientRegistrationRequest struct {
+	ClientID                              string          `json:"client_id,omitempty" example:"nB6qUAXl1pT8s0gS2pS1kw"`
+	RedirectURIs                          []string        `json:"redirect_uris" example:"https://app.example.com/callback"`
+	TokenEndpointAuthMethod               string          `json:"token_endpoint_auth_method" validate:"omitempty,oneof=client_secret_basic client_secret_post client_secret_jwt private_key_jwt none tls_client_auth self_signed_tls_client_auth" example:"client_secret_basic"`
+	GrantTypes                            []string        `json:"grant_types" example:"authorization_code,refresh_token"`
+	ClientName                            string          `json:"client_name" example:"Partner App"`
+	LogoURI                               string          `json:"logo_uri" example:"https://app.example.com/logo.png"`
+	Scope                                 string          `json:"scope" example:"openid users:read"`
+	JWKS                                  json.RawMessage `json:"jwks,omitempty" swaggertype:"object"`
+	TLSClientAuthSubjectDN                string          `json:"tls_client_auth_subject_dn,omitempty" example:"CN=my-service,O=Example"`
+	TLSClientCertificateThumbprint        string          `json:"tls_client_certificate_thumbprint,omitempty" example:"A4DtL2JmUMhAsvJj5tKyn64SqzmuXbMrJa0n761y5v0"`
+	TLSClientCertificateBoundAccessTokens bool            `json:"tls_client_certificate_bound_access_tokens,omitempty" example:"true"`
+	RequirePushedAuthorizationRequests    bool            `json:"require_pushed_authorization_requests,omitempty" example:"true"`
+}
@@ -0,0 +1,24 @@
+package api
+
+import "encoding/json"
+
+// swagger:model api.OAuthClientRegistrationResponse
+type OAuthClientRegistrationResponse struct {
+	ClientID                              string          `json:"client_id" example:"nB6qUAXl1pT8s0gS2pS1kw"`
+	ClientSecret                          string          `json:"client_secret,omitempty" example:"Zt3Jr8bP3nq0sJ2cZb3v8i6mRrKxYw1c4yX2oYl5NhA"`
+	ClientIDIssuedAt                      int64           `json:"client_id_issued_at" example:"1700000000"`
+	ClientSecretExpiresAt                 int64           `json:"client_secret_expires_at" example:"0"`
+	RegistrationAccessToken               string          `json:"registration_access_token,omitempty" example:"h3Cg5bP1nq0sJ2cZb3v8i6mRrKxYw1c4yX2oYl5NhA"`
+	RegistrationClientURI                 string          `json:"registration_client_uri" example:"https://auth.example.com/api/oauth/register/nB6qUAXl1pT8s0gS2pS1kw"`
+	RedirectURIs                          []string        `json:"redirect_uris" example:"https://app.example.com/callback"`
+	TokenEndpointAuthMethod               string          `json:"token_endpoint_auth_method" example:"client_secret_basic"`
+	GrantTypes                            []string        `json:"grant_types" example:"authorization_code,refresh_token"`
+	ClientName                            string          `json:"client_name,omitempty" example:"Partner App"`
+	LogoURI                               string          `json:"logo_uri,omitempty" example:"https://app.example.com/logo.png"`
+	Scope                                 string          `json:"scope,omitempty" example:"openid users:read"`
+	JWKS                                  json.RawMessage `json:"jwks,omitempty" swaggertype:"object"`
+	TLSClientAuthSubjectDN                string          `json:"tls_client_auth_subject_dn,omitempty" example:"CN=my-service,O=Example"`
+	TLSClientCertificateThumbprint        string          `json:"tls_client_certificate_thumbprint,omitempty" example:"A4DtL2JmUMhAsvJj5tKyn64SqzmuXbMrJa0n761y5v0"`
+	TLSClientCertificateBoundAccessTokens bool            `json:"tls_client_certificate_bound_access_tokens" example:"false"`
+	RequirePushedAuthorizationRequests    bool            `json:"require_pushed_authorization_requests" example:"false"`
+}
@@ -1,13 +1,36 @@
 package api
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // swagger:model api.OAuthClientResponse
 type OAuthClientResponse struct {
-	ClientID     string    `json:"client_id" example:"my-client"`
-	ClientSecret string    `json:"client_secret" example:"secret"`
-	UserID       int       `json:"user_id" example:"42"`
-	GrantTypes   []string  `json:"grant_types" example:"password,client_credentials"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ClientID                              string          `json:"client_id" example:"my-client"`
+	ClientSecret                          string          `json:"client_secret,omitempty" example:"Zt3Jr8bP3nq0sJ2cZb3v8i6mRrKxYw1c4yX2oYl5NhA"`
+	UserID                                int             `json:"user_id" example:"42"`
+	ClientType                            string          `json:"client_type" example:"confidential"`
+	ClientName                            string          `json:"client_name" example:"My App"`
+	LogoURI                               string          `json:"logo_uri" example:"https://app.example.com/logo.png"`
+	GrantTypes                            []string        `json:"grant_types" example:"password,client_credentials"`
+	RedirectURIs                          []string        `json:"redirect_uris" example:"https://app.example.com/callback"`
+	Scopes                                []string        `json:"scopes" example:"users:read,users:write"`
+	TokenEndpointAuthMethod               string          `json:"token_endpoint_auth_method" example:"client_secret_basic"`
+	JWKS                                  json.RawMessage `json:"jwks,omitempty" swaggertype:"object"`
+	TLSClientAuthSubjectDN                string          `json:"tls_client_auth_subject_dn,omitempty" example:"CN=my-service,O=Example"`
+	TLSClientCertificateThumbprint        string          `json:"tls_client_certificate_thumbprint,omitempty" example:"A4DtL2JmUMhAsvJj5tKyn64SqzmuXbMrJa0n761y5v0"`
+	TLSClientCertificateBoundAccessTokens bool            `json:"tls_client_certificate_bound_access_tokens" example:"false"`
+	RequirePushedAuthorizationRequests    bool            `json:"require_pushed_authorization_requests" example:"false"`
+	TokenExchangeAudiences                []string        `json:"token_exchange_audiences,omitempty" example:"billing-service,report-service"`
+	AccessTokenTTL                        int             `json:"access_token_ttl,omitempty" example:"3600"`
+	RefreshTokenTTL                       int             `json:"refresh_token_ttl,omitempty" example:"1209600"`
+	RefreshTokenAbsoluteTTL               int             `json:"refresh_token_absolute_ttl,omitempty" example:"7776000"`
+	RefreshTokenIdleTimeout               int             `json:"refresh_token_idle_timeout,omitempty" example:"604800"`
+	IDTokenTTL                            int             `json:"id_token_ttl,omitempty" example:"3600"`
+	AccessTokenAudiences                  []string        `json:"access_token_audiences,omitempty" example:"life-is-hard"`
+	FirstParty                            bool            `json:"first_party" example:"false"`
+	CreatedAt                             time.Time       `json:"created_at"`
+	UpdatedAt                             time.Time       `json:"updated_at"`
+	PreviousClientSecretExpiresAt         *time.Time      `json:"previous_client_secret_expires_at,omitempty"`
 }
@@ -0,0 +1,12 @@
+package api
+
+import "time"
+
+// swagger:model api.OAuthGrantResponse
+type OAuthGrantResponse struct {
+	ClientID   string    `json:"client_id" example:"my-client"`
+	ClientName string    `json:"client_name,omitempty" example:"My App"`
+	LogoURI    string    `json:"logo_uri,omitempty" example:"https://app.example.com/logo.png"`
+	Scopes     []string  `json:"scopes" example:"openid,users:read"`
+	GrantedAt  time.Time `json:"granted_at"`
+}
@@ -0,0 +1,8 @@
+package api
+
+// swagger:model api.OAuthErrorResponse
+type OAuthErrorResponse struct {
+	Error            string `json:"error" example:"invalid_grant"`
+	ErrorDescription string `json:"error_description,omitempty" example:"invalid authorization code"`
+	ErrorURI         string `json:"error_uri,omitempty" example:"https://datatracker.ietf.org/doc/html/rfc6749#section-5.2"`
+}
@@ -0,0 +1,28 @@
+package api
+
+// swagger:model api.OpenIDConfigurationResponse
+type OpenIDConfigurationResponse struct {
+	Issuer                                     string   `json:"issuer" example:"https://auth.example.com"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint" example:"https://auth.example.com/api/oauth/authorize"`
+	TokenEndpoint                              string   `json:"token_endpoint" example:"https://auth.example.com/api/oauth/token"`
+	UserInfoEndpoint                           string   `json:"userinfo_endpoint" example:"https://auth.example.com/api/oauth/userinfo"`
+	JWKSURI                                    string   `json:"jwks_uri" example:"https://auth.example.com/.well-known/jwks.json"`
+	RevocationEndpoint                         string   `json:"revocation_endpoint" example:"https://auth.example.com/api/oauth/revoke"`
+	IntrospectionEndpoint                      string   `json:"introspection_endpoint" example:"https://auth.example.com/api/oauth/introspect"`
+	DeviceAuthorizationEndpoint                string   `json:"device_authorization_endpoint" example:"https://auth.example.com/api/oauth/device_authorization"`
+	RegistrationEndpoint                       string   `json:"registration_endpoint" example:"https://auth.example.com/api/oauth/register"`
+	PushedAuthorizationRequestEndpoint         string   `json:"pushed_authorization_request_endpoint" example:"https://auth.example.com/api/oauth/par"`
+	ScopesSupported                            []string `json:"scopes_supported" example:"openid,profile,email"`
+	ResponseTypesSupported                     []string `json:"response_types_supported" example:"code"`
+	GrantTypesSupported                        []string `json:"grant_types_supported" example:"authorization_code,refresh_token"`
+	SubjectTypesSupported                      []string `json:"subject_types_supported" example:"public"`
+	IDTokenSigningAlgValuesSupported           []string `json:"id_token_signing_alg_values_supported" example:"RS256"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported" example:"client_secret_basic,client_secret_post,private_key_jwt"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported" example:"RS256,ES256,HS256"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported" example:"S256,plain"`
+	ClaimsSupported                            []string `json:"claims_supported" example:"sub,name,email,email_verified"`
+	ACRValuesSupported                         []string `json:"acr_values_supported" example:"aal1,aal2"`
+	TLSClientCertificateBoundAccessTokens      bool     `json:"tls_client_certificate_bound_access_tokens" example:"true"`
+	DPoPSigningAlgValuesSupported              []string `json:"dpop_signing_alg_values_supported" example:"RS256,ES256"`
+	RequirePushedAuthorizationRequests         bool     `json:"require_pushed_authorization_requests" example:"false"`
+}
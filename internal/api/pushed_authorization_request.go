@@ -0,0 +1,13 @@
+package api
+
+// swagger:model api.PushedAuthorizationRequest
+type PushedAuthorizationRequest struct {
+	ResponseType        string `form:"response_type" example:"code"`
+	RedirectURI         string `form:"redirect_uri" example:"https://app.example.com/callback"`
+	Scope               string `form:"scope" example:"openid users:read"`
+	State               string `form:"state" example:"xyz"`
+	CodeChallenge       string `form:"code_challenge" example:"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"`
+	CodeChallengeMethod string `form:"code_challenge_method" example:"S256"`
+	Nonce               string `form:"nonce" example:"n-0S6_WzA2Mj"`
+	RequestURI          string `form:"request_uri" example:""`
+}
@@ -0,0 +1,7 @@
+package api
+
+// swagger:model api.PushedAuthorizationResponse
+type PushedAuthorizationResponse struct {
+	RequestURI string `json:"request_uri" example:"urn:ietf:params:oauth:request_uri:6esc_11ACC5bwc014ltc14eY22c"`
+	ExpiresIn  int    `json:"expires_in" example:"60"`
+}
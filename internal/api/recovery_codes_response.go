@@ -0,0 +1,6 @@
+package api
+
+// swagger:model api.RecoveryCodesResponse
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes" example:"k3m9-x2q7,p8d4-w6n1"`
+}
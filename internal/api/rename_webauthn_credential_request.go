@@ -0,0 +1,6 @@
+package api
+
+// swagger:model api.RenameWebAuthnCredentialRequest
+type RenameWebAuthnCredentialRequest struct {
+	Name string `form:"name" validate:"required,max=64" example:"YubiKey 5C"`
+}
@@ -0,0 +1,7 @@
+package api
+
+// swagger:model api.ResetPasswordRequest
+type ResetPasswordRequest struct {
+	Token       string `form:"token" validate:"required" example:"Yb2x6Q1nG0p8c3l5vQx9u7k2m4s6w8z0a1d3f5h7j9E"`
+	NewPassword string `form:"new_password" validate:"required" example:"NewSecret456!"`
+}
@@ -0,0 +1,7 @@
+package api
+
+// swagger:model api.RevokeRequest
+type RevokeRequest struct {
+	Token         string `form:"token" validate:"required" example:"..."`
+	TokenTypeHint string `form:"token_type_hint" example:"refresh_token"`
+}
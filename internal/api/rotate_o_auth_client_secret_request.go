@@ -0,0 +1,6 @@
+package api
+
+// swagger:model api.RotateOAuthClientSecretRequest
+type RotateOAuthClientSecretRequest struct {
+	GracePeriod *int `json:"grace_period" validate:"omitempty,min=0,max=2592000" example:"86400"`
+}
@@ -2,11 +2,23 @@ package api
 
 // swagger:model api.TokenRequest
 type TokenRequest struct {
-	GrantType    string `form:"grant_type" validate:"required" example:"password"`
-	Username     string `form:"username" example:"user@example.com"`
-	Password     string `form:"password" example:"password"`
-	RefreshToken string `form:"refresh_token" example:"..."`
-	Scope        string `form:"scope" example:"read write"`
-	ClientID     string `swaggerignore:"true"`
-	ClientSecret string `swaggerignore:"true"`
+	GrantType          string   `form:"grant_type" validate:"required" example:"password"`
+	Username           string   `form:"username" example:"user@example.com"`
+	Password           string   `form:"password" example:"password"`
+	OTP                string   `form:"otp" example:"123456"`
+	RefreshToken       string   `form:"refresh_token" example:"..."`
+	Code               string   `form:"code" example:"..."`
+	RedirectURI        string   `form:"redirect_uri" example:"https://app.example.com/callback"`
+	CodeVerifier       string   `form:"code_verifier" example:"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"`
+	DeviceCode         string   `form:"device_code" example:"GmRhmhcxhwAzkoEqiMEg_DnyEysNkuNhszIySk9eS"`
+	Scope              string   `form:"scope" example:"openid users:read"`
+	SubjectToken       string   `form:"subject_token" example:"..."`
+	SubjectTokenType   string   `form:"subject_token_type" example:"urn:ietf:params:oauth:token-type:access_token"`
+	ActorToken         string   `form:"actor_token" example:"..."`
+	ActorTokenType     string   `form:"actor_token_type" example:"urn:ietf:params:oauth:token-type:access_token"`
+	Audience           []string `form:"audience" example:"billing-service"`
+	RequestedTokenType string   `form:"requested_token_type" example:"urn:ietf:params:oauth:token-type:access_token"`
+	Assertion          string   `form:"assertion" example:"eyJhbGciOiJFUzI1NiIsImtpZCI6ImNpIn0..."`
+	ClientID           string   `swaggerignore:"true"`
+	ClientSecret       string   `swaggerignore:"true"`
 }
@@ -2,8 +2,11 @@ package api
 
 // swagger:model api.TokenResponse
 type TokenResponse struct {
-	AccessToken  string `json:"access_token" example:"..."`
-	TokenType    string `json:"token_type" example:"Bearer"`
-	ExpiresIn    int    `json:"expires_in" example:"86400"`
-	RefreshToken string `json:"refresh_token,omitempty" example:"..."`
+	AccessToken     string `json:"access_token" example:"..."`
+	TokenType       string `json:"token_type" example:"Bearer"`
+	ExpiresIn       int    `json:"expires_in" example:"86400"`
+	RefreshToken    string `json:"refresh_token,omitempty" example:"..."`
+	IDToken         string `json:"id_token,omitempty" example:"..."`
+	Scope           string `json:"scope,omitempty" example:"openid users:read"`
+	IssuedTokenType string `json:"issued_token_type,omitempty" example:"urn:ietf:params:oauth:token-type:access_token"`
 }
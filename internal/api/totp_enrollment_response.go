@@ -0,0 +1,7 @@
+package api
+
+// swagger:model api.TOTPEnrollmentResponse
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret" example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	URI    string `json:"uri" example:"otpauth://totp/life-is-hard:alice?algorithm=SHA1&digits=6&issuer=life-is-hard&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+}
@@ -1,7 +1,27 @@
 package api
 
+import "encoding/json"
+
 // swagger:model api.UpdateOAuthClientRequest
 type UpdateOAuthClientRequest struct {
-	ClientSecret string   `json:"client_secret" validate:"required" example:"new-secret"`
-	GrantTypes   []string `json:"grant_types" validate:"required" example:"password,client_credentials,refresh_token"`
+	ClientType                            string          `json:"client_type" validate:"omitempty,oneof=public confidential" example:"confidential"`
+	ClientName                            string          `json:"client_name" example:"My App"`
+	LogoURI                               string          `json:"logo_uri" example:"https://app.example.com/logo.png"`
+	GrantTypes                            []string        `json:"grant_types" validate:"required" example:"password,client_credentials,refresh_token"`
+	RedirectURIs                          []string        `json:"redirect_uris" example:"https://app.example.com/callback"`
+	Scopes                                []string        `json:"scopes" example:"users:read,users:write"`
+	TokenEndpointAuthMethod               string          `json:"token_endpoint_auth_method" validate:"omitempty,oneof=client_secret_basic client_secret_post client_secret_jwt private_key_jwt none tls_client_auth self_signed_tls_client_auth" example:"client_secret_basic"`
+	JWKS                                  json.RawMessage `json:"jwks,omitempty" swaggertype:"object"`
+	TLSClientAuthSubjectDN                string          `json:"tls_client_auth_subject_dn,omitempty" example:"CN=my-service,O=Example"`
+	TLSClientCertificateThumbprint        string          `json:"tls_client_certificate_thumbprint,omitempty" example:"A4DtL2JmUMhAsvJj5tKyn64SqzmuXbMrJa0n761y5v0"`
+	TLSClientCertificateBoundAccessTokens bool            `json:"tls_client_certificate_bound_access_tokens,omitempty" example:"true"`
+	RequirePushedAuthorizationRequests    bool            `json:"require_pushed_authorization_requests,omitempty" example:"true"`
+	TokenExchangeAudiences                []string        `json:"token_exchange_audiences,omitempty" example:"billing-service,report-service"`
+	AccessTokenTTL                        int             `json:"access_token_ttl,omitempty" validate:"min=0,max=86400" example:"3600"`
+	RefreshTokenTTL                       int             `json:"refresh_token_ttl,omitempty" validate:"min=0,max=31536000" example:"1209600"`
+	RefreshTokenAbsoluteTTL               int             `json:"refresh_token_absolute_ttl,omitempty" validate:"min=0,max=31536000" example:"7776000"`
+	RefreshTokenIdleTimeout               int             `json:"refresh_token_idle_timeout,omitempty" validate:"min=0,max=31536000" example:"604800"`
+	IDTokenTTL                            int             `json:"id_token_ttl,omitempty" validate:"min=0,max=86400" example:"3600"`
+	AccessTokenAudiences                  []string        `json:"access_token_audiences,omitempty" example:"life-is-hard"`
+	FirstParty                            *bool           `json:"first_party,omitempty" example:"false"`
 }
@@ -0,0 +1,8 @@
+package api
+
+// swagger:model api.UserListResponse
+type UserListResponse struct {
+	Users      []UserResponse `json:"users"`
+	Total      int            `json:"total" example:"42"`
+	NextCursor string         `json:"next_cursor,omitempty" example:"eyJzb3J0IjoiaWQiLCJpZCI6MjB9"`
+}
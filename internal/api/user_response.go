@@ -4,9 +4,11 @@ import "time"
 
 // swagger:model api.UserResponse
 type UserResponse struct {
-	ID        int       `json:"id" example:"1"`
-	Name      string    `json:"name" example:"Alice"`
-	Email     string    `json:"email" example:"alice@example.com"`
-	IsAdmin   bool      `json:"is_admin" example:"false"`
-	CreatedAt time.Time `json:"created_at" example:"2025-05-01T15:04:05Z07:00"`
+	ID      int    `json:"id" example:"1"`
+	Name    string `json:"name" example:"Alice"`
+	Email   string `json:"email" example:"alice@example.com"`
+	IsAdmin bool   `json:"is_admin" example:"false"`
+	// EmailVerified 表示使用者已驗證目前的 Email
+	EmailVerified bool      `json:"email_verified" example:"true"`
+	CreatedAt     time.Time `json:"created_at" example:"2025-05-01T15:04:05Z07:00"`
 }
@@ -0,0 +1,11 @@
+package api
+
+// swagger:model api.UserInfoResponse
+type UserInfoResponse struct {
+	Sub string `json:"sub" example:"1"`
+	// Name 僅在具備 profile scope 時提供
+	Name string `json:"name,omitempty" example:"Alice"`
+	// Email 與 EmailVerified 僅在具備 email scope 時提供
+	Email         string `json:"email,omitempty" example:"alice@example.com"`
+	EmailVerified *bool  `json:"email_verified,omitempty" example:"false"`
+}
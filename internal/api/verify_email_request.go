@@ -0,0 +1,6 @@
+package api
+
+// swagger:model api.VerifyEmailRequest
+type VerifyEmailRequest struct {
+	Token string `json:"token" form:"token" validate:"required" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+}
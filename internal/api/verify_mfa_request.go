@@ -0,0 +1,7 @@
+package api
+
+// swagger:model api.VerifyMFARequest
+type VerifyMFARequest struct {
+	MFAToken string `form:"mfa_token" validate:"required" example:"Yb2x6Q1nG0p8c3l5vQx9u7k2m4s6w8z0a1d3f5h7j9E"`
+	Code     string `form:"code" validate:"required" example:"123456"`
+}
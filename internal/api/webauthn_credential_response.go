@@ -0,0 +1,12 @@
+package api
+
+import "time"
+
+// swagger:model api.WebAuthnCredentialResponse
+type WebAuthnCredentialResponse struct {
+	ID         string     `json:"id" example:"3q2-7wXyZ0aB1cD2eF3gHw"`
+	Name       string     `json:"name" example:"MacBook Touch ID"`
+	Transports []string   `json:"transports" example:"internal,hybrid"`
+	CreatedAt  time.Time  `json:"created_at" example:"2025-05-01T15:04:05Z07:00"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" example:"2025-05-02T09:30:00Z07:00"`
+}
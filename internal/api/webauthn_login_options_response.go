@@ -0,0 +1,12 @@
+package api
+
+// WebAuthnLoginOptionsResponse 為 navigator.credentials.get() 的 PublicKeyCredentialRequestOptions；
+// allowCredentials 為空，由瀏覽器列出可發現的 passkey
+// swagger:model api.WebAuthnLoginOptionsResponse
+type WebAuthnLoginOptionsResponse struct {
+	Challenge        string                         `json:"challenge" example:"Yb2x6Q1nG0p8c3l5vQx9u7k2m4s6w8z0a1d3f5h7j9E"`
+	RPID             string                         `json:"rpId" example:"auth.example.com"`
+	Timeout          int                            `json:"timeout" example:"300000"`
+	UserVerification string                         `json:"userVerification" example:"required"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+}
@@ -0,0 +1,18 @@
+package api
+
+// swagger:model api.WebAuthnAssertionResponse
+type WebAuthnAssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON" validate:"required" example:"eyJ0eXBlIjoid2ViYXV0aG4uZ2V0In0"`
+	AuthenticatorData string `json:"authenticatorData" validate:"required" example:"SZYN5YgOjGh0NBcPZHZgW4_krrmihjLHmVzzuoMdl2MFAAAAAQ"`
+	Signature         string `json:"signature" validate:"required" example:"MEUCIQDx"`
+	UserHandle        string `json:"userHandle" validate:"required" example:"MQ"`
+}
+
+// WebAuthnLoginRequest 為 navigator.credentials.get() 回傳的 PublicKeyCredential 序列化後的 JSON
+// swagger:model api.WebAuthnLoginRequest
+type WebAuthnLoginRequest struct {
+	ID       string                    `json:"id" validate:"required" example:"3q2-7wXyZ0aB1cD2eF3gHw"`
+	RawID    string                    `json:"rawId" example:"3q2-7wXyZ0aB1cD2eF3gHw"`
+	Type     string                    `json:"type" validate:"eq=public-key" example:"public-key"`
+	Response WebAuthnAssertionResponse `json:"response"`
+}
@@ -0,0 +1,48 @@
+package api
+
+// swagger:model api.WebAuthnRelyingParty
+type WebAuthnRelyingParty struct {
+	ID   string `json:"id" example:"auth.example.com"`
+	Name string `json:"name" example:"life-is-hard"`
+}
+
+// swagger:model api.WebAuthnUser
+type WebAuthnUser struct {
+	// ID 為 base64url 編碼的 user handle
+	ID          string `json:"id" example:"MQ"`
+	Name        string `json:"name" example:"alice"`
+	DisplayName string `json:"displayName" example:"alice"`
+}
+
+// swagger:model api.WebAuthnCredentialParameter
+type WebAuthnCredentialParameter struct {
+	Type string `json:"type" example:"public-key"`
+	Alg  int    `json:"alg" example:"-7"`
+}
+
+// swagger:model api.WebAuthnCredentialDescriptor
+type WebAuthnCredentialDescriptor struct {
+	Type       string   `json:"type" example:"public-key"`
+	ID         string   `json:"id" example:"3q2-7wXyZ0aB1cD2eF3gHw"`
+	Transports []string `json:"transports,omitempty" example:"internal,hybrid"`
+}
+
+// swagger:model api.WebAuthnAuthenticatorSelection
+type WebAuthnAuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey" example:"required"`
+	RequireResidentKey bool   `json:"requireResidentKey" example:"true"`
+	UserVerification   string `json:"userVerification" example:"required"`
+}
+
+// WebAuthnRegistrationOptionsResponse 為 navigator.credentials.create() 的 PublicKeyCredentialCreationOptions，二進位欄位皆為 base64url
+// swagger:model api.WebAuthnRegistrationOptionsResponse
+type WebAuthnRegistrationOptionsResponse struct {
+	Challenge              string                         `json:"challenge" example:"Yb2x6Q1nG0p8c3l5vQx9u7k2m4s6w8z0a1d3f5h7j9E"`
+	RP                     WebAuthnRelyingParty           `json:"rp"`
+	User                   WebAuthnUser                   `json:"user"`
+	PubKeyCredParams       []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                            `json:"timeout" example:"300000"`
+	ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection WebAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                         `json:"attestation" example:"none"`
+}
@@ -0,0 +1,18 @@
+package api
+
+// swagger:model api.WebAuthnAttestationResponse
+type WebAuthnAttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON" validate:"required" example:"eyJ0eXBlIjoid2ViYXV0aG4uY3JlYXRlIn0"`
+	AttestationObject string   `json:"attestationObject" validate:"required" example:"o2NmbXRkbm9uZQ"`
+	Transports        []string `json:"transports" example:"internal,hybrid"`
+}
+
+// WebAuthnRegistrationRequest 為 navigator.credentials.create() 回傳的 PublicKeyCredential 序列化後的 JSON，另可附上 credential 名稱
+// swagger:model api.WebAuthnRegistrationRequest
+type WebAuthnRegistrationRequest struct {
+	ID       string                      `json:"id" validate:"required" example:"3q2-7wXyZ0aB1cD2eF3gHw"`
+	RawID    string                      `json:"rawId" example:"3q2-7wXyZ0aB1cD2eF3gHw"`
+	Type     string                      `json:"type" validate:"eq=public-key" example:"public-key"`
+	Response WebAuthnAttestationResponse `json:"response"`
+	Name     string                      `json:"name" validate:"max=64" example:"MacBook Touch ID"`
+}
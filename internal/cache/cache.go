@@ -8,7 +8,7 @@ import (
 )
 
 // Cache 定義快取操作介面
-// 提供基礎的 Get、Set、Close 方法
+// 提供基礎的 Get、Set、Del、Close 方法，以及計數用的 Incr、Expire 與僅在 key 不存在時寫入的 SetNX
 // 用於封裝 Redis 或其他快取實作
 // 方便測試時替換 FakeCache 實作
 // ttl <= 0 表示不設過期
@@ -16,13 +16,21 @@ import (
 type Cache interface {
 	Get(ctx context.Context, key string) *redis.StringCmd
 	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) *redis.StatusCmd
+	SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) *redis.BoolCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	Incr(ctx context.Context, key string) *redis.IntCmd
+	Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
 	Close() error
 }
 
 type FakeCache struct {
-	GetFn   func(ctx context.Context, key string) *redis.StringCmd
-	SetFn   func(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd
-	CloseFn func() error
+	GetFn    func(ctx context.Context, key string) *redis.StringCmd
+	SetFn    func(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd
+	SetNXFn  func(ctx context.Context, key string, value any, expiration time.Duration) *redis.BoolCmd
+	DelFn    func(ctx context.Context, keys ...string) *redis.IntCmd
+	IncrFn   func(ctx context.Context, key string) *redis.IntCmd
+	ExpireFn func(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
+	CloseFn  func() error
 }
 
 // Get 執行 Fake 設定或 panic
@@ -41,6 +49,38 @@ func (f *FakeCache) Set(ctx context.Context, key string, value any, expiration t
 	panic("unexpected Set")
 }
 
+// SetNX 執行 Fake 設定或 panic
+func (f *FakeCache) SetNX(ctx context.Context, key string, value any, expiration time.Duration) *redis.BoolCmd {
+	if f.SetNXFn != nil {
+		return f.SetNXFn(ctx, key, value, expiration)
+	}
+	panic("unexpected SetNX")
+}
+
+// Del 執行 Fake 設定或 panic
+func (f *FakeCache) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	if f.DelFn != nil {
+		return f.DelFn(ctx, keys...)
+	}
+	panic("unexpected Del")
+}
+
+// Incr 執行 Fake 設定或 panic
+func (f *FakeCache) Incr(ctx context.Context, key string) *redis.IntCmd {
+	if f.IncrFn != nil {
+		return f.IncrFn(ctx, key)
+	}
+	panic("unexpected Incr")
+}
+
+// Expire 執行 Fake 設定或 panic
+func (f *FakeCache) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	if f.ExpireFn != nil {
+		return f.ExpireFn(ctx, key, expiration)
+	}
+	panic("unexpected Expire")
+}
+
 // Close 執行 Fake 設定或 no-op
 func (f *FakeCache) Close() error {
 	if f.CloseFn != nil {
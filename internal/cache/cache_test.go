@@ -14,10 +14,15 @@ func TestFakeCache(t *testing.T) {
 	c := &FakeCache{}
 	require.Panics(t, func() { c.Get(context.Background(), "k") })
 	require.Panics(t, func() { c.Set(context.Background(), "k", 1, 0) })
+	require.Panics(t, func() { c.Del(context.Background(), "k") })
+	require.Panics(t, func() { c.SetNX(context.Background(), "k", 1, 0) })
+	require.Panics(t, func() { c.Incr(context.Background(), "k") })
+	require.Panics(t, func() { c.Expire(context.Background(), "k", 0) })
 	require.NoError(t, c.Close())
 
 	gCalled := false
 	sCalled := false
+	dCalled := false
 	clCalled := false
 	c.GetFn = func(ctx context.Context, key string) *redis.StringCmd {
 		gCalled = true
@@ -27,12 +32,77 @@ func TestFakeCache(t *testing.T) {
 		sCalled = true
 		return redis.NewStatusResult("OK", nil)
 	}
+	c.DelFn = func(ctx context.Context, keys ...string) *redis.IntCmd {
+		dCalled = true
+		return redis.NewIntResult(int64(len(keys)), nil)
+	}
+	c.SetNXFn = func(ctx context.Context, key string, val any, exp time.Duration) *redis.BoolCmd {
+		return redis.NewBoolResult(true, nil)
+	}
+	c.IncrFn = func(ctx context.Context, key string) *redis.IntCmd {
+		return redis.NewIntResult(3, nil)
+	}
+	c.ExpireFn = func(ctx context.Context, key string, exp time.Duration) *redis.BoolCmd {
+		return redis.NewBoolResult(exp > 0, nil)
+	}
 	c.CloseFn = func() error { clCalled = true; return errors.New("close") }
 
 	require.Equal(t, "v", c.Get(context.Background(), "k").Val())
 	require.Equal(t, "OK", c.Set(context.Background(), "k", 1, 0).Val())
+	require.Equal(t, int64(2), c.Del(context.Background(), "a", "b").Val())
+	require.True(t, c.SetNX(context.Background(), "k", 1, 0).Val())
+	require.Equal(t, int64(3), c.Incr(context.Background(), "k").Val())
+	require.True(t, c.Expire(context.Background(), "k", time.Second).Val())
 	require.EqualError(t, c.Close(), "close")
 	require.True(t, gCalled)
 	require.True(t, sCalled)
+	require.True(t, dCalled)
 	require.True(t, clCalled)
 }
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	var c Cache = NewMemoryCache(nil)
+	m := c.(*MemoryCache)
+
+	_, err := c.Get(ctx, "k").Result()
+	require.ErrorIs(t, err, redis.Nil)
+
+	require.NoError(t, c.Set(ctx, "k", []byte("v"), time.Minute).Err())
+	require.NoError(t, c.Set(ctx, "n", 3, 0).Err())
+	require.Equal(t, "v", c.Get(ctx, "k").Val())
+	require.Equal(t, "3", c.Get(ctx, "n").Val())
+	require.Equal(t, time.Minute, m.TTLs["k"])
+
+	require.Equal(t, int64(1), c.Del(ctx, "k", "missing").Val())
+	require.NotContains(t, m.Data, "k")
+	require.NotContains(t, m.TTLs, "k")
+
+	require.True(t, c.SetNX(ctx, "once", "a", time.Minute).Val())
+	require.False(t, c.SetNX(ctx, "once", "b", time.Hour).Val())
+	require.Equal(t, "a", m.Data["once"])
+	require.Equal(t, time.Minute, m.TTLs["once"])
+
+	require.False(t, c.Expire(ctx, "c", time.Hour).Val())
+	require.Equal(t, int64(1), c.Incr(ctx, "c").Val())
+	require.True(t, c.Expire(ctx, "c", time.Hour).Val())
+	require.Equal(t, int64(2), c.Incr(ctx, "c").Val())
+	require.Equal(t, time.Hour, m.TTLs["c"])
+	require.NoError(t, c.Set(ctx, "s", "v", 0).Err())
+	require.Error(t, c.Incr(ctx, "s").Err())
+
+	m.FailOn["get"] = "n"
+	m.FailOn["setnx"] = "n"
+	m.FailOn["incr"] = "n"
+	m.FailOn["expire"] = "n"
+	require.Error(t, c.SetNX(ctx, "n", "v", 0).Err())
+	require.Error(t, c.Incr(ctx, "n").Err())
+	require.Error(t, c.Expire(ctx, "n", time.Hour).Err())
+	m.FailOn["set"] = "x:"
+	m.FailOn["del"] = "n"
+	require.Error(t, c.Get(ctx, "n").Err())
+	require.Error(t, c.Set(ctx, "x:1", "v", 0).Err())
+	require.Error(t, c.Del(ctx, "a", "n").Err())
+	require.Contains(t, m.Data, "n")
+	require.NoError(t, c.Close())
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryCache 以 map 實作 Cache，供測試觀察跨請求的快取內容；TTL 只記錄不會過期。
+// FailOn 以操作名稱（get、set、setnx、del、incr、expire）對應 key 前綴，key 符合時該操作回傳錯誤
+type MemoryCache struct {
+	Data   map[string]string
+	TTLs   map[string]time.Duration
+	FailOn map[string]string
+}
+
+// NewMemoryCache 以 data 為初始內容建立 MemoryCache；data 為 nil 時建立空的 map
+func NewMemoryCache(data map[string]string) *MemoryCache {
+	if data == nil {
+		data = map[string]string{}
+	}
+	return &MemoryCache{Data: data, TTLs: map[string]time.Duration{}, FailOn: map[string]string{}}
+}
+
+func (m *MemoryCache) fails(op, key string) bool {
+	prefix, ok := m.FailOn[op]
+	return ok && strings.HasPrefix(key, prefix)
+}
+
+// Get 回傳 key 的值，不存在時回傳 redis.Nil
+func (m *MemoryCache) Get(_ context.Context, key string) *redis.StringCmd {
+	if m.fails("get", key) {
+		return redis.NewStringResult("", errors.New("get"))
+	}
+	if v, ok := m.Data[key]; ok {
+		return redis.NewStringResult(v, nil)
+	}
+	return redis.NewStringResult("", redis.Nil)
+}
+
+// Set 與 Redis 相同以字串保存 value
+func (m *MemoryCache) Set(_ context.Context, key string, value any, ttl time.Duration) *redis.StatusCmd {
+	if m.fails("set", key) {
+		return redis.NewStatusResult("", errors.New("set"))
+	}
+	m.Data[key] = toString(value)
+	m.TTLs[key] = ttl
+	return redis.NewStatusResult("OK", nil)
+}
+
+// SetNX 僅在 key 不存在時寫入，回傳是否寫入
+func (m *MemoryCache) SetNX(_ context.Context, key string, value any, ttl time.Duration) *redis.BoolCmd {
+	if m.fails("setnx", key) {
+		return redis.NewBoolResult(false, errors.New("setnx"))
+	}
+	if _, ok := m.Data[key]; ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	m.Data[key] = toString(value)
+	m.TTLs[key] = ttl
+	return redis.NewBoolResult(true, nil)
+}
+
+// Del 刪除 keys 並回傳實際刪除的數量
+func (m *MemoryCache) Del(_ context.Context, keys ...string) *redis.IntCmd {
+	for _, k := range keys {
+		if m.fails("del", k) {
+			return redis.NewIntResult(0, errors.New("del"))
+		}
+	}
+	var deleted int64
+	for _, k := range keys {
+		if _, ok := m.Data[k]; ok {
+			deleted++
+			delete(m.Data, k)
+			delete(m.TTLs, k)
+		}
+	}
+	return redis.NewIntResult(deleted, nil)
+}
+
+// Incr 將 key 的整數值加一並回傳，不存在時自 0 起算；與 Redis 相同保留原本的 TTL
+func (m *MemoryCache) Incr(_ context.Context, key string) *redis.IntCmd {
+	if m.fails("incr", key) {
+		return redis.NewIntResult(0, errors.New("incr"))
+	}
+	var n int64
+	if v, ok := m.Data[key]; ok {
+		var err error
+		if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return redis.NewIntResult(0, err)
+		}
+	}
+	n++
+	m.Data[key] = strconv.FormatInt(n, 10)
+	return redis.NewIntResult(n, nil)
+}
+
+// Expire 設定既有 key 的 TTL，key 不存在時回傳 false
+func (m *MemoryCache) Expire(_ context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	if m.fails("expire", key) {
+		return redis.NewBoolResult(false, errors.New("expire"))
+	}
+	if _, ok := m.Data[key]; !ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	m.TTLs[key] = expiration
+	return redis.NewBoolResult(true, nil)
+}
+
+// Close 為 no-op
+func (m *MemoryCache) Close() error {
+	return nil
+}
+
+func toString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
@@ -27,6 +27,22 @@ func (s *stubClient) Set(ctx context.Context, key string, value interface{}, ttl
 	return redis.NewStatusResult("OK", nil)
 }
 
+func (s *stubClient) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) *redis.BoolCmd {
+	return redis.NewBoolResult(true, nil)
+}
+
+func (s *stubClient) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	return redis.NewIntResult(int64(len(keys)), nil)
+}
+
+func (s *stubClient) Incr(ctx context.Context, key string) *redis.IntCmd {
+	return redis.NewIntResult(1, nil)
+}
+
+func (s *stubClient) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	return redis.NewBoolResult(true, nil)
+}
+
 func (s *stubClient) Close() error { return nil }
 
 func TestNewRedisClient(t *testing.T) {
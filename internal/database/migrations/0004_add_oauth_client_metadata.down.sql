@@ -0,0 +1,5 @@
+ALTER TABLE oauth_clients
+    DROP COLUMN IF EXISTS scopes,
+    DROP COLUMN IF EXISTS logo_uri,
+    DROP COLUMN IF EXISTS client_name,
+    DROP COLUMN IF EXISTS client_type;
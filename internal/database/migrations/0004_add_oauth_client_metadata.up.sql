@@ -0,0 +1,5 @@
+ALTER TABLE oauth_clients
+    ADD COLUMN client_type TEXT   NOT NULL DEFAULT 'confidential' CHECK (client_type IN ('public', 'confidential')),
+    ADD COLUMN client_name TEXT   NOT NULL DEFAULT '',
+    ADD COLUMN logo_uri    TEXT   NOT NULL DEFAULT '',
+    ADD COLUMN scopes      TEXT[] NOT NULL DEFAULT ARRAY[]::TEXT[];
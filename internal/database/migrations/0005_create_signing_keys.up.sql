@@ -0,0 +1,16 @@
+CREATE TABLE signing_keys (
+    kid         TEXT          PRIMARY KEY,
+    algorithm   TEXT          NOT NULL CHECK (algorithm IN ('RS256', 'ES256', 'EdDSA')),
+    status      TEXT          NOT NULL CHECK (status IN ('next', 'active', 'retired')),
+    private_key BYTEA         NOT NULL,
+    public_key  BYTEA         NOT NULL,
+    expires_at  TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ   NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ   NOT NULL DEFAULT now()
+);
+
+-- active 與 next 各最多一把；輪替時同一個 UPDATE 內互換狀態，因此延後至交易結束才檢查
+ALTER TABLE signing_keys
+    ADD CONSTRAINT signing_keys_single_active_next
+    EXCLUDE USING btree (status WITH =) WHERE (status IN ('next', 'active'))
+    DEFERRABLE INITIALLY DEFERRED;
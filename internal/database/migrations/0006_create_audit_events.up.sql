@@ -0,0 +1,11 @@
+CREATE TABLE audit_events (
+    id          BIGSERIAL     PRIMARY KEY,
+    event_type  TEXT          NOT NULL,
+    user_id     INTEGER       REFERENCES users(id) ON DELETE SET NULL,
+    client_id   TEXT          NOT NULL DEFAULT '',
+    ip_address  TEXT          NOT NULL DEFAULT '',
+    details     JSONB         NOT NULL DEFAULT '{}'::JSONB,
+    created_at  TIMESTAMPTZ   NOT NULL DEFAULT now()
+);
+
+CREATE INDEX audit_events_user_id_idx ON audit_events (user_id, created_at);
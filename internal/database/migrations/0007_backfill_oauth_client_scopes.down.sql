@@ -0,0 +1,2 @@
+-- 回填前後的 scope 無法區分，不還原
+SELECT 1;
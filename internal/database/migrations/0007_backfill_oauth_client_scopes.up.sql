@@ -0,0 +1,4 @@
+-- 啟用 scope 檢查前建立的 client 未登記 scope，補上全部 scope 以維持原有存取範圍
+UPDATE oauth_clients
+SET scopes = ARRAY['openid', 'profile', 'email', 'users:read', 'users:write', 'clients:manage']::TEXT[]
+WHERE cardinality(scopes) = 0;
@@ -0,0 +1,4 @@
+-- 雜湊無法還原為明文，僅移除輪替用欄位
+ALTER TABLE oauth_clients
+    DROP COLUMN IF EXISTS previous_client_secret_expires_at,
+    DROP COLUMN IF EXISTS previous_client_secret;
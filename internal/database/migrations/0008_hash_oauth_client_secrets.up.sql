@@ -0,0 +1,10 @@
+-- 既有的明文 secret 以 bcrypt 雜湊（與 Go 的 bcrypt 相容），之後只保存雜湊
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+UPDATE oauth_clients
+SET client_secret = crypt(client_secret, gen_salt('bf', 10))
+WHERE client_secret NOT LIKE '$2_$%';
+
+ALTER TABLE oauth_clients
+    ADD COLUMN previous_client_secret            TEXT NOT NULL DEFAULT '',
+    ADD COLUMN previous_client_secret_expires_at TIMESTAMPTZ;
@@ -0,0 +1,4 @@
+ALTER TABLE oauth_clients
+    DROP COLUMN IF EXISTS client_secret_sealed,
+    DROP COLUMN IF EXISTS jwks,
+    DROP COLUMN IF EXISTS token_endpoint_auth_method;
@@ -0,0 +1,6 @@
+-- 既有 client 皆以 HTTP Basic 認證，預設值維持原行為
+ALTER TABLE oauth_clients
+    ADD COLUMN token_endpoint_auth_method TEXT  NOT NULL DEFAULT 'client_secret_basic'
+        CHECK (token_endpoint_auth_method IN ('client_secret_basic', 'client_secret_post', 'client_secret_jwt', 'private_key_jwt', 'none')),
+    ADD COLUMN jwks                       JSONB,
+    ADD COLUMN client_secret_sealed       BYTEA;
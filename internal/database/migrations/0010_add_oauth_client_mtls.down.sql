@@ -0,0 +1,7 @@
+ALTER TABLE oauth_clients
+    DROP COLUMN IF EXISTS tls_client_certificate_bound_access_tokens,
+    DROP COLUMN IF EXISTS tls_client_certificate_thumbprint,
+    DROP COLUMN IF EXISTS tls_client_auth_subject_dn,
+    DROP CONSTRAINT oauth_clients_token_endpoint_auth_method_check,
+    ADD CONSTRAINT oauth_clients_token_endpoint_auth_method_check
+        CHECK (token_endpoint_auth_method IN ('client_secret_basic', 'client_secret_post', 'client_secret_jwt', 'private_key_jwt', 'none'));
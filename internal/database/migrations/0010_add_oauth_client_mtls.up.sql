@@ -0,0 +1,9 @@
+-- RFC 8705：以 client 憑證認證，並可將 access token 綁定至憑證
+ALTER TABLE oauth_clients
+    DROP CONSTRAINT oauth_clients_token_endpoint_auth_method_check,
+    ADD CONSTRAINT oauth_clients_token_endpoint_auth_method_check
+        CHECK (token_endpoint_auth_method IN ('client_secret_basic', 'client_secret_post', 'client_secret_jwt', 'private_key_jwt', 'none',
+                                              'tls_client_auth', 'self_signed_tls_client_auth')),
+    ADD COLUMN tls_client_auth_subject_dn                 TEXT    NOT NULL DEFAULT '',
+    ADD COLUMN tls_client_certificate_thumbprint          TEXT    NOT NULL DEFAULT '',
+    ADD COLUMN tls_client_certificate_bound_access_tokens BOOLEAN NOT NULL DEFAULT FALSE;
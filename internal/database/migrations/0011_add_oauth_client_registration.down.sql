@@ -0,0 +1,4 @@
+DELETE FROM oauth_clients WHERE user_id IS NULL;
+ALTER TABLE oauth_clients
+    DROP COLUMN IF EXISTS registration_access_token,
+    ALTER COLUMN user_id SET NOT NULL;
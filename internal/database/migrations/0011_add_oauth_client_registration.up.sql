@@ -0,0 +1,4 @@
+-- RFC 7591/7592：動態註冊的 client 可能沒有擁有者，並以 registration access token（僅保存 SHA-256 雜湊）管理自身的註冊資料
+ALTER TABLE oauth_clients
+    ALTER COLUMN user_id DROP NOT NULL,
+    ADD COLUMN registration_access_token TEXT NOT NULL DEFAULT '';
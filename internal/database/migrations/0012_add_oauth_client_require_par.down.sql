@@ -0,0 +1,2 @@
+ALTER TABLE oauth_clients
+    DROP COLUMN IF EXISTS require_pushed_authorization_requests;
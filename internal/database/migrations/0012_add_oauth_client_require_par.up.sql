@@ -0,0 +1,3 @@
+-- RFC 9126 §6：要求 client 一律先透過 PAR 推送授權請求
+ALTER TABLE oauth_clients
+    ADD COLUMN require_pushed_authorization_requests BOOLEAN NOT NULL DEFAULT FALSE;
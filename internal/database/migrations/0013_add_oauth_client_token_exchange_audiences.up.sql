@@ -0,0 +1,3 @@
+-- RFC 8693：client 以 token exchange 可換取的 audience
+ALTER TABLE oauth_clients
+    ADD COLUMN token_exchange_audiences TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,7 @@
+ALTER TABLE oauth_clients
+    DROP COLUMN IF EXISTS access_token_audiences,
+    DROP COLUMN IF EXISTS id_token_ttl,
+    DROP COLUMN IF EXISTS refresh_token_idle_timeout,
+    DROP COLUMN IF EXISTS refresh_token_absolute_ttl,
+    DROP COLUMN IF EXISTS refresh_token_ttl,
+    DROP COLUMN IF EXISTS access_token_ttl;
@@ -0,0 +1,8 @@
+-- client 自訂的 token 效期（秒）與 access token 的 aud；0 與空陣列表示沿用伺服器預設
+ALTER TABLE oauth_clients
+    ADD COLUMN access_token_ttl INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN refresh_token_ttl INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN refresh_token_absolute_ttl INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN refresh_token_idle_timeout INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN id_token_ttl INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN access_token_audiences TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS oauth_grants;
+
+ALTER TABLE oauth_clients
+    DROP COLUMN IF EXISTS first_party;
@@ -0,0 +1,11 @@
+-- 使用者同意第三方 client 存取的 scope；第一方 client 不需經過同意
+ALTER TABLE oauth_clients
+    ADD COLUMN first_party BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE oauth_grants (
+    user_id     INTEGER       NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id   TEXT          NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    scopes      TEXT[]        NOT NULL DEFAULT '{}',
+    granted_at  TIMESTAMPTZ   NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, client_id)
+);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS users_is_admin_idx;
+DROP INDEX IF EXISTS users_email_domain_idx;
+DROP INDEX IF EXISTS users_name_pattern_idx;
+DROP INDEX IF EXISTS users_created_at_idx;
+
+ALTER TABLE users ALTER COLUMN created_at DROP NOT NULL;
@@ -0,0 +1,8 @@
+-- 管理員列出使用者時的篩選與 keyset 分頁；created_at 改為 NOT NULL 以便作為游標
+UPDATE users SET created_at = now() WHERE created_at IS NULL;
+ALTER TABLE users ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX users_created_at_idx ON users (created_at, id);
+CREATE INDEX users_name_pattern_idx ON users (name text_pattern_ops);
+CREATE INDEX users_email_domain_idx ON users (split_part(email, '@', 2));
+CREATE INDEX users_is_admin_idx ON users (id) WHERE is_admin;
@@ -0,0 +1,3 @@
+-- 使用者是否已驗證目前的 Email；變更 Email 時重設為未驗證
+ALTER TABLE users
+    ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,16 @@
+-- 使用者的 TOTP 金鑰；confirmed_at 為 NULL 表示尚未完成註冊。last_used_step 防止同一組驗證碼重複使用
+CREATE TABLE user_totp (
+    user_id         INTEGER       PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret          TEXT          NOT NULL,
+    confirmed_at    TIMESTAMPTZ,
+    last_used_step  BIGINT        NOT NULL DEFAULT 0,
+    created_at      TIMESTAMPTZ   NOT NULL DEFAULT now()
+);
+
+-- 遺失驗證器時使用的一次性 recovery code，僅保存 SHA-256 雜湊
+CREATE TABLE user_recovery_codes (
+    user_id     INTEGER       NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash   TEXT          NOT NULL,
+    used_at     TIMESTAMPTZ,
+    PRIMARY KEY (user_id, code_hash)
+);
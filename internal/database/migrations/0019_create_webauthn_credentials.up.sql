@@ -0,0 +1,13 @@
+-- 使用者註冊的 WebAuthn credential（passkey）；id 為 base64url 編碼的 credential ID，public_key 為 COSE 格式的公鑰
+CREATE TABLE webauthn_credentials (
+    id            TEXT          PRIMARY KEY,
+    user_id       INTEGER       NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name          TEXT          NOT NULL DEFAULT '',
+    public_key    BYTEA         NOT NULL,
+    sign_count    BIGINT        NOT NULL DEFAULT 0,
+    transports    TEXT[]        NOT NULL DEFAULT '{}',
+    created_at    TIMESTAMPTZ   NOT NULL DEFAULT now(),
+    last_used_at  TIMESTAMPTZ
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
@@ -1,31 +1,38 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
 	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
 	"life-is-hard/internal/service"
 	"life-is-hard/internal/store"
 
 	"github.com/labstack/echo/v4"
 )
 
+// mfaChallengeTTL 為密碼驗證通過後提交第二因素的期限
+const mfaChallengeTTL = 5 * time.Minute
+
 // @Summary     登入使用者
-// @Description 使用 Username 與 Password 進行驗證，回傳存取令牌與到期時間
+// @Description 使用 Username 與 Password 進行驗證，回傳存取令牌與到期時間；已啟用 MFA 的使用者改回傳 202 與 mfa_token，須再以 /auth/mfa/verify 提交 TOTP 驗證碼或 recovery code。已註冊 passkey 的使用者可改以 /auth/webauthn/login 免密碼登入
 // @Tags        auth
 // @Accept      application/x-www-form-urlencoded
 // @Produce     json
 // @Param       username formData string true "使用者名稱"
 // @Param       password formData string true "使用者密碼"
 // @Success     200      {object} api.LoginResponse
+// @Success     202      {object} api.MFAChallengeResponse
 // @Failure     400      {object} api.ErrorResponse
 // @Failure     401      {object} api.ErrorResponse
 // @Failure     500      {object} api.ErrorResponse
 // @Router      /auth/login [post]
-func LoginHandler(db database.DB) echo.HandlerFunc {
+func LoginHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var req api.LoginRequest
 		if err := c.Bind(&req); err != nil {
@@ -35,19 +42,93 @@ func LoginHandler(db database.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
 		}
 
-		user, err := store.GetUserByName(c.Request().Context(), db, req.Username)
+		ctx := c.Request().Context()
+		user, err := store.GetUserByName(ctx, db, req.Username)
 		if err != nil {
 			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid credentials"})
 		}
-		if err := service.AuthenticateUser(c.Request().Context(), *user, req.Password); err != nil {
+		if err := service.AuthenticateUser(ctx, *user, req.Password); err != nil {
 			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid credentials"})
 		}
 
-		token, err := service.IssueAccessToken(*user, 24*time.Hour)
+		mfaEnabled, err := service.MFAEnabled(ctx, db, user.ID)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: fmt.Sprintf("failed to issue token: %v", err)})
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to check mfa"})
+		}
+		if mfaEnabled {
+			token, err := service.IssueMFAChallenge(ctx, cache, user.ID, mfaChallengeTTL)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to issue mfa token"})
+			}
+			return c.JSON(http.StatusAccepted, api.MFAChallengeResponse{MFAToken: token, ExpiresIn: int(mfaChallengeTTL.Seconds())})
+		}
+
+		return issueLoginToken(c, *user, service.PasswordAuthentication())
+	}
+}
+
+// @Summary     Verify MFA
+// @Description 以登入時取得的 mfa_token 與 TOTP 驗證碼或 recovery code 完成登入，回傳帶有 amr 與 acr 的存取令牌；mfa_token 只能換發一次，同一使用者驗證失敗次數受限
+// @Tags        auth
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       mfa_token formData string true "登入時取得的 mfa_token"
+// @Param       code      formData string true "TOTP 驗證碼或 recovery code"
+// @Success     200       {object} api.LoginResponse
+// @Failure     400       {object} api.ErrorResponse
+// @Failure     401       {object} api.ErrorResponse
+// @Failure     429       {object} api.ErrorResponse
+// @Failure     500       {object} api.ErrorResponse
+// @Router      /auth/mfa/verify [post]
+func VerifyMFAHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.VerifyMFARequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid form data"})
 		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		ctx := c.Request().Context()
+		userID, err := service.MFAChallengeUser(ctx, cache, req.MFAToken)
+		if errors.Is(err, service.ErrInvalidMFAChallenge) {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to verify mfa token"})
+		}
+		if err := service.VerifyMFACode(ctx, db, cache, userID, req.Code); err != nil {
+			switch {
+			case errors.Is(err, service.ErrRateLimited):
+				return c.JSON(http.StatusTooManyRequests, api.ErrorResponse{Message: err.Error()})
+			case errors.Is(err, service.ErrInvalidMFACode), errors.Is(err, service.ErrMFANotEnabled):
+				return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to verify mfa code"})
+		}
+		// 驗證碼已使用，mfa_token 同樣只能換發一次
+		if err := service.ConsumeMFAChallenge(ctx, cache, req.MFAToken); err != nil {
+			if errors.Is(err, service.ErrInvalidMFAChallenge) {
+				return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to verify mfa token"})
+		}
+		user, err := store.GetUserByID(ctx, db, userID)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid credentials"})
+		}
+
+		return issueLoginToken(c, *user, service.MFAAuthentication())
+	}
+}
 
-		return c.JSON(http.StatusOK, api.LoginResponse{AccessToken: token})
+// issueLoginToken 發行第一方登入的 access token；不經由 OAuth client，效期與 aud 採伺服器預設
+func issueLoginToken(c echo.Context, user model.User, authn service.Authentication) error {
+	lifetimes := service.ServerTokenLifetimes()
+	token, err := service.IssueAccessToken(c.Request().Context(), user, "", "", lifetimes.Audience, lifetimes.AccessTokenTTL, nil, authn)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: fmt.Sprintf("failed to issue token: %v", err)})
 	}
+	return c.JSON(http.StatusOK, api.LoginResponse{AccessToken: token, ExpiresIn: int(lifetimes.AccessTokenTTL.Seconds())})
 }
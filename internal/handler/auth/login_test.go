@@ -2,19 +2,25 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
 	"life-is-hard/internal/database"
 	"life-is-hard/internal/model"
 	"life-is-hard/internal/service"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 )
 
@@ -38,9 +44,35 @@ func (r *fakeRow) Scan(dest ...any) error {
 	*dest[3].(*string) = u.PasswordHash
 	*dest[4].(*time.Time) = u.CreatedAt
 	*dest[5].(*bool) = u.IsAdmin
+	*dest[6].(*bool) = u.EmailVerified
 	return nil
 }
 
+type totpRow struct{ totp *model.UserTOTP }
+
+func (r *totpRow) Scan(dest ...any) error {
+	t := r.totp
+	*dest[0].(*int) = t.UserID
+	*dest[1].(*string) = t.Secret
+	*dest[2].(**time.Time) = t.ConfirmedAt
+	*dest[3].(*int64) = t.LastUsedStep
+	*dest[4].(*time.Time) = t.CreatedAt
+	return nil
+}
+
+// userDB 回傳查詢使用者時回傳 user 的 FakeDB；totp 為 nil 表示未啟用 MFA
+func userDB(user *model.User, totp *model.UserTOTP) *database.FakeDB {
+	return &database.FakeDB{QueryRowFn: func(_ context.Context, sql string, _ ...any) pgx.Row {
+		if strings.Contains(sql, "user_totp") {
+			if totp == nil {
+				return &fakeRow{err: pgx.ErrNoRows}
+			}
+			return &totpRow{totp: totp}
+		}
+		return &fakeRow{user: user}
+	}}
+}
+
 func newContext(e *echo.Echo, body string) (echo.Context, *httptest.ResponseRecorder) {
 	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
@@ -54,7 +86,7 @@ func TestLoginHandler(t *testing.T) {
 	t.Run("bind error", func(t *testing.T) {
 		e.Validator = &stubValidator{}
 		ctx, rec := newContext(e, "{bad json")
-		err := LoginHandler(&database.FakeDB{})(ctx)
+		err := LoginHandler(&database.FakeDB{}, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 		require.Contains(t, rec.Body.String(), "無效的表單資料")
@@ -63,7 +95,7 @@ func TestLoginHandler(t *testing.T) {
 	t.Run("validate error", func(t *testing.T) {
 		e.Validator = &stubValidator{err: errors.New("v")}
 		ctx, rec := newContext(e, `{"username":"u","password":"p"}`)
-		err := LoginHandler(&database.FakeDB{})(ctx)
+		err := LoginHandler(&database.FakeDB{}, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 		require.Contains(t, rec.Body.String(), "v")
@@ -75,7 +107,7 @@ func TestLoginHandler(t *testing.T) {
 			return &fakeRow{err: errors.New("no rows")}
 		}}
 		ctx, rec := newContext(e, `{"username":"u","password":"p"}`)
-		err := LoginHandler(db)(ctx)
+		err := LoginHandler(db, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusUnauthorized, rec.Code)
 	})
@@ -84,11 +116,9 @@ func TestLoginHandler(t *testing.T) {
 		e.Validator = &stubValidator{}
 		hash, _ := service.HashPassword("good")
 		sample := &model.User{ID: 1, Name: "u", Email: "e", PasswordHash: hash, CreatedAt: time.Now()}
-		db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
-			return &fakeRow{user: sample}
-		}}
+		db := userDB(sample, nil)
 		ctx, rec := newContext(e, `{"username":"u","password":"bad"}`)
-		err := LoginHandler(db)(ctx)
+		err := LoginHandler(db, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusUnauthorized, rec.Code)
 	})
@@ -97,12 +127,10 @@ func TestLoginHandler(t *testing.T) {
 		e.Validator = &stubValidator{}
 		hash, _ := service.HashPassword("pw")
 		sample := &model.User{ID: 2, Name: "u", Email: "e", PasswordHash: hash, CreatedAt: time.Now()}
-		db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
-			return &fakeRow{user: sample}
-		}}
+		db := userDB(sample, nil)
 		t.Setenv("JWT_SECRET", "")
 		ctx, rec := newContext(e, `{"username":"u","password":"pw"}`)
-		err := LoginHandler(db)(ctx)
+		err := LoginHandler(db, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusInternalServerError, rec.Code)
 		require.Contains(t, rec.Body.String(), "failed to issue token")
@@ -112,14 +140,159 @@ func TestLoginHandler(t *testing.T) {
 		e.Validator = &stubValidator{}
 		hash, _ := service.HashPassword("pw")
 		sample := &model.User{ID: 3, Name: "u", Email: "e", PasswordHash: hash, CreatedAt: time.Now()}
-		db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
-			return &fakeRow{user: sample}
-		}}
+		db := userDB(sample, nil)
 		t.Setenv("JWT_SECRET", "secret")
 		ctx, rec := newContext(e, `{"username":"u","password":"pw"}`)
-		err := LoginHandler(db)(ctx)
+		err := LoginHandler(db, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusOK, rec.Code)
 		require.Contains(t, rec.Body.String(), "access_token")
+		require.Contains(t, rec.Body.String(), `"expires_in":86400`)
+
+		var resp api.LoginResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		claims, err := service.VerifyAccessToken(context.Background(), cache.NewMemoryCache(nil), resp.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, service.PasswordAuthentication(), claims.Authentication)
+	})
+
+	t.Run("mfa check fail", func(t *testing.T) {
+		e.Validator = &stubValidator{}
+		hash, _ := service.HashPassword("pw")
+		sample := &model.User{ID: 4, Name: "u", PasswordHash: hash}
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, sql string, _ ...any) pgx.Row {
+			if strings.Contains(sql, "user_totp") {
+				return &fakeRow{err: errors.New("db")}
+			}
+			return &fakeRow{user: sample}
+		}}
+		ctx, rec := newContext(e, `{"username":"u","password":"pw"}`)
+		err := LoginHandler(db, &cache.FakeCache{})(ctx)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("mfa challenge", func(t *testing.T) {
+		e.Validator = &stubValidator{}
+		hash, _ := service.HashPassword("pw")
+		sample := &model.User{ID: 5, Name: "u", PasswordHash: hash}
+		confirmed := time.Now()
+		db := userDB(sample, &model.UserTOTP{UserID: 5, ConfirmedAt: &confirmed})
+		data := map[string]string{}
+		ctx, rec := newContext(e, `{"username":"u","password":"pw"}`)
+		err := LoginHandler(db, cache.NewMemoryCache(data))(ctx)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		require.NotContains(t, rec.Body.String(), "access_token")
+
+		var resp api.MFAChallengeResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, 300, resp.ExpiresIn)
+		userID, err := service.MFAChallengeUser(context.Background(), cache.NewMemoryCache(data), resp.MFAToken)
+		require.NoError(t, err)
+		require.Equal(t, 5, userID)
+	})
+
+	t.Run("mfa challenge store fail", func(t *testing.T) {
+		e.Validator = &stubValidator{}
+		hash, _ := service.HashPassword("pw")
+		sample := &model.User{ID: 6, Name: "u", PasswordHash: hash}
+		confirmed := time.Now()
+		db := userDB(sample, &model.UserTOTP{UserID: 6, ConfirmedAt: &confirmed})
+		fc := &cache.FakeCache{SetFn: func(_ context.Context, _ string, _ any, _ time.Duration) *redis.StatusCmd {
+			return redis.NewStatusResult("", errors.New("set"))
+		}}
+		ctx, rec := newContext(e, `{"username":"u","password":"pw"}`)
+		err := LoginHandler(db, fc)(ctx)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+		require.Contains(t, rec.Body.String(), "failed to issue mfa token")
+	})
+}
+
+func TestVerifyMFAHandler(t *testing.T) {
+	t.Setenv("JWT_SECRET", "secret")
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	confirmed := time.Now()
+	user := &model.User{ID: 7, Name: "alice"}
+	// 非 6 碼的驗證碼視為 recovery code，以 recoveryOK 決定是否可使用
+	var recoveryOK bool
+	db := userDB(user, &model.UserTOTP{UserID: 7, Secret: "JBSWY3DPEHPK3PXP", ConfirmedAt: &confirmed})
+	db.ExecFn = func(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+		if recoveryOK {
+			return pgconn.NewCommandTag("UPDATE 1"), nil
+		}
+		return pgconn.NewCommandTag("UPDATE 0"), nil
+	}
+	challenge := func(data map[string]string) string {
+		token, err := service.IssueMFAChallenge(context.Background(), cache.NewMemoryCache(data), 7, time.Minute)
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Run("validate error", func(t *testing.T) {
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newFormContext(e, url.Values{"mfa_token": {"x"}})
+		require.NoError(t, VerifyMFAHandler(db, cache.NewMemoryCache(nil))(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("invalid mfa token", func(t *testing.T) {
+		ctx, rec := newFormContext(e, url.Values{"mfa_token": {"unknown"}, "code": {"k3m9-x2q7"}})
+		require.NoError(t, VerifyMFAHandler(db, cache.NewMemoryCache(nil))(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Contains(t, rec.Body.String(), service.ErrInvalidMFAChallenge.Error())
+	})
+
+	t.Run("invalid code", func(t *testing.T) {
+		recoveryOK = false
+		data := map[string]string{}
+		token := challenge(data)
+		ctx, rec := newFormContext(e, url.Values{"mfa_token": {token}, "code": {"k3m9-x2q7"}})
+		require.NoError(t, VerifyMFAHandler(db, cache.NewMemoryCache(data))(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Contains(t, rec.Body.String(), service.ErrInvalidMFACode.Error())
+		require.Contains(t, data, "mfa_failures:7")
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		recoveryOK = true
+		data := map[string]string{"mfa_failures:7": "5"}
+		token := challenge(data)
+		ctx, rec := newFormContext(e, url.Values{"mfa_token": {token}, "code": {"k3m9-x2q7"}})
+		require.NoError(t, VerifyMFAHandler(db, cache.NewMemoryCache(data))(ctx))
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		recoveryOK = true
+		data := map[string]string{}
+		token := challenge(data)
+		ctx, rec := newFormContext(e, url.Values{"mfa_token": {token}, "code": {"k3m9-x2q7"}})
+		require.NoError(t, VerifyMFAHandler(db, cache.NewMemoryCache(data))(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp api.LoginResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		claims, err := service.VerifyAccessToken(context.Background(), cache.NewMemoryCache(data), resp.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, 7, claims.UserID)
+		require.Equal(t, service.MFAAuthentication(), claims.Authentication)
+
+		// mfa_token 只能換發一次
+		ctx, rec = newFormContext(e, url.Values{"mfa_token": {token}, "code": {"p8d4-w6n1"}})
+		require.NoError(t, VerifyMFAHandler(db, cache.NewMemoryCache(data))(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("cache error", func(t *testing.T) {
+		fc := &cache.FakeCache{GetFn: func(_ context.Context, _ string) *redis.StringCmd {
+			return redis.NewStringResult("", errors.New("get"))
+		}}
+		ctx, rec := newFormContext(e, url.Values{"mfa_token": {"x"}, "code": {"k3m9-x2q7"}})
+		require.NoError(t, VerifyMFAHandler(db, fc)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 }
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	allowPasswordReset   = service.AllowPasswordResetRequest
+	sendPasswordReset    = service.SendPasswordReset
+	consumePasswordReset = service.ConsumePasswordResetToken
+	revokeUserSessions   = service.RevokeUserSessions
+	hashPassword         = service.HashPassword
+	getUserByEmail       = store.GetUserByEmail
+	getUserByID          = store.GetUserByID
+	updateUserPassword   = store.UpdateUserPassword
+)
+
+// @Summary     Request password reset
+// @Description 寄送重設密碼信至該 Email；不論帳號是否存在皆回傳相同結果，同一 Email 與同一 IP 的要求次數受限
+// @Tags        auth
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       email formData string true "使用者 Email"
+// @Success     202   "Accepted"
+// @Failure     400   {object} api.ErrorResponse
+// @Failure     429   {object} api.ErrorResponse
+// @Failure     500   {object} api.ErrorResponse
+// @Router      /auth/password/forgot [post]
+func ForgotPasswordHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.ForgotPasswordRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid form data"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		ctx := c.Request().Context()
+		if err := allowPasswordReset(ctx, cache, req.Email, c.RealIP()); err != nil {
+			if errors.Is(err, service.ErrRateLimited) {
+				return c.JSON(http.StatusTooManyRequests, api.ErrorResponse{Message: err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to process request"})
+		}
+
+		user, err := getUserByEmail(ctx, db, req.Email)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.NoContent(http.StatusAccepted)
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to process request"})
+		}
+		// 於背景寄送，回應時間不受 SMTP 影響，無法藉此分辨帳號是否存在；寄送失敗只記錄
+		logger := c.Logger()
+		go func(ctx context.Context, user model.User) {
+			if err := sendPasswordReset(ctx, cache, user); err != nil {
+				logger.Errorf("failed to send password reset mail: %v", err)
+			}
+		}(context.WithoutCancel(ctx), *user)
+		return c.NoContent(http.StatusAccepted)
+	}
+}
+
+// @Summary     Reset password
+// @Description 以重設密碼信中的 token 設定新密碼，並撤銷該使用者所有的 access token 與 refresh token；token 只能使用一次，密碼若已變更則失效
+// @Tags        auth
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       token        formData string true "重設密碼信中的 token"
+// @Param       new_password formData string true "新密碼"
+// @Success     204          "No Content"
+// @Failure     400          {object} api.ErrorResponse
+// @Failure     500          {object} api.ErrorResponse
+// @Router      /auth/password/reset [post]
+func ResetPasswordHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.ResetPasswordRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid form data"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		ctx := c.Request().Context()
+		data, err := consumePasswordReset(ctx, cache, req.Token)
+		if errors.Is(err, service.ErrInvalidPasswordResetToken) {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to verify token"})
+		}
+		user, err := getUserByID(ctx, db, data.UserID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: service.ErrInvalidPasswordResetToken.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to retrieve user"})
+		}
+		if !data.ValidFor(*user) {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: service.ErrInvalidPasswordResetToken.Error()})
+		}
+
+		hash, err := hashPassword(req.NewPassword)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to hash password"})
+		}
+		// 先撤銷工作階段再更新密碼，避免新密碼生效後舊 token 仍可使用
+		if err := revokeUserSessions(ctx, cache, user.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to revoke sessions"})
+		}
+		if err := updateUserPassword(ctx, db, user.ID, hash); err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to update password"})
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
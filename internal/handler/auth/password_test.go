@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func restore() {
+	allowPasswordReset = service.AllowPasswordResetRequest
+	sendPasswordReset = service.SendPasswordReset
+	consumePasswordReset = service.ConsumePasswordResetToken
+	revokeUserSessions = service.RevokeUserSessions
+	hashPassword = service.HashPassword
+	getUserByEmail = store.GetUserByEmail
+	getUserByID = store.GetUserByID
+	updateUserPassword = store.UpdateUserPassword
+	beginWebAuthnLogin = service.BeginWebAuthnLogin
+	finishWebAuthnLogin = service.FinishWebAuthnLogin
+}
+
+func newFormContext(e *echo.Echo, form url.Values) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	return e.NewContext(req, rec), rec
+}
+
+func TestForgotPasswordHandler(t *testing.T) {
+	t.Cleanup(restore)
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	form := url.Values{"email": {"alice@example.com"}}
+	user := &model.User{ID: 1, Email: "alice@example.com"}
+
+	var gotEmail, gotIP string
+	allowPasswordReset = func(_ context.Context, _ cache.Cache, email, ip string) error {
+		gotEmail, gotIP = email, ip
+		return nil
+	}
+	// 寄送於背景進行，stub 完成後送出寄送的使用者
+	sent := make(chan model.User, 1)
+	sendPasswordReset = func(_ context.Context, _ cache.Cache, u model.User) error {
+		sent <- u
+		return nil
+	}
+
+	t.Run("validate error", func(t *testing.T) {
+		e.Validator = &stubValidator{err: errors.New("v")}
+		t.Cleanup(func() { e.Validator = &stubValidator{} })
+		ctx, rec := newFormContext(e, url.Values{})
+		require.NoError(t, ForgotPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("sends mail", func(t *testing.T) {
+		getUserByEmail = func(context.Context, database.DB, string) (*model.User, error) { return user, nil }
+		ctx, rec := newFormContext(e, form)
+		require.NoError(t, ForgotPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		require.Empty(t, rec.Body.String())
+		require.Equal(t, "alice@example.com", gotEmail)
+		require.Equal(t, "192.0.2.1", gotIP)
+		require.Equal(t, *user, <-sent)
+	})
+
+	t.Run("responds before mail is sent", func(t *testing.T) {
+		t.Cleanup(func() {
+			sendPasswordReset = func(_ context.Context, _ cache.Cache, u model.User) error {
+				sent <- u
+				return nil
+			}
+		})
+		getUserByEmail = func(context.Context, database.DB, string) (*model.User, error) { return user, nil }
+		release := make(chan struct{})
+		done := make(chan struct{})
+		sendPasswordReset = func(ctx context.Context, _ cache.Cache, _ model.User) error {
+			defer close(done)
+			<-release
+			// 請求結束後 context 仍不可被取消
+			return ctx.Err()
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		reqCtx, cancel := context.WithCancel(req.Context())
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req.WithContext(reqCtx), rec)
+		require.NoError(t, ForgotPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		cancel()
+
+		select {
+		case <-done:
+			t.Fatal("mailer finished before the handler returned")
+		default:
+		}
+		close(release)
+		<-done
+	})
+
+	// 帳號不存在或寄送失敗時回應與成功相同
+	t.Run("unknown email", func(t *testing.T) {
+		getUserByEmail = func(context.Context, database.DB, string) (*model.User, error) {
+			return nil, pgx.ErrNoRows
+		}
+		ctx, rec := newFormContext(e, form)
+		require.NoError(t, ForgotPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		require.Empty(t, rec.Body.String())
+		require.Empty(t, sent)
+	})
+
+	t.Run("send error", func(t *testing.T) {
+		getUserByEmail = func(context.Context, database.DB, string) (*model.User, error) { return user, nil }
+		failed := make(chan struct{})
+		sendPasswordReset = func(context.Context, cache.Cache, model.User) error {
+			defer close(failed)
+			return errors.New("smtp")
+		}
+		ctx, rec := newFormContext(e, form)
+		require.NoError(t, ForgotPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		require.Empty(t, rec.Body.String())
+		<-failed
+	})
+
+	t.Run("lookup error", func(t *testing.T) {
+		getUserByEmail = func(context.Context, database.DB, string) (*model.User, error) {
+			return nil, errors.New("db")
+		}
+		ctx, rec := newFormContext(e, form)
+		require.NoError(t, ForgotPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		allowPasswordReset = func(context.Context, cache.Cache, string, string) error { return service.ErrRateLimited }
+		ctx, rec := newFormContext(e, form)
+		require.NoError(t, ForgotPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	})
+
+	t.Run("rate limit error", func(t *testing.T) {
+		allowPasswordReset = func(context.Context, cache.Cache, string, string) error { return errors.New("cache") }
+		ctx, rec := newFormContext(e, form)
+		require.NoError(t, ForgotPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+}
+
+func TestResetPasswordHandler(t *testing.T) {
+	t.Cleanup(restore)
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	form := url.Values{"token": {"tok"}, "new_password": {"NewSecret456!"}}
+	user := &model.User{ID: 1, PasswordHash: "old"}
+	var issued *service.PasswordResetData
+
+	setup := func() {
+		restore()
+		// 以真實的 token 建立資料，確保密碼指紋與使用者一致
+		c := &cache.FakeCache{}
+		var stored string
+		c.SetFn = func(_ context.Context, _ string, v any, _ time.Duration) *redis.StatusCmd {
+			stored = string(v.([]byte))
+			return redis.NewStatusResult("OK", nil)
+		}
+		_, err := service.IssuePasswordResetToken(context.Background(), c, *user)
+		require.NoError(t, err)
+		issued = &service.PasswordResetData{}
+		require.NoError(t, json.Unmarshal([]byte(stored), issued))
+		consumePasswordReset = func(_ context.Context, _ cache.Cache, token string) (*service.PasswordResetData, error) {
+			require.Equal(t, "tok", token)
+			return issued, nil
+		}
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return user, nil }
+		hashPassword = func(string) (string, error) { return "new", nil }
+	}
+
+	t.Run("success", func(t *testing.T) {
+		setup()
+		var steps []string
+		revokeUserSessions = func(_ context.Context, _ cache.Cache, userID int) error {
+			require.Equal(t, 1, userID)
+			steps = append(steps, "revoke")
+			return nil
+		}
+		updateUserPassword = func(_ context.Context, _ database.DB, userID int, hash string) error {
+			require.Equal(t, 1, userID)
+			require.Equal(t, "new", hash)
+			steps = append(steps, "update")
+			return nil
+		}
+		ctx, rec := newFormContext(e, form)
+		require.NoError(t, ResetPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, []string{"revoke", "update"}, steps)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		setup()
+		consumePasswordReset = func(context.Context, cache.Cache, string) (*service.PasswordResetData, error) {
+			return nil, service.ErrInvalidPasswordResetToken
+		}
+		ctx, rec := newFormContext(e, form)
+		require.NoError(t, ResetPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	// 寄出後密碼已變更的 token 失效
+	t.Run("password changed", func(t *testing.T) {
+		setup()
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) {
+			return &model.User{ID: 1, PasswordHash: "changed"}, nil
+		}
+		ctx, rec := newFormContext(e, form)
+		require.NoError(t, ResetPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), service.ErrInvalidPasswordResetToken.Error())
+	})
+
+	t.Run("user deleted", func(t *testing.T) {
+		setup()
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return nil, pgx.ErrNoRows }
+		ctx, rec := newFormContext(e, form)
+		require.NoError(t, ResetPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	for name, prepare := range map[string]func(){
+		"consume error": func() {
+			consumePasswordReset = func(context.Context, cache.Cache, string) (*service.PasswordResetData, error) {
+				return nil, errors.New("cache")
+			}
+		},
+		"lookup error": func() {
+			getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return nil, errors.New("db") }
+		},
+		"hash error": func() {
+			hashPassword = func(string) (string, error) { return "", errors.New("hash") }
+		},
+		"revoke error": func() {
+			revokeUserSessions = func(context.Context, cache.Cache, int) error { return errors.New("cache") }
+		},
+		"update error": func() {
+			revokeUserSessions = func(context.Context, cache.Cache, int) error { return nil }
+			updateUserPassword = func(context.Context, database.DB, int, string) error { return errors.New("db") }
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			setup()
+			prepare()
+			ctx, rec := newFormContext(e, form)
+			require.NoError(t, ResetPasswordHandler(nil, nil)(ctx))
+			require.Equal(t, http.StatusInternalServerError, rec.Code)
+		})
+	}
+
+	t.Run("validate error", func(t *testing.T) {
+		e.Validator = &stubValidator{err: errors.New("v")}
+		ctx, rec := newFormContext(e, url.Values{})
+		require.NoError(t, ResetPasswordHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
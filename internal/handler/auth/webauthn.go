@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	beginWebAuthnLogin  = service.BeginWebAuthnLogin
+	finishWebAuthnLogin = service.FinishWebAuthnLogin
+)
+
+// @Summary     Begin passkey login
+// @Description 產生 WebAuthn 登入 ceremony 的參數，交給 navigator.credentials.get()；challenge 只能使用一次。不指定 allowCredentials，由瀏覽器列出此網域可用的 passkey
+// @Tags        auth
+// @Produce     json
+// @Success     200 {object} api.WebAuthnLoginOptionsResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Router      /auth/webauthn/login/options [post]
+func WebAuthnLoginOptionsHandler(cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		challenge, err := beginWebAuthnLogin(c.Request().Context(), cache)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to begin passkey login"})
+		}
+		return c.JSON(http.StatusOK, api.WebAuthnLoginOptionsResponse{
+			Challenge:        challenge,
+			RPID:             service.WebAuthnRP().ID,
+			Timeout:          int(service.WebAuthnChallengeTTL.Milliseconds()),
+			UserVerification: "required",
+			AllowCredentials: []api.WebAuthnCredentialDescriptor{},
+		})
+	}
+}
+
+// @Summary     Passkey login
+// @Description 以 navigator.credentials.get() 回傳的 assertion 登入，取代 /auth/login 的密碼驗證；passkey 須經使用者驗證（生物辨識或 PIN），簽發的存取令牌視為多重因素驗證（amr 為 hwk 與 mfa，acr 為 aal2）
+// @Tags        auth
+// @Accept      json
+// @Produce     json
+// @Param       request body     api.WebAuthnLoginRequest true "PublicKeyCredential"
+// @Success     200     {object} api.LoginResponse
+// @Failure     400     {object} api.ErrorResponse
+// @Failure     401     {object} api.ErrorResponse
+// @Failure     500     {object} api.ErrorResponse
+// @Router      /auth/webauthn/login [post]
+func WebAuthnLoginHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.WebAuthnLoginRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid request payload"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		ctx := c.Request().Context()
+		userID, err := finishWebAuthnLogin(ctx, db, cache, service.WebAuthnAssertionResponse{
+			ID:                req.ID,
+			ClientDataJSON:    req.Response.ClientDataJSON,
+			AuthenticatorData: req.Response.AuthenticatorData,
+			Signature:         req.Response.Signature,
+			UserHandle:        req.Response.UserHandle,
+		})
+		if errors.Is(err, service.ErrInvalidWebAuthnChallenge) || errors.Is(err, service.ErrInvalidWebAuthnResponse) {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to verify passkey"})
+		}
+		user, err := getUserByID(ctx, db, userID)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid credentials"})
+		}
+
+		return issueLoginToken(c, *user, service.PasskeyAuthentication())
+	}
+}
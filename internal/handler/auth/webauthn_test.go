@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebAuthnLoginOptionsHandler(t *testing.T) {
+	e := echo.New()
+
+	t.Run("begin error", func(t *testing.T) {
+		t.Cleanup(restore)
+		beginWebAuthnLogin = func(context.Context, cache.Cache) (string, error) { return "", errors.New("c") }
+		ctx, rec := newContext(e, "")
+		require.NoError(t, WebAuthnLoginOptionsHandler(nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		t.Cleanup(restore)
+		beginWebAuthnLogin = func(context.Context, cache.Cache) (string, error) { return "challenge", nil }
+		ctx, rec := newContext(e, "")
+		require.NoError(t, WebAuthnLoginOptionsHandler(nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), `"allowCredentials":[]`)
+		var resp api.WebAuthnLoginOptionsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, api.WebAuthnLoginOptionsResponse{
+			Challenge:        "challenge",
+			RPID:             "localhost",
+			Timeout:          300000,
+			UserVerification: "required",
+			AllowCredentials: []api.WebAuthnCredentialDescriptor{},
+		}, resp)
+	})
+}
+
+func TestWebAuthnLoginHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	body := `{"id":"Y3JlZA","rawId":"Y3JlZA","type":"public-key","response":{"clientDataJSON":"cd","authenticatorData":"ad","signature":"sig","userHandle":"MQ"}}`
+
+	t.Run("bind error", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newContext(e, "{bad json")
+		require.NoError(t, WebAuthnLoginHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("validate error", func(t *testing.T) {
+		t.Cleanup(restore)
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newContext(e, body)
+		require.NoError(t, WebAuthnLoginHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"invalid challenge", service.ErrInvalidWebAuthnChallenge, http.StatusUnauthorized},
+		{"invalid response", service.ErrInvalidWebAuthnResponse, http.StatusUnauthorized},
+		{"finish error", errors.New("db"), http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(restore)
+			finishWebAuthnLogin = func(context.Context, database.DB, cache.Cache, service.WebAuthnAssertionResponse) (int, error) {
+				return 0, tc.err
+			}
+			ctx, rec := newContext(e, body)
+			require.NoError(t, WebAuthnLoginHandler(nil, nil)(ctx))
+			require.Equal(t, tc.code, rec.Code)
+		})
+	}
+
+	t.Run("user lookup fail", func(t *testing.T) {
+		t.Cleanup(restore)
+		finishWebAuthnLogin = func(context.Context, database.DB, cache.Cache, service.WebAuthnAssertionResponse) (int, error) {
+			return 1, nil
+		}
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return nil, errors.New("g") }
+		ctx, rec := newContext(e, body)
+		require.NoError(t, WebAuthnLoginHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		t.Cleanup(restore)
+		t.Setenv("JWT_SECRET", "secret")
+		var got service.WebAuthnAssertionResponse
+		finishWebAuthnLogin = func(_ context.Context, _ database.DB, _ cache.Cache, resp service.WebAuthnAssertionResponse) (int, error) {
+			got = resp
+			return 5, nil
+		}
+		getUserByID = func(_ context.Context, _ database.DB, id int) (*model.User, error) {
+			return &model.User{ID: id, Name: "alice"}, nil
+		}
+		ctx, rec := newContext(e, body)
+		require.NoError(t, WebAuthnLoginHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, service.WebAuthnAssertionResponse{
+			ID: "Y3JlZA", ClientDataJSON: "cd", AuthenticatorData: "ad", Signature: "sig", UserHandle: "MQ",
+		}, got)
+
+		var resp api.LoginResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		claims, err := service.VerifyAccessToken(context.Background(), cache.NewMemoryCache(nil), resp.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, 5, claims.UserID)
+		require.Equal(t, service.PasskeyAuthentication(), claims.Authentication)
+	})
+}
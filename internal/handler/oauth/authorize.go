@@ -0,0 +1,252 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	authorizationCodeTTL = 10 * time.Minute
+	consentRequestTTL    = 10 * time.Minute
+)
+
+// openIDAvailable 回傳能否簽發 id_token，測試可覆寫
+var openIDAvailable = service.OpenIDAvailable
+
+// @Summary     OAuth2 authorization endpoint
+// @Description 已登入使用者為 client 核發授權碼（authorization_code grant；OAuth client 取得的 token 回傳 403），支援 PKCE (S256/plain，public client 必須使用)，scope 含 openid 時兌換後另發 id_token（須已設定非對稱簽章金鑰，否則回傳 invalid_scope），成功後導回 redirect_uri。client 可先經由 /oauth/par 推送授權參數再以 request_uri 引用，要求 PAR 的 client 只接受此方式。非第一方 client 需經使用者同意：先前的同意未涵蓋本次 scope 時回傳 200 與 consent_challenge，由使用者於 /oauth/authorize/consent 同意或拒絕後導回
+// @Tags        oauth
+// @Produce     json
+// @Param       response_type         query string false "必須為 code（未帶 request_uri 時必填）"
+// @Param       client_id             query string true  "Client ID"
+// @Param       redirect_uri          query string false "導回網址，需與 client 註冊值完全相符（僅註冊一個時可省略）"
+// @Param       scope                 query string false "以空白分隔的 scope，未指定時為 client 登記的全部 scope"
+// @Param       state                 query string false "原樣帶回的 state"
+// @Param       code_challenge        query string false "PKCE code_challenge"
+// @Param       code_challenge_method query string false "PKCE 方法：S256 或 plain（預設 plain）"
+// @Param       nonce                 query string false "OpenID Connect nonce，原樣放入 id_token"
+// @Param       request_uri           query string false "PAR 端點回傳的 request_uri；帶入時僅需另帶 client_id，其餘參數以推送的內容為準"
+// @Success     200 {object} api.ConsentRequiredResponse
+// @Success     302
+// @Failure     400 {object} api.ErrorResponse
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     403 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Password
+// @Router      /oauth/authorize [get]
+func AuthorizeHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		// 只接受使用者直接登入的 token，避免第三方 client 以取得的 token 代替使用者授權其他 client
+		claims, err := firstPartyUser(c)
+		if err != nil {
+			return firstPartyUserError(c, err)
+		}
+
+		var req api.AuthorizeRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid request"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		// client 與 redirect_uri 未驗證前，錯誤不可導回
+		oc, err := store.GetOAuthClientByClientID(ctx, db, req.ClientID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid client_id"})
+		}
+		// 經由 PAR 推送的請求只採用推送時的參數（RFC 9126 §4）
+		if req.RequestURI != "" {
+			pushed, err := service.ConsumePushedAuthorizationRequest(ctx, cache, oc.ClientID, req.RequestURI)
+			if errors.Is(err, service.ErrRequestURINotFound) {
+				return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid request_uri"})
+			}
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to load pushed authorization request"})
+			}
+			req = api.AuthorizeRequest{
+				ResponseType:        pushed.ResponseType,
+				ClientID:            pushed.ClientID,
+				RedirectURI:         pushed.RedirectURI,
+				Scope:               pushed.Scope,
+				State:               pushed.State,
+				CodeChallenge:       pushed.CodeChallenge,
+				CodeChallengeMethod: pushed.CodeChallengeMethod,
+				Nonce:               pushed.Nonce,
+			}
+		} else if oc.RequirePushedAuthorizationRequests {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "pushed authorization request required"})
+		}
+		redirectURI, ok := resolveRedirectURI(oc.RedirectURIs, req.RedirectURI)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid redirect_uri"})
+		}
+
+		scope, aerr := validateAuthorizationRequest(oc, req)
+		if aerr != nil {
+			return redirectWithParams(c, redirectURI, aerr.params(), req.State)
+		}
+
+		// auth_time 為使用者登入時間，即登入 token 的簽發時間
+		authTime := time.Now()
+		if claims.IssuedAt != nil {
+			authTime = claims.IssuedAt.Time
+		}
+		data := service.AuthorizationCodeData{
+			UserID:              claims.UserID,
+			ClientID:            oc.ClientID,
+			RedirectURI:         req.RedirectURI,
+			Scope:               scope,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: req.CodeChallengeMethod,
+			Nonce:               req.Nonce,
+			AuthTime:            authTime.Unix(),
+			Authentication:      claims.Authentication,
+		}
+
+		// 第一方 client 不需同意；其他 client 須有涵蓋本次 scope 的同意，否則交由使用者決定
+		if !oc.FirstParty {
+			grant, err := store.GetOAuthGrant(ctx, db, claims.UserID, oc.ClientID)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return redirectWithParams(c, redirectURI, url.Values{"error": {"server_error"}}, req.State)
+			}
+			if !service.GrantCoversScope(grant, scope) {
+				return requireConsent(c, cache, oc, data, redirectURI, req.State)
+			}
+		}
+		return redirectWithCode(c, cache, data, redirectURI, req.State)
+	}
+}
+
+// requireConsent 保存授權請求並回傳 consent challenge，供前端顯示同意畫面後呼叫 /oauth/authorize/consent
+func requireConsent(c echo.Context, cache cache.Cache, oc *model.OAuthClient, data service.AuthorizationCodeData, redirectURI, state string) error {
+	challenge, err := service.IssueConsentRequest(c.Request().Context(), cache, service.ConsentRequestData{
+		UserID:               data.UserID,
+		ClientID:             data.ClientID,
+		RedirectURI:          redirectURI,
+		RequestedRedirectURI: data.RedirectURI,
+		Scope:                data.Scope,
+		State:                state,
+		CodeChallenge:        data.CodeChallenge,
+		CodeChallengeMethod:  data.CodeChallengeMethod,
+		Nonce:                data.Nonce,
+		AuthTime:             data.AuthTime,
+		Authentication:       data.Authentication,
+	}, consentRequestTTL)
+	if err != nil {
+		return redirectWithParams(c, redirectURI, url.Values{"error": {"server_error"}}, state)
+	}
+	return c.JSON(http.StatusOK, api.ConsentRequiredResponse{
+		ConsentChallenge: challenge,
+		ClientID:         oc.ClientID,
+		ClientName:       oc.ClientName,
+		LogoURI:          oc.LogoURI,
+		Scope:            data.Scope,
+		ExpiresIn:        int(consentRequestTTL.Seconds()),
+	})
+}
+
+// redirectWithCode 核發授權碼並導回 redirect_uri
+func redirectWithCode(c echo.Context, cache cache.Cache, data service.AuthorizationCodeData, redirectURI, state string) error {
+	code, err := service.IssueAuthorizationCode(c.Request().Context(), cache, data, authorizationCodeTTL)
+	if err != nil {
+		return redirectWithParams(c, redirectURI, url.Values{"error": {"server_error"}}, state)
+	}
+	return redirectWithParams(c, redirectURI, url.Values{"code": {code}}, state)
+}
+
+// authorizationError 為 RFC 6749 §4.1.2.1 的錯誤；authorize 端點以導回傳遞，PAR 端點直接回傳
+type authorizationError struct {
+	code        string
+	description string
+}
+
+func (e *authorizationError) params() url.Values {
+	params := url.Values{"error": {e.code}}
+	if e.description != "" {
+		params.Set("error_description", e.description)
+	}
+	return params
+}
+
+// validateAuthorizationRequest 檢查 response_type、grant type、PKCE 與 scope，成功時回傳實際授予的 scope
+func validateAuthorizationRequest(oc *model.OAuthClient, req api.AuthorizeRequest) (string, *authorizationError) {
+	if req.ResponseType != "code" {
+		return "", &authorizationError{code: errCodeUnsupportedResponseType}
+	}
+	if !hasGrantType(oc.GrantTypes, "authorization_code") {
+		return "", &authorizationError{code: errCodeUnauthorizedClient}
+	}
+	if req.CodeChallenge == "" && (req.CodeChallengeMethod != "" || oc.IsPublic()) {
+		return "", &authorizationError{code: errCodeInvalidRequest, description: "code_challenge required"}
+	}
+	if err := service.ValidateCodeChallengeMethod(req.CodeChallengeMethod); err != nil {
+		return "", &authorizationError{code: errCodeInvalidRequest, description: err.Error()}
+	}
+	scope, err := service.ResolveScope(req.Scope, oc.Scopes)
+	if err != nil {
+		return "", &authorizationError{code: errCodeInvalidScope, description: err.Error()}
+	}
+	// 兌換授權碼時才會發現無法簽發 id_token，因此在授權前先拒絕
+	if service.HasScope(scope, service.ScopeOpenID) && !openIDAvailable() {
+		return "", &authorizationError{code: errCodeInvalidScope, description: service.ErrIDTokenUnavailable.Error()}
+	}
+	return scope, nil
+}
+
+// resolveRedirectURI 以完全比對的方式確認 redirect_uri；未帶值時僅允許唯一註冊的網址
+func resolveRedirectURI(registered []string, requested string) (string, bool) {
+	if requested == "" {
+		if len(registered) == 1 {
+			return registered[0], true
+		}
+		return "", false
+	}
+	for _, uri := range registered {
+		if uri == requested {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+// redirectWithParams 將參數附加到 redirect_uri 既有的 query 後導回
+func redirectWithParams(c echo.Context, redirectURI string, params url.Values, state string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid redirect_uri"})
+	}
+	q := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			q.Add(k, v)
+		}
+	}
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	return c.Redirect(http.StatusFound, u.String())
+}
+
+func hasGrantType(grantTypes []string, grantType string) bool {
+	for _, gt := range grantTypes {
+		if gt == grantType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,355 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+type stubValidator struct{ err error }
+
+func (s *stubValidator) Validate(i interface{}) error { return s.err }
+
+func newAuthorizeCtx(e *echo.Echo, query string, claims *service.CustomClaims) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query, nil)
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(req, rec)
+	if claims != nil {
+		ctx.Set(middleware.ContextUserKey, claims)
+	}
+	return ctx, rec
+}
+
+func TestAuthorizeHandler(t *testing.T) {
+	openIDAvailable = func() bool { return true }
+	t.Cleanup(func() { openIDAvailable = service.OpenIDAvailable })
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	now := time.Now()
+	claims := &service.CustomClaims{UserID: 1}
+	client := &model.OAuthClient{
+		ClientID:     "cid",
+		ClientSecret: "sec",
+		UserID:       1,
+		GrantTypes:   []string{"authorization_code"},
+		RedirectURIs: []string{"https://app.example.com/cb?x=1"},
+		Scopes:       []string{"openid", "users:read"},
+		FirstParty:   true,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	clientDB := func(oc *model.OAuthClient) *database.FakeDB {
+		return &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeClientRow{client: oc}
+		}}
+	}
+	okCache := func(stored *[]byte) *cache.FakeCache {
+		return &cache.FakeCache{SetFn: func(_ context.Context, _ string, v any, _ time.Duration) *redis.StatusCmd {
+			if stored != nil {
+				*stored = v.([]byte)
+			}
+			return redis.NewStatusResult("OK", nil)
+		}}
+	}
+	location := func(t *testing.T, rec *httptest.ResponseRecorder) url.Values {
+		require.Equal(t, http.StatusFound, rec.Code)
+		u, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+		require.NoError(t, err)
+		require.Equal(t, "app.example.com", u.Host)
+		return u.Query()
+	}
+
+	t.Run("no claims", func(t *testing.T) {
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid", nil)
+		require.NoError(t, AuthorizeHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("third-party token", func(t *testing.T) {
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid", &service.CustomClaims{UserID: 1, ClientID: "other"})
+		require.NoError(t, AuthorizeHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("bind error", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/oauth/authorize", nil)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Body = http.NoBody
+		req.ContentLength = 1
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		ctx.Set(middleware.ContextUserKey, claims)
+		require.NoError(t, AuthorizeHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("validate error", func(t *testing.T) {
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newAuthorizeCtx(e, "", claims)
+		require.NoError(t, AuthorizeHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("unknown client", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeClientRow{err: errors.New("no")}
+		}}
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=x", claims)
+		require.NoError(t, AuthorizeHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), "invalid client_id")
+	})
+
+	t.Run("redirect uri mismatch", func(t *testing.T) {
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid&redirect_uri="+url.QueryEscape("https://evil.example.com/cb"), claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), "invalid redirect_uri")
+	})
+
+	t.Run("redirect uri ambiguous", func(t *testing.T) {
+		oc := *client
+		oc.RedirectURIs = []string{"https://a/cb", "https://b/cb"}
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(&oc), nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("unparsable registered uri", func(t *testing.T) {
+		oc := *client
+		oc.RedirectURIs = []string{"://bad"}
+		ctx, rec := newAuthorizeCtx(e, "response_type=token&client_id=cid", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(&oc), nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("unsupported response type", func(t *testing.T) {
+		ctx, rec := newAuthorizeCtx(e, "response_type=token&client_id=cid&state=s1", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), nil)(ctx))
+		q := location(t, rec)
+		require.Equal(t, "unsupported_response_type", q.Get("error"))
+		require.Equal(t, "s1", q.Get("state"))
+		require.Equal(t, "1", q.Get("x"))
+	})
+
+	t.Run("grant not allowed", func(t *testing.T) {
+		oc := *client
+		oc.GrantTypes = []string{"password"}
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(&oc), nil)(ctx))
+		require.Equal(t, "unauthorized_client", location(t, rec).Get("error"))
+	})
+
+	t.Run("method without challenge", func(t *testing.T) {
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid&code_challenge_method=S256", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), nil)(ctx))
+		require.Equal(t, "invalid_request", location(t, rec).Get("error"))
+	})
+
+	t.Run("public client without challenge", func(t *testing.T) {
+		oc := *client
+		oc.ClientType = model.ClientTypePublic
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(&oc), nil)(ctx))
+		require.Equal(t, "invalid_request", location(t, rec).Get("error"))
+	})
+
+	t.Run("unsupported challenge method", func(t *testing.T) {
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid&code_challenge=abc&code_challenge_method=S512", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), nil)(ctx))
+		require.Equal(t, "invalid_request", location(t, rec).Get("error"))
+	})
+
+	t.Run("invalid scope", func(t *testing.T) {
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid&state=st&scope=users:write", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), nil)(ctx))
+		q := location(t, rec)
+		require.Equal(t, "invalid_scope", q.Get("error"))
+		require.Equal(t, "st", q.Get("state"))
+	})
+
+	t.Run("openid without signing key", func(t *testing.T) {
+		openIDAvailable = func() bool { return false }
+		t.Cleanup(func() { openIDAvailable = func() bool { return true } })
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid&state=st&scope=openid", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), nil)(ctx))
+		q := location(t, rec)
+		require.Equal(t, "invalid_scope", q.Get("error"))
+		require.Equal(t, service.ErrIDTokenUnavailable.Error(), q.Get("error_description"))
+	})
+
+	t.Run("store code fail", func(t *testing.T) {
+		cch := &cache.FakeCache{SetFn: func(context.Context, string, any, time.Duration) *redis.StatusCmd {
+			return redis.NewStatusResult("", errors.New("set"))
+		}}
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), cch)(ctx))
+		require.Equal(t, "server_error", location(t, rec).Get("error"))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		var stored []byte
+		query := "response_type=code&client_id=cid&state=st&scope=users:read" +
+			"&redirect_uri=" + url.QueryEscape(client.RedirectURIs[0]) +
+			"&code_challenge=abc&code_challenge_method=S256&nonce=n1"
+		loggedIn := &service.CustomClaims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(time.Unix(1700000000, 0))}}
+		ctx, rec := newAuthorizeCtx(e, query, loggedIn)
+		require.NoError(t, AuthorizeHandler(clientDB(client), okCache(&stored))(ctx))
+		q := location(t, rec)
+		require.NotEmpty(t, q.Get("code"))
+		require.Equal(t, "st", q.Get("state"))
+
+		var data service.AuthorizationCodeData
+		require.NoError(t, json.Unmarshal(stored, &data))
+		require.Equal(t, service.AuthorizationCodeData{
+			UserID:              1,
+			ClientID:            "cid",
+			RedirectURI:         client.RedirectURIs[0],
+			Scope:               "users:read",
+			CodeChallenge:       "abc",
+			CodeChallengeMethod: "S256",
+			Nonce:               "n1",
+			AuthTime:            1700000000,
+		}, data)
+	})
+
+	t.Run("pushed authorization request", func(t *testing.T) {
+		pushed := `{"client_id":"cid","response_type":"code","redirect_uri":"https://app.example.com/cb?x=1","scope":"users:read","state":"pushed","nonce":"n1"}`
+		cch := cache.NewMemoryCache(map[string]string{"pushed_authorization_request:abc": pushed})
+		// query 中推送內容以外的參數一律忽略
+		query := "client_id=cid&state=ignored&scope=openid&request_uri=" + url.QueryEscape(service.RequestURIPrefix+"abc")
+		ctx, rec := newAuthorizeCtx(e, query, claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), cch)(ctx))
+		q := location(t, rec)
+		require.Equal(t, "pushed", q.Get("state"))
+
+		var data service.AuthorizationCodeData
+		require.NoError(t, json.Unmarshal([]byte(cch.Data["authorization_code:"+q.Get("code")]), &data))
+		require.Equal(t, "users:read", data.Scope)
+		require.Equal(t, "n1", data.Nonce)
+		require.Equal(t, client.RedirectURIs[0], data.RedirectURI)
+		require.NotContains(t, cch.Data, "pushed_authorization_request:abc")
+
+		// request_uri 只能使用一次
+		ctx, rec = newAuthorizeCtx(e, query, claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), cch)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), "invalid request_uri")
+	})
+
+	t.Run("pushed authorization request load fail", func(t *testing.T) {
+		cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+			return redis.NewStringResult("", errors.New("get"))
+		}}
+		ctx, rec := newAuthorizeCtx(e, "client_id=cid&request_uri="+url.QueryEscape(service.RequestURIPrefix+"abc"), claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), cch)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("pushed authorization request required", func(t *testing.T) {
+		oc := *client
+		oc.RequirePushedAuthorizationRequests = true
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(&oc), okCache(nil))(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), "pushed authorization request required")
+	})
+
+	t.Run("auth time defaults to now", func(t *testing.T) {
+		var stored []byte
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid", claims)
+		require.NoError(t, AuthorizeHandler(clientDB(client), okCache(&stored))(ctx))
+		require.NotEmpty(t, location(t, rec).Get("code"))
+		var data service.AuthorizationCodeData
+		require.NoError(t, json.Unmarshal(stored, &data))
+		require.InDelta(t, time.Now().Unix(), data.AuthTime, 5)
+		require.Equal(t, "openid users:read", data.Scope)
+	})
+
+	thirdParty := *client
+	thirdParty.FirstParty = false
+	thirdParty.ClientName = "Third Party"
+	grantDB := func(grant *fakeGrantRow) *database.FakeDB {
+		return &database.FakeDB{QueryRowFn: func(_ context.Context, sql string, _ ...any) pgx.Row {
+			if strings.Contains(sql, "oauth_grants") {
+				return grant
+			}
+			return &fakeClientRow{client: &thirdParty}
+		}}
+	}
+
+	t.Run("consent required without grant", func(t *testing.T) {
+		cch := cache.NewMemoryCache(nil)
+		query := "response_type=code&client_id=cid&state=st&scope=users:read&nonce=n1"
+		ctx, rec := newAuthorizeCtx(e, query, claims)
+		require.NoError(t, AuthorizeHandler(grantDB(&fakeGrantRow{err: pgx.ErrNoRows}), cch)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.ConsentRequiredResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.NotEmpty(t, resp.ConsentChallenge)
+		require.Equal(t, "Third Party", resp.ClientName)
+		require.Equal(t, "users:read", resp.Scope)
+		require.Equal(t, int(consentRequestTTL.Seconds()), resp.ExpiresIn)
+
+		var data service.ConsentRequestData
+		require.NoError(t, json.Unmarshal([]byte(cch.Data["consent_request:"+resp.ConsentChallenge]), &data))
+		require.Equal(t, 1, data.UserID)
+		require.Equal(t, client.RedirectURIs[0], data.RedirectURI)
+		require.Empty(t, data.RequestedRedirectURI)
+		require.Equal(t, "st", data.State)
+		require.Equal(t, "n1", data.Nonce)
+		for k := range cch.Data {
+			require.NotContains(t, k, "authorization_code:")
+		}
+	})
+
+	t.Run("consent required for new scope", func(t *testing.T) {
+		grant := &fakeGrantRow{grant: &model.OAuthGrant{UserID: 1, ClientID: "cid", Scopes: []string{"users:read"}}}
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid&scope=openid+users:read", claims)
+		require.NoError(t, AuthorizeHandler(grantDB(grant), cache.NewMemoryCache(nil))(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), "consent_challenge")
+	})
+
+	t.Run("prior grant skips consent", func(t *testing.T) {
+		grant := &fakeGrantRow{grant: &model.OAuthGrant{UserID: 1, ClientID: "cid", Scopes: []string{"openid", "users:read"}}}
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid&scope=users:read", claims)
+		require.NoError(t, AuthorizeHandler(grantDB(grant), cache.NewMemoryCache(nil))(ctx))
+		require.NotEmpty(t, location(t, rec).Get("code"))
+	})
+
+	t.Run("grant lookup fail", func(t *testing.T) {
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid", claims)
+		require.NoError(t, AuthorizeHandler(grantDB(&fakeGrantRow{err: errors.New("db")}), cache.NewMemoryCache(nil))(ctx))
+		require.Equal(t, "server_error", location(t, rec).Get("error"))
+	})
+
+	t.Run("store consent request fail", func(t *testing.T) {
+		cch := &cache.FakeCache{SetFn: func(context.Context, string, any, time.Duration) *redis.StatusCmd {
+			return redis.NewStatusResult("", errors.New("set"))
+		}}
+		ctx, rec := newAuthorizeCtx(e, "response_type=code&client_id=cid", claims)
+		require.NoError(t, AuthorizeHandler(grantDB(&fakeGrantRow{err: pgx.ErrNoRows}), cch)(ctx))
+		require.Equal(t, "server_error", location(t, rec).Get("error"))
+	})
+}
@@ -0,0 +1,164 @@
+package oauth
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errMissingClientAuth      = errors.New("client authentication required")
+	errMultipleClientAuth     = errors.New("only one client authentication method may be used")
+	errInvalidAuthHeader      = errors.New("invalid authorization header")
+	errInvalidClient          = errors.New("invalid client credentials")
+	errInvalidClientAssertion = errors.New("invalid client assertion")
+)
+
+// supportedClientAuthMethods 為 token endpoint 支援的 client 認證方式，亦公開於 discovery 文件
+var supportedClientAuthMethods = []string{
+	model.ClientAuthMethodSecretBasic,
+	model.ClientAuthMethodSecretPost,
+	model.ClientAuthMethodSecretJWT,
+	model.ClientAuthMethodPrivateKey,
+	model.ClientAuthMethodNone,
+	model.ClientAuthMethodTLS,
+	model.ClientAuthMethodSelfSignedTLS,
+}
+
+// authenticateClient 依請求判斷 client 使用的認證方式：HTTP Basic、表單中的 client_secret、
+// RFC 7523 client assertion，或僅帶 client_id 的 public client 與 RFC 8705 mTLS client；
+// 認證方式必須與 client 登記的一致
+func authenticateClient(c echo.Context, db database.DB, cache cache.Cache) (*model.OAuthClient, error) {
+	auth := c.Request().Header.Get("Authorization")
+	clientID := c.FormValue("client_id")
+	secret := c.FormValue("client_secret")
+	assertionType := c.FormValue("client_assertion_type")
+	assertion := c.FormValue("client_assertion")
+
+	// RFC 6749 §2.3：每個請求只能使用一種認證方式
+	used := 0
+	for _, present := range []bool{auth != "", secret != "", assertionType != "" || assertion != ""} {
+		if present {
+			used++
+		}
+	}
+	if used > 1 {
+		return nil, errMultipleClientAuth
+	}
+
+	var method string
+	switch {
+	case auth != "":
+		id, basicSecret, err := parseBasicAuth(auth)
+		if err != nil {
+			return nil, err
+		}
+		if clientID != "" && clientID != id {
+			return nil, errInvalidClient
+		}
+		method, clientID, secret = model.ClientAuthMethodSecretBasic, id, basicSecret
+	case secret != "":
+		method = model.ClientAuthMethodSecretPost
+	case assertionType != "" || assertion != "":
+		if assertionType != service.ClientAssertionTypeJWTBearer {
+			return nil, errInvalidClientAssertion
+		}
+		iss, err := service.ClientAssertionIssuer(assertion)
+		if err != nil || (clientID != "" && clientID != iss) {
+			return nil, errInvalidClientAssertion
+		}
+		// private_key_jwt 或 client_secret_jwt 由 client 登記的方式決定
+		clientID = iss
+	case clientID != "":
+		// public client 或以 TLS client 憑證認證，由 client 登記的方式決定
+		method = model.ClientAuthMethodNone
+	default:
+		return nil, errMissingClientAuth
+	}
+
+	ctx := c.Request().Context()
+	oc, err := store.GetOAuthClientByClientID(ctx, db, clientID)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+	switch method {
+	case model.ClientAuthMethodSecretBasic, model.ClientAuthMethodSecretPost:
+		if oc.TokenEndpointAuthMethod != method || !service.VerifyClientSecret(oc, secret) {
+			return nil, errInvalidClient
+		}
+	case model.ClientAuthMethodNone:
+		switch oc.TokenEndpointAuthMethod {
+		case model.ClientAuthMethodNone:
+		case model.ClientAuthMethodTLS, model.ClientAuthMethodSelfSignedTLS:
+			if err := service.VerifyClientCertificate(oc, peerCertificates(c)); err != nil {
+				return nil, errInvalidClient
+			}
+		default:
+			return nil, errInvalidClient
+		}
+	default:
+		if err := service.VerifyClientAssertion(ctx, cache, oc, assertion, assertionAudiences(c)); err != nil {
+			if errors.Is(err, service.ErrInvalidClientAssertion) {
+				return nil, errInvalidClientAssertion
+			}
+			return nil, err
+		}
+	}
+	return oc, nil
+}
+
+// parseBasicAuth 解析 Authorization: Basic 標頭中的 client_id 與 client_secret
+func parseBasicAuth(auth string) (clientID, secret string, err error) {
+	const prefix = "Basic "
+	if !strings.HasPrefix(auth, prefix) {
+		return "", "", errInvalidAuthHeader
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", "", errInvalidAuthHeader
+	}
+	clientID, secret, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", errInvalidAuthHeader
+	}
+	return clientID, secret, nil
+}
+
+// peerCertificates 回傳 client 於 TLS 握手出示的憑證鏈；非 TLS 連線或未出示憑證時為空
+func peerCertificates(c echo.Context) []*x509.Certificate {
+	if state := c.Request().TLS; state != nil {
+		return state.PeerCertificates
+	}
+	return nil
+}
+
+// assertionAudiences 為 client assertion 可接受的 aud：issuer、token endpoint 或目前請求的 endpoint；
+// 未設定 OAUTH_ISSUER 時不接受任何 aud
+func assertionAudiences(c echo.Context) []string {
+	issuer := issuerURL()
+	if issuer == "" {
+		return nil
+	}
+	return []string{issuer, issuer + "/api/oauth/token", issuer + c.Request().URL.Path}
+}
+
+// clientAuthError 依 RFC 6749 §5.2 將 authenticateClient 的錯誤轉為 OAuth 錯誤回應
+func clientAuthError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errMultipleClientAuth):
+		return oauthError(c, errCodeInvalidRequest, err.Error())
+	case errors.Is(err, errMissingClientAuth), errors.Is(err, errInvalidAuthHeader),
+		errors.Is(err, errInvalidClient), errors.Is(err, errInvalidClientAssertion):
+		return oauthError(c, errCodeInvalidClient, err.Error())
+	}
+	return oauthError(c, errCodeServerError, "failed to authenticate client")
+}
@@ -0,0 +1,230 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// newClientAssertionKey 產生 private_key_jwt 用的 ES256 金鑰與對應的 JWK Set
+func newClientAssertionKey(t *testing.T) (*ecdsa.PrivateKey, json.RawMessage) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	b64 := func(n interface{ FillBytes([]byte) []byte }) string {
+		return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32)))
+	}
+	jwks, err := json.Marshal(service.JSONWebKeySet{Keys: []service.JSONWebKey{{
+		KeyType: "EC", KeyID: "k1", Use: "sig", Algorithm: "ES256", Curve: "P-256", X: b64(key.X), Y: b64(key.Y),
+	}}})
+	require.NoError(t, err)
+	return key, jwks
+}
+
+// signClientAssertion 簽發 RFC 7523 client assertion，aud 為測試請求的 token endpoint
+func signClientAssertion(t *testing.T, key *ecdsa.PrivateKey, clientID, jti string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    clientID,
+		Subject:   clientID,
+		Audience:  jwt.ClaimStrings{"http://example.com/api/oauth/token"},
+		ID:        jti,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	token.Header["kid"] = "k1"
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+// newClientCertificate 產生 self_signed_tls_client_auth 用的自簽 client 憑證
+func newClientCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "svc"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	t.Setenv("OAUTH_ISSUER", "http://example.com")
+	e := echo.New()
+	key, jwks := newClientAssertionKey(t)
+	cert := newClientCertificate(t)
+	clients := map[string]*model.OAuthClient{
+		"cid":    {ClientID: "cid", ClientSecret: "sec"},
+		"post":   {ClientID: "post", ClientSecret: "sec", TokenEndpointAuthMethod: model.ClientAuthMethodSecretPost},
+		"public": {ClientID: "public", ClientType: model.ClientTypePublic, TokenEndpointAuthMethod: model.ClientAuthMethodNone},
+		"jwt":    {ClientID: "jwt", TokenEndpointAuthMethod: model.ClientAuthMethodPrivateKey, JWKS: jwks},
+		"mtls":   {ClientID: "mtls", TokenEndpointAuthMethod: model.ClientAuthMethodSelfSignedTLS, TLSClientCertificateThumbprint: service.CertificateThumbprint(cert)},
+	}
+	db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+		c, ok := clients[args[0].(string)]
+		if !ok {
+			return &fakeClientRow{err: errors.New("no rows")}
+		}
+		return &fakeClientRow{client: c}
+	}}
+	basic := func(s string) string { return "Basic " + base64.StdEncoding.EncodeToString([]byte(s)) }
+	newReq := func(form url.Values, auth string) echo.Context {
+		req := httptest.NewRequest(http.MethodPost, "/api/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return e.NewContext(req, httptest.NewRecorder())
+	}
+	assertionForm := func(clientID, assertion string) url.Values {
+		return url.Values{"client_id": {clientID}, "client_assertion_type": {service.ClientAssertionTypeJWTBearer}, "client_assertion": {assertion}}
+	}
+
+	cases := []struct {
+		name string
+		form url.Values
+		auth string
+		err  error
+	}{
+		{"missing", nil, "", errMissingClientAuth},
+		{"not basic", nil, "Bearer x", errInvalidAuthHeader},
+		{"not base64", nil, "Basic !!!", errInvalidAuthHeader},
+		{"no colon", nil, basic("cid"), errInvalidAuthHeader},
+		{"unknown client", nil, basic("x:sec"), errInvalidClient},
+		{"wrong secret", nil, basic("cid:bad"), errInvalidClient},
+		{"basic client_id mismatch", url.Values{"client_id": {"post"}}, basic("cid:sec"), errInvalidClient},
+		{"basic for post client", nil, basic("post:sec"), errInvalidClient},
+		{"post for basic client", url.Values{"client_id": {"cid"}, "client_secret": {"sec"}}, "", errInvalidClient},
+		{"post wrong secret", url.Values{"client_id": {"post"}, "client_secret": {"bad"}}, "", errInvalidClient},
+		{"none for confidential client", url.Values{"client_id": {"cid"}}, "", errInvalidClient},
+		{"basic and post", url.Values{"client_secret": {"sec"}}, basic("cid:sec"), errMultipleClientAuth},
+		{"post and assertion", url.Values{"client_secret": {"sec"}, "client_assertion": {"x"}}, "", errMultipleClientAuth},
+		{"assertion wrong type", url.Values{"client_assertion_type": {"saml"}, "client_assertion": {"x"}}, "", errInvalidClientAssertion},
+		{"assertion malformed", assertionForm("", "x"), "", errInvalidClientAssertion},
+		{"assertion client_id mismatch", assertionForm("cid", signClientAssertion(t, key, "jwt", "j0")), "", errInvalidClientAssertion},
+		{"assertion unknown client", assertionForm("", signClientAssertion(t, key, "nobody", "j0")), "", errInvalidClient},
+		{"assertion for secret client", assertionForm("", signClientAssertion(t, key, "cid", "j0")), "", errInvalidClientAssertion},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := authenticateClient(newReq(tc.form, tc.auth), db, cache.NewMemoryCache(nil))
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	t.Run("client_secret_basic", func(t *testing.T) {
+		oc, err := authenticateClient(newReq(url.Values{"client_id": {"cid"}}, basic("cid:sec")), db, nil)
+		require.NoError(t, err)
+		require.Equal(t, "cid", oc.ClientID)
+	})
+
+	t.Run("client_secret_post", func(t *testing.T) {
+		oc, err := authenticateClient(newReq(url.Values{"client_id": {"post"}, "client_secret": {"sec"}}, ""), db, nil)
+		require.NoError(t, err)
+		require.Equal(t, "post", oc.ClientID)
+	})
+
+	t.Run("none", func(t *testing.T) {
+		oc, err := authenticateClient(newReq(url.Values{"client_id": {"public"}}, ""), db, nil)
+		require.NoError(t, err)
+		require.Equal(t, "public", oc.ClientID)
+	})
+
+	t.Run("self_signed_tls_client_auth", func(t *testing.T) {
+		ctx := newReq(url.Values{"client_id": {"mtls"}}, "")
+		_, err := authenticateClient(ctx, db, nil)
+		require.ErrorIs(t, err, errInvalidClient)
+
+		ctx.Request().TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{newClientCertificate(t)}}
+		_, err = authenticateClient(ctx, db, nil)
+		require.ErrorIs(t, err, errInvalidClient)
+
+		ctx.Request().TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		oc, err := authenticateClient(ctx, db, nil)
+		require.NoError(t, err)
+		require.Equal(t, "mtls", oc.ClientID)
+	})
+
+	t.Run("private_key_jwt", func(t *testing.T) {
+		cch := cache.NewMemoryCache(nil)
+		assertion := signClientAssertion(t, key, "jwt", "j1")
+		oc, err := authenticateClient(newReq(assertionForm("", assertion), ""), db, cch)
+		require.NoError(t, err)
+		require.Equal(t, "jwt", oc.ClientID)
+		require.Contains(t, cch.Data, "client_assertion_jti:jwt:j1")
+
+		// 同一 assertion 不可重送
+		_, err = authenticateClient(newReq(assertionForm("jwt", assertion), ""), db, cch)
+		require.ErrorIs(t, err, errInvalidClientAssertion)
+	})
+
+	t.Run("private_key_jwt without issuer", func(t *testing.T) {
+		// 未設定 issuer 時不以 Host header 推得可接受的 aud
+		t.Setenv("OAUTH_ISSUER", "")
+		_, err := authenticateClient(newReq(assertionForm("", signClientAssertion(t, key, "jwt", "j3")), ""), db, cache.NewMemoryCache(nil))
+		require.ErrorIs(t, err, errInvalidClientAssertion)
+	})
+
+	t.Run("private_key_jwt cache error", func(t *testing.T) {
+		cch := cache.NewMemoryCache(nil)
+		cch.FailOn["setnx"] = "client_assertion_jti:"
+		_, err := authenticateClient(newReq(assertionForm("", signClientAssertion(t, key, "jwt", "j2")), ""), db, cch)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, errInvalidClientAssertion)
+	})
+}
+
+func TestClientAuthError(t *testing.T) {
+	e := echo.New()
+	newCtx := func() (echo.Context, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		return e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec), rec
+	}
+
+	for _, err := range []error{errMissingClientAuth, errInvalidAuthHeader, errInvalidClient, errInvalidClientAssertion} {
+		ctx, rec := newCtx()
+		require.NoError(t, clientAuthError(ctx, err))
+		resp := requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
+		require.Equal(t, err.Error(), resp.ErrorDescription)
+	}
+
+	ctx, rec := newCtx()
+	require.NoError(t, clientAuthError(ctx, errMultipleClientAuth))
+	requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidRequest)
+
+	ctx, rec = newCtx()
+	require.NoError(t, clientAuthError(ctx, errors.New("cache down")))
+	resp := requireOAuthError(t, rec, http.StatusInternalServerError, errCodeServerError)
+	require.NotContains(t, resp.ErrorDescription, "cache down")
+}
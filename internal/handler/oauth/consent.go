@@ -0,0 +1,88 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// @Summary     同意或拒絕 OAuth 授權
+// @Description 使用者對 /oauth/authorize 回傳的 consent_challenge 同意（action=approve）或拒絕（action=deny）；同意時保存授權並核發授權碼導回 redirect_uri，之後相同或較少的 scope 不再詢問；拒絕時以 error=access_denied 導回。每個 consent_challenge 僅能決定一次
+// @Tags        oauth
+// @Accept      json
+// @Produce     json
+// @Param       body body api.ConsentRequest true "consent challenge 與動作"
+// @Success     302
+// @Failure     400 {object} api.ErrorResponse
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     403 {object} api.ErrorResponse
+// @Failure     404 {object} api.ErrorResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Router      /oauth/authorize/consent [post]
+func ConsentHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := firstPartyUser(c)
+		if err != nil {
+			return firstPartyUserError(c, err)
+		}
+		var req api.ConsentRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid request"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		ctx := c.Request().Context()
+		consent, err := service.ConsumeConsentRequest(ctx, cache, claims.UserID, req.ConsentChallenge)
+		if errors.Is(err, service.ErrConsentRequestNotFound) {
+			return c.JSON(http.StatusNotFound, api.ErrorResponse{Message: err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to load consent request"})
+		}
+		if req.Action == "deny" {
+			return redirectWithParams(c, consent.RedirectURI, url.Values{"error": {errCodeAccessDenied}}, consent.State)
+		}
+
+		// 合併先前同意的 scope，使同意只會擴大不會縮小
+		grant, err := store.GetOAuthGrant(ctx, db, consent.UserID, consent.ClientID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return redirectWithParams(c, consent.RedirectURI, url.Values{"error": {"server_error"}}, consent.State)
+		}
+		var granted []string
+		if grant != nil {
+			granted = grant.Scopes
+		}
+		if err := store.SaveOAuthGrant(ctx, db, &model.OAuthGrant{
+			UserID:   consent.UserID,
+			ClientID: consent.ClientID,
+			Scopes:   service.MergeGrantScopes(granted, consent.Scope),
+		}); err != nil {
+			return redirectWithParams(c, consent.RedirectURI, url.Values{"error": {"server_error"}}, consent.State)
+		}
+
+		return redirectWithCode(c, cache, service.AuthorizationCodeData{
+			UserID:              consent.UserID,
+			ClientID:            consent.ClientID,
+			RedirectURI:         consent.RequestedRedirectURI,
+			Scope:               consent.Scope,
+			CodeChallenge:       consent.CodeChallenge,
+			CodeChallengeMethod: consent.CodeChallengeMethod,
+			Nonce:               consent.Nonce,
+			AuthTime:            consent.AuthTime,
+			Authentication:      consent.Authentication,
+		}, consent.RedirectURI, consent.State)
+	}
+}
@@ -0,0 +1,189 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGrantRow 模擬 GetOAuthGrant（4 欄）與 SaveOAuthGrant（RETURNING granted_at）的掃描
+type fakeGrantRow struct {
+	grant *model.OAuthGrant
+	err   error
+}
+
+func (r *fakeGrantRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	switch len(dest) {
+	case 4:
+		*dest[0].(*int) = r.grant.UserID
+		*dest[1].(*string) = r.grant.ClientID
+		*dest[2].(*[]string) = r.grant.Scopes
+		*dest[3].(*time.Time) = r.grant.GrantedAt
+	case 1:
+		*dest[0].(*time.Time) = time.Now()
+	}
+	return nil
+}
+
+func TestConsentHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	claims := &service.CustomClaims{UserID: 7}
+	const key = "consent_request:abc"
+	stored := `{"user_id":7,"client_id":"cid","redirect_uri":"https://app.example.com/cb?x=1","requested_redirect_uri":"https://app.example.com/cb?x=1","scope":"openid users:read","state":"st","nonce":"n1","auth_time":1700000000}`
+	newReq := func(body string, claims *service.CustomClaims) (echo.Context, *httptest.ResponseRecorder) {
+		return newDeviceCtx(e, http.MethodPost, "/api/oauth/authorize/consent", echo.MIMEApplicationJSON, body, claims)
+	}
+	// grantDB 回傳既有的同意並記錄儲存的 scope
+	grantDB := func(existing *fakeGrantRow, saveErr error, saved *[]string) *database.FakeDB {
+		return &database.FakeDB{QueryRowFn: func(_ context.Context, sql string, args ...any) pgx.Row {
+			if strings.HasPrefix(strings.TrimSpace(sql), "INSERT") {
+				if saved != nil {
+					*saved = args[2].([]string)
+				}
+				return &fakeGrantRow{err: saveErr}
+			}
+			return existing
+		}}
+	}
+	location := func(t *testing.T, rec *httptest.ResponseRecorder) url.Values {
+		require.Equal(t, http.StatusFound, rec.Code)
+		u, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+		require.NoError(t, err)
+		require.Equal(t, "app.example.com", u.Host)
+		require.Equal(t, "1", u.Query().Get("x"))
+		return u.Query()
+	}
+
+	t.Run("no claims", func(t *testing.T) {
+		ctx, rec := newReq(`{"consent_challenge":"abc","action":"approve"}`, nil)
+		require.NoError(t, ConsentHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("third-party token", func(t *testing.T) {
+		ctx, rec := newReq(`{"consent_challenge":"abc","action":"approve"}`, &service.CustomClaims{UserID: 7, ClientID: "cid"})
+		require.NoError(t, ConsentHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("bind error", func(t *testing.T) {
+		ctx, rec := newReq("{", claims)
+		require.NoError(t, ConsentHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("validate error", func(t *testing.T) {
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newReq(`{}`, claims)
+		require.NoError(t, ConsentHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("unknown challenge", func(t *testing.T) {
+		ctx, rec := newReq(`{"consent_challenge":"nope","action":"approve"}`, claims)
+		require.NoError(t, ConsentHandler(nil, cache.NewMemoryCache(nil))(ctx))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		cch := cache.NewMemoryCache(map[string]string{key: stored})
+		ctx, rec := newReq(`{"consent_challenge":"abc","action":"approve"}`, &service.CustomClaims{UserID: 8})
+		require.NoError(t, ConsentHandler(nil, cch)(ctx))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+		require.Contains(t, cch.Data, key)
+	})
+
+	t.Run("load fail", func(t *testing.T) {
+		cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+			return redis.NewStringResult("", errors.New("get"))
+		}}
+		ctx, rec := newReq(`{"consent_challenge":"abc","action":"approve"}`, claims)
+		require.NoError(t, ConsentHandler(nil, cch)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		cch := cache.NewMemoryCache(map[string]string{key: stored})
+		ctx, rec := newReq(`{"consent_challenge":"abc","action":"deny"}`, claims)
+		require.NoError(t, ConsentHandler(nil, cch)(ctx))
+		q := location(t, rec)
+		require.Equal(t, errCodeAccessDenied, q.Get("error"))
+		require.Equal(t, "st", q.Get("state"))
+		require.NotContains(t, cch.Data, key)
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		cch := cache.NewMemoryCache(map[string]string{key: stored})
+		var saved []string
+		existing := &fakeGrantRow{grant: &model.OAuthGrant{UserID: 7, ClientID: "cid", Scopes: []string{"users:write"}}}
+		ctx, rec := newReq(`{"consent_challenge":"abc","action":"approve"}`, claims)
+		require.NoError(t, ConsentHandler(grantDB(existing, nil, &saved), cch)(ctx))
+		q := location(t, rec)
+		require.Equal(t, "st", q.Get("state"))
+		// 同意的 scope 與先前的同意合併
+		require.Equal(t, []string{"openid", "users:read", "users:write"}, saved)
+
+		var data service.AuthorizationCodeData
+		require.NoError(t, json.Unmarshal([]byte(cch.Data["authorization_code:"+q.Get("code")]), &data))
+		require.Equal(t, service.AuthorizationCodeData{
+			UserID:      7,
+			ClientID:    "cid",
+			RedirectURI: "https://app.example.com/cb?x=1",
+			Scope:       "openid users:read",
+			Nonce:       "n1",
+			AuthTime:    1700000000,
+		}, data)
+
+		// consent challenge 只能使用一次
+		ctx, rec = newReq(`{"consent_challenge":"abc","action":"approve"}`, claims)
+		require.NoError(t, ConsentHandler(grantDB(existing, nil, nil), cch)(ctx))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("approve first grant", func(t *testing.T) {
+		cch := cache.NewMemoryCache(map[string]string{key: stored})
+		var saved []string
+		ctx, rec := newReq(`{"consent_challenge":"abc","action":"approve"}`, claims)
+		require.NoError(t, ConsentHandler(grantDB(&fakeGrantRow{err: pgx.ErrNoRows}, nil, &saved), cch)(ctx))
+		require.NotEmpty(t, location(t, rec).Get("code"))
+		require.Equal(t, []string{"openid", "users:read"}, saved)
+	})
+
+	t.Run("grant lookup fail", func(t *testing.T) {
+		cch := cache.NewMemoryCache(map[string]string{key: stored})
+		ctx, rec := newReq(`{"consent_challenge":"abc","action":"approve"}`, claims)
+		require.NoError(t, ConsentHandler(grantDB(&fakeGrantRow{err: errors.New("db")}, nil, nil), cch)(ctx))
+		require.Equal(t, "server_error", location(t, rec).Get("error"))
+	})
+
+	t.Run("save grant fail", func(t *testing.T) {
+		cch := cache.NewMemoryCache(map[string]string{key: stored})
+		ctx, rec := newReq(`{"consent_challenge":"abc","action":"approve"}`, claims)
+		require.NoError(t, ConsentHandler(grantDB(&fakeGrantRow{err: pgx.ErrNoRows}, errors.New("fk"), nil), cch)(ctx))
+		require.Equal(t, "server_error", location(t, rec).Get("error"))
+		for k := range cch.Data {
+			require.NotContains(t, k, "authorization_code:")
+		}
+	})
+}
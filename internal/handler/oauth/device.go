@@ -0,0 +1,220 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	deviceCodeTTL          = 10 * time.Minute
+	deviceCodePollInterval = 5
+)
+
+var (
+	errMissingUser             = errors.New("invalid or missing token")
+	errFirstPartyTokenRequired = errors.New("a user login token is required")
+)
+
+// @Summary     OAuth2 device authorization endpoint
+// @Description 依 RFC 8628 為無瀏覽器的裝置（如 CLI）核發 device_code 與 user_code；使用者於 verification_uri 登入並核准後，client 以 device_code grant 輪詢 /oauth/token 取得 token
+// @Tags        oauth
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       Authorization         header   string false "Basic base64(client_id:client_secret)（client_secret_basic）"
+// @Param       client_id             formData string false "Client ID（client_secret_post、mTLS 與 none 必填）"
+// @Param       client_secret         formData string false "Client secret（client_secret_post）"
+// @Param       client_assertion_type formData string false "urn:ietf:params:oauth:client-assertion-type:jwt-bearer（private_key_jwt、client_secret_jwt）"
+// @Param       client_assertion      formData string false "RFC 7523 client assertion JWT（private_key_jwt、client_secret_jwt）"
+// @Param       scope                 formData string false "以空白分隔的 scope，未指定時為 client 登記的全部 scope"
+// @Success     200 {object} api.DeviceAuthorizationResponse
+// @Failure     400 {object} api.OAuthErrorResponse
+// @Failure     401 {object} api.OAuthErrorResponse
+// @Failure     500 {object} api.OAuthErrorResponse
+// @Router      /oauth/device_authorization [post]
+func DeviceAuthorizationHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		noStore(c)
+		var req api.DeviceAuthorizationRequest
+		if err := c.Bind(&req); err != nil {
+			return oauthError(c, errCodeInvalidRequest, "invalid request payload")
+		}
+
+		oc, err := authenticateClient(c, db, cache)
+		if err != nil {
+			return clientAuthError(c, err)
+		}
+		if !hasGrantType(oc.GrantTypes, service.GrantTypeDeviceCode) {
+			return oauthError(c, errCodeUnauthorizedClient, "grant_type not allowed for this client")
+		}
+		scope, err := service.ResolveScope(req.Scope, oc.Scopes)
+		if err != nil {
+			return oauthError(c, errCodeInvalidScope, err.Error())
+		}
+
+		deviceCode, userCode, err := service.IssueDeviceAuthorization(c.Request().Context(), cache, oc.ClientID, scope, deviceCodeTTL, deviceCodePollInterval)
+		if err != nil {
+			return oauthError(c, errCodeServerError, "failed to issue device code")
+		}
+		verificationURI := issuerURL() + "/api/oauth/device"
+		return c.JSON(http.StatusOK, api.DeviceAuthorizationResponse{
+			DeviceCode:              deviceCode,
+			UserCode:                userCode,
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {userCode}}.Encode(),
+			ExpiresIn:               int(deviceCodeTTL.Seconds()),
+			Interval:                deviceCodePollInterval,
+		})
+	}
+}
+
+// @Summary     查詢待核准的裝置授權
+// @Description 已登入使用者以裝置上顯示的 user_code 查詢提出授權的 client 與 scope，供確認後核准；同一使用者與同一 IP 的查詢與核准次數合併限制，超過時回傳 429
+// @Tags        oauth
+// @Produce     json
+// @Param       user_code query string true "裝置上顯示的 user code，不分大小寫、可省略連字號"
+// @Success     200 {object} api.DeviceVerificationResponse
+// @Failure     400 {object} api.ErrorResponse
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     403 {object} api.ErrorResponse
+// @Failure     404 {object} api.ErrorResponse
+// @Failure     429 {object} api.ErrorResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Router      /oauth/device [get]
+func DeviceVerificationHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := firstPartyUser(c)
+		if err != nil {
+			return firstPartyUserError(c, err)
+		}
+		var req api.DeviceVerificationRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid request"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		ctx := c.Request().Context()
+		if err := service.AllowUserCodeAttempt(ctx, cache, claims.UserID, c.RealIP()); err != nil {
+			return deviceLookupError(c, err)
+		}
+		data, _, err := service.LookupDeviceAuthorization(ctx, cache, req.UserCode)
+		if err != nil {
+			return deviceLookupError(c, err)
+		}
+		oc, err := store.GetOAuthClientByClientID(ctx, db, data.ClientID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to retrieve client"})
+		}
+		return c.JSON(http.StatusOK, api.DeviceVerificationResponse{
+			UserCode:   data.UserCode,
+			ClientID:   oc.ClientID,
+			ClientName: oc.ClientName,
+			Scope:      data.Scope,
+			Status:     data.Status,
+		})
+	}
+}
+
+// @Summary     核准或拒絕裝置授權
+// @Description 已登入使用者核准（action=approve，預設）或拒絕（action=deny）裝置上顯示的 user_code；每個 user code 僅能決定一次，次數限制與查詢合併計算
+// @Tags        oauth
+// @Accept      json
+// @Produce     json
+// @Param       body body api.DeviceVerificationRequest true "user code 與動作"
+// @Success     204
+// @Failure     400 {object} api.ErrorResponse
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     403 {object} api.ErrorResponse
+// @Failure     404 {object} api.ErrorResponse
+// @Failure     429 {object} api.ErrorResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Router      /oauth/device [post]
+func DeviceApprovalHandler(cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := firstPartyUser(c)
+		if err != nil {
+			return firstPartyUserError(c, err)
+		}
+		var req api.DeviceVerificationRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid request"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		ctx := c.Request().Context()
+		if err := service.AllowUserCodeAttempt(ctx, cache, claims.UserID, c.RealIP()); err != nil {
+			return deviceLookupError(c, err)
+		}
+		approve := req.Action != "deny"
+		if err := service.DecideDeviceAuthorization(ctx, cache, req.UserCode, claims.UserID, approve, claims.Authentication); err != nil {
+			return deviceLookupError(c, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// firstPartyUser 取得核准裝置或同意授權的使用者；僅接受使用者直接登入的第一方 token，
+// 避免第三方 client 代替使用者核准其他裝置或同意自己的授權
+func firstPartyUser(c echo.Context) (*service.CustomClaims, error) {
+	claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+	if !ok || claims.UserID == 0 {
+		return nil, errMissingUser
+	}
+	if claims.ClientID != "" {
+		return nil, errFirstPartyTokenRequired
+	}
+	return claims, nil
+}
+
+// firstPartyUserError 將 firstPartyUser 的錯誤轉為 HTTP 回應
+func firstPartyUserError(c echo.Context, err error) error {
+	if errors.Is(err, errFirstPartyTokenRequired) {
+		return c.JSON(http.StatusForbidden, api.ErrorResponse{Message: err.Error()})
+	}
+	return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: err.Error()})
+}
+
+func deviceLookupError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrUserCodeNotFound):
+		return c.JSON(http.StatusNotFound, api.ErrorResponse{Message: err.Error()})
+	case errors.Is(err, service.ErrDeviceAlreadyDecided):
+		return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+	case errors.Is(err, service.ErrRateLimited):
+		return c.JSON(http.StatusTooManyRequests, api.ErrorResponse{Message: err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to process user code"})
+}
+
+// deviceGrantError 將輪詢結果轉為 RFC 8628 §3.5 的錯誤碼
+func deviceGrantError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrAuthorizationPending):
+		return oauthError(c, errCodeAuthorizationPending, err.Error())
+	case errors.Is(err, service.ErrSlowDown):
+		return oauthError(c, errCodeSlowDown, err.Error())
+	case errors.Is(err, service.ErrDeviceAccessDenied):
+		return oauthError(c, errCodeAccessDenied, err.Error())
+	case errors.Is(err, service.ErrDeviceCodeExpired):
+		return oauthError(c, errCodeExpiredToken, err.Error())
+	case errors.Is(err, service.ErrDeviceCodeNotFound):
+		return oauthError(c, errCodeInvalidGrant, err.Error())
+	}
+	return oauthError(c, errCodeServerError, "failed to check device code")
+}
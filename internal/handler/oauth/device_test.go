@@ -0,0 +1,294 @@
+package oauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func newDeviceCtx(e *echo.Echo, method, target, contentType, body string, claims *service.CustomClaims) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(req, rec)
+	if claims != nil {
+		ctx.Set(middleware.ContextUserKey, claims)
+	}
+	return ctx, rec
+}
+
+func TestDeviceAuthorizationHandler(t *testing.T) {
+	t.Setenv("OAUTH_ISSUER", "http://example.com")
+	e := echo.New()
+	now := time.Now()
+	client := &model.OAuthClient{ClientID: "cli", ClientSecret: "sec", GrantTypes: []string{service.GrantTypeDeviceCode}, Scopes: []string{"openid", "users:read"}, CreatedAt: now, UpdatedAt: now}
+	clientDB := func(oc *model.OAuthClient) *database.FakeDB {
+		return &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeClientRow{client: oc}
+		}}
+	}
+	validAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("cli:sec"))
+	newReq := func(form, auth string) (echo.Context, *httptest.ResponseRecorder) {
+		ctx, rec := newDeviceCtx(e, http.MethodPost, "/api/oauth/device_authorization", echo.MIMEApplicationForm, form, nil)
+		if auth != "" {
+			ctx.Request().Header.Set("Authorization", auth)
+		}
+		return ctx, rec
+	}
+
+	t.Run("bind error", func(t *testing.T) {
+		ctx, rec := newReq("bad%", validAuth)
+		require.NoError(t, DeviceAuthorizationHandler(clientDB(client), nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("invalid client", func(t *testing.T) {
+		ctx, rec := newReq("", "Basic "+base64.StdEncoding.EncodeToString([]byte("cli:nope")))
+		require.NoError(t, DeviceAuthorizationHandler(clientDB(client), nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("grant not allowed", func(t *testing.T) {
+		other := *client
+		other.GrantTypes = []string{"authorization_code"}
+		ctx, rec := newReq("", validAuth)
+		require.NoError(t, DeviceAuthorizationHandler(clientDB(&other), nil)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeUnauthorizedClient)
+	})
+
+	t.Run("invalid scope", func(t *testing.T) {
+		ctx, rec := newReq("scope=users:write", validAuth)
+		require.NoError(t, DeviceAuthorizationHandler(clientDB(client), nil)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidScope)
+		require.Contains(t, rec.Body.String(), "invalid scope")
+	})
+
+	t.Run("store fail", func(t *testing.T) {
+		cch := &cache.FakeCache{SetFn: func(context.Context, string, any, time.Duration) *redis.StatusCmd {
+			return redis.NewStatusResult("", errors.New("set"))
+		}}
+		ctx, rec := newReq("", validAuth)
+		require.NoError(t, DeviceAuthorizationHandler(clientDB(client), cch)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		cch := cache.NewMemoryCache(nil)
+		ctx, rec := newReq("scope=openid", validAuth)
+		require.NoError(t, DeviceAuthorizationHandler(clientDB(client), cch)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp api.DeviceAuthorizationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.NotEmpty(t, resp.DeviceCode)
+		require.Len(t, resp.UserCode, 9)
+		require.Equal(t, "http://example.com/api/oauth/device", resp.VerificationURI)
+		require.Equal(t, resp.VerificationURI+"?user_code="+url.QueryEscape(resp.UserCode), resp.VerificationURIComplete)
+		require.Equal(t, 600, resp.ExpiresIn)
+		require.Equal(t, 5, resp.Interval)
+
+		var data service.DeviceAuthorizationData
+		require.NoError(t, json.Unmarshal([]byte(cch.Data["device_code:"+resp.DeviceCode]), &data))
+		require.Equal(t, "cli", data.ClientID)
+		require.Equal(t, "openid", data.Scope)
+	})
+}
+
+func TestDeviceVerificationHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	now := time.Now()
+	claims := &service.CustomClaims{UserID: 1}
+	client := &model.OAuthClient{ClientID: "cli", ClientName: "CLI", CreatedAt: now, UpdatedAt: now}
+	db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+		return &fakeClientRow{client: client}
+	}}
+	deviceCache := func(status string) *cache.MemoryCache {
+		b, _ := json.Marshal(service.DeviceAuthorizationData{ClientID: "cli", Scope: "openid", UserCode: "BCDF-GHJK", Status: status, ExpiresAt: now.Add(time.Minute).Unix()})
+		return cache.NewMemoryCache(map[string]string{"device_user_code:BCDFGHJK": "dc", "device_code:dc": string(b)})
+	}
+	newReq := func(query string, claims *service.CustomClaims) (echo.Context, *httptest.ResponseRecorder) {
+		return newDeviceCtx(e, http.MethodGet, "/api/oauth/device?"+query, "", "", claims)
+	}
+
+	t.Run("no claims", func(t *testing.T) {
+		ctx, rec := newReq("user_code=BCDF-GHJK", nil)
+		require.NoError(t, DeviceVerificationHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("third party token", func(t *testing.T) {
+		ctx, rec := newReq("user_code=BCDF-GHJK", &service.CustomClaims{UserID: 1, ClientID: "cid"})
+		require.NoError(t, DeviceVerificationHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("bind error", func(t *testing.T) {
+		ctx, rec := newDeviceCtx(e, http.MethodGet, "/api/oauth/device", echo.MIMEApplicationJSON, "{", claims)
+		require.NoError(t, DeviceVerificationHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("validate error", func(t *testing.T) {
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newReq("", claims)
+		require.NoError(t, DeviceVerificationHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		ctx, rec := newReq("user_code=XXXX-XXXX", claims)
+		require.NoError(t, DeviceVerificationHandler(db, cache.NewMemoryCache(nil))(ctx))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("already decided", func(t *testing.T) {
+		ctx, rec := newReq("user_code=BCDF-GHJK", claims)
+		require.NoError(t, DeviceVerificationHandler(db, deviceCache(service.DeviceStatusApproved))(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("cache error", func(t *testing.T) {
+		cch := deviceCache(service.DeviceStatusPending)
+		cch.FailOn["get"] = "device_user_code:"
+		ctx, rec := newReq("user_code=BCDF-GHJK", claims)
+		require.NoError(t, DeviceVerificationHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+		cch = deviceCache(service.DeviceStatusPending)
+		cch.FailOn["incr"] = "user_code_rate:"
+		ctx, rec = newReq("user_code=BCDF-GHJK", claims)
+		require.NoError(t, DeviceVerificationHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		// 猜錯的 user code 同樣計數，達上限後即使 user code 正確也不再查詢
+		cch := deviceCache(service.DeviceStatusPending)
+		for range 20 {
+			ctx, rec := newReq("user_code=XXXX-XXXX", claims)
+			require.NoError(t, DeviceVerificationHandler(db, cch)(ctx))
+			require.Equal(t, http.StatusNotFound, rec.Code)
+		}
+		ctx, rec := newReq("user_code=BCDF-GHJK", claims)
+		require.NoError(t, DeviceVerificationHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	})
+
+	t.Run("client gone", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeClientRow{err: errors.New("no")}
+		}}
+		ctx, rec := newReq("user_code=BCDF-GHJK", claims)
+		require.NoError(t, DeviceVerificationHandler(db, deviceCache(service.DeviceStatusPending))(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ctx, rec := newReq("user_code=bcdfghjk", claims)
+		require.NoError(t, DeviceVerificationHandler(db, deviceCache(service.DeviceStatusPending))(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.DeviceVerificationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, api.DeviceVerificationResponse{UserCode: "BCDF-GHJK", ClientID: "cli", ClientName: "CLI", Scope: "openid", Status: service.DeviceStatusPending}, resp)
+	})
+}
+
+func TestDeviceApprovalHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	now := time.Now()
+	claims := &service.CustomClaims{UserID: 7}
+	deviceCache := func() *cache.MemoryCache {
+		b, _ := json.Marshal(service.DeviceAuthorizationData{ClientID: "cli", UserCode: "BCDF-GHJK", Status: service.DeviceStatusPending, ExpiresAt: now.Add(time.Minute).Unix()})
+		return cache.NewMemoryCache(map[string]string{"device_user_code:BCDFGHJK": "dc", "device_code:dc": string(b)})
+	}
+	newReq := func(body string, claims *service.CustomClaims) (echo.Context, *httptest.ResponseRecorder) {
+		return newDeviceCtx(e, http.MethodPost, "/api/oauth/device", echo.MIMEApplicationJSON, body, claims)
+	}
+	stored := func(t *testing.T, cch *cache.MemoryCache) service.DeviceAuthorizationData {
+		var data service.DeviceAuthorizationData
+		require.NoError(t, json.Unmarshal([]byte(cch.Data["device_code:dc"]), &data))
+		return data
+	}
+
+	t.Run("no claims", func(t *testing.T) {
+		ctx, rec := newReq(`{"user_code":"BCDF-GHJK"}`, nil)
+		require.NoError(t, DeviceApprovalHandler(nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("bind error", func(t *testing.T) {
+		ctx, rec := newReq("{", claims)
+		require.NoError(t, DeviceApprovalHandler(nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("validate error", func(t *testing.T) {
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newReq(`{}`, claims)
+		require.NoError(t, DeviceApprovalHandler(nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		ctx, rec := newReq(`{"user_code":"XXXX-XXXX"}`, claims)
+		require.NoError(t, DeviceApprovalHandler(cache.NewMemoryCache(nil))(ctx))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		cch := deviceCache()
+		ctx, rec := newReq(`{"user_code":"bcdf-ghjk"}`, claims)
+		require.NoError(t, DeviceApprovalHandler(cch)(ctx))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		data := stored(t, cch)
+		require.Equal(t, service.DeviceStatusApproved, data.Status)
+		require.Equal(t, 7, data.UserID)
+
+		// user code 只能使用一次
+		ctx, rec = newReq(`{"user_code":"BCDF-GHJK","action":"deny"}`, claims)
+		require.NoError(t, DeviceApprovalHandler(cch)(ctx))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		cch := deviceCache()
+		ctx, rec := newReq(`{"user_code":"BCDF-GHJK","action":"deny"}`, claims)
+		require.NoError(t, DeviceApprovalHandler(cch)(ctx))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, service.DeviceStatusDenied, stored(t, cch).Status)
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		cch := deviceCache()
+		cch.Data["user_code_rate:user:7"] = "20"
+		ctx, rec := newReq(`{"user_code":"BCDF-GHJK"}`, claims)
+		require.NoError(t, DeviceApprovalHandler(cch)(ctx))
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+		require.Equal(t, service.DeviceStatusPending, stored(t, cch).Status)
+	})
+}
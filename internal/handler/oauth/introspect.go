@@ -0,0 +1,84 @@
+package oauth
+
+import (
+	"net/http"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+// @Summary     OAuth2 introspect token
+// @Description 依 RFC 7662 查詢 access token 或 refresh token 的狀態；無效、過期或已撤銷的 token 僅回傳 active=false；憑證綁定的 access token 另回傳 cnf。public client（none）不可呼叫
+// @Tags        oauth
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       Authorization         header   string false "Basic base64(client_id:client_secret)（client_secret_basic）"
+// @Param       client_id             formData string false "Client ID（client_secret_post、mTLS 與 none 必填）"
+// @Param       client_secret         formData string false "Client secret（client_secret_post）"
+// @Param       client_assertion_type formData string false "urn:ietf:params:oauth:client-assertion-type:jwt-bearer（private_key_jwt、client_secret_jwt）"
+// @Param       client_assertion      formData string false "RFC 7523 client assertion JWT（private_key_jwt、client_secret_jwt）"
+// @Param       token                 formData string true  "要查詢的 token"
+// @Param       token_type_hint       formData string false "Token 類型提示：access_token 或 refresh_token"
+// @Success     200 {object} api.IntrospectResponse
+// @Failure     400 {object} api.OAuthErrorResponse
+// @Failure     401 {object} api.OAuthErrorResponse
+// @Failure     500 {object} api.OAuthErrorResponse
+// @Router      /oauth/introspect [post]
+func IntrospectHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		noStore(c)
+		var req api.IntrospectRequest
+		if err := c.Bind(&req); err != nil {
+			return oauthError(c, errCodeInvalidRequest, "invalid request payload")
+		}
+		if err := c.Validate(&req); err != nil {
+			return oauthError(c, errCodeInvalidRequest, err.Error())
+		}
+
+		oc, err := authenticateClient(c, db, cache)
+		if err != nil {
+			return clientAuthError(c, err)
+		}
+		// RFC 7662 §2.1：introspection 僅開放給能認證身分的 client
+		if oc.TokenEndpointAuthMethod == model.ClientAuthMethodNone {
+			return oauthError(c, errCodeInvalidClient, "public clients cannot introspect tokens")
+		}
+
+		result, err := service.IntrospectToken(c.Request().Context(), cache, req.Token, req.TokenTypeHint)
+		if err != nil {
+			return oauthError(c, errCodeServerError, "failed to introspect token")
+		}
+		resp := api.IntrospectResponse{
+			Active:    result.Active,
+			TokenType: result.TokenType,
+			Sub:       result.Subject,
+			ClientID:  result.ClientID,
+			Scope:     result.Scope,
+			Exp:       result.ExpiresAt,
+			Iat:       result.IssuedAt,
+			IsAdmin:   result.IsAdmin,
+			Aud:       result.Audience,
+			Act:       newTokenActor(result.Actor),
+		}
+		if result.Confirmation != nil {
+			resp.Cnf = &api.TokenConfirmation{
+				X5tS256: result.Confirmation.X5tS256,
+				JKT:     result.Confirmation.JKT,
+			}
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// newTokenActor 將 act claim 連同巢狀的前一代理者轉為回應格式
+func newTokenActor(a *service.Actor) *api.TokenActor {
+	if a == nil {
+		return nil
+	}
+	return &api.TokenActor{Sub: a.Subject, ClientID: a.ClientID, Act: newTokenActor(a.Actor)}
+}
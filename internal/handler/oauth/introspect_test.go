@@ -0,0 +1,140 @@
+package oauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func newIntrospectCtx(e *echo.Echo, form string, auth string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(form))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	return e.NewContext(req, rec), rec
+}
+
+func TestIntrospectHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	now := time.Now()
+	client := &model.OAuthClient{ClientID: "rs", ClientSecret: "sec", GrantTypes: []string{"client_credentials"}, CreatedAt: now, UpdatedAt: now}
+	db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+		return &fakeClientRow{client: client}
+	}}
+	validAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("rs:sec"))
+
+	t.Run("bind error", func(t *testing.T) {
+		ctx, rec := newIntrospectCtx(e, "bad%", validAuth)
+		require.NoError(t, IntrospectHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("validate error", func(t *testing.T) {
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newIntrospectCtx(e, "", validAuth)
+		require.NoError(t, IntrospectHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("missing client auth", func(t *testing.T) {
+		ctx, rec := newIntrospectCtx(e, "token=t", "")
+		require.NoError(t, IntrospectHandler(db, nil)(ctx))
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
+	})
+
+	t.Run("public client", func(t *testing.T) {
+		public := &model.OAuthClient{ClientID: "app", ClientType: model.ClientTypePublic, TokenEndpointAuthMethod: model.ClientAuthMethodNone}
+		publicDB := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeClientRow{client: public}
+		}}
+		ctx, rec := newIntrospectCtx(e, "token=t&client_id=app", "")
+		require.NoError(t, IntrospectHandler(publicDB, nil)(ctx))
+		resp := requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
+		require.Equal(t, "public clients cannot introspect tokens", resp.ErrorDescription)
+	})
+
+	t.Run("wrong client secret", func(t *testing.T) {
+		bad := "Basic " + base64.StdEncoding.EncodeToString([]byte("rs:nope"))
+		ctx, rec := newIntrospectCtx(e, "token=t", bad)
+		require.NoError(t, IntrospectHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("cache error", func(t *testing.T) {
+		cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+			return redis.NewStringResult("", errors.New("get"))
+		}}
+		ctx, rec := newIntrospectCtx(e, "token=t", validAuth)
+		require.NoError(t, IntrospectHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("inactive", func(t *testing.T) {
+		cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+			return redis.NewStringResult("", redis.Nil)
+		}}
+		ctx, rec := newIntrospectCtx(e, "token=t", validAuth)
+		require.NoError(t, IntrospectHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{"active":false}`, rec.Body.String())
+	})
+
+	t.Run("active refresh token of another client", func(t *testing.T) {
+		data, _ := json.Marshal(service.RefreshTokenData{UserID: 1, ClientID: "cid", IssuedAt: 10, ExpiresAt: 20})
+		cch := &cache.FakeCache{GetFn: func(_ context.Context, key string) *redis.StringCmd {
+			if strings.HasPrefix(key, "revoked_grant:") || strings.HasPrefix(key, "revoked_user_sessions:") {
+				return redis.NewStringResult("", redis.Nil)
+			}
+			return redis.NewStringResult(string(data), nil)
+		}}
+		ctx, rec := newIntrospectCtx(e, "token=t&token_type_hint=refresh_token", validAuth)
+		require.NoError(t, IntrospectHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.IntrospectResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, api.IntrospectResponse{
+			Active:    true,
+			TokenType: "refresh_token",
+			Sub:       "1",
+			ClientID:  "cid",
+			Exp:       20,
+			Iat:       10,
+		}, resp)
+	})
+	t.Run("exchanged access token", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "s")
+		subject := &service.CustomClaims{UserID: 1, Actor: &service.Actor{Subject: "gateway"}}
+		tok, _, err := service.IssueExchangedAccessToken(context.Background(), model.OAuthClient{ClientID: "orders"}, subject, nil, []string{"rs"}, "", time.Minute, nil)
+		require.NoError(t, err)
+		cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+			return redis.NewStringResult("", redis.Nil)
+		}}
+		ctx, rec := newIntrospectCtx(e, "token="+tok+"&token_type_hint=access_token", validAuth)
+		require.NoError(t, IntrospectHandler(db, cch)(ctx))
+		var resp api.IntrospectResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, []string{"rs"}, resp.Aud)
+		require.Equal(t, &api.TokenActor{Sub: "orders", ClientID: "orders", Act: &api.TokenActor{Sub: "gateway"}}, resp.Act)
+	})
+}
@@ -0,0 +1,80 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+// signingKeyRetireGrace 為輪替後舊金鑰仍接受驗證的期間，取 access token 與 ID token 效期上限的較長者
+const signingKeyRetireGrace = max(service.MaxAccessTokenTTL, service.MaxIDTokenTTL)
+
+var (
+	publicJWKS        = service.PublicJWKS
+	rotateSigningKeys = service.RotateSigningKeys
+)
+
+// JWKSHandler 於 /.well-known/jwks.json 公開驗證 access token 所需的公鑰，
+// 包含 active、next 與尚在寬限期內的 retired 金鑰；路徑不在 /api 之下，因此不列入 swagger
+func JWKSHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		keys, err := publicJWKS(c.Request().Context())
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to load signing keys"})
+		}
+		c.Response().Header().Set("Cache-Control", "public, max-age=300")
+		return c.JSON(http.StatusOK, newJWKSResponse(keys))
+	}
+}
+
+// @Summary     Rotate signing keys
+// @Description 管理員輪替 JWT 簽章金鑰（經由 OAuth client 取得的 token 須具備 keys:manage）：next 升為 active 並產生新的 next，舊 active 在寬限期內仍可驗證
+// @Tags        oauth
+// @Produce     json
+// @Success     200 {object} api.JWKSResponse
+// @Failure     400 {object} api.ErrorResponse
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     403 {object} api.ErrorResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Application[keys:manage]
+// @Security    OAuth2Password[keys:manage]
+// @Router      /oauth/signing-keys/rotate [post]
+func RotateSigningKeysHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		if err := rotateSigningKeys(ctx, signingKeyRetireGrace); err != nil {
+			if errors.Is(err, service.ErrKeyStoreNotConfigured) {
+				return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to rotate signing keys"})
+		}
+		keys, err := publicJWKS(ctx)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to load signing keys"})
+		}
+		return c.JSON(http.StatusOK, newJWKSResponse(keys))
+	}
+}
+
+func newJWKSResponse(keys []service.JSONWebKey) api.JWKSResponse {
+	resp := api.JWKSResponse{Keys: make([]api.JSONWebKey, 0, len(keys))}
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, api.JSONWebKey{
+			Kty: k.KeyType,
+			Kid: k.KeyID,
+			Use: k.Use,
+			Alg: k.Algorithm,
+			N:   k.N,
+			E:   k.E,
+			Crv: k.Curve,
+			X:   k.X,
+			Y:   k.Y,
+		})
+	}
+	return resp
+}
@@ -0,0 +1,99 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/service"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func restoreJWKSGlobals() {
+	publicJWKS = service.PublicJWKS
+	rotateSigningKeys = service.RotateSigningKeys
+}
+
+var sampleJWKS = []service.JSONWebKey{
+	{KeyType: "EC", KeyID: "k1", Use: "sig", Algorithm: "ES256", Curve: "P-256", X: "x", Y: "y"},
+	{KeyType: "RSA", KeyID: "k2", Use: "sig", Algorithm: "RS256", N: "n", E: "AQAB"},
+}
+
+func TestJWKSHandler(t *testing.T) {
+	t.Cleanup(restoreJWKSGlobals)
+	e := echo.New()
+
+	t.Run("load error", func(t *testing.T) {
+		publicJWKS = func(context.Context) ([]service.JSONWebKey, error) { return nil, errors.New("db") }
+		rec := httptest.NewRecorder()
+		require.NoError(t, JWKSHandler()(e.NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil), rec)))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("without key store", func(t *testing.T) {
+		restoreJWKSGlobals()
+		rec := httptest.NewRecorder()
+		require.NoError(t, JWKSHandler()(e.NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil), rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{"keys":[]}`, rec.Body.String())
+	})
+
+	t.Run("success", func(t *testing.T) {
+		publicJWKS = func(context.Context) ([]service.JSONWebKey, error) { return sampleJWKS, nil }
+		rec := httptest.NewRecorder()
+		require.NoError(t, JWKSHandler()(e.NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil), rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "public, max-age=300", rec.Header().Get("Cache-Control"))
+		var resp api.JWKSResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, api.JWKSResponse{Keys: []api.JSONWebKey{
+			{Kty: "EC", Kid: "k1", Use: "sig", Alg: "ES256", Crv: "P-256", X: "x", Y: "y"},
+			{Kty: "RSA", Kid: "k2", Use: "sig", Alg: "RS256", N: "n", E: "AQAB"},
+		}}, resp)
+	})
+}
+
+func TestRotateSigningKeysHandler(t *testing.T) {
+	t.Cleanup(restoreJWKSGlobals)
+	e := echo.New()
+	call := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		require.NoError(t, RotateSigningKeysHandler()(e.NewContext(httptest.NewRequest(http.MethodPost, "/oauth/signing-keys/rotate", nil), rec)))
+		return rec
+	}
+
+	t.Run("not configured", func(t *testing.T) {
+		restoreJWKSGlobals()
+		require.Equal(t, http.StatusBadRequest, call().Code)
+	})
+
+	t.Run("rotate error", func(t *testing.T) {
+		rotateSigningKeys = func(context.Context, time.Duration) error { return errors.New("db") }
+		require.Equal(t, http.StatusInternalServerError, call().Code)
+	})
+
+	t.Run("load error", func(t *testing.T) {
+		rotateSigningKeys = func(context.Context, time.Duration) error { return nil }
+		publicJWKS = func(context.Context) ([]service.JSONWebKey, error) { return nil, errors.New("db") }
+		require.Equal(t, http.StatusInternalServerError, call().Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		var grace time.Duration
+		rotateSigningKeys = func(_ context.Context, g time.Duration) error { grace = g; return nil }
+		publicJWKS = func(context.Context) ([]service.JSONWebKey, error) { return sampleJWKS, nil }
+		rec := call()
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, signingKeyRetireGrace, grace)
+		require.GreaterOrEqual(t, grace, service.MaxAccessTokenTTL)
+		require.GreaterOrEqual(t, grace, service.MaxIDTokenTTL)
+		require.Contains(t, rec.Body.String(), `"kid":"k2"`)
+	})
+}
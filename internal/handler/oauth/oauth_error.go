@@ -0,0 +1,95 @@
+package oauth
+
+import (
+	"net/http"
+
+	"life-is-hard/internal/api"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RFC 6749 §4.1.2.1 與 §5.2、RFC 6750 §3.1、RFC 7591 §3.2.2、RFC 8628 §3.5、RFC 8693 §2.2.2 與 RFC 9449 定義的錯誤碼
+const (
+	errCodeInvalidRequest          = "invalid_request"
+	errCodeInvalidClient           = "invalid_client"
+	errCodeInvalidGrant            = "invalid_grant"
+	errCodeUnauthorizedClient      = "unauthorized_client"
+	errCodeUnsupportedGrantType    = "unsupported_grant_type"
+	errCodeInvalidScope            = "invalid_scope"
+	errCodeServerError             = "server_error"
+	errCodeAuthorizationPending    = "authorization_pending"
+	errCodeSlowDown                = "slow_down"
+	errCodeAccessDenied            = "access_denied"
+	errCodeExpiredToken            = "expired_token"
+	errCodeInvalidDPoPProof        = "invalid_dpop_proof"
+	errCodeUseDPoPNonce            = "use_dpop_nonce"
+	errCodeInvalidToken            = "invalid_token"
+	errCodeInsufficientScope       = "insufficient_scope"
+	errCodeInvalidRedirectURI      = "invalid_redirect_uri"
+	errCodeInvalidClientMetadata   = "invalid_client_metadata"
+	errCodeUnsupportedResponseType = "unsupported_response_type"
+	errCodeInvalidTarget           = "invalid_target"
+	// errCodeMFARequired 非標準錯誤碼：已啟用 MFA 的使用者以 password grant 取得 token 時須另以 otp 參數提交驗證碼
+	errCodeMFARequired                   = "mfa_required"
+	errorURIRFC6749TokenResponse         = "https://datatracker.ietf.org/doc/html/rfc6749#section-5.2"
+	errorURIRFC6749AuthorizationResponse = "https://datatracker.ietf.org/doc/html/rfc6749#section-4.1.2.1"
+	errorURIRFC8628TokenResponse         = "https://datatracker.ietf.org/doc/html/rfc8628#section-3.5"
+	errorURIRFC9449DPoP                  = "https://datatracker.ietf.org/doc/html/rfc9449#section-12.2"
+	errorURIRFC6750BearerError           = "https://datatracker.ietf.org/doc/html/rfc6750#section-3.1"
+	errorURIRFC7591Registration          = "https://datatracker.ietf.org/doc/html/rfc7591#section-3.2.2"
+	errorURIRFC8693TokenExchange         = "https://datatracker.ietf.org/doc/html/rfc8693#section-2.2.2"
+)
+
+// errorURIs 將錯誤碼對應到定義它的規格章節，作為回應中的 error_uri
+var errorURIs = map[string]string{
+	errCodeInvalidRequest:          errorURIRFC6749TokenResponse,
+	errCodeInvalidClient:           errorURIRFC6749TokenResponse,
+	errCodeInvalidGrant:            errorURIRFC6749TokenResponse,
+	errCodeUnauthorizedClient:      errorURIRFC6749TokenResponse,
+	errCodeUnsupportedGrantType:    errorURIRFC6749TokenResponse,
+	errCodeInvalidScope:            errorURIRFC6749TokenResponse,
+	errCodeAuthorizationPending:    errorURIRFC8628TokenResponse,
+	errCodeSlowDown:                errorURIRFC8628TokenResponse,
+	errCodeAccessDenied:            errorURIRFC8628TokenResponse,
+	errCodeExpiredToken:            errorURIRFC8628TokenResponse,
+	errCodeInvalidDPoPProof:        errorURIRFC9449DPoP,
+	errCodeUseDPoPNonce:            errorURIRFC9449DPoP,
+	errCodeInvalidToken:            errorURIRFC6750BearerError,
+	errCodeInsufficientScope:       errorURIRFC6750BearerError,
+	errCodeInvalidRedirectURI:      errorURIRFC7591Registration,
+	errCodeInvalidClientMetadata:   errorURIRFC7591Registration,
+	errCodeUnsupportedResponseType: errorURIRFC6749AuthorizationResponse,
+	errCodeInvalidTarget:           errorURIRFC8693TokenExchange,
+}
+
+// noStore 依 RFC 6749 §5.1 禁止快取含有 token 或憑證的回應
+func noStore(c echo.Context) {
+	h := c.Response().Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set("Pragma", "no-cache")
+}
+
+// oauthError 依 RFC 6749 §5.2 回傳錯誤；invalid_client 與 invalid_token 為 401 並附上 WWW-Authenticate，
+// insufficient_scope 為 403，server_error 為 500，其餘為 400
+func oauthError(c echo.Context, code, description string) error {
+	noStore(c)
+	status := http.StatusBadRequest
+	switch code {
+	case errCodeInvalidClient:
+		status = http.StatusUnauthorized
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="oauth", error="invalid_client"`)
+	case errCodeInvalidToken:
+		status = http.StatusUnauthorized
+		c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	case errCodeInsufficientScope:
+		status = http.StatusForbidden
+		c.Response().Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+	case errCodeServerError:
+		status = http.StatusInternalServerError
+	}
+	return c.JSON(status, api.OAuthErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+		ErrorURI:         errorURIs[code],
+	})
+}
@@ -0,0 +1,72 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"life-is-hard/internal/api"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// requireOAuthError 檢查回應為 RFC 6749 §5.2 格式的指定錯誤碼且不可快取
+func requireOAuthError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) api.OAuthErrorResponse {
+	t.Helper()
+	require.Equal(t, status, rec.Code)
+	require.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	var resp api.OAuthErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, code, resp.Error)
+	return resp
+}
+
+func TestOAuthError(t *testing.T) {
+	e := echo.New()
+	newCtx := func() (echo.Context, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		return e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec), rec
+	}
+
+	cases := []struct {
+		code   string
+		status int
+		uri    string
+	}{
+		{errCodeInvalidRequest, http.StatusBadRequest, errorURIRFC6749TokenResponse},
+		{errCodeInvalidClient, http.StatusUnauthorized, errorURIRFC6749TokenResponse},
+		{errCodeInvalidGrant, http.StatusBadRequest, errorURIRFC6749TokenResponse},
+		{errCodeUnauthorizedClient, http.StatusBadRequest, errorURIRFC6749TokenResponse},
+		{errCodeUnsupportedGrantType, http.StatusBadRequest, errorURIRFC6749TokenResponse},
+		{errCodeInvalidScope, http.StatusBadRequest, errorURIRFC6749TokenResponse},
+		{errCodeSlowDown, http.StatusBadRequest, errorURIRFC8628TokenResponse},
+		{errCodeInvalidDPoPProof, http.StatusBadRequest, errorURIRFC9449DPoP},
+		{errCodeInvalidToken, http.StatusUnauthorized, errorURIRFC6750BearerError},
+		{errCodeInsufficientScope, http.StatusForbidden, errorURIRFC6750BearerError},
+		{errCodeInvalidRedirectURI, http.StatusBadRequest, errorURIRFC7591Registration},
+		{errCodeInvalidClientMetadata, http.StatusBadRequest, errorURIRFC7591Registration},
+		{errCodeUnsupportedResponseType, http.StatusBadRequest, errorURIRFC6749AuthorizationResponse},
+		{errCodeInvalidTarget, http.StatusBadRequest, errorURIRFC8693TokenExchange},
+		{errCodeServerError, http.StatusInternalServerError, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.code, func(t *testing.T) {
+			ctx, rec := newCtx()
+			require.NoError(t, oauthError(ctx, tc.code, "desc"))
+			resp := requireOAuthError(t, rec, tc.status, tc.code)
+			require.Equal(t, "desc", resp.ErrorDescription)
+			require.Equal(t, tc.uri, resp.ErrorURI)
+			require.Equal(t, "no-cache", rec.Header().Get("Pragma"))
+			switch tc.code {
+			case errCodeInvalidClient:
+				require.Equal(t, `Basic realm="oauth", error="invalid_client"`, rec.Header().Get("WWW-Authenticate"))
+			case errCodeInvalidToken, errCodeInsufficientScope:
+				require.Equal(t, `Bearer error="`+tc.code+`"`, rec.Header().Get("WWW-Authenticate"))
+			default:
+				require.Empty(t, rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// issuerURL 回傳 OAUTH_ISSUER 設定的 OpenID issuer；服務啟動時即要求設定，
+// 不以請求的 Host header 推得，避免 issuer 與各端點網址被偽造
+func issuerURL() string {
+	return strings.TrimRight(os.Getenv("OAUTH_ISSUER"), "/")
+}
+
+// supportedScopes 回傳 discovery 公開的 scope；無法簽發 id_token 時不列出 openid
+func supportedScopes() []string {
+	scopes := service.SupportedScopes()
+	if openIDAvailable() {
+		return scopes
+	}
+	return slices.DeleteFunc(scopes, func(s string) bool { return s == service.ScopeOpenID })
+}
+
+// OpenIDConfigurationHandler 於 /.well-known/openid-configuration 提供 OpenID Connect Discovery 文件；
+// 路徑不在 /api 之下，因此不列入 swagger
+func OpenIDConfigurationHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		issuer := issuerURL()
+		return c.JSON(http.StatusOK, api.OpenIDConfigurationResponse{
+			Issuer:                                     issuer,
+			AuthorizationEndpoint:                      issuer + "/api/oauth/authorize",
+			TokenEndpoint:                              issuer + "/api/oauth/token",
+			UserInfoEndpoint:                           issuer + "/api/oauth/userinfo",
+			JWKSURI:                                    issuer + "/.well-known/jwks.json",
+			RevocationEndpoint:                         issuer + "/api/oauth/revoke",
+			IntrospectionEndpoint:                      issuer + "/api/oauth/introspect",
+			DeviceAuthorizationEndpoint:                issuer + "/api/oauth/device_authorization",
+			RegistrationEndpoint:                       issuer + "/api/oauth/register",
+			PushedAuthorizationRequestEndpoint:         issuer + "/api/oauth/par",
+			ScopesSupported:                            supportedScopes(),
+			ResponseTypesSupported:                     []string{"code"},
+			GrantTypesSupported:                        service.SupportedGrantTypes,
+			SubjectTypesSupported:                      []string{"public"},
+			IDTokenSigningAlgValuesSupported:           service.IDTokenSigningAlgorithms(),
+			TokenEndpointAuthMethodsSupported:          supportedClientAuthMethods,
+			TokenEndpointAuthSigningAlgValuesSupported: service.ClientAssertionAlgorithms(),
+			CodeChallengeMethodsSupported:              []string{service.PKCEMethodS256, service.PKCEMethodPlain},
+			ClaimsSupported:                            []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "name", "email", "email_verified", "amr", "acr"},
+			ACRValuesSupported:                         []string{service.ACRSingleFactor, service.ACRMultiFactor},
+			TLSClientCertificateBoundAccessTokens:      true,
+			DPoPSigningAlgValuesSupported:              service.DPoPSigningAlgorithms(),
+			// PAR 是否必要由各 client 的設定決定
+			RequirePushedAuthorizationRequests: false,
+		})
+	}
+}
+
+// @Summary     OpenID Connect UserInfo
+// @Description 以 access token 取得使用者的標準 claims：sub 一律提供，name 須具備 profile scope，email 與 email_verified 須具備 email scope
+// @Tags        oauth
+// @Produce     json
+// @Success     200 {object} api.UserInfoResponse
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Password[openid]
+// @Router      /oauth/userinfo [get]
+// @Router      /oauth/userinfo [post]
+func UserInfoHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		// client_credentials 的 token 代表 client 本身，subject 不是使用者
+		if !ok || claims.UserID == 0 || claims.Subject != fmt.Sprint(claims.UserID) {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+		user, err := store.GetUserByID(c.Request().Context(), db, claims.UserID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+			}
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to retrieve user"})
+		}
+		// 依 OIDC Core §5.4 只回傳 token 的 scope 涵蓋的 claims；第一方 token 不受 scope 限制
+		granted := func(scope string) bool {
+			return claims.ClientID == "" || service.HasScope(claims.Scope, scope)
+		}
+		resp := api.UserInfoResponse{Sub: fmt.Sprint(user.ID)}
+		if granted(service.ScopeProfile) {
+			resp.Name = user.Name
+		}
+		if granted(service.ScopeEmail) {
+			resp.Email = user.Email
+			resp.EmailVerified = &user.EmailVerified
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,150 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssuerURL(t *testing.T) {
+	// 不以請求的 Host 推得 issuer
+	t.Setenv("OAUTH_ISSUER", "")
+	require.Empty(t, issuerURL())
+
+	t.Setenv("OAUTH_ISSUER", "https://auth.example.com/")
+	require.Equal(t, "https://auth.example.com", issuerURL())
+}
+
+func TestOpenIDConfigurationHandler(t *testing.T) {
+	t.Setenv("OAUTH_ISSUER", "http://example.com")
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil), rec)
+	require.NoError(t, OpenIDConfigurationHandler()(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp api.OpenIDConfigurationResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "http://example.com", resp.Issuer)
+	require.Equal(t, "http://example.com/api/oauth/authorize", resp.AuthorizationEndpoint)
+	require.Equal(t, "http://example.com/api/oauth/token", resp.TokenEndpoint)
+	require.Equal(t, "http://example.com/api/oauth/userinfo", resp.UserInfoEndpoint)
+	require.Equal(t, "http://example.com/.well-known/jwks.json", resp.JWKSURI)
+	require.Equal(t, "http://example.com/api/oauth/device_authorization", resp.DeviceAuthorizationEndpoint)
+	require.Equal(t, "http://example.com/api/oauth/register", resp.RegistrationEndpoint)
+	require.Equal(t, "http://example.com/api/oauth/par", resp.PushedAuthorizationRequestEndpoint)
+	require.False(t, resp.RequirePushedAuthorizationRequests)
+	require.Contains(t, resp.GrantTypesSupported, service.GrantTypeDeviceCode)
+	require.Contains(t, resp.GrantTypesSupported, service.GrantTypeJWTBearer)
+	require.Equal(t, []string{"client_secret_basic", "client_secret_post", "client_secret_jwt", "private_key_jwt", "none",
+		"tls_client_auth", "self_signed_tls_client_auth"}, resp.TokenEndpointAuthMethodsSupported)
+	require.True(t, resp.TLSClientCertificateBoundAccessTokens)
+	require.Equal(t, service.DPoPSigningAlgorithms(), resp.DPoPSigningAlgValuesSupported)
+	require.Contains(t, resp.TokenEndpointAuthSigningAlgValuesSupported, "ES256")
+	// 未設定非對稱簽章金鑰時不提供 OpenID Connect
+	require.Empty(t, resp.IDTokenSigningAlgValuesSupported)
+	require.NotContains(t, resp.ScopesSupported, "openid")
+	require.Contains(t, resp.ScopesSupported, "users:read")
+	require.Equal(t, []string{"code"}, resp.ResponseTypesSupported)
+	require.Equal(t, []string{"aal1", "aal2"}, resp.ACRValuesSupported)
+	require.Contains(t, resp.ClaimsSupported, "amr")
+
+	openIDAvailable = func() bool { return true }
+	t.Cleanup(func() { openIDAvailable = service.OpenIDAvailable })
+	require.Contains(t, supportedScopes(), "openid")
+}
+
+func TestUserInfoHandler(t *testing.T) {
+	e := echo.New()
+	userClaims := &service.CustomClaims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}}
+
+	newCtx := func(claims any) (echo.Context, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/oauth/userinfo", nil), rec)
+		if claims != nil {
+			ctx.Set(middleware.ContextUserKey, claims)
+		}
+		return ctx, rec
+	}
+	userDB := func(row pgx.Row) *database.FakeDB {
+		return &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row { return row }}
+	}
+
+	t.Run("no claims", func(t *testing.T) {
+		ctx, rec := newCtx(nil)
+		require.NoError(t, UserInfoHandler(&database.FakeDB{})(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("client credentials token", func(t *testing.T) {
+		ctx, rec := newCtx(&service.CustomClaims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{Subject: "cid"}})
+		require.NoError(t, UserInfoHandler(&database.FakeDB{})(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("user not found", func(t *testing.T) {
+		ctx, rec := newCtx(userClaims)
+		require.NoError(t, UserInfoHandler(userDB(&fakeUserRow{err: pgx.ErrNoRows}))(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("db error", func(t *testing.T) {
+		ctx, rec := newCtx(userClaims)
+		require.NoError(t, UserInfoHandler(userDB(&fakeUserRow{err: errors.New("db")}))(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ctx, rec := newCtx(userClaims)
+		row := &fakeUserRow{user: &model.User{ID: 1, Name: "alice", Email: "a@example.com"}}
+		require.NoError(t, UserInfoHandler(userDB(row))(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{"sub":"1","name":"alice","email":"a@example.com","email_verified":false}`, rec.Body.String())
+	})
+
+	t.Run("openid only", func(t *testing.T) {
+		ctx, rec := newCtx(&service.CustomClaims{UserID: 1, ClientID: "cid", Scope: "openid", RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}})
+		row := &fakeUserRow{user: &model.User{ID: 1, Name: "alice", Email: "a@example.com", EmailVerified: true}}
+		require.NoError(t, UserInfoHandler(userDB(row))(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{"sub":"1"}`, rec.Body.String())
+	})
+
+	t.Run("openid and email", func(t *testing.T) {
+		ctx, rec := newCtx(&service.CustomClaims{UserID: 1, ClientID: "cid", Scope: "openid email", RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}})
+		row := &fakeUserRow{user: &model.User{ID: 1, Name: "alice", Email: "a@example.com"}}
+		require.NoError(t, UserInfoHandler(userDB(row))(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{"sub":"1","email":"a@example.com","email_verified":false}`, rec.Body.String())
+	})
+
+	t.Run("openid and profile", func(t *testing.T) {
+		ctx, rec := newCtx(&service.CustomClaims{UserID: 1, ClientID: "cid", Scope: "openid profile", RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}})
+		row := &fakeUserRow{user: &model.User{ID: 1, Name: "alice", Email: "a@example.com"}}
+		require.NoError(t, UserInfoHandler(userDB(row))(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{"sub":"1","name":"alice"}`, rec.Body.String())
+	})
+
+	t.Run("verified email", func(t *testing.T) {
+		ctx, rec := newCtx(userClaims)
+		row := &fakeUserRow{user: &model.User{ID: 1, Name: "alice", Email: "a@example.com", EmailVerified: true}}
+		require.NoError(t, UserInfoHandler(userDB(row))(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{"sub":"1","name":"alice","email":"a@example.com","email_verified":true}`, rec.Body.String())
+	})
+}
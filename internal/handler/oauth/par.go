@@ -0,0 +1,88 @@
+package oauth
+
+import (
+	"net/http"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+// pushedAuthorizationRequestTTL 為 request_uri 的有效期間，RFC 9126 §2.2 建議為短時間
+const pushedAuthorizationRequestTTL = 60 * time.Second
+
+// @Summary     OAuth2 pushed authorization request endpoint
+// @Description 依 RFC 9126 由 client 先在後端推送授權參數並取得一次性的 request_uri，再以 client_id 與 request_uri 導向 /oauth/authorize；client 認證方式與 /oauth/token 相同，參數的檢查與 authorize 端點一致
+// @Tags        oauth
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       Authorization         header   string false "Basic base64(client_id:client_secret)（client_secret_basic）"
+// @Param       client_id             formData string false "Client ID（client_secret_post、mTLS 與 none 必填）"
+// @Param       client_secret         formData string false "Client secret（client_secret_post）"
+// @Param       client_assertion_type formData string false "urn:ietf:params:oauth:client-assertion-type:jwt-bearer（private_key_jwt、client_secret_jwt）"
+// @Param       client_assertion      formData string false "RFC 7523 client assertion JWT（private_key_jwt、client_secret_jwt）"
+// @Param       response_type         formData string true  "必須為 code"
+// @Param       redirect_uri          formData string false "導回網址，需與 client 註冊值完全相符（僅註冊一個時可省略）"
+// @Param       scope                 formData string false "以空白分隔的 scope，未指定時為 client 登記的全部 scope"
+// @Param       state                 formData string false "原樣帶回的 state"
+// @Param       code_challenge        formData string false "PKCE code_challenge"
+// @Param       code_challenge_method formData string false "PKCE 方法：S256 或 plain（預設 plain）"
+// @Param       nonce                 formData string false "OpenID Connect nonce，原樣放入 id_token"
+// @Success     201 {object} api.PushedAuthorizationResponse
+// @Failure     400 {object} api.OAuthErrorResponse
+// @Failure     401 {object} api.OAuthErrorResponse
+// @Failure     500 {object} api.OAuthErrorResponse
+// @Router      /oauth/par [post]
+func PushedAuthorizationHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		noStore(c)
+		var req api.PushedAuthorizationRequest
+		if err := c.Bind(&req); err != nil {
+			return oauthError(c, errCodeInvalidRequest, "invalid request payload")
+		}
+
+		oc, err := authenticateClient(c, db, cache)
+		if err != nil {
+			return clientAuthError(c, err)
+		}
+		// RFC 9126 §2.1：推送的請求不可再引用 request_uri
+		if req.RequestURI != "" {
+			return oauthError(c, errCodeInvalidRequest, "request_uri not allowed")
+		}
+		if _, ok := resolveRedirectURI(oc.RedirectURIs, req.RedirectURI); !ok {
+			return oauthError(c, errCodeInvalidRequest, "invalid redirect_uri")
+		}
+		data := service.PushedAuthorizationData{
+			ClientID:            oc.ClientID,
+			ResponseType:        req.ResponseType,
+			RedirectURI:         req.RedirectURI,
+			Scope:               req.Scope,
+			State:               req.State,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: req.CodeChallengeMethod,
+			Nonce:               req.Nonce,
+		}
+		if _, aerr := validateAuthorizationRequest(oc, api.AuthorizeRequest{
+			ResponseType:        data.ResponseType,
+			ClientID:            data.ClientID,
+			Scope:               data.Scope,
+			CodeChallenge:       data.CodeChallenge,
+			CodeChallengeMethod: data.CodeChallengeMethod,
+		}); aerr != nil {
+			return oauthError(c, aerr.code, aerr.description)
+		}
+
+		requestURI, err := service.PushAuthorizationRequest(c.Request().Context(), cache, data, pushedAuthorizationRequestTTL)
+		if err != nil {
+			return oauthError(c, errCodeServerError, "failed to store authorization request")
+		}
+		return c.JSON(http.StatusCreated, api.PushedAuthorizationResponse{
+			RequestURI: requestURI,
+			ExpiresIn:  int(pushedAuthorizationRequestTTL.Seconds()),
+		})
+	}
+}
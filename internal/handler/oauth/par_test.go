@@ -0,0 +1,129 @@
+package oauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushedAuthorizationHandler(t *testing.T) {
+	openIDAvailable = func() bool { return true }
+	t.Cleanup(func() { openIDAvailable = service.OpenIDAvailable })
+	e := echo.New()
+	now := time.Now()
+	client := &model.OAuthClient{
+		ClientID:     "cid",
+		ClientSecret: "sec",
+		GrantTypes:   []string{"authorization_code"},
+		RedirectURIs: []string{"https://app.example.com/cb"},
+		Scopes:       []string{"openid", "users:read"},
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	clientDB := func(oc *model.OAuthClient) *database.FakeDB {
+		return &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeClientRow{client: oc}
+		}}
+	}
+	validAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("cid:sec"))
+	newReq := func(form, auth string) (echo.Context, *httptest.ResponseRecorder) {
+		ctx, rec := newDeviceCtx(e, http.MethodPost, "/api/oauth/par", echo.MIMEApplicationForm, form, nil)
+		if auth != "" {
+			ctx.Request().Header.Set("Authorization", auth)
+		}
+		return ctx, rec
+	}
+
+	t.Run("bind error", func(t *testing.T) {
+		ctx, rec := newReq("bad%", validAuth)
+		require.NoError(t, PushedAuthorizationHandler(clientDB(client), nil)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidRequest)
+	})
+
+	t.Run("invalid client", func(t *testing.T) {
+		ctx, rec := newReq("response_type=code", "Basic "+base64.StdEncoding.EncodeToString([]byte("cid:nope")))
+		require.NoError(t, PushedAuthorizationHandler(clientDB(client), nil)(ctx))
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		public := *client
+		public.ClientType = model.ClientTypePublic
+		public.TokenEndpointAuthMethod = model.ClientAuthMethodNone
+		public.ClientSecret = ""
+		noGrant := *client
+		noGrant.GrantTypes = []string{"client_credentials"}
+		cases := map[string]struct {
+			client *model.OAuthClient
+			form   string
+			auth   string
+			code   string
+		}{
+			"nested request_uri":      {client, "response_type=code&request_uri=" + service.RequestURIPrefix + "x", validAuth, errCodeInvalidRequest},
+			"redirect uri mismatch":   {client, "response_type=code&redirect_uri=https://evil.example.com/cb", validAuth, errCodeInvalidRequest},
+			"response type":           {client, "response_type=token", validAuth, errCodeUnsupportedResponseType},
+			"grant not allowed":       {&noGrant, "response_type=code", validAuth, errCodeUnauthorizedClient},
+			"public without pkce":     {&public, "response_type=code&client_id=cid", "", errCodeInvalidRequest},
+			"unsupported pkce":        {client, "response_type=code&code_challenge=abc&code_challenge_method=S512", validAuth, errCodeInvalidRequest},
+			"scope outside of client": {client, "response_type=code&scope=users:write", validAuth, errCodeInvalidScope},
+		}
+		for name, tc := range cases {
+			ctx, rec := newReq(tc.form, tc.auth)
+			require.NoError(t, PushedAuthorizationHandler(clientDB(tc.client), nil)(ctx), name)
+			requireOAuthError(t, rec, http.StatusBadRequest, tc.code)
+		}
+	})
+
+	t.Run("store fail", func(t *testing.T) {
+		cch := &cache.FakeCache{SetFn: func(context.Context, string, any, time.Duration) *redis.StatusCmd {
+			return redis.NewStatusResult("", errors.New("set"))
+		}}
+		ctx, rec := newReq("response_type=code", validAuth)
+		require.NoError(t, PushedAuthorizationHandler(clientDB(client), cch)(ctx))
+		requireOAuthError(t, rec, http.StatusInternalServerError, errCodeServerError)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		cch := cache.NewMemoryCache(nil)
+		ctx, rec := newReq("response_type=code&state=st&scope=openid&code_challenge=abc&code_challenge_method=S256&nonce=n1"+
+			"&redirect_uri=https://app.example.com/cb", validAuth)
+		require.NoError(t, PushedAuthorizationHandler(clientDB(client), cch)(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+		var resp api.PushedAuthorizationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.True(t, strings.HasPrefix(resp.RequestURI, service.RequestURIPrefix))
+		require.Equal(t, 60, resp.ExpiresIn)
+
+		data, err := service.ConsumePushedAuthorizationRequest(context.Background(), cch, "cid", resp.RequestURI)
+		require.NoError(t, err)
+		require.Equal(t, &service.PushedAuthorizationData{
+			ClientID:            "cid",
+			ResponseType:        "code",
+			RedirectURI:         "https://app.example.com/cb",
+			Scope:               "openid",
+			State:               "st",
+			CodeChallenge:       "abc",
+			CodeChallengeMethod: "S256",
+			Nonce:               "n1",
+		}, data)
+	})
+}
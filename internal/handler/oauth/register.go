@@ -0,0 +1,277 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// openRegistration 回傳是否允許未帶 initial access token 的匿名註冊（OAUTH_OPEN_REGISTRATION）
+func openRegistration() bool {
+	open, _ := strconv.ParseBool(os.Getenv("OAUTH_OPEN_REGISTRATION"))
+	return open
+}
+
+// @Summary     OAuth2 dynamic client registration
+// @Description 依 RFC 7591 註冊 client。預設須以使用者的 access token 作為 initial access token（經由 OAuth client 取得者須具備 clients:manage），該使用者即為 client 擁有者；設定 OAUTH_OPEN_REGISTRATION=true 時允許匿名註冊，此類 client 沒有擁有者。client_secret 與 registration_access_token 僅在此回應中出現一次，後者用於 RFC 7592 管理自身的註冊資料。token_endpoint_auth_method 為 none 的 client 為 public client
+// @Tags        oauth
+// @Accept      json
+// @Produce     json
+// @Param       Authorization header string false "Bearer initial access token"
+// @Param       request       body   api.OAuthClientRegistrationRequest true "Client metadata"
+// @Success     201 {object} api.OAuthClientRegistrationResponse
+// @Failure     400 {object} api.OAuthErrorResponse
+// @Failure     401 {object} api.OAuthErrorResponse
+// @Failure     403 {object} api.OAuthErrorResponse
+// @Failure     500 {object} api.OAuthErrorResponse
+// @Router      /oauth/register [post]
+func RegisterClientHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		noStore(c)
+		var req api.OAuthClientRegistrationRequest
+		if err := c.Bind(&req); err != nil {
+			return oauthError(c, errCodeInvalidClientMetadata, "invalid request payload")
+		}
+		if err := c.Validate(&req); err != nil {
+			return oauthError(c, errCodeInvalidClientMetadata, err.Error())
+		}
+
+		// initial access token 由 OptionalAuth 驗證；持有者成為 client 擁有者
+		client := &model.OAuthClient{}
+		claims, _ := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		switch {
+		case claims == nil:
+			if !openRegistration() {
+				return oauthError(c, errCodeInvalidToken, "initial access token required")
+			}
+		case claims.UserID == 0:
+			return oauthError(c, errCodeInvalidToken, "initial access token must belong to a user")
+		case claims.ClientID != "" && !service.HasScope(claims.Scope, service.ScopeClientsManage):
+			return oauthError(c, errCodeInsufficientScope, "initial access token requires the clients:manage scope")
+		default:
+			client.UserID = claims.UserID
+		}
+
+		clientID, err := service.NewClientID()
+		if err != nil {
+			return oauthError(c, errCodeServerError, "failed to generate client_id")
+		}
+		client.ClientID = clientID
+		applyClientMetadata(client, req)
+		if err := service.ValidateOAuthClient(client); err != nil {
+			return clientMetadataError(c, err)
+		}
+
+		var secret string
+		if service.NeedsClientSecret(client) {
+			if secret, err = service.RotateClientSecret(client, 0); err != nil {
+				return oauthError(c, errCodeServerError, "failed to generate client secret")
+			}
+		}
+		token, err := service.IssueRegistrationAccessToken(client)
+		if err != nil {
+			return oauthError(c, errCodeServerError, "failed to issue registration access token")
+		}
+		if err := store.CreateOAuthClient(c.Request().Context(), db, client); err != nil {
+			return oauthError(c, errCodeServerError, "failed to register client")
+		}
+
+		resp := newClientRegistrationResponse(c, *client)
+		resp.ClientSecret = secret
+		resp.RegistrationAccessToken = token
+		return c.JSON(http.StatusCreated, resp)
+	}
+}
+
+// @Summary     OAuth2 read client registration
+// @Description 依 RFC 7592 §2.1 以 registration_access_token 讀取 client 的註冊資料
+// @Tags        oauth
+// @Produce     json
+// @Param       Authorization header string true "Bearer registration access token"
+// @Param       client_id     path   string true "Client ID"
+// @Success     200 {object} api.OAuthClientRegistrationResponse
+// @Failure     401 {object} api.OAuthErrorResponse
+// @Failure     500 {object} api.OAuthErrorResponse
+// @Router      /oauth/register/{client_id} [get]
+func GetClientRegistrationHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		noStore(c)
+		client, err := authenticateRegistration(c, db)
+		if err != nil {
+			return registrationAuthError(c, err)
+		}
+		return c.JSON(http.StatusOK, newClientRegistrationResponse(c, *client))
+	}
+}
+
+// @Summary     OAuth2 update client registration
+// @Description 依 RFC 7592 §2.2 以請求內容取代 client 的註冊資料，body 的 client_id 須與路徑相同；改用以 secret 認證的方式而尚無可用的 secret 時，會產生新的 client_secret 並僅在此回應中出現一次
+// @Tags        oauth
+// @Accept      json
+// @Produce     json
+// @Param       Authorization header string true "Bearer registration access token"
+// @Param       client_id     path   string true "Client ID"
+// @Param       request       body   api.OAuthClientRegistrationRequest true "Client metadata"
+// @Success     200 {object} api.OAuthClientRegistrationResponse
+// @Failure     400 {object} api.OAuthErrorResponse
+// @Failure     401 {object} api.OAuthErrorResponse
+// @Failure     500 {object} api.OAuthErrorResponse
+// @Router      /oauth/register/{client_id} [put]
+func UpdateClientRegistrationHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		noStore(c)
+		client, err := authenticateRegistration(c, db)
+		if err != nil {
+			return registrationAuthError(c, err)
+		}
+
+		var req api.OAuthClientRegistrationRequest
+		if err := c.Bind(&req); err != nil {
+			return oauthError(c, errCodeInvalidClientMetadata, "invalid request payload")
+		}
+		if err := c.Validate(&req); err != nil {
+			return oauthError(c, errCodeInvalidClientMetadata, err.Error())
+		}
+		if req.ClientID != client.ClientID {
+			return oauthError(c, errCodeInvalidClientMetadata, "client_id does not match the registration")
+		}
+
+		applyClientMetadata(client, req)
+		if err := service.ValidateOAuthClient(client); err != nil {
+			return clientMetadataError(c, err)
+		}
+
+		var secret string
+		if service.NeedsClientSecret(client) {
+			if secret, err = service.RotateClientSecret(client, 0); err != nil {
+				return oauthError(c, errCodeServerError, "failed to generate client secret")
+			}
+		}
+		ctx := c.Request().Context()
+		if err := store.UpdateOAuthClient(ctx, db, client); err != nil {
+			return oauthError(c, errCodeServerError, "failed to update client")
+		}
+		if secret != "" {
+			if err := store.UpdateOAuthClientSecret(ctx, db, client); err != nil {
+				return oauthError(c, errCodeServerError, "failed to update client")
+			}
+		}
+
+		resp := newClientRegistrationResponse(c, *client)
+		resp.ClientSecret = secret
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// @Summary     OAuth2 delete client registration
+// @Description 依 RFC 7592 §2.3 刪除 client，之後 client_id 與 registration_access_token 皆失效
+// @Tags        oauth
+// @Param       Authorization header string true "Bearer registration access token"
+// @Param       client_id     path   string true "Client ID"
+// @Success     204
+// @Failure     401 {object} api.OAuthErrorResponse
+// @Failure     500 {object} api.OAuthErrorResponse
+// @Router      /oauth/register/{client_id} [delete]
+func DeleteClientRegistrationHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		noStore(c)
+		client, err := authenticateRegistration(c, db)
+		if err != nil {
+			return registrationAuthError(c, err)
+		}
+		if err := store.DeleteOAuthClient(c.Request().Context(), db, client.ClientID); err != nil {
+			return oauthError(c, errCodeServerError, "failed to delete client")
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// authenticateRegistration 以 Bearer registration access token 驗證路徑中的 client
+func authenticateRegistration(c echo.Context, db database.DB) (*model.OAuthClient, error) {
+	scheme, token, ok := strings.Cut(c.Request().Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return nil, service.ErrInvalidRegistrationAccessToken
+	}
+	client, err := store.GetOAuthClientByClientID(c.Request().Context(), db, c.Param("client_id"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, service.ErrInvalidRegistrationAccessToken
+		}
+		return nil, err
+	}
+	if err := service.VerifyRegistrationAccessToken(client, token); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// registrationAuthError 依 RFC 7592 §2：token 無效或 client 不存在時一律回傳 401，不透露 client 是否存在
+func registrationAuthError(c echo.Context, err error) error {
+	if errors.Is(err, service.ErrInvalidRegistrationAccessToken) {
+		return oauthError(c, errCodeInvalidToken, err.Error())
+	}
+	return oauthError(c, errCodeServerError, "failed to retrieve client")
+}
+
+// clientMetadataError 將 ValidateOAuthClient 的錯誤對應到 RFC 7591 §3.2.2 的錯誤碼
+func clientMetadataError(c echo.Context, err error) error {
+	if errors.Is(err, service.ErrInvalidRedirectURI) {
+		return oauthError(c, errCodeInvalidRedirectURI, err.Error())
+	}
+	return oauthError(c, errCodeInvalidClientMetadata, err.Error())
+}
+
+// applyClientMetadata 以 RFC 7591 metadata 取代 client 的設定；grant_types 預設為 authorization_code，
+// token_endpoint_auth_method 為 none 的 client 為 public client
+func applyClientMetadata(client *model.OAuthClient, req api.OAuthClientRegistrationRequest) {
+	client.ClientType = model.ClientTypeConfidential
+	if req.TokenEndpointAuthMethod == model.ClientAuthMethodNone {
+		client.ClientType = model.ClientTypePublic
+	}
+	client.GrantTypes = req.GrantTypes
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{"authorization_code"}
+	}
+	client.ClientName = req.ClientName
+	client.LogoURI = req.LogoURI
+	client.RedirectURIs = req.RedirectURIs
+	client.Scopes = strings.Fields(req.Scope)
+	client.TokenEndpointAuthMethod = req.TokenEndpointAuthMethod
+	client.JWKS = req.JWKS
+	client.TLSClientAuthSubjectDN = req.TLSClientAuthSubjectDN
+	client.TLSClientCertificateThumbprint = req.TLSClientCertificateThumbprint
+	client.TLSClientCertificateBoundAccessTokens = req.TLSClientCertificateBoundAccessTokens
+	client.RequirePushedAuthorizationRequests = req.RequirePushedAuthorizationRequests
+}
+
+// newClientRegistrationResponse 轉換為 RFC 7591 §3.2.1 回應格式；client_secret 不會過期
+func newClientRegistrationResponse(c echo.Context, client model.OAuthClient) api.OAuthClientRegistrationResponse {
+	return api.OAuthClientRegistrationResponse{
+		ClientID:                              client.ClientID,
+		ClientIDIssuedAt:                      client.CreatedAt.Unix(),
+		RegistrationClientURI:                 issuerURL() + "/api/oauth/register/" + client.ClientID,
+		RedirectURIs:                          client.RedirectURIs,
+		TokenEndpointAuthMethod:               client.TokenEndpointAuthMethod,
+		GrantTypes:                            client.GrantTypes,
+		ClientName:                            client.ClientName,
+		LogoURI:                               client.LogoURI,
+		Scope:                                 strings.Join(client.Scopes, " "),
+		JWKS:                                  client.JWKS,
+		TLSClientAuthSubjectDN:                client.TLSClientAuthSubjectDN,
+		TLSClientCertificateThumbprint:        client.TLSClientCertificateThumbprint,
+		TLSClientCertificateBoundAccessTokens: client.TLSClientCertificateBoundAccessTokens,
+		RequirePushedAuthorizationRequests:    client.RequirePushedAuthorizationRequests,
+	}
+}
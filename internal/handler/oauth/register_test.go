@@ -0,0 +1,264 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegistrationRow 模擬 CreateOAuthClient（client_id, created_at, updated_at）與 UpdateOAuthClient（updated_at）的回傳
+type fakeRegistrationRow struct {
+	now time.Time
+	err error
+}
+
+func (r *fakeRegistrationRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	for _, d := range dest {
+		if t, ok := d.(*time.Time); ok {
+			*t = r.now
+		}
+	}
+	return nil
+}
+
+func newRegisterCtx(e *echo.Echo, method, clientID, body, auth string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, "/api/oauth/register", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(req, rec)
+	if clientID != "" {
+		ctx.SetParamNames("client_id")
+		ctx.SetParamValues(clientID)
+	}
+	return ctx, rec
+}
+
+func TestRegisterClientHandler(t *testing.T) {
+	t.Setenv("OAUTH_ISSUER", "http://example.com")
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	now := time.Unix(1700000000, 0)
+	var created *model.OAuthClient
+	db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, args ...any) pgx.Row {
+		created = &model.OAuthClient{
+			ClientID:                args[0].(string),
+			ClientSecret:            args[1].(string),
+			UserID:                  args[2].(int),
+			TokenEndpointAuthMethod: args[9].(string),
+			RegistrationAccessToken: args[15].(string),
+		}
+		return &fakeRegistrationRow{now: now}
+	}}
+	body := `{"redirect_uris":["https://app.example.com/cb"],"client_name":"Partner","scope":"openid users:read"}`
+	withClaims := func(ctx echo.Context, claims *service.CustomClaims) echo.Context {
+		ctx.Set(middleware.ContextUserKey, claims)
+		return ctx
+	}
+
+	t.Run("initial access token required", func(t *testing.T) {
+		ctx, rec := newRegisterCtx(e, http.MethodPost, "", body, "")
+		require.NoError(t, RegisterClientHandler(db)(ctx))
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidToken)
+	})
+
+	t.Run("initial access token", func(t *testing.T) {
+		ctx, rec := newRegisterCtx(e, http.MethodPost, "", body, "")
+		require.NoError(t, RegisterClientHandler(db)(withClaims(ctx, &service.CustomClaims{UserID: 7})))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		var resp api.OAuthClientRegistrationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, created.ClientID, resp.ClientID)
+		require.Equal(t, 7, created.UserID)
+		require.Equal(t, now.Unix(), resp.ClientIDIssuedAt)
+		require.Equal(t, "http://example.com/api/oauth/register/"+resp.ClientID, resp.RegistrationClientURI)
+		require.Equal(t, []string{"authorization_code"}, resp.GrantTypes)
+		require.Equal(t, model.ClientAuthMethodSecretBasic, resp.TokenEndpointAuthMethod)
+		require.Equal(t, "openid users:read", resp.Scope)
+		require.NotEmpty(t, resp.ClientSecret)
+		require.True(t, service.VerifyClientSecret(created, resp.ClientSecret))
+		require.NoError(t, service.VerifyRegistrationAccessToken(created, resp.RegistrationAccessToken))
+	})
+
+	t.Run("initial access token scope", func(t *testing.T) {
+		ctx, rec := newRegisterCtx(e, http.MethodPost, "", body, "")
+		require.NoError(t, RegisterClientHandler(db)(withClaims(ctx, &service.CustomClaims{UserID: 7, ClientID: "cid", Scope: "users:read"})))
+		requireOAuthError(t, rec, http.StatusForbidden, errCodeInsufficientScope)
+
+		ctx, rec = newRegisterCtx(e, http.MethodPost, "", body, "")
+		require.NoError(t, RegisterClientHandler(db)(withClaims(ctx, &service.CustomClaims{ClientID: "cid", Scope: "clients:manage"})))
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidToken)
+	})
+
+	t.Run("open registration", func(t *testing.T) {
+		t.Setenv("OAUTH_OPEN_REGISTRATION", "true")
+		ctx, rec := newRegisterCtx(e, http.MethodPost, "", `{"redirect_uris":["https://app.example.com/cb"],"token_endpoint_auth_method":"none"}`, "")
+		require.NoError(t, RegisterClientHandler(db)(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var resp api.OAuthClientRegistrationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Zero(t, created.UserID)
+		require.Empty(t, resp.ClientSecret)
+		require.Equal(t, model.ClientAuthMethodNone, resp.TokenEndpointAuthMethod)
+	})
+
+	t.Run("invalid metadata", func(t *testing.T) {
+		t.Setenv("OAUTH_OPEN_REGISTRATION", "true")
+		ctx, rec := newRegisterCtx(e, http.MethodPost, "", "{", "")
+		require.NoError(t, RegisterClientHandler(db)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidClientMetadata)
+
+		ctx, rec = newRegisterCtx(e, http.MethodPost, "", `{"redirect_uris":["http://app.example.com/cb"]}`, "")
+		require.NoError(t, RegisterClientHandler(db)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidRedirectURI)
+
+		ctx, rec = newRegisterCtx(e, http.MethodPost, "", `{"redirect_uris":["https://app.example.com/cb"],"scope":"admin"}`, "")
+		require.NoError(t, RegisterClientHandler(db)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidClientMetadata)
+
+		ctx, rec = newRegisterCtx(e, http.MethodPost, "", `{"redirect_uris":["https://app.example.com/cb"],"grant_types":["authorization_code","implicit"]}`, "")
+		require.NoError(t, RegisterClientHandler(db)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidClientMetadata)
+
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec = newRegisterCtx(e, http.MethodPost, "", body, "")
+		require.NoError(t, RegisterClientHandler(db)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidClientMetadata)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		t.Setenv("OAUTH_OPEN_REGISTRATION", "true")
+		failing := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeRegistrationRow{err: errors.New("db")}
+		}}
+		ctx, rec := newRegisterCtx(e, http.MethodPost, "", body, "")
+		require.NoError(t, RegisterClientHandler(failing)(ctx))
+		requireOAuthError(t, rec, http.StatusInternalServerError, errCodeServerError)
+	})
+}
+
+func TestClientRegistrationManagement(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	now := time.Unix(1700000000, 0)
+	client := &model.OAuthClient{
+		ClientID:                "dyn",
+		ClientType:              model.ClientTypePublic,
+		GrantTypes:              []string{"authorization_code"},
+		RedirectURIs:            []string{"https://app.example.com/cb"},
+		TokenEndpointAuthMethod: model.ClientAuthMethodNone,
+		CreatedAt:               now,
+		UpdatedAt:               now,
+	}
+	token, err := service.IssueRegistrationAccessToken(client)
+	require.NoError(t, err)
+	var updates []string
+	db := &database.FakeDB{
+		QueryRowFn: func(_ context.Context, q string, args ...any) pgx.Row {
+			if strings.HasPrefix(strings.TrimSpace(q), "UPDATE") {
+				updates = append(updates, q)
+				return &fakeRegistrationRow{now: now}
+			}
+			if args[0] != client.ClientID {
+				return &fakeClientRow{err: pgx.ErrNoRows}
+			}
+			c := *client
+			return &fakeClientRow{client: &c}
+		},
+		ExecFn: func(context.Context, string, ...any) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag{}, nil
+		},
+	}
+	bearer := "Bearer " + token
+
+	t.Run("authentication", func(t *testing.T) {
+		for name, tc := range map[string]struct{ clientID, auth string }{
+			"missing token":  {"dyn", ""},
+			"basic":          {"dyn", "Basic abc"},
+			"wrong token":    {"dyn", "Bearer other"},
+			"unknown client": {"missing", bearer},
+		} {
+			ctx, rec := newRegisterCtx(e, http.MethodGet, tc.clientID, "", tc.auth)
+			require.NoError(t, GetClientRegistrationHandler(db)(ctx), name)
+			requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidToken)
+		}
+
+		failing := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeClientRow{err: errors.New("db")}
+		}}
+		ctx, rec := newRegisterCtx(e, http.MethodGet, "dyn", "", bearer)
+		require.NoError(t, GetClientRegistrationHandler(failing)(ctx))
+		requireOAuthError(t, rec, http.StatusInternalServerError, errCodeServerError)
+	})
+
+	t.Run("read", func(t *testing.T) {
+		ctx, rec := newRegisterCtx(e, http.MethodGet, "dyn", "", bearer)
+		require.NoError(t, GetClientRegistrationHandler(db)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.OAuthClientRegistrationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, "dyn", resp.ClientID)
+		require.Empty(t, resp.RegistrationAccessToken)
+		require.Equal(t, []string{"https://app.example.com/cb"}, resp.RedirectURIs)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		ctx, rec := newRegisterCtx(e, http.MethodPut, "dyn", `{"client_id":"other","redirect_uris":["https://app.example.com/cb"]}`, bearer)
+		require.NoError(t, UpdateClientRegistrationHandler(db)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidClientMetadata)
+
+		ctx, rec = newRegisterCtx(e, http.MethodPut, "dyn", `{"client_id":"dyn","redirect_uris":["https://*.example.com/cb"]}`, bearer)
+		require.NoError(t, UpdateClientRegistrationHandler(db)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidRedirectURI)
+		require.Empty(t, updates)
+
+		// 改為 confidential client 時產生 secret
+		ctx, rec = newRegisterCtx(e, http.MethodPut, "dyn", `{"client_id":"dyn","redirect_uris":["https://app.example.com/new"],"client_name":"Renamed"}`, bearer)
+		require.NoError(t, UpdateClientRegistrationHandler(db)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.OAuthClientRegistrationResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, "Renamed", resp.ClientName)
+		require.Equal(t, []string{"https://app.example.com/new"}, resp.RedirectURIs)
+		require.Equal(t, model.ClientAuthMethodSecretBasic, resp.TokenEndpointAuthMethod)
+		require.NotEmpty(t, resp.ClientSecret)
+		require.Len(t, updates, 2)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		ctx, rec := newRegisterCtx(e, http.MethodDelete, "dyn", "", bearer)
+		require.NoError(t, DeleteClientRegistrationHandler(db)(ctx))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+
+		failing := *db
+		failing.ExecFn = func(context.Context, string, ...any) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag{}, errors.New("db")
+		}
+		ctx, rec = newRegisterCtx(e, http.MethodDelete, "dyn", "", bearer)
+		require.NoError(t, DeleteClientRegistrationHandler(&failing)(ctx))
+		requireOAuthError(t, rec, http.StatusInternalServerError, errCodeServerError)
+	})
+}
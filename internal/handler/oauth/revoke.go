@@ -0,0 +1,52 @@
+package oauth
+
+import (
+	"net/http"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+// @Summary     OAuth2 revoke token
+// @Description 依 RFC 7009 撤銷 access token 或 refresh token；無效或不屬於該 client 的 token 也回傳 200
+// @Tags        oauth
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       Authorization         header   string false "Basic base64(client_id:client_secret)（client_secret_basic）"
+// @Param       client_id             formData string false "Client ID（client_secret_post、mTLS 與 none 必填）"
+// @Param       client_secret         formData string false "Client secret（client_secret_post）"
+// @Param       client_assertion_type formData string false "urn:ietf:params:oauth:client-assertion-type:jwt-bearer（private_key_jwt、client_secret_jwt）"
+// @Param       client_assertion      formData string false "RFC 7523 client assertion JWT（private_key_jwt、client_secret_jwt）"
+// @Param       token                 formData string true  "要撤銷的 token"
+// @Param       token_type_hint       formData string false "Token 類型提示：access_token 或 refresh_token"
+// @Success     200
+// @Failure     400 {object} api.OAuthErrorResponse
+// @Failure     401 {object} api.OAuthErrorResponse
+// @Failure     500 {object} api.OAuthErrorResponse
+// @Router      /oauth/revoke [post]
+func RevokeHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		noStore(c)
+		var req api.RevokeRequest
+		if err := c.Bind(&req); err != nil {
+			return oauthError(c, errCodeInvalidRequest, "invalid request payload")
+		}
+		if err := c.Validate(&req); err != nil {
+			return oauthError(c, errCodeInvalidRequest, err.Error())
+		}
+
+		oc, err := authenticateClient(c, db, cache)
+		if err != nil {
+			return clientAuthError(c, err)
+		}
+
+		if err := service.RevokeToken(c.Request().Context(), cache, oc.ClientID, req.Token, req.TokenTypeHint); err != nil {
+			return oauthError(c, errCodeServerError, "failed to revoke token")
+		}
+		return c.NoContent(http.StatusOK)
+	}
+}
@@ -0,0 +1,98 @@
+package oauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func newRevokeCtx(e *echo.Echo, form string, auth string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/revoke", strings.NewReader(form))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	return e.NewContext(req, rec), rec
+}
+
+func TestRevokeHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	now := time.Now()
+	client := &model.OAuthClient{ClientID: "cid", ClientSecret: "sec", GrantTypes: []string{"password"}, CreatedAt: now, UpdatedAt: now}
+	db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+		return &fakeClientRow{client: client}
+	}}
+	validAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("cid:sec"))
+
+	t.Run("bind error", func(t *testing.T) {
+		ctx, rec := newRevokeCtx(e, "bad%", validAuth)
+		require.NoError(t, RevokeHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("validate error", func(t *testing.T) {
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newRevokeCtx(e, "", validAuth)
+		require.NoError(t, RevokeHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("missing client auth", func(t *testing.T) {
+		ctx, rec := newRevokeCtx(e, "token=t", "")
+		require.NoError(t, RevokeHandler(db, nil)(ctx))
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
+	})
+
+	t.Run("wrong client secret", func(t *testing.T) {
+		bad := "Basic " + base64.StdEncoding.EncodeToString([]byte("cid:nope"))
+		ctx, rec := newRevokeCtx(e, "token=t", bad)
+		require.NoError(t, RevokeHandler(db, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("cache error", func(t *testing.T) {
+		cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+			return redis.NewStringResult("", errors.New("get"))
+		}}
+		ctx, rec := newRevokeCtx(e, "token=t&token_type_hint=refresh_token", validAuth)
+		require.NoError(t, RevokeHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		data, _ := json.Marshal(service.RefreshTokenData{UserID: 1, ClientID: "cid"})
+		deleted := false
+		cch := &cache.FakeCache{
+			GetFn: func(_ context.Context, key string) *redis.StringCmd {
+				if strings.HasPrefix(key, "revoked_grant:") || strings.HasPrefix(key, "revoked_user_sessions:") {
+					return redis.NewStringResult("", redis.Nil)
+				}
+				return redis.NewStringResult(string(data), nil)
+			},
+			DelFn: func(context.Context, ...string) *redis.IntCmd { deleted = true; return redis.NewIntResult(1, nil) },
+		}
+		ctx, rec := newRevokeCtx(e, "token=t", validAuth)
+		require.NoError(t, RevokeHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.True(t, deleted)
+	})
+}
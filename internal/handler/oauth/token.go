@@ -1,9 +1,10 @@
 package oauth
 
 import (
-	"encoding/base64"
+	"errors"
+	"fmt"
 	"net/http"
-	"strings"
+	"slices"
 	"time"
 
 	"life-is-hard/internal/api"
@@ -16,124 +17,391 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+var issueIDToken = service.IssueIDToken
+
 // @Summary     OAuth2 obtain access token
-// @Description Issue a JWT access token (and refresh token if applicable) using OAuth2 grant_type
+// @Description Issue a JWT access token (and a refresh token where applicable) for the given grant_type; per-grant parameters and behaviour are described on each parameter. Clients authenticate with their registered token_endpoint_auth_method. Token lifetimes (expires_in) and the access token aud follow the client's configuration, and tokens carry amr and acr claims describing how the user authenticated. Errors follow RFC 6749 §5.2; invalid_client responses are 401 with a WWW-Authenticate header
 // @Tags        oauth
 // @Accept      application/x-www-form-urlencoded
 // @Produce     json
-// @Param       Authorization header string true  "Basic base64(client_id:client_secret)"
-// @Param       grant_type     formData string true  "Grant type: password, client_credentials, or refresh_token"
-// @Param       username       formData string false "Username (required for password grant)"
-// @Param       password       formData string false "Password (required for password grant)"
-// @Param       refresh_token  formData string false "Refresh token (required for refresh_token grant)"
+// @Param       Authorization         header   string false "Basic base64(client_id:client_secret) (client_secret_basic)"
+// @Param       DPoP                  header   string false "DPoP proof JWT (RFC 9449) carrying the server nonce; binds the access token to the proof key (cnf.jkt, token_type DPoP). A missing or stale nonce fails with use_dpop_nonce and a DPoP-Nonce header"
+// @Param       client_id             formData string false "Client ID (required for client_secret_post, tls_client_auth, self_signed_tls_client_auth and none). With tls_client_certificate_bound_access_tokens the token is bound to the presented certificate (cnf.x5t#S256)"
+// @Param       client_secret         formData string false "Client secret (client_secret_post)"
+// @Param       client_assertion_type formData string false "urn:ietf:params:oauth:client-assertion-type:jwt-bearer (private_key_jwt, client_secret_jwt)"
+// @Param       client_assertion      formData string false "RFC 7523 client assertion JWT (private_key_jwt, client_secret_jwt); each jti is usable once"
+// @Param       grant_type            formData string true  "Grant type: password, client_credentials, refresh_token, authorization_code, urn:ietf:params:oauth:grant-type:device_code, urn:ietf:params:oauth:grant-type:token-exchange, or urn:ietf:params:oauth:grant-type:jwt-bearer"
+// @Param       username              formData string false "Username (required for password grant)"
+// @Param       password              formData string false "Password (required for password grant)"
+// @Param       otp                   formData string false "TOTP code or recovery code (required for password grant when the user has enabled MFA, otherwise fails with mfa_required)"
+// @Param       refresh_token         formData string false "Refresh token (required for refresh_token grant). Single-use: a new one is returned, replaying a used token revokes its family, and rotation never outlives the absolute lifetime or idle timeout"
+// @Param       code                  formData string false "Authorization code (required for authorization_code grant); with the openid scope the response also includes an id_token"
+// @Param       redirect_uri          formData string false "Redirect URI (required for authorization_code grant if sent to /oauth/authorize)"
+// @Param       code_verifier         formData string false "PKCE code verifier (required for authorization_code grant if code_challenge was sent)"
+// @Param       device_code           formData string false "Device code (required for device_code grant, RFC 8628); fails with authorization_pending, slow_down, access_denied or expired_token until the user approves"
+// @Param       scope                 formData string false "Space-delimited scopes; defaults to all scopes registered for the client, and may only narrow the original grant on refresh_token"
+// @Param       subject_token         formData string false "Access token of the user on whose behalf the request is made (required for token-exchange grant, RFC 8693). A sender-constrained token needs the same DPoP key or client certificate. The issued token carries an act claim, only narrows the scope, expires no later than the subject token and has no refresh token"
+// @Param       subject_token_type    formData string false "urn:ietf:params:oauth:token-type:access_token (required for token-exchange grant)"
+// @Param       actor_token           formData string false "Access token of the acting party (token-exchange grant; defaults to the client itself). A sender-constrained token needs the same DPoP key or client certificate"
+// @Param       actor_token_type      formData string false "urn:ietf:params:oauth:token-type:access_token (required with actor_token)"
+// @Param       audience              formData []string false "Target service(s) of the exchanged token, allowed by the client's token_exchange_audiences (required for token-exchange grant)" collectionFormat(multi)
+// @Param       requested_token_type  formData string false "urn:ietf:params:oauth:token-type:access_token (the only supported type)"
+// @Param       assertion             formData string false "JWT signed by a trusted issuer, whose sub is mapped to the client and each jti usable once (required for jwt-bearer grant, RFC 7523); no refresh token is issued"
 // @Success     200 {object} api.TokenResponse
-// @Failure     400 {object} api.ErrorResponse
-// @Failure     401 {object} api.ErrorResponse
-// @Failure     500 {object} api.ErrorResponse
+// @Failure     400 {object} api.OAuthErrorResponse
+// @Failure     401 {object} api.OAuthErrorResponse
+// @Failure     500 {object} api.OAuthErrorResponse
 // @Router      /oauth/token [post]
 func TokenHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		noStore(c)
 		ctx := c.Request().Context()
 		var req api.TokenRequest
 		if err := c.Bind(&req); err != nil {
-			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid request payload"})
+			return oauthError(c, errCodeInvalidRequest, "invalid request payload")
 		}
 
-		// 解析 Basic 認證
-		auth := c.Request().Header.Get("Authorization")
-		const prefix = "Basic "
-		if !strings.HasPrefix(auth, prefix) {
-			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid authorization header"})
-		}
-		decoded, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+		// 驗證 client
+		oc, err := authenticateClient(c, db, cache)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid authorization header"})
+			return clientAuthError(c, err)
+		}
+		req.ClientID = oc.ClientID
+
+		// 檢查 grant_type
+		if req.GrantType == "" {
+			return oauthError(c, errCodeInvalidRequest, "missing grant_type")
+		}
+		if !hasGrantType(service.SupportedGrantTypes, req.GrantType) {
+			return oauthError(c, errCodeUnsupportedGrantType, "unsupported grant_type")
 		}
-		parts := strings.SplitN(string(decoded), ":", 2)
-		if len(parts) != 2 {
-			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid authorization header"})
+		if !hasGrantType(oc.GrantTypes, req.GrantType) {
+			return oauthError(c, errCodeUnauthorizedClient, "grant_type not allowed for this client")
 		}
-		req.ClientID = parts[0]
-		req.ClientSecret = parts[1]
 
-		// 驗證 client
-		oc, err := store.GetOAuthClientByClientID(ctx, db, req.ClientID)
-		if err != nil || oc.ClientSecret != req.ClientSecret {
-			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid client credentials"})
+		// RFC 8705 §3：client 登記要求時，access token 綁定至此次連線出示的 client 憑證
+		var cnf *service.Confirmation
+		if oc.TLSClientCertificateBoundAccessTokens {
+			if cnf = service.CertificateConfirmation(peerCertificates(c)); cnf == nil {
+				return oauthError(c, errCodeInvalidRequest, "client certificate required for certificate-bound access tokens")
+			}
 		}
 
-		// 檢查 grant_type
-		allowed := false
-		for _, gt := range oc.GrantTypes {
-			if gt == req.GrantType {
-				allowed = true
-				break
+		// RFC 9449：帶有 DPoP proof 時，access token 綁定至 proof 的公鑰（cnf.jkt）
+		jkt, err := verifyTokenDPoP(c, cache)
+		if err != nil {
+			return dpopError(c, cache, err)
+		}
+		if jkt != "" {
+			if cnf == nil {
+				cnf = &service.Confirmation{}
 			}
+			cnf.JKT = jkt
 		}
-		if !allowed {
-			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "unauthorized grant_type"})
+		// RFC 9449 §5：confidential client 的 refresh token 已由 client 認證保護，只有 public client 須綁定
+		var refreshJKT string
+		if oc.IsPublic() {
+			refreshJKT = jkt
 		}
 
-		var tokenStr, newRefreshToken string
+		// token 效期與 aud 依 client 設定，未設定者沿用伺服器預設
+		lifetimes := service.ClientTokenLifetimes(*oc)
+		var tokenStr, newRefreshToken, idToken, scope, issuedTokenType string
+		expiresIn := int(lifetimes.AccessTokenTTL.Seconds())
 
 		switch req.GrantType {
 		case "password":
 			user, err := store.GetUserByName(ctx, db, req.Username)
 			if err != nil {
-				return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid credentials"})
+				return oauthError(c, errCodeInvalidGrant, "invalid credentials")
 			}
 			if err := service.AuthenticateUser(ctx, *user, req.Password); err != nil {
-				return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid credentials"})
+				return oauthError(c, errCodeInvalidGrant, "invalid credentials")
+			}
+			// 已啟用 MFA 的使用者須同時以 otp 參數提交 TOTP 驗證碼或 recovery code
+			authn := service.PasswordAuthentication()
+			mfaEnabled, err := service.MFAEnabled(ctx, db, user.ID)
+			if err != nil {
+				return oauthError(c, errCodeServerError, "failed to check mfa")
+			}
+			if mfaEnabled {
+				if req.OTP == "" {
+					return oauthError(c, errCodeMFARequired, "otp required")
+				}
+				if err := service.VerifyMFACode(ctx, db, cache, user.ID, req.OTP); err != nil {
+					if errors.Is(err, service.ErrInvalidMFACode) || errors.Is(err, service.ErrRateLimited) || errors.Is(err, service.ErrMFANotEnabled) {
+						return oauthError(c, errCodeInvalidGrant, err.Error())
+					}
+					return oauthError(c, errCodeServerError, "failed to verify otp")
+				}
+				authn = service.MFAAuthentication()
+			}
+			if scope, err = service.ResolveScope(req.Scope, oc.Scopes); err != nil {
+				return oauthError(c, errCodeInvalidScope, err.Error())
 			}
 
 			// 發行 access token
-			tokenStr, err = service.IssueAccessToken(*user, 24*time.Hour)
+			tokenStr, err = service.IssueAccessToken(ctx, *user, oc.ClientID, scope, lifetimes.Audience, lifetimes.AccessTokenTTL, cnf, authn)
 			if err != nil {
-				return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to issue token"})
+				return oauthError(c, errCodeServerError, "failed to issue token")
 			}
 
 			// 發行 refresh token
-			newRefreshToken, err = service.IssueRefreshToken(ctx, cache, user.ID, oc.ClientID, user.IsAdmin, 30*24*time.Hour)
+			newRefreshToken, err = service.IssueRefreshToken(ctx, cache, user.ID, oc.ClientID, user.IsAdmin, scope, lifetimes, refreshJKT, authn)
 			if err != nil {
-				return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to issue refresh token"})
+				return oauthError(c, errCodeServerError, "failed to issue refresh token")
 			}
 
 		case "client_credentials":
-			// 為 client 自身（由 owner）發行 access token
-			owner, err := store.GetUserByID(ctx, db, oc.UserID)
+			// 為 client 自身（由 owner）發行 access token；匿名註冊的 client 沒有 owner，token 不代表任何使用者
+			if scope, err = service.ResolveScope(req.Scope, oc.Scopes); err != nil {
+				return oauthError(c, errCodeInvalidScope, err.Error())
+			}
+			owner, err := clientOwner(c, db, oc)
+			if err != nil {
+				return oauthError(c, errCodeServerError, "failed to retrieve client owner")
+			}
+
+			tokenStr, err = service.IssueClientAccessToken(ctx, *owner, *oc, scope, lifetimes.Audience, lifetimes.AccessTokenTTL, cnf)
 			if err != nil {
-				return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to retrieve client owner"})
+				return oauthError(c, errCodeServerError, "failed to issue token")
 			}
 
-			tokenStr, err = service.IssueClientAccessToken(*owner, *oc, 24*time.Hour)
+		case "authorization_code":
+			// 兌換一次性授權碼
+			data, err := service.ConsumeAuthorizationCode(ctx, cache, req.Code)
 			if err != nil {
-				return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to issue token"})
+				return oauthError(c, errCodeInvalidGrant, "invalid authorization code")
+			}
+			if data.ClientID != oc.ClientID || data.RedirectURI != req.RedirectURI {
+				return oauthError(c, errCodeInvalidGrant, "invalid authorization code")
+			}
+			if err := service.VerifyPKCE(data.CodeChallenge, data.CodeChallengeMethod, req.CodeVerifier); err != nil {
+				return oauthError(c, errCodeInvalidGrant, "invalid code_verifier")
+			}
+			user, err := store.GetUserByID(ctx, db, data.UserID)
+			if err != nil {
+				return oauthError(c, errCodeInvalidGrant, "invalid authorization code")
+			}
+
+			// scope 已於 /oauth/authorize 依 client 設定決定；amr 與 acr 沿用使用者授權時的登入方式
+			scope = data.Scope
+			tokenStr, err = service.IssueAccessToken(ctx, *user, oc.ClientID, scope, lifetimes.Audience, lifetimes.AccessTokenTTL, cnf, data.Authentication)
+			if err != nil {
+				return oauthError(c, errCodeServerError, "failed to issue token")
+			}
+			newRefreshToken, err = service.IssueRefreshToken(ctx, cache, user.ID, oc.ClientID, user.IsAdmin, scope, lifetimes, refreshJKT, data.Authentication)
+			if err != nil {
+				return oauthError(c, errCodeServerError, "failed to issue refresh token")
+			}
+			if service.HasScope(data.Scope, service.ScopeOpenID) {
+				idToken, err = issueIDToken(ctx, issuerURL(), data, tokenStr, lifetimes.IDTokenTTL)
+				if err != nil {
+					return oauthError(c, errCodeServerError, "failed to issue id_token")
+				}
 			}
 
 		case "refresh_token":
-			// 驗證並讀取 refresh token
-			data, err := service.ValidateRefreshToken(ctx, cache, req.RefreshToken)
+			// 每次使用皆輪替 refresh token；舊 token 重用時整個 family 已被撤銷。scope 只能縮減
+			data, rotated, err := service.RotateRefreshToken(ctx, cache, oc.ClientID, req.RefreshToken, jkt, req.Scope, lifetimes)
 			if err != nil {
-				return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid refresh token"})
+				var reused *service.ReusedRefreshTokenError
+				if errors.As(err, &reused) {
+					recordRefreshTokenReuse(c, db, reused.Data)
+					return oauthError(c, errCodeInvalidGrant, "invalid refresh token")
+				}
+				if errors.Is(err, service.ErrRefreshTokenNotFound) || errors.Is(err, service.ErrRefreshTokenBindingMismatch) {
+					return oauthError(c, errCodeInvalidGrant, "invalid refresh token")
+				}
+				if errors.Is(err, service.ErrInvalidScope) {
+					return oauthError(c, errCodeInvalidScope, err.Error())
+				}
+				return oauthError(c, errCodeServerError, "failed to rotate refresh token")
 			}
 			// 重新發行 access token
-			tokenStr, err = service.IssueAccessToken(model.User{ID: data.UserID, IsAdmin: false}, 24*time.Hour)
+			scope = data.Scope
+			tokenStr, err = service.IssueAccessToken(ctx, model.User{ID: data.UserID, IsAdmin: false}, oc.ClientID, scope, lifetimes.Audience, lifetimes.AccessTokenTTL, cnf, data.Authentication)
+			if err != nil {
+				return oauthError(c, errCodeServerError, "failed to issue token")
+			}
+			newRefreshToken = rotated
+
+		case service.GrantTypeDeviceCode:
+			// 輪詢 device code；使用者核准前依 RFC 8628 回傳對應的錯誤碼
+			data, err := service.PollDeviceAuthorization(ctx, cache, oc.ClientID, req.DeviceCode)
+			if err != nil {
+				return deviceGrantError(c, err)
+			}
+			user, err := store.GetUserByID(ctx, db, data.UserID)
+			if err != nil {
+				return oauthError(c, errCodeInvalidGrant, "user not found")
+			}
+
+			// scope 已於 /oauth/device_authorization 依 client 設定決定；amr 與 acr 沿用使用者核准時的登入方式
+			scope = data.Scope
+			tokenStr, err = service.IssueAccessToken(ctx, *user, oc.ClientID, scope, lifetimes.Audience, lifetimes.AccessTokenTTL, cnf, data.Authentication)
+			if err != nil {
+				return oauthError(c, errCodeServerError, "failed to issue token")
+			}
+			newRefreshToken, err = service.IssueRefreshToken(ctx, cache, user.ID, oc.ClientID, user.IsAdmin, scope, lifetimes, refreshJKT, data.Authentication)
 			if err != nil {
-				return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to issue token"})
+				return oauthError(c, errCodeServerError, "failed to issue refresh token")
 			}
-			// reuse same refresh token
-			newRefreshToken = req.RefreshToken
 
-		default:
-			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "unsupported grant_type"})
+		case service.GrantTypeTokenExchange:
+			// RFC 8693：subject_token 代表使用者，actor_token 代表實際行事的一方（未提供時為 client 自身）
+			if req.RequestedTokenType != "" && req.RequestedTokenType != service.TokenTypeAccessToken {
+				return oauthError(c, errCodeInvalidRequest, "unsupported requested_token_type")
+			}
+			if req.SubjectToken == "" || req.SubjectTokenType != service.TokenTypeAccessToken {
+				return oauthError(c, errCodeInvalidRequest, "subject_token of type access_token required")
+			}
+			subject, err := service.VerifyAccessToken(ctx, cache, req.SubjectToken)
+			if err != nil {
+				return oauthError(c, errCodeInvalidRequest, "invalid subject_token")
+			}
+			if err := service.VerifyTokenExchangeBinding(subject, jkt, peerCertificates(c)); err != nil {
+				return oauthError(c, errCodeInvalidRequest, "subject_token: "+err.Error())
+			}
+			// 限定給其他服務的 token 只能由該 audience 的 client 再交換
+			if !service.AcceptsAudience(subject.Audience) && !slices.Contains(subject.Audience, oc.ClientID) {
+				return oauthError(c, errCodeInvalidRequest, "subject_token is not intended for this client")
+			}
+			var actor *service.CustomClaims
+			if req.ActorToken != "" || req.ActorTokenType != "" {
+				if req.ActorToken == "" || req.ActorTokenType != service.TokenTypeAccessToken {
+					return oauthError(c, errCodeInvalidRequest, "actor_token of type access_token required")
+				}
+				if actor, err = service.VerifyAccessToken(ctx, cache, req.ActorToken); err != nil {
+					return oauthError(c, errCodeInvalidRequest, "invalid actor_token")
+				}
+				if err := service.VerifyTokenExchangeBinding(actor, jkt, peerCertificates(c)); err != nil {
+					return oauthError(c, errCodeInvalidRequest, "actor_token: "+err.Error())
+				}
+			}
+			if len(req.Audience) == 0 {
+				return oauthError(c, errCodeInvalidRequest, "missing audience")
+			}
+			audience, err := service.ResolveTokenExchangeAudience(req.Audience, oc.TokenExchangeAudiences)
+			if err != nil {
+				return oauthError(c, errCodeInvalidTarget, err.Error())
+			}
+			if scope, err = service.ResolveTokenExchangeScope(req.Scope, subject, oc.Scopes); err != nil {
+				return oauthError(c, errCodeInvalidScope, err.Error())
+			}
+
+			var ttl time.Duration
+			tokenStr, ttl, err = service.IssueExchangedAccessToken(ctx, *oc, subject, actor, audience, scope, lifetimes.AccessTokenTTL, cnf)
+			if err != nil {
+				return oauthError(c, errCodeServerError, "failed to issue token")
+			}
+			expiresIn = int(ttl.Seconds())
+			issuedTokenType = service.TokenTypeAccessToken
+
+		case service.GrantTypeJWTBearer:
+			// RFC 7523：以信任簽發者的 assertion 換取 token，sub 依對應規則代表使用者或 client 自身；不發 refresh token
+			if req.Assertion == "" {
+				return oauthError(c, errCodeInvalidRequest, "missing assertion")
+			}
+			mapping, err := service.VerifyJWTBearerAssertion(ctx, cache, oc.ClientID, req.Assertion, assertionAudiences(c))
+			if errors.Is(err, service.ErrInvalidJWTBearerAssertion) {
+				return oauthError(c, errCodeInvalidGrant, err.Error())
+			}
+			if err != nil {
+				return oauthError(c, errCodeServerError, "failed to verify assertion")
+			}
+			if scope, err = service.ResolveScope(req.Scope, service.JWTBearerScopes(mapping, oc.Scopes)); err != nil {
+				return oauthError(c, errCodeInvalidScope, err.Error())
+			}
+			subject := &model.User{}
+			if mapping.Username != "" {
+				if subject, err = store.GetUserByName(ctx, db, mapping.Username); err != nil {
+					return oauthError(c, errCodeInvalidGrant, "mapped user not found")
+				}
+				tokenStr, err = service.IssueAccessToken(ctx, *subject, oc.ClientID, scope, lifetimes.Audience, lifetimes.AccessTokenTTL, cnf, service.Authentication{})
+			} else {
+				if subject, err = clientOwner(c, db, oc); err != nil {
+					return oauthError(c, errCodeServerError, "failed to retrieve client owner")
+				}
+				tokenStr, err = service.IssueClientAccessToken(ctx, *subject, *oc, scope, lifetimes.Audience, lifetimes.AccessTokenTTL, cnf)
+			}
+			if err != nil {
+				return oauthError(c, errCodeServerError, "failed to issue token")
+			}
 		}
 
+		tokenType := "Bearer"
+		if jkt != "" {
+			tokenType = "DPoP"
+		}
 		resp := api.TokenResponse{
-			AccessToken:  tokenStr,
-			TokenType:    "Bearer",
-			ExpiresIn:    86400,
-			RefreshToken: newRefreshToken,
+			AccessToken:     tokenStr,
+			TokenType:       tokenType,
+			ExpiresIn:       expiresIn,
+			RefreshToken:    newRefreshToken,
+			IDToken:         idToken,
+			Scope:           scope,
+			IssuedTokenType: issuedTokenType,
 		}
 		return c.JSON(http.StatusOK, resp)
 	}
 }
+
+// clientOwner 回傳代表 client 自身的 token 所屬的使用者；匿名註冊的 client 沒有 owner，token 不代表任何使用者
+func clientOwner(c echo.Context, db database.DB, oc *model.OAuthClient) (*model.User, error) {
+	if oc.UserID == 0 {
+		return &model.User{}, nil
+	}
+	return store.GetUserByID(c.Request().Context(), db, oc.UserID)
+}
+
+// verifyTokenDPoP 驗證 token 請求的 DPoP proof 並回傳其 jkt；未帶 proof 時回傳空字串。proof 須帶伺服器 nonce
+func verifyTokenDPoP(c echo.Context, cache cache.Cache) (string, error) {
+	proofs := c.Request().Header.Values("DPoP")
+	switch len(proofs) {
+	case 0:
+		return "", nil
+	case 1:
+	default:
+		return "", fmt.Errorf("%w: multiple DPoP proofs", service.ErrInvalidDPoPProof)
+	}
+	return service.VerifyDPoPProof(c.Request().Context(), cache, proofs[0], service.DPoPRequest{
+		Method:       http.MethodPost,
+		URL:          issuerURL() + c.Request().URL.Path,
+		RequireNonce: true,
+	})
+}
+
+// dpopError 將 DPoP 驗證錯誤對應到 RFC 9449 的錯誤碼；缺少或過期的 nonce 回傳 use_dpop_nonce
+// 並於 DPoP-Nonce header 提供新的 nonce
+func dpopError(c echo.Context, cache cache.Cache, err error) error {
+	switch {
+	case errors.Is(err, service.ErrUseDPoPNonce):
+		nonce, err := service.IssueDPoPNonce(c.Request().Context(), cache)
+		if err != nil {
+			return oauthError(c, errCodeServerError, "failed to issue DPoP nonce")
+		}
+		c.Response().Header().Set("DPoP-Nonce", nonce)
+		return oauthError(c, errCodeUseDPoPNonce, "authorization server requires nonce in DPoP proof")
+	case errors.Is(err, service.ErrInvalidDPoPProof):
+		return oauthError(c, errCodeInvalidDPoPProof, err.Error())
+	}
+	return oauthError(c, errCodeServerError, "failed to verify DPoP proof")
+}
+
+// recordRefreshTokenReuse 寫入 refresh token 重用的稽核紀錄；寫入失敗僅記錄 log，不影響回應
+func recordRefreshTokenReuse(c echo.Context, db database.DB, data *service.RefreshTokenData) {
+	event := &model.AuditEvent{
+		EventType: model.AuditEventRefreshTokenReuse,
+		UserID:    data.UserID,
+		ClientID:  data.ClientID,
+		IPAddress: c.RealIP(),
+		Details: map[string]any{
+			"family_id":  data.FamilyID,
+			"user_agent": c.Request().UserAgent(),
+		},
+	}
+	if err := store.CreateAuditEvent(c.Request().Context(), db, event); err != nil {
+		c.Logger().Errorf("failed to record refresh token reuse: %v", err)
+	}
+}
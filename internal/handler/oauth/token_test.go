@@ -2,24 +2,38 @@ package oauth
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"life-is-hard/internal/api"
 	"life-is-hard/internal/cache"
 	"life-is-hard/internal/database"
 	"life-is-hard/internal/model"
 	"life-is-hard/internal/service"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // fakeUserRow implements pgx.Row for user queries
@@ -39,10 +53,54 @@ func (r *fakeUserRow) Scan(dest ...any) error {
 	*dest[3].(*string) = u.PasswordHash
 	*dest[4].(*time.Time) = u.CreatedAt
 	*dest[5].(*bool) = u.IsAdmin
+	*dest[6].(*bool) = u.EmailVerified
 	return nil
 }
 
-// fakeClientRow implements pgx.Row for oauth client queries
+// fakeTOTPRow implements pgx.Row for user_totp queries
+type fakeTOTPRow struct {
+	totp *model.UserTOTP
+}
+
+func (r *fakeTOTPRow) Scan(dest ...any) error {
+	t := r.totp
+	*dest[0].(*int) = t.UserID
+	*dest[1].(*string) = t.Secret
+	*dest[2].(**time.Time) = t.ConfirmedAt
+	*dest[3].(*int64) = t.LastUsedStep
+	*dest[4].(*time.Time) = t.CreatedAt
+	return nil
+}
+
+// fakeAuditRow implements pgx.Row for audit event inserts
+type fakeAuditRow struct {
+	err error
+}
+
+func (r *fakeAuditRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int64) = 1
+	*dest[1].(*time.Time) = time.Now()
+	return nil
+}
+
+// testSecretHashes 快取測試用 client secret 的 bcrypt 雜湊，避免每次掃描都重新計算
+var testSecretHashes = map[string]string{}
+
+// hashTestSecret 以最低成本雜湊明文 secret，模擬資料表中保存的內容
+func hashTestSecret(secret string) string {
+	if h, ok := testSecretHashes[secret]; ok {
+		return h
+	}
+	b, _ := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+	testSecretHashes[secret] = string(b)
+	return string(b)
+}
+
+// fakeClientRow implements pgx.Row for oauth client queries; ClientSecret is given in plaintext
+// and returned hashed as stored in the table
 type fakeClientRow struct {
 	client *model.OAuthClient
 	err    error
@@ -54,14 +112,73 @@ func (r *fakeClientRow) Scan(dest ...any) error {
 	}
 	c := r.client
 	*dest[0].(*string) = c.ClientID
-	*dest[1].(*string) = c.ClientSecret
+	// 不使用 secret 的 client 在資料表中保存空字串
+	if c.ClientSecret != "" {
+		*dest[1].(*string) = hashTestSecret(c.ClientSecret)
+	}
 	*dest[2].(*int) = c.UserID
-	*dest[3].(*[]string) = c.GrantTypes
-	*dest[4].(*time.Time) = c.CreatedAt
-	*dest[5].(*time.Time) = c.UpdatedAt
+	*dest[3].(*string) = c.ClientType
+	*dest[4].(*string) = c.ClientName
+	*dest[5].(*string) = c.LogoURI
+	*dest[6].(*[]string) = c.GrantTypes
+	*dest[7].(*[]string) = c.RedirectURIs
+	*dest[8].(*[]string) = c.Scopes
+	*dest[9].(*time.Time) = c.CreatedAt
+	*dest[10].(*time.Time) = c.UpdatedAt
+	// 與資料表預設值相同，未指定時為 client_secret_basic
+	method := c.TokenEndpointAuthMethod
+	if method == "" {
+		method = model.ClientAuthMethodSecretBasic
+	}
+	*dest[13].(*string) = method
+	*dest[14].(*json.RawMessage) = c.JWKS
+	*dest[15].(*[]byte) = c.SealedClientSecret
+	*dest[16].(*string) = c.TLSClientAuthSubjectDN
+	*dest[17].(*string) = c.TLSClientCertificateThumbprint
+	*dest[18].(*bool) = c.TLSClientCertificateBoundAccessTokens
+	*dest[19].(*string) = c.RegistrationAccessToken
+	*dest[20].(*bool) = c.RequirePushedAuthorizationRequests
+	*dest[21].(*[]string) = c.TokenExchangeAudiences
+	*dest[22].(*int) = c.AccessTokenTTL
+	*dest[23].(*int) = c.RefreshTokenTTL
+	*dest[24].(*int) = c.RefreshTokenAbsoluteTTL
+	*dest[25].(*int) = c.RefreshTokenIdleTimeout
+	*dest[26].(*int) = c.IDTokenTTL
+	*dest[27].(*[]string) = c.AccessTokenAudiences
+	*dest[28].(*bool) = c.FirstParty
 	return nil
 }
 
+// newDPoPKey 產生 DPoP proof 用的 ES256 金鑰與對應的公鑰 JWK
+func newDPoPKey(t *testing.T) (*ecdsa.PrivateKey, service.JSONWebKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	b64 := func(n interface{ FillBytes([]byte) []byte }) string {
+		return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32)))
+	}
+	return key, service.JSONWebKey{KeyType: "EC", Curve: "P-256", X: b64(key.X), Y: b64(key.Y)}
+}
+
+// signDPoPProof 簽發 RFC 9449 DPoP proof；accessToken 不為空時帶入 ath
+func signDPoPProof(t *testing.T, key *ecdsa.PrivateKey, jwk service.JSONWebKey, method, htu, nonce, accessToken string) string {
+	t.Helper()
+	claims := jwt.MapClaims{"htm": method, "htu": htu, "jti": rand.Text(), "iat": time.Now().Unix()}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = service.DPoPProofType
+	token.Header["jwk"] = map[string]any{"kty": jwk.KeyType, "crv": jwk.Curve, "x": jwk.X, "y": jwk.Y}
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
 // helper to create echo context with form body and Authorization header
 func newCtx(e *echo.Echo, form string, auth string) (echo.Context, *httptest.ResponseRecorder) {
 	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form))
@@ -74,11 +191,12 @@ func newCtx(e *echo.Echo, form string, auth string) (echo.Context, *httptest.Res
 }
 
 func TestTokenHandler(t *testing.T) {
+	t.Setenv("OAUTH_ISSUER", "http://example.com")
 	e := echo.New()
 	now := time.Now()
 	hashed, _ := service.HashPassword("pw")
 	user := &model.User{ID: 1, Name: "u", Email: "e", PasswordHash: hashed, CreatedAt: now}
-	client := &model.OAuthClient{ClientID: "cid", ClientSecret: "sec", UserID: 1, GrantTypes: []string{"password", "client_credentials", "refresh_token"}, CreatedAt: now, UpdatedAt: now}
+	client := &model.OAuthClient{ClientID: "cid", ClientSecret: "sec", UserID: 1, GrantTypes: []string{"password", "client_credentials", "refresh_token"}, Scopes: []string{"users:read", "users:write"}, CreatedAt: now, UpdatedAt: now}
 
 	validAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("cid:sec"))
 
@@ -86,23 +204,29 @@ func TestTokenHandler(t *testing.T) {
 		ctx, rec := newCtx(e, "bad%", validAuth)
 		err := TokenHandler(&database.FakeDB{}, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
-		require.Equal(t, http.StatusBadRequest, rec.Code)
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidRequest)
 		require.Contains(t, rec.Body.String(), "invalid request payload")
 	})
 
-	t.Run("invalid auth prefix", func(t *testing.T) {
+	t.Run("missing client auth", func(t *testing.T) {
 		ctx, rec := newCtx(e, "grant_type=password", "")
 		err := TokenHandler(&database.FakeDB{}, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
-		require.Equal(t, http.StatusBadRequest, rec.Code)
-		require.Contains(t, rec.Body.String(), "invalid authorization header")
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
+		require.Contains(t, rec.Body.String(), "client authentication required")
+	})
+
+	t.Run("multiple client auth", func(t *testing.T) {
+		ctx, rec := newCtx(e, "grant_type=password&client_secret=sec", validAuth)
+		require.NoError(t, TokenHandler(&database.FakeDB{}, &cache.FakeCache{})(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidRequest)
 	})
 
 	t.Run("decode error", func(t *testing.T) {
 		ctx, rec := newCtx(e, "grant_type=password", "Basic !!!")
 		err := TokenHandler(&database.FakeDB{}, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
-		require.Equal(t, http.StatusBadRequest, rec.Code)
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
 		require.Contains(t, rec.Body.String(), "invalid authorization header")
 	})
 
@@ -111,7 +235,7 @@ func TestTokenHandler(t *testing.T) {
 		ctx, rec := newCtx(e, "grant_type=password", "Basic "+bad)
 		err := TokenHandler(&database.FakeDB{}, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
-		require.Equal(t, http.StatusBadRequest, rec.Code)
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
 	})
 
 	t.Run("invalid client", func(t *testing.T) {
@@ -121,7 +245,8 @@ func TestTokenHandler(t *testing.T) {
 		ctx, rec := newCtx(e, "grant_type=password", validAuth)
 		err := TokenHandler(db, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
-		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
+		require.Contains(t, rec.Header().Get("WWW-Authenticate"), "Basic")
 	})
 
 	t.Run("unauthorized grant", func(t *testing.T) {
@@ -131,7 +256,7 @@ func TestTokenHandler(t *testing.T) {
 		ctx, rec := newCtx(e, "grant_type=password", validAuth)
 		err := TokenHandler(db, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
-		require.Equal(t, http.StatusBadRequest, rec.Code)
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeUnauthorizedClient)
 	})
 
 	t.Run("password user not found", func(t *testing.T) {
@@ -144,7 +269,7 @@ func TestTokenHandler(t *testing.T) {
 		ctx, rec := newCtx(e, "grant_type=password&username=x&password=pw", validAuth)
 		err := TokenHandler(db, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
-		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidGrant)
 	})
 
 	t.Run("password auth fail", func(t *testing.T) {
@@ -152,12 +277,15 @@ func TestTokenHandler(t *testing.T) {
 			if strings.Contains(q, "FROM oauth_clients") {
 				return &fakeClientRow{client: client}
 			}
+			if strings.Contains(q, "FROM user_totp") {
+				return &fakeUserRow{err: pgx.ErrNoRows}
+			}
 			return &fakeUserRow{user: user}
 		}}
 		ctx, rec := newCtx(e, "grant_type=password&username=u&password=bad", validAuth)
 		err := TokenHandler(db, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
-		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidGrant)
 	})
 
 	t.Run("password issue access token fail", func(t *testing.T) {
@@ -165,6 +293,9 @@ func TestTokenHandler(t *testing.T) {
 			if strings.Contains(q, "FROM oauth_clients") {
 				return &fakeClientRow{client: client}
 			}
+			if strings.Contains(q, "FROM user_totp") {
+				return &fakeUserRow{err: pgx.ErrNoRows}
+			}
 			return &fakeUserRow{user: user}
 		}}
 		ctx, rec := newCtx(e, "grant_type=password&username=u&password=pw", validAuth)
@@ -180,6 +311,9 @@ func TestTokenHandler(t *testing.T) {
 			if strings.Contains(q, "FROM oauth_clients") {
 				return &fakeClientRow{client: client}
 			}
+			if strings.Contains(q, "FROM user_totp") {
+				return &fakeUserRow{err: pgx.ErrNoRows}
+			}
 			return &fakeUserRow{user: user}
 		}}
 		cch := &cache.FakeCache{SetFn: func(context.Context, string, any, time.Duration) *redis.StatusCmd {
@@ -198,6 +332,9 @@ func TestTokenHandler(t *testing.T) {
 			if strings.Contains(q, "FROM oauth_clients") {
 				return &fakeClientRow{client: client}
 			}
+			if strings.Contains(q, "FROM user_totp") {
+				return &fakeUserRow{err: pgx.ErrNoRows}
+			}
 			return &fakeUserRow{user: user}
 		}}
 		cch := &cache.FakeCache{SetFn: func(context.Context, string, any, time.Duration) *redis.StatusCmd {
@@ -210,6 +347,113 @@ func TestTokenHandler(t *testing.T) {
 		require.Equal(t, http.StatusOK, rec.Code)
 		require.Contains(t, rec.Body.String(), "access_token")
 		require.Contains(t, rec.Body.String(), "refresh_token")
+		require.Contains(t, rec.Body.String(), `"scope":"users:read users:write"`)
+		require.Contains(t, rec.Body.String(), `"expires_in":86400`)
+		require.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	})
+
+	t.Run("password mfa", func(t *testing.T) {
+		confirmed := time.Now()
+		// 非 6 碼的 otp 視為 recovery code，以 recoveryOK 決定是否可使用
+		var recoveryOK bool
+		db := &database.FakeDB{
+			QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+				if strings.Contains(q, "FROM oauth_clients") {
+					return &fakeClientRow{client: client}
+				}
+				if strings.Contains(q, "FROM user_totp") {
+					return &fakeTOTPRow{totp: &model.UserTOTP{UserID: user.ID, ConfirmedAt: &confirmed}}
+				}
+				return &fakeUserRow{user: user}
+			},
+			ExecFn: func(ctx context.Context, q string, args ...any) (pgconn.CommandTag, error) {
+				if recoveryOK {
+					return pgconn.NewCommandTag("UPDATE 1"), nil
+				}
+				return pgconn.NewCommandTag("UPDATE 0"), nil
+			},
+		}
+		t.Setenv("JWT_SECRET", "s")
+
+		ctx, rec := newCtx(e, "grant_type=password&username=u&password=pw", validAuth)
+		require.NoError(t, TokenHandler(db, cache.NewMemoryCache(nil))(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeMFARequired)
+
+		cch := cache.NewMemoryCache(nil)
+		ctx, rec = newCtx(e, "grant_type=password&username=u&password=pw&otp=k3m9-x2q7", validAuth)
+		require.NoError(t, TokenHandler(db, cch)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidGrant)
+		require.Contains(t, cch.Data, fmt.Sprintf("mfa_failures:%d", user.ID))
+
+		recoveryOK = true
+		ctx, rec = newCtx(e, "grant_type=password&username=u&password=pw&otp=k3m9-x2q7", validAuth)
+		require.NoError(t, TokenHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		claims, err := service.VerifyAccessToken(context.Background(), cch, resp.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, service.MFAAuthentication(), claims.Authentication)
+		var stored service.RefreshTokenData
+		require.NoError(t, json.Unmarshal([]byte(cch.Data["refresh_token:"+resp.RefreshToken]), &stored))
+		require.Equal(t, service.MFAAuthentication(), stored.Authentication)
+	})
+
+	t.Run("client token lifetimes", func(t *testing.T) {
+		custom := *client
+		custom.AccessTokenTTL = 300
+		custom.RefreshTokenTTL = 3600
+		custom.AccessTokenAudiences = []string{"billing"}
+		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+			if strings.Contains(q, "FROM oauth_clients") {
+				return &fakeClientRow{client: &custom}
+			}
+			if strings.Contains(q, "FROM user_totp") {
+				return &fakeUserRow{err: pgx.ErrNoRows}
+			}
+			return &fakeUserRow{user: user}
+		}}
+		cch := cache.NewMemoryCache(nil)
+		ctx, rec := newCtx(e, "grant_type=password&username=u&password=pw", validAuth)
+		t.Setenv("JWT_SECRET", "s")
+		require.NoError(t, TokenHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, 300, resp.ExpiresIn)
+		claims, err := service.VerifyAccessToken(context.Background(), cch, resp.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, jwt.ClaimStrings{"billing"}, claims.Audience)
+		require.Equal(t, 300*time.Second, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+		var stored service.RefreshTokenData
+		require.NoError(t, json.Unmarshal([]byte(cch.Data["refresh_token:"+resp.RefreshToken]), &stored))
+		require.Equal(t, int64(3600), stored.ExpiresAt-stored.IssuedAt)
+	})
+
+	t.Run("password invalid scope", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+			if strings.Contains(q, "FROM oauth_clients") {
+				return &fakeClientRow{client: client}
+			}
+			if strings.Contains(q, "FROM user_totp") {
+				return &fakeUserRow{err: pgx.ErrNoRows}
+			}
+			return &fakeUserRow{user: user}
+		}}
+		ctx, rec := newCtx(e, "grant_type=password&username=u&password=pw&scope=clients:manage", validAuth)
+		require.NoError(t, TokenHandler(db, &cache.FakeCache{})(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), "invalid scope")
+	})
+
+	t.Run("client creds invalid scope", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+			return &fakeClientRow{client: client}
+		}}
+		ctx, rec := newCtx(e, "grant_type=client_credentials&scope=openid", validAuth)
+		require.NoError(t, TokenHandler(db, &cache.FakeCache{})(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), "invalid scope")
 	})
 
 	t.Run("client creds owner error", func(t *testing.T) {
@@ -247,57 +491,825 @@ func TestTokenHandler(t *testing.T) {
 			}
 			return &fakeUserRow{user: user}
 		}}
-		ctx, rec := newCtx(e, "grant_type=client_credentials", validAuth)
+		ctx, rec := newCtx(e, "grant_type=client_credentials&scope=users:read", validAuth)
 		t.Setenv("JWT_SECRET", "s")
 		err := TokenHandler(db, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusOK, rec.Code)
-		require.Contains(t, rec.Body.String(), "access_token")
+		var resp api.TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, "users:read", resp.Scope)
+		claims, err := service.VerifyAccessToken(context.Background(), cache.NewMemoryCache(nil), resp.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, "users:read", claims.Scope)
 	})
 
-	t.Run("refresh token invalid", func(t *testing.T) {
+	t.Run("client creds without owner", func(t *testing.T) {
+		// 匿名動態註冊的 client 沒有 owner，不查詢使用者
+		ownerless := *client
+		ownerless.UserID = 0
 		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
-			return &fakeClientRow{client: client}
-		}}
-		cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
-			return redis.NewStringResult("", redis.Nil)
+			if strings.Contains(q, "FROM oauth_clients") {
+				return &fakeClientRow{client: &ownerless}
+			}
+			return &fakeUserRow{err: errors.New("unexpected user lookup")}
 		}}
-		ctx, rec := newCtx(e, "grant_type=refresh_token&refresh_token=tok", validAuth)
-		err := TokenHandler(db, cch)(ctx)
+		ctx, rec := newCtx(e, "grant_type=client_credentials&scope=users:read", validAuth)
+		t.Setenv("JWT_SECRET", "s")
+		require.NoError(t, TokenHandler(db, &cache.FakeCache{})(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		claims, err := service.VerifyAccessToken(context.Background(), cache.NewMemoryCache(nil), resp.AccessToken)
 		require.NoError(t, err)
-		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Zero(t, claims.UserID)
+		require.Equal(t, "cid", claims.Subject)
 	})
 
-	t.Run("refresh token issue access token fail", func(t *testing.T) {
+	t.Run("client secret post", func(t *testing.T) {
+		postClient := *client
+		postClient.TokenEndpointAuthMethod = model.ClientAuthMethodSecretPost
 		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
-			return &fakeClientRow{client: client}
+			if strings.Contains(q, "FROM oauth_clients") {
+				return &fakeClientRow{client: &postClient}
+			}
+			return &fakeUserRow{user: user}
+		}}
+		t.Setenv("JWT_SECRET", "s")
+		ctx, rec := newCtx(e, "grant_type=client_credentials&client_id=cid&client_secret=sec", "")
+		require.NoError(t, TokenHandler(db, &cache.FakeCache{})(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		// 登記為 client_secret_post 的 client 不可改用 HTTP Basic
+		ctx, rec = newCtx(e, "grant_type=client_credentials", validAuth)
+		require.NoError(t, TokenHandler(db, &cache.FakeCache{})(ctx))
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
+	})
+
+	t.Run("certificate-bound token", func(t *testing.T) {
+		cert := newClientCertificate(t)
+		mtlsClient := *client
+		mtlsClient.TokenEndpointAuthMethod = model.ClientAuthMethodSelfSignedTLS
+		mtlsClient.TLSClientCertificateThumbprint = service.CertificateThumbprint(cert)
+		mtlsClient.TLSClientCertificateBoundAccessTokens = true
+		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+			if strings.Contains(q, "FROM oauth_clients") {
+				return &fakeClientRow{client: &mtlsClient}
+			}
+			return &fakeUserRow{user: user}
 		}}
-		dataBytes, _ := json.Marshal(service.RefreshTokenData{UserID: 1, ClientID: "cid"})
-		cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
-			return redis.NewStringResult(string(dataBytes), nil)
+		t.Setenv("JWT_SECRET", "s")
+
+		// 未出示憑證無法以 mTLS 認證
+		ctx, rec := newCtx(e, "grant_type=client_credentials&client_id=cid", "")
+		require.NoError(t, TokenHandler(db, &cache.FakeCache{})(ctx))
+		requireOAuthError(t, rec, http.StatusUnauthorized, errCodeInvalidClient)
+
+		ctx, rec = newCtx(e, "grant_type=client_credentials&client_id=cid", "")
+		ctx.Request().TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		require.NoError(t, TokenHandler(db, &cache.FakeCache{})(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		claims, err := service.VerifyAccessToken(context.Background(), cache.NewMemoryCache(nil), resp.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, service.CertificateThumbprint(cert), claims.Confirmation.X5tS256)
+
+		// 以 secret 認證的 client 要求綁定時必須出示憑證
+		boundSecretClient := *client
+		boundSecretClient.TLSClientCertificateBoundAccessTokens = true
+		db = &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+			return &fakeClientRow{client: &boundSecretClient}
 		}}
-		ctx, rec := newCtx(e, "grant_type=refresh_token&refresh_token=tok", validAuth)
-		t.Setenv("JWT_SECRET", "")
-		err := TokenHandler(db, cch)(ctx)
+		ctx, rec = newCtx(e, "grant_type=client_credentials", validAuth)
+		require.NoError(t, TokenHandler(db, &cache.FakeCache{})(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidRequest)
+	})
+
+	t.Run("dpop", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "s")
+		key, jwk := newDPoPKey(t)
+		jkt, err := service.JWKThumbprint(jwk)
 		require.NoError(t, err)
+		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+			if strings.Contains(q, "FROM oauth_clients") {
+				return &fakeClientRow{client: client}
+			}
+			if strings.Contains(q, "FROM user_totp") {
+				return &fakeUserRow{err: pgx.ErrNoRows}
+			}
+			return &fakeUserRow{user: user}
+		}}
+		cch := cache.NewMemoryCache(nil)
+		form := "grant_type=password&username=u&password=pw"
+		newDPoPCtx := func(proofs ...string) (echo.Context, *httptest.ResponseRecorder) {
+			ctx, rec := newCtx(e, form, validAuth)
+			for _, p := range proofs {
+				ctx.Request().Header.Add("DPoP", p)
+			}
+			return ctx, rec
+		}
+
+		// 未帶 nonce 時要求 client 以伺服器提供的 nonce 重送
+		ctx, rec := newDPoPCtx(signDPoPProof(t, key, jwk, "POST", "http://example.com/oauth/token", "", ""))
+		require.NoError(t, TokenHandler(db, cch)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeUseDPoPNonce)
+		nonce := rec.Header().Get("DPoP-Nonce")
+		require.NotEmpty(t, nonce)
+
+		ctx, rec = newDPoPCtx(signDPoPProof(t, key, jwk, "POST", "http://example.com/oauth/token", nonce, ""))
+		require.NoError(t, TokenHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, "DPoP", resp.TokenType)
+		claims, err := service.VerifyAccessToken(context.Background(), cache.NewMemoryCache(nil), resp.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, jkt, claims.Confirmation.JKT)
+		// confidential client 的 refresh token 不綁定金鑰
+		var stored service.RefreshTokenData
+		require.NoError(t, json.Unmarshal([]byte(cch.Data["refresh_token:"+resp.RefreshToken]), &stored))
+		require.Empty(t, stored.JKT)
+
+		ctx, rec = newDPoPCtx(signDPoPProof(t, key, jwk, "GET", "http://example.com/oauth/token", nonce, ""))
+		require.NoError(t, TokenHandler(db, cch)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidDPoPProof)
+
+		proof := signDPoPProof(t, key, jwk, "POST", "http://example.com/oauth/token", nonce, "")
+		ctx, rec = newDPoPCtx(proof, proof)
+		require.NoError(t, TokenHandler(db, cch)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidDPoPProof)
+		require.Contains(t, rec.Body.String(), "multiple DPoP proofs")
+
+		failing := &cache.FakeCache{
+			GetFn: func(context.Context, string) *redis.StringCmd { return redis.NewStringResult("", redis.Nil) },
+			SetFn: func(context.Context, string, any, time.Duration) *redis.StatusCmd {
+				return redis.NewStatusResult("", errors.New("down"))
+			},
+		}
+		ctx, rec = newDPoPCtx(signDPoPProof(t, key, jwk, "POST", "http://example.com/oauth/token", "", ""))
+		require.NoError(t, TokenHandler(db, failing)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+		require.Contains(t, rec.Body.String(), "failed to issue DPoP nonce")
+
+		ctx, rec = newDPoPCtx(signDPoPProof(t, key, jwk, "POST", "http://example.com/oauth/token", nonce, ""))
+		require.NoError(t, TokenHandler(db, &cache.FakeCache{
+			GetFn: func(context.Context, string) *redis.StringCmd { return redis.NewStringResult("1", nil) },
+			SetNXFn: func(context.Context, string, any, time.Duration) *redis.BoolCmd {
+				return redis.NewBoolResult(false, errors.New("down"))
+			},
+		})(ctx))
 		require.Equal(t, http.StatusInternalServerError, rec.Code)
+		require.Contains(t, rec.Body.String(), "failed to verify DPoP proof")
+	})
+
+	t.Run("dpop-bound refresh token", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "s")
+		key, jwk := newDPoPKey(t)
+		jkt, err := service.JWKThumbprint(jwk)
+		require.NoError(t, err)
+		publicClient := *client
+		publicClient.ClientType = model.ClientTypePublic
+		publicClient.TokenEndpointAuthMethod = model.ClientAuthMethodNone
+		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+			return &fakeClientRow{client: &publicClient}
+		}}
+		refreshData, _ := json.Marshal(service.RefreshTokenData{UserID: 1, ClientID: "cid", Scope: "users:read", FamilyID: "fam", JKT: jkt})
+		cch := cache.NewMemoryCache(map[string]string{
+			"refresh_token:tok":        string(refreshData),
+			"refresh_token_family:fam": "tok",
+			"dpop_nonce:n":             "1",
+		})
+		form := "grant_type=refresh_token&refresh_token=tok&client_id=cid"
+
+		// 綁定金鑰的 refresh token 不可在沒有 proof 的情況下使用
+		ctx, rec := newCtx(e, form, "")
+		require.NoError(t, TokenHandler(db, cch)(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidGrant)
+
+		ctx, rec = newCtx(e, form, "")
+		ctx.Request().Header.Set("DPoP", signDPoPProof(t, key, jwk, "POST", "http://example.com/oauth/token", "n", ""))
+		require.NoError(t, TokenHandler(db, cch)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, "DPoP", resp.TokenType)
+		var stored service.RefreshTokenData
+		require.NoError(t, json.Unmarshal([]byte(cch.Data["refresh_token:"+resp.RefreshToken]), &stored))
+		require.Equal(t, jkt, stored.JKT)
 	})
 
-	t.Run("refresh token success", func(t *testing.T) {
+	t.Run("refresh token", func(t *testing.T) {
 		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
 			return &fakeClientRow{client: client}
 		}}
-		dataBytes, _ := json.Marshal(service.RefreshTokenData{UserID: 1, ClientID: "cid"})
-		cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
-			return redis.NewStringResult(string(dataBytes), nil)
+		refreshData, _ := json.Marshal(service.RefreshTokenData{UserID: 1, ClientID: "cid", Scope: "users:read users:write", FamilyID: "fam"})
+		form := "grant_type=refresh_token&refresh_token=tok"
+
+		t.Run("invalid", func(t *testing.T) {
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cache.NewMemoryCache(nil))(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidGrant)
+			require.Contains(t, rec.Body.String(), "invalid refresh token")
+		})
+
+		t.Run("cache error", func(t *testing.T) {
+			cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+				return redis.NewStringResult("", errors.New("down"))
+			}}
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			require.Equal(t, http.StatusInternalServerError, rec.Code)
+			require.Contains(t, rec.Body.String(), "failed to rotate refresh token")
+		})
+
+		t.Run("scope beyond grant", func(t *testing.T) {
+			cch := cache.NewMemoryCache(map[string]string{"refresh_token:tok": string(refreshData)})
+			ctx, rec := newCtx(e, form+"&scope=clients:manage", validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidScope)
+			require.Contains(t, rec.Body.String(), "invalid scope")
+			require.Contains(t, cch.Data, "refresh_token:tok")
+		})
+
+		t.Run("downscope", func(t *testing.T) {
+			t.Setenv("JWT_SECRET", "s")
+			cch := cache.NewMemoryCache(map[string]string{"refresh_token:tok": string(refreshData)})
+			ctx, rec := newCtx(e, form+"&scope=users:read", validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			require.Equal(t, http.StatusOK, rec.Code)
+			var resp api.TokenResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			require.Equal(t, "users:read", resp.Scope)
+			// 新的 refresh token 仍保有原授權範圍
+			var stored service.RefreshTokenData
+			require.NoError(t, json.Unmarshal([]byte(cch.Data["refresh_token:"+resp.RefreshToken]), &stored))
+			require.Equal(t, "users:read users:write", stored.Scope)
+		})
+
+		t.Run("issue access token fail", func(t *testing.T) {
+			cch := cache.NewMemoryCache(map[string]string{"refresh_token:tok": string(refreshData)})
+			ctx, rec := newCtx(e, form, validAuth)
+			t.Setenv("JWT_SECRET", "")
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			require.Equal(t, http.StatusInternalServerError, rec.Code)
+		})
+
+		t.Run("rotation and reuse", func(t *testing.T) {
+			t.Setenv("JWT_SECRET", "s")
+			cch := cache.NewMemoryCache(map[string]string{
+				"refresh_token:tok":        string(refreshData),
+				"refresh_token_family:fam": "tok",
+			})
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			require.Equal(t, http.StatusOK, rec.Code)
+			var resp api.TokenResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			require.NotEmpty(t, resp.AccessToken)
+			require.NotEmpty(t, resp.RefreshToken)
+			require.NotEqual(t, "tok", resp.RefreshToken)
+			require.Contains(t, cch.Data, "refresh_token:"+resp.RefreshToken)
+
+			// 舊 token 重用：整個 family 撤銷並寫入稽核紀錄
+			var audited []any
+			auditDB := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, args ...any) pgx.Row {
+				if strings.Contains(q, "audit_events") {
+					audited = args
+					return &fakeAuditRow{}
+				}
+				return &fakeClientRow{client: client}
+			}}
+			ctx, rec = newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(auditDB, cch)(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidGrant)
+			require.NotContains(t, cch.Data, "refresh_token:"+resp.RefreshToken)
+			require.Equal(t, model.AuditEventRefreshTokenReuse, audited[0])
+			require.Equal(t, 1, audited[1])
+			require.Equal(t, "cid", audited[2])
+			require.Equal(t, "fam", audited[4].(map[string]any)["family_id"])
+
+			// 新 token 隨 family 一併失效
+			ctx, rec = newCtx(e, "grant_type=refresh_token&refresh_token="+resp.RefreshToken, validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidGrant)
+		})
+
+		t.Run("reuse audit fail", func(t *testing.T) {
+			cch := cache.NewMemoryCache(map[string]string{"rotated_refresh_token:tok": string(refreshData)})
+			auditDB := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, args ...any) pgx.Row {
+				if strings.Contains(q, "audit_events") {
+					return &fakeAuditRow{err: errors.New("db")}
+				}
+				return &fakeClientRow{client: client}
+			}}
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(auditDB, cch)(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidGrant)
+		})
+	})
+
+	t.Run("authorization code", func(t *testing.T) {
+		verifier := strings.Repeat("v", 43)
+		acClient := *client
+		acClient.GrantTypes = []string{"authorization_code"}
+		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+			if strings.Contains(q, "FROM oauth_clients") {
+				return &fakeClientRow{client: &acClient}
+			}
+			return &fakeUserRow{user: user}
+		}}
+		codeCache := func(data *service.AuthorizationCodeData, deleted int64) *cache.FakeCache {
+			return &cache.FakeCache{
+				GetFn: func(context.Context, string) *redis.StringCmd {
+					if data == nil {
+						return redis.NewStringResult("", redis.Nil)
+					}
+					b, _ := json.Marshal(data)
+					return redis.NewStringResult(string(b), nil)
+				},
+				DelFn: func(context.Context, ...string) *redis.IntCmd { return redis.NewIntResult(deleted, nil) },
+				SetFn: func(context.Context, string, any, time.Duration) *redis.StatusCmd {
+					return redis.NewStatusResult("OK", nil)
+				},
+			}
+		}
+		valid := &service.AuthorizationCodeData{UserID: 1, ClientID: "cid", RedirectURI: "https://app/cb", CodeChallenge: verifier, CodeChallengeMethod: "plain"}
+		form := "grant_type=authorization_code&code=c&redirect_uri=https%3A%2F%2Fapp%2Fcb&code_verifier=" + verifier
+		t.Setenv("JWT_SECRET", "s")
+
+		t.Run("unknown code", func(t *testing.T) {
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(nil, 1))(ctx))
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+			require.Contains(t, rec.Body.String(), "invalid authorization code")
+		})
+
+		t.Run("code already used", func(t *testing.T) {
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(valid, 0))(ctx))
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+
+		t.Run("client mismatch", func(t *testing.T) {
+			other := *valid
+			other.ClientID = "other"
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(&other, 1))(ctx))
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+
+		t.Run("redirect mismatch", func(t *testing.T) {
+			ctx, rec := newCtx(e, "grant_type=authorization_code&code=c&code_verifier="+verifier, validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(valid, 1))(ctx))
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+
+		t.Run("bad verifier", func(t *testing.T) {
+			ctx, rec := newCtx(e, "grant_type=authorization_code&code=c&redirect_uri=https%3A%2F%2Fapp%2Fcb&code_verifier="+strings.Repeat("x", 43), validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(valid, 1))(ctx))
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+			require.Contains(t, rec.Body.String(), "invalid code_verifier")
+		})
+
+		t.Run("user gone", func(t *testing.T) {
+			db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+				if strings.Contains(q, "FROM oauth_clients") {
+					return &fakeClientRow{client: &acClient}
+				}
+				return &fakeUserRow{err: errors.New("no user")}
+			}}
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(valid, 1))(ctx))
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+
+		t.Run("issue access token fail", func(t *testing.T) {
+			t.Setenv("JWT_SECRET", "")
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(valid, 1))(ctx))
+			require.Equal(t, http.StatusInternalServerError, rec.Code)
+		})
+
+		t.Run("issue refresh token fail", func(t *testing.T) {
+			cch := codeCache(valid, 1)
+			cch.SetFn = func(context.Context, string, any, time.Duration) *redis.StatusCmd {
+				return redis.NewStatusResult("", errors.New("set"))
+			}
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			require.Equal(t, http.StatusInternalServerError, rec.Code)
+			require.Contains(t, rec.Body.String(), "failed to issue refresh token")
+		})
+
+		t.Run("success", func(t *testing.T) {
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(valid, 1))(ctx))
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Contains(t, rec.Body.String(), "access_token")
+			require.Contains(t, rec.Body.String(), "refresh_token")
+			require.NotContains(t, rec.Body.String(), "id_token")
+		})
+
+		oidc := *valid
+		oidc.Scope = "openid email"
+		oidc.Nonce = "n1"
+
+		t.Run("id token fail", func(t *testing.T) {
+			t.Cleanup(func() { issueIDToken = service.IssueIDToken })
+			issueIDToken = func(context.Context, string, *service.AuthorizationCodeData, string, time.Duration) (string, error) {
+				return "", errors.New("sign")
+			}
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(&oidc, 1))(ctx))
+			require.Equal(t, http.StatusInternalServerError, rec.Code)
+			require.Contains(t, rec.Body.String(), "failed to issue id_token")
+		})
+
+		t.Run("openid success", func(t *testing.T) {
+			// id_token 的內容由 service 測試涵蓋，這裡確認傳入的參數
+			t.Cleanup(func() { issueIDToken = service.IssueIDToken })
+			var gotIssuer, gotAccessToken string
+			var gotData *service.AuthorizationCodeData
+			issueIDToken = func(_ context.Context, issuer string, data *service.AuthorizationCodeData, accessToken string, _ time.Duration) (string, error) {
+				gotIssuer, gotData, gotAccessToken = issuer, data, accessToken
+				return "idt", nil
+			}
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(&oidc, 1))(ctx))
+			require.Equal(t, http.StatusOK, rec.Code)
+			var resp api.TokenResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			require.Equal(t, "idt", resp.IDToken)
+			require.Equal(t, "http://example.com", gotIssuer)
+			require.Equal(t, resp.AccessToken, gotAccessToken)
+			require.Equal(t, 1, gotData.UserID)
+			require.Equal(t, "n1", gotData.Nonce)
+		})
+
+		t.Run("openid without signing key", func(t *testing.T) {
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, codeCache(&oidc, 1))(ctx))
+			require.Equal(t, http.StatusInternalServerError, rec.Code)
+			require.Contains(t, rec.Body.String(), "failed to issue id_token")
+		})
+	})
+
+	t.Run("device code", func(t *testing.T) {
+		dcClient := *client
+		dcClient.GrantTypes = []string{service.GrantTypeDeviceCode}
+		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+			if strings.Contains(q, "FROM oauth_clients") {
+				return &fakeClientRow{client: &dcClient}
+			}
+			return &fakeUserRow{user: user}
 		}}
-		ctx, rec := newCtx(e, "grant_type=refresh_token&refresh_token=tok", validAuth)
+		deviceCache := func(status string) *cache.MemoryCache {
+			b, _ := json.Marshal(service.DeviceAuthorizationData{ClientID: "cid", Scope: "users:read", UserCode: "BCDF-GHJK", Status: status, UserID: 1, Interval: 5, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+			return cache.NewMemoryCache(map[string]string{"device_code:dc": string(b)})
+		}
+		form := "grant_type=" + url.QueryEscape(service.GrantTypeDeviceCode) + "&device_code=dc"
 		t.Setenv("JWT_SECRET", "s")
-		err := TokenHandler(db, cch)(ctx)
+
+		t.Run("pending then slow down", func(t *testing.T) {
+			cch := deviceCache(service.DeviceStatusPending)
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, "authorization_pending")
+
+			ctx, rec = newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, "slow_down")
+		})
+
+		t.Run("denied", func(t *testing.T) {
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, deviceCache(service.DeviceStatusDenied))(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, "access_denied")
+		})
+
+		t.Run("expired", func(t *testing.T) {
+			b, _ := json.Marshal(service.DeviceAuthorizationData{ClientID: "cid", Status: service.DeviceStatusPending, ExpiresAt: time.Now().Add(-time.Second).Unix()})
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cache.NewMemoryCache(map[string]string{"device_code:dc": string(b)}))(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, "expired_token")
+		})
+
+		t.Run("unknown", func(t *testing.T) {
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cache.NewMemoryCache(nil))(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, "invalid_grant")
+		})
+
+		t.Run("cache error", func(t *testing.T) {
+			cch := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+				return redis.NewStringResult("", errors.New("get"))
+			}}
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			require.Equal(t, http.StatusInternalServerError, rec.Code)
+		})
+
+		t.Run("user gone", func(t *testing.T) {
+			db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+				if strings.Contains(q, "FROM oauth_clients") {
+					return &fakeClientRow{client: &dcClient}
+				}
+				return &fakeUserRow{err: errors.New("no user")}
+			}}
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, deviceCache(service.DeviceStatusApproved))(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, "invalid_grant")
+		})
+
+		t.Run("issue access token fail", func(t *testing.T) {
+			t.Setenv("JWT_SECRET", "")
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, deviceCache(service.DeviceStatusApproved))(ctx))
+			require.Equal(t, http.StatusInternalServerError, rec.Code)
+		})
+
+		t.Run("issue refresh token fail", func(t *testing.T) {
+			cch := deviceCache(service.DeviceStatusApproved)
+			cch.FailOn["set"] = "refresh_token:"
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			require.Equal(t, http.StatusInternalServerError, rec.Code)
+			require.Contains(t, rec.Body.String(), "failed to issue refresh token")
+		})
+
+		t.Run("success", func(t *testing.T) {
+			cch := deviceCache(service.DeviceStatusApproved)
+			ctx, rec := newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			require.Equal(t, http.StatusOK, rec.Code)
+			var resp api.TokenResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			require.Equal(t, "users:read", resp.Scope)
+			require.NotEmpty(t, resp.RefreshToken)
+
+			// device code 只能兌換一次
+			ctx, rec = newCtx(e, form, validAuth)
+			require.NoError(t, TokenHandler(db, cch)(ctx))
+			requireOAuthError(t, rec, http.StatusBadRequest, "invalid_grant")
+		})
+	})
+
+	t.Run("token exchange", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "s")
+		ctx := context.Background()
+		txClient := *client
+		txClient.GrantTypes = []string{service.GrantTypeTokenExchange}
+		txClient.TokenExchangeAudiences = []string{"billing", "reports"}
+		db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeClientRow{client: &txClient}
+		}}
+		subjectToken, err := service.IssueAccessToken(ctx, *user, "web", "users:read", nil, time.Hour, nil, service.Authentication{})
 		require.NoError(t, err)
-		require.Equal(t, http.StatusOK, rec.Code)
-		require.Contains(t, rec.Body.String(), "access_token")
-		require.Contains(t, rec.Body.String(), "refresh_token")
+		actorToken, err := service.IssueClientAccessToken(ctx, *user, model.OAuthClient{ClientID: "batch", UserID: 1}, "", nil, time.Hour, nil)
+		require.NoError(t, err)
+		exchange := func(params url.Values) (*httptest.ResponseRecorder, api.TokenResponse) {
+			form := url.Values{
+				"grant_type":         {service.GrantTypeTokenExchange},
+				"subject_token":      {subjectToken},
+				"subject_token_type": {service.TokenTypeAccessToken},
+				"audience":           {"billing"},
+			}
+			for k, v := range params {
+				form[k] = v
+			}
+			c, rec := newCtx(e, form.Encode(), validAuth)
+			require.NoError(t, TokenHandler(db, cache.NewMemoryCache(nil))(c))
+			var resp api.TokenResponse
+			_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+			return rec, resp
+		}
+		parse := func(t *testing.T, tok string) *service.CustomClaims {
+			t.Helper()
+			claims, err := service.VerifyAccessToken(ctx, cache.NewMemoryCache(nil), tok)
+			require.NoError(t, err)
+			return claims
+		}
+
+		t.Run("invalid requests", func(t *testing.T) {
+			cases := map[string]struct {
+				params url.Values
+				code   string
+			}{
+				"requested token type": {url.Values{"requested_token_type": {"urn:ietf:params:oauth:token-type:id_token"}}, errCodeInvalidRequest},
+				"missing subject":      {url.Values{"subject_token": {""}}, errCodeInvalidRequest},
+				"subject token type":   {url.Values{"subject_token_type": {"urn:ietf:params:oauth:token-type:jwt"}}, errCodeInvalidRequest},
+				"invalid subject":      {url.Values{"subject_token": {"garbage"}}, errCodeInvalidRequest},
+				"actor without type":   {url.Values{"actor_token": {actorToken}}, errCodeInvalidRequest},
+				"type without actor":   {url.Values{"actor_token_type": {service.TokenTypeAccessToken}}, errCodeInvalidRequest},
+				"invalid actor":        {url.Values{"actor_token": {"garbage"}, "actor_token_type": {service.TokenTypeAccessToken}}, errCodeInvalidRequest},
+				"missing audience":     {url.Values{"audience": nil}, errCodeInvalidRequest},
+				"audience not allowed": {url.Values{"audience": {"billing", "admin"}}, errCodeInvalidTarget},
+				"scope beyond subject": {url.Values{"scope": {"users:write"}}, errCodeInvalidScope},
+			}
+			for name, tc := range cases {
+				rec, _ := exchange(tc.params)
+				resp := requireOAuthError(t, rec, http.StatusBadRequest, tc.code)
+				require.NotEmpty(t, resp.ErrorDescription, name)
+			}
+		})
+
+		t.Run("subject restricted to another audience", func(t *testing.T) {
+			restricted, _, err := service.IssueExchangedAccessToken(ctx, model.OAuthClient{ClientID: "web"}, parse(t, subjectToken), nil, []string{"billing"}, "", time.Hour, nil)
+			require.NoError(t, err)
+			rec, _ := exchange(url.Values{"subject_token": {restricted}})
+			resp := requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidRequest)
+			require.Equal(t, "subject_token is not intended for this client", resp.ErrorDescription)
+
+			// 限定給此 client 的 token 可再交換，並保留代理鏈
+			restricted, _, err = service.IssueExchangedAccessToken(ctx, model.OAuthClient{ClientID: "web"}, parse(t, subjectToken), nil, []string{"cid"}, "", time.Hour, nil)
+			require.NoError(t, err)
+			rec, resp2 := exchange(url.Values{"subject_token": {restricted}})
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, &service.Actor{Subject: "cid", ClientID: "cid", Actor: &service.Actor{Subject: "web", ClientID: "web"}}, parse(t, resp2.AccessToken).Actor)
+		})
+
+		t.Run("success", func(t *testing.T) {
+			rec, resp := exchange(url.Values{"audience": {"billing", "reports"}})
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, service.TokenTypeAccessToken, resp.IssuedTokenType)
+			require.Equal(t, "Bearer", resp.TokenType)
+			require.Empty(t, resp.RefreshToken)
+			require.Equal(t, "users:read", resp.Scope)
+			require.InDelta(t, 3600, resp.ExpiresIn, 2)
+
+			claims := parse(t, resp.AccessToken)
+			require.Equal(t, jwt.ClaimStrings{"billing", "reports"}, claims.Audience)
+			require.Equal(t, 1, claims.UserID)
+			require.Equal(t, "cid", claims.ClientID)
+			require.Equal(t, &service.Actor{Subject: "cid", ClientID: "cid"}, claims.Actor)
+		})
+
+		t.Run("sender-constrained subject", func(t *testing.T) {
+			key, jwk := newDPoPKey(t)
+			jkt, err := service.JWKThumbprint(jwk)
+			require.NoError(t, err)
+			bound, err := service.IssueAccessToken(ctx, *user, "web", "users:read", nil, time.Hour, &service.Confirmation{JKT: jkt}, service.Authentication{})
+			require.NoError(t, err)
+			cch := cache.NewMemoryCache(map[string]string{"dpop_nonce:n": "1"})
+			call := func(subject, actor string, proof string) *httptest.ResponseRecorder {
+				form := url.Values{
+					"grant_type":         {service.GrantTypeTokenExchange},
+					"subject_token":      {subject},
+					"subject_token_type": {service.TokenTypeAccessToken},
+					"audience":           {"billing"},
+				}
+				if actor != "" {
+					form.Set("actor_token", actor)
+					form.Set("actor_token_type", service.TokenTypeAccessToken)
+				}
+				c, rec := newCtx(e, form.Encode(), validAuth)
+				if proof != "" {
+					c.Request().Header.Set("DPoP", proof)
+				}
+				require.NoError(t, TokenHandler(db, cch)(c))
+				return rec
+			}
+
+			// 綁定 DPoP 金鑰的 subject token 不可以 bearer 方式交換
+			resp := requireOAuthError(t, call(bound, "", ""), http.StatusBadRequest, errCodeInvalidRequest)
+			require.Contains(t, resp.ErrorDescription, "subject_token")
+
+			otherKey, otherJWK := newDPoPKey(t)
+			rec := call(bound, "", signDPoPProof(t, otherKey, otherJWK, "POST", "http://example.com/oauth/token", "n", ""))
+			requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidRequest)
+
+			rec = call(bound, "", signDPoPProof(t, key, jwk, "POST", "http://example.com/oauth/token", "n", ""))
+			require.Equal(t, http.StatusOK, rec.Code)
+			var tr api.TokenResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tr))
+			require.Equal(t, "DPoP", tr.TokenType)
+			require.Equal(t, jkt, parse(t, tr.AccessToken).Confirmation.JKT)
+
+			// actor token 的綁定同樣須相符
+			resp = requireOAuthError(t, call(subjectToken, bound, ""), http.StatusBadRequest, errCodeInvalidRequest)
+			require.Contains(t, resp.ErrorDescription, "actor_token")
+		})
+
+		t.Run("actor token", func(t *testing.T) {
+			rec, resp := exchange(url.Values{"actor_token": {actorToken}, "actor_token_type": {service.TokenTypeAccessToken}})
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, &service.Actor{Subject: "batch", ClientID: "batch"}, parse(t, resp.AccessToken).Actor)
+		})
+	})
+
+	t.Run("jwt bearer", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "s")
+		t.Cleanup(func() { service.UseTrustedIssuers(nil) })
+		ctx := context.Background()
+		key, jwks := newClientAssertionKey(t)
+		cfg, err := json.Marshal(map[string]any{"issuers": []map[string]any{{
+			"issuer": "https://ci.example.com",
+			"jwks":   jwks,
+			"subjects": []map[string]any{
+				{"subject": "repo:app:*", "client_id": "ci"},
+				{"subject": "deploy", "client_id": "ci", "username": "u", "scopes": []string{"users:read"}},
+				{"subject": "ghost", "client_id": "ci", "username": "ghost"},
+			},
+		}}})
+		require.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "issuers.json")
+		require.NoError(t, os.WriteFile(path, cfg, 0o600))
+		issuers, err := service.LoadTrustedIssuers(path)
+		require.NoError(t, err)
+		service.UseTrustedIssuers(issuers)
+
+		ciClient := &model.OAuthClient{ClientID: "ci", UserID: 1, ClientType: model.ClientTypePublic, TokenEndpointAuthMethod: model.ClientAuthMethodNone,
+			GrantTypes: []string{service.GrantTypeJWTBearer}, Scopes: []string{"users:read", "users:write"}, CreatedAt: now, UpdatedAt: now}
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, args ...any) pgx.Row {
+			if strings.Contains(q, "FROM oauth_clients") {
+				return &fakeClientRow{client: ciClient}
+			}
+			if args[0] == "u" || args[0] == 1 {
+				return &fakeUserRow{user: user}
+			}
+			return &fakeUserRow{err: pgx.ErrNoRows}
+		}}
+		sign := func(sub, jti string) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+				Issuer:    "https://ci.example.com",
+				Subject:   sub,
+				Audience:  jwt.ClaimStrings{"http://example.com/api/oauth/token"},
+				ID:        jti,
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+			})
+			token.Header["kid"] = "k1"
+			signed, err := token.SignedString(key)
+			require.NoError(t, err)
+			return signed
+		}
+		cch := cache.NewMemoryCache(nil)
+		exchange := func(params url.Values) (*httptest.ResponseRecorder, api.TokenResponse) {
+			form := url.Values{"grant_type": {service.GrantTypeJWTBearer}, "client_id": {"ci"}}
+			for k, v := range params {
+				form[k] = v
+			}
+			c, rec := newCtx(e, form.Encode(), "")
+			require.NoError(t, TokenHandler(db, cch)(c))
+			var resp api.TokenResponse
+			_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+			return rec, resp
+		}
+
+		t.Run("client subject", func(t *testing.T) {
+			assertion := sign("repo:app:main", "j1")
+			rec, resp := exchange(url.Values{"assertion": {assertion}})
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Empty(t, resp.RefreshToken)
+			require.Equal(t, "users:read users:write", resp.Scope)
+			claims, err := service.VerifyAccessToken(ctx, cch, resp.AccessToken)
+			require.NoError(t, err)
+			require.Equal(t, "ci", claims.Subject)
+			require.Equal(t, 1, claims.UserID)
+
+			// 同一個 jti 不可重複使用
+			rec, _ = exchange(url.Values{"assertion": {assertion}})
+			requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidGrant)
+		})
+
+		t.Run("user subject", func(t *testing.T) {
+			rec, resp := exchange(url.Values{"assertion": {sign("deploy", "j2")}})
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, "users:read", resp.Scope)
+			claims, err := service.VerifyAccessToken(ctx, cch, resp.AccessToken)
+			require.NoError(t, err)
+			require.Equal(t, "1", claims.Subject)
+			require.Equal(t, "ci", claims.ClientID)
+		})
+
+		t.Run("errors", func(t *testing.T) {
+			cases := map[string]struct {
+				params url.Values
+				code   string
+			}{
+				"missing assertion":   {url.Values{}, errCodeInvalidRequest},
+				"invalid assertion":   {url.Values{"assertion": {"garbage"}}, errCodeInvalidGrant},
+				"unmapped subject":    {url.Values{"assertion": {sign("repo:other", "j3")}}, errCodeInvalidGrant},
+				"scope beyond policy": {url.Values{"assertion": {sign("deploy", "j4")}, "scope": {"users:write"}}, errCodeInvalidScope},
+				"unknown user":        {url.Values{"assertion": {sign("ghost", "j5")}}, errCodeInvalidGrant},
+			}
+			for name, tc := range cases {
+				rec, _ := exchange(tc.params)
+				resp := requireOAuthError(t, rec, http.StatusBadRequest, tc.code)
+				require.NotEmpty(t, resp.ErrorDescription, name)
+			}
+		})
+
+		t.Run("cache error", func(t *testing.T) {
+			failing := &cache.FakeCache{SetNXFn: func(context.Context, string, any, time.Duration) *redis.BoolCmd {
+				return redis.NewBoolResult(false, errors.New("down"))
+			}}
+			form := url.Values{"grant_type": {service.GrantTypeJWTBearer}, "client_id": {"ci"}, "assertion": {sign("repo:app:x", "j6")}}
+			c, rec := newCtx(e, form.Encode(), "")
+			require.NoError(t, TokenHandler(db, failing)(c))
+			requireOAuthError(t, rec, http.StatusInternalServerError, errCodeServerError)
+		})
 	})
 
 	t.Run("unsupported grant type", func(t *testing.T) {
@@ -307,6 +1319,15 @@ func TestTokenHandler(t *testing.T) {
 		ctx, rec := newCtx(e, "grant_type=foo", validAuth)
 		err := TokenHandler(db, &cache.FakeCache{})(ctx)
 		require.NoError(t, err)
-		require.Equal(t, http.StatusBadRequest, rec.Code)
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeUnsupportedGrantType)
+	})
+
+	t.Run("missing grant type", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(ctx context.Context, q string, args ...any) pgx.Row {
+			return &fakeClientRow{client: client}
+		}}
+		ctx, rec := newCtx(e, "username=u", validAuth)
+		require.NoError(t, TokenHandler(db, &cache.FakeCache{})(ctx))
+		requireOAuthError(t, rec, http.StatusBadRequest, errCodeInvalidRequest)
 	})
 }
@@ -0,0 +1,87 @@
+package users
+
+import (
+	"errors"
+	"net/http"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/labstack/echo/v4"
+)
+
+// @Summary     Verify email address
+// @Description 以驗證信中的 token 完成 Email 驗證；token 只能使用一次，且寄出後 Email 若已變更則失效
+// @Tags        users
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       token formData string true "驗證信中的 token"
+// @Success     204   "No Content"
+// @Failure     400   {object} api.ErrorResponse
+// @Failure     500   {object} api.ErrorResponse
+// @Router      /users/email/verify [post]
+func VerifyEmailHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.VerifyEmailRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid form data"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		ctx := c.Request().Context()
+		userID, email, err := service.ConsumeEmailVerificationToken(ctx, cache, req.Token)
+		if errors.Is(err, service.ErrInvalidEmailVerificationToken) {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to verify token"})
+		}
+		verified, err := store.VerifyUserEmail(ctx, db, userID, email)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		if !verified {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: service.ErrInvalidEmailVerificationToken.Error()})
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// @Summary     Resend email verification
+// @Description 重新寄送驗證信至當前使用者的 Email；先前寄出的 token 在效期內仍可使用
+// @Tags        users
+// @Produce     json
+// @Success     202 "Accepted"
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     409 {object} api.ErrorResponse "Email 已驗證"
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
+// @Router      /users/me/email/verification [post]
+func ResendMyEmailVerificationHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		user, err := getUserByID(c.Request().Context(), db, claims.UserID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		if user.EmailVerified {
+			return c.JSON(http.StatusConflict, api.ErrorResponse{Message: "email already verified"})
+		}
+		if err := sendVerification(c.Request().Context(), cache, *user); err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to send verification mail"})
+		}
+		return c.NoContent(http.StatusAccepted)
+	}
+}
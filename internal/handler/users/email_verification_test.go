@@ -0,0 +1,152 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyEmailHandler(t *testing.T) {
+	t.Setenv("JWT_SECRET", "s")
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	user := model.User{ID: 7, Email: "alice@example.com"}
+	verify := func(db database.DB, c cache.Cache, token string) (int, string) {
+		ctx, rec := newFormCtx(e, url.Values{"token": {token}}.Encode())
+		require.NoError(t, VerifyEmailHandler(db, c)(ctx))
+		return rec.Code, rec.Body.String()
+	}
+
+	t.Run("bind and validate errors", func(t *testing.T) {
+		ctx, rec := newFormCtx(e, "%")
+		require.NoError(t, VerifyEmailHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		e := echo.New()
+		e.Validator = &stubValidator{err: errors.New("token required")}
+		ctx, rec = newFormCtx(e, "")
+		require.NoError(t, VerifyEmailHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("success and reuse", func(t *testing.T) {
+		c := cache.NewMemoryCache(nil)
+		token, err := service.IssueEmailVerificationToken(context.Background(), c, user)
+		require.NoError(t, err)
+		var gotArgs []any
+		db := &database.FakeDB{ExecFn: func(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+			gotArgs = args
+			return pgconn.NewCommandTag("UPDATE 1"), nil
+		}}
+
+		code, _ := verify(db, c, token)
+		require.Equal(t, http.StatusNoContent, code)
+		require.Equal(t, []any{7, "alice@example.com"}, gotArgs)
+
+		code, body := verify(db, c, token)
+		require.Equal(t, http.StatusBadRequest, code)
+		require.Contains(t, body, "invalid or expired verification token")
+	})
+
+	t.Run("email changed", func(t *testing.T) {
+		c := cache.NewMemoryCache(nil)
+		token, err := service.IssueEmailVerificationToken(context.Background(), c, user)
+		require.NoError(t, err)
+		db := &database.FakeDB{ExecFn: func(context.Context, string, ...any) (pgconn.CommandTag, error) {
+			return pgconn.NewCommandTag("UPDATE 0"), nil
+		}}
+		code, _ := verify(db, c, token)
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		c := cache.NewMemoryCache(nil)
+		token, err := service.IssueEmailVerificationToken(context.Background(), c, user)
+		require.NoError(t, err)
+		db := &database.FakeDB{ExecFn: func(context.Context, string, ...any) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag{}, errors.New("db")
+		}}
+		code, _ := verify(db, c, token)
+		require.Equal(t, http.StatusInternalServerError, code)
+	})
+
+	t.Run("cache error", func(t *testing.T) {
+		token, err := service.IssueEmailVerificationToken(context.Background(), cache.NewMemoryCache(nil), user)
+		require.NoError(t, err)
+		c := &cache.FakeCache{DelFn: func(context.Context, ...string) *redis.IntCmd {
+			return redis.NewIntResult(0, errors.New("down"))
+		}}
+		code, _ := verify(nil, c, token)
+		require.Equal(t, http.StatusInternalServerError, code)
+	})
+}
+
+func TestResendMyEmailVerificationHandler(t *testing.T) {
+	e := echo.New()
+	newCtx := func(userID int) (echo.Context, func() int) {
+		ctx, rec := newMeCtx(e, http.MethodPost, "")
+		if userID != 0 {
+			ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: userID})
+		}
+		return ctx, func() int { return rec.Code }
+	}
+
+	t.Run("no claims", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, code := newCtx(0)
+		require.NoError(t, ResendMyEmailVerificationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, code())
+	})
+
+	t.Run("get error", func(t *testing.T) {
+		t.Cleanup(restore)
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return nil, errors.New("g") }
+		ctx, code := newCtx(1)
+		require.NoError(t, ResendMyEmailVerificationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, code())
+	})
+
+	t.Run("already verified", func(t *testing.T) {
+		t.Cleanup(restore)
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) {
+			return &model.User{ID: 1, EmailVerified: true}, nil
+		}
+		ctx, code := newCtx(1)
+		require.NoError(t, ResendMyEmailVerificationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusConflict, code())
+	})
+
+	t.Run("send", func(t *testing.T) {
+		t.Cleanup(restore)
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) {
+			return &model.User{ID: 1, Email: "a@b.com"}, nil
+		}
+		var sent []string
+		sendVerification = func(_ context.Context, _ cache.Cache, u model.User) error {
+			sent = append(sent, u.Email)
+			return nil
+		}
+		ctx, code := newCtx(1)
+		require.NoError(t, ResendMyEmailVerificationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusAccepted, code())
+		require.Equal(t, []string{"a@b.com"}, sent)
+
+		sendVerification = func(context.Context, cache.Cache, model.User) error { return errors.New("smtp") }
+		ctx, code = newCtx(1)
+		require.NoError(t, ResendMyEmailVerificationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, code())
+	})
+}
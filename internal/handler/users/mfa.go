@@ -0,0 +1,211 @@
+package users
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	beginTOTPEnrollment     = service.BeginTOTPEnrollment
+	confirmTOTPEnrollment   = service.ConfirmTOTPEnrollment
+	regenerateRecoveryCodes = service.RegenerateRecoveryCodes
+	disableMFA              = service.DisableMFA
+	deleteUserMFA           = store.DeleteUserMFA
+)
+
+// @Summary     Begin TOTP enrollment
+// @Description 產生新的 TOTP 金鑰與 otpauth URI 供驗證器 App 註冊，取代先前未確認的註冊；須以 /users/me/mfa/totp/confirm 確認後才會啟用
+// @Tags        users
+// @Produce     json
+// @Success     200 {object} api.TOTPEnrollmentResponse
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     403 {object} api.ErrorResponse "僅接受使用者直接登入的 token"
+// @Failure     409 {object} api.ErrorResponse "已啟用 MFA"
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Router      /users/me/mfa/totp [post]
+func BeginMyTOTPEnrollmentHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		user, err := getUserByID(c.Request().Context(), db, claims.UserID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		enrollment, err := beginTOTPEnrollment(c.Request().Context(), db, *user)
+		if errors.Is(err, service.ErrMFAAlreadyEnabled) {
+			return c.JSON(http.StatusConflict, api.ErrorResponse{Message: err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to begin totp enrollment"})
+		}
+		return c.JSON(http.StatusOK, api.TOTPEnrollmentResponse{Secret: enrollment.Secret, URI: enrollment.URI})
+	}
+}
+
+// @Summary     Confirm TOTP enrollment
+// @Description 以驗證器 App 產生的驗證碼確認註冊並啟用 MFA，回傳只會顯示這一次的 recovery code
+// @Tags        users
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       code formData string true "驗證器 App 產生的 TOTP 驗證碼"
+// @Success     200  {object} api.RecoveryCodesResponse
+// @Failure     400  {object} api.ErrorResponse
+// @Failure     401  {object} api.ErrorResponse
+// @Failure     403  {object} api.ErrorResponse "僅接受使用者直接登入的 token"
+// @Failure     409  {object} api.ErrorResponse "已啟用 MFA"
+// @Failure     500  {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Router      /users/me/mfa/totp/confirm [post]
+func ConfirmMyTOTPEnrollmentHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.MFACodeRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid form data"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		codes, err := confirmTOTPEnrollment(c.Request().Context(), db, claims.UserID, req.Code)
+		switch {
+		case errors.Is(err, service.ErrInvalidMFACode), errors.Is(err, service.ErrTOTPEnrollmentNotFound):
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		case errors.Is(err, service.ErrMFAAlreadyEnabled):
+			return c.JSON(http.StatusConflict, api.ErrorResponse{Message: err.Error()})
+		case err != nil:
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to confirm totp enrollment"})
+		}
+		return c.JSON(http.StatusOK, api.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// @Summary     Regenerate recovery codes
+// @Description 驗證 TOTP 驗證碼或 recovery code 後產生新的 recovery code，先前的 recovery code 全部失效；驗證失敗次數受限
+// @Tags        users
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       code formData string true "TOTP 驗證碼或 recovery code"
+// @Success     200  {object} api.RecoveryCodesResponse
+// @Failure     400  {object} api.ErrorResponse
+// @Failure     401  {object} api.ErrorResponse
+// @Failure     403  {object} api.ErrorResponse "僅接受使用者直接登入的 token"
+// @Failure     409  {object} api.ErrorResponse "未啟用 MFA"
+// @Failure     429  {object} api.ErrorResponse
+// @Failure     500  {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Router      /users/me/mfa/recovery-codes [post]
+func RegenerateMyRecoveryCodesHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.MFACodeRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid form data"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		codes, err := regenerateRecoveryCodes(c.Request().Context(), db, cache, claims.UserID, req.Code)
+		if err != nil {
+			return mfaCodeError(c, err, "failed to regenerate recovery codes")
+		}
+		return c.JSON(http.StatusOK, api.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// @Summary     Disable MFA
+// @Description 驗證 TOTP 驗證碼或 recovery code 後停用 MFA，刪除 TOTP 金鑰與所有 recovery code；驗證失敗次數受限
+// @Tags        users
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       code formData string true "TOTP 驗證碼或 recovery code"
+// @Success     204  "No Content"
+// @Failure     400  {object} api.ErrorResponse
+// @Failure     401  {object} api.ErrorResponse
+// @Failure     403  {object} api.ErrorResponse "僅接受使用者直接登入的 token"
+// @Failure     409  {object} api.ErrorResponse "未啟用 MFA"
+// @Failure     429  {object} api.ErrorResponse
+// @Failure     500  {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Router      /users/me/mfa/totp [delete]
+func DisableMyMFAHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.MFACodeRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid form data"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		if err := disableMFA(c.Request().Context(), db, cache, claims.UserID, req.Code); err != nil {
+			return mfaCodeError(c, err, "failed to disable mfa")
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// @Summary     Reset a user's MFA
+// @Description 管理員刪除使用者的 TOTP 金鑰與所有 recovery code，供遺失驗證器與 recovery code 的使用者重新註冊
+// @Tags        users
+// @Param       user_id path int true "使用者 ID"
+// @Success     204     "No Content"
+// @Failure     400     {object} api.ErrorResponse "參數錯誤"
+// @Failure     500     {object} api.ErrorResponse "伺服器錯誤"
+// @Security    ApiKeyAuth
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
+// @Router      /users/{user_id}/mfa [delete]
+func ResetUserMFAHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid user ID"})
+		}
+		if err := deleteUserMFA(c.Request().Context(), db, id); err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// mfaCodeError 將 MFA 驗證碼的驗證錯誤轉為回應
+func mfaCodeError(c echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidMFACode):
+		return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: err.Error()})
+	case errors.Is(err, service.ErrMFANotEnabled):
+		return c.JSON(http.StatusConflict, api.ErrorResponse{Message: err.Error()})
+	case errors.Is(err, service.ErrRateLimited):
+		return c.JSON(http.StatusTooManyRequests, api.ErrorResponse{Message: err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: message})
+}
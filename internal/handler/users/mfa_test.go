@@ -0,0 +1,267 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBeginMyTOTPEnrollmentHandler(t *testing.T) {
+	e := echo.New()
+	newCtx := func(userID int) (echo.Context, *httptest.ResponseRecorder) {
+		ctx, rec := newMeCtx(e, http.MethodPost, "")
+		if userID != 0 {
+			ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: userID})
+		}
+		return ctx, rec
+	}
+
+	t.Run("no claims", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newCtx(0)
+		require.NoError(t, BeginMyTOTPEnrollmentHandler(nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("get error", func(t *testing.T) {
+		t.Cleanup(restore)
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return nil, errors.New("g") }
+		ctx, rec := newCtx(1)
+		require.NoError(t, BeginMyTOTPEnrollmentHandler(nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"already enabled", service.ErrMFAAlreadyEnabled, http.StatusConflict},
+		{"enroll error", errors.New("db"), http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(restore)
+			getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return &model.User{ID: 1}, nil }
+			beginTOTPEnrollment = func(context.Context, database.DB, model.User) (*service.TOTPEnrollment, error) {
+				return nil, tc.err
+			}
+			ctx, rec := newCtx(1)
+			require.NoError(t, BeginMyTOTPEnrollmentHandler(nil)(ctx))
+			require.Equal(t, tc.code, rec.Code)
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		t.Cleanup(restore)
+		getUserByID = func(_ context.Context, _ database.DB, id int) (*model.User, error) {
+			return &model.User{ID: id, Name: "alice"}, nil
+		}
+		var enrolled model.User
+		beginTOTPEnrollment = func(_ context.Context, _ database.DB, u model.User) (*service.TOTPEnrollment, error) {
+			enrolled = u
+			return &service.TOTPEnrollment{Secret: "ABC", URI: "otpauth://totp/x"}, nil
+		}
+		ctx, rec := newCtx(3)
+		require.NoError(t, BeginMyTOTPEnrollmentHandler(nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "alice", enrolled.Name)
+		var resp api.TOTPEnrollmentResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, api.TOTPEnrollmentResponse{Secret: "ABC", URI: "otpauth://totp/x"}, resp)
+	})
+}
+
+func TestConfirmMyTOTPEnrollmentHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+
+	t.Run("validate error", func(t *testing.T) {
+		t.Cleanup(restore)
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newMeCtx(e, http.MethodPost, "code=")
+		require.NoError(t, ConfirmMyTOTPEnrollmentHandler(nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("no claims", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newMeCtx(e, http.MethodPost, "code=123456")
+		require.NoError(t, ConfirmMyTOTPEnrollmentHandler(nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"invalid code", service.ErrInvalidMFACode, http.StatusBadRequest},
+		{"not enrolled", service.ErrTOTPEnrollmentNotFound, http.StatusBadRequest},
+		{"already enabled", service.ErrMFAAlreadyEnabled, http.StatusConflict},
+		{"confirm error", errors.New("db"), http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(restore)
+			confirmTOTPEnrollment = func(context.Context, database.DB, int, string) ([]string, error) { return nil, tc.err }
+			ctx, rec := newMeCtx(e, http.MethodPost, "code=123456")
+			ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+			require.NoError(t, ConfirmMyTOTPEnrollmentHandler(nil)(ctx))
+			require.Equal(t, tc.code, rec.Code)
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		t.Cleanup(restore)
+		var gotID int
+		var gotCode string
+		confirmTOTPEnrollment = func(_ context.Context, _ database.DB, id int, code string) ([]string, error) {
+			gotID, gotCode = id, code
+			return []string{"k3m9-x2q7", "p8d4-w6n1"}, nil
+		}
+		ctx, rec := newMeCtx(e, http.MethodPost, "code=123456")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 4})
+		require.NoError(t, ConfirmMyTOTPEnrollmentHandler(nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, 4, gotID)
+		require.Equal(t, "123456", gotCode)
+		var resp api.RecoveryCodesResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, []string{"k3m9-x2q7", "p8d4-w6n1"}, resp.RecoveryCodes)
+	})
+}
+
+func TestRegenerateMyRecoveryCodesHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+
+	t.Run("no claims", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newMeCtx(e, http.MethodPost, "code=123456")
+		require.NoError(t, RegenerateMyRecoveryCodesHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"invalid code", service.ErrInvalidMFACode, http.StatusUnauthorized},
+		{"not enabled", service.ErrMFANotEnabled, http.StatusConflict},
+		{"rate limited", service.ErrRateLimited, http.StatusTooManyRequests},
+		{"regenerate error", errors.New("db"), http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(restore)
+			regenerateRecoveryCodes = func(context.Context, database.DB, cache.Cache, int, string) ([]string, error) {
+				return nil, tc.err
+			}
+			ctx, rec := newMeCtx(e, http.MethodPost, "code=123456")
+			ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+			require.NoError(t, RegenerateMyRecoveryCodesHandler(nil, nil)(ctx))
+			require.Equal(t, tc.code, rec.Code)
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		t.Cleanup(restore)
+		regenerateRecoveryCodes = func(_ context.Context, _ database.DB, _ cache.Cache, id int, code string) ([]string, error) {
+			return []string{fmt.Sprintf("%d-%s", id, code)}, nil
+		}
+		ctx, rec := newMeCtx(e, http.MethodPost, "code=k3m9-x2q7")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 5})
+		require.NoError(t, RegenerateMyRecoveryCodesHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), "5-k3m9-x2q7")
+	})
+}
+
+func TestDisableMyMFAHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+
+	t.Run("no claims", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newMeCtx(e, http.MethodDelete, "code=123456")
+		require.NoError(t, DisableMyMFAHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("invalid code", func(t *testing.T) {
+		t.Cleanup(restore)
+		disableMFA = func(context.Context, database.DB, cache.Cache, int, string) error { return service.ErrInvalidMFACode }
+		ctx, rec := newMeCtx(e, http.MethodDelete, "code=000000")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, DisableMyMFAHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		t.Cleanup(restore)
+		var gotID int
+		disableMFA = func(_ context.Context, _ database.DB, _ cache.Cache, id int, _ string) error {
+			gotID = id
+			return nil
+		}
+		ctx, rec := newMeCtx(e, http.MethodDelete, "code=123456")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 6})
+		require.NoError(t, DisableMyMFAHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, 6, gotID)
+	})
+}
+
+func TestResetUserMFAHandler(t *testing.T) {
+	e := echo.New()
+	newCtx := func(id string) (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+id+"/mfa", nil)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues(id)
+		return ctx, rec
+	}
+
+	t.Run("invalid id", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newCtx("abc")
+		require.NoError(t, ResetUserMFAHandler(nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("delete error", func(t *testing.T) {
+		t.Cleanup(restore)
+		deleteUserMFA = func(context.Context, database.DB, int) error { return errors.New("d") }
+		ctx, rec := newCtx("1")
+		require.NoError(t, ResetUserMFAHandler(nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		t.Cleanup(restore)
+		var gotID int
+		deleteUserMFA = func(_ context.Context, _ database.DB, id int) error {
+			gotID = id
+			return nil
+		}
+		ctx, rec := newCtx("7")
+		require.NoError(t, ResetUserMFAHandler(nil)(ctx))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, 7, gotID)
+	})
+}
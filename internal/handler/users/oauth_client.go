@@ -2,6 +2,7 @@ package users
 
 import (
 	"net/http"
+	"slices"
 	"time"
 
 	"life-is-hard/internal/api"
@@ -14,7 +15,23 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// defaultClientSecretGrace 為輪替時未指定 grace_period 的預設寬限期
+	defaultClientSecretGrace = 24 * time.Hour
+	// errFirstPartyAdminOnly 為非管理員標記第一方 client 時的錯誤訊息；第一方 client 可略過使用者同意
+	errFirstPartyAdminOnly = "only admins can set first_party"
+	// errTokenExchangeAudiencesAdminOnly 為非管理員設定 token_exchange_audiences 時的錯誤訊息；可交換的 aud 決定 client 能取得哪些服務的 token
+	errTokenExchangeAudiencesAdminOnly = "only admins can set token_exchange_audiences"
+	// errAccessTokenAudiencesAdminOnly 為非管理員設定 access_token_audiences 時的錯誤訊息；aud 決定 token 可被哪些服務接受
+	errAccessTokenAudiencesAdminOnly = "only admins can set access_token_audiences"
+	// errReservedClientIDAdminOnly 為非管理員註冊信任簽發者對應的 client_id 時的錯誤訊息；該 client 可兌換簽發者的 assertion
+	errReservedClientIDAdminOnly = "client_id is reserved by a trusted issuer"
+)
+
+var rotateClientSecret = service.RotateClientSecret
+
 // @Summary     Create OAuth client for authenticated user
+// @Description client_secret 由伺服器產生，僅在此回應中出現一次，之後只保存雜湊；token_endpoint_auth_method 為 private_key_jwt、none 或 mTLS 方式時不產生 secret，private_key_jwt 須提供 jwks；tls_client_auth 須提供 tls_client_auth_subject_dn，self_signed_tls_client_auth 須提供 tls_client_certificate_thumbprint。access_token_ttl 等 token 效期以秒為單位，0 表示沿用伺服器預設；access_token_audiences 為 access token 的 aud；token_exchange_audiences、access_token_audiences 與 first_party（標記免同意的第一方 client）僅限管理員設定；信任簽發者對應規則使用的 client_id 僅限管理員註冊
 // @Tags        users
 // @Accept      json
 // @Produce     json
@@ -22,10 +39,11 @@ import (
 // @Success     201 {object} api.OAuthClientResponse
 // @Failure     400 {object} api.ErrorResponse
 // @Failure     401 {object} api.ErrorResponse
+// @Failure     403 {object} api.ErrorResponse
 // @Failure     500 {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[clients:manage]
+// @Security    OAuth2Password[clients:manage]
 // @Router      /users/me/oauth-clients [post]
 func CreateMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -41,24 +59,61 @@ func CreateMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 		if err := c.Validate(&req); err != nil {
 			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
 		}
+		firstParty := req.FirstParty != nil && *req.FirstParty
+		if firstParty && !claims.IsAdmin {
+			return c.JSON(http.StatusForbidden, api.ErrorResponse{Message: errFirstPartyAdminOnly})
+		}
+		if len(req.TokenExchangeAudiences) > 0 && !claims.IsAdmin {
+			return c.JSON(http.StatusForbidden, api.ErrorResponse{Message: errTokenExchangeAudiencesAdminOnly})
+		}
+		if len(req.AccessTokenAudiences) > 0 && !claims.IsAdmin {
+			return c.JSON(http.StatusForbidden, api.ErrorResponse{Message: errAccessTokenAudiencesAdminOnly})
+		}
+		if service.ReservedByTrustedIssuer(req.ClientID) && !claims.IsAdmin {
+			return c.JSON(http.StatusForbidden, api.ErrorResponse{Message: errReservedClientIDAdminOnly})
+		}
 
 		client := &model.OAuthClient{
-			ClientID:     req.ClientID,
-			ClientSecret: req.ClientSecret,
-			UserID:       claims.UserID,
-			GrantTypes:   req.GrantTypes,
+			ClientID:                              req.ClientID,
+			UserID:                                claims.UserID,
+			ClientType:                            req.ClientType,
+			ClientName:                            req.ClientName,
+			LogoURI:                               req.LogoURI,
+			GrantTypes:                            req.GrantTypes,
+			RedirectURIs:                          req.RedirectURIs,
+			Scopes:                                req.Scopes,
+			TokenEndpointAuthMethod:               req.TokenEndpointAuthMethod,
+			JWKS:                                  req.JWKS,
+			TLSClientAuthSubjectDN:                req.TLSClientAuthSubjectDN,
+			TLSClientCertificateThumbprint:        req.TLSClientCertificateThumbprint,
+			TLSClientCertificateBoundAccessTokens: req.TLSClientCertificateBoundAccessTokens,
+			RequirePushedAuthorizationRequests:    req.RequirePushedAuthorizationRequests,
+			TokenExchangeAudiences:                req.TokenExchangeAudiences,
+			AccessTokenTTL:                        req.AccessTokenTTL,
+			RefreshTokenTTL:                       req.RefreshTokenTTL,
+			RefreshTokenAbsoluteTTL:               req.RefreshTokenAbsoluteTTL,
+			RefreshTokenIdleTimeout:               req.RefreshTokenIdleTimeout,
+			IDTokenTTL:                            req.IDTokenTTL,
+			AccessTokenAudiences:                  req.AccessTokenAudiences,
+			FirstParty:                            firstParty,
+		}
+		if err := service.ValidateOAuthClient(client); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+		var secret string
+		if service.NeedsClientSecret(client) {
+			var err error
+			if secret, err = rotateClientSecret(client, 0); err != nil {
+				return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to generate client secret"})
+			}
 		}
 		if err := store.CreateOAuthClient(c.Request().Context(), db, client); err != nil {
 			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
 		}
-		return c.JSON(http.StatusCreated, api.OAuthClientResponse{
-			ClientID:     client.ClientID,
-			ClientSecret: client.ClientSecret,
-			UserID:       client.UserID,
-			GrantTypes:   client.GrantTypes,
-			CreatedAt:    client.CreatedAt,
-			UpdatedAt:    client.UpdatedAt,
-		})
+
+		resp := newOAuthClientResponse(*client)
+		resp.ClientSecret = secret
+		return c.JSON(http.StatusCreated, resp)
 	}
 }
 
@@ -70,8 +125,8 @@ func CreateMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 // @Failure     401 {object} api.ErrorResponse
 // @Failure     500 {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[clients:manage]
+// @Security    OAuth2Password[clients:manage]
 // @Router      /users/me/oauth-clients [get]
 func ListMyOAuthClientsHandler(db database.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -87,14 +142,7 @@ func ListMyOAuthClientsHandler(db database.DB) echo.HandlerFunc {
 
 		resp := make([]api.OAuthClientResponse, len(clients))
 		for i, client := range clients {
-			resp[i] = api.OAuthClientResponse{
-				ClientID:     client.ClientID,
-				ClientSecret: client.ClientSecret,
-				UserID:       client.UserID,
-				GrantTypes:   client.GrantTypes,
-				CreatedAt:    client.CreatedAt,
-				UpdatedAt:    client.UpdatedAt,
-			}
+			resp[i] = newOAuthClientResponse(client)
 		}
 		return c.JSON(http.StatusOK, resp)
 	}
@@ -111,8 +159,8 @@ func ListMyOAuthClientsHandler(db database.DB) echo.HandlerFunc {
 // @Failure     404 {object} api.ErrorResponse
 // @Failure     500 {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[clients:manage]
+// @Security    OAuth2Password[clients:manage]
 // @Router      /users/me/oauth-clients/{client_id} [get]
 func GetMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -129,18 +177,12 @@ func GetMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusNotFound, api.ErrorResponse{Message: "client not found"})
 		}
 
-		return c.JSON(http.StatusOK, api.OAuthClientResponse{
-			ClientID:     client.ClientID,
-			ClientSecret: client.ClientSecret,
-			UserID:       client.UserID,
-			GrantTypes:   client.GrantTypes,
-			CreatedAt:    client.CreatedAt,
-			UpdatedAt:    client.UpdatedAt,
-		})
+		return c.JSON(http.StatusOK, newOAuthClientResponse(*client))
 	}
 }
 
 // @Summary     Update OAuth client for authenticated user
+// @Description 改用 client_secret_basic、client_secret_post 或 client_secret_jwt 而尚無可用的 secret 時，會產生新的 client_secret 並僅在此回應中出現一次；未帶 first_party、token_exchange_audiences 或 access_token_audiences 時維持原設定，變更僅限管理員
 // @Tags        users
 // @Accept      json
 // @Produce     json
@@ -149,11 +191,12 @@ func GetMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 // @Success     200 {object} api.OAuthClientResponse
 // @Failure     400 {object} api.ErrorResponse
 // @Failure     401 {object} api.ErrorResponse
+// @Failure     403 {object} api.ErrorResponse
 // @Failure     404 {object} api.ErrorResponse
 // @Failure     500 {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[clients:manage]
+// @Security    OAuth2Password[clients:manage]
 // @Router      /users/me/oauth-clients/{client_id} [put]
 func UpdateMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -178,22 +221,126 @@ func UpdateMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusNotFound, api.ErrorResponse{Message: "client not found"})
 		}
 
-		client.ClientSecret = req.ClientSecret
+		client.ClientType = req.ClientType
+		client.ClientName = req.ClientName
+		client.LogoURI = req.LogoURI
 		client.GrantTypes = req.GrantTypes
+		client.RedirectURIs = req.RedirectURIs
+		client.Scopes = req.Scopes
+		client.TokenEndpointAuthMethod = req.TokenEndpointAuthMethod
+		client.JWKS = req.JWKS
+		client.TLSClientAuthSubjectDN = req.TLSClientAuthSubjectDN
+		client.TLSClientCertificateThumbprint = req.TLSClientCertificateThumbprint
+		client.TLSClientCertificateBoundAccessTokens = req.TLSClientCertificateBoundAccessTokens
+		client.RequirePushedAuthorizationRequests = req.RequirePushedAuthorizationRequests
+		if req.TokenExchangeAudiences != nil && !slices.Equal(req.TokenExchangeAudiences, client.TokenExchangeAudiences) {
+			if !claims.IsAdmin {
+				return c.JSON(http.StatusForbidden, api.ErrorResponse{Message: errTokenExchangeAudiencesAdminOnly})
+			}
+			client.TokenExchangeAudiences = req.TokenExchangeAudiences
+		}
+		client.AccessTokenTTL = req.AccessTokenTTL
+		client.RefreshTokenTTL = req.RefreshTokenTTL
+		client.RefreshTokenAbsoluteTTL = req.RefreshTokenAbsoluteTTL
+		client.RefreshTokenIdleTimeout = req.RefreshTokenIdleTimeout
+		client.IDTokenTTL = req.IDTokenTTL
+		if req.AccessTokenAudiences != nil && !slices.Equal(req.AccessTokenAudiences, client.AccessTokenAudiences) {
+			if !claims.IsAdmin {
+				return c.JSON(http.StatusForbidden, api.ErrorResponse{Message: errAccessTokenAudiencesAdminOnly})
+			}
+			client.AccessTokenAudiences = req.AccessTokenAudiences
+		}
+		if req.FirstParty != nil && *req.FirstParty != client.FirstParty {
+			if !claims.IsAdmin {
+				return c.JSON(http.StatusForbidden, api.ErrorResponse{Message: errFirstPartyAdminOnly})
+			}
+			client.FirstParty = *req.FirstParty
+		}
+		if err := service.ValidateOAuthClient(client); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
 		client.UpdatedAt = time.Now().UTC()
 
+		// 改用以 secret 認證的方式但尚無可用的 secret 時，立即產生新 secret
+		var secret string
+		if service.NeedsClientSecret(client) {
+			if secret, err = rotateClientSecret(client, 0); err != nil {
+				return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to generate client secret"})
+			}
+		}
+
 		if err := store.UpdateOAuthClient(c.Request().Context(), db, client); err != nil {
 			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
 		}
+		if secret != "" {
+			if err := store.UpdateOAuthClientSecret(c.Request().Context(), db, client); err != nil {
+				return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+			}
+		}
+
+		resp := newOAuthClientResponse(*client)
+		resp.ClientSecret = secret
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// @Summary     Rotate OAuth client secret for authenticated user
+// @Description 產生新的 client_secret 並僅在此回應中出現一次；舊 secret 於 grace_period 秒內仍可使用，供部署切換
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Param       client_id path string true "Client ID"
+// @Param       request   body api.RotateOAuthClientSecretRequest false "Rotate OAuth client secret"
+// @Success     200 {object} api.OAuthClientResponse
+// @Failure     400 {object} api.ErrorResponse
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     404 {object} api.ErrorResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Application[clients:manage]
+// @Security    OAuth2Password[clients:manage]
+// @Router      /users/me/oauth-clients/{client_id}/secret [post]
+func RotateMyOAuthClientSecretHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		var req api.RotateOAuthClientSecretRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid request"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+		grace := defaultClientSecretGrace
+		if req.GracePeriod != nil {
+			grace = time.Duration(*req.GracePeriod) * time.Second
+		}
+
+		client, err := store.GetOAuthClientByClientID(c.Request().Context(), db, c.Param("client_id"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		if client.UserID != claims.UserID {
+			return c.JSON(http.StatusNotFound, api.ErrorResponse{Message: "client not found"})
+		}
+		if !client.UsesClientSecret() {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "client does not authenticate with a client secret"})
+		}
+
+		secret, err := rotateClientSecret(client, grace)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to generate client secret"})
+		}
+		if err := store.UpdateOAuthClientSecret(c.Request().Context(), db, client); err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
 
-		return c.JSON(http.StatusOK, api.OAuthClientResponse{
-			ClientID:     client.ClientID,
-			ClientSecret: client.ClientSecret,
-			UserID:       client.UserID,
-			GrantTypes:   client.GrantTypes,
-			CreatedAt:    client.CreatedAt,
-			UpdatedAt:    client.UpdatedAt,
-		})
+		resp := newOAuthClientResponse(*client)
+		resp.ClientSecret = secret
+		return c.JSON(http.StatusOK, resp)
 	}
 }
 
@@ -208,8 +355,8 @@ func UpdateMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 // @Failure     404 {object} api.ErrorResponse
 // @Failure     500 {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[clients:manage]
+// @Security    OAuth2Password[clients:manage]
 // @Router      /users/me/oauth-clients/{client_id} [delete]
 func DeleteMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -232,3 +379,37 @@ func DeleteMyOAuthClientHandler(db database.DB) echo.HandlerFunc {
 		return c.NoContent(http.StatusNoContent)
 	}
 }
+
+// newOAuthClientResponse 轉換為回應格式；secret 雜湊不會回傳，舊 secret 已過寬限期時不列出失效時間
+func newOAuthClientResponse(client model.OAuthClient) api.OAuthClientResponse {
+	resp := api.OAuthClientResponse{
+		ClientID:                              client.ClientID,
+		UserID:                                client.UserID,
+		ClientType:                            client.ClientType,
+		ClientName:                            client.ClientName,
+		LogoURI:                               client.LogoURI,
+		GrantTypes:                            client.GrantTypes,
+		RedirectURIs:                          client.RedirectURIs,
+		Scopes:                                client.Scopes,
+		CreatedAt:                             client.CreatedAt,
+		UpdatedAt:                             client.UpdatedAt,
+		TokenEndpointAuthMethod:               client.TokenEndpointAuthMethod,
+		JWKS:                                  client.JWKS,
+		TLSClientAuthSubjectDN:                client.TLSClientAuthSubjectDN,
+		TLSClientCertificateThumbprint:        client.TLSClientCertificateThumbprint,
+		TLSClientCertificateBoundAccessTokens: client.TLSClientCertificateBoundAccessTokens,
+		RequirePushedAuthorizationRequests:    client.RequirePushedAuthorizationRequests,
+		TokenExchangeAudiences:                client.TokenExchangeAudiences,
+		AccessTokenTTL:                        client.AccessTokenTTL,
+		RefreshTokenTTL:                       client.RefreshTokenTTL,
+		RefreshTokenAbsoluteTTL:               client.RefreshTokenAbsoluteTTL,
+		RefreshTokenIdleTimeout:               client.RefreshTokenIdleTimeout,
+		IDTokenTTL:                            client.IDTokenTTL,
+		AccessTokenAudiences:                  client.AccessTokenAudiences,
+		FirstParty:                            client.FirstParty,
+	}
+	if exp := client.PreviousClientSecretExpiresAt; exp != nil && time.Now().Before(*exp) {
+		resp.PreviousClientSecretExpiresAt = exp
+	}
+	return resp
+}
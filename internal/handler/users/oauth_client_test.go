@@ -2,6 +2,7 @@ package users
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -9,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"life-is-hard/internal/api"
 	"life-is-hard/internal/database"
 	"life-is-hard/internal/middleware"
 	"life-is-hard/internal/model"
@@ -32,13 +34,36 @@ func (r *fakeRow) Scan(dest ...any) error {
 	}
 	c := r.client
 	switch len(dest) {
-	case 6:
+	case 29:
 		*dest[0].(*string) = c.ClientID
 		*dest[1].(*string) = c.ClientSecret
 		*dest[2].(*int) = c.UserID
-		*dest[3].(*[]string) = c.GrantTypes
-		*dest[4].(*time.Time) = c.CreatedAt
-		*dest[5].(*time.Time) = c.UpdatedAt
+		*dest[3].(*string) = c.ClientType
+		*dest[4].(*string) = c.ClientName
+		*dest[5].(*string) = c.LogoURI
+		*dest[6].(*[]string) = c.GrantTypes
+		*dest[7].(*[]string) = c.RedirectURIs
+		*dest[8].(*[]string) = c.Scopes
+		*dest[9].(*time.Time) = c.CreatedAt
+		*dest[10].(*time.Time) = c.UpdatedAt
+		*dest[11].(*string) = c.PreviousClientSecret
+		*dest[12].(**time.Time) = c.PreviousClientSecretExpiresAt
+		*dest[13].(*string) = c.TokenEndpointAuthMethod
+		*dest[14].(*json.RawMessage) = c.JWKS
+		*dest[15].(*[]byte) = c.SealedClientSecret
+		*dest[16].(*string) = c.TLSClientAuthSubjectDN
+		*dest[17].(*string) = c.TLSClientCertificateThumbprint
+		*dest[18].(*bool) = c.TLSClientCertificateBoundAccessTokens
+		*dest[19].(*string) = c.RegistrationAccessToken
+		*dest[20].(*bool) = c.RequirePushedAuthorizationRequests
+		*dest[21].(*[]string) = c.TokenExchangeAudiences
+		*dest[22].(*int) = c.AccessTokenTTL
+		*dest[23].(*int) = c.RefreshTokenTTL
+		*dest[24].(*int) = c.RefreshTokenAbsoluteTTL
+		*dest[25].(*int) = c.RefreshTokenIdleTimeout
+		*dest[26].(*int) = c.IDTokenTTL
+		*dest[27].(*[]string) = c.AccessTokenAudiences
+		*dest[28].(*bool) = c.FirstParty
 	case 3:
 		*dest[0].(*string) = c.ClientID
 		*dest[1].(*time.Time) = c.CreatedAt
@@ -78,9 +103,32 @@ func (r *fakeRows) Scan(dest ...any) error {
 	*dest[0].(*string) = c.ClientID
 	*dest[1].(*string) = c.ClientSecret
 	*dest[2].(*int) = c.UserID
-	*dest[3].(*[]string) = c.GrantTypes
-	*dest[4].(*time.Time) = c.CreatedAt
-	*dest[5].(*time.Time) = c.UpdatedAt
+	*dest[3].(*string) = c.ClientType
+	*dest[4].(*string) = c.ClientName
+	*dest[5].(*string) = c.LogoURI
+	*dest[6].(*[]string) = c.GrantTypes
+	*dest[7].(*[]string) = c.RedirectURIs
+	*dest[8].(*[]string) = c.Scopes
+	*dest[9].(*time.Time) = c.CreatedAt
+	*dest[10].(*time.Time) = c.UpdatedAt
+	*dest[11].(*string) = c.PreviousClientSecret
+	*dest[12].(**time.Time) = c.PreviousClientSecretExpiresAt
+	*dest[13].(*string) = c.TokenEndpointAuthMethod
+	*dest[14].(*json.RawMessage) = c.JWKS
+	*dest[15].(*[]byte) = c.SealedClientSecret
+	*dest[16].(*string) = c.TLSClientAuthSubjectDN
+	*dest[17].(*string) = c.TLSClientCertificateThumbprint
+	*dest[18].(*bool) = c.TLSClientCertificateBoundAccessTokens
+	*dest[19].(*string) = c.RegistrationAccessToken
+	*dest[20].(*bool) = c.RequirePushedAuthorizationRequests
+	*dest[21].(*[]string) = c.TokenExchangeAudiences
+	*dest[22].(*int) = c.AccessTokenTTL
+	*dest[23].(*int) = c.RefreshTokenTTL
+	*dest[24].(*int) = c.RefreshTokenAbsoluteTTL
+	*dest[25].(*int) = c.RefreshTokenIdleTimeout
+	*dest[26].(*int) = c.IDTokenTTL
+	*dest[27].(*[]string) = c.AccessTokenAudiences
+	*dest[28].(*bool) = c.FirstParty
 	return nil
 }
 func (r *fakeRows) Values() ([]any, error) { return nil, nil }
@@ -107,12 +155,13 @@ func newClientCtx(e *echo.Echo, method, id, body string) (echo.Context, *httptes
 
 // sample OAuth client for tests
 var sampleClient = model.OAuthClient{
-	ClientID:     "cid",
-	ClientSecret: "sec",
-	UserID:       1,
-	GrantTypes:   []string{"password"},
-	CreatedAt:    time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
-	UpdatedAt:    time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	ClientID:                "cid",
+	ClientSecret:            "hash",
+	UserID:                  1,
+	GrantTypes:              []string{"password"},
+	CreatedAt:               time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	UpdatedAt:               time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	TokenEndpointAuthMethod: model.ClientAuthMethodSecretBasic,
 }
 
 func TestCreateMyOAuthClientHandler(t *testing.T) {
@@ -145,11 +194,21 @@ func TestCreateMyOAuthClientHandler(t *testing.T) {
 		e.Validator = &stubValidator{}
 	})
 
+	t.Run("invalid metadata", func(t *testing.T) {
+		body := `{"client_id":"c","grant_types":["authorization_code"],"redirect_uris":["http://app.example.com/cb"]}`
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		err := CreateMyOAuthClientHandler(nil)(ctx)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), "redirect_uri")
+	})
+
 	t.Run("store error", func(t *testing.T) {
 		db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
 			return &fakeRow{scanErr: errors.New("fail")}
 		}}
-		body := `{"client_id":"c","client_secret":"s","grant_types":["password"]}`
+		body := `{"client_id":"c","grant_types":["password"]}`
 		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
 		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 2})
 		err := CreateMyOAuthClientHandler(db)(ctx)
@@ -163,13 +222,165 @@ func TestCreateMyOAuthClientHandler(t *testing.T) {
 			c.ClientID = "new"
 			return &fakeRow{client: &c}
 		}}
-		body := `{"client_id":"new","client_secret":"s","grant_types":["password"]}`
+		body := `{"client_id":"new","client_name":"App","grant_types":["authorization_code"],"redirect_uris":["https://app.example.com/cb"]}`
 		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
 		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
 		err := CreateMyOAuthClientHandler(db)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusCreated, rec.Code)
 		require.Contains(t, rec.Body.String(), "\"client_id\":\"new\"")
+		require.Contains(t, rec.Body.String(), "\"client_type\":\"confidential\"")
+		require.Contains(t, rec.Body.String(), "\"client_name\":\"App\"")
+
+		var resp api.OAuthClientResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.ClientSecret, 43)
+		require.NotContains(t, rec.Body.String(), "hash")
+	})
+
+	t.Run("first party", func(t *testing.T) {
+		var args []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, a ...any) pgx.Row {
+			args = a
+			return &fakeRow{client: &sampleClient}
+		}}
+		body := `{"client_id":"new","grant_types":["password"],"first_party":true}`
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, CreateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Nil(t, args)
+
+		ctx, rec = newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1, IsAdmin: true})
+		require.NoError(t, CreateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, true, args[24])
+		require.Contains(t, rec.Body.String(), `"first_party":true`)
+	})
+
+	t.Run("access token audiences", func(t *testing.T) {
+		var args []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, a ...any) pgx.Row {
+			args = a
+			return &fakeRow{client: &sampleClient}
+		}}
+		body := `{"client_id":"new","grant_types":["password"],"access_token_audiences":["billing"]}`
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, CreateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Contains(t, rec.Body.String(), "access_token_audiences")
+		require.Nil(t, args)
+
+		ctx, rec = newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1, IsAdmin: true})
+		require.NoError(t, CreateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("token exchange audiences", func(t *testing.T) {
+		var args []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, a ...any) pgx.Row {
+			args = a
+			return &fakeRow{client: &sampleClient}
+		}}
+		body := `{"client_id":"new","grant_types":["password"],"token_exchange_audiences":["billing"]}`
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, CreateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Contains(t, rec.Body.String(), "token_exchange_audiences")
+		require.Nil(t, args)
+
+		ctx, rec = newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1, IsAdmin: true})
+		require.NoError(t, CreateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("reserved client_id", func(t *testing.T) {
+		t.Cleanup(func() { service.UseTrustedIssuers(nil) })
+		service.UseTrustedIssuers([]service.TrustedIssuer{{Issuer: "https://ci.example.com", Subjects: []service.SubjectMapping{{Subject: "repo:*", ClientID: "ci"}}}})
+		var args []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, a ...any) pgx.Row {
+			args = a
+			return &fakeRow{client: &sampleClient}
+		}}
+		body := `{"client_id":"ci","grant_types":["password"]}`
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, CreateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Contains(t, rec.Body.String(), "reserved")
+		require.Nil(t, args)
+
+		ctx, rec = newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1, IsAdmin: true})
+		require.NoError(t, CreateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("public client", func(t *testing.T) {
+		var args []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, a ...any) pgx.Row {
+			args = a
+			c := sampleClient
+			return &fakeRow{client: &c}
+		}}
+		body := `{"client_id":"app","client_type":"public","grant_types":["authorization_code"],"redirect_uris":["https://app.example.com/cb"]}`
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, CreateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "", args[1])
+		require.Equal(t, model.ClientAuthMethodNone, args[9])
+		var resp api.OAuthClientResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Empty(t, resp.ClientSecret)
+		require.Equal(t, model.ClientAuthMethodNone, resp.TokenEndpointAuthMethod)
+	})
+
+	t.Run("private_key_jwt requires jwks", func(t *testing.T) {
+		body := `{"client_id":"svc","grant_types":["client_credentials"],"token_endpoint_auth_method":"private_key_jwt"}`
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, CreateMyOAuthClientHandler(nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), "invalid jwks")
+	})
+
+	t.Run("self_signed_tls_client_auth", func(t *testing.T) {
+		var args []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, a ...any) pgx.Row {
+			args = a
+			c := sampleClient
+			return &fakeRow{client: &c}
+		}}
+		thumbprint := "A4DtL2JmUMhAsvJj5tKyn64SqzmuXbMrJa0n761y5v0"
+		body := `{"client_id":"svc","grant_types":["client_credentials"],"token_endpoint_auth_method":"self_signed_tls_client_auth",` +
+			`"tls_client_certificate_thumbprint":"` + thumbprint + `","tls_client_certificate_bound_access_tokens":true}`
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", body)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, CreateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, "", args[1])
+		require.Equal(t, thumbprint, args[13])
+		require.Equal(t, true, args[14])
+		var resp api.OAuthClientResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Empty(t, resp.ClientSecret)
+		require.Equal(t, thumbprint, resp.TLSClientCertificateThumbprint)
+		require.True(t, resp.TLSClientCertificateBoundAccessTokens)
+	})
+
+	t.Run("generate secret error", func(t *testing.T) {
+		t.Cleanup(func() { rotateClientSecret = service.RotateClientSecret })
+		rotateClientSecret = func(*model.OAuthClient, time.Duration) (string, error) { return "", errors.New("rand") }
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients", `{"client_id":"new","grant_types":["password"]}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, CreateMyOAuthClientHandler(nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 }
 
@@ -202,7 +413,7 @@ func TestListMyOAuthClientsHandler(t *testing.T) {
 		err := ListMyOAuthClientsHandler(db)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusOK, rec.Code)
-		require.Contains(t, rec.Body.String(), "client_secret")
+		require.NotContains(t, rec.Body.String(), `"client_secret":`)
 	})
 }
 
@@ -257,7 +468,7 @@ func TestUpdateMyOAuthClientHandler(t *testing.T) {
 	e := echo.New()
 	e.Validator = &stubValidator{}
 
-	body := `{"client_secret":"ns","grant_types":["password"]}`
+	body := `{"grant_types":["password"]}`
 
 	t.Run("no claims", func(t *testing.T) {
 		ctx, rec := newClientCtx(e, http.MethodPut, "cid", body)
@@ -308,6 +519,17 @@ func TestUpdateMyOAuthClientHandler(t *testing.T) {
 		require.Equal(t, http.StatusNotFound, rec.Code)
 	})
 
+	t.Run("invalid metadata", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeRow{client: &sampleClient}
+		}}
+		ctx, rec := newClientCtx(e, http.MethodPut, "cid", `{"client_type":"public","grant_types":["client_credentials"]}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		err := UpdateMyOAuthClientHandler(db)(ctx)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
 	t.Run("update error", func(t *testing.T) {
 		db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, _ ...any) pgx.Row {
 			if strings.HasPrefix(q, "UPDATE") {
@@ -324,9 +546,12 @@ func TestUpdateMyOAuthClientHandler(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		updated := sampleClient
-		updated.ClientSecret = "ns"
 		updated.UpdatedAt = updated.UpdatedAt.Add(time.Hour)
-		db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+		var updateQuery string
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, _ ...any) pgx.Row {
+			if strings.HasPrefix(q, "UPDATE") {
+				updateQuery = q
+			}
 			return &fakeRow{client: &updated}
 		}}
 		ctx, rec := newClientCtx(e, http.MethodPut, "cid", body)
@@ -334,8 +559,338 @@ func TestUpdateMyOAuthClientHandler(t *testing.T) {
 		err := UpdateMyOAuthClientHandler(db)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusOK, rec.Code)
-		require.Contains(t, rec.Body.String(), "\"client_secret\":\"ns\"")
+		require.NotContains(t, rec.Body.String(), `"client_secret":`)
+		require.NotContains(t, updateQuery, "client_secret")
 	})
+
+	t.Run("token lifetimes", func(t *testing.T) {
+		var updateArgs []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, a ...any) pgx.Row {
+			if strings.HasPrefix(q, "UPDATE") {
+				updateArgs = a
+			}
+			return &fakeRow{client: &sampleClient}
+		}}
+		ctx, rec := newClientCtx(e, http.MethodPut, "cid", `{"grant_types":["password"],"access_token_ttl":300,"refresh_token_idle_timeout":86400,"access_token_audiences":["billing"]}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Nil(t, updateArgs)
+
+		ctx, rec = newClientCtx(e, http.MethodPut, "cid", `{"grant_types":["password"],"access_token_ttl":300,"refresh_token_idle_timeout":86400,"access_token_audiences":["billing"]}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1, IsAdmin: true})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, 300, updateArgs[14])
+		require.Equal(t, 86400, updateArgs[17])
+		require.Equal(t, []string{"billing"}, updateArgs[19])
+		require.Contains(t, rec.Body.String(), `"access_token_ttl":300`)
+
+		ctx, rec = newClientCtx(e, http.MethodPut, "cid", `{"grant_types":["password"],"access_token_audiences":["a b"]}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1, IsAdmin: true})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("access token audiences", func(t *testing.T) {
+		restricted := sampleClient
+		restricted.AccessTokenAudiences = []string{"billing"}
+		var updateArgs []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, a ...any) pgx.Row {
+			if strings.HasPrefix(q, "UPDATE") {
+				updateArgs = a
+			}
+			c := restricted
+			return &fakeRow{client: &c}
+		}}
+		// 未帶或未變更時維持原設定
+		for _, body := range []string{`{"grant_types":["password"]}`, `{"grant_types":["password"],"access_token_audiences":["billing"]}`} {
+			updateArgs = nil
+			ctx, rec := newClientCtx(e, http.MethodPut, "cid", body)
+			ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+			require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+			require.Equal(t, http.StatusOK, rec.Code, body)
+			require.Equal(t, []string{"billing"}, updateArgs[19])
+		}
+
+		// 非管理員不可清除
+		updateArgs = nil
+		ctx, rec := newClientCtx(e, http.MethodPut, "cid", `{"grant_types":["password"],"access_token_audiences":[]}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Nil(t, updateArgs)
+
+		ctx, rec = newClientCtx(e, http.MethodPut, "cid", `{"grant_types":["password"],"access_token_audiences":[]}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1, IsAdmin: true})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Empty(t, updateArgs[19])
+	})
+
+	t.Run("token exchange audiences", func(t *testing.T) {
+		restricted := sampleClient
+		restricted.TokenExchangeAudiences = []string{"billing"}
+		var updateArgs []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, a ...any) pgx.Row {
+			if strings.HasPrefix(q, "UPDATE") {
+				updateArgs = a
+			}
+			c := restricted
+			return &fakeRow{client: &c}
+		}}
+		// 未帶或未變更時維持原設定
+		for _, body := range []string{`{"grant_types":["password"]}`, `{"grant_types":["password"],"token_exchange_audiences":["billing"]}`} {
+			updateArgs = nil
+			ctx, rec := newClientCtx(e, http.MethodPut, "cid", body)
+			ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+			require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+			require.Equal(t, http.StatusOK, rec.Code, body)
+			require.Equal(t, []string{"billing"}, updateArgs[13])
+		}
+
+		// 非管理員不可清除
+		updateArgs = nil
+		ctx, rec := newClientCtx(e, http.MethodPut, "cid", `{"grant_types":["password"],"token_exchange_audiences":[]}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Nil(t, updateArgs)
+
+		ctx, rec = newClientCtx(e, http.MethodPut, "cid", `{"grant_types":["password"],"token_exchange_audiences":[]}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1, IsAdmin: true})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Empty(t, updateArgs[13])
+	})
+
+	t.Run("first party", func(t *testing.T) {
+		firstParty := sampleClient
+		firstParty.FirstParty = true
+		var updateArgs []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, a ...any) pgx.Row {
+			if strings.HasPrefix(q, "UPDATE") {
+				updateArgs = a
+			}
+			c := firstParty
+			return &fakeRow{client: &c}
+		}}
+		// 未帶 first_party 時維持原設定
+		ctx, rec := newClientCtx(e, http.MethodPut, "cid", `{"grant_types":["password"]}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, true, updateArgs[20])
+
+		// 非管理員不可變更
+		updateArgs = nil
+		ctx, rec = newClientCtx(e, http.MethodPut, "cid", `{"grant_types":["password"],"first_party":false}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Nil(t, updateArgs)
+
+		ctx, rec = newClientCtx(e, http.MethodPut, "cid", `{"grant_types":["password"],"first_party":false}`)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1, IsAdmin: true})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, false, updateArgs[20])
+	})
+
+	// public client 改為 confidential 並以 secret 認證時，產生新 secret
+	public := sampleClient
+	public.ClientType = model.ClientTypePublic
+	public.ClientSecret = ""
+	public.TokenEndpointAuthMethod = model.ClientAuthMethodNone
+	toSecret := `{"client_type":"confidential","grant_types":["password"],"token_endpoint_auth_method":"client_secret_post"}`
+
+	t.Run("issues secret", func(t *testing.T) {
+		var secretArgs []any
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, a ...any) pgx.Row {
+			if strings.Contains(q, "SET client_secret") {
+				secretArgs = a
+			}
+			c := public
+			return &fakeRow{client: &c}
+		}}
+		ctx, rec := newClientCtx(e, http.MethodPut, "cid", toSecret)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.OAuthClientResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.ClientSecret, 43)
+		require.Equal(t, model.ClientAuthMethodSecretPost, resp.TokenEndpointAuthMethod)
+		require.NotEmpty(t, secretArgs[0])
+		require.NotEqual(t, resp.ClientSecret, secretArgs[0])
+	})
+
+	t.Run("issue secret error", func(t *testing.T) {
+		t.Cleanup(func() { rotateClientSecret = service.RotateClientSecret })
+		rotateClientSecret = func(*model.OAuthClient, time.Duration) (string, error) { return "", errors.New("rand") }
+		db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			c := public
+			return &fakeRow{client: &c}
+		}}
+		ctx, rec := newClientCtx(e, http.MethodPut, "cid", toSecret)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("store secret error", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, _ ...any) pgx.Row {
+			if strings.Contains(q, "SET client_secret") {
+				return &fakeRow{scanErr: errors.New("up")}
+			}
+			c := public
+			return &fakeRow{client: &c}
+		}}
+		ctx, rec := newClientCtx(e, http.MethodPut, "cid", toSecret)
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, UpdateMyOAuthClientHandler(db)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+}
+
+func TestRotateMyOAuthClientSecretHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	claims := &service.CustomClaims{UserID: 1}
+	newReq := func(body string) (echo.Context, *httptest.ResponseRecorder) {
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients/cid/secret", body)
+		ctx.SetPath("/users/me/oauth-clients/:client_id/secret")
+		ctx.SetParamNames("client_id")
+		ctx.SetParamValues("cid")
+		ctx.Set(middleware.ContextUserKey, claims)
+		return ctx, rec
+	}
+	// recordingDB 記錄 UPDATE 的參數，回傳 sampleClient 供查詢
+	recordingDB := func(args *[]any) *database.FakeDB {
+		return &database.FakeDB{QueryRowFn: func(_ context.Context, q string, a ...any) pgx.Row {
+			if strings.HasPrefix(q, "UPDATE") {
+				*args = a
+			}
+			c := sampleClient
+			return &fakeRow{client: &c}
+		}}
+	}
+
+	t.Run("no claims", func(t *testing.T) {
+		ctx, rec := newJSONCtx(e, http.MethodPost, "/users/me/oauth-clients/cid/secret", "")
+		require.NoError(t, RotateMyOAuthClientSecretHandler(nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("bind error", func(t *testing.T) {
+		ctx, rec := newReq(`{bad`)
+		require.NoError(t, RotateMyOAuthClientSecretHandler(nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("validate error", func(t *testing.T) {
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newReq(`{"grace_period":-1}`)
+		require.NoError(t, RotateMyOAuthClientSecretHandler(nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("get error", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeRow{scanErr: errors.New("fail")}
+		}}
+		ctx, rec := newReq("")
+		require.NoError(t, RotateMyOAuthClientSecretHandler(db)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("not owner", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			c := sampleClient
+			c.UserID = 2
+			return &fakeRow{client: &c}
+		}}
+		ctx, rec := newReq("")
+		require.NoError(t, RotateMyOAuthClientSecretHandler(db)(ctx))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("no client secret", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			c := sampleClient
+			c.TokenEndpointAuthMethod = model.ClientAuthMethodPrivateKey
+			return &fakeRow{client: &c}
+		}}
+		ctx, rec := newReq("")
+		require.NoError(t, RotateMyOAuthClientSecretHandler(db)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("generate secret error", func(t *testing.T) {
+		t.Cleanup(func() { rotateClientSecret = service.RotateClientSecret })
+		rotateClientSecret = func(*model.OAuthClient, time.Duration) (string, error) { return "", errors.New("rand") }
+		var args []any
+		ctx, rec := newReq("")
+		require.NoError(t, RotateMyOAuthClientSecretHandler(recordingDB(&args))(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+		require.Nil(t, args)
+	})
+
+	t.Run("update error", func(t *testing.T) {
+		db := &database.FakeDB{QueryRowFn: func(_ context.Context, q string, _ ...any) pgx.Row {
+			if strings.HasPrefix(q, "UPDATE") {
+				return &fakeRow{scanErr: errors.New("up")}
+			}
+			return &fakeRow{client: &sampleClient}
+		}}
+		ctx, rec := newReq("")
+		require.NoError(t, RotateMyOAuthClientSecretHandler(db)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("default grace", func(t *testing.T) {
+		var args []any
+		ctx, rec := newReq("")
+		require.NoError(t, RotateMyOAuthClientSecretHandler(recordingDB(&args))(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp api.OAuthClientResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.ClientSecret, 43)
+		require.Equal(t, "hash", args[1])
+		expiresAt := args[2].(*time.Time)
+		require.WithinDuration(t, time.Now().Add(24*time.Hour), *expiresAt, time.Minute)
+		require.True(t, expiresAt.Equal(*resp.PreviousClientSecretExpiresAt))
+	})
+
+	t.Run("no grace", func(t *testing.T) {
+		var args []any
+		ctx, rec := newReq(`{"grace_period":0}`)
+		require.NoError(t, RotateMyOAuthClientSecretHandler(recordingDB(&args))(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "", args[1])
+		require.Nil(t, args[2])
+		require.NotContains(t, rec.Body.String(), "previous_client_secret_expires_at")
+	})
+}
+
+func TestNewOAuthClientResponse(t *testing.T) {
+	c := sampleClient
+	expired := time.Now().Add(-time.Minute)
+	c.PreviousClientSecretExpiresAt = &expired
+	require.Nil(t, newOAuthClientResponse(c).PreviousClientSecretExpiresAt)
+
+	active := time.Now().Add(time.Hour)
+	c.PreviousClientSecretExpiresAt = &active
+	require.Equal(t, &active, newOAuthClientResponse(c).PreviousClientSecretExpiresAt)
+	require.Empty(t, newOAuthClientResponse(c).ClientSecret)
+
+	c.TokenEndpointAuthMethod = model.ClientAuthMethodPrivateKey
+	c.JWKS = json.RawMessage(`{"keys":[]}`)
+	resp := newOAuthClientResponse(c)
+	require.Equal(t, model.ClientAuthMethodPrivateKey, resp.TokenEndpointAuthMethod)
+	require.JSONEq(t, `{"keys":[]}`, string(resp.JWKS))
 }
 
 func TestDeleteMyOAuthClientHandler(t *testing.T) {
@@ -0,0 +1,87 @@
+package users
+
+import (
+	"net/http"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/labstack/echo/v4"
+)
+
+// @Summary     List OAuth consents for authenticated user
+// @Description 列出使用者同意過的 client 與授予的 scope，依同意時間由新到舊排序
+// @Tags        users
+// @Produce     json
+// @Success     200 {array} api.OAuthGrantResponse
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Application[users:read]
+// @Security    OAuth2Password[users:read]
+// @Router      /users/me/grants [get]
+func ListMyGrantsHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		grants, err := store.ListOAuthGrants(c.Request().Context(), db, claims.UserID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+
+		resp := make([]api.OAuthGrantResponse, len(grants))
+		for i, g := range grants {
+			resp[i] = api.OAuthGrantResponse{
+				ClientID:   g.ClientID,
+				ClientName: g.ClientName,
+				LogoURI:    g.LogoURI,
+				Scopes:     g.Scopes,
+				GrantedAt:  g.GrantedAt,
+			}
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// @Summary     Revoke OAuth consent for authenticated user
+// @Description 撤銷對 client 的同意；該 client 此前取得的 refresh token 一併失效，之後的授權須重新同意
+// @Tags        users
+// @Produce     json
+// @Param       client_id path string true "Client ID"
+// @Success     204
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     404 {object} api.ErrorResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
+// @Router      /users/me/grants/{client_id} [delete]
+func RevokeMyGrantHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		ctx := c.Request().Context()
+		clientID := c.Param("client_id")
+		deleted, err := store.DeleteOAuthGrant(ctx, db, claims.UserID, clientID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		if !deleted {
+			return c.JSON(http.StatusNotFound, api.ErrorResponse{Message: "grant not found"})
+		}
+		if err := service.RevokeGrantRefreshTokens(ctx, cache, claims.UserID, clientID); err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to revoke refresh tokens"})
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
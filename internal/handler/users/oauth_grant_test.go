@@ -0,0 +1,154 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGrantRows implements pgx.Rows for listing grants
+type fakeGrantRows struct {
+	fakeRows
+	grants []model.OAuthGrant
+}
+
+func (r *fakeGrantRows) Next() bool { return r.idx < len(r.grants) }
+func (r *fakeGrantRows) Scan(dest ...any) error {
+	g := r.grants[r.idx]
+	r.idx++
+	*dest[0].(*int) = g.UserID
+	*dest[1].(*string) = g.ClientID
+	*dest[2].(*[]string) = g.Scopes
+	*dest[3].(*time.Time) = g.GrantedAt
+	*dest[4].(*string) = g.ClientName
+	*dest[5].(*string) = g.LogoURI
+	return nil
+}
+
+func newGrantCtx(e *echo.Echo, method, clientID string) (echo.Context, *httptest.ResponseRecorder) {
+	c, rec := newJSONCtx(e, method, "/users/me/grants/"+clientID, "")
+	c.SetPath("/users/me/grants/:client_id")
+	c.SetParamNames("client_id")
+	c.SetParamValues(clientID)
+	return c, rec
+}
+
+func TestListMyGrantsHandler(t *testing.T) {
+	e := echo.New()
+	grantedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("no claims", func(t *testing.T) {
+		ctx, rec := newJSONCtx(e, http.MethodGet, "/users/me/grants", "")
+		require.NoError(t, ListMyGrantsHandler(nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		db := &database.FakeDB{QueryFn: func(context.Context, string, ...any) (pgx.Rows, error) {
+			return nil, errors.New("db")
+		}}
+		ctx, rec := newJSONCtx(e, http.MethodGet, "/users/me/grants", "")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, ListMyGrantsHandler(db)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		var gotArgs []any
+		rows := &fakeGrantRows{grants: []model.OAuthGrant{{UserID: 1, ClientID: "cid", Scopes: []string{"openid"}, GrantedAt: grantedAt, ClientName: "My App"}}}
+		db := &database.FakeDB{QueryFn: func(_ context.Context, _ string, args ...any) (pgx.Rows, error) {
+			gotArgs = args
+			return rows, nil
+		}}
+		ctx, rec := newJSONCtx(e, http.MethodGet, "/users/me/grants", "")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, ListMyGrantsHandler(db)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, []any{1}, gotArgs)
+
+		var resp []api.OAuthGrantResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, []api.OAuthGrantResponse{{ClientID: "cid", ClientName: "My App", Scopes: []string{"openid"}, GrantedAt: grantedAt}}, resp)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		db := &database.FakeDB{QueryFn: func(context.Context, string, ...any) (pgx.Rows, error) {
+			return &fakeGrantRows{}, nil
+		}}
+		ctx, rec := newJSONCtx(e, http.MethodGet, "/users/me/grants", "")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, ListMyGrantsHandler(db)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `[]`, rec.Body.String())
+	})
+}
+
+func TestRevokeMyGrantHandler(t *testing.T) {
+	e := echo.New()
+	deleteDB := func(tag string, err error, args *[]any) *database.FakeDB {
+		return &database.FakeDB{ExecFn: func(_ context.Context, _ string, a ...any) (pgconn.CommandTag, error) {
+			if args != nil {
+				*args = a
+			}
+			return pgconn.NewCommandTag(tag), err
+		}}
+	}
+	t.Run("no claims", func(t *testing.T) {
+		ctx, rec := newGrantCtx(e, http.MethodDelete, "cid")
+		require.NoError(t, RevokeMyGrantHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		ctx, rec := newGrantCtx(e, http.MethodDelete, "cid")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, RevokeMyGrantHandler(deleteDB("", errors.New("db"), nil), nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		c := cache.NewMemoryCache(nil)
+		ctx, rec := newGrantCtx(e, http.MethodDelete, "cid")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, RevokeMyGrantHandler(deleteDB("DELETE 0", nil, nil), c)(ctx))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+		require.Empty(t, c.Data)
+	})
+
+	t.Run("revoke tokens error", func(t *testing.T) {
+		c := cache.NewMemoryCache(nil)
+		c.FailOn["set"] = "revoked_grant:"
+		ctx, rec := newGrantCtx(e, http.MethodDelete, "cid")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, RevokeMyGrantHandler(deleteDB("DELETE 1", nil, nil), c)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		var args []any
+		c := cache.NewMemoryCache(nil)
+		ctx, rec := newGrantCtx(e, http.MethodDelete, "cid")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		require.NoError(t, RevokeMyGrantHandler(deleteDB("DELETE 1", nil, &args), c)(ctx))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, []any{1, "cid"}, args)
+		// 此前發行的 refresh token 一併失效
+		require.Contains(t, c.Data, "revoked_grant:1:cid")
+	})
+}
@@ -1,12 +1,18 @@
 package users
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/mail"
 	"strconv"
 	"strings"
+	"time"
 
 	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
 	"life-is-hard/internal/database"
 	"life-is-hard/internal/middleware"
 	"life-is-hard/internal/model"
@@ -24,10 +30,17 @@ var (
 	updateUser         = store.UpdateUser
 	updateUserPassword = store.UpdateUserPassword
 	deleteUser         = store.DeleteUser
+	listUsers          = store.ListUsers
+	sendVerification   = service.SendEmailVerification
 )
 
+// defaultUserPageSize 為未指定 limit 時每頁的使用者數
+const defaultUserPageSize = 20
+
+var errInvalidUserCursor = errors.New("invalid cursor")
+
 // @Summary     Create a new user
-// @Description 接收使用者表單資料並建立新帳號 (Email 會自動轉小寫)
+// @Description 接收使用者表單資料並建立新帳號 (Email 會自動轉小寫)，並寄出 Email 驗證信
 // @Tags        users
 // @Accept      application/x-www-form-urlencoded
 // @Produce     json
@@ -39,10 +52,10 @@ var (
 // @Failure     400      {object} api.ErrorResponse
 // @Failure     500      {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
 // @Router      /users [post]
-func CreateUserHandler(db database.DB) echo.HandlerFunc {
+func CreateUserHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var req api.CreateUserRequest
 		if err := c.Bind(&req); err != nil {
@@ -71,14 +84,90 @@ func CreateUserHandler(db database.DB) echo.HandlerFunc {
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
 		}
+		sendEmailVerification(c, cache, *user)
 
-		return c.JSON(http.StatusCreated, api.UserResponse{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt,
-			IsAdmin:   user.IsAdmin,
-		})
+		return c.JSON(http.StatusCreated, newUserResponse(*user))
+	}
+}
+
+// @Summary     List users
+// @Description 依條件列出使用者並以 cursor 分頁；total 為不計分頁的符合總數，Link header 提供 first 與 next 頁的網址。
+// @Description sort 可為 id、name、email、created_at，加上 - 前綴為遞減；換頁時須沿用相同的篩選與排序
+// @Tags        users
+// @Produce     json
+// @Param       is_admin       query    boolean false "是否為管理員"
+// @Param       email_domain   query    string  false "Email 網域"
+// @Param       name_prefix    query    string  false "姓名前綴"
+// @Param       created_after  query    string  false "建立時間下限 (RFC 3339，含)"
+// @Param       created_before query    string  false "建立時間上限 (RFC 3339，不含)"
+// @Param       sort           query    string  false "排序欄位，預設 id"
+// @Param       limit          query    int     false "每頁筆數 (1-100，預設 20)"
+// @Param       cursor         query    string  false "上一頁回傳的 next_cursor"
+// @Success     200 {object} api.UserListResponse
+// @Header      200 {string} Link "RFC 8288 分頁連結"
+// @Failure     400 {object} api.ErrorResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Application[users:read]
+// @Security    OAuth2Password[users:read]
+// @Router      /users [get]
+func ListUsersHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.ListUsersRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid query parameters"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		opts := store.UserListOptions{
+			EmailDomain:   req.EmailDomain,
+			NamePrefix:    req.NamePrefix,
+			CreatedAfter:  req.CreatedAfter,
+			CreatedBefore: req.CreatedBefore,
+			Sort:          strings.TrimPrefix(req.Sort, "-"),
+			Desc:          strings.HasPrefix(req.Sort, "-"),
+			Limit:         req.Limit,
+		}
+		if req.IsAdmin != "" {
+			isAdmin := req.IsAdmin == "true"
+			opts.IsAdmin = &isAdmin
+		}
+		if opts.Limit == 0 {
+			opts.Limit = defaultUserPageSize
+		}
+		if req.Cursor != "" {
+			after, err := decodeUserCursor(req.Cursor, req.Sort)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+			}
+			opts.After = after
+		}
+
+		// 多取一筆以判斷是否還有下一頁
+		limit := opts.Limit
+		opts.Limit++
+		list, total, err := listUsers(c.Request().Context(), db, opts)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+
+		resp := api.UserListResponse{Users: make([]api.UserResponse, 0, limit), Total: total}
+		if len(list) > limit {
+			list = list[:limit]
+			resp.NextCursor = encodeUserCursor(list[limit-1], req.Sort)
+		}
+		for _, u := range list {
+			resp.Users = append(resp.Users, newUserResponse(u))
+		}
+
+		links := []string{pageLink(c, "", "first")}
+		if resp.NextCursor != "" {
+			links = append(links, pageLink(c, resp.NextCursor, "next"))
+		}
+		c.Response().Header().Set("Link", strings.Join(links, ", "))
+		return c.JSON(http.StatusOK, resp)
 	}
 }
 
@@ -92,8 +181,8 @@ func CreateUserHandler(db database.DB) echo.HandlerFunc {
 // @Failure     404  {object}  api.ErrorResponse  "使用者不存在"
 // @Failure     500  {object}  api.ErrorResponse  "伺服器錯誤"
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[users:read]
+// @Security    OAuth2Password[users:read]
 // @Router      /users/{user_id} [get]
 func GetUserHandler(db database.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -105,18 +194,12 @@ func GetUserHandler(db database.DB) echo.HandlerFunc {
 		if err != nil {
 			return c.JSON(http.StatusNotFound, api.ErrorResponse{Message: "user not found"})
 		}
-		return c.JSON(http.StatusOK, api.UserResponse{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt,
-			IsAdmin:   user.IsAdmin,
-		})
+		return c.JSON(http.StatusOK, newUserResponse(*user))
 	}
 }
 
 // @Summary     Update a user by ID
-// @Description 根據使用者 ID 更新使用者姓名、Email 及管理員狀態
+// @Description 根據使用者 ID 更新使用者姓名、Email 及管理員狀態；Email 變更時重設為未驗證並寄出驗證信
 // @Tags        users
 // @Accept      application/x-www-form-urlencoded
 // @Produce     json
@@ -129,10 +212,10 @@ func GetUserHandler(db database.DB) echo.HandlerFunc {
 // @Failure     404      {object} api.ErrorResponse
 // @Failure     500      {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
 // @Router      /users/{user_id} [put]
-func UpdateUserHandler(db database.DB) echo.HandlerFunc {
+func UpdateUserHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		id, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
@@ -152,13 +235,21 @@ func UpdateUserHandler(db database.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid email format"})
 		}
 
-		if err := updateUser(c.Request().Context(), db, &model.User{
+		current, err := getUserByID(c.Request().Context(), db, id)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, api.ErrorResponse{Message: "user not found"})
+		}
+		user := &model.User{
 			ID:    id,
 			Name:  req.Name,
 			Email: req.Email,
-		}); err != nil {
+		}
+		if err := updateUser(c.Request().Context(), db, user); err != nil {
 			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
 		}
+		if user.Email != current.Email {
+			sendEmailVerification(c, cache, *user)
+		}
 
 		return c.NoContent(http.StatusNoContent)
 	}
@@ -172,8 +263,8 @@ func UpdateUserHandler(db database.DB) echo.HandlerFunc {
 // @Failure     400  {object}  api.ErrorResponse  "參數錯誤"
 // @Failure     500  {object}  api.ErrorResponse  "伺服器錯誤"
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
 // @Router      /users/{user_id} [delete]
 func DeleteUserHandler(db database.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -196,8 +287,8 @@ func DeleteUserHandler(db database.DB) echo.HandlerFunc {
 // @Failure     401 {object} api.ErrorResponse
 // @Failure     500 {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[users:read]
+// @Security    OAuth2Password[users:read]
 // @Router      /users/me [get]
 func GetMyUserHandler(db database.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -209,18 +300,12 @@ func GetMyUserHandler(db database.DB) echo.HandlerFunc {
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
 		}
-		return c.JSON(http.StatusOK, api.UserResponse{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt,
-			IsAdmin:   user.IsAdmin,
-		})
+		return c.JSON(http.StatusOK, newUserResponse(*user))
 	}
 }
 
 // @Summary     Update current user info
-// @Description 使用 JWT 更新當前使用者姓名和 Email
+// @Description 使用 JWT 更新當前使用者姓名和 Email；Email 變更時重設為未驗證並寄出驗證信
 // @Tags        users
 // @Accept      application/x-www-form-urlencoded
 // @Produce     json
@@ -231,10 +316,10 @@ func GetMyUserHandler(db database.DB) echo.HandlerFunc {
 // @Failure     401   {object} api.ErrorResponse
 // @Failure     500   {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
 // @Router      /users/me [put]
-func UpdateMyUserHandler(db database.DB) echo.HandlerFunc {
+func UpdateMyUserHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var req api.UpdateUserRequest
 		if err := c.Bind(&req); err != nil {
@@ -254,14 +339,21 @@ func UpdateMyUserHandler(db database.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid email format"})
 		}
 
-		err := updateUser(c.Request().Context(), db, &model.User{
+		current, err := getUserByID(c.Request().Context(), db, claims.UserID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		user := &model.User{
 			ID:    claims.UserID,
 			Name:  req.Name,
 			Email: req.Email,
-		})
-		if err != nil {
+		}
+		if err := updateUser(c.Request().Context(), db, user); err != nil {
 			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
 		}
+		if user.Email != current.Email {
+			sendEmailVerification(c, cache, *user)
+		}
 
 		return c.NoContent(http.StatusNoContent)
 	}
@@ -279,8 +371,8 @@ func UpdateMyUserHandler(db database.DB) echo.HandlerFunc {
 // @Failure     401      {object} api.ErrorResponse
 // @Failure     500      {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
 // @Router      /users/me/password [patch]
 func UpdateMyUserPasswordHandler(db database.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -327,8 +419,8 @@ func UpdateMyUserPasswordHandler(db database.DB) echo.HandlerFunc {
 // @Failure     401 {object} api.ErrorResponse
 // @Failure     500 {object} api.ErrorResponse
 // @Security    ApiKeyAuth
-// @Security    OAuth2Application
-// @Security    OAuth2Password
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
 // @Router      /users/me [delete]
 func DeleteMyUserHandler(db database.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -342,3 +434,78 @@ func DeleteMyUserHandler(db database.DB) echo.HandlerFunc {
 		return c.NoContent(http.StatusNoContent)
 	}
 }
+
+func newUserResponse(u model.User) api.UserResponse {
+	return api.UserResponse{
+		ID:            u.ID,
+		Name:          u.Name,
+		Email:         u.Email,
+		CreatedAt:     u.CreatedAt,
+		IsAdmin:       u.IsAdmin,
+		EmailVerified: u.EmailVerified,
+	}
+}
+
+// sendEmailVerification 寄出驗證信；寄送失敗僅記錄 log，使用者可再要求重寄
+func sendEmailVerification(c echo.Context, cache cache.Cache, user model.User) {
+	if err := sendVerification(c.Request().Context(), cache, user); err != nil {
+		c.Logger().Errorf("failed to send email verification to user %d: %v", user.ID, err)
+	}
+}
+
+// userCursor 為 ListUsersHandler 的分頁游標，記錄上一頁最後一筆的排序值與 id；
+// 綁定產生時的排序方式，避免換了排序後沿用而跳過資料
+type userCursor struct {
+	Sort  string `json:"sort"`
+	ID    int    `json:"id"`
+	Value string `json:"v,omitempty"`
+}
+
+func encodeUserCursor(u model.User, sort string) string {
+	cur := userCursor{Sort: sort, ID: u.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case "name":
+		cur.Value = u.Name
+	case "email":
+		cur.Value = u.Email
+	case "created_at":
+		cur.Value = u.CreatedAt.Format(time.RFC3339Nano)
+	}
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeUserCursor(s, sort string) (*model.User, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidUserCursor
+	}
+	var cur userCursor
+	if err := json.Unmarshal(b, &cur); err != nil || cur.Sort != sort {
+		return nil, errInvalidUserCursor
+	}
+	u := &model.User{ID: cur.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case "name":
+		u.Name = cur.Value
+	case "email":
+		u.Email = cur.Value
+	case "created_at":
+		if u.CreatedAt, err = time.Parse(time.RFC3339Nano, cur.Value); err != nil {
+			return nil, errInvalidUserCursor
+		}
+	}
+	return u, nil
+}
+
+// pageLink 以目前請求的查詢參數產生 RFC 8288 的分頁連結，cursor 為空時指向第一頁
+func pageLink(c echo.Context, cursor, rel string) string {
+	u := *c.Request().URL
+	q := u.Query()
+	q.Del("cursor")
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	u.RawQuery = q.Encode()
+	return fmt.Sprintf("<%s>; rel=%q", u.RequestURI(), rel)
+}
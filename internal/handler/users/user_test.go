@@ -2,6 +2,8 @@ package users
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -9,6 +11,8 @@ import (
 	"testing"
 	"time"
 
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
 	"life-is-hard/internal/database"
 	"life-is-hard/internal/middleware"
 	"life-is-hard/internal/model"
@@ -68,6 +72,18 @@ func restore() {
 	updateUser = store.UpdateUser
 	updateUserPassword = store.UpdateUserPassword
 	deleteUser = store.DeleteUser
+	listUsers = store.ListUsers
+	sendVerification = service.SendEmailVerification
+	beginTOTPEnrollment = service.BeginTOTPEnrollment
+	confirmTOTPEnrollment = service.ConfirmTOTPEnrollment
+	regenerateRecoveryCodes = service.RegenerateRecoveryCodes
+	disableMFA = service.DisableMFA
+	deleteUserMFA = store.DeleteUserMFA
+	beginWebAuthnRegistration = service.BeginWebAuthnRegistration
+	finishWebAuthnRegistration = service.FinishWebAuthnRegistration
+	listWebAuthnCredentials = store.ListWebAuthnCredentials
+	renameWebAuthnCredential = store.RenameWebAuthnCredential
+	deleteWebAuthnCredential = store.DeleteWebAuthnCredential
 }
 
 func TestCreateUserHandler(t *testing.T) {
@@ -77,7 +93,7 @@ func TestCreateUserHandler(t *testing.T) {
 		t.Cleanup(restore)
 		e.Validator = &stubValidator{}
 		ctx, rec := newFormCtx(e, "%")
-		err := CreateUserHandler(nil)(ctx)
+		err := CreateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 		require.Contains(t, rec.Body.String(), "invalid form data")
@@ -87,7 +103,7 @@ func TestCreateUserHandler(t *testing.T) {
 		t.Cleanup(restore)
 		e.Validator = &stubValidator{err: errors.New("v")}
 		ctx, rec := newFormCtx(e, "name=a&email=a@b.com&password=p&is_admin=true")
-		err := CreateUserHandler(nil)(ctx)
+		err := CreateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 		require.Contains(t, rec.Body.String(), "v")
@@ -98,7 +114,7 @@ func TestCreateUserHandler(t *testing.T) {
 		e.Validator = &stubValidator{}
 		hashPassword = func(string) (string, error) { return "", errors.New("hash") }
 		ctx, rec := newFormCtx(e, "name=a&email=a@b.com&password=p&is_admin=true")
-		err := CreateUserHandler(nil)(ctx)
+		err := CreateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 		require.Contains(t, rec.Body.String(), "failed to hash password")
@@ -109,7 +125,7 @@ func TestCreateUserHandler(t *testing.T) {
 		e.Validator = &stubValidator{}
 		hashPassword = func(string) (string, error) { return "h", nil }
 		ctx, rec := newFormCtx(e, "name=a&email=bad&password=p&is_admin=true")
-		err := CreateUserHandler(nil)(ctx)
+		err := CreateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 		require.Contains(t, rec.Body.String(), "invalid email format")
@@ -123,7 +139,7 @@ func TestCreateUserHandler(t *testing.T) {
 			return nil, errors.New("c")
 		}
 		ctx, rec := newFormCtx(e, "name=a&email=a@b.com&password=p&is_admin=true")
-		err := CreateUserHandler(nil)(ctx)
+		err := CreateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
@@ -140,15 +156,166 @@ func TestCreateUserHandler(t *testing.T) {
 			u.CreatedAt = now
 			return u, nil
 		}
+		var sent []model.User
+		sendVerification = func(_ context.Context, _ cache.Cache, u model.User) error {
+			sent = append(sent, u)
+			return nil
+		}
 		ctx, rec := newFormCtx(e, "name=A&email=Alice@EXAMPLE.com&password=p&is_admin=true")
-		err := CreateUserHandler(nil)(ctx)
+		err := CreateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusCreated, rec.Code)
 		require.Equal(t, "alice@example.com", gotEmail)
 		require.Contains(t, rec.Body.String(), "\"id\":1")
+		require.Contains(t, rec.Body.String(), "\"email_verified\":false")
+		require.Len(t, sent, 1)
+		require.Equal(t, "alice@example.com", sent[0].Email)
+
+		// 驗證信寄送失敗不影響建立帳號
+		sendVerification = func(context.Context, cache.Cache, model.User) error { return errors.New("smtp") }
+		ctx, rec = newFormCtx(e, "name=A&email=Alice@EXAMPLE.com&password=p&is_admin=true")
+		require.NoError(t, CreateUserHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code)
 	})
 }
 
+func newListCtx(e *echo.Echo, query string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodGet, "/api/users?"+query, nil)
+	rec := httptest.NewRecorder()
+	return e.NewContext(req, rec), rec
+}
+
+func TestListUsersHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	all := []model.User{
+		{ID: 1, Name: "alice", Email: "alice@example.com", CreatedAt: now, IsAdmin: true},
+		{ID: 2, Name: "bob", Email: "bob@example.com", CreatedAt: now.Add(time.Hour)},
+		{ID: 3, Name: "carol", Email: "carol@example.com", CreatedAt: now.Add(2 * time.Hour)},
+	}
+
+	t.Run("invalid query", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newListCtx(e, "created_after=yesterday")
+		require.NoError(t, ListUsersHandler(nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+
+		e := echo.New()
+		e.Validator = &stubValidator{err: errors.New("bad sort")}
+		ctx, rec = newListCtx(e, "sort=password")
+		require.NoError(t, ListUsersHandler(nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), "bad sort")
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		t.Cleanup(restore)
+		for _, q := range []string{
+			"cursor=!",
+			"cursor=" + base64.RawURLEncoding.EncodeToString([]byte("{")),
+			// 游標須沿用產生時的排序
+			"sort=name&cursor=" + encodeUserCursor(all[0], "-name"),
+			"sort=created_at&cursor=" + base64.RawURLEncoding.EncodeToString([]byte(`{"sort":"created_at","id":1,"v":"x"}`)),
+		} {
+			ctx, rec := newListCtx(e, q)
+			require.NoError(t, ListUsersHandler(nil)(ctx))
+			require.Equal(t, http.StatusBadRequest, rec.Code, q)
+			require.Contains(t, rec.Body.String(), "invalid cursor")
+		}
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		t.Cleanup(restore)
+		listUsers = func(context.Context, database.DB, store.UserListOptions) ([]model.User, int, error) {
+			return nil, 0, errors.New("db")
+		}
+		ctx, rec := newListCtx(e, "")
+		require.NoError(t, ListUsersHandler(nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		t.Cleanup(restore)
+		var got store.UserListOptions
+		listUsers = func(_ context.Context, _ database.DB, opts store.UserListOptions) ([]model.User, int, error) {
+			got = opts
+			// 模擬 -created_at 排序下自游標之後的資料
+			var page []model.User
+			for i := len(all) - 1; i >= 0; i-- {
+				if opts.After == nil || all[i].CreatedAt.Before(opts.After.CreatedAt) {
+					page = append(page, all[i])
+				}
+			}
+			if len(page) > opts.Limit {
+				page = page[:opts.Limit]
+			}
+			return page, len(all), nil
+		}
+
+		ctx, rec := newListCtx(e, "is_admin=false&email_domain=example.com&name_prefix=b&sort=-created_at&limit=2")
+		require.NoError(t, ListUsersHandler(nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.False(t, *got.IsAdmin)
+		require.Equal(t, "example.com", got.EmailDomain)
+		require.Equal(t, "b", got.NamePrefix)
+		require.Equal(t, "created_at", got.Sort)
+		require.True(t, got.Desc)
+		require.Equal(t, 3, got.Limit)
+		require.Nil(t, got.After)
+
+		var resp api.UserListResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, 3, resp.Total)
+		require.Len(t, resp.Users, 2)
+		require.Equal(t, 3, resp.Users[0].ID)
+		require.NotEmpty(t, resp.NextCursor)
+		link := rec.Header().Get("Link")
+		require.Contains(t, link, `</api/users?email_domain=example.com&is_admin=false&limit=2&name_prefix=b&sort=-created_at>; rel="first"`)
+		require.Contains(t, link, "cursor="+resp.NextCursor+`&email_domain=example.com&is_admin=false&limit=2&name_prefix=b&sort=-created_at>; rel="next"`)
+
+		ctx, rec = newListCtx(e, "sort=-created_at&limit=2&cursor="+resp.NextCursor)
+		require.NoError(t, ListUsersHandler(nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, &model.User{ID: 2, CreatedAt: all[1].CreatedAt}, got.After)
+		resp = api.UserListResponse{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.Users, 1)
+		require.Equal(t, 1, resp.Users[0].ID)
+		require.Empty(t, resp.NextCursor)
+		require.NotContains(t, rec.Header().Get("Link"), `rel="next"`)
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Cleanup(restore)
+		var got store.UserListOptions
+		listUsers = func(_ context.Context, _ database.DB, opts store.UserListOptions) ([]model.User, int, error) {
+			got = opts
+			return nil, 0, nil
+		}
+		ctx, rec := newListCtx(e, "")
+		require.NoError(t, ListUsersHandler(nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `{"users":[],"total":0}`, rec.Body.String())
+		require.Equal(t, store.UserListOptions{Limit: defaultUserPageSize + 1}, got)
+	})
+}
+
+func TestUserCursor(t *testing.T) {
+	u := model.User{ID: 7, Name: "alice", Email: "alice@example.com", CreatedAt: time.Date(2025, 5, 1, 12, 0, 0, 5, time.UTC)}
+	for sort, want := range map[string]*model.User{
+		"":           {ID: 7},
+		"-id":        {ID: 7},
+		"name":       {ID: 7, Name: "alice"},
+		"-email":     {ID: 7, Email: "alice@example.com"},
+		"created_at": {ID: 7, CreatedAt: u.CreatedAt},
+	} {
+		got, err := decodeUserCursor(encodeUserCursor(u, sort), sort)
+		require.NoError(t, err, sort)
+		require.Equal(t, want, got, sort)
+	}
+}
+
 func TestGetUserHandler(t *testing.T) {
 	e := echo.New()
 	t.Run("bad id", func(t *testing.T) {
@@ -189,7 +356,7 @@ func TestUpdateUserHandler(t *testing.T) {
 	t.Run("bad id", func(t *testing.T) {
 		t.Cleanup(restore)
 		ctx, rec := newUpdateCtx(e, "x", "")
-		err := UpdateUserHandler(nil)(ctx)
+		err := UpdateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 	})
@@ -197,7 +364,7 @@ func TestUpdateUserHandler(t *testing.T) {
 	t.Run("bind error", func(t *testing.T) {
 		t.Cleanup(restore)
 		ctx, rec := newUpdateCtx(e, "1", "%")
-		err := UpdateUserHandler(nil)(ctx)
+		err := UpdateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 	})
@@ -206,7 +373,7 @@ func TestUpdateUserHandler(t *testing.T) {
 		t.Cleanup(restore)
 		e.Validator = &stubValidator{err: errors.New("v")}
 		ctx, rec := newUpdateCtx(e, "1", "name=a&email=a@b.com")
-		err := UpdateUserHandler(nil)(ctx)
+		err := UpdateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 		require.Contains(t, rec.Body.String(), "v")
@@ -216,17 +383,28 @@ func TestUpdateUserHandler(t *testing.T) {
 		t.Cleanup(restore)
 		e.Validator = &stubValidator{}
 		ctx, rec := newUpdateCtx(e, "1", "name=a&email=bad")
-		err := UpdateUserHandler(nil)(ctx)
+		err := UpdateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 	})
 
+	t.Run("not found", func(t *testing.T) {
+		t.Cleanup(restore)
+		e.Validator = &stubValidator{}
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return nil, errors.New("no") }
+		ctx, rec := newUpdateCtx(e, "1", "name=a&email=a@b.com")
+		err := UpdateUserHandler(nil, nil)(ctx)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
 	t.Run("update error", func(t *testing.T) {
 		t.Cleanup(restore)
 		e.Validator = &stubValidator{}
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return &model.User{ID: 1}, nil }
 		updateUser = func(context.Context, database.DB, *model.User) error { return errors.New("u") }
 		ctx, rec := newUpdateCtx(e, "1", "name=a&email=a@b.com")
-		err := UpdateUserHandler(nil)(ctx)
+		err := UpdateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
@@ -234,17 +412,32 @@ func TestUpdateUserHandler(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		t.Cleanup(restore)
 		e.Validator = &stubValidator{}
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) {
+			return &model.User{ID: 2, Email: "b@ex.com", EmailVerified: true}, nil
+		}
 		var got model.User
 		updateUser = func(_ context.Context, _ database.DB, u *model.User) error {
 			got = *u
 			return nil
 		}
+		var sent []string
+		sendVerification = func(_ context.Context, _ cache.Cache, u model.User) error {
+			sent = append(sent, u.Email)
+			return nil
+		}
 		ctx, rec := newUpdateCtx(e, "2", "name=A&email=B@EX.com")
-		err := UpdateUserHandler(nil)(ctx)
+		err := UpdateUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusNoContent, rec.Code)
 		require.Equal(t, "b@ex.com", got.Email)
 		require.Equal(t, 2, got.ID)
+		require.Empty(t, sent)
+
+		// Email 變更時寄出驗證信
+		ctx, rec = newUpdateCtx(e, "2", "name=A&email=new@ex.com")
+		require.NoError(t, UpdateUserHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, []string{"new@ex.com"}, sent)
 	})
 }
 
@@ -319,7 +512,7 @@ func TestUpdateMyUserHandler(t *testing.T) {
 	t.Run("bind error", func(t *testing.T) {
 		t.Cleanup(restore)
 		ctx, rec := newMeCtx(e, http.MethodPut, "%")
-		err := UpdateMyUserHandler(nil)(ctx)
+		err := UpdateMyUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 	})
@@ -328,7 +521,7 @@ func TestUpdateMyUserHandler(t *testing.T) {
 		t.Cleanup(restore)
 		e.Validator = &stubValidator{err: errors.New("v")}
 		ctx, rec := newMeCtx(e, http.MethodPut, "name=a&email=a@b.com")
-		err := UpdateMyUserHandler(nil)(ctx)
+		err := UpdateMyUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 		require.Contains(t, rec.Body.String(), "v")
@@ -338,7 +531,7 @@ func TestUpdateMyUserHandler(t *testing.T) {
 		t.Cleanup(restore)
 		e.Validator = &stubValidator{}
 		ctx, rec := newMeCtx(e, http.MethodPut, "name=a&email=a@b.com")
-		err := UpdateMyUserHandler(nil)(ctx)
+		err := UpdateMyUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusUnauthorized, rec.Code)
 	})
@@ -347,35 +540,55 @@ func TestUpdateMyUserHandler(t *testing.T) {
 		t.Cleanup(restore)
 		ctx, rec := newMeCtx(e, http.MethodPut, "name=a&email=bad")
 		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
-		err := UpdateMyUserHandler(nil)(ctx)
+		err := UpdateMyUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, rec.Code)
 	})
 
+	t.Run("get error", func(t *testing.T) {
+		t.Cleanup(restore)
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return nil, errors.New("g") }
+		ctx, rec := newMeCtx(e, http.MethodPut, "name=a&email=a@b.com")
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
+		err := UpdateMyUserHandler(nil, nil)(ctx)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
 	t.Run("update error", func(t *testing.T) {
 		t.Cleanup(restore)
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return &model.User{ID: 1}, nil }
 		updateUser = func(context.Context, database.DB, *model.User) error { return errors.New("u") }
 		ctx, rec := newMeCtx(e, http.MethodPut, "name=a&email=a@b.com")
 		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 1})
-		err := UpdateMyUserHandler(nil)(ctx)
+		err := UpdateMyUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 
 	t.Run("success", func(t *testing.T) {
 		t.Cleanup(restore)
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) {
+			return &model.User{ID: 5, Email: "old@ex.com", EmailVerified: true}, nil
+		}
 		var got model.User
 		updateUser = func(_ context.Context, _ database.DB, u *model.User) error {
 			got = *u
 			return nil
 		}
+		var sent []string
+		sendVerification = func(_ context.Context, _ cache.Cache, u model.User) error {
+			sent = append(sent, u.Email)
+			return errors.New("smtp")
+		}
 		ctx, rec := newMeCtx(e, http.MethodPut, "name=A&email=B@Ex.com")
 		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: 5})
-		err := UpdateMyUserHandler(nil)(ctx)
+		err := UpdateMyUserHandler(nil, nil)(ctx)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusNoContent, rec.Code)
 		require.Equal(t, 5, got.ID)
 		require.Equal(t, "b@ex.com", got.Email)
+		require.Equal(t, []string{"b@ex.com"}, sent)
 	})
 }
 
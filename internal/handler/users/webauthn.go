@@ -0,0 +1,237 @@
+package users
+
+import (
+	"errors"
+	"net/http"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+	"life-is-hard/internal/store"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	beginWebAuthnRegistration  = service.BeginWebAuthnRegistration
+	finishWebAuthnRegistration = service.FinishWebAuthnRegistration
+	listWebAuthnCredentials    = store.ListWebAuthnCredentials
+	renameWebAuthnCredential   = store.RenameWebAuthnCredential
+	deleteWebAuthnCredential   = store.DeleteWebAuthnCredential
+)
+
+// @Summary     Begin passkey registration
+// @Description 產生 WebAuthn 註冊 ceremony 的參數，交給 navigator.credentials.create()；要求可發現的 credential 與使用者驗證，僅接受 none attestation。challenge 只能由同一使用者使用一次；須以最近登入取得的第一方 token 呼叫，否則回傳 401 要求重新登入
+// @Tags        users
+// @Produce     json
+// @Success     200 {object} api.WebAuthnRegistrationOptionsResponse
+// @Failure     401 {object} api.ErrorResponse "未登入或須重新登入"
+// @Failure     403 {object} api.ErrorResponse "僅接受使用者直接登入的 token"
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Router      /users/me/webauthn/registration/options [post]
+func BeginMyWebAuthnRegistrationHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		ctx := c.Request().Context()
+		user, err := getUserByID(ctx, db, claims.UserID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		reg, err := beginWebAuthnRegistration(ctx, db, cache, user.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to begin passkey registration"})
+		}
+
+		rp := service.WebAuthnRP()
+		params := make([]api.WebAuthnCredentialParameter, 0, len(service.WebAuthnAlgorithms()))
+		for _, alg := range service.WebAuthnAlgorithms() {
+			params = append(params, api.WebAuthnCredentialParameter{Type: "public-key", Alg: alg})
+		}
+		exclude := make([]api.WebAuthnCredentialDescriptor, len(reg.Exclude))
+		for i, cred := range reg.Exclude {
+			exclude[i] = api.WebAuthnCredentialDescriptor{Type: "public-key", ID: cred.ID, Transports: cred.Transports}
+		}
+		return c.JSON(http.StatusOK, api.WebAuthnRegistrationOptionsResponse{
+			Challenge:          reg.Challenge,
+			RP:                 api.WebAuthnRelyingParty{ID: rp.ID, Name: rp.Name},
+			User:               api.WebAuthnUser{ID: reg.UserHandle, Name: user.Name, DisplayName: user.Name},
+			PubKeyCredParams:   params,
+			Timeout:            int(service.WebAuthnChallengeTTL.Milliseconds()),
+			ExcludeCredentials: exclude,
+			AuthenticatorSelection: api.WebAuthnAuthenticatorSelection{
+				ResidentKey:        "required",
+				RequireResidentKey: true,
+				UserVerification:   "required",
+			},
+			Attestation: "none",
+		})
+	}
+}
+
+// @Summary     Finish passkey registration
+// @Description 驗證 navigator.credentials.create() 回傳的 attestation 並保存 passkey，之後可以 /auth/webauthn/login 免密碼登入
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Param       request body     api.WebAuthnRegistrationRequest true "PublicKeyCredential"
+// @Success     201     {object} api.WebAuthnCredentialResponse
+// @Failure     400     {object} api.ErrorResponse
+// @Failure     401     {object} api.ErrorResponse "未登入或須重新登入"
+// @Failure     403     {object} api.ErrorResponse "僅接受使用者直接登入的 token"
+// @Failure     409     {object} api.ErrorResponse "credential 已註冊"
+// @Failure     500     {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Router      /users/me/webauthn/credentials [post]
+func FinishMyWebAuthnRegistrationHandler(db database.DB, cache cache.Cache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.WebAuthnRegistrationRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid request payload"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		cred, err := finishWebAuthnRegistration(c.Request().Context(), db, cache, claims.UserID, req.Name, service.WebAuthnAttestationResponse{
+			ID:                req.ID,
+			ClientDataJSON:    req.Response.ClientDataJSON,
+			AttestationObject: req.Response.AttestationObject,
+			Transports:        req.Response.Transports,
+		})
+		switch {
+		case errors.Is(err, service.ErrInvalidWebAuthnChallenge), errors.Is(err, service.ErrInvalidWebAuthnResponse):
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		case errors.Is(err, service.ErrWebAuthnCredentialExists):
+			return c.JSON(http.StatusConflict, api.ErrorResponse{Message: err.Error()})
+		case err != nil:
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: "failed to register passkey"})
+		}
+		return c.JSON(http.StatusCreated, newWebAuthnCredentialResponse(*cred))
+	}
+}
+
+// @Summary     List passkeys
+// @Description 列出使用者註冊的 passkey，依註冊時間排序
+// @Tags        users
+// @Produce     json
+// @Success     200 {array}  api.WebAuthnCredentialResponse
+// @Failure     401 {object} api.ErrorResponse
+// @Failure     500 {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Application[users:read]
+// @Security    OAuth2Password[users:read]
+// @Router      /users/me/webauthn/credentials [get]
+func ListMyWebAuthnCredentialsHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		creds, err := listWebAuthnCredentials(c.Request().Context(), db, claims.UserID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		resp := make([]api.WebAuthnCredentialResponse, len(creds))
+		for i, cred := range creds {
+			resp[i] = newWebAuthnCredentialResponse(cred)
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// @Summary     Rename a passkey
+// @Description 變更 passkey 的顯示名稱
+// @Tags        users
+// @Accept      application/x-www-form-urlencoded
+// @Produce     json
+// @Param       credential_id path     string true "Credential ID"
+// @Param       name          formData string true "顯示名稱"
+// @Success     204           "No Content"
+// @Failure     400           {object} api.ErrorResponse
+// @Failure     401           {object} api.ErrorResponse
+// @Failure     404           {object} api.ErrorResponse
+// @Failure     500           {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
+// @Router      /users/me/webauthn/credentials/{credential_id} [patch]
+func RenameMyWebAuthnCredentialHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req api.RenameWebAuthnCredentialRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: "invalid form data"})
+		}
+		if err := c.Validate(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, api.ErrorResponse{Message: err.Error()})
+		}
+
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		renamed, err := renameWebAuthnCredential(c.Request().Context(), db, claims.UserID, c.Param("credential_id"), req.Name)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		if !renamed {
+			return c.JSON(http.StatusNotFound, api.ErrorResponse{Message: "passkey not found"})
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// @Summary     Delete a passkey
+// @Description 刪除 passkey，之後無法再以其登入
+// @Tags        users
+// @Produce     json
+// @Param       credential_id path string true "Credential ID"
+// @Success     204           "No Content"
+// @Failure     401           {object} api.ErrorResponse
+// @Failure     404           {object} api.ErrorResponse
+// @Failure     500           {object} api.ErrorResponse
+// @Security    ApiKeyAuth
+// @Security    OAuth2Application[users:write]
+// @Security    OAuth2Password[users:write]
+// @Router      /users/me/webauthn/credentials/{credential_id} [delete]
+func DeleteMyWebAuthnCredentialHandler(db database.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, ok := c.Get(middleware.ContextUserKey).(*service.CustomClaims)
+		if !ok || claims.UserID == 0 {
+			return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Message: "invalid or missing token"})
+		}
+
+		deleted, err := deleteWebAuthnCredential(c.Request().Context(), db, claims.UserID, c.Param("credential_id"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, api.ErrorResponse{Message: err.Error()})
+		}
+		if !deleted {
+			return c.JSON(http.StatusNotFound, api.ErrorResponse{Message: "passkey not found"})
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func newWebAuthnCredentialResponse(cred model.WebAuthnCredential) api.WebAuthnCredentialResponse {
+	return api.WebAuthnCredentialResponse{
+		ID:         cred.ID,
+		Name:       cred.Name,
+		Transports: cred.Transports,
+		CreatedAt:  cred.CreatedAt,
+		LastUsedAt: cred.LastUsedAt,
+	}
+}
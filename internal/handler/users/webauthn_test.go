@@ -0,0 +1,309 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/api"
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// newCredentialCtx 建立 /users/me/webauthn/credentials 的請求；id 不為空時設定 credential_id 路徑參數
+func newCredentialCtx(e *echo.Echo, method, id, contentType, body string, userID int) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, "/users/me/webauthn/credentials", strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(req, rec)
+	if id != "" {
+		ctx.SetParamNames("credential_id")
+		ctx.SetParamValues(id)
+	}
+	if userID != 0 {
+		ctx.Set(middleware.ContextUserKey, &service.CustomClaims{UserID: userID})
+	}
+	return ctx, rec
+}
+
+func TestBeginMyWebAuthnRegistrationHandler(t *testing.T) {
+	e := echo.New()
+
+	t.Run("no claims", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newCredentialCtx(e, http.MethodPost, "", "", "", 0)
+		require.NoError(t, BeginMyWebAuthnRegistrationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("get error", func(t *testing.T) {
+		t.Cleanup(restore)
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return nil, errors.New("g") }
+		ctx, rec := newCredentialCtx(e, http.MethodPost, "", "", "", 1)
+		require.NoError(t, BeginMyWebAuthnRegistrationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("begin error", func(t *testing.T) {
+		t.Cleanup(restore)
+		getUserByID = func(context.Context, database.DB, int) (*model.User, error) { return &model.User{ID: 1}, nil }
+		beginWebAuthnRegistration = func(context.Context, database.DB, cache.Cache, int) (*service.WebAuthnRegistration, error) {
+			return nil, errors.New("c")
+		}
+		ctx, rec := newCredentialCtx(e, http.MethodPost, "", "", "", 1)
+		require.NoError(t, BeginMyWebAuthnRegistrationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		t.Cleanup(restore)
+		getUserByID = func(_ context.Context, _ database.DB, id int) (*model.User, error) {
+			return &model.User{ID: id, Name: "alice"}, nil
+		}
+		beginWebAuthnRegistration = func(_ context.Context, _ database.DB, _ cache.Cache, id int) (*service.WebAuthnRegistration, error) {
+			return &service.WebAuthnRegistration{
+				Challenge:  "challenge",
+				UserHandle: service.WebAuthnUserHandle(id),
+				Exclude:    []model.WebAuthnCredential{{ID: "Y3JlZA", Transports: []string{"usb"}}},
+			}, nil
+		}
+		ctx, rec := newCredentialCtx(e, http.MethodPost, "", "", "", 2)
+		require.NoError(t, BeginMyWebAuthnRegistrationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.WebAuthnRegistrationOptionsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, api.WebAuthnRegistrationOptionsResponse{
+			Challenge: "challenge",
+			RP:        api.WebAuthnRelyingParty{ID: "localhost", Name: "life-is-hard"},
+			User:      api.WebAuthnUser{ID: "Mg", Name: "alice", DisplayName: "alice"},
+			PubKeyCredParams: []api.WebAuthnCredentialParameter{
+				{Type: "public-key", Alg: -7}, {Type: "public-key", Alg: -8}, {Type: "public-key", Alg: -257},
+			},
+			Timeout:            300000,
+			ExcludeCredentials: []api.WebAuthnCredentialDescriptor{{Type: "public-key", ID: "Y3JlZA", Transports: []string{"usb"}}},
+			AuthenticatorSelection: api.WebAuthnAuthenticatorSelection{
+				ResidentKey: "required", RequireResidentKey: true, UserVerification: "required",
+			},
+			Attestation: "none",
+		}, resp)
+	})
+}
+
+func TestFinishMyWebAuthnRegistrationHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+	body := `{"id":"Y3JlZA","rawId":"Y3JlZA","type":"public-key","name":"Laptop","response":{"clientDataJSON":"cd","attestationObject":"ao","transports":["internal"]}}`
+
+	t.Run("bind error", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newCredentialCtx(e, http.MethodPost, "", echo.MIMEApplicationJSON, "{bad json", 1)
+		require.NoError(t, FinishMyWebAuthnRegistrationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("validate error", func(t *testing.T) {
+		t.Cleanup(restore)
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newCredentialCtx(e, http.MethodPost, "", echo.MIMEApplicationJSON, body, 1)
+		require.NoError(t, FinishMyWebAuthnRegistrationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("no claims", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newCredentialCtx(e, http.MethodPost, "", echo.MIMEApplicationJSON, body, 0)
+		require.NoError(t, FinishMyWebAuthnRegistrationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"invalid challenge", service.ErrInvalidWebAuthnChallenge, http.StatusBadRequest},
+		{"invalid response", service.ErrInvalidWebAuthnResponse, http.StatusBadRequest},
+		{"already registered", service.ErrWebAuthnCredentialExists, http.StatusConflict},
+		{"finish error", errors.New("db"), http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(restore)
+			finishWebAuthnRegistration = func(context.Context, database.DB, cache.Cache, int, string, service.WebAuthnAttestationResponse) (*model.WebAuthnCredential, error) {
+				return nil, tc.err
+			}
+			ctx, rec := newCredentialCtx(e, http.MethodPost, "", echo.MIMEApplicationJSON, body, 1)
+			require.NoError(t, FinishMyWebAuthnRegistrationHandler(nil, nil)(ctx))
+			require.Equal(t, tc.code, rec.Code)
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		t.Cleanup(restore)
+		now := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+		var gotID int
+		var gotName string
+		var got service.WebAuthnAttestationResponse
+		finishWebAuthnRegistration = func(_ context.Context, _ database.DB, _ cache.Cache, id int, name string, resp service.WebAuthnAttestationResponse) (*model.WebAuthnCredential, error) {
+			gotID, gotName, got = id, name, resp
+			return &model.WebAuthnCredential{ID: resp.ID, UserID: id, Name: name, PublicKey: []byte{1}, Transports: resp.Transports, CreatedAt: now}, nil
+		}
+		ctx, rec := newCredentialCtx(e, http.MethodPost, "", echo.MIMEApplicationJSON, body, 3)
+		require.NoError(t, FinishMyWebAuthnRegistrationHandler(nil, nil)(ctx))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		require.Equal(t, 3, gotID)
+		require.Equal(t, "Laptop", gotName)
+		require.Equal(t, service.WebAuthnAttestationResponse{
+			ID: "Y3JlZA", ClientDataJSON: "cd", AttestationObject: "ao", Transports: []string{"internal"},
+		}, got)
+		require.NotContains(t, rec.Body.String(), "public_key")
+		var resp api.WebAuthnCredentialResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, api.WebAuthnCredentialResponse{ID: "Y3JlZA", Name: "Laptop", Transports: []string{"internal"}, CreatedAt: now}, resp)
+	})
+}
+
+func TestListMyWebAuthnCredentialsHandler(t *testing.T) {
+	e := echo.New()
+
+	t.Run("no claims", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newCredentialCtx(e, http.MethodGet, "", "", "", 0)
+		require.NoError(t, ListMyWebAuthnCredentialsHandler(nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("list error", func(t *testing.T) {
+		t.Cleanup(restore)
+		listWebAuthnCredentials = func(context.Context, database.DB, int) ([]model.WebAuthnCredential, error) {
+			return nil, errors.New("l")
+		}
+		ctx, rec := newCredentialCtx(e, http.MethodGet, "", "", "", 1)
+		require.NoError(t, ListMyWebAuthnCredentialsHandler(nil)(ctx))
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		t.Cleanup(restore)
+		listWebAuthnCredentials = func(context.Context, database.DB, int) ([]model.WebAuthnCredential, error) { return nil, nil }
+		ctx, rec := newCredentialCtx(e, http.MethodGet, "", "", "", 1)
+		require.NoError(t, ListMyWebAuthnCredentialsHandler(nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, `[]`, rec.Body.String())
+	})
+
+	t.Run("success", func(t *testing.T) {
+		t.Cleanup(restore)
+		now := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+		var gotID int
+		listWebAuthnCredentials = func(_ context.Context, _ database.DB, id int) ([]model.WebAuthnCredential, error) {
+			gotID = id
+			return []model.WebAuthnCredential{{ID: "a", Name: "Laptop", PublicKey: []byte{1}, Transports: []string{"internal"}, CreatedAt: now, LastUsedAt: &now}}, nil
+		}
+		ctx, rec := newCredentialCtx(e, http.MethodGet, "", "", "", 4)
+		require.NoError(t, ListMyWebAuthnCredentialsHandler(nil)(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, 4, gotID)
+		var resp []api.WebAuthnCredentialResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, []api.WebAuthnCredentialResponse{{ID: "a", Name: "Laptop", Transports: []string{"internal"}, CreatedAt: now, LastUsedAt: &now}}, resp)
+	})
+}
+
+func TestRenameMyWebAuthnCredentialHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &stubValidator{}
+
+	t.Run("validate error", func(t *testing.T) {
+		t.Cleanup(restore)
+		e.Validator = &stubValidator{err: errors.New("v")}
+		defer func() { e.Validator = &stubValidator{} }()
+		ctx, rec := newCredentialCtx(e, http.MethodPatch, "a", echo.MIMEApplicationForm, "name=", 1)
+		require.NoError(t, RenameMyWebAuthnCredentialHandler(nil)(ctx))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("no claims", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newCredentialCtx(e, http.MethodPatch, "a", echo.MIMEApplicationForm, "name=Laptop", 0)
+		require.NoError(t, RenameMyWebAuthnCredentialHandler(nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	for _, tc := range []struct {
+		name    string
+		renamed bool
+		err     error
+		code    int
+	}{
+		{"rename error", false, errors.New("db"), http.StatusInternalServerError},
+		{"not found", false, nil, http.StatusNotFound},
+		{"success", true, nil, http.StatusNoContent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(restore)
+			var gotUser int
+			var gotID, gotName string
+			renameWebAuthnCredential = func(_ context.Context, _ database.DB, userID int, id, name string) (bool, error) {
+				gotUser, gotID, gotName = userID, id, name
+				return tc.renamed, tc.err
+			}
+			ctx, rec := newCredentialCtx(e, http.MethodPatch, "a", echo.MIMEApplicationForm, "name=YubiKey", 5)
+			require.NoError(t, RenameMyWebAuthnCredentialHandler(nil)(ctx))
+			require.Equal(t, tc.code, rec.Code)
+			require.Equal(t, 5, gotUser)
+			require.Equal(t, "a", gotID)
+			require.Equal(t, "YubiKey", gotName)
+		})
+	}
+}
+
+func TestDeleteMyWebAuthnCredentialHandler(t *testing.T) {
+	e := echo.New()
+
+	t.Run("no claims", func(t *testing.T) {
+		t.Cleanup(restore)
+		ctx, rec := newCredentialCtx(e, http.MethodDelete, "a", "", "", 0)
+		require.NoError(t, DeleteMyWebAuthnCredentialHandler(nil)(ctx))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	for _, tc := range []struct {
+		name    string
+		deleted bool
+		err     error
+		code    int
+	}{
+		{"delete error", false, errors.New("db"), http.StatusInternalServerError},
+		{"not found", false, nil, http.StatusNotFound},
+		{"success", true, nil, http.StatusNoContent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(restore)
+			var gotUser int
+			var gotID string
+			deleteWebAuthnCredential = func(_ context.Context, _ database.DB, userID int, id string) (bool, error) {
+				gotUser, gotID = userID, id
+				return tc.deleted, tc.err
+			}
+			ctx, rec := newCredentialCtx(e, http.MethodDelete, "a", "", "", 6)
+			require.NoError(t, DeleteMyWebAuthnCredentialHandler(nil)(ctx))
+			require.Equal(t, tc.code, rec.Code)
+			require.Equal(t, 6, gotUser)
+			require.Equal(t, "a", gotID)
+		})
+	}
+}
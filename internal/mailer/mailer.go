@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Message 為一封純文字郵件
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 定義寄送郵件的介面
+// 正式環境使用 SMTPMailer，測試與本機開發使用 Outbox
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// encode 產生 RFC 5322 格式的郵件內容，主旨以 RFC 2047 編碼以支援非 ASCII 字元
+func (m Message) encode(from string, date time.Time) ([]byte, error) {
+	for _, v := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("invalid mail header: %q", v)
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
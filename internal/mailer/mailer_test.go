@@ -0,0 +1,86 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageEncode(t *testing.T) {
+	date := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	b, err := Message{To: "alice@example.com", Subject: "驗證 Email", Body: "line1\nline2"}.encode("noreply@example.com", date)
+	require.NoError(t, err)
+	s := string(b)
+	require.Contains(t, s, "From: noreply@example.com\r\n")
+	require.Contains(t, s, "To: alice@example.com\r\n")
+	require.Contains(t, s, "Subject: =?utf-8?q?")
+	require.Contains(t, s, "Date: Thu, 01 May 2025 12:00:00 +0000\r\n")
+	require.True(t, strings.HasSuffix(s, "\r\n\r\nline1\r\nline2"))
+
+	// 禁止以換行注入額外的標頭
+	_, err = Message{To: "a@example.com\r\nBcc: b@example.com"}.encode("noreply@example.com", date)
+	require.Error(t, err)
+}
+
+func TestSMTPMailer(t *testing.T) {
+	t.Cleanup(func() { smtpSendMail = smtp.SendMail })
+
+	_, err := NewSMTPMailer("localhost", "noreply@example.com", "", "")
+	require.Error(t, err)
+	_, err = NewSMTPMailer("localhost:25", "", "", "")
+	require.Error(t, err)
+
+	m, err := NewSMTPMailer("localhost:25", "noreply@example.com", "", "")
+	require.NoError(t, err)
+	require.Nil(t, m.auth)
+	m, err = NewSMTPMailer("smtp.example.com:587", "noreply@example.com", "user", "pw")
+	require.NoError(t, err)
+	require.NotNil(t, m.auth)
+
+	var gotAddr, gotFrom string
+	var gotTo []string
+	smtpSendMail = func(addr string, _ smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotFrom, gotTo = addr, from, to
+		return nil
+	}
+	require.NoError(t, m.Send(context.Background(), Message{To: "alice@example.com", Subject: "hi", Body: "body"}))
+	require.Equal(t, "smtp.example.com:587", gotAddr)
+	require.Equal(t, "noreply@example.com", gotFrom)
+	require.Equal(t, []string{"alice@example.com"}, gotTo)
+
+	require.Error(t, m.Send(context.Background(), Message{To: "a\nb"}))
+	smtpSendMail = func(string, smtp.Auth, string, []string, []byte) error { return errors.New("refused") }
+	require.ErrorContains(t, m.Send(context.Background(), Message{To: "alice@example.com"}), "refused")
+}
+
+func TestOutbox(t *testing.T) {
+	o := NewOutbox("")
+	require.NoError(t, o.Send(context.Background(), Message{To: "a@example.com", Subject: "1"}))
+	require.NoError(t, o.Send(context.Background(), Message{To: "b@example.com", Subject: "2"}))
+	msgs := o.Messages()
+	require.Equal(t, []Message{{To: "a@example.com", Subject: "1"}, {To: "b@example.com", Subject: "2"}}, msgs)
+	msgs[0].To = "changed"
+	require.Equal(t, "a@example.com", o.Messages()[0].To)
+
+	dir := t.TempDir()
+	o = NewOutbox(dir)
+	require.NoError(t, o.Send(context.Background(), Message{To: "a@example.com", Subject: "hi", Body: "body"}))
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	b, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	require.Contains(t, string(b), "To: a@example.com")
+	require.Error(t, o.Send(context.Background(), Message{To: "a\r\nb"}))
+
+	o = NewOutbox(filepath.Join(dir, "missing"))
+	require.ErrorContains(t, o.Send(context.Background(), Message{To: "a@example.com"}), "failed to write mail")
+	require.Empty(t, o.Messages())
+}
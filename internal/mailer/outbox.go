@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outbox 將郵件保存在記憶體，並可寫入目錄中的 .eml 檔，不會真的寄出；供測試與本機開發使用
+type Outbox struct {
+	dir      string
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewOutbox 建立 Outbox；dir 為空時僅保存在記憶體
+func NewOutbox(dir string) *Outbox {
+	return &Outbox{dir: dir}
+}
+
+// Send 保存郵件
+func (o *Outbox) Send(_ context.Context, msg Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.dir != "" {
+		now := time.Now()
+		body, err := msg.encode("outbox@localhost", now)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%s-%03d.eml", now.UTC().Format("20060102T150405.000000000"), len(o.messages))
+		if err := os.WriteFile(filepath.Join(o.dir, name), body, 0o600); err != nil {
+			return fmt.Errorf("failed to write mail: %w", err)
+		}
+	}
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+// Messages 回傳已保存郵件的複本，依寄送順序排列
+func (o *Outbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message(nil), o.messages...)
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// smtpSendMail 用來寄送郵件，測試可覆寫此變數。
+var smtpSendMail = smtp.SendMail
+
+// SMTPMailer 透過 SMTP 伺服器寄送郵件；伺服器支援時會以 STARTTLS 加密連線
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer 建立 SMTPMailer
+// addr: host:port；from: 寄件者；username 為空時不進行驗證
+func NewSMTPMailer(addr, from, username, password string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address: %w", err)
+	}
+	if from == "" {
+		return nil, fmt.Errorf("missing sender address")
+	}
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+// Send 寄出郵件
+func (m *SMTPMailer) Send(_ context.Context, msg Message) error {
+	body, err := msg.encode(m.from, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := smtpSendMail(m.addr, m.auth, m.from, []string{msg.To}, body); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package middleware
 
 import (
+	"crypto/x509"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	"life-is-hard/internal/cache"
 	"life-is-hard/internal/service"
 
 	"github.com/labstack/echo/v4"
@@ -12,40 +15,181 @@ import (
 
 const ContextUserKey = "user"
 
-func extractClaims(c echo.Context) (*service.CustomClaims, error) {
+func extractClaims(c echo.Context, cache cache.Cache) (*service.CustomClaims, error) {
 	authHeader := c.Request().Header.Get("Authorization")
 	if authHeader == "" {
 		return nil, echo.NewHTTPError(http.StatusUnauthorized, "missing token")
 	}
 	parts := strings.SplitN(authHeader, " ", 2)
-	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+	if len(parts) != 2 || (!strings.EqualFold(parts[0], "bearer") && !strings.EqualFold(parts[0], "dpop")) {
 		return nil, echo.NewHTTPError(http.StatusUnauthorized, "invalid authorization header format")
 	}
 	tokenString := parts[1]
-	claims, err := service.VerifyAccessToken(tokenString)
+	claims, err := service.VerifyAccessToken(c.Request().Context(), cache, tokenString)
 	if err != nil {
 		return nil, echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("invalid token: %v", err))
 	}
+	// aud 限定給其他服務的 token（例如 token exchange 換得者）本 API 不接受
+	if !service.AcceptsAudience(claims.Audience) {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "invalid token: audience restricted to another service")
+	}
+	if err := verifyDPoPBinding(c, cache, claims, parts[0], tokenString); err != nil {
+		return nil, err
+	}
+	// RFC 8705 §3：憑證綁定的 token 只能在出示同一張 client 憑證的連線上使用
+	var chain []*x509.Certificate
+	if state := c.Request().TLS; state != nil {
+		chain = state.PeerCertificates
+	}
+	if err := service.VerifyCertificateBinding(claims, chain); err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("invalid token: %v", err))
+	}
 	return claims, nil
 }
 
-func RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		claims, err := extractClaims(c)
-		if err != nil {
-			return err
+// verifyDPoPBinding 依 RFC 9449 §7 驗證 DPoP 綁定：cnf.jkt 綁定的 token 須以 DPoP scheme 傳送，
+// 並附上以同一把金鑰簽署、ath 對應此 token 的 proof；未綁定的 token 不得使用 DPoP scheme
+func verifyDPoPBinding(c echo.Context, cache cache.Cache, claims *service.CustomClaims, scheme, token string) error {
+	var jkt string
+	if claims.Confirmation != nil {
+		jkt = claims.Confirmation.JKT
+	}
+	if !strings.EqualFold(scheme, "dpop") {
+		if jkt != "" {
+			return dpopError(c, "DPoP-bound token requires the DPoP authorization scheme")
 		}
-		c.Set(ContextUserKey, claims)
-		return next(c)
+		return nil
+	}
+	if jkt == "" {
+		return dpopError(c, "token is not DPoP-bound")
+	}
+	proofs := c.Request().Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return dpopError(c, "exactly one DPoP proof is required")
+	}
+	req := c.Request()
+	proofJKT, err := service.VerifyDPoPProof(req.Context(), cache, proofs[0], service.DPoPRequest{
+		Method:      req.Method,
+		URL:         c.Scheme() + "://" + req.Host + req.URL.Path,
+		AccessToken: token,
+	})
+	if err != nil {
+		return dpopError(c, err.Error())
+	}
+	if proofJKT != jkt {
+		return dpopError(c, "DPoP proof key does not match token binding")
 	}
+	return nil
+}
+
+// dpopError 回傳 401 並依 RFC 9449 §7.1 於 WWW-Authenticate 提示 DPoP scheme
+func dpopError(c echo.Context, message string) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate,
+		fmt.Sprintf(`DPoP algs="%s", error="invalid_dpop_proof"`, strings.Join(service.DPoPSigningAlgorithms(), " ")))
+	return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("invalid token: %s", message))
 }
 
-func RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
-	return RequireAuth(func(c echo.Context) error {
-		claims := c.Get(ContextUserKey).(*service.CustomClaims)
-		if !claims.IsAdmin {
-			return echo.NewHTTPError(http.StatusForbidden, "admin privileges required")
+// RequireAuth 驗證 Bearer 或 DPoP token（含撤銷清單）並將 claims 放入 context
+func RequireAuth(cache cache.Cache) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, err := extractClaims(c, cache)
+			if err != nil {
+				return err
+			}
+			c.Set(ContextUserKey, claims)
+			return next(c)
 		}
-		return next(c)
-	})
+	}
+}
+
+// OptionalAuth 帶有 Authorization header 時與 RequireAuth 相同，未帶時直接放行，供可匿名呼叫的端點使用
+func OptionalAuth(cache cache.Cache) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Header.Get("Authorization") == "" {
+				return next(c)
+			}
+			return RequireAuth(cache)(next)(c)
+		}
+	}
+}
+
+// RequireAdmin 要求管理員的 token；設定管理員須使用 MFA 時，僅以密碼登入的 token 依 RFC 9470 要求以多重因素重新登入
+func RequireAdmin(cache cache.Cache) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return RequireAuth(cache)(func(c echo.Context) error {
+			claims := c.Get(ContextUserKey).(*service.CustomClaims)
+			if !claims.IsAdmin {
+				return echo.NewHTTPError(http.StatusForbidden, "admin privileges required")
+			}
+			if !service.AdminMFASatisfied(claims) {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate,
+					fmt.Sprintf(`Bearer error="insufficient_user_authentication", error_description="multi-factor authentication required", acr_values="%s"`, service.ACRMultiFactor))
+				return echo.NewHTTPError(http.StatusUnauthorized, "multi-factor authentication required")
+			}
+			return next(c)
+		})
+	}
+}
+
+// RequireFirstParty 僅接受使用者直接登入取得的第一方 token（無 client_id），須置於 RequireAuth 之後；
+// 供變更登入方式等不應交由 OAuth client 代為操作的端點使用
+func RequireFirstParty() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := c.Get(ContextUserKey).(*service.CustomClaims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing token")
+			}
+			if claims.ClientID != "" {
+				return echo.NewHTTPError(http.StatusForbidden, "a user login token is required")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireRecentAuth 要求使用者於 maxAge 內登入取得的第一方 token，須置於 RequireAuth 之後；
+// 否則依 RFC 9470 以 max_age 要求重新登入
+func RequireRecentAuth(maxAge time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := c.Get(ContextUserKey).(*service.CustomClaims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing token")
+			}
+			if !service.AuthenticatedWithin(claims, maxAge) {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate,
+					fmt.Sprintf(`Bearer error="insufficient_user_authentication", error_description="recent authentication required", max_age=%d`, int(maxAge.Seconds())))
+				return echo.NewHTTPError(http.StatusUnauthorized, "recent authentication required")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireScope 要求 OAuth client 取得的 access token 具備所有指定 scope，須置於 RequireAuth 或 RequireAdmin 之後；
+// 使用者直接登入取得的第一方 token（無 client_id）不受 scope 限制
+func RequireScope(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := c.Get(ContextUserKey).(*service.CustomClaims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing token")
+			}
+			if claims.ClientID == "" {
+				return next(c)
+			}
+			for _, scope := range scopes {
+				if !service.HasScope(claims.Scope, scope) {
+					// RFC 6750 §3.1
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate,
+						fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+					return echo.NewHTTPError(http.StatusForbidden, "insufficient scope")
+				}
+			}
+			return next(c)
+		}
+	}
 }
@@ -1,15 +1,29 @@
 package middleware
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"life-is-hard/internal/cache"
 	"life-is-hard/internal/model"
 	"life-is-hard/internal/service"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 )
 
@@ -23,43 +37,203 @@ func newContext(auth string) (echo.Context, *httptest.ResponseRecorder) {
 	return e.NewContext(req, rec), rec
 }
 
+// notRevoked 模擬撤銷清單中查無此 jti
+func notRevoked() *cache.FakeCache {
+	return &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+		return redis.NewStringResult("", redis.Nil)
+	}}
+}
+
 func TestExtractClaims(t *testing.T) {
 	t.Setenv("JWT_SECRET", "testsecret")
 
 	// missing header
 	ctx, _ := newContext("")
-	_, err := extractClaims(ctx)
+	_, err := extractClaims(ctx, notRevoked())
 	require.Error(t, err)
 
 	// bad format
 	ctx, _ = newContext("BadHeader")
-	_, err = extractClaims(ctx)
+	_, err = extractClaims(ctx, notRevoked())
 	require.Error(t, err)
 
 	// invalid token
 	ctx, _ = newContext("Bearer invalid")
-	_, err = extractClaims(ctx)
+	_, err = extractClaims(ctx, notRevoked())
 	require.Error(t, err)
 
 	// valid token
-	tok, err := service.IssueAccessToken(model.User{ID: 1, IsAdmin: true}, time.Minute)
+	tok, err := service.IssueAccessToken(context.Background(), model.User{ID: 1, IsAdmin: true}, "", "", nil, time.Minute, nil, service.Authentication{})
 	require.NoError(t, err)
 	ctx, _ = newContext("Bearer " + tok)
-	claims, err := extractClaims(ctx)
+	claims, err := extractClaims(ctx, notRevoked())
 	require.NoError(t, err)
 	require.Equal(t, 1, claims.UserID)
 	require.True(t, claims.IsAdmin)
+
+	// audience 限定給其他服務的 token
+	exchanged, _, err := service.IssueExchangedAccessToken(context.Background(), model.OAuthClient{ClientID: "orders"},
+		&service.CustomClaims{UserID: 1}, nil, []string{"billing"}, "", time.Minute, nil)
+	require.NoError(t, err)
+	ctx, _ = newContext("Bearer " + exchanged)
+	_, err = extractClaims(ctx, notRevoked())
+	require.ErrorContains(t, err, "audience restricted")
+
+	// aud 含有伺服器預設 audience 的 token 可使用
+	service.UseTokenLifetimes(service.TokenLifetimes{Audience: []string{"life-is-hard"}})
+	t.Cleanup(func() { service.UseTokenLifetimes(service.DefaultTokenLifetimes) })
+	tok, err = service.IssueAccessToken(context.Background(), model.User{ID: 1}, "", "", []string{"life-is-hard", "billing"}, time.Minute, nil, service.Authentication{})
+	require.NoError(t, err)
+	ctx, _ = newContext("Bearer " + tok)
+	_, err = extractClaims(ctx, notRevoked())
+	require.NoError(t, err)
+
+	// revoked token
+	revoked := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+		return redis.NewStringResult("1", nil)
+	}}
+	ctx, _ = newContext("Bearer " + tok)
+	_, err = extractClaims(ctx, revoked)
+	require.Error(t, err)
+
+	// denylist unavailable
+	broken := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+		return redis.NewStringResult("", errors.New("down"))
+	}}
+	ctx, _ = newContext("Bearer " + tok)
+	_, err = extractClaims(ctx, broken)
+	require.Error(t, err)
+}
+
+// newClientCertificate 產生測試用的自簽 client 憑證
+func newClientCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "svc"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestExtractClaimsCertificateBound(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+	cert := newClientCertificate(t)
+	cnf := service.CertificateConfirmation([]*x509.Certificate{cert})
+	tok, err := service.IssueAccessToken(context.Background(), model.User{ID: 1}, "cid", "", nil, time.Minute, cnf, service.Authentication{})
+	require.NoError(t, err)
+
+	// 未出示憑證
+	ctx, _ := newContext("Bearer " + tok)
+	_, err = extractClaims(ctx, notRevoked())
+	require.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+
+	// 出示其他憑證
+	ctx, _ = newContext("Bearer " + tok)
+	ctx.Request().TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{newClientCertificate(t)}}
+	_, err = extractClaims(ctx, notRevoked())
+	require.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+
+	// 出示綁定的憑證
+	ctx, _ = newContext("Bearer " + tok)
+	ctx.Request().TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	claims, err := extractClaims(ctx, notRevoked())
+	require.NoError(t, err)
+	require.Equal(t, cnf.X5tS256, claims.Confirmation.X5tS256)
+}
+
+// signDPoPProof 以 key 簽發對應 GET http://example.com/ 的 DPoP proof，ath 為 accessToken 的雜湊
+func signDPoPProof(t *testing.T, key *ecdsa.PrivateKey, accessToken string) string {
+	t.Helper()
+	sum := sha256.Sum256([]byte(accessToken))
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"htm": http.MethodGet,
+		"htu": "http://example.com/",
+		"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+		"jti": rand.Text(),
+		"iat": time.Now().Unix(),
+	})
+	token.Header["typ"] = service.DPoPProofType
+	token.Header["jwk"] = map[string]any{"kty": "EC", "crv": "P-256", "x": b64Coord(key.X), "y": b64Coord(key.Y)}
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+func b64Coord(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32)))
+}
+
+func TestExtractClaimsDPoP(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jkt, err := service.JWKThumbprint(service.JSONWebKey{KeyType: "EC", Curve: "P-256", X: b64Coord(key.X), Y: b64Coord(key.Y)})
+	require.NoError(t, err)
+	tok, err := service.IssueAccessToken(context.Background(), model.User{ID: 1}, "cid", "", nil, time.Minute, &service.Confirmation{JKT: jkt}, service.Authentication{})
+	require.NoError(t, err)
+	cch := cache.NewMemoryCache(nil)
+	requireDPoPError := func(ctx echo.Context, err error) {
+		t.Helper()
+		require.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+		require.Contains(t, ctx.Response().Header().Get(echo.HeaderWWWAuthenticate), `error="invalid_dpop_proof"`)
+	}
+
+	// 綁定的 token 不可當作 Bearer token 使用
+	ctx, _ := newContext("Bearer " + tok)
+	_, err = extractClaims(ctx, cch)
+	requireDPoPError(ctx, err)
+
+	// 缺少 proof
+	ctx, _ = newContext("DPoP " + tok)
+	_, err = extractClaims(ctx, cch)
+	requireDPoPError(ctx, err)
+
+	// proof 的 ath 對應其他 token
+	ctx, _ = newContext("DPoP " + tok)
+	ctx.Request().Header.Set("DPoP", signDPoPProof(t, key, "other"))
+	_, err = extractClaims(ctx, cch)
+	requireDPoPError(ctx, err)
+
+	// 以其他金鑰簽署的 proof
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ctx, _ = newContext("DPoP " + tok)
+	ctx.Request().Header.Set("DPoP", signDPoPProof(t, other, tok))
+	_, err = extractClaims(ctx, cch)
+	requireDPoPError(ctx, err)
+
+	ctx, _ = newContext("DPoP " + tok)
+	ctx.Request().Header.Set("DPoP", signDPoPProof(t, key, tok))
+	claims, err := extractClaims(ctx, cch)
+	require.NoError(t, err)
+	require.Equal(t, jkt, claims.Confirmation.JKT)
+
+	// 未綁定的 token 不可使用 DPoP scheme
+	bearer, err := service.IssueAccessToken(context.Background(), model.User{ID: 1}, "cid", "", nil, time.Minute, nil, service.Authentication{})
+	require.NoError(t, err)
+	ctx, _ = newContext("DPoP " + bearer)
+	ctx.Request().Header.Set("DPoP", signDPoPProof(t, key, bearer))
+	_, err = extractClaims(ctx, cch)
+	requireDPoPError(ctx, err)
 }
 
 func TestRequireAuth(t *testing.T) {
 	t.Setenv("JWT_SECRET", "secret")
-	tok, err := service.IssueAccessToken(model.User{ID: 2}, time.Minute)
+	tok, err := service.IssueAccessToken(context.Background(), model.User{ID: 2}, "", "", nil, time.Minute, nil, service.Authentication{})
 	require.NoError(t, err)
 
 	// success path
 	ctx, rec := newContext("Bearer " + tok)
 	called := false
-	handler := RequireAuth(func(c echo.Context) error {
+	handler := RequireAuth(notRevoked())(func(c echo.Context) error {
 		called = true
 		cl := c.Get(ContextUserKey).(*service.CustomClaims)
 		require.Equal(t, 2, cl.UserID)
@@ -72,22 +246,48 @@ func TestRequireAuth(t *testing.T) {
 	// missing token
 	ctx, _ = newContext("")
 	called = false
-	err = RequireAuth(func(echo.Context) error { called = true; return nil })(ctx)
+	err = RequireAuth(notRevoked())(func(echo.Context) error { called = true; return nil })(ctx)
 	require.Error(t, err)
 	require.False(t, called)
 }
 
+func TestOptionalAuth(t *testing.T) {
+	t.Setenv("JWT_SECRET", "secret")
+	tok, err := service.IssueAccessToken(context.Background(), model.User{ID: 2}, "", "", nil, time.Minute, nil, service.Authentication{})
+	require.NoError(t, err)
+	var claims *service.CustomClaims
+	next := func(c echo.Context) error {
+		claims, _ = c.Get(ContextUserKey).(*service.CustomClaims)
+		return nil
+	}
+
+	// 未帶 token 時放行且不設定 claims
+	ctx, _ := newContext("")
+	require.NoError(t, OptionalAuth(notRevoked())(next)(ctx))
+	require.Nil(t, claims)
+
+	ctx, _ = newContext("Bearer " + tok)
+	require.NoError(t, OptionalAuth(notRevoked())(next)(ctx))
+	require.Equal(t, 2, claims.UserID)
+
+	// 帶了無效的 token 仍須拒絕
+	claims = nil
+	ctx, _ = newContext("Bearer invalid")
+	require.Error(t, OptionalAuth(notRevoked())(next)(ctx))
+	require.Nil(t, claims)
+}
+
 func TestRequireAdmin(t *testing.T) {
 	t.Setenv("JWT_SECRET", "adminsecret")
-	adminTok, err := service.IssueAccessToken(model.User{ID: 3, IsAdmin: true}, time.Minute)
+	adminTok, err := service.IssueAccessToken(context.Background(), model.User{ID: 3, IsAdmin: true}, "", "", nil, time.Minute, nil, service.Authentication{})
 	require.NoError(t, err)
-	userTok, err := service.IssueAccessToken(model.User{ID: 4, IsAdmin: false}, time.Minute)
+	userTok, err := service.IssueAccessToken(context.Background(), model.User{ID: 4, IsAdmin: false}, "", "", nil, time.Minute, nil, service.Authentication{})
 	require.NoError(t, err)
 
 	// admin ok
 	ctx, rec := newContext("Bearer " + adminTok)
 	called := false
-	err = RequireAdmin(func(c echo.Context) error { called = true; return c.String(http.StatusOK, "admin") })(ctx)
+	err = RequireAdmin(notRevoked())(func(c echo.Context) error { called = true; return c.String(http.StatusOK, "admin") })(ctx)
 	require.NoError(t, err)
 	require.True(t, called)
 	require.Equal(t, http.StatusOK, rec.Code)
@@ -95,7 +295,103 @@ func TestRequireAdmin(t *testing.T) {
 	// non-admin should fail
 	ctx, _ = newContext("Bearer " + userTok)
 	called = false
-	err = RequireAdmin(func(c echo.Context) error { called = true; return nil })(ctx)
+	err = RequireAdmin(notRevoked())(func(c echo.Context) error { called = true; return nil })(ctx)
 	require.Error(t, err)
 	require.False(t, called)
 }
+
+func TestRequireAdminMFA(t *testing.T) {
+	t.Setenv("JWT_SECRET", "adminsecret")
+	service.UseAdminMFARequired(true)
+	t.Cleanup(func() { service.UseAdminMFARequired(false) })
+	next := func(c echo.Context) error { return c.String(http.StatusOK, "admin") }
+
+	// 僅以密碼登入的管理員須以多重因素重新登入
+	pwdTok, err := service.IssueAccessToken(context.Background(), model.User{ID: 3, IsAdmin: true}, "", "", nil, time.Minute, nil, service.PasswordAuthentication())
+	require.NoError(t, err)
+	ctx, rec := newContext("Bearer " + pwdTok)
+	err = RequireAdmin(notRevoked())(next)(ctx)
+	require.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+	require.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `error="insufficient_user_authentication"`)
+	require.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `acr_values="aal2"`)
+
+	mfaTok, err := service.IssueAccessToken(context.Background(), model.User{ID: 3, IsAdmin: true}, "", "", nil, time.Minute, nil, service.MFAAuthentication())
+	require.NoError(t, err)
+	ctx, rec = newContext("Bearer " + mfaTok)
+	require.NoError(t, RequireAdmin(notRevoked())(next)(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRequireScope(t *testing.T) {
+	next := func(c echo.Context) error { return c.String(http.StatusOK, "ok") }
+	withClaims := func(claims *service.CustomClaims) (echo.Context, *httptest.ResponseRecorder) {
+		ctx, rec := newContext("")
+		if claims != nil {
+			ctx.Set(ContextUserKey, claims)
+		}
+		return ctx, rec
+	}
+
+	// 未經 RequireAuth
+	ctx, _ := withClaims(nil)
+	err := RequireScope("users:read")(next)(ctx)
+	require.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+
+	// 第一方 token 不受限制
+	ctx, rec := withClaims(&service.CustomClaims{UserID: 1})
+	require.NoError(t, RequireScope("users:read")(next)(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// scope 足夠
+	ctx, rec = withClaims(&service.CustomClaims{UserID: 1, ClientID: "cid", Scope: "users:read users:write"})
+	require.NoError(t, RequireScope("users:read", "users:write")(next)(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// scope 不足
+	ctx, rec = withClaims(&service.CustomClaims{UserID: 1, ClientID: "cid", Scope: "users:read"})
+	err = RequireScope("users:read", "users:write")(next)(ctx)
+	require.Equal(t, http.StatusForbidden, err.(*echo.HTTPError).Code)
+	require.Equal(t, `Bearer error="insufficient_scope", scope="users:read users:write"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+}
+
+func TestRequireFirstParty(t *testing.T) {
+	next := func(c echo.Context) error { return c.String(http.StatusOK, "ok") }
+
+	ctx, _ := newContext("")
+	err := RequireFirstParty()(next)(ctx)
+	require.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+
+	ctx, rec := newContext("")
+	ctx.Set(ContextUserKey, &service.CustomClaims{UserID: 1})
+	require.NoError(t, RequireFirstParty()(next)(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// OAuth client 取得的 token 即使具備 scope 也不接受
+	ctx, _ = newContext("")
+	ctx.Set(ContextUserKey, &service.CustomClaims{UserID: 1, ClientID: "cid", Scope: "users:write"})
+	err = RequireFirstParty()(next)(ctx)
+	require.Equal(t, http.StatusForbidden, err.(*echo.HTTPError).Code)
+}
+
+func TestRequireRecentAuth(t *testing.T) {
+	next := func(c echo.Context) error { return c.String(http.StatusOK, "ok") }
+	withIssuedAt := func(iat time.Time) *service.CustomClaims {
+		return &service.CustomClaims{UserID: 1, Authentication: service.PasswordAuthentication(),
+			RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(iat)}}
+	}
+
+	ctx, _ := newContext("")
+	err := RequireRecentAuth(time.Minute)(next)(ctx)
+	require.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+
+	ctx, rec := newContext("")
+	ctx.Set(ContextUserKey, withIssuedAt(time.Now()))
+	require.NoError(t, RequireRecentAuth(time.Minute)(next)(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	ctx, rec = newContext("")
+	ctx.Set(ContextUserKey, withIssuedAt(time.Now().Add(-time.Hour)))
+	err = RequireRecentAuth(time.Minute)(next)(ctx)
+	require.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+	require.Equal(t, `Bearer error="insufficient_user_authentication", error_description="recent authentication required", max_age=60`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+}
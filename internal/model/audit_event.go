@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+const (
+	AuditEventRefreshTokenReuse = "refresh_token_reuse"
+)
+
+// AuditEvent 為安全相關事件的稽核紀錄；UserID 為 0 表示事件與特定使用者無關
+type AuditEvent struct {
+	ID        int64          `db:"id" json:"id"`
+	EventType string         `db:"event_type" json:"event_type"`
+	UserID    int            `db:"user_id" json:"user_id"`
+	ClientID  string         `db:"client_id" json:"client_id"`
+	IPAddress string         `db:"ip_address" json:"ip_address"`
+	Details   map[string]any `db:"details" json:"details"`
+	CreatedAt time.Time      `db:"created_at" json:"created_at"`
+}
@@ -1,12 +1,83 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	ClientTypePublic       = "public"
+	ClientTypeConfidential = "confidential"
+)
+
+// token endpoint 的 client 認證方式（OpenID Connect Core §9 / RFC 7591）
+const (
+	ClientAuthMethodSecretBasic = "client_secret_basic"
+	ClientAuthMethodSecretPost  = "client_secret_post"
+	ClientAuthMethodSecretJWT   = "client_secret_jwt"
+	ClientAuthMethodPrivateKey  = "private_key_jwt"
+	ClientAuthMethodNone        = "none"
+	// RFC 8705 §2：以 TLS client 憑證認證
+	ClientAuthMethodTLS           = "tls_client_auth"
+	ClientAuthMethodSelfSignedTLS = "self_signed_tls_client_auth"
+)
 
 type OAuthClient struct {
-	ClientID     string    `db:"client_id" json:"client_id"`
-	ClientSecret string    `db:"client_secret" json:"client_secret"`
+	ClientID string `db:"client_id" json:"client_id"`
+	// ClientSecret 為 bcrypt 雜湊，明文只在建立或輪替時回傳一次
+	ClientSecret string `db:"client_secret" json:"-"`
+	// UserID 為擁有者；匿名動態註冊的 client 沒有擁有者，值為 0
 	UserID       int       `db:"user_id" json:"user_id"`
+	ClientType   string    `db:"client_type" json:"client_type"`
+	ClientName   string    `db:"client_name" json:"client_name"`
+	LogoURI      string    `db:"logo_uri" json:"logo_uri"`
 	GrantTypes   []string  `db:"grant_types" json:"grant_types"`
+	RedirectURIs []string  `db:"redirect_uris" json:"redirect_uris"`
+	Scopes       []string  `db:"scopes" json:"scopes"`
 	CreatedAt    time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+	// PreviousClientSecret 為輪替前的 secret 雜湊，在 PreviousClientSecretExpiresAt 之前仍可通過驗證
+	PreviousClientSecret          string     `db:"previous_client_secret" json:"-"`
+	PreviousClientSecretExpiresAt *time.Time `db:"previous_client_secret_expires_at" json:"-"`
+	TokenEndpointAuthMethod       string     `db:"token_endpoint_auth_method" json:"token_endpoint_auth_method"`
+	// JWKS 為 private_key_jwt 用來驗證 client assertion 的公開金鑰（RFC 7517 JWK Set）
+	JWKS json.RawMessage `db:"jwks" json:"jwks,omitempty"`
+	// SealedClientSecret 為以簽章金鑰庫加密的 secret 明文，僅 client_secret_jwt 需要以其驗證 HMAC
+	SealedClientSecret []byte `db:"client_secret_sealed" json:"-"`
+	// TLSClientAuthSubjectDN 為 tls_client_auth 要求的憑證 subject（RFC 4514 字串）
+	TLSClientAuthSubjectDN string `db:"tls_client_auth_subject_dn" json:"tls_client_auth_subject_dn,omitempty"`
+	// TLSClientCertificateThumbprint 為 self_signed_tls_client_auth 登記的憑證 SHA-256 指紋（base64url）
+	TLSClientCertificateThumbprint string `db:"tls_client_certificate_thumbprint" json:"tls_client_certificate_thumbprint,omitempty"`
+	// TLSClientCertificateBoundAccessTokens 為 true 時，access token 綁定至 token endpoint 出示的 client 憑證
+	TLSClientCertificateBoundAccessTokens bool `db:"tls_client_certificate_bound_access_tokens" json:"tls_client_certificate_bound_access_tokens"`
+	// RequirePushedAuthorizationRequests 為 true 時，授權請求必須先經由 /oauth/par 推送（RFC 9126）
+	RequirePushedAuthorizationRequests bool `db:"require_pushed_authorization_requests" json:"require_pushed_authorization_requests"`
+	// TokenExchangeAudiences 為 client 以 token exchange（RFC 8693）可換取的 audience
+	TokenExchangeAudiences []string `db:"token_exchange_audiences" json:"token_exchange_audiences"`
+	// 以下為 client 自訂的 token 效期（秒），0 表示沿用伺服器預設
+	AccessTokenTTL          int `db:"access_token_ttl" json:"access_token_ttl"`
+	RefreshTokenTTL         int `db:"refresh_token_ttl" json:"refresh_token_ttl"`
+	RefreshTokenAbsoluteTTL int `db:"refresh_token_absolute_ttl" json:"refresh_token_absolute_ttl"`
+	RefreshTokenIdleTimeout int `db:"refresh_token_idle_timeout" json:"refresh_token_idle_timeout"`
+	IDTokenTTL              int `db:"id_token_ttl" json:"id_token_ttl"`
+	// AccessTokenAudiences 為發給此 client 的 access token 的 aud，空陣列表示沿用伺服器預設
+	AccessTokenAudiences []string `db:"access_token_audiences" json:"access_token_audiences"`
+	// FirstParty 為 true 時為自家服務的 client，授權時不需使用者同意；僅管理員可設定
+	FirstParty bool `db:"first_party" json:"first_party"`
+	// RegistrationAccessToken 為 RFC 7592 registration access token 的 SHA-256 雜湊，僅動態註冊的 client 才有
+	RegistrationAccessToken string `db:"registration_access_token" json:"-"`
+}
+
+// IsPublic 回傳 client 是否為無法保管密鑰的 public client
+func (c OAuthClient) IsPublic() bool {
+	return c.ClientType == ClientTypePublic
+}
+
+// UsesClientSecret 回傳 client 是否以 client secret 認證
+func (c OAuthClient) UsesClientSecret() bool {
+	switch c.TokenEndpointAuthMethod {
+	case ClientAuthMethodSecretBasic, ClientAuthMethodSecretPost, ClientAuthMethodSecretJWT:
+		return true
+	}
+	return false
 }
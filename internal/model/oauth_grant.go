@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// OAuthGrant 為使用者同意 client 存取的 scope；同一使用者對同一 client 只有一筆，再次同意時合併 scope
+type OAuthGrant struct {
+	UserID    int       `db:"user_id" json:"user_id"`
+	ClientID  string    `db:"client_id" json:"client_id"`
+	Scopes    []string  `db:"scopes" json:"scopes"`
+	GrantedAt time.Time `db:"granted_at" json:"granted_at"`
+	// ClientName 與 LogoURI 僅於列出使用者的同意時自 oauth_clients 帶出
+	ClientName string `db:"client_name" json:"client_name,omitempty"`
+	LogoURI    string `db:"logo_uri" json:"logo_uri,omitempty"`
+}
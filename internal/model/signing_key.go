@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+const (
+	SigningKeyStatusNext    = "next"
+	SigningKeyStatusActive  = "active"
+	SigningKeyStatusRetired = "retired"
+)
+
+// SigningKey 為簽發 JWT 的非對稱金鑰；PrivateKey 以 AES-GCM 加密後儲存
+type SigningKey struct {
+	KID        string     `db:"kid" json:"kid"`
+	Algorithm  string     `db:"algorithm" json:"algorithm"`
+	Status     string     `db:"status" json:"status"`
+	PrivateKey []byte     `db:"private_key" json:"-"`
+	PublicKey  []byte     `db:"public_key" json:"public_key"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time  `db:"updated_at" json:"updated_at"`
+}
@@ -3,10 +3,12 @@ package model
 import "time"
 
 type User struct {
-	ID           int       `db:"id" json:"id"`
-	Name         string    `db:"name" json:"name"`
-	Email        string    `db:"email" json:"email"`
-	PasswordHash string    `db:"password_hash" json:"password_hash"`
-	IsAdmin      bool      `db:"is_admin" json:"is_admin"`
-	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	ID           int    `db:"id" json:"id"`
+	Name         string `db:"name" json:"name"`
+	Email        string `db:"email" json:"email"`
+	PasswordHash string `db:"password_hash" json:"password_hash"`
+	IsAdmin      bool   `db:"is_admin" json:"is_admin"`
+	// EmailVerified 表示使用者已驗證目前的 Email
+	EmailVerified bool      `db:"email_verified" json:"email_verified"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
 }
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// UserTOTP 為使用者的 TOTP 金鑰；Secret 設定加密金鑰時以 AES-GCM 加密後儲存，ConfirmedAt 為 nil 表示尚未完成註冊
+type UserTOTP struct {
+	UserID       int        `db:"user_id" json:"user_id"`
+	Secret       string     `db:"secret" json:"-"`
+	ConfirmedAt  *time.Time `db:"confirmed_at" json:"confirmed_at"`
+	LastUsedStep int64      `db:"last_used_step" json:"-"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
+
+// Confirmed 回傳 TOTP 是否已完成註冊並啟用
+func (t *UserTOTP) Confirmed() bool {
+	return t.ConfirmedAt != nil
+}
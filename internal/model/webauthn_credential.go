@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// WebAuthnCredential 為使用者註冊的 passkey；ID 為 base64url 編碼的 credential ID，PublicKey 為 COSE 格式的公鑰
+type WebAuthnCredential struct {
+	ID         string     `db:"id" json:"id"`
+	UserID     int        `db:"user_id" json:"user_id"`
+	Name       string     `db:"name" json:"name"`
+	PublicKey  []byte     `db:"public_key" json:"-"`
+	SignCount  int64      `db:"sign_count" json:"sign_count"`
+	Transports []string   `db:"transports" json:"transports"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+}
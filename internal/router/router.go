@@ -10,34 +10,81 @@ import (
 	"life-is-hard/internal/handler/oauth"
 	"life-is-hard/internal/handler/users"
 	"life-is-hard/internal/middleware"
+	"life-is-hard/internal/service"
 )
 
 // Setup 註冊所有路由與中介層
 func Setup(e *echo.Echo, db database.DB, cache cache.Cache) {
 	api := e.Group("/api")
 
+	// 公開驗證 access token 的公鑰與 OpenID Connect Discovery
+	e.GET("/.well-known/jwks.json", oauth.JWKSHandler())
+	e.GET("/.well-known/openid-configuration", oauth.OpenIDConfigurationHandler())
+
 	// 健康檢查（需登入）
-	api.GET("/ping", handler.PingHandler(db, cache), middleware.RequireAuth)
+	api.GET("/ping", handler.PingHandler(db, cache), middleware.RequireAuth(cache))
 
 	// 使用者登入
-	api.POST("/auth/login", auth.LoginHandler(db))
+	api.POST("/auth/login", auth.LoginHandler(db, cache))
+	api.POST("/auth/mfa/verify", auth.VerifyMFAHandler(db, cache))
+	api.POST("/auth/webauthn/login/options", auth.WebAuthnLoginOptionsHandler(cache))
+	api.POST("/auth/webauthn/login", auth.WebAuthnLoginHandler(db, cache))
+	api.POST("/auth/password/forgot", auth.ForgotPasswordHandler(db, cache))
+	api.POST("/auth/password/reset", auth.ResetPasswordHandler(db, cache))
 	api.POST("/oauth/token", oauth.TokenHandler(db, cache))
+	api.POST("/oauth/revoke", oauth.RevokeHandler(db, cache))
+	api.POST("/oauth/introspect", oauth.IntrospectHandler(db, cache))
+	api.GET("/oauth/authorize", oauth.AuthorizeHandler(db, cache), middleware.RequireAuth(cache))
+	api.POST("/oauth/authorize/consent", oauth.ConsentHandler(db, cache), middleware.RequireAuth(cache))
+	api.POST("/oauth/par", oauth.PushedAuthorizationHandler(db, cache))
+	api.POST("/oauth/device_authorization", oauth.DeviceAuthorizationHandler(db, cache))
+	api.GET("/oauth/device", oauth.DeviceVerificationHandler(db, cache), middleware.RequireAuth(cache))
+	api.POST("/oauth/device", oauth.DeviceApprovalHandler(cache), middleware.RequireAuth(cache))
+	api.GET("/oauth/userinfo", oauth.UserInfoHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeOpenID))
+	api.POST("/oauth/userinfo", oauth.UserInfoHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeOpenID))
+	api.POST("/oauth/register", oauth.RegisterClientHandler(db), middleware.OptionalAuth(cache))
+	api.GET("/oauth/register/:client_id", oauth.GetClientRegistrationHandler(db))
+	api.PUT("/oauth/register/:client_id", oauth.UpdateClientRegistrationHandler(db))
+	api.DELETE("/oauth/register/:client_id", oauth.DeleteClientRegistrationHandler(db))
+	api.POST("/oauth/signing-keys/rotate", oauth.RotateSigningKeysHandler(), middleware.RequireAdmin(cache), middleware.RequireScope(service.ScopeKeysManage))
+
+	// 管理員專屬 Users CRUD；經由 OAuth client 取得的 token 另須具備對應 scope
+	api.POST("/users", users.CreateUserHandler(db, cache), middleware.RequireAdmin(cache), middleware.RequireScope(service.ScopeUsersWrite))
+	api.GET("/users", users.ListUsersHandler(db), middleware.RequireAdmin(cache), middleware.RequireScope(service.ScopeUsersRead))
+	api.GET("/users/:id", users.GetUserHandler(db), middleware.RequireAdmin(cache), middleware.RequireScope(service.ScopeUsersRead))
+	api.PUT("/users/:id", users.UpdateUserHandler(db, cache), middleware.RequireAdmin(cache), middleware.RequireScope(service.ScopeUsersWrite))
+	api.DELETE("/users/:id", users.DeleteUserHandler(db), middleware.RequireAdmin(cache), middleware.RequireScope(service.ScopeUsersWrite))
+	api.DELETE("/users/:id/mfa", users.ResetUserMFAHandler(db), middleware.RequireAdmin(cache), middleware.RequireScope(service.ScopeUsersWrite))
 
-	// 管理員專屬 Users CRUD
-	api.POST("/users", users.CreateUserHandler(db), middleware.RequireAdmin)
-	api.GET("/users/:id", users.GetUserHandler(db), middleware.RequireAdmin)
-	api.PUT("/users/:id", users.UpdateUserHandler(db), middleware.RequireAdmin)
-	api.DELETE("/users/:id", users.DeleteUserHandler(db), middleware.RequireAdmin)
+	// 以驗證信中的 token 驗證 Email（不需登入）
+	api.POST("/users/email/verify", users.VerifyEmailHandler(db, cache))
 
 	// 取得、更新、刪除當前使用者個人資料
-	api.GET("/users/me", users.GetMyUserHandler(db), middleware.RequireAuth)
-	api.PUT("/users/me", users.UpdateMyUserHandler(db), middleware.RequireAuth)
-	api.DELETE("/users/me", users.DeleteMyUserHandler(db), middleware.RequireAuth)
-	api.PATCH("/users/me/password", users.UpdateMyUserPasswordHandler(db), middleware.RequireAuth)
-
-	api.POST("/users/me/oauth-clients", users.CreateMyOAuthClientHandler(db), middleware.RequireAuth)
-	api.GET("/users/me/oauth-clients", users.ListMyOAuthClientsHandler(db), middleware.RequireAuth)
-	api.GET("/users/me/oauth-clients/:client_id", users.GetMyOAuthClientHandler(db), middleware.RequireAuth)
-	api.PUT("/users/me/oauth-clients/:client_id", users.UpdateMyOAuthClientHandler(db), middleware.RequireAuth)
-	api.DELETE("/users/me/oauth-clients/:client_id", users.DeleteMyOAuthClientHandler(db), middleware.RequireAuth)
+	api.GET("/users/me", users.GetMyUserHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeUsersRead))
+	api.PUT("/users/me", users.UpdateMyUserHandler(db, cache), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeUsersWrite))
+	api.DELETE("/users/me", users.DeleteMyUserHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeUsersWrite))
+	api.PATCH("/users/me/password", users.UpdateMyUserPasswordHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeUsersWrite))
+	api.POST("/users/me/email/verification", users.ResendMyEmailVerificationHandler(db, cache), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeUsersWrite))
+	// MFA 設定僅限使用者直接登入，OAuth client 即使具備 users:write 也不可變更
+	api.POST("/users/me/mfa/totp", users.BeginMyTOTPEnrollmentHandler(db), middleware.RequireAuth(cache), middleware.RequireFirstParty())
+	api.POST("/users/me/mfa/totp/confirm", users.ConfirmMyTOTPEnrollmentHandler(db), middleware.RequireAuth(cache), middleware.RequireFirstParty())
+	api.DELETE("/users/me/mfa/totp", users.DisableMyMFAHandler(db, cache), middleware.RequireAuth(cache), middleware.RequireFirstParty())
+	api.POST("/users/me/mfa/recovery-codes", users.RegenerateMyRecoveryCodesHandler(db, cache), middleware.RequireAuth(cache), middleware.RequireFirstParty())
+	// 註冊 passkey 僅限使用者直接登入，且須為剛登入取得的 token，避免竊得的 token 留下永久的登入方式
+	api.POST("/users/me/webauthn/registration/options", users.BeginMyWebAuthnRegistrationHandler(db, cache), middleware.RequireAuth(cache), middleware.RequireFirstParty(), middleware.RequireRecentAuth(service.ReauthenticationMaxAge))
+	api.POST("/users/me/webauthn/credentials", users.FinishMyWebAuthnRegistrationHandler(db, cache), middleware.RequireAuth(cache), middleware.RequireFirstParty(), middleware.RequireRecentAuth(service.ReauthenticationMaxAge))
+	api.GET("/users/me/webauthn/credentials", users.ListMyWebAuthnCredentialsHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeUsersRead))
+	api.PATCH("/users/me/webauthn/credentials/:credential_id", users.RenameMyWebAuthnCredentialHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeUsersWrite))
+	api.DELETE("/users/me/webauthn/credentials/:credential_id", users.DeleteMyWebAuthnCredentialHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeUsersWrite))
+
+	// 當前使用者對 OAuth client 的同意
+	api.GET("/users/me/grants", users.ListMyGrantsHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeUsersRead))
+	api.DELETE("/users/me/grants/:client_id", users.RevokeMyGrantHandler(db, cache), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeUsersWrite))
+
+	api.POST("/users/me/oauth-clients", users.CreateMyOAuthClientHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeClientsManage))
+	api.GET("/users/me/oauth-clients", users.ListMyOAuthClientsHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeClientsManage))
+	api.GET("/users/me/oauth-clients/:client_id", users.GetMyOAuthClientHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeClientsManage))
+	api.PUT("/users/me/oauth-clients/:client_id", users.UpdateMyOAuthClientHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeClientsManage))
+	api.DELETE("/users/me/oauth-clients/:client_id", users.DeleteMyOAuthClientHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeClientsManage))
+	api.POST("/users/me/oauth-clients/:client_id/secret", users.RotateMyOAuthClientSecretHandler(db), middleware.RequireAuth(cache), middleware.RequireScope(service.ScopeClientsManage))
 }
@@ -1,13 +1,19 @@
 package router
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"life-is-hard/internal/cache"
 	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/service"
 
 	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 )
 
@@ -23,20 +29,58 @@ func TestSetupRoutes(t *testing.T) {
 	expected := []string{
 		http.MethodGet + " /api/ping",
 		http.MethodPost + " /api/auth/login",
+		http.MethodPost + " /api/auth/mfa/verify",
+		http.MethodPost + " /api/auth/webauthn/login/options",
+		http.MethodPost + " /api/auth/webauthn/login",
+		http.MethodPost + " /api/auth/password/forgot",
+		http.MethodPost + " /api/auth/password/reset",
 		http.MethodPost + " /api/oauth/token",
+		http.MethodPost + " /api/oauth/revoke",
+		http.MethodPost + " /api/oauth/introspect",
+		http.MethodGet + " /api/oauth/authorize",
+		http.MethodPost + " /api/oauth/authorize/consent",
+		http.MethodPost + " /api/oauth/par",
+		http.MethodPost + " /api/oauth/device_authorization",
+		http.MethodGet + " /api/oauth/device",
+		http.MethodPost + " /api/oauth/device",
+		http.MethodPost + " /api/oauth/register",
+		http.MethodGet + " /api/oauth/register/:client_id",
+		http.MethodPut + " /api/oauth/register/:client_id",
+		http.MethodDelete + " /api/oauth/register/:client_id",
+		http.MethodPost + " /api/oauth/signing-keys/rotate",
+		http.MethodGet + " /.well-known/jwks.json",
+		http.MethodGet + " /.well-known/openid-configuration",
+		http.MethodGet + " /api/oauth/userinfo",
+		http.MethodPost + " /api/oauth/userinfo",
 		http.MethodPost + " /api/users",
+		http.MethodGet + " /api/users",
 		http.MethodGet + " /api/users/:id",
 		http.MethodPut + " /api/users/:id",
 		http.MethodDelete + " /api/users/:id",
+		http.MethodDelete + " /api/users/:id/mfa",
 		http.MethodGet + " /api/users/me",
 		http.MethodPut + " /api/users/me",
 		http.MethodDelete + " /api/users/me",
 		http.MethodPatch + " /api/users/me/password",
+		http.MethodPost + " /api/users/me/email/verification",
+		http.MethodPost + " /api/users/me/mfa/totp",
+		http.MethodPost + " /api/users/me/mfa/totp/confirm",
+		http.MethodDelete + " /api/users/me/mfa/totp",
+		http.MethodPost + " /api/users/me/mfa/recovery-codes",
+		http.MethodPost + " /api/users/me/webauthn/registration/options",
+		http.MethodPost + " /api/users/me/webauthn/credentials",
+		http.MethodGet + " /api/users/me/webauthn/credentials",
+		http.MethodPatch + " /api/users/me/webauthn/credentials/:credential_id",
+		http.MethodDelete + " /api/users/me/webauthn/credentials/:credential_id",
+		http.MethodPost + " /api/users/email/verify",
+		http.MethodGet + " /api/users/me/grants",
+		http.MethodDelete + " /api/users/me/grants/:client_id",
 		http.MethodPost + " /api/users/me/oauth-clients",
 		http.MethodGet + " /api/users/me/oauth-clients",
 		http.MethodGet + " /api/users/me/oauth-clients/:client_id",
 		http.MethodPut + " /api/users/me/oauth-clients/:client_id",
 		http.MethodDelete + " /api/users/me/oauth-clients/:client_id",
+		http.MethodPost + " /api/users/me/oauth-clients/:client_id/secret",
 	}
 
 	require.Equal(t, len(expected), len(got))
@@ -45,3 +89,79 @@ func TestSetupRoutes(t *testing.T) {
 		require.True(t, ok, "missing route %s", k)
 	}
 }
+
+func TestRouteScopes(t *testing.T) {
+	t.Setenv("JWT_SECRET", "s")
+	e := echo.New()
+	notRevoked := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+		return redis.NewStringResult("", redis.Nil)
+	}}
+	Setup(e, &database.FakeDB{}, notRevoked)
+
+	tok, err := service.IssueAccessToken(context.Background(), model.User{ID: 1, IsAdmin: true}, "cid", "users:read", nil, time.Minute, nil, service.Authentication{})
+	require.NoError(t, err)
+
+	for _, tc := range []struct{ method, path, scope string }{
+		{http.MethodPost, "/api/users", "users:write"},
+		{http.MethodDelete, "/api/users/me", "users:write"},
+		{http.MethodGet, "/api/users/me/oauth-clients", "clients:manage"},
+		{http.MethodGet, "/api/oauth/userinfo", "openid"},
+		{http.MethodPost, "/api/oauth/signing-keys/rotate", "keys:manage"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusForbidden, rec.Code, tc.path)
+		require.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `scope="`+tc.scope+`"`, tc.path)
+	}
+}
+
+func TestRouteFirstParty(t *testing.T) {
+	t.Setenv("JWT_SECRET", "s")
+	e := echo.New()
+	notRevoked := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+		return redis.NewStringResult("", redis.Nil)
+	}}
+	Setup(e, &database.FakeDB{}, notRevoked)
+
+	tok, err := service.IssueAccessToken(context.Background(), model.User{ID: 1}, "cid", "users:read users:write", nil, time.Minute, nil, service.Authentication{})
+	require.NoError(t, err)
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodPost, "/api/users/me/mfa/totp"},
+		{http.MethodPost, "/api/users/me/mfa/totp/confirm"},
+		{http.MethodDelete, "/api/users/me/mfa/totp"},
+		{http.MethodPost, "/api/users/me/mfa/recovery-codes"},
+		{http.MethodPost, "/api/users/me/webauthn/registration/options"},
+		{http.MethodPost, "/api/users/me/webauthn/credentials"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusForbidden, rec.Code, tc.path)
+		require.Contains(t, rec.Body.String(), "a user login token is required", tc.path)
+	}
+}
+
+func TestRoutePasskeyRegistrationRequiresRecentAuth(t *testing.T) {
+	t.Setenv("JWT_SECRET", "s")
+	e := echo.New()
+	notRevoked := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+		return redis.NewStringResult("", redis.Nil)
+	}}
+	Setup(e, &database.FakeDB{}, notRevoked)
+
+	// 未記錄登入方式的第一方 token 視為非剛登入
+	tok, err := service.IssueAccessToken(context.Background(), model.User{ID: 1}, "", "", nil, time.Minute, nil, service.Authentication{})
+	require.NoError(t, err)
+	for _, path := range []string{"/api/users/me/webauthn/registration/options", "/api/users/me/webauthn/credentials"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnauthorized, rec.Code, path)
+		require.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `error="insufficient_user_authentication"`, path)
+	}
+}
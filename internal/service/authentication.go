@@ -33,13 +33,93 @@ type CustomClaims struct {
 	UserID   int    `json:"user_id,omitempty"`
 	ClientID string `json:"client_id,omitempty"`
 	IsAdmin  bool   `json:"is_admin,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// Confirmation 為 token 綁定的持有證明；為 nil 時為一般 bearer token
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	// Actor 為 token exchange 換得的 token 上代替使用者行事的一方
+	Actor *Actor `json:"act,omitempty"`
+	Authentication
 	jwt.RegisteredClaims
 }
 
+const (
+	// AMR 值依 RFC 8176
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRMultiFactor = "mfa"
+	AMRHardwareKey = "hwk"
+
+	// ACRSingleFactor 與 ACRMultiFactor 分別表示僅以單一因素或以多重因素驗證使用者
+	ACRSingleFactor = "aal1"
+	ACRMultiFactor  = "aal2"
+)
+
+// Authentication 為使用者登入時的驗證方式，隨登入 token 延續至經由 OAuth client 取得的 token；
+// 不經使用者登入取得的 token（如 client_credentials）則為零值
+type Authentication struct {
+	AMR []string `json:"amr,omitempty"`
+	ACR string   `json:"acr,omitempty"`
+}
+
+// PasswordAuthentication 為僅以密碼登入的驗證方式
+func PasswordAuthentication() Authentication {
+	return Authentication{AMR: []string{AMRPassword}, ACR: ACRSingleFactor}
+}
+
+// MFAAuthentication 為以密碼加上 TOTP 或 recovery code 登入的驗證方式
+func MFAAuthentication() Authentication {
+	return Authentication{AMR: []string{AMRPassword, AMROTP, AMRMultiFactor}, ACR: ACRMultiFactor}
+}
+
+// PasskeyAuthentication 為以 passkey 登入的驗證方式；authenticator 須驗證使用者，持有金鑰之外另以 PIN 或生物辨識驗證，視為多重因素
+func PasskeyAuthentication() Authentication {
+	return Authentication{AMR: []string{AMRHardwareKey, AMRMultiFactor}, ACR: ACRMultiFactor}
+}
+
+// ReauthenticationMaxAge 為註冊 passkey 等變更登入方式的操作要求的登入時效，需涵蓋 WebAuthn challenge 的效期
+const ReauthenticationMaxAge = 2 * WebAuthnChallengeTTL
+
+// AuthenticatedWithin 回傳 token 是否為使用者於 maxAge 內登入取得；只有第一方 token 於登入時發行，其 iat 即為登入時間
+func AuthenticatedWithin(claims *CustomClaims, maxAge time.Duration) bool {
+	if claims.ClientID != "" || len(claims.AMR) == 0 || claims.IssuedAt == nil {
+		return false
+	}
+	return timeNow().Sub(claims.IssuedAt.Time) <= maxAge
+}
+
+// MultiFactor 回傳是否以多重因素驗證
+func (a Authentication) MultiFactor() bool {
+	return a.ACR == ACRMultiFactor
+}
+
+// Confirmation 為 RFC 7800 的 cnf claim，記錄 access token 綁定的持有證明
+type Confirmation struct {
+	// X5tS256 為 RFC 8705 §3.1 的 client 憑證 SHA-256 指紋（base64url）
+	X5tS256 string `json:"x5t#S256,omitempty"`
+	// JKT 為 RFC 9449 §6.1 的 DPoP 公鑰 JWK thumbprint
+	JKT string `json:"jkt,omitempty"`
+}
+
+var ErrRefreshTokenNotFound = errors.New("refresh token not found or expired")
+
 type RefreshTokenData struct {
-	UserID   int    `json:"user_id"`
-	ClientID string `json:"client_id"`
-	IsAdmin  bool   `json:"is_admin,omitempty"`
+	UserID    int    `json:"user_id"`
+	ClientID  string `json:"client_id"`
+	IsAdmin   bool   `json:"is_admin,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	// FamilyID 串起同一次授權輪替出的所有 refresh token，偵測到重用時整個 family 一併撤銷
+	FamilyID string `json:"family_id,omitempty"`
+	// FamilyExpiresAt 為 family 的絕對到期時間，輪替出的 token 皆不超過此時間；0 表示不限
+	FamilyExpiresAt int64 `json:"family_exp,omitempty"`
+	// FamilyIssuedAt 為 family 首次發行的時間（Unix 毫秒），用以判斷是否早於使用者撤銷同意；
+	// 精確至毫秒，同一秒內撤銷後重新同意取得的 family 才不會被誤判
+	FamilyIssuedAt int64 `json:"family_iat_ms,omitempty"`
+	// JKT 為 refresh token 綁定的 DPoP 公鑰 thumbprint，輪替時須以同一把金鑰出示 proof
+	JKT string `json:"jkt,omitempty"`
+	// Authentication 為取得此 family 時使用者的驗證方式，輪替後換發的 access token 沿用
+	Authentication
 }
 
 func HashPassword(password string) (string, error) {
@@ -61,59 +141,131 @@ func AuthenticateUser(ctx context.Context, user model.User, password string) err
 	return nil
 }
 
-func IssueAccessToken(user model.User, ttl time.Duration) (string, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return "", fmt.Errorf("JWT_SECRET not set")
+// newTokenID 產生隨機識別碼，作為 access token 的 jti 與 refresh token 的 family
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := randRead(b); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// IssueAccessToken 為使用者發行 access token；clientID 為空表示非經由 OAuth client 取得，
+// 此類第一方 token 不受 scope 限制。audience 不為空時寫入 aud；cnf 不為 nil 時 token 綁定至對應的持有證明；
+// authn 為使用者的驗證方式，寫入 amr 與 acr
+func IssueAccessToken(ctx context.Context, user model.User, clientID, scope string, audience []string, ttl time.Duration, cnf *Confirmation, authn Authentication) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
 	}
 	now := timeNow()
 	claims := CustomClaims{
-		UserID:  user.ID,
-		IsAdmin: user.IsAdmin,
+		UserID:         user.ID,
+		ClientID:       clientID,
+		IsAdmin:        user.IsAdmin,
+		Scope:          scope,
+		Confirmation:   cnf,
+		Authentication: authn,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
 			Subject:   fmt.Sprint(user.ID),
+			Audience:  audience,
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return signClaims(ctx, claims)
 }
 
-func IssueClientAccessToken(user model.User, client model.OAuthClient, ttl time.Duration) (string, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return "", fmt.Errorf("JWT_SECRET not set")
-	}
+func IssueClientAccessToken(ctx context.Context, user model.User, client model.OAuthClient, scope string, audience []string, ttl time.Duration, cnf *Confirmation) (string, error) {
 	if user.ID != client.UserID {
 		return "", fmt.Errorf("user %d is not the owner of client %s", user.ID, client.ClientID)
 	}
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
 	now := timeNow()
 	claims := CustomClaims{
-		UserID:   user.ID,
-		ClientID: client.ClientID,
-		IsAdmin:  user.IsAdmin,
+		UserID:       user.ID,
+		ClientID:     client.ClientID,
+		IsAdmin:      user.IsAdmin,
+		Scope:        scope,
+		Confirmation: cnf,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
 			Subject:   fmt.Sprint(client.ClientID),
+			Audience:  audience,
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return signClaims(ctx, claims)
 }
 
-func VerifyAccessToken(tokenString string) (*CustomClaims, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return nil, fmt.Errorf("JWT_SECRET not set")
-	}
-	token, err := parseWithClaims(tokenString, &CustomClaims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+// tokenSigner 為簽發當下使用的演算法與金鑰
+type tokenSigner struct {
+	method jwt.SigningMethod
+	kid    string
+	key    interface{}
+}
+
+// currentSigner 取得金鑰庫的 active 金鑰；未設定金鑰庫時以 JWT_SECRET 簽發 HS256
+func currentSigner(ctx context.Context) (*tokenSigner, error) {
+	if keyStore == nil {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("JWT_SECRET not set")
 		}
-		return []byte(secret), nil
-	})
+		return &tokenSigner{method: jwt.SigningMethodHS256, key: []byte(secret)}, nil
+	}
+	key, err := keyStore.signingKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenSigner{method: key.method, kid: key.kid, key: key.signer}, nil
+}
+
+func (s *tokenSigner) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.method, claims)
+	if s.kid != "" {
+		token.Header["kid"] = s.kid
+	}
+	return token.SignedString(s.key)
+}
+
+// signClaims 以目前的簽章金鑰簽發 JWT，使用金鑰庫時帶上 kid
+func signClaims(ctx context.Context, claims jwt.Claims) (string, error) {
+	signer, err := currentSigner(ctx)
+	if err != nil {
+		return "", err
+	}
+	return signer.sign(claims)
+}
+
+// VerifyAccessToken 驗證簽章與效期，並確認 token 未被撤銷
+func VerifyAccessToken(ctx context.Context, cache cache.Cache, tokenString string) (*CustomClaims, error) {
+	claims, err := parseAccessToken(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := IsAccessTokenRevoked(ctx, cache, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+	return claims, nil
+}
+
+// parseAccessToken 僅驗證簽章與效期，不檢查撤銷狀態
+func parseAccessToken(ctx context.Context, tokenString string) (*CustomClaims, error) {
+	keyFunc, err := verificationKeyFunc(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := parseWithClaims(tokenString, &CustomClaims{}, keyFunc)
 	if err != nil {
 		return nil, err
 	}
@@ -124,13 +276,70 @@ func VerifyAccessToken(tokenString string) (*CustomClaims, error) {
 	return claims, nil
 }
 
-func IssueRefreshToken(ctx context.Context, cache cache.Cache, userID int, clientID string, isAdmin bool, ttl time.Duration) (string, error) {
+// verificationKeyFunc 依 kid 從金鑰庫取得驗證金鑰，並要求 token 的 alg 與金鑰一致；
+// 未設定金鑰庫時僅接受以 JWT_SECRET 簽發的 HS256
+func verificationKeyFunc(ctx context.Context) (jwt.Keyfunc, error) {
+	if keyStore == nil {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("JWT_SECRET not set")
+		}
+		return func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			}
+			return []byte(secret), nil
+		}, nil
+	}
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := keyStore.verificationKey(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return key.public, nil
+	}, nil
+}
+
+// IssueRefreshToken 發行新 family 的 refresh token，效期依 lifetimes 決定；jkt 不為空時綁定至該 DPoP 金鑰，
+// authn 為取得授權時使用者的驗證方式
+func IssueRefreshToken(ctx context.Context, cache cache.Cache, userID int, clientID string, isAdmin bool, scope string, lifetimes TokenLifetimes, jkt string, authn Authentication) (string, error) {
+	familyID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := timeNow()
+	var familyExpiresAt int64
+	if lifetimes.RefreshTokenAbsoluteTTL > 0 {
+		familyExpiresAt = now.Add(lifetimes.RefreshTokenAbsoluteTTL).Unix()
+	}
+	ttl := lifetimes.refreshTokenTTL(now, familyExpiresAt)
+	data := RefreshTokenData{
+		UserID:          userID,
+		ClientID:        clientID,
+		IsAdmin:         isAdmin,
+		Scope:           scope,
+		IssuedAt:        now.Unix(),
+		ExpiresAt:       now.Add(ttl).Unix(),
+		FamilyID:        familyID,
+		JKT:             jkt,
+		FamilyExpiresAt: familyExpiresAt,
+		FamilyIssuedAt:  now.UnixMilli(),
+		Authentication:  authn,
+	}
+	return storeRefreshToken(ctx, cache, data, ttl)
+}
+
+// storeRefreshToken 產生新的 refresh token 存入快取，並將其記為所屬 family 目前唯一有效的 token
+func storeRefreshToken(ctx context.Context, cache cache.Cache, data RefreshTokenData, ttl time.Duration) (string, error) {
 	b := make([]byte, 32)
 	if _, err := randRead(b); err != nil {
 		return "", fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 	token := base64.RawURLEncoding.EncodeToString(b)
-	data := RefreshTokenData{UserID: userID, ClientID: clientID, IsAdmin: isAdmin}
 	bytesData, err := jsonMarshal(data)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal refresh token data: %w", err)
@@ -139,6 +348,10 @@ func IssueRefreshToken(ctx context.Context, cache cache.Cache, userID int, clien
 	if err := cache.Set(ctx, key, bytesData, ttl).Err(); err != nil {
 		return "", fmt.Errorf("failed to store refresh token: %w", err)
 	}
+	familyKey := fmt.Sprintf("refresh_token_family:%s", data.FamilyID)
+	if err := cache.Set(ctx, familyKey, token, ttl).Err(); err != nil {
+		return "", fmt.Errorf("failed to store refresh token family: %w", err)
+	}
 	return token, nil
 }
 
@@ -147,7 +360,7 @@ func ValidateRefreshToken(ctx context.Context, cache cache.Cache, token string)
 	val, err := cache.Get(ctx, key).Result()
 	if err != nil {
 		if err == redis.Nil {
-			return nil, fmt.Errorf("refresh token not found or expired")
+			return nil, ErrRefreshTokenNotFound
 		}
 		return nil, fmt.Errorf("failed to retrieve refresh token: %w", err)
 	}
@@ -155,5 +368,21 @@ func ValidateRefreshToken(ctx context.Context, cache cache.Cache, token string)
 	if err := jsonUnmarshal([]byte(val), &data); err != nil {
 		return nil, fmt.Errorf("failed to parse refresh token data: %w", err)
 	}
+	// 使用者撤銷同意或撤銷所有工作階段前發行的 token 視為不存在並立即刪除
+	revoked, err := grantRevoked(ctx, cache, &data)
+	if err != nil {
+		return nil, err
+	}
+	if !revoked {
+		if revoked, err = userSessionsRevoked(ctx, cache, data.UserID, time.UnixMilli(familyIssuedAt(&data))); err != nil {
+			return nil, err
+		}
+	}
+	if revoked {
+		if err := RevokeRefreshToken(ctx, cache, token); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenNotFound
+	}
 	return &data, nil
 }
@@ -2,11 +2,16 @@ package service
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,6 +32,22 @@ func restoreGlobals() {
 	jsonUnmarshal = json.Unmarshal
 	timeNow = time.Now
 	parseWithClaims = jwt.ParseWithClaims
+	rsaGenerateKey = rsa.GenerateKey
+	ecdsaGenerateKey = ecdsa.GenerateKey
+	ed25519GenerateKey = ed25519.GenerateKey
+	x509MarshalPKCS8 = x509.MarshalPKCS8PrivateKey
+	x509MarshalPKIXPublic = x509.MarshalPKIXPublicKey
+	keyStore = nil
+	clientCAs = nil
+	trustedIssuers = nil
+	mailSender = nil
+	emailVerificationURL = ""
+	passwordResetURL = ""
+	mfaIssuer = defaultMFAIssuer
+	totpAEAD = nil
+	adminMFARequired = false
+	webAuthnRP = DefaultWebAuthnRelyingParty
+	serverTokenLifetimes = DefaultTokenLifetimes
 }
 
 func TestHashPassword(t *testing.T) {
@@ -52,20 +73,44 @@ func TestAuthenticateUser(t *testing.T) {
 	require.Error(t, AuthenticateUser(context.Background(), u, "bad"))
 }
 
+func TestAuthenticatedWithin(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	claims := func(clientID string, authn Authentication, iat time.Time) *CustomClaims {
+		return &CustomClaims{ClientID: clientID, Authentication: authn, RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(iat)}}
+	}
+
+	require.True(t, AuthenticatedWithin(claims("", PasswordAuthentication(), now.Add(-5*time.Minute)), 5*time.Minute))
+	require.False(t, AuthenticatedWithin(claims("", PasswordAuthentication(), now.Add(-6*time.Minute)), 5*time.Minute))
+	// OAuth client 取得或未記錄登入方式的 token 不代表使用者剛登入
+	require.False(t, AuthenticatedWithin(claims("cid", PasskeyAuthentication(), now), 5*time.Minute))
+	require.False(t, AuthenticatedWithin(claims("", Authentication{}, now), 5*time.Minute))
+	require.False(t, AuthenticatedWithin(&CustomClaims{Authentication: PasswordAuthentication()}, 5*time.Minute))
+	require.GreaterOrEqual(t, ReauthenticationMaxAge, WebAuthnChallengeTTL)
+}
+
 func TestIssueAccessToken(t *testing.T) {
 	t.Cleanup(restoreGlobals)
 	os.Unsetenv("JWT_SECRET")
-	_, err := IssueAccessToken(model.User{}, time.Minute)
+	_, err := IssueAccessToken(context.Background(), model.User{}, "", "", nil, time.Minute, nil, Authentication{})
 	require.Error(t, err)
 
 	os.Setenv("JWT_SECRET", "s")
-	tok, err := IssueAccessToken(model.User{ID: 5, IsAdmin: true}, time.Minute)
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	_, err = IssueAccessToken(context.Background(), model.User{ID: 5}, "", "", nil, time.Minute, nil, Authentication{})
+	require.Error(t, err)
+
+	randRead = rand.Read
+	tok, err := IssueAccessToken(context.Background(), model.User{ID: 5, IsAdmin: true}, "cli", "", nil, time.Minute, nil, Authentication{})
 	require.NoError(t, err)
 	claims := &CustomClaims{}
 	_, err = jwt.ParseWithClaims(tok, claims, func(*jwt.Token) (any, error) { return []byte("s"), nil })
 	require.NoError(t, err)
 	require.Equal(t, 5, claims.UserID)
+	require.Equal(t, "cli", claims.ClientID)
 	require.True(t, claims.IsAdmin)
+	require.NotEmpty(t, claims.ID)
 }
 
 func TestIssueClientAccessToken(t *testing.T) {
@@ -74,46 +119,73 @@ func TestIssueClientAccessToken(t *testing.T) {
 	client := model.OAuthClient{ClientID: "c", UserID: 1}
 
 	os.Unsetenv("JWT_SECRET")
-	_, err := IssueClientAccessToken(user, client, time.Minute)
+	_, err := IssueClientAccessToken(context.Background(), user, client, "", nil, time.Minute, nil)
 	require.Error(t, err)
 
 	os.Setenv("JWT_SECRET", "s")
-	_, err = IssueClientAccessToken(model.User{ID: 2}, client, time.Minute)
+	_, err = IssueClientAccessToken(context.Background(), model.User{ID: 2}, client, "", nil, time.Minute, nil)
+	require.Error(t, err)
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	_, err = IssueClientAccessToken(context.Background(), user, client, "", nil, time.Minute, nil)
 	require.Error(t, err)
+	randRead = rand.Read
 
-	tok, err := IssueClientAccessToken(user, client, time.Hour)
+	tok, err := IssueClientAccessToken(context.Background(), user, client, "", nil, time.Hour, nil)
 	require.NoError(t, err)
 	c := &CustomClaims{}
 	_, err = jwt.ParseWithClaims(tok, c, func(*jwt.Token) (any, error) { return []byte("s"), nil })
 	require.NoError(t, err)
 	require.Equal(t, "c", c.ClientID)
+	require.NotEmpty(t, c.ID)
+	require.Nil(t, c.Confirmation)
+
+	// 憑證綁定的 token 帶 cnf.x5t#S256
+	tok, err = IssueClientAccessToken(context.Background(), user, client, "", nil, time.Hour, &Confirmation{X5tS256: "thumb"})
+	require.NoError(t, err)
+	c = &CustomClaims{}
+	_, err = jwt.ParseWithClaims(tok, c, func(*jwt.Token) (any, error) { return []byte("s"), nil })
+	require.NoError(t, err)
+	require.Equal(t, "thumb", c.Confirmation.X5tS256)
 }
 
 func TestVerifyAccessToken(t *testing.T) {
 	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	c := &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+		return redis.NewStringResult("", redis.Nil)
+	}}
 	os.Unsetenv("JWT_SECRET")
-	_, err := VerifyAccessToken("abc")
+	_, err := VerifyAccessToken(ctx, c, "abc")
 	require.Error(t, err)
 
 	os.Setenv("JWT_SECRET", "s")
-	_, err = VerifyAccessToken("invalid")
+	_, err = VerifyAccessToken(ctx, c, "invalid")
 	require.Error(t, err)
 
 	tokNone, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"foo": "bar"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
-	_, err = VerifyAccessToken(tokNone)
+	_, err = VerifyAccessToken(ctx, c, tokNone)
 	require.Error(t, err)
 
 	parseWithClaims = func(s string, c jwt.Claims, k jwt.Keyfunc, opts ...jwt.ParserOption) (*jwt.Token, error) {
 		return &jwt.Token{Claims: jwt.MapClaims{}, Valid: false}, nil
 	}
-	_, err = VerifyAccessToken("whatever")
+	_, err = VerifyAccessToken(ctx, c, "whatever")
 	require.Error(t, err)
 
 	parseWithClaims = jwt.ParseWithClaims
-	tok, _ := IssueAccessToken(model.User{ID: 3}, time.Minute)
-	claims, err := VerifyAccessToken(tok)
+	tok, _ := IssueAccessToken(ctx, model.User{ID: 3}, "", "", nil, time.Minute, nil, Authentication{})
+	claims, err := VerifyAccessToken(ctx, c, tok)
 	require.NoError(t, err)
 	require.Equal(t, 3, claims.UserID)
+
+	c.GetFn = func(context.Context, string) *redis.StringCmd { return redis.NewStringResult("", errors.New("get")) }
+	_, err = VerifyAccessToken(ctx, c, tok)
+	require.Error(t, err)
+
+	c.GetFn = func(context.Context, string) *redis.StringCmd { return redis.NewStringResult("1", nil) }
+	_, err = VerifyAccessToken(ctx, c, tok)
+	require.EqualError(t, err, "token has been revoked")
 }
 
 func TestIssueRefreshToken(t *testing.T) {
@@ -122,38 +194,58 @@ func TestIssueRefreshToken(t *testing.T) {
 	c := &cache.FakeCache{}
 
 	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
-	_, err := IssueRefreshToken(ctx, c, 1, "cli", false, time.Second)
+	_, err := IssueRefreshToken(ctx, c, 1, "cli", false, "", TokenLifetimes{RefreshTokenTTL: time.Second}, "", Authentication{})
 	require.Error(t, err)
 
 	randRead = rand.Read
 	jsonMarshal = func(any) ([]byte, error) { return nil, errors.New("json") }
-	_, err = IssueRefreshToken(ctx, c, 1, "cli", false, time.Second)
+	_, err = IssueRefreshToken(ctx, c, 1, "cli", false, "", TokenLifetimes{RefreshTokenTTL: time.Second}, "", Authentication{})
 	require.Error(t, err)
 
 	jsonMarshal = json.Marshal
 	c.SetFn = func(context.Context, string, any, time.Duration) *redis.StatusCmd {
 		return redis.NewStatusResult("", errors.New("set"))
 	}
-	_, err = IssueRefreshToken(ctx, c, 1, "cli", false, time.Second)
+	_, err = IssueRefreshToken(ctx, c, 1, "cli", false, "", TokenLifetimes{RefreshTokenTTL: time.Second}, "", Authentication{})
 	require.Error(t, err)
 
-	var storedKey string
-	var storedVal []byte
-	c.SetFn = func(_ context.Context, key string, val any, _ time.Duration) *redis.StatusCmd {
-		storedKey = key
-		storedVal = val.([]byte)
+	// family 指標寫入失敗
+	c.SetFn = func(_ context.Context, key string, _ any, _ time.Duration) *redis.StatusCmd {
+		if strings.HasPrefix(key, "refresh_token_family:") {
+			return redis.NewStatusResult("", errors.New("set"))
+		}
 		return redis.NewStatusResult("OK", nil)
 	}
-	tok, err := IssueRefreshToken(ctx, c, 1, "cli", true, time.Second)
+	_, err = IssueRefreshToken(ctx, c, 1, "cli", false, "", TokenLifetimes{RefreshTokenTTL: time.Second}, "", Authentication{})
+	require.Error(t, err)
+
+	stored := cache.NewMemoryCache(nil)
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	tok, err := IssueRefreshToken(ctx, stored, 1, "cli", true, "", TokenLifetimes{RefreshTokenTTL: time.Second}, "", Authentication{})
 	require.NoError(t, err)
-	require.Contains(t, storedKey, tok)
 	decoded, _ := base64.RawURLEncoding.DecodeString(tok)
 	require.Len(t, decoded, 32)
 	var d RefreshTokenData
-	require.NoError(t, json.Unmarshal(storedVal, &d))
+	require.NoError(t, json.Unmarshal([]byte(stored.Data["refresh_token:"+tok]), &d))
 	require.Equal(t, 1, d.UserID)
 	require.Equal(t, "cli", d.ClientID)
 	require.True(t, d.IsAdmin)
+	require.Equal(t, now.Unix(), d.IssuedAt)
+	require.Equal(t, now.Add(time.Second).Unix(), d.ExpiresAt)
+	require.NotEmpty(t, d.FamilyID)
+	require.Equal(t, tok, stored.Data["refresh_token_family:"+d.FamilyID])
+
+	// family id 成功但 token 產生失敗
+	calls := 0
+	randRead = func(b []byte) (int, error) {
+		if calls++; calls > 1 {
+			return 0, errors.New("rand")
+		}
+		return rand.Read(b)
+	}
+	_, err = IssueRefreshToken(ctx, stored, 1, "cli", false, "", TokenLifetimes{RefreshTokenTTL: time.Second}, "", Authentication{})
+	require.Error(t, err)
 }
 
 func TestValidateRefreshToken(t *testing.T) {
@@ -165,7 +257,7 @@ func TestValidateRefreshToken(t *testing.T) {
 		return redis.NewStringResult("", redis.Nil)
 	}
 	_, err := ValidateRefreshToken(ctx, c, "tok")
-	require.Error(t, err)
+	require.ErrorIs(t, err, ErrRefreshTokenNotFound)
 
 	c.GetFn = func(context.Context, string) *redis.StringCmd {
 		return redis.NewStringResult("", errors.New("get"))
@@ -181,13 +273,74 @@ func TestValidateRefreshToken(t *testing.T) {
 	require.Error(t, err)
 
 	jsonUnmarshal = json.Unmarshal
-	dataBytes, _ := json.Marshal(RefreshTokenData{UserID: 2, ClientID: "c", IsAdmin: true})
-	c.GetFn = func(context.Context, string) *redis.StringCmd {
+	dataBytes, _ := json.Marshal(RefreshTokenData{UserID: 2, ClientID: "c", IsAdmin: true, IssuedAt: 100, FamilyIssuedAt: 50000})
+	revokedAt := redis.NewStringResult("", redis.Nil)
+	sessionsRevokedAt := redis.NewStringResult("", redis.Nil)
+	var deleted []string
+	c.GetFn = func(_ context.Context, key string) *redis.StringCmd {
+		if key == "revoked_grant:2:c" {
+			return revokedAt
+		}
+		if key == "revoked_user_sessions:2" {
+			return sessionsRevokedAt
+		}
 		return redis.NewStringResult(string(dataBytes), nil)
 	}
+	c.DelFn = func(_ context.Context, keys ...string) *redis.IntCmd {
+		deleted = append(deleted, keys...)
+		return redis.NewIntResult(1, nil)
+	}
 	data, err := ValidateRefreshToken(ctx, c, "tok")
 	require.NoError(t, err)
 	require.Equal(t, 2, data.UserID)
 	require.Equal(t, "c", data.ClientID)
 	require.True(t, data.IsAdmin)
+
+	// family 發行於撤銷同意之後仍有效
+	revokedAt = redis.NewStringResult("49999", nil)
+	_, err = ValidateRefreshToken(ctx, c, "tok")
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+
+	// 撤銷同意前發行的 family 即使已輪替仍失效，並刪除該 token
+	revokedAt = redis.NewStringResult("50000", nil)
+	_, err = ValidateRefreshToken(ctx, c, "tok")
+	require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	require.Equal(t, []string{"refresh_token:tok"}, deleted)
+
+	c.DelFn = func(context.Context, ...string) *redis.IntCmd { return redis.NewIntResult(0, errors.New("del")) }
+	_, err = ValidateRefreshToken(ctx, c, "tok")
+	require.Error(t, err)
+
+	revokedAt = redis.NewStringResult("bad", nil)
+	_, err = ValidateRefreshToken(ctx, c, "tok")
+	require.ErrorContains(t, err, "failed to parse grant revocation")
+
+	revokedAt = redis.NewStringResult("", errors.New("get"))
+	_, err = ValidateRefreshToken(ctx, c, "tok")
+	require.ErrorContains(t, err, "failed to retrieve grant revocation")
+
+	// 使用者撤銷所有工作階段前發行的 family 失效
+	revokedAt = redis.NewStringResult("", redis.Nil)
+	c.DelFn = func(_ context.Context, keys ...string) *redis.IntCmd {
+		deleted = append(deleted, keys...)
+		return redis.NewIntResult(1, nil)
+	}
+	sessionsRevokedAt = redis.NewStringResult("49999", nil)
+	_, err = ValidateRefreshToken(ctx, c, "tok")
+	require.NoError(t, err)
+
+	deleted = nil
+	sessionsRevokedAt = redis.NewStringResult("50000", nil)
+	_, err = ValidateRefreshToken(ctx, c, "tok")
+	require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	require.Equal(t, []string{"refresh_token:tok"}, deleted)
+
+	sessionsRevokedAt = redis.NewStringResult("bad", nil)
+	_, err = ValidateRefreshToken(ctx, c, "tok")
+	require.ErrorContains(t, err, "failed to parse session revocation")
+
+	sessionsRevokedAt = redis.NewStringResult("", errors.New("get"))
+	_, err = ValidateRefreshToken(ctx, c, "tok")
+	require.ErrorContains(t, err, "failed to retrieve session revocation")
 }
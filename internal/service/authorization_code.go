@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	PKCEMethodPlain = "plain"
+	PKCEMethodS256  = "S256"
+)
+
+// codeVerifierPattern 依 RFC 7636 §4.1：43~128 個 unreserved 字元
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+type AuthorizationCodeData struct {
+	UserID              int    `json:"user_id"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri,omitempty"`
+	Scope               string `json:"scope,omitempty"`
+	CodeChallenge       string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+	AuthTime            int64  `json:"auth_time,omitempty"`
+	Authentication
+}
+
+// IssueAuthorizationCode 產生一次性授權碼並存入快取
+func IssueAuthorizationCode(ctx context.Context, cache cache.Cache, data AuthorizationCodeData, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := randRead(b); err != nil {
+		return "", fmt.Errorf("failed to generate authorization code: %w", err)
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+	bytesData, err := jsonMarshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal authorization code data: %w", err)
+	}
+	key := fmt.Sprintf("authorization_code:%s", code)
+	if err := cache.Set(ctx, key, bytesData, ttl).Err(); err != nil {
+		return "", fmt.Errorf("failed to store authorization code: %w", err)
+	}
+	return code, nil
+}
+
+// ConsumeAuthorizationCode 讀取並刪除授權碼，確保同一授權碼只能兌換一次
+func ConsumeAuthorizationCode(ctx context.Context, cache cache.Cache, code string) (*AuthorizationCodeData, error) {
+	key := fmt.Sprintf("authorization_code:%s", code)
+	val, err := cache.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("authorization code not found or expired")
+		}
+		return nil, fmt.Errorf("failed to retrieve authorization code: %w", err)
+	}
+	// 僅有成功刪除的呼叫者可以使用此授權碼，避免併發重複兌換
+	deleted, err := cache.Del(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete authorization code: %w", err)
+	}
+	if deleted == 0 {
+		return nil, fmt.Errorf("authorization code already used")
+	}
+	var data AuthorizationCodeData
+	if err := jsonUnmarshal([]byte(val), &data); err != nil {
+		return nil, fmt.Errorf("failed to parse authorization code data: %w", err)
+	}
+	return &data, nil
+}
+
+// ValidateCodeChallengeMethod 檢查 code_challenge_method 是否受支援，空值視為 plain
+func ValidateCodeChallengeMethod(method string) error {
+	switch method {
+	case "", PKCEMethodPlain, PKCEMethodS256:
+		return nil
+	}
+	return fmt.Errorf("unsupported code_challenge_method: %s", method)
+}
+
+// VerifyPKCE 依 RFC 7636 比對 code_verifier 與授權時保存的 code_challenge
+func VerifyPKCE(challenge, method, verifier string) error {
+	if challenge == "" {
+		if verifier != "" {
+			return errors.New("code_verifier supplied without code_challenge")
+		}
+		return nil
+	}
+	if !codeVerifierPattern.MatchString(verifier) {
+		return errors.New("invalid code_verifier")
+	}
+	var computed string
+	switch method {
+	case "", PKCEMethodPlain:
+		computed = verifier
+	case PKCEMethodS256:
+		sum := sha256.Sum256([]byte(verifier))
+		computed = base64.RawURLEncoding.EncodeToString(sum[:])
+	default:
+		return fmt.Errorf("unsupported code_challenge_method: %s", method)
+	}
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) != 1 {
+		return errors.New("code_verifier does not match code_challenge")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueAuthorizationCode(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	c := &cache.FakeCache{}
+	data := AuthorizationCodeData{UserID: 1, ClientID: "cli", RedirectURI: "https://app/cb"}
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	_, err := IssueAuthorizationCode(ctx, c, data, time.Minute)
+	require.Error(t, err)
+
+	randRead = rand.Read
+	jsonMarshal = func(any) ([]byte, error) { return nil, errors.New("json") }
+	_, err = IssueAuthorizationCode(ctx, c, data, time.Minute)
+	require.Error(t, err)
+
+	jsonMarshal = json.Marshal
+	c.SetFn = func(context.Context, string, any, time.Duration) *redis.StatusCmd {
+		return redis.NewStatusResult("", errors.New("set"))
+	}
+	_, err = IssueAuthorizationCode(ctx, c, data, time.Minute)
+	require.Error(t, err)
+
+	var storedKey string
+	var storedVal []byte
+	var storedTTL time.Duration
+	c.SetFn = func(_ context.Context, key string, val any, ttl time.Duration) *redis.StatusCmd {
+		storedKey, storedVal, storedTTL = key, val.([]byte), ttl
+		return redis.NewStatusResult("OK", nil)
+	}
+	code, err := IssueAuthorizationCode(ctx, c, data, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "authorization_code:"+code, storedKey)
+	require.Equal(t, time.Minute, storedTTL)
+	var d AuthorizationCodeData
+	require.NoError(t, json.Unmarshal(storedVal, &d))
+	require.Equal(t, data, d)
+}
+
+func TestConsumeAuthorizationCode(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	c := &cache.FakeCache{}
+	dataBytes, _ := json.Marshal(AuthorizationCodeData{UserID: 2, ClientID: "c"})
+
+	c.GetFn = func(context.Context, string) *redis.StringCmd { return redis.NewStringResult("", redis.Nil) }
+	_, err := ConsumeAuthorizationCode(ctx, c, "code")
+	require.Error(t, err)
+
+	c.GetFn = func(context.Context, string) *redis.StringCmd { return redis.NewStringResult("", errors.New("get")) }
+	_, err = ConsumeAuthorizationCode(ctx, c, "code")
+	require.Error(t, err)
+
+	c.GetFn = func(context.Context, string) *redis.StringCmd { return redis.NewStringResult(string(dataBytes), nil) }
+	c.DelFn = func(context.Context, ...string) *redis.IntCmd { return redis.NewIntResult(0, errors.New("del")) }
+	_, err = ConsumeAuthorizationCode(ctx, c, "code")
+	require.Error(t, err)
+
+	c.DelFn = func(context.Context, ...string) *redis.IntCmd { return redis.NewIntResult(0, nil) }
+	_, err = ConsumeAuthorizationCode(ctx, c, "code")
+	require.EqualError(t, err, "authorization code already used")
+
+	c.DelFn = func(context.Context, ...string) *redis.IntCmd { return redis.NewIntResult(1, nil) }
+	jsonUnmarshal = func([]byte, any) error { return errors.New("unmarshal") }
+	_, err = ConsumeAuthorizationCode(ctx, c, "code")
+	require.Error(t, err)
+
+	jsonUnmarshal = json.Unmarshal
+	var deletedKeys []string
+	c.DelFn = func(_ context.Context, keys ...string) *redis.IntCmd {
+		deletedKeys = keys
+		return redis.NewIntResult(1, nil)
+	}
+	data, err := ConsumeAuthorizationCode(ctx, c, "code")
+	require.NoError(t, err)
+	require.Equal(t, 2, data.UserID)
+	require.Equal(t, []string{"authorization_code:code"}, deletedKeys)
+}
+
+func TestValidateCodeChallengeMethod(t *testing.T) {
+	require.NoError(t, ValidateCodeChallengeMethod(""))
+	require.NoError(t, ValidateCodeChallengeMethod(PKCEMethodPlain))
+	require.NoError(t, ValidateCodeChallengeMethod(PKCEMethodS256))
+	require.Error(t, ValidateCodeChallengeMethod("S512"))
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := strings.Repeat("a", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	s256 := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	require.NoError(t, VerifyPKCE("", "", ""))
+	require.Error(t, VerifyPKCE("", "", verifier))
+	require.Error(t, VerifyPKCE(s256, PKCEMethodS256, "short"))
+	require.NoError(t, VerifyPKCE(s256, PKCEMethodS256, verifier))
+	require.Error(t, VerifyPKCE(s256, PKCEMethodS256, strings.Repeat("b", 43)))
+	require.NoError(t, VerifyPKCE(verifier, PKCEMethodPlain, verifier))
+	require.NoError(t, VerifyPKCE(verifier, "", verifier))
+	require.Error(t, VerifyPKCE(verifier, "S512", verifier))
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// cborMaxDepth 限制巢狀陣列與 map 的深度，避免惡意輸入耗盡堆疊
+const cborMaxDepth = 16
+
+var errInvalidCBOR = errors.New("invalid cbor")
+
+// decodeCBOR 解碼 RFC 8949 的單一資料項目並回傳其後剩餘的位元組，僅支援 WebAuthn 使用的定長型別：
+// 整數解為 int64、byte string 解為 []byte、text string 解為 string、陣列解為 []any、
+// map 解為 map[any]any（鍵須為整數或字串），true/false/null 解為 bool 與 nil
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (any, []byte, error) {
+	if depth > cborMaxDepth || len(b) == 0 {
+		return nil, nil, errInvalidCBOR
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22, 23:
+			return nil, b, nil
+		}
+		return nil, nil, errInvalidCBOR
+	}
+	n, b, err := decodeCBORArgument(info, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return int64(n), b, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return -1 - int64(n), b, nil
+	case 2, 3:
+		if n > uint64(len(b)) {
+			return nil, nil, errInvalidCBOR
+		}
+		if major == 2 {
+			return append([]byte(nil), b[:n]...), b[n:], nil
+		}
+		return string(b[:n]), b[n:], nil
+	case 4:
+		// 每個項目至少一個位元組，長度超過剩餘資料必為無效
+		if n > uint64(len(b)) {
+			return nil, nil, errInvalidCBOR
+		}
+		items := make([]any, 0, n)
+		for range n {
+			var item any
+			if item, b, err = decodeCBORItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, b, nil
+	case 5:
+		if n > uint64(len(b))/2 {
+			return nil, nil, errInvalidCBOR
+		}
+		m := make(map[any]any, n)
+		for range n {
+			var key, val any
+			if key, b, err = decodeCBORItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errInvalidCBOR
+			}
+			if _, dup := m[key]; dup {
+				return nil, nil, errInvalidCBOR
+			}
+			if val, b, err = decodeCBORItem(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[key] = val
+		}
+		return m, b, nil
+	}
+	// 不支援 tag 與浮點數
+	return nil, nil, errInvalidCBOR
+}
+
+// decodeCBORArgument 讀取項目的長度或數值；不支援不定長度
+func decodeCBORArgument(info byte, b []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24 && len(b) >= 1:
+		return uint64(b[0]), b[1:], nil
+	case info == 25 && len(b) >= 2:
+		return uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case info == 26 && len(b) >= 4:
+		return uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	case info == 27 && len(b) >= 8:
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	}
+	return 0, nil, errInvalidCBOR
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// encodeCBOR 以 RFC 8949 編碼測試資料，供軟體 authenticator 產生 attestation object 與 COSE 公鑰
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		case n <= 0xffffffff:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+	switch x := v.(type) {
+	case int:
+		return encodeCBOR(int64(x))
+	case int64:
+		if x < 0 {
+			return head(1, uint64(-1-x))
+		}
+		return head(0, uint64(x))
+	case []byte:
+		return append(head(2, uint64(len(x))), x...)
+	case string:
+		return append(head(3, uint64(len(x))), x...)
+	case []any:
+		b := head(4, uint64(len(x)))
+		for _, item := range x {
+			b = append(b, encodeCBOR(item)...)
+		}
+		return b
+	case map[any]any:
+		b := head(5, uint64(len(x)))
+		for k, val := range x {
+			b = append(b, encodeCBOR(k)...)
+			b = append(b, encodeCBOR(val)...)
+		}
+		return b
+	case bool:
+		if x {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	}
+	panic(fmt.Sprintf("encodeCBOR: unsupported type %T", v))
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	value := map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": make([]byte, 300),
+		int64(-1):  int64(1),
+		int64(3):   int64(-257),
+		"list":     []any{true, false, nil, int64(70000), int64(1) << 40},
+	}
+	encoded := encodeCBOR(value)
+	got, rest, err := decodeCBOR(append(encoded, 0x01))
+	require.NoError(t, err)
+	require.Equal(t, value, got)
+	require.Equal(t, []byte{0x01}, rest)
+
+	// RFC 8949 附錄 A 的範例
+	for hexValue, want := range map[string]any{
+		"\x00":                 int64(0),
+		"\x17":                 int64(23),
+		"\x18\x64":             int64(100),
+		"\x20":                 int64(-1),
+		"\x39\x01\x00":         int64(-257),
+		"\x43\x01\x02\x03":     []byte{1, 2, 3},
+		"\x64\x49\x45\x54\x46": "IETF",
+	} {
+		got, rest, err := decodeCBOR([]byte(hexValue))
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+		require.Empty(t, rest)
+	}
+
+	deep := []byte{}
+	for range cborMaxDepth + 2 {
+		deep = append(deep, 0x81)
+	}
+	for name, b := range map[string][]byte{
+		"empty":                {},
+		"truncated bytes":      {0x43, 0x01},
+		"truncated argument":   {0x19, 0x01},
+		"indefinite length":    {0x5f, 0x41, 0x00, 0xff},
+		"float":                {0xf9, 0x3c, 0x00},
+		"tag":                  {0xc0, 0x00},
+		"array too long":       {0x85, 0x00},
+		"byte string map key":  {0xa1, 0x41, 0x00, 0x00},
+		"duplicate map key":    {0xa2, 0x01, 0x00, 0x01, 0x00},
+		"uint64 out of range":  {0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"nint64 out of range":  {0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"nesting too deep":     append(deep, 0x00),
+		"map value truncated":  {0xa1, 0x01},
+		"array item truncated": {0x82, 0x00},
+	} {
+		_, _, err := decodeCBOR(b)
+		require.ErrorIs(t, err, errInvalidCBOR, name)
+	}
+}
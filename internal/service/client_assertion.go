@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClientAssertionTypeJWTBearer 為 RFC 7523 §2.2 的 client_assertion_type
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+const (
+	// clientAssertionMaxLifetime 限制 assertion 的效期，也是 jti 保留在快取中的上限
+	clientAssertionMaxLifetime = time.Hour
+	// minClientRSAKeyBits 為 client 登記的 RSA 公鑰最小長度
+	minClientRSAKeyBits = 2048
+)
+
+var (
+	ErrInvalidClientAssertion = errors.New("invalid client assertion")
+
+	// private_key_jwt 與 client_secret_jwt 可使用的簽章演算法
+	clientAssertionKeyAlgs    = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+	clientAssertionSecretAlgs = []string{"HS256", "HS384", "HS512"}
+
+	jwkCurves = map[string]elliptic.Curve{
+		"P-256": elliptic.P256(),
+		"P-384": elliptic.P384(),
+		"P-521": elliptic.P521(),
+	}
+)
+
+// JSONWebKeySet 為 RFC 7517 §5 的 JWK Set
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// clientKey 為 client 登記的驗證金鑰；alg 為空表示不限定演算法
+type clientKey struct {
+	kid    string
+	alg    string
+	public crypto.PublicKey
+}
+
+// ClientAssertionAlgorithms 回傳 client assertion 可使用的簽章演算法
+func ClientAssertionAlgorithms() []string {
+	return slices.Concat(clientAssertionKeyAlgs, clientAssertionSecretAlgs)
+}
+
+// ClientAssertionIssuer 在驗證簽章前取出 assertion 的 iss，用於請求未帶 client_id 時找出 client
+func ClientAssertionIssuer(assertion string) (string, error) {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, &claims); err != nil || claims.Issuer == "" {
+		return "", ErrInvalidClientAssertion
+	}
+	return claims.Issuer, nil
+}
+
+// VerifyClientAssertion 依 RFC 7523 §3 驗證 client assertion：iss 與 sub 皆須為 client_id、
+// aud 須包含 audiences 之一、必須帶 exp 與 jti，且同一 jti 在效期內只能使用一次
+func VerifyClientAssertion(ctx context.Context, cache cache.Cache, c *model.OAuthClient, assertion string, audiences []string) error {
+	keyFunc, algs, err := clientAssertionKeyFunc(c)
+	if err != nil {
+		return err
+	}
+	var claims jwt.RegisteredClaims
+	if _, err := parseWithClaims(assertion, &claims, keyFunc,
+		jwt.WithValidMethods(algs),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(c.ClientID),
+		jwt.WithSubject(c.ClientID),
+		jwt.WithTimeFunc(timeNow),
+	); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidClientAssertion, err)
+	}
+	if !slices.ContainsFunc(claims.Audience, func(aud string) bool { return slices.Contains(audiences, aud) }) {
+		return fmt.Errorf("%w: audience mismatch", ErrInvalidClientAssertion)
+	}
+	if claims.ID == "" {
+		return fmt.Errorf("%w: missing jti", ErrInvalidClientAssertion)
+	}
+	ttl := claims.ExpiresAt.Sub(timeNow())
+	if ttl > clientAssertionMaxLifetime {
+		return fmt.Errorf("%w: exp is too far in the future", ErrInvalidClientAssertion)
+	}
+
+	// 以 SETNX 原子地記錄 jti，併發重送同一 assertion 時僅有一方通過
+	key := fmt.Sprintf("client_assertion_jti:%s:%s", c.ClientID, claims.ID)
+	stored, err := cache.SetNX(ctx, key, "1", ttl).Result()
+	if err != nil {
+		return fmt.Errorf("failed to store client assertion jti: %w", err)
+	}
+	if !stored {
+		return fmt.Errorf("%w: jti already used", ErrInvalidClientAssertion)
+	}
+	return nil
+}
+
+// clientAssertionKeyFunc 依 client 登記的認證方式選擇驗證金鑰與可接受的演算法
+func clientAssertionKeyFunc(c *model.OAuthClient) (jwt.Keyfunc, []string, error) {
+	switch c.TokenEndpointAuthMethod {
+	case model.ClientAuthMethodPrivateKey:
+		keys, err := parseClientJWKS(c.JWKS)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidClientAssertion, err)
+		}
+		return func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			return findClientKey(keys, kid, t.Method.Alg())
+		}, clientAssertionKeyAlgs, nil
+	case model.ClientAuthMethodSecretJWT:
+		secret, err := openClientSecret(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(*jwt.Token) (interface{}, error) {
+			return secret, nil
+		}, clientAssertionSecretAlgs, nil
+	}
+	return nil, nil, fmt.Errorf("%w: client does not authenticate with JWT assertions", ErrInvalidClientAssertion)
+}
+
+// findClientKey 依 kid 找出驗證金鑰；assertion 未帶 kid 時僅在 client 只登記一把金鑰時接受
+func findClientKey(keys []clientKey, kid, alg string) (crypto.PublicKey, error) {
+	var matched *clientKey
+	switch {
+	case kid != "":
+		for i := range keys {
+			if keys[i].kid == kid {
+				matched = &keys[i]
+				break
+			}
+		}
+	case len(keys) == 1:
+		matched = &keys[0]
+	}
+	if matched == nil {
+		return nil, fmt.Errorf("unknown client key: %q", kid)
+	}
+	if matched.alg != "" && matched.alg != alg {
+		return nil, fmt.Errorf("unexpected signing method: %s", alg)
+	}
+	return matched.public, nil
+}
+
+// parseClientJWKS 解析 client 登記的 JWK Set，至少須有一把可用於簽章驗證的公鑰
+func parseClientJWKS(raw json.RawMessage) ([]clientKey, error) {
+	var set JSONWebKeySet
+	if err := jsonUnmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %w", err)
+	}
+	keys := make([]clientKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		public, err := parsePublicJWK(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwks key %q: %w", k.KeyID, err)
+		}
+		keys = append(keys, clientKey{kid: k.KeyID, alg: k.Algorithm, public: public})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("invalid jwks: no signing keys")
+	}
+	return keys, nil
+}
+
+// parsePublicJWK 將 RFC 7518 §6 的公鑰參數還原為 Go 的公鑰型別
+func parsePublicJWK(k JSONWebKey) (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil || len(n) == 0 {
+			return nil, fmt.Errorf("invalid RSA modulus")
+		}
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if public.N.BitLen() < minClientRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minClientRSAKeyBits)
+		}
+		return public, nil
+	case "EC":
+		curve, ok := jwkCurves[k.Curve]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve: %q", k.Curve)
+		}
+		x, errX := decode(k.X)
+		y, errY := decode(k.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid EC coordinates")
+		}
+		public := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if _, err := public.ECDH(); err != nil {
+			return nil, fmt.Errorf("invalid EC point: %w", err)
+		}
+		return public, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %q", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %q", k.KeyType)
+}
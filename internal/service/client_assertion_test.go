@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// testClientKey 為測試用的 client 簽章金鑰
+type testClientKey struct {
+	kid    string
+	method jwt.SigningMethod
+	signer crypto.Signer
+}
+
+func newTestClientKey(t *testing.T, kid string, method jwt.SigningMethod) testClientKey {
+	t.Helper()
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch method {
+	case jwt.SigningMethodRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	}
+	require.NoError(t, err)
+	return testClientKey{kid: kid, method: method, signer: signer}
+}
+
+func (k testClientKey) jwk() JSONWebKey {
+	return newJSONWebKey(&signingKey{kid: k.kid, method: k.method, public: k.signer.Public()})
+}
+
+func testJWKS(t *testing.T, keys ...JSONWebKey) json.RawMessage {
+	t.Helper()
+	b, err := json.Marshal(JSONWebKeySet{Keys: keys})
+	require.NoError(t, err)
+	return b
+}
+
+// signAssertion 以 key 簽發 assertion；kid 為空時不帶 kid header
+func signAssertion(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+func TestClientAssertionAlgorithms(t *testing.T) {
+	algs := ClientAssertionAlgorithms()
+	require.Contains(t, algs, "RS256")
+	require.Contains(t, algs, "EdDSA")
+	require.Contains(t, algs, "HS256")
+}
+
+func TestClientAssertionIssuer(t *testing.T) {
+	key := []byte("k")
+	iss, err := ClientAssertionIssuer(signAssertion(t, jwt.SigningMethodHS256, key, "", jwt.RegisteredClaims{Issuer: "cid"}))
+	require.NoError(t, err)
+	require.Equal(t, "cid", iss)
+
+	_, err = ClientAssertionIssuer(signAssertion(t, jwt.SigningMethodHS256, key, "", jwt.RegisteredClaims{Subject: "cid"}))
+	require.ErrorIs(t, err, ErrInvalidClientAssertion)
+
+	_, err = ClientAssertionIssuer("not-a-jwt")
+	require.ErrorIs(t, err, ErrInvalidClientAssertion)
+}
+
+func TestVerifyClientAssertion(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	audiences := []string{"https://auth.example.com", "https://auth.example.com/api/oauth/token"}
+
+	ecKey := newTestClientKey(t, "ec", jwt.SigningMethodES256)
+	rsaKey := newTestClientKey(t, "rsa", jwt.SigningMethodRS256)
+	edKey := newTestClientKey(t, "ed", jwt.SigningMethodEdDSA)
+	client := &model.OAuthClient{
+		ClientID:                "cid",
+		TokenEndpointAuthMethod: model.ClientAuthMethodPrivateKey,
+		JWKS:                    testJWKS(t, ecKey.jwk(), rsaKey.jwk(), edKey.jwk()),
+	}
+	claims := func(jti string) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Issuer:    "cid",
+			Subject:   "cid",
+			Audience:  jwt.ClaimStrings{"https://auth.example.com/api/oauth/token"},
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		}
+	}
+
+	t.Run("private_key_jwt", func(t *testing.T) {
+		for _, k := range []testClientKey{ecKey, rsaKey, edKey} {
+			rc := cache.NewMemoryCache(nil)
+			assertion := signAssertion(t, k.method, k.signer, k.kid, claims("jti-"+k.kid))
+			require.NoError(t, VerifyClientAssertion(ctx, rc, client, assertion, audiences), k.kid)
+			require.Equal(t, 5*time.Minute, rc.TTLs["client_assertion_jti:cid:jti-"+k.kid])
+
+			// 同一 jti 不可重送
+			err := VerifyClientAssertion(ctx, rc, client, assertion, audiences)
+			require.ErrorIs(t, err, ErrInvalidClientAssertion)
+			require.Contains(t, err.Error(), "jti already used")
+		}
+	})
+
+	t.Run("single key without kid", func(t *testing.T) {
+		single := &model.OAuthClient{ClientID: "cid", TokenEndpointAuthMethod: model.ClientAuthMethodPrivateKey, JWKS: testJWKS(t, ecKey.jwk())}
+		assertion := signAssertion(t, ecKey.method, ecKey.signer, "", claims("j"))
+		require.NoError(t, VerifyClientAssertion(ctx, cache.NewMemoryCache(nil), single, assertion, audiences))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		other := newTestClientKey(t, "ec", jwt.SigningMethodES256)
+		cases := map[string]func() string{
+			"wrong issuer": func() string {
+				c := claims("j")
+				c.Issuer = "other"
+				return signAssertion(t, ecKey.method, ecKey.signer, "ec", c)
+			},
+			"wrong subject": func() string {
+				c := claims("j")
+				c.Subject = "other"
+				return signAssertion(t, ecKey.method, ecKey.signer, "ec", c)
+			},
+			"wrong audience": func() string {
+				c := claims("j")
+				c.Audience = jwt.ClaimStrings{"https://evil.example.com"}
+				return signAssertion(t, ecKey.method, ecKey.signer, "ec", c)
+			},
+			"missing jti": func() string {
+				return signAssertion(t, ecKey.method, ecKey.signer, "ec", claims(""))
+			},
+			"missing exp": func() string {
+				c := claims("j")
+				c.ExpiresAt = nil
+				return signAssertion(t, ecKey.method, ecKey.signer, "ec", c)
+			},
+			"expired": func() string {
+				c := claims("j")
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+				return signAssertion(t, ecKey.method, ecKey.signer, "ec", c)
+			},
+			"exp too far": func() string {
+				c := claims("j")
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(2 * time.Hour))
+				return signAssertion(t, ecKey.method, ecKey.signer, "ec", c)
+			},
+			"unknown kid": func() string {
+				return signAssertion(t, ecKey.method, ecKey.signer, "nope", claims("j"))
+			},
+			"no kid with several keys": func() string {
+				return signAssertion(t, ecKey.method, ecKey.signer, "", claims("j"))
+			},
+			"alg does not match jwk": func() string {
+				return signAssertion(t, rsaKey.method, rsaKey.signer, "ec", claims("j"))
+			},
+			"wrong signature": func() string {
+				return signAssertion(t, other.method, other.signer, "ec", claims("j"))
+			},
+			"hmac not allowed": func() string {
+				return signAssertion(t, jwt.SigningMethodHS256, []byte("secret"), "ec", claims("j"))
+			},
+		}
+		for name, assertion := range cases {
+			t.Run(name, func(t *testing.T) {
+				err := VerifyClientAssertion(ctx, cache.NewMemoryCache(nil), client, assertion(), audiences)
+				require.ErrorIs(t, err, ErrInvalidClientAssertion)
+			})
+		}
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		assertion := signAssertion(t, ecKey.method, ecKey.signer, "ec", claims("j"))
+		rc := cache.NewMemoryCache(nil)
+		rc.FailOn["setnx"] = "client_assertion_jti:"
+		err := VerifyClientAssertion(ctx, rc, client, assertion, audiences)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrInvalidClientAssertion)
+	})
+
+	t.Run("client not registered for assertions", func(t *testing.T) {
+		assertion := signAssertion(t, ecKey.method, ecKey.signer, "ec", claims("j"))
+		basic := &model.OAuthClient{ClientID: "cid", TokenEndpointAuthMethod: model.ClientAuthMethodSecretBasic}
+		require.ErrorIs(t, VerifyClientAssertion(ctx, nil, basic, assertion, audiences), ErrInvalidClientAssertion)
+
+		broken := &model.OAuthClient{ClientID: "cid", TokenEndpointAuthMethod: model.ClientAuthMethodPrivateKey, JWKS: json.RawMessage(`{}`)}
+		require.ErrorIs(t, VerifyClientAssertion(ctx, nil, broken, assertion, audiences), ErrInvalidClientAssertion)
+	})
+
+	t.Run("client_secret_jwt", func(t *testing.T) {
+		secretClient := &model.OAuthClient{ClientID: "cid", ClientSecret: "hash", TokenEndpointAuthMethod: model.ClientAuthMethodSecretJWT}
+		assertion := func(secret string) string {
+			return signAssertion(t, jwt.SigningMethodHS256, []byte(secret), "", claims("j"))
+		}
+
+		require.ErrorIs(t, VerifyClientAssertion(ctx, nil, secretClient, assertion("s"), audiences), ErrKeyStoreNotConfigured)
+
+		ks, _, _ := newTestKeyStore(t, SigningAlgES256)
+		UseKeyStore(ks)
+		timeNow = func() time.Time { return now }
+		secret, err := RotateClientSecret(secretClient, 0)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, VerifyClientAssertion(ctx, cache.NewMemoryCache(nil), secretClient, assertion("wrong"), audiences), ErrInvalidClientAssertion)
+		require.NoError(t, VerifyClientAssertion(ctx, cache.NewMemoryCache(nil), secretClient, assertion(secret), audiences))
+
+		// 非對稱演算法不可用於 client_secret_jwt
+		es := signAssertion(t, ecKey.method, ecKey.signer, "ec", claims("j"))
+		require.ErrorIs(t, VerifyClientAssertion(ctx, cache.NewMemoryCache(nil), secretClient, es, audiences), ErrInvalidClientAssertion)
+	})
+}
+
+func TestParseClientJWKS(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ec := newTestClientKey(t, "ec", jwt.SigningMethodES256).jwk()
+
+	keys, err := parseClientJWKS(testJWKS(t, ec))
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, "ec", keys[0].kid)
+	require.Equal(t, "ES256", keys[0].alg)
+
+	_, err = parseClientJWKS(json.RawMessage(`not json`))
+	require.ErrorContains(t, err, "invalid jwks")
+
+	_, err = parseClientJWKS(nil)
+	require.ErrorContains(t, err, "invalid jwks")
+
+	_, err = parseClientJWKS(json.RawMessage(`{"keys":[]}`))
+	require.ErrorContains(t, err, "no signing keys")
+
+	// 加密用途的金鑰不列入
+	enc := ec
+	enc.Use = "enc"
+	_, err = parseClientJWKS(testJWKS(t, enc))
+	require.ErrorContains(t, err, "no signing keys")
+
+	bad := ec
+	bad.X = "!!"
+	_, err = parseClientJWKS(testJWKS(t, bad))
+	require.ErrorContains(t, err, `invalid jwks key "ec"`)
+}
+
+func TestParsePublicJWK(t *testing.T) {
+	rsaJWK := newTestClientKey(t, "rsa", jwt.SigningMethodRS256).jwk()
+	ecJWK := newTestClientKey(t, "ec", jwt.SigningMethodES256).jwk()
+	edJWK := newTestClientKey(t, "ed", jwt.SigningMethodEdDSA).jwk()
+	for _, k := range []JSONWebKey{rsaJWK, ecJWK, edJWK} {
+		_, err := parsePublicJWK(k)
+		require.NoError(t, err, k.KeyType)
+	}
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	b64 := base64.RawURLEncoding.EncodeToString
+	modify := func(k JSONWebKey, f func(*JSONWebKey)) JSONWebKey {
+		f(&k)
+		return k
+	}
+	cases := map[string]JSONWebKey{
+		"rsa bad modulus":  modify(rsaJWK, func(k *JSONWebKey) { k.N = "!!" }),
+		"rsa empty":        modify(rsaJWK, func(k *JSONWebKey) { k.N = "" }),
+		"rsa bad exponent": modify(rsaJWK, func(k *JSONWebKey) { k.E = b64(make([]byte, 5)) }),
+		"rsa too small":    modify(rsaJWK, func(k *JSONWebKey) { k.N = b64(small.N.Bytes()) }),
+		"ec curve":         modify(ecJWK, func(k *JSONWebKey) { k.Curve = "secp256k1" }),
+		"ec bad x":         modify(ecJWK, func(k *JSONWebKey) { k.X = "!!" }),
+		"ec off curve":     modify(ecJWK, func(k *JSONWebKey) { k.Y = b64(big.NewInt(1).Bytes()) }),
+		"okp curve":        modify(edJWK, func(k *JSONWebKey) { k.Curve = "X25519" }),
+		"okp length":       modify(edJWK, func(k *JSONWebKey) { k.X = b64([]byte("short")) }),
+		"unknown kty":      {KeyType: "oct"},
+	}
+	for name, k := range cases {
+		_, err := parsePublicJWK(k)
+		require.Error(t, err, name)
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"life-is-hard/internal/model"
+)
+
+var (
+	ErrInvalidClientCertificate = errors.New("invalid client certificate")
+
+	// clientCAs 為 tls_client_auth 信任的 CA；未設定時無法使用 PKI 方式的 mTLS 認證
+	clientCAs *x509.CertPool
+)
+
+// UseClientCAs 設定 tls_client_auth 驗證 client 憑證鏈所信任的 CA，傳入 nil 則停用
+func UseClientCAs(pool *x509.CertPool) {
+	clientCAs = pool
+}
+
+// CertificateThumbprint 回傳憑證 DER 編碼的 SHA-256 指紋（base64url，不含 padding）
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ValidateCertificateThumbprint 檢查登記的指紋是否為 SHA-256 的 base64url 編碼
+func ValidateCertificateThumbprint(thumbprint string) error {
+	b, err := base64.RawURLEncoding.DecodeString(thumbprint)
+	if err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("invalid tls_client_certificate_thumbprint: must be a base64url-encoded SHA-256 hash")
+	}
+	return nil
+}
+
+// CertificateConfirmation 為 client 出示的憑證產生 cnf claim；未出示憑證時回傳 nil
+func CertificateConfirmation(chain []*x509.Certificate) *Confirmation {
+	if len(chain) == 0 {
+		return nil
+	}
+	return &Confirmation{X5tS256: CertificateThumbprint(chain[0])}
+}
+
+// VerifyClientCertificate 依 RFC 8705 §2 驗證 client 於 TLS 握手出示的憑證：tls_client_auth 須由信任的 CA
+// 簽發且 subject 與登記的一致；self_signed_tls_client_auth 則比對登記的憑證指紋
+func VerifyClientCertificate(c *model.OAuthClient, chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("%w: no certificate presented", ErrInvalidClientCertificate)
+	}
+	leaf := chain[0]
+	switch c.TokenEndpointAuthMethod {
+	case model.ClientAuthMethodTLS:
+		if clientCAs == nil {
+			return fmt.Errorf("%w: no trusted client CAs configured", ErrInvalidClientCertificate)
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range chain[1:] {
+			intermediates.AddCert(cert)
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         clientCAs,
+			Intermediates: intermediates,
+			CurrentTime:   timeNow(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidClientCertificate, err)
+		}
+		if !strings.EqualFold(leaf.Subject.String(), c.TLSClientAuthSubjectDN) {
+			return fmt.Errorf("%w: subject mismatch", ErrInvalidClientCertificate)
+		}
+		return nil
+	case model.ClientAuthMethodSelfSignedTLS:
+		// 自簽憑證不驗證信任鏈，僅要求仍在效期內且與登記的指紋相同
+		now := timeNow()
+		if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+			return fmt.Errorf("%w: certificate expired or not yet valid", ErrInvalidClientCertificate)
+		}
+		if subtle.ConstantTimeCompare([]byte(CertificateThumbprint(leaf)), []byte(c.TLSClientCertificateThumbprint)) != 1 {
+			return fmt.Errorf("%w: thumbprint mismatch", ErrInvalidClientCertificate)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: client does not authenticate with a TLS certificate", ErrInvalidClientCertificate)
+}
+
+// VerifyCertificateBinding 確認 access token 的 cnf 與目前連線出示的 client 憑證相符；未綁定的 token 一律通過
+func VerifyCertificateBinding(claims *CustomClaims, chain []*x509.Certificate) error {
+	if claims.Confirmation == nil || claims.Confirmation.X5tS256 == "" {
+		return nil
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("certificate-bound token requires a client certificate")
+	}
+	if subtle.ConstantTimeCompare([]byte(CertificateThumbprint(chain[0])), []byte(claims.Confirmation.X5tS256)) != 1 {
+		return fmt.Errorf("client certificate does not match token binding")
+	}
+	return nil
+}
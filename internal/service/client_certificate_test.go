@@ -0,0 +1,103 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCertificate 簽發測試用 client 憑證；parent 為 nil 時為自簽憑證，isCA 為 true 時可再簽發其他憑證
+func newTestCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func TestCertificateThumbprint(t *testing.T) {
+	cert, _ := newTestCertificate(t, "svc", nil, nil, false)
+	sum := sha256.Sum256(cert.Raw)
+	thumbprint := CertificateThumbprint(cert)
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:]), thumbprint)
+	require.NoError(t, ValidateCertificateThumbprint(thumbprint))
+	require.Error(t, ValidateCertificateThumbprint(""))
+	require.Error(t, ValidateCertificateThumbprint("not base64!"))
+	require.Error(t, ValidateCertificateThumbprint(base64.RawURLEncoding.EncodeToString([]byte("short"))))
+
+	require.Nil(t, CertificateConfirmation(nil))
+	require.Equal(t, &Confirmation{X5tS256: thumbprint}, CertificateConfirmation([]*x509.Certificate{cert}))
+}
+
+func TestVerifyClientCertificate(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ca, caKey := newTestCertificate(t, "ca", nil, nil, true)
+	leaf, _ := newTestCertificate(t, "svc", ca, caKey, false)
+	other, _ := newTestCertificate(t, "svc", nil, nil, false)
+
+	pki := &model.OAuthClient{ClientID: "pki", TokenEndpointAuthMethod: model.ClientAuthMethodTLS, TLSClientAuthSubjectDN: "CN=svc,O=Example"}
+	selfSigned := &model.OAuthClient{ClientID: "self", TokenEndpointAuthMethod: model.ClientAuthMethodSelfSignedTLS, TLSClientCertificateThumbprint: CertificateThumbprint(other)}
+
+	require.ErrorIs(t, VerifyClientCertificate(pki, nil), ErrInvalidClientCertificate)
+	// 未設定信任的 CA
+	require.ErrorIs(t, VerifyClientCertificate(pki, []*x509.Certificate{leaf}), ErrInvalidClientCertificate)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	UseClientCAs(pool)
+	require.NoError(t, VerifyClientCertificate(pki, []*x509.Certificate{leaf}))
+	require.ErrorIs(t, VerifyClientCertificate(pki, []*x509.Certificate{other}), ErrInvalidClientCertificate)
+
+	wrongSubject := *pki
+	wrongSubject.TLSClientAuthSubjectDN = "CN=other,O=Example"
+	require.ErrorIs(t, VerifyClientCertificate(&wrongSubject, []*x509.Certificate{leaf}), ErrInvalidClientCertificate)
+
+	require.NoError(t, VerifyClientCertificate(selfSigned, []*x509.Certificate{other}))
+	require.ErrorIs(t, VerifyClientCertificate(selfSigned, []*x509.Certificate{leaf}), ErrInvalidClientCertificate)
+
+	timeNow = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	require.ErrorIs(t, VerifyClientCertificate(selfSigned, []*x509.Certificate{other}), ErrInvalidClientCertificate)
+	require.ErrorIs(t, VerifyClientCertificate(pki, []*x509.Certificate{leaf}), ErrInvalidClientCertificate)
+
+	secretClient := &model.OAuthClient{TokenEndpointAuthMethod: model.ClientAuthMethodSecretBasic}
+	require.ErrorIs(t, VerifyClientCertificate(secretClient, []*x509.Certificate{leaf}), ErrInvalidClientCertificate)
+}
+
+func TestVerifyCertificateBinding(t *testing.T) {
+	cert, _ := newTestCertificate(t, "svc", nil, nil, false)
+	other, _ := newTestCertificate(t, "svc", nil, nil, false)
+	chain := []*x509.Certificate{cert}
+
+	require.NoError(t, VerifyCertificateBinding(&CustomClaims{}, nil))
+	bound := &CustomClaims{Confirmation: CertificateConfirmation(chain)}
+	require.NoError(t, VerifyCertificateBinding(bound, chain))
+	require.Error(t, VerifyCertificateBinding(bound, nil))
+	require.Error(t, VerifyCertificateBinding(bound, []*x509.Certificate{other}))
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"life-is-hard/internal/model"
+)
+
+// registrationAccessTokenBytes 為 registration access token 的隨機位元組數
+const registrationAccessTokenBytes = 32
+
+var ErrInvalidRegistrationAccessToken = errors.New("invalid registration access token")
+
+// NewClientID 為動態註冊的 client 產生 client_id，不會產生信任簽發者對應規則保留的 client_id
+func NewClientID() (string, error) {
+	for {
+		id, err := newTokenID()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate client_id: %w", err)
+		}
+		if !ReservedByTrustedIssuer(id) {
+			return id, nil
+		}
+	}
+}
+
+// IssueRegistrationAccessToken 依 RFC 7592 §3 產生管理註冊資料用的 token；
+// 明文僅回傳給呼叫端一次，client 只保存其 SHA-256 雜湊（高熵 token 不需要 bcrypt）
+func IssueRegistrationAccessToken(c *model.OAuthClient) (string, error) {
+	b := make([]byte, registrationAccessTokenBytes)
+	if _, err := randRead(b); err != nil {
+		return "", fmt.Errorf("failed to generate registration access token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	c.RegistrationAccessToken = hashRegistrationAccessToken(token)
+	return token, nil
+}
+
+// VerifyRegistrationAccessToken 以固定時間比對 registration access token；非動態註冊的 client 一律失敗
+func VerifyRegistrationAccessToken(c *model.OAuthClient, token string) error {
+	if c.RegistrationAccessToken == "" || token == "" {
+		return ErrInvalidRegistrationAccessToken
+	}
+	if subtle.ConstantTimeCompare([]byte(hashRegistrationAccessToken(token)), []byte(c.RegistrationAccessToken)) != 1 {
+		return ErrInvalidRegistrationAccessToken
+	}
+	return nil
+}
+
+func hashRegistrationAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
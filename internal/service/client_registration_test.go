@@ -0,0 +1,56 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"life-is-hard/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClientID(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	id, err := NewClientID()
+	require.NoError(t, err)
+	require.Len(t, id, 22)
+
+	// 產生的 client_id 與信任簽發者保留的相同時重新產生
+	reserved := base64.RawURLEncoding.EncodeToString(make([]byte, 16))
+	UseTrustedIssuers([]TrustedIssuer{{Issuer: "https://ci.example.com", Subjects: []SubjectMapping{{Subject: "repo:*", ClientID: reserved}}}})
+	calls := 0
+	randRead = func(b []byte) (int, error) {
+		if calls++; calls > 1 {
+			b[0] = 1
+		}
+		return len(b), nil
+	}
+	id, err = NewClientID()
+	require.NoError(t, err)
+	require.NotEqual(t, reserved, id)
+	require.Equal(t, 2, calls)
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	_, err = NewClientID()
+	require.ErrorContains(t, err, "failed to generate client_id")
+}
+
+func TestRegistrationAccessToken(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	var c model.OAuthClient
+	token, err := IssueRegistrationAccessToken(&c)
+	require.NoError(t, err)
+	require.Len(t, token, 43)
+	require.NotEqual(t, token, c.RegistrationAccessToken)
+	require.NoError(t, VerifyRegistrationAccessToken(&c, token))
+	require.ErrorIs(t, VerifyRegistrationAccessToken(&c, "other"), ErrInvalidRegistrationAccessToken)
+	require.ErrorIs(t, VerifyRegistrationAccessToken(&c, ""), ErrInvalidRegistrationAccessToken)
+
+	// 非動態註冊的 client 沒有 registration access token
+	require.ErrorIs(t, VerifyRegistrationAccessToken(&model.OAuthClient{}, token), ErrInvalidRegistrationAccessToken)
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	_, err = IssueRegistrationAccessToken(&c)
+	require.Error(t, err)
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"life-is-hard/internal/model"
+)
+
+// clientSecretBytes 為產生 client secret 的隨機位元組數，編碼後長度仍在 bcrypt 的 72 bytes 限制內
+const clientSecretBytes = 32
+
+// GenerateClientSecret 產生新的 client secret 與其 bcrypt 雜湊；明文僅回傳給呼叫端一次，不會保存
+func GenerateClientSecret() (secret, hash string, err error) {
+	b := make([]byte, clientSecretBytes)
+	if _, err := randRead(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate client secret: %w", err)
+	}
+	secret = base64.RawURLEncoding.EncodeToString(b)
+	hash, err = HashPassword(secret)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to hash client secret: %w", err)
+	}
+	return secret, hash, nil
+}
+
+// VerifyClientSecret 以固定時間比對 client secret；輪替後的寬限期內舊 secret 仍然有效
+func VerifyClientSecret(c *model.OAuthClient, secret string) bool {
+	if c.ClientSecret != "" && ComparePassword(c.ClientSecret, secret) == nil {
+		return true
+	}
+	if c.PreviousClientSecret == "" || c.PreviousClientSecretExpiresAt == nil || !timeNow().Before(*c.PreviousClientSecretExpiresAt) {
+		return false
+	}
+	return ComparePassword(c.PreviousClientSecret, secret) == nil
+}
+
+// NeedsClientSecret 回傳以 secret 認證的 client 是否還沒有可用的 secret，例如剛建立或剛改用
+// client_secret_jwt（雜湊無法作為 HMAC 金鑰，需要重新產生並加密保存）
+func NeedsClientSecret(c *model.OAuthClient) bool {
+	if !c.UsesClientSecret() {
+		return false
+	}
+	return c.ClientSecret == "" || (c.TokenEndpointAuthMethod == model.ClientAuthMethodSecretJWT && len(c.SealedClientSecret) == 0)
+}
+
+// RotateClientSecret 為 client 產生新 secret，舊 secret 保留 grace 期間供部署切換；
+// grace 為 0 時舊 secret 立即失效。client_secret_jwt 的 secret 另以金鑰庫加密保存，舊 secret 不保留
+func RotateClientSecret(c *model.OAuthClient, grace time.Duration) (string, error) {
+	secret, hash, err := GenerateClientSecret()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealClientSecret(c, secret)
+	if err != nil {
+		return "", err
+	}
+	c.SealedClientSecret = sealed
+	c.PreviousClientSecret = ""
+	c.PreviousClientSecretExpiresAt = nil
+	if grace > 0 {
+		expiresAt := timeNow().Add(grace).UTC()
+		c.PreviousClientSecret = c.ClientSecret
+		c.PreviousClientSecretExpiresAt = &expiresAt
+	}
+	c.ClientSecret = hash
+	return secret, nil
+}
+
+// clientSecretAD 為加密 client secret 時的 additional data，綁定 client 並與簽章金鑰的 kid 區隔
+func clientSecretAD(clientID string) string {
+	return "client_secret:" + clientID
+}
+
+// sealClientSecret 以金鑰庫加密 client_secret_jwt 所需的 secret 明文；其他認證方式不保存明文
+func sealClientSecret(c *model.OAuthClient, secret string) ([]byte, error) {
+	if c.TokenEndpointAuthMethod != model.ClientAuthMethodSecretJWT {
+		return nil, nil
+	}
+	if keyStore == nil {
+		return nil, ErrKeyStoreNotConfigured
+	}
+	return keyStore.seal(clientSecretAD(c.ClientID), []byte(secret))
+}
+
+// openClientSecret 解密 client_secret_jwt 的 secret，作為驗證 client assertion 的 HMAC 金鑰
+func openClientSecret(c *model.OAuthClient) ([]byte, error) {
+	if keyStore == nil {
+		return nil, ErrKeyStoreNotConfigured
+	}
+	size := keyStore.aead.NonceSize()
+	if len(c.SealedClientSecret) < size {
+		return nil, errors.New("client secret is not available for client_secret_jwt")
+	}
+	secret, err := keyStore.aead.Open(nil, c.SealedClientSecret[:size], c.SealedClientSecret[size:], []byte(clientSecretAD(c.ClientID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt client secret: %w", err)
+	}
+	return secret, nil
+}
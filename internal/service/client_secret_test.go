@@ -0,0 +1,155 @@
+package service
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/model"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestGenerateClientSecret(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	secret, hash, err := GenerateClientSecret()
+	require.NoError(t, err)
+	require.Len(t, secret, 43)
+	require.NotEqual(t, secret, hash)
+	require.NoError(t, bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)))
+
+	other, _, err := GenerateClientSecret()
+	require.NoError(t, err)
+	require.NotEqual(t, secret, other)
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	_, _, err = GenerateClientSecret()
+	require.Error(t, err)
+
+	restoreGlobals()
+	bcryptGenerateFromPassword = func([]byte, int) ([]byte, error) { return nil, errors.New("bcrypt") }
+	_, _, err = GenerateClientSecret()
+	require.Error(t, err)
+}
+
+func TestVerifyClientSecret(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	hash := func(s string) string {
+		b, _ := bcrypt.GenerateFromPassword([]byte(s), bcrypt.MinCost)
+		return string(b)
+	}
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Second)
+
+	c := &model.OAuthClient{ClientSecret: hash("new"), PreviousClientSecret: hash("old"), PreviousClientSecretExpiresAt: &later}
+	require.True(t, VerifyClientSecret(c, "new"))
+	require.True(t, VerifyClientSecret(c, "old"))
+	require.False(t, VerifyClientSecret(c, "other"))
+
+	c.PreviousClientSecretExpiresAt = &earlier
+	require.False(t, VerifyClientSecret(c, "old"))
+
+	c.PreviousClientSecretExpiresAt = nil
+	require.False(t, VerifyClientSecret(c, "old"))
+
+	// 沒有 secret 的 client 不接受空字串
+	require.False(t, VerifyClientSecret(&model.OAuthClient{}, ""))
+}
+
+func TestRotateClientSecret(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	bcryptGenerateFromPassword = func(p []byte, _ int) ([]byte, error) {
+		return bcrypt.GenerateFromPassword(p, bcrypt.MinCost)
+	}
+
+	t.Run("with grace", func(t *testing.T) {
+		c := &model.OAuthClient{ClientSecret: "old-hash"}
+		secret, err := RotateClientSecret(c, time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, "old-hash", c.PreviousClientSecret)
+		require.True(t, now.Add(time.Hour).Equal(*c.PreviousClientSecretExpiresAt))
+		require.NoError(t, bcrypt.CompareHashAndPassword([]byte(c.ClientSecret), []byte(secret)))
+	})
+
+	t.Run("without grace", func(t *testing.T) {
+		exp := now.Add(time.Hour)
+		c := &model.OAuthClient{ClientSecret: "old-hash", PreviousClientSecret: "older-hash", PreviousClientSecretExpiresAt: &exp}
+		_, err := RotateClientSecret(c, 0)
+		require.NoError(t, err)
+		require.Empty(t, c.PreviousClientSecret)
+		require.Nil(t, c.PreviousClientSecretExpiresAt)
+	})
+
+	t.Run("generate error", func(t *testing.T) {
+		t.Cleanup(func() { randRead = rand.Read })
+		randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+		c := &model.OAuthClient{ClientSecret: "old-hash"}
+		_, err := RotateClientSecret(c, time.Hour)
+		require.Error(t, err)
+		require.Equal(t, "old-hash", c.ClientSecret)
+	})
+}
+
+func TestNeedsClientSecret(t *testing.T) {
+	require.False(t, NeedsClientSecret(&model.OAuthClient{TokenEndpointAuthMethod: model.ClientAuthMethodNone}))
+	require.False(t, NeedsClientSecret(&model.OAuthClient{TokenEndpointAuthMethod: model.ClientAuthMethodPrivateKey}))
+	require.True(t, NeedsClientSecret(&model.OAuthClient{TokenEndpointAuthMethod: model.ClientAuthMethodSecretBasic}))
+	require.False(t, NeedsClientSecret(&model.OAuthClient{TokenEndpointAuthMethod: model.ClientAuthMethodSecretPost, ClientSecret: "hash"}))
+	// 改用 client_secret_jwt 時既有的雜湊無法作為 HMAC 金鑰
+	require.True(t, NeedsClientSecret(&model.OAuthClient{TokenEndpointAuthMethod: model.ClientAuthMethodSecretJWT, ClientSecret: "hash"}))
+	require.False(t, NeedsClientSecret(&model.OAuthClient{TokenEndpointAuthMethod: model.ClientAuthMethodSecretJWT, ClientSecret: "hash", SealedClientSecret: []byte("sealed")}))
+}
+
+func TestSealedClientSecret(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	bcryptGenerateFromPassword = func(p []byte, _ int) ([]byte, error) {
+		return bcrypt.GenerateFromPassword(p, bcrypt.MinCost)
+	}
+	c := &model.OAuthClient{ClientID: "cid", ClientSecret: "old-hash", TokenEndpointAuthMethod: model.ClientAuthMethodSecretJWT}
+
+	_, err := RotateClientSecret(c, 0)
+	require.ErrorIs(t, err, ErrKeyStoreNotConfigured)
+	require.Equal(t, "old-hash", c.ClientSecret)
+	_, err = openClientSecret(c)
+	require.ErrorIs(t, err, ErrKeyStoreNotConfigured)
+
+	ks, _, _ := newTestKeyStore(t, SigningAlgES256)
+	UseKeyStore(ks)
+	_, err = openClientSecret(c)
+	require.ErrorContains(t, err, "not available")
+
+	secret, err := RotateClientSecret(c, 0)
+	require.NoError(t, err)
+	require.NotEmpty(t, c.SealedClientSecret)
+	opened, err := openClientSecret(c)
+	require.NoError(t, err)
+	require.Equal(t, secret, string(opened))
+
+	// 加密的 secret 綁定 client_id，不能搬到其他 client
+	moved := *c
+	moved.ClientID = "other"
+	_, err = openClientSecret(&moved)
+	require.ErrorContains(t, err, "failed to decrypt")
+
+	// 改回 client_secret_basic 後輪替不再保存明文
+	c.TokenEndpointAuthMethod = model.ClientAuthMethodSecretBasic
+	_, err = RotateClientSecret(c, 0)
+	require.NoError(t, err)
+	require.Nil(t, c.SealedClientSecret)
+
+	c.TokenEndpointAuthMethod = model.ClientAuthMethodSecretJWT
+	randRead = func(b []byte) (int, error) {
+		if len(b) == clientSecretBytes {
+			return rand.Read(b)
+		}
+		return 0, errors.New("rand")
+	}
+	_, err = RotateClientSecret(c, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrConsentRequestNotFound = errors.New("consent request not found or expired")
+
+// ConsentRequestData 為等待使用者同意的授權請求；同意後據此核發授權碼
+type ConsentRequestData struct {
+	UserID   int    `json:"user_id"`
+	ClientID string `json:"client_id"`
+	// RedirectURI 為驗證後實際導回的網址，RequestedRedirectURI 為請求原本帶入的值（兌換授權碼時比對）
+	RedirectURI          string `json:"redirect_uri"`
+	RequestedRedirectURI string `json:"requested_redirect_uri,omitempty"`
+	Scope                string `json:"scope,omitempty"`
+	State                string `json:"state,omitempty"`
+	CodeChallenge        string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod  string `json:"code_challenge_method,omitempty"`
+	Nonce                string `json:"nonce,omitempty"`
+	AuthTime             int64  `json:"auth_time,omitempty"`
+	Authentication
+}
+
+// GrantCoversScope 回傳使用者先前的同意是否已涵蓋以空白分隔的 scope
+func GrantCoversScope(grant *model.OAuthGrant, scope string) bool {
+	if grant == nil {
+		return false
+	}
+	for _, s := range strings.Fields(scope) {
+		if !containsScope(grant.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// MergeGrantScopes 回傳先前同意的 scope 與本次同意 scope 的聯集，已排序且不重複
+func MergeGrantScopes(granted []string, scope string) []string {
+	merged := normalizeScope(append(append([]string{}, granted...), strings.Fields(scope)...))
+	return strings.Fields(merged)
+}
+
+// IssueConsentRequest 保存等待同意的授權請求並回傳 consent challenge
+func IssueConsentRequest(ctx context.Context, cache cache.Cache, data ConsentRequestData, ttl time.Duration) (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	bytesData, err := jsonMarshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal consent request: %w", err)
+	}
+	if err := cache.Set(ctx, consentRequestKey(id), bytesData, ttl).Err(); err != nil {
+		return "", fmt.Errorf("failed to store consent request: %w", err)
+	}
+	return id, nil
+}
+
+// ConsumeConsentRequest 讀取並刪除 consent challenge 對應的授權請求；僅限提出請求的使用者決定一次
+func ConsumeConsentRequest(ctx context.Context, cache cache.Cache, userID int, challenge string) (*ConsentRequestData, error) {
+	if challenge == "" {
+		return nil, ErrConsentRequestNotFound
+	}
+	key := consentRequestKey(challenge)
+	val, err := cache.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrConsentRequestNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve consent request: %w", err)
+	}
+	var data ConsentRequestData
+	if err := jsonUnmarshal([]byte(val), &data); err != nil {
+		return nil, fmt.Errorf("failed to parse consent request: %w", err)
+	}
+	// 其他使用者不可代為同意或消耗此請求
+	if data.UserID != userID {
+		return nil, ErrConsentRequestNotFound
+	}
+	deleted, err := cache.Del(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete consent request: %w", err)
+	}
+	if deleted == 0 {
+		return nil, ErrConsentRequestNotFound
+	}
+	return &data, nil
+}
+
+// RevokeGrantRefreshTokens 記錄使用者撤銷對 client 的同意，此前發行的 refresh token family 自此皆失效；
+// refresh token 未依使用者建立索引，因此以撤銷時間（Unix 毫秒）標記，於驗證 refresh token 時比對。
+// 標記保留至撤銷前發行的 refresh token 皆已到期為止
+func RevokeGrantRefreshTokens(ctx context.Context, cache cache.Cache, userID int, clientID string) error {
+	revokedAt := strconv.FormatInt(timeNow().UnixMilli(), 10)
+	if err := cache.Set(ctx, revokedGrantKey(userID, clientID), revokedAt, MaxRefreshTokenTTL).Err(); err != nil {
+		return fmt.Errorf("failed to revoke grant refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// grantRevoked 回傳 refresh token 的 family 是否在使用者撤銷同意之時或之前發行
+func grantRevoked(ctx context.Context, cache cache.Cache, data *RefreshTokenData) (bool, error) {
+	val, err := cache.Get(ctx, revokedGrantKey(data.UserID, data.ClientID)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to retrieve grant revocation: %w", err)
+	}
+	revokedAt, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse grant revocation: %w", err)
+	}
+	return familyIssuedAt(data) <= revokedAt, nil
+}
+
+// familyIssuedAt 回傳 refresh token family 首次發行的時間（Unix 毫秒）；舊資料沒有此欄位時以 token 本身的發行時間代替
+func familyIssuedAt(data *RefreshTokenData) int64 {
+	if data.FamilyIssuedAt != 0 {
+		return data.FamilyIssuedAt
+	}
+	return time.Unix(data.IssuedAt, 0).UnixMilli()
+}
+
+func consentRequestKey(id string) string {
+	return fmt.Sprintf("consent_request:%s", id)
+}
+
+func revokedGrantKey(userID int, clientID string) string {
+	return fmt.Sprintf("revoked_grant:%d:%s", userID, clientID)
+}
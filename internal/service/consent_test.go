@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/model"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrantCoversScope(t *testing.T) {
+	grant := &model.OAuthGrant{Scopes: []string{"openid", "users:read"}}
+	require.True(t, GrantCoversScope(grant, "openid"))
+	require.True(t, GrantCoversScope(grant, "users:read openid"))
+	require.True(t, GrantCoversScope(grant, ""))
+	require.False(t, GrantCoversScope(grant, "openid users:write"))
+	require.False(t, GrantCoversScope(nil, "openid"))
+}
+
+func TestMergeGrantScopes(t *testing.T) {
+	require.Equal(t, []string{"openid", "users:read", "users:write"}, MergeGrantScopes([]string{"users:read", "openid"}, "users:write openid"))
+	require.Equal(t, []string{"openid"}, MergeGrantScopes(nil, "openid"))
+}
+
+func TestIssueConsentRequest(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	rc := cache.NewMemoryCache(nil)
+	data := ConsentRequestData{UserID: 1, ClientID: "cid", RedirectURI: "https://app/cb", State: "st"}
+
+	challenge, err := IssueConsentRequest(ctx, rc, data, time.Minute)
+	require.NoError(t, err)
+	require.NotEmpty(t, challenge)
+	key := "consent_request:" + challenge
+	require.Equal(t, time.Minute, rc.TTLs[key])
+	require.Contains(t, rc.Data[key], `"state":"st"`)
+
+	rc.FailOn["set"] = "consent_request:"
+	_, err = IssueConsentRequest(ctx, rc, data, time.Minute)
+	require.ErrorContains(t, err, "failed to store consent request")
+
+	jsonMarshal = func(any) ([]byte, error) { return nil, errors.New("json") }
+	_, err = IssueConsentRequest(ctx, rc, data, time.Minute)
+	require.ErrorContains(t, err, "failed to marshal consent request")
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	_, err = IssueConsentRequest(ctx, rc, data, time.Minute)
+	require.Error(t, err)
+}
+
+func TestConsumeConsentRequest(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	const key = "consent_request:abc"
+	stored := `{"user_id":1,"client_id":"cid","redirect_uri":"https://app/cb","nonce":"n1"}`
+
+	t.Run("success and single use", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{key: stored})
+		data, err := ConsumeConsentRequest(ctx, rc, 1, "abc")
+		require.NoError(t, err)
+		require.Equal(t, &ConsentRequestData{UserID: 1, ClientID: "cid", RedirectURI: "https://app/cb", Nonce: "n1"}, data)
+		require.NotContains(t, rc.Data, key)
+
+		_, err = ConsumeConsentRequest(ctx, rc, 1, "abc")
+		require.ErrorIs(t, err, ErrConsentRequestNotFound)
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{key: stored})
+		_, err := ConsumeConsentRequest(ctx, rc, 2, "abc")
+		require.ErrorIs(t, err, ErrConsentRequestNotFound)
+		// 不可因其他使用者的請求而失效
+		require.Contains(t, rc.Data, key)
+	})
+
+	t.Run("empty challenge", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{key: stored})
+		_, err := ConsumeConsentRequest(ctx, rc, 1, "")
+		require.ErrorIs(t, err, ErrConsentRequestNotFound)
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{key: stored})
+		rc.FailOn["get"] = "consent_request:"
+		_, err := ConsumeConsentRequest(ctx, rc, 1, "abc")
+		require.ErrorContains(t, err, "failed to retrieve consent request")
+
+		rc = cache.NewMemoryCache(map[string]string{key: stored})
+		rc.FailOn["del"] = "consent_request:"
+		_, err = ConsumeConsentRequest(ctx, rc, 1, "abc")
+		require.ErrorContains(t, err, "failed to delete consent request")
+	})
+
+	t.Run("concurrent consume", func(t *testing.T) {
+		fc := &cache.FakeCache{
+			GetFn: func(context.Context, string) *redis.StringCmd { return redis.NewStringResult(stored, nil) },
+			DelFn: func(context.Context, ...string) *redis.IntCmd { return redis.NewIntResult(0, nil) },
+		}
+		_, err := ConsumeConsentRequest(ctx, fc, 1, "abc")
+		require.ErrorIs(t, err, ErrConsentRequestNotFound)
+	})
+
+	t.Run("corrupt data", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{key: "{"})
+		_, err := ConsumeConsentRequest(ctx, rc, 1, "abc")
+		require.ErrorContains(t, err, "failed to parse consent request")
+	})
+}
+
+func TestRevokeGrantRefreshTokens(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	timeNow = func() time.Time { return now }
+	rc := cache.NewMemoryCache(nil)
+
+	// 撤銷前發行的 token 在撤銷後失效，包含輪替出的 token
+	before, err := IssueRefreshToken(ctx, rc, 1, "cid", false, "openid", DefaultTokenLifetimes, "", Authentication{})
+	require.NoError(t, err)
+	other, err := IssueRefreshToken(ctx, rc, 1, "other", false, "openid", DefaultTokenLifetimes, "", Authentication{})
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	_, rotated, err := RotateRefreshToken(ctx, rc, "cid", before, "", "", DefaultTokenLifetimes)
+	require.NoError(t, err)
+
+	require.NoError(t, RevokeGrantRefreshTokens(ctx, rc, 1, "cid"))
+	require.Equal(t, "1060000", rc.Data["revoked_grant:1:cid"])
+	require.Equal(t, MaxRefreshTokenTTL, rc.TTLs["revoked_grant:1:cid"])
+
+	_, err = ValidateRefreshToken(ctx, rc, rotated)
+	require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	require.NotContains(t, rc.Data, "refresh_token:"+rotated)
+	_, err = ValidateRefreshToken(ctx, rc, other)
+	require.NoError(t, err)
+
+	// 同一秒內重新同意後發行的 token 不受影響
+	now = now.Add(time.Millisecond)
+	after, err := IssueRefreshToken(ctx, rc, 1, "cid", false, "openid", DefaultTokenLifetimes, "", Authentication{})
+	require.NoError(t, err)
+	_, err = ValidateRefreshToken(ctx, rc, after)
+	require.NoError(t, err)
+
+	rc.FailOn["set"] = "revoked_grant:"
+	require.ErrorContains(t, RevokeGrantRefreshTokens(ctx, rc, 1, "cid"), "failed to revoke grant refresh tokens")
+}
+
+func TestRotateRefreshTokenKeepsFamilyIssuedAt(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	timeNow = func() time.Time { return time.Unix(2000, 0) }
+	rc := cache.NewMemoryCache(map[string]string{
+		"refresh_token:legacy": `{"user_id":1,"client_id":"cid","iat":1500,"family_id":"f"}`,
+	})
+	data, _, err := RotateRefreshToken(ctx, rc, "cid", "legacy", "", "", DefaultTokenLifetimes)
+	require.NoError(t, err)
+	require.Equal(t, int64(1500000), data.FamilyIssuedAt)
+}
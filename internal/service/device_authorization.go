@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+
+	// userCodeCharset 依 RFC 8628 §6.1 僅用子音，避免拼出單字且不易混淆
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength  = 8
+
+	// deviceCodeSlowDownStep 為 RFC 8628 §3.5 收到 slow_down 後輪詢間隔須增加的秒數
+	deviceCodeSlowDownStep = 5
+	// deviceCodeRetention 為 device code 到期後仍保留的時間，期間內輪詢回傳 expired_token 而非 invalid_grant
+	deviceCodeRetention = 10 * time.Minute
+
+	// userCodeAttemptWindow 內同一使用者與同一 IP 查詢或核准 user code 的次數上限，防止暴力猜測 user code
+	userCodeAttemptWindow    = 15 * time.Minute
+	userCodeAttemptUserLimit = 20
+	userCodeAttemptIPLimit   = 50
+)
+
+var (
+	ErrDeviceCodeNotFound   = errors.New("device code not found")
+	ErrUserCodeNotFound     = errors.New("user code not found or expired")
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("polling too frequently")
+	ErrDeviceCodeExpired    = errors.New("device code expired")
+	ErrDeviceAccessDenied   = errors.New("device authorization denied")
+	ErrDeviceAlreadyDecided = errors.New("device authorization already decided")
+)
+
+// DeviceAuthorizationData 為 RFC 8628 device flow 的狀態；使用者核准後才帶有 UserID
+type DeviceAuthorizationData struct {
+	ClientID  string `json:"client_id"`
+	Scope     string `json:"scope,omitempty"`
+	UserCode  string `json:"user_code"`
+	Status    string `json:"status"`
+	UserID    int    `json:"user_id,omitempty"`
+	Interval  int    `json:"interval"`
+	ExpiresAt int64  `json:"exp"`
+	Authentication
+}
+
+// devicePollState 為輪詢的節流狀態；與授權狀態分開保存，輪詢寫入時不會覆蓋同時發生的核准或拒絕
+type devicePollState struct {
+	Interval     int   `json:"interval"`
+	LastPolledAt int64 `json:"last_polled_at"`
+}
+
+// IssueDeviceAuthorization 為 client 產生 device code 與供使用者輸入的 user code
+func IssueDeviceAuthorization(ctx context.Context, cache cache.Cache, clientID, scope string, ttl time.Duration, interval int) (deviceCode, userCode string, err error) {
+	deviceCode, err = newTokenID()
+	if err != nil {
+		return "", "", err
+	}
+	userCode, err = newUserCode()
+	if err != nil {
+		return "", "", err
+	}
+	data := &DeviceAuthorizationData{
+		ClientID:  clientID,
+		Scope:     scope,
+		UserCode:  userCode,
+		Status:    DeviceStatusPending,
+		Interval:  interval,
+		ExpiresAt: timeNow().Add(ttl).Unix(),
+	}
+	if err := saveDeviceAuthorization(ctx, cache, deviceCode, data); err != nil {
+		return "", "", err
+	}
+	key := fmt.Sprintf("device_user_code:%s", normalizeUserCode(userCode))
+	if err := cache.Set(ctx, key, deviceCode, ttl).Err(); err != nil {
+		return "", "", fmt.Errorf("failed to store user code: %w", err)
+	}
+	return deviceCode, userCode, nil
+}
+
+// AllowUserCodeAttempt 以固定時間窗限制同一使用者與同一 IP 查詢或核准 user code 的次數，超過時回傳 ErrRateLimited；
+// 須在查詢 user code 之前呼叫，不論 user code 是否存在都會計數
+func AllowUserCodeAttempt(ctx context.Context, cache cache.Cache, userID int, ip string) error {
+	for _, l := range []struct {
+		key   string
+		limit int
+	}{
+		{fmt.Sprintf("user_code_rate:user:%d", userID), userCodeAttemptUserLimit},
+		{"user_code_rate:ip:" + ip, userCodeAttemptIPLimit},
+	} {
+		ok, err := allowRequest(ctx, cache, l.key, l.limit, userCodeAttemptWindow)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrRateLimited
+		}
+	}
+	return nil
+}
+
+// LookupDeviceAuthorization 依使用者輸入的 user code 取得待核准的授權；大小寫與連字號不影響比對
+func LookupDeviceAuthorization(ctx context.Context, cache cache.Cache, userCode string) (*DeviceAuthorizationData, string, error) {
+	deviceCode, err := cache.Get(ctx, fmt.Sprintf("device_user_code:%s", normalizeUserCode(userCode))).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, "", ErrUserCodeNotFound
+		}
+		return nil, "", fmt.Errorf("failed to retrieve user code: %w", err)
+	}
+	data, err := loadDeviceAuthorization(ctx, cache, deviceCode)
+	if err != nil {
+		if errors.Is(err, ErrDeviceCodeNotFound) {
+			return nil, "", ErrUserCodeNotFound
+		}
+		return nil, "", err
+	}
+	if data.Status != DeviceStatusPending {
+		return nil, "", ErrDeviceAlreadyDecided
+	}
+	if timeNow().Unix() >= data.ExpiresAt {
+		return nil, "", ErrUserCodeNotFound
+	}
+	return data, deviceCode, nil
+}
+
+// DecideDeviceAuthorization 記錄使用者核准或拒絕；user code 僅能使用一次。authn 為核准時使用者的驗證方式
+func DecideDeviceAuthorization(ctx context.Context, cache cache.Cache, userCode string, userID int, approve bool, authn Authentication) error {
+	data, deviceCode, err := LookupDeviceAuthorization(ctx, cache, userCode)
+	if err != nil {
+		return err
+	}
+	data.Status = DeviceStatusDenied
+	if approve {
+		data.Status = DeviceStatusApproved
+		data.UserID = userID
+		data.Authentication = authn
+	}
+	if err := saveDeviceAuthorization(ctx, cache, deviceCode, data); err != nil {
+		return err
+	}
+	if err := cache.Del(ctx, fmt.Sprintf("device_user_code:%s", normalizeUserCode(userCode))).Err(); err != nil {
+		return fmt.Errorf("failed to delete user code: %w", err)
+	}
+	return nil
+}
+
+// PollDeviceAuthorization 處理 client 以 device code 換發 token 的輪詢，依 RFC 8628 §3.5 回傳
+// ErrAuthorizationPending、ErrSlowDown、ErrDeviceAccessDenied 或 ErrDeviceCodeExpired；
+// 核准後 device code 即刪除，只能兌換一次；不屬於 clientID 的 device code 視為不存在
+func PollDeviceAuthorization(ctx context.Context, cache cache.Cache, clientID, deviceCode string) (*DeviceAuthorizationData, error) {
+	data, err := loadDeviceAuthorization(ctx, cache, deviceCode)
+	if err != nil {
+		return nil, err
+	}
+	if data.ClientID != clientID {
+		return nil, ErrDeviceCodeNotFound
+	}
+	now := timeNow().Unix()
+	if now >= data.ExpiresAt {
+		return nil, ErrDeviceCodeExpired
+	}
+
+	switch data.Status {
+	case DeviceStatusApproved:
+		deleted, err := cache.Del(ctx, fmt.Sprintf("device_code:%s", deviceCode)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete device code: %w", err)
+		}
+		// 僅有成功刪除的呼叫者可以兌換，避免併發重複發行
+		if deleted == 0 {
+			return nil, ErrDeviceCodeNotFound
+		}
+		return data, nil
+	case DeviceStatusDenied:
+		return nil, ErrDeviceAccessDenied
+	}
+
+	poll, err := loadDevicePollState(ctx, cache, deviceCode, data.Interval)
+	if err != nil {
+		return nil, err
+	}
+	tooSoon := poll.LastPolledAt != 0 && now-poll.LastPolledAt < int64(poll.Interval)
+	if tooSoon {
+		poll.Interval += deviceCodeSlowDownStep
+	}
+	poll.LastPolledAt = now
+	b, err := jsonMarshal(poll)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal device poll state: %w", err)
+	}
+	ttl := time.Unix(data.ExpiresAt, 0).Sub(timeNow())
+	if err := cache.Set(ctx, fmt.Sprintf("device_code_poll:%s", deviceCode), b, ttl).Err(); err != nil {
+		return nil, fmt.Errorf("failed to store device poll state: %w", err)
+	}
+	if tooSoon {
+		return nil, ErrSlowDown
+	}
+	return nil, ErrAuthorizationPending
+}
+
+// loadDevicePollState 取得 device code 的輪詢狀態；尚未輪詢過時以 interval 為初始間隔
+func loadDevicePollState(ctx context.Context, cache cache.Cache, deviceCode string, interval int) (*devicePollState, error) {
+	val, err := cache.Get(ctx, fmt.Sprintf("device_code_poll:%s", deviceCode)).Result()
+	if err == redis.Nil {
+		return &devicePollState{Interval: interval}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve device poll state: %w", err)
+	}
+	var poll devicePollState
+	if err := jsonUnmarshal([]byte(val), &poll); err != nil {
+		return nil, fmt.Errorf("failed to parse device poll state: %w", err)
+	}
+	return &poll, nil
+}
+
+func saveDeviceAuthorization(ctx context.Context, cache cache.Cache, deviceCode string, data *DeviceAuthorizationData) error {
+	b, err := jsonMarshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal device authorization data: %w", err)
+	}
+	ttl := time.Unix(data.ExpiresAt, 0).Sub(timeNow()) + deviceCodeRetention
+	if err := cache.Set(ctx, fmt.Sprintf("device_code:%s", deviceCode), b, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store device code: %w", err)
+	}
+	return nil
+}
+
+func loadDeviceAuthorization(ctx context.Context, cache cache.Cache, deviceCode string) (*DeviceAuthorizationData, error) {
+	val, err := cache.Get(ctx, fmt.Sprintf("device_code:%s", deviceCode)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrDeviceCodeNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve device code: %w", err)
+	}
+	var data DeviceAuthorizationData
+	if err := jsonUnmarshal([]byte(val), &data); err != nil {
+		return nil, fmt.Errorf("failed to parse device authorization data: %w", err)
+	}
+	return &data, nil
+}
+
+// newUserCode 產生 XXXX-XXXX 格式的 user code；以拒絕取樣避免字元分布偏差
+func newUserCode() (string, error) {
+	var sb strings.Builder
+	buf := make([]byte, 1)
+	for sb.Len() < userCodeLength {
+		if _, err := randRead(buf); err != nil {
+			return "", fmt.Errorf("failed to generate user code: %w", err)
+		}
+		// 取小於字元數整數倍的值，使每個字元機率相同
+		if int(buf[0]) >= 256-256%len(userCodeCharset) {
+			continue
+		}
+		sb.WriteByte(userCodeCharset[int(buf[0])%len(userCodeCharset)])
+	}
+	code := sb.String()
+	return code[:4] + "-" + code[4:], nil
+}
+
+// normalizeUserCode 去除使用者輸入中的連字號與空白並轉為大寫
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
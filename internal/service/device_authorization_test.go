@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueDeviceAuthorization(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+
+	t.Run("success", func(t *testing.T) {
+		rc := cache.NewMemoryCache(nil)
+		dc, uc, err := IssueDeviceAuthorization(ctx, rc, "cid", "openid", 10*time.Minute, 5)
+		require.NoError(t, err)
+		require.Regexp(t, regexp.MustCompile(`^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`), uc)
+		require.Equal(t, dc, rc.Data["device_user_code:"+normalizeUserCode(uc)])
+		require.Equal(t, 10*time.Minute, rc.TTLs["device_user_code:"+normalizeUserCode(uc)])
+		require.Equal(t, 10*time.Minute+deviceCodeRetention, rc.TTLs["device_code:"+dc])
+		var data DeviceAuthorizationData
+		require.NoError(t, json.Unmarshal([]byte(rc.Data["device_code:"+dc]), &data))
+		require.Equal(t, DeviceAuthorizationData{ClientID: "cid", Scope: "openid", UserCode: uc, Status: DeviceStatusPending, Interval: 5, ExpiresAt: now.Add(10 * time.Minute).Unix()}, data)
+	})
+
+	t.Run("device code error", func(t *testing.T) {
+		t.Cleanup(restoreGlobals)
+		randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+		_, _, err := IssueDeviceAuthorization(ctx, cache.NewMemoryCache(nil), "cid", "", time.Minute, 5)
+		require.Error(t, err)
+	})
+
+	t.Run("user code error", func(t *testing.T) {
+		t.Cleanup(restoreGlobals)
+		calls := 0
+		randRead = func(b []byte) (int, error) {
+			if calls++; calls > 1 {
+				return 0, errors.New("rand")
+			}
+			return len(b), nil
+		}
+		_, _, err := IssueDeviceAuthorization(ctx, cache.NewMemoryCache(nil), "cid", "", time.Minute, 5)
+		require.Error(t, err)
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		for _, prefix := range []string{"device_code:", "device_user_code:"} {
+			rc := cache.NewMemoryCache(nil)
+			rc.FailOn["set"] = prefix
+			_, _, err := IssueDeviceAuthorization(ctx, rc, "cid", "", time.Minute, 5)
+			require.Error(t, err, prefix)
+		}
+	})
+
+	t.Run("marshal error", func(t *testing.T) {
+		t.Cleanup(func() { jsonMarshal = json.Marshal })
+		jsonMarshal = func(any) ([]byte, error) { return nil, errors.New("json") }
+		_, _, err := IssueDeviceAuthorization(ctx, cache.NewMemoryCache(nil), "cid", "", time.Minute, 5)
+		require.Error(t, err)
+	})
+}
+
+func TestNewUserCode(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	// 255 超出 20 的整數倍範圍會被捨棄，其餘依序對應 charset
+	seq := []byte{255, 0, 1, 2, 3, 4, 5, 6, 19}
+	randRead = func(b []byte) (int, error) {
+		b[0], seq = seq[0], seq[1:]
+		return 1, nil
+	}
+	code, err := newUserCode()
+	require.NoError(t, err)
+	require.Equal(t, "BCDF-GHJZ", code)
+	require.Equal(t, "BCDFGHJZ", normalizeUserCode(" bcdf-ghjz"))
+}
+
+func TestAllowUserCodeAttempt(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewMemoryCache(nil)
+
+	for range userCodeAttemptUserLimit {
+		require.NoError(t, AllowUserCodeAttempt(ctx, c, 1, "1.2.3.4"))
+	}
+	require.Equal(t, userCodeAttemptWindow, c.TTLs["user_code_rate:user:1"])
+	// 同一使用者換 IP 仍受限
+	require.ErrorIs(t, AllowUserCodeAttempt(ctx, c, 1, "5.6.7.8"), ErrRateLimited)
+
+	// 同一 IP 換使用者仍受限
+	for i := range userCodeAttemptIPLimit - userCodeAttemptUserLimit {
+		require.NoError(t, AllowUserCodeAttempt(ctx, c, 100+i, "1.2.3.4"))
+	}
+	require.ErrorIs(t, AllowUserCodeAttempt(ctx, c, 2, "1.2.3.4"), ErrRateLimited)
+
+	c.FailOn["incr"] = "user_code_rate:"
+	require.ErrorContains(t, AllowUserCodeAttempt(ctx, c, 3, "9.9.9.9"), "failed to record rate limit")
+}
+
+func TestDeviceAuthorizationDecision(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+
+	stored := func(d DeviceAuthorizationData) string {
+		b, _ := json.Marshal(d)
+		return string(b)
+	}
+	pending := DeviceAuthorizationData{ClientID: "cid", Scope: "openid", UserCode: "BCDF-GHJK", Status: DeviceStatusPending, Interval: 5, ExpiresAt: now.Add(time.Minute).Unix()}
+	newCache := func(d DeviceAuthorizationData) *cache.MemoryCache {
+		return cache.NewMemoryCache(map[string]string{
+			"device_user_code:BCDFGHJK": "dc",
+			"device_code:dc":            stored(d),
+		})
+	}
+
+	t.Run("lookup", func(t *testing.T) {
+		data, dc, err := LookupDeviceAuthorization(ctx, newCache(pending), "bcdf ghjk")
+		require.NoError(t, err)
+		require.Equal(t, "dc", dc)
+		require.Equal(t, pending, *data)
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		rc := newCache(pending)
+		require.NoError(t, DecideDeviceAuthorization(ctx, rc, "BCDF-GHJK", 7, true, Authentication{}))
+		require.NotContains(t, rc.Data, "device_user_code:BCDFGHJK")
+		var data DeviceAuthorizationData
+		require.NoError(t, json.Unmarshal([]byte(rc.Data["device_code:dc"]), &data))
+		require.Equal(t, DeviceStatusApproved, data.Status)
+		require.Equal(t, 7, data.UserID)
+
+		err := DecideDeviceAuthorization(ctx, rc, "BCDF-GHJK", 7, true, Authentication{})
+		require.ErrorIs(t, err, ErrUserCodeNotFound)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		rc := newCache(pending)
+		require.NoError(t, DecideDeviceAuthorization(ctx, rc, "BCDF-GHJK", 7, false, Authentication{}))
+		var data DeviceAuthorizationData
+		require.NoError(t, json.Unmarshal([]byte(rc.Data["device_code:dc"]), &data))
+		require.Equal(t, DeviceStatusDenied, data.Status)
+		require.Zero(t, data.UserID)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, _, err := LookupDeviceAuthorization(ctx, cache.NewMemoryCache(nil), "x")
+		require.ErrorIs(t, err, ErrUserCodeNotFound)
+
+		rc := cache.NewMemoryCache(map[string]string{"device_user_code:BCDFGHJK": "dc"})
+		_, _, err = LookupDeviceAuthorization(ctx, rc, "BCDF-GHJK")
+		require.ErrorIs(t, err, ErrUserCodeNotFound)
+
+		expired := pending
+		expired.ExpiresAt = now.Unix()
+		_, _, err = LookupDeviceAuthorization(ctx, newCache(expired), "BCDF-GHJK")
+		require.ErrorIs(t, err, ErrUserCodeNotFound)
+	})
+
+	t.Run("already decided", func(t *testing.T) {
+		decided := pending
+		decided.Status = DeviceStatusDenied
+		err := DecideDeviceAuthorization(ctx, newCache(decided), "BCDF-GHJK", 7, true, Authentication{})
+		require.ErrorIs(t, err, ErrDeviceAlreadyDecided)
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		for _, tc := range []struct{ op, prefix string }{
+			{"get", "device_user_code:"},
+			{"get", "device_code:"},
+			{"set", "device_code:"},
+			{"del", "device_user_code:"},
+		} {
+			rc := newCache(pending)
+			rc.FailOn[tc.op] = tc.prefix
+			err := DecideDeviceAuthorization(ctx, rc, "BCDF-GHJK", 7, true, Authentication{})
+			require.Error(t, err, tc)
+			require.NotErrorIs(t, err, ErrUserCodeNotFound)
+		}
+
+		rc := cache.NewMemoryCache(map[string]string{"device_user_code:BCDFGHJK": "dc", "device_code:dc": "{"})
+		_, _, err := LookupDeviceAuthorization(ctx, rc, "BCDF-GHJK")
+		require.Error(t, err)
+	})
+}
+
+func TestPollDeviceAuthorization(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+
+	stored := func(d DeviceAuthorizationData) string {
+		b, _ := json.Marshal(d)
+		return string(b)
+	}
+	pending := DeviceAuthorizationData{ClientID: "cid", Scope: "openid", UserCode: "BCDF-GHJK", Status: DeviceStatusPending, Interval: 5, ExpiresAt: now.Add(time.Minute).Unix()}
+	newCache := func(d DeviceAuthorizationData) *cache.MemoryCache {
+		return cache.NewMemoryCache(map[string]string{"device_code:dc": stored(d)})
+	}
+	load := func(rc *cache.MemoryCache) devicePollState {
+		var poll devicePollState
+		require.NoError(t, json.Unmarshal([]byte(rc.Data["device_code_poll:dc"]), &poll))
+		return poll
+	}
+
+	t.Run("pending then slow down", func(t *testing.T) {
+		rc := newCache(pending)
+		_, err := PollDeviceAuthorization(ctx, rc, "cid", "dc")
+		require.ErrorIs(t, err, ErrAuthorizationPending)
+		require.Equal(t, devicePollState{Interval: 5, LastPolledAt: now.Unix()}, load(rc))
+		require.Equal(t, time.Minute, rc.TTLs["device_code_poll:dc"])
+		require.Equal(t, stored(pending), rc.Data["device_code:dc"])
+
+		_, err = PollDeviceAuthorization(ctx, rc, "cid", "dc")
+		require.ErrorIs(t, err, ErrSlowDown)
+		require.Equal(t, 10, load(rc).Interval)
+
+		// 等待超過新的間隔後恢復為 pending
+		later := now.Add(10 * time.Second)
+		timeNow = func() time.Time { return later }
+		t.Cleanup(func() { timeNow = func() time.Time { return now } })
+		_, err = PollDeviceAuthorization(ctx, rc, "cid", "dc")
+		require.ErrorIs(t, err, ErrAuthorizationPending)
+		require.Equal(t, 10, load(rc).Interval)
+	})
+
+	t.Run("approved", func(t *testing.T) {
+		approved := pending
+		approved.Status = DeviceStatusApproved
+		approved.UserID = 7
+		rc := newCache(approved)
+		data, err := PollDeviceAuthorization(ctx, rc, "cid", "dc")
+		require.NoError(t, err)
+		require.Equal(t, 7, data.UserID)
+		require.NotContains(t, rc.Data, "device_code:dc")
+
+		_, err = PollDeviceAuthorization(ctx, rc, "cid", "dc")
+		require.ErrorIs(t, err, ErrDeviceCodeNotFound)
+	})
+
+	t.Run("decided while polling", func(t *testing.T) {
+		// 輪詢讀取授權狀態後使用者才核准：輪詢不可覆蓋核准結果
+		rc := newCache(pending)
+		approved := pending
+		approved.Status = DeviceStatusApproved
+		approved.UserID = 7
+		fc := &cache.FakeCache{
+			GetFn: func(ctx context.Context, key string) *redis.StringCmd {
+				res := rc.Get(ctx, key)
+				if key == "device_code:dc" {
+					rc.Data[key] = stored(approved)
+				}
+				return res
+			},
+			SetFn: rc.Set,
+		}
+		_, err := PollDeviceAuthorization(ctx, fc, "cid", "dc")
+		require.ErrorIs(t, err, ErrAuthorizationPending)
+
+		data, err := PollDeviceAuthorization(ctx, rc, "cid", "dc")
+		require.NoError(t, err)
+		require.Equal(t, 7, data.UserID)
+	})
+
+	t.Run("approved concurrently redeemed", func(t *testing.T) {
+		approved := pending
+		approved.Status = DeviceStatusApproved
+		rc := newCache(approved)
+		fc := &cache.FakeCache{
+			GetFn: func(ctx context.Context, key string) *redis.StringCmd {
+				res := rc.Get(ctx, key)
+				delete(rc.Data, key)
+				return res
+			},
+			SetFn: rc.Set,
+			DelFn: rc.Del,
+		}
+		_, err := PollDeviceAuthorization(ctx, fc, "cid", "dc")
+		require.ErrorIs(t, err, ErrDeviceCodeNotFound)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		denied := pending
+		denied.Status = DeviceStatusDenied
+		_, err := PollDeviceAuthorization(ctx, newCache(denied), "cid", "dc")
+		require.ErrorIs(t, err, ErrDeviceAccessDenied)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired := pending
+		expired.ExpiresAt = now.Unix()
+		_, err := PollDeviceAuthorization(ctx, newCache(expired), "cid", "dc")
+		require.ErrorIs(t, err, ErrDeviceCodeExpired)
+	})
+
+	t.Run("other client", func(t *testing.T) {
+		_, err := PollDeviceAuthorization(ctx, newCache(pending), "other", "dc")
+		require.ErrorIs(t, err, ErrDeviceCodeNotFound)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := PollDeviceAuthorization(ctx, cache.NewMemoryCache(nil), "cid", "dc")
+		require.ErrorIs(t, err, ErrDeviceCodeNotFound)
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		rc := newCache(pending)
+		rc.FailOn["get"] = "device_code:"
+		_, err := PollDeviceAuthorization(ctx, rc, "cid", "dc")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrDeviceCodeNotFound)
+
+		for _, op := range []string{"get", "set"} {
+			rc = newCache(pending)
+			rc.FailOn[op] = "device_code_poll:"
+			_, err = PollDeviceAuthorization(ctx, rc, "cid", "dc")
+			require.Error(t, err, op)
+			require.NotErrorIs(t, err, ErrAuthorizationPending)
+		}
+
+		rc = newCache(pending)
+		rc.Data["device_code_poll:dc"] = "{"
+		_, err = PollDeviceAuthorization(ctx, rc, "cid", "dc")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrAuthorizationPending)
+
+		approved := pending
+		approved.Status = DeviceStatusApproved
+		rc = newCache(approved)
+		rc.FailOn["del"] = "device_code:"
+		_, err = PollDeviceAuthorization(ctx, rc, "cid", "dc")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrDeviceCodeNotFound)
+	})
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// DPoPProofType 為 RFC 9449 §4.2 DPoP proof 的 typ header
+const DPoPProofType = "dpop+jwt"
+
+const (
+	// dpopProofLifetime 為 proof 的 iat 可接受的最大經過時間，也是 jti 保留在快取中的期間
+	dpopProofLifetime = 5 * time.Minute
+	// dpopClockSkew 容許 client 時鐘略快於伺服器
+	dpopClockSkew = time.Minute
+	// dpopNonceTTL 為伺服器提供的 nonce 有效期間
+	dpopNonceTTL = 5 * time.Minute
+)
+
+var (
+	ErrInvalidDPoPProof = errors.New("invalid DPoP proof")
+	// ErrUseDPoPNonce 表示 proof 未帶有效的伺服器 nonce，client 應以新的 nonce 重送（RFC 9449 §8）
+	ErrUseDPoPNonce = errors.New("DPoP nonce required")
+)
+
+// DPoPRequest 為 DPoP proof 所對應的 HTTP 請求
+type DPoPRequest struct {
+	Method string
+	// URL 為請求的網址，比對 htu 時忽略 query 與 fragment
+	URL string
+	// AccessToken 不為空時要求 proof 的 ath 為其雜湊（存取受保護資源時）
+	AccessToken string
+	// RequireNonce 要求 proof 帶有以 IssueDPoPNonce 核發且尚未過期的 nonce
+	RequireNonce bool
+}
+
+// dpopClaims 為 RFC 9449 §4.2 DPoP proof 的 claims
+type dpopClaims struct {
+	HTM   string `json:"htm"`
+	HTU   string `json:"htu"`
+	ATH   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// DPoPSigningAlgorithms 回傳 DPoP proof 可使用的簽章演算法；proof 只接受非對稱金鑰
+func DPoPSigningAlgorithms() []string {
+	return slices.Clone(clientAssertionKeyAlgs)
+}
+
+// IssueDPoPNonce 產生伺服器 nonce 並存入快取，client 須於後續 proof 的 nonce claim 帶回
+func IssueDPoPNonce(ctx context.Context, cache cache.Cache) (string, error) {
+	nonce, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	if err := cache.Set(ctx, fmt.Sprintf("dpop_nonce:%s", nonce), "1", dpopNonceTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed to store DPoP nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+// VerifyDPoPProof 依 RFC 9449 §4.3 驗證 DPoP proof：typ 須為 dpop+jwt、以 header 中 jwk 的公鑰簽章，
+// htm 與 htu 須對應 req，iat 須在效期內，且同一金鑰的 jti 只能使用一次。回傳公鑰的 JWK thumbprint（jkt）
+func VerifyDPoPProof(ctx context.Context, cache cache.Cache, proof string, req DPoPRequest) (string, error) {
+	var jkt string
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != DPoPProofType {
+			return nil, fmt.Errorf("typ must be %s", DPoPProofType)
+		}
+		jwk, err := dpopProofKey(t.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		public, err := parsePublicJWK(jwk)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk: %w", err)
+		}
+		if jkt, err = JWKThumbprint(jwk); err != nil {
+			return nil, err
+		}
+		return public, nil
+	}
+	var claims dpopClaims
+	if _, err := parseWithClaims(proof, &claims, keyFunc,
+		jwt.WithValidMethods(clientAssertionKeyAlgs),
+		jwt.WithTimeFunc(timeNow),
+	); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidDPoPProof, err)
+	}
+
+	now := timeNow()
+	switch {
+	case claims.ID == "":
+		return "", fmt.Errorf("%w: missing jti", ErrInvalidDPoPProof)
+	case claims.IssuedAt == nil:
+		return "", fmt.Errorf("%w: missing iat", ErrInvalidDPoPProof)
+	case claims.IssuedAt.Time.Before(now.Add(-dpopProofLifetime)) || claims.IssuedAt.Time.After(now.Add(dpopClockSkew)):
+		return "", fmt.Errorf("%w: iat is outside the acceptable window", ErrInvalidDPoPProof)
+	case claims.HTM != req.Method:
+		return "", fmt.Errorf("%w: htm mismatch", ErrInvalidDPoPProof)
+	case !sameHTU(claims.HTU, req.URL):
+		return "", fmt.Errorf("%w: htu mismatch", ErrInvalidDPoPProof)
+	}
+	if req.AccessToken != "" {
+		sum := sha256.Sum256([]byte(req.AccessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", fmt.Errorf("%w: ath mismatch", ErrInvalidDPoPProof)
+		}
+	}
+	if req.RequireNonce {
+		if claims.Nonce == "" {
+			return "", ErrUseDPoPNonce
+		}
+		if err := cache.Get(ctx, fmt.Sprintf("dpop_nonce:%s", claims.Nonce)).Err(); err == redis.Nil {
+			return "", ErrUseDPoPNonce
+		} else if err != nil {
+			return "", fmt.Errorf("failed to check DPoP nonce: %w", err)
+		}
+	}
+
+	// 與 client assertion 相同，以 SETNX 原子地記錄 jti，併發重送同一 proof 時僅有一方通過
+	key := fmt.Sprintf("dpop_jti:%s:%s", jkt, claims.ID)
+	stored, err := cache.SetNX(ctx, key, "1", dpopProofLifetime+dpopClockSkew).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to store DPoP proof jti: %w", err)
+	}
+	if !stored {
+		return "", fmt.Errorf("%w: jti already used", ErrInvalidDPoPProof)
+	}
+	return jkt, nil
+}
+
+// dpopProofKey 取出 proof header 中的 jwk；不得包含私鑰參數
+func dpopProofKey(header any) (JSONWebKey, error) {
+	var jwk JSONWebKey
+	raw, ok := header.(map[string]any)
+	if !ok {
+		return jwk, fmt.Errorf("missing jwk header")
+	}
+	if _, private := raw["d"]; private {
+		return jwk, fmt.Errorf("jwk must not contain a private key")
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return jwk, fmt.Errorf("invalid jwk: %w", err)
+	}
+	if err := json.Unmarshal(b, &jwk); err != nil {
+		return jwk, fmt.Errorf("invalid jwk: %w", err)
+	}
+	return jwk, nil
+}
+
+// JWKThumbprint 依 RFC 7638 計算公鑰的 SHA-256 thumbprint（base64url），作為 cnf.jkt
+func JWKThumbprint(k JSONWebKey) (string, error) {
+	// 必要成員依字典序排列；參數皆為 base64url 或曲線名稱，不需額外跳脫
+	var members string
+	switch k.KeyType {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Curve, k.X, k.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Curve, k.X)
+	default:
+		return "", fmt.Errorf("unsupported key type: %q", k.KeyType)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// sameHTU 比對 htu 與請求網址：scheme 與 host 不分大小寫，忽略 query 與 fragment
+func sameHTU(htu, requestURL string) bool {
+	a, errA := url.Parse(htu)
+	b, errB := url.Parse(requestURL)
+	if errA != nil || errB != nil || !a.IsAbs() {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.EscapedPath() == b.EscapedPath()
+}
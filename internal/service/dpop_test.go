@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// signDPoPProof 以 key 簽發 DPoP proof，header 帶入公鑰的 jwk；modify 可在簽章前調整 header
+func signDPoPProof(t *testing.T, key testClientKey, claims dpopClaims, modify func(map[string]any)) string {
+	t.Helper()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["typ"] = DPoPProofType
+	token.Header["jwk"] = mustJWKMap(t, key.jwk())
+	if modify != nil {
+		modify(token.Header)
+	}
+	s, err := token.SignedString(key.signer)
+	require.NoError(t, err)
+	return s
+}
+
+// mustJWKMap 將 JWK 轉為 JWT header 使用的 map
+func mustJWKMap(t *testing.T, k JSONWebKey) map[string]any {
+	t.Helper()
+	b, err := json.Marshal(k)
+	require.NoError(t, err)
+	var m map[string]any
+	require.NoError(t, json.Unmarshal(b, &m))
+	return m
+}
+
+func TestDPoPSigningAlgorithms(t *testing.T) {
+	algs := DPoPSigningAlgorithms()
+	require.Contains(t, algs, "ES256")
+	require.NotContains(t, algs, "HS256")
+}
+
+func TestIssueDPoPNonce(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	rc := cache.NewMemoryCache(nil)
+	nonce, err := IssueDPoPNonce(ctx, rc)
+	require.NoError(t, err)
+	require.Equal(t, dpopNonceTTL, rc.TTLs["dpop_nonce:"+nonce])
+
+	rc.FailOn["set"] = "dpop_nonce:"
+	_, err = IssueDPoPNonce(ctx, rc)
+	require.ErrorContains(t, err, "failed to store DPoP nonce")
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	_, err = IssueDPoPNonce(ctx, rc)
+	require.Error(t, err)
+}
+
+func TestVerifyDPoPProof(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+
+	key := newTestClientKey(t, "", jwt.SigningMethodES256)
+	jkt, err := JWKThumbprint(key.jwk())
+	require.NoError(t, err)
+	const tokenURL = "https://auth.example.com/api/oauth/token"
+	claims := func(jti string) dpopClaims {
+		return dpopClaims{
+			HTM:              "POST",
+			HTU:              tokenURL,
+			RegisteredClaims: jwt.RegisteredClaims{ID: jti, IssuedAt: jwt.NewNumericDate(now)},
+		}
+	}
+	req := DPoPRequest{Method: "POST", URL: tokenURL}
+
+	t.Run("valid proof", func(t *testing.T) {
+		rc := cache.NewMemoryCache(nil)
+		proof := signDPoPProof(t, key, claims("j1"), nil)
+		got, err := VerifyDPoPProof(ctx, rc, proof, req)
+		require.NoError(t, err)
+		require.Equal(t, jkt, got)
+		require.Equal(t, dpopProofLifetime+dpopClockSkew, rc.TTLs["dpop_jti:"+jkt+":j1"])
+
+		// 同一把金鑰的 jti 不可重送
+		_, err = VerifyDPoPProof(ctx, rc, proof, req)
+		require.ErrorIs(t, err, ErrInvalidDPoPProof)
+		require.Contains(t, err.Error(), "jti already used")
+	})
+
+	t.Run("htu ignores query and host case", func(t *testing.T) {
+		proof := signDPoPProof(t, key, claims("j"), nil)
+		_, err := VerifyDPoPProof(ctx, cache.NewMemoryCache(nil), proof,
+			DPoPRequest{Method: "POST", URL: "https://AUTH.example.com/api/oauth/token?x=1"})
+		require.NoError(t, err)
+	})
+
+	t.Run("access token hash", func(t *testing.T) {
+		sum := sha256.Sum256([]byte("access"))
+		c := claims("j")
+		c.ATH = base64.RawURLEncoding.EncodeToString(sum[:])
+		proof := signDPoPProof(t, key, c, nil)
+		_, err := VerifyDPoPProof(ctx, cache.NewMemoryCache(nil), proof,
+			DPoPRequest{Method: "POST", URL: tokenURL, AccessToken: "access"})
+		require.NoError(t, err)
+
+		_, err = VerifyDPoPProof(ctx, cache.NewMemoryCache(nil), proof,
+			DPoPRequest{Method: "POST", URL: tokenURL, AccessToken: "other"})
+		require.ErrorIs(t, err, ErrInvalidDPoPProof)
+		require.Contains(t, err.Error(), "ath mismatch")
+	})
+
+	t.Run("nonce", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{"dpop_nonce:n1": "1"})
+		nonceReq := req
+		nonceReq.RequireNonce = true
+
+		_, err := VerifyDPoPProof(ctx, rc, signDPoPProof(t, key, claims("a"), nil), nonceReq)
+		require.ErrorIs(t, err, ErrUseDPoPNonce)
+
+		c := claims("b")
+		c.Nonce = "expired"
+		_, err = VerifyDPoPProof(ctx, rc, signDPoPProof(t, key, c, nil), nonceReq)
+		require.ErrorIs(t, err, ErrUseDPoPNonce)
+
+		c = claims("c")
+		c.Nonce = "n1"
+		_, err = VerifyDPoPProof(ctx, rc, signDPoPProof(t, key, c, nil), nonceReq)
+		require.NoError(t, err)
+
+		rc.FailOn["get"] = "dpop_nonce:"
+		c.ID = "d"
+		_, err = VerifyDPoPProof(ctx, rc, signDPoPProof(t, key, c, nil), nonceReq)
+		require.ErrorContains(t, err, "failed to check DPoP nonce")
+	})
+
+	t.Run("invalid proofs", func(t *testing.T) {
+		hmacProof, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims("j")).SignedString([]byte("k"))
+		require.NoError(t, err)
+		other := newTestClientKey(t, "", jwt.SigningMethodES256)
+		withClaims := func(f func(*dpopClaims)) string {
+			c := claims("j")
+			f(&c)
+			return signDPoPProof(t, key, c, nil)
+		}
+		withHeader := func(f func(map[string]any)) string {
+			return signDPoPProof(t, key, claims("j"), f)
+		}
+		cases := map[string]struct {
+			proof string
+			msg   string
+		}{
+			"malformed":      {"not-a-jwt", ""},
+			"hmac":           {hmacProof, "signing method HS256 is invalid"},
+			"typ":            {withHeader(func(h map[string]any) { h["typ"] = "JWT" }), "typ must be"},
+			"missing jwk":    {withHeader(func(h map[string]any) { delete(h, "jwk") }), "missing jwk"},
+			"private jwk":    {withHeader(func(h map[string]any) { h["jwk"].(map[string]any)["d"] = "x" }), "private key"},
+			"bad jwk":        {withHeader(func(h map[string]any) { h["jwk"].(map[string]any)["x"] = 1 }), "invalid jwk"},
+			"unsupported kt": {withHeader(func(h map[string]any) { h["jwk"].(map[string]any)["kty"] = "oct" }), "invalid jwk"},
+			"wrong key":      {withHeader(func(h map[string]any) { h["jwk"] = mustJWKMap(t, other.jwk()) }), "signature"},
+			"missing jti":    {withClaims(func(c *dpopClaims) { c.ID = "" }), "missing jti"},
+			"missing iat":    {withClaims(func(c *dpopClaims) { c.IssuedAt = nil }), "missing iat"},
+			"stale iat":      {withClaims(func(c *dpopClaims) { c.IssuedAt = jwt.NewNumericDate(now.Add(-6 * time.Minute)) }), "iat"},
+			"future iat":     {withClaims(func(c *dpopClaims) { c.IssuedAt = jwt.NewNumericDate(now.Add(2 * time.Minute)) }), "iat"},
+			"htm":            {withClaims(func(c *dpopClaims) { c.HTM = "GET" }), "htm mismatch"},
+			"htu":            {withClaims(func(c *dpopClaims) { c.HTU = "https://auth.example.com/other" }), "htu mismatch"},
+			"relative htu":   {withClaims(func(c *dpopClaims) { c.HTU = "/api/oauth/token" }), "htu mismatch"},
+		}
+		for name, tc := range cases {
+			_, err := VerifyDPoPProof(ctx, cache.NewMemoryCache(nil), tc.proof, req)
+			require.ErrorIs(t, err, ErrInvalidDPoPProof, name)
+			require.Contains(t, err.Error(), tc.msg, name)
+		}
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		rc := cache.NewMemoryCache(nil)
+		rc.FailOn["setnx"] = "dpop_jti:"
+		_, err := VerifyDPoPProof(ctx, rc, signDPoPProof(t, key, claims("j"), nil), req)
+		require.ErrorContains(t, err, "failed to store DPoP proof jti")
+	})
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// RFC 7638 §3.1 的範例
+	got, err := JWKThumbprint(JSONWebKey{
+		KeyType: "RSA",
+		N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:       "AQAB",
+		KeyID:   "2011-04-29",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", got)
+
+	for _, k := range []testClientKey{
+		newTestClientKey(t, "", jwt.SigningMethodES256),
+		newTestClientKey(t, "", jwt.SigningMethodEdDSA),
+	} {
+		got, err := JWKThumbprint(k.jwk())
+		require.NoError(t, err)
+		require.Len(t, got, 43)
+	}
+
+	_, err = JWKThumbprint(JSONWebKey{KeyType: "oct"})
+	require.Error(t, err)
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/mailer"
+	"life-is-hard/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// emailVerificationTTL 為驗證信中 token 的效期
+	emailVerificationTTL = 24 * time.Hour
+	// emailVerificationAudience 區隔驗證 token 與 access token，避免兩者互相冒用
+	emailVerificationAudience = "email_verification"
+)
+
+var (
+	ErrInvalidEmailVerificationToken = errors.New("invalid or expired verification token")
+
+	// mailSender 為寄送通知信的 Mailer；未設定時無法寄信
+	mailSender mailer.Mailer
+	// emailVerificationURL 為驗證信中的連結，token 以查詢參數附加
+	emailVerificationURL string
+)
+
+// emailVerificationClaims 為驗證 token 的 claims；sub 為使用者 ID，email 為寄送當下待驗證的 Email
+type emailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// UseMailer 設定寄送通知信的 Mailer，傳入 nil 則停用寄信
+func UseMailer(m mailer.Mailer) {
+	mailSender = m
+}
+
+// UseEmailVerificationURL 設定驗證信中的連結；空白時信中只附上 token
+func UseEmailVerificationURL(u string) {
+	emailVerificationURL = u
+}
+
+// IssueEmailVerificationToken 簽發綁定使用者與目前 Email 的驗證 token，並登記 jti 確保只能使用一次
+func IssueEmailVerificationToken(ctx context.Context, cache cache.Cache, user model.User) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := timeNow()
+	token, err := signClaims(ctx, emailVerificationClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  jwt.ClaimStrings{emailVerificationAudience},
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(emailVerificationTTL)),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign verification token: %w", err)
+	}
+	key := fmt.Sprintf("email_verification:%s", jti)
+	if err := cache.Set(ctx, key, strconv.Itoa(user.ID), emailVerificationTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed to store verification token: %w", err)
+	}
+	return token, nil
+}
+
+// ConsumeEmailVerificationToken 驗證簽章與效期並作廢 token，回傳使用者 ID 與 token 對應的 Email
+func ConsumeEmailVerificationToken(ctx context.Context, cache cache.Cache, token string) (int, string, error) {
+	keyFunc, err := verificationKeyFunc(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	claims := &emailVerificationClaims{}
+	if _, err := parseWithClaims(token, claims, keyFunc,
+		jwt.WithAudience(emailVerificationAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(timeNow),
+	); err != nil {
+		return 0, "", ErrInvalidEmailVerificationToken
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || claims.ID == "" || claims.Email == "" {
+		return 0, "", ErrInvalidEmailVerificationToken
+	}
+	// 僅有成功刪除 jti 的呼叫者可以使用此 token
+	deleted, err := cache.Del(ctx, fmt.Sprintf("email_verification:%s", claims.ID)).Result()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to consume verification token: %w", err)
+	}
+	if deleted == 0 {
+		return 0, "", ErrInvalidEmailVerificationToken
+	}
+	return userID, claims.Email, nil
+}
+
+// SendEmailVerification 簽發驗證 token 並寄送驗證信至使用者目前的 Email
+func SendEmailVerification(ctx context.Context, cache cache.Cache, user model.User) error {
+	if mailSender == nil {
+		return fmt.Errorf("mailer not configured")
+	}
+	token, err := IssueEmailVerificationToken(ctx, cache, user)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("%s 您好：\n\n請在 %d 小時內完成 Email 驗證。\n\n", user.Name, int(emailVerificationTTL.Hours()))
+	if emailVerificationURL != "" {
+		link, err := url.Parse(emailVerificationURL)
+		if err != nil {
+			return fmt.Errorf("invalid email verification URL: %w", err)
+		}
+		q := link.Query()
+		q.Set("token", token)
+		link.RawQuery = q.Encode()
+		body += fmt.Sprintf("請開啟以下連結：\n%s\n", link)
+	} else {
+		body += fmt.Sprintf("驗證碼：\n%s\n", token)
+	}
+	if err := mailSender.Send(ctx, mailer.Message{To: user.Email, Subject: "請驗證您的 Email", Body: body}); err != nil {
+		return fmt.Errorf("failed to send verification mail: %w", err)
+	}
+	return nil
+}
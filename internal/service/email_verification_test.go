@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/mailer"
+	"life-is-hard/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failingMailer 寄送時一律失敗
+type failingMailer struct{}
+
+func (failingMailer) Send(context.Context, mailer.Message) error { return errors.New("smtp down") }
+
+func TestEmailVerificationToken(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	t.Setenv("JWT_SECRET", "s")
+	ctx := context.Background()
+	user := model.User{ID: 7, Name: "alice", Email: "alice@example.com"}
+	c := cache.NewMemoryCache(nil)
+
+	token, err := IssueEmailVerificationToken(ctx, c, user)
+	require.NoError(t, err)
+	require.Len(t, c.Data, 1)
+	for key := range c.Data {
+		require.True(t, strings.HasPrefix(key, "email_verification:"))
+		require.Equal(t, emailVerificationTTL, c.TTLs[key])
+	}
+
+	userID, email, err := ConsumeEmailVerificationToken(ctx, c, token)
+	require.NoError(t, err)
+	require.Equal(t, 7, userID)
+	require.Equal(t, "alice@example.com", email)
+	require.Empty(t, c.Data)
+
+	// token 只能使用一次
+	_, _, err = ConsumeEmailVerificationToken(ctx, c, token)
+	require.ErrorIs(t, err, ErrInvalidEmailVerificationToken)
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		accessToken, err := IssueAccessToken(ctx, user, "", "", nil, time.Hour, nil, Authentication{})
+		require.NoError(t, err)
+		for name, tok := range map[string]string{
+			"garbage":      "garbage",
+			"access token": accessToken,
+			"tampered":     token[:len(token)-2] + "xx",
+		} {
+			_, _, err := ConsumeEmailVerificationToken(ctx, c, tok)
+			require.ErrorIs(t, err, ErrInvalidEmailVerificationToken, name)
+		}
+
+		token, err := IssueEmailVerificationToken(ctx, c, user)
+		require.NoError(t, err)
+		timeNow = func() time.Time { return time.Now().Add(emailVerificationTTL + time.Minute) }
+		_, _, err = ConsumeEmailVerificationToken(ctx, c, token)
+		require.ErrorIs(t, err, ErrInvalidEmailVerificationToken)
+		timeNow = time.Now
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		c := cache.NewMemoryCache(nil)
+		c.FailOn["set"] = "email_verification:"
+		_, err := IssueEmailVerificationToken(ctx, c, user)
+		require.ErrorContains(t, err, "failed to store verification token")
+
+		c = cache.NewMemoryCache(nil)
+		token, err := IssueEmailVerificationToken(ctx, c, user)
+		require.NoError(t, err)
+		c.FailOn["del"] = "email_verification:"
+		_, _, err = ConsumeEmailVerificationToken(ctx, c, token)
+		require.ErrorContains(t, err, "failed to consume verification token")
+	})
+
+	t.Run("signing errors", func(t *testing.T) {
+		t.Setenv("JWT_SECRET", "")
+		_, err := IssueEmailVerificationToken(ctx, c, user)
+		require.Error(t, err)
+		_, _, err = ConsumeEmailVerificationToken(ctx, c, token)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrInvalidEmailVerificationToken)
+
+		t.Setenv("JWT_SECRET", "s")
+		randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+		_, err = IssueEmailVerificationToken(ctx, c, user)
+		require.Error(t, err)
+	})
+}
+
+func TestSendEmailVerification(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	t.Setenv("JWT_SECRET", "s")
+	ctx := context.Background()
+	user := model.User{ID: 7, Name: "alice", Email: "alice@example.com"}
+	c := cache.NewMemoryCache(nil)
+
+	require.ErrorContains(t, SendEmailVerification(ctx, c, user), "mailer not configured")
+
+	outbox := mailer.NewOutbox("")
+	UseMailer(outbox)
+	require.NoError(t, SendEmailVerification(ctx, c, user))
+	msg := outbox.Messages()[0]
+	require.Equal(t, "alice@example.com", msg.To)
+	require.Contains(t, msg.Body, "驗證碼")
+
+	UseEmailVerificationURL("https://app.example.com/verify-email?lang=zh")
+	require.NoError(t, SendEmailVerification(ctx, c, user))
+	body := outbox.Messages()[1].Body
+	start := strings.Index(body, "https://")
+	link, err := url.Parse(strings.TrimSpace(body[start:]))
+	require.NoError(t, err)
+	require.Equal(t, "zh", link.Query().Get("lang"))
+	userID, _, err := ConsumeEmailVerificationToken(ctx, c, link.Query().Get("token"))
+	require.NoError(t, err)
+	require.Equal(t, 7, userID)
+
+	UseEmailVerificationURL("://bad")
+	require.ErrorContains(t, SendEmailVerification(ctx, c, user), "invalid email verification URL")
+
+	UseEmailVerificationURL("")
+	UseMailer(failingMailer{})
+	require.ErrorContains(t, SendEmailVerification(ctx, c, user), "smtp down")
+
+	t.Setenv("JWT_SECRET", "")
+	require.Error(t, SendEmailVerification(ctx, c, user))
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"life-is-hard/internal/cache"
+)
+
+// TokenIntrospection 為 RFC 7662 introspection 的結果；Active 為 false 時其餘欄位皆為零值
+type TokenIntrospection struct {
+	Active    bool
+	TokenType string
+	Subject   string
+	ClientID  string
+	Scope     string
+	IsAdmin   bool
+	ExpiresAt int64
+	IssuedAt  int64
+	// Confirmation 為 access token 綁定的持有證明（RFC 8705 §3.2）
+	Confirmation *Confirmation
+	// Audience 與 Actor 僅出現在 token exchange 換得的 access token（RFC 8693 §2.2）
+	Audience []string
+	Actor    *Actor
+}
+
+type tokenIntrospector func(ctx context.Context, cache cache.Cache, token string) (*TokenIntrospection, error)
+
+// IntrospectToken 依 RFC 7662 查詢 token 狀態。hint 僅決定查找順序；
+// 無效、過期或已撤銷的 token 回傳 Active 為 false，僅在快取故障時回傳 error
+func IntrospectToken(ctx context.Context, cache cache.Cache, token, hint string) (*TokenIntrospection, error) {
+	lookups := []tokenIntrospector{
+		introspectRefreshToken,
+		introspectAccessToken,
+	}
+	if hint == "access_token" {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+	for _, lookup := range lookups {
+		result, err := lookup(ctx, cache, token)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			return result, nil
+		}
+	}
+	return &TokenIntrospection{Active: false}, nil
+}
+
+func introspectRefreshToken(ctx context.Context, cache cache.Cache, token string) (*TokenIntrospection, error) {
+	data, err := ValidateRefreshToken(ctx, cache, token)
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &TokenIntrospection{
+		Active:    true,
+		TokenType: "refresh_token",
+		Subject:   fmt.Sprint(data.UserID),
+		ClientID:  data.ClientID,
+		Scope:     data.Scope,
+		IsAdmin:   data.IsAdmin,
+		ExpiresAt: data.ExpiresAt,
+		IssuedAt:  data.IssuedAt,
+	}, nil
+}
+
+func introspectAccessToken(ctx context.Context, cache cache.Cache, token string) (*TokenIntrospection, error) {
+	claims, err := parseAccessToken(ctx, token)
+	if err != nil {
+		return nil, nil
+	}
+	revoked, err := IsAccessTokenRevoked(ctx, cache, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, nil
+	}
+	result := &TokenIntrospection{
+		Active:       true,
+		TokenType:    "access_token",
+		Subject:      claims.Subject,
+		ClientID:     claims.ClientID,
+		Scope:        claims.Scope,
+		IsAdmin:      claims.IsAdmin,
+		Confirmation: claims.Confirmation,
+		Audience:     claims.Audience,
+		Actor:        claims.Actor,
+	}
+	if claims.ExpiresAt != nil {
+		result.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		result.IssuedAt = claims.IssuedAt.Unix()
+	}
+	return result, nil
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospectToken(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	os.Setenv("JWT_SECRET", "s")
+	refreshData, _ := json.Marshal(RefreshTokenData{UserID: 7, ClientID: "cid", IsAdmin: true, Scope: "read", IssuedAt: 10, ExpiresAt: 20})
+	access, err := IssueAccessToken(ctx, model.User{ID: 3, IsAdmin: true}, "cid", "", nil, time.Hour, nil, Authentication{})
+	require.NoError(t, err)
+
+	// 依 key 前綴決定回傳：refresh_token 查詢結果由 refresh 控制，撤銷清單由 revoked 控制
+	newCache := func(refresh string, refreshErr error, revoked string, revokedErr error) *cache.FakeCache {
+		return &cache.FakeCache{GetFn: func(_ context.Context, key string) *redis.StringCmd {
+			if strings.HasPrefix(key, "refresh_token:") {
+				return redis.NewStringResult(refresh, refreshErr)
+			}
+			return redis.NewStringResult(revoked, revokedErr)
+		}}
+	}
+
+	t.Run("refresh token", func(t *testing.T) {
+		res, err := IntrospectToken(ctx, newCache(string(refreshData), nil, "", redis.Nil), "rt", "")
+		require.NoError(t, err)
+		require.Equal(t, &TokenIntrospection{
+			Active:    true,
+			TokenType: "refresh_token",
+			Subject:   "7",
+			ClientID:  "cid",
+			Scope:     "read",
+			IsAdmin:   true,
+			ExpiresAt: 20,
+			IssuedAt:  10,
+		}, res)
+	})
+
+	t.Run("refresh lookup error", func(t *testing.T) {
+		_, err := IntrospectToken(ctx, newCache("", errors.New("get"), "", redis.Nil), "rt", "")
+		require.Error(t, err)
+	})
+
+	t.Run("access token", func(t *testing.T) {
+		for _, hint := range []string{"", "access_token"} {
+			res, err := IntrospectToken(ctx, newCache("", redis.Nil, "", redis.Nil), access, hint)
+			require.NoError(t, err)
+			require.True(t, res.Active)
+			require.Equal(t, "access_token", res.TokenType)
+			require.Equal(t, "3", res.Subject)
+			require.Equal(t, "cid", res.ClientID)
+			require.True(t, res.IsAdmin)
+			require.Equal(t, res.IssuedAt+int64(time.Hour/time.Second), res.ExpiresAt)
+		}
+	})
+
+	t.Run("certificate-bound access token", func(t *testing.T) {
+		bound, err := IssueAccessToken(ctx, model.User{ID: 3}, "cid", "", nil, time.Hour, &Confirmation{X5tS256: "thumb"}, Authentication{})
+		require.NoError(t, err)
+		res, err := IntrospectToken(ctx, newCache("", redis.Nil, "", redis.Nil), bound, "access_token")
+		require.NoError(t, err)
+		require.Equal(t, &Confirmation{X5tS256: "thumb"}, res.Confirmation)
+	})
+
+	t.Run("exchanged access token", func(t *testing.T) {
+		subject := &CustomClaims{UserID: 3, RegisteredClaims: jwt.RegisteredClaims{Subject: "3"}}
+		exchanged, _, err := IssueExchangedAccessToken(ctx, model.OAuthClient{ClientID: "orders"}, subject, nil, []string{"billing"}, "", time.Hour, nil)
+		require.NoError(t, err)
+		res, err := IntrospectToken(ctx, newCache("", redis.Nil, "", redis.Nil), exchanged, "access_token")
+		require.NoError(t, err)
+		require.Equal(t, []string{"billing"}, res.Audience)
+		require.Equal(t, &Actor{Subject: "orders", ClientID: "orders"}, res.Actor)
+	})
+
+	t.Run("access token without timestamps", func(t *testing.T) {
+		tok, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, CustomClaims{UserID: 1}).SignedString([]byte("s"))
+		res, err := IntrospectToken(ctx, newCache("", redis.Nil, "", redis.Nil), tok, "access_token")
+		require.NoError(t, err)
+		require.True(t, res.Active)
+		require.Zero(t, res.ExpiresAt)
+		require.Zero(t, res.IssuedAt)
+	})
+
+	t.Run("revoked access token", func(t *testing.T) {
+		res, err := IntrospectToken(ctx, newCache("", redis.Nil, "1", nil), access, "access_token")
+		require.NoError(t, err)
+		require.Equal(t, &TokenIntrospection{Active: false}, res)
+	})
+
+	t.Run("revocation check error", func(t *testing.T) {
+		_, err := IntrospectToken(ctx, newCache("", redis.Nil, "", errors.New("get")), access, "access_token")
+		require.Error(t, err)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		res, err := IntrospectToken(ctx, newCache("", redis.Nil, "", redis.Nil), "garbage", "")
+		require.NoError(t, err)
+		require.False(t, res.Active)
+	})
+}
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/store"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// GrantTypeJWTBearer 為 RFC 7523 §2.1 以 JWT 換取 access token 的 grant type
+const GrantTypeJWTBearer = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// jwtBearerMaxLifetime 限制 assertion 的剩餘效期，也是 jti 保留在快取中的上限
+const jwtBearerMaxLifetime = time.Hour
+
+var (
+	ErrInvalidJWTBearerAssertion = errors.New("invalid assertion")
+
+	// trustedIssuers 為可簽發 JWT bearer assertion 的外部簽發者；未設定時停用此 grant
+	trustedIssuers []TrustedIssuer
+)
+
+// TrustedIssuer 為信任的 assertion 簽發者（例如 CI 系統）；驗證金鑰擇一設定 jwks、jwks_file 或 public_key_file
+type TrustedIssuer struct {
+	Issuer        string          `json:"issuer"`
+	JWKS          json.RawMessage `json:"jwks,omitempty"`
+	JWKSFile      string          `json:"jwks_file,omitempty"`
+	PublicKeyFile string          `json:"public_key_file,omitempty"`
+	// Audiences 為 assertion 須包含的 aud；未設定時為本服務的 issuer 或 token endpoint
+	Audiences []string         `json:"audiences,omitempty"`
+	Subjects  []SubjectMapping `json:"subjects"`
+
+	keys []clientKey
+}
+
+// SubjectMapping 將 assertion 的 sub 對應到可兌換的 client，以及 token 所代表的使用者
+type SubjectMapping struct {
+	// Subject 須與 sub 完全相符；以 * 結尾時比對前綴
+	Subject  string `json:"subject"`
+	ClientID string `json:"client_id"`
+	// Username 設定時 token 代表該使用者，否則如同 client_credentials 代表 client 自身
+	Username string `json:"username,omitempty"`
+	// Scopes 限制可取得的 scope；未設定時為 client 登記的全部 scope
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// LoadTrustedIssuers 讀取 JSON 格式的簽發者設定（{"issuers": [...]}），並載入各簽發者的驗證金鑰
+func LoadTrustedIssuers(path string) ([]TrustedIssuer, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted issuers: %w", err)
+	}
+	var cfg struct {
+		Issuers []TrustedIssuer `json:"issuers"`
+	}
+	if err := jsonUnmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid trusted issuers: %w", err)
+	}
+	seen := map[string]bool{}
+	for i := range cfg.Issuers {
+		iss := &cfg.Issuers[i]
+		if iss.Issuer == "" || seen[iss.Issuer] {
+			return nil, fmt.Errorf("invalid trusted issuer: missing or duplicate issuer %q", iss.Issuer)
+		}
+		seen[iss.Issuer] = true
+		if iss.keys, err = loadIssuerKeys(*iss); err != nil {
+			return nil, fmt.Errorf("invalid trusted issuer %q: %w", iss.Issuer, err)
+		}
+		for _, m := range iss.Subjects {
+			if m.Subject == "" || m.Subject == "*" || m.ClientID == "" {
+				return nil, fmt.Errorf("invalid trusted issuer %q: subjects require subject and client_id", iss.Issuer)
+			}
+			for _, s := range m.Scopes {
+				if !IsKnownScope(s) {
+					return nil, fmt.Errorf("invalid trusted issuer %q: invalid scope %q", iss.Issuer, s)
+				}
+			}
+		}
+	}
+	return cfg.Issuers, nil
+}
+
+// CheckTrustedIssuerClients 確認對應規則中的 client_id 皆為已註冊的 client；
+// 否則之後以該 client_id 註冊的 client 會取得對應規則授予的身分
+func CheckTrustedIssuerClients(ctx context.Context, db database.DB, issuers []TrustedIssuer) error {
+	for _, iss := range issuers {
+		for _, m := range iss.Subjects {
+			_, err := store.GetOAuthClientByClientID(ctx, db, m.ClientID)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("invalid trusted issuer %q: client_id %q is not registered", iss.Issuer, m.ClientID)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to retrieve client %q: %w", m.ClientID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ReservedByTrustedIssuer 回傳 clientID 是否為信任簽發者對應規則使用的 client_id；此類 client_id 不開放一般使用者註冊
+func ReservedByTrustedIssuer(clientID string) bool {
+	for _, iss := range trustedIssuers {
+		for _, m := range iss.Subjects {
+			if m.ClientID == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UseTrustedIssuers 設定 JWT bearer grant 信任的簽發者，傳入 nil 則停用
+func UseTrustedIssuers(issuers []TrustedIssuer) {
+	trustedIssuers = issuers
+}
+
+// VerifyJWTBearerAssertion 依 RFC 7523 §3 驗證 clientID 出示的 assertion：須由信任的簽發者簽署、
+// aud 須符合簽發者設定（未設定時為 audiences 之一）、必須帶 exp 與 jti 且同一 jti 只能使用一次，
+// sub 須有允許 clientID 兌換的對應規則。回傳第一個符合的規則
+func VerifyJWTBearerAssertion(ctx context.Context, cache cache.Cache, clientID, assertion string, audiences []string) (*SubjectMapping, error) {
+	var unverified jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, &unverified); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWTBearerAssertion, err)
+	}
+	idx := slices.IndexFunc(trustedIssuers, func(iss TrustedIssuer) bool { return iss.Issuer == unverified.Issuer })
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: untrusted issuer", ErrInvalidJWTBearerAssertion)
+	}
+	iss := trustedIssuers[idx]
+
+	var claims jwt.RegisteredClaims
+	if _, err := parseWithClaims(assertion, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		// public_key_file 載入的金鑰沒有 kid，不比對 assertion 帶的 kid
+		if len(iss.keys) == 1 && iss.keys[0].kid == "" {
+			kid = ""
+		}
+		return findClientKey(iss.keys, kid, t.Method.Alg())
+	},
+		jwt.WithValidMethods(clientAssertionKeyAlgs),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(iss.Issuer),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(timeNow),
+	); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWTBearerAssertion, err)
+	}
+	if len(iss.Audiences) > 0 {
+		audiences = iss.Audiences
+	}
+	if !slices.ContainsFunc(claims.Audience, func(aud string) bool { return slices.Contains(audiences, aud) }) {
+		return nil, fmt.Errorf("%w: audience mismatch", ErrInvalidJWTBearerAssertion)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrInvalidJWTBearerAssertion)
+	}
+	mapping := iss.subjectMapping(claims.Subject, clientID)
+	if mapping == nil {
+		return nil, fmt.Errorf("%w: subject not allowed for this client", ErrInvalidJWTBearerAssertion)
+	}
+	if claims.ID == "" {
+		return nil, fmt.Errorf("%w: missing jti", ErrInvalidJWTBearerAssertion)
+	}
+	ttl := claims.ExpiresAt.Sub(timeNow())
+	if ttl > jwtBearerMaxLifetime {
+		return nil, fmt.Errorf("%w: exp is too far in the future", ErrInvalidJWTBearerAssertion)
+	}
+
+	// 與 client assertion 相同，以 SETNX 原子地記錄 jti 防止重送
+	key := fmt.Sprintf("jwt_bearer_jti:%s:%s", iss.Issuer, claims.ID)
+	stored, err := cache.SetNX(ctx, key, "1", ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to store assertion jti: %w", err)
+	}
+	if !stored {
+		return nil, fmt.Errorf("%w: jti already used", ErrInvalidJWTBearerAssertion)
+	}
+	return mapping, nil
+}
+
+// JWTBearerScopes 回傳對應規則下 client 可取得的 scope
+func JWTBearerScopes(m *SubjectMapping, clientScopes []string) []string {
+	if len(m.Scopes) == 0 {
+		return clientScopes
+	}
+	allowed := make([]string, 0, len(m.Scopes))
+	for _, s := range m.Scopes {
+		if containsScope(clientScopes, s) {
+			allowed = append(allowed, s)
+		}
+	}
+	return allowed
+}
+
+func (iss TrustedIssuer) subjectMapping(subject, clientID string) *SubjectMapping {
+	for _, m := range iss.Subjects {
+		if m.ClientID != clientID {
+			continue
+		}
+		if m.Subject == subject {
+			return &m
+		}
+		if prefix, ok := strings.CutSuffix(m.Subject, "*"); ok && strings.HasPrefix(subject, prefix) {
+			return &m
+		}
+	}
+	return nil
+}
+
+// loadIssuerKeys 依設定載入簽發者的驗證金鑰，三種來源必須恰好設定一種
+func loadIssuerKeys(iss TrustedIssuer) ([]clientKey, error) {
+	sources := 0
+	for _, set := range []bool{len(iss.JWKS) > 0, iss.JWKSFile != "", iss.PublicKeyFile != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("exactly one of jwks, jwks_file or public_key_file is required")
+	}
+	switch {
+	case iss.JWKSFile != "":
+		raw, err := os.ReadFile(iss.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read jwks_file: %w", err)
+		}
+		return parseClientJWKS(raw)
+	case iss.PublicKeyFile != "":
+		raw, err := os.ReadFile(iss.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public_key_file: %w", err)
+		}
+		public, err := parsePublicKeyPEM(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []clientKey{{public: public}}, nil
+	}
+	return parseClientJWKS(iss.JWKS)
+}
+
+// parsePublicKeyPEM 解析 PKIX（"PUBLIC KEY"）格式的 PEM 公鑰
+func parsePublicKeyPEM(raw []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("invalid public key: expected a PEM \"PUBLIC KEY\" block")
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minClientRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minClientRSAKeyBits)
+		}
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+	return public, nil
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func TestLoadTrustedIssuers(t *testing.T) {
+	dir := t.TempDir()
+	ecKey := newTestClientKey(t, "ec", jwt.SigningMethodES256)
+	edKey := newTestClientKey(t, "ed", jwt.SigningMethodEdDSA)
+	der, err := x509.MarshalPKIXPublicKey(edKey.signer.Public())
+	require.NoError(t, err)
+	pemFile := writeTestFile(t, dir, "ci.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	jwksFile := writeTestFile(t, dir, "ci.jwks", testJWKS(t, ecKey.jwk()))
+
+	load := func(cfg any) ([]TrustedIssuer, error) {
+		raw, err := json.Marshal(map[string]any{"issuers": cfg})
+		require.NoError(t, err)
+		return LoadTrustedIssuers(writeTestFile(t, dir, "issuers.json", raw))
+	}
+	subjects := []map[string]any{{"subject": "repo:org/*", "client_id": "batch", "scopes": []string{"users:read"}}}
+
+	t.Run("ok", func(t *testing.T) {
+		issuers, err := load([]map[string]any{
+			{"issuer": "https://ci.example.com", "jwks_file": jwksFile, "subjects": subjects},
+			{"issuer": "https://other.example.com", "public_key_file": pemFile, "audiences": []string{"life-is-hard"}, "subjects": subjects},
+			{"issuer": "https://inline.example.com", "jwks": json.RawMessage(testJWKS(t, edKey.jwk())), "subjects": subjects},
+		})
+		require.NoError(t, err)
+		require.Len(t, issuers, 3)
+		require.Len(t, issuers[0].keys, 1)
+		require.Equal(t, "ec", issuers[0].keys[0].kid)
+		require.Equal(t, edKey.signer.Public(), issuers[1].keys[0].public)
+		require.Equal(t, []string{"life-is-hard"}, issuers[1].Audiences)
+		require.Equal(t, "repo:org/*", issuers[2].Subjects[0].Subject)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, cfg := range map[string][]map[string]any{
+			"missing issuer":   {{"jwks_file": jwksFile, "subjects": subjects}},
+			"duplicate issuer": {{"issuer": "a", "jwks_file": jwksFile}, {"issuer": "a", "jwks_file": jwksFile}},
+			"no key":           {{"issuer": "a", "subjects": subjects}},
+			"two keys":         {{"issuer": "a", "jwks_file": jwksFile, "public_key_file": pemFile}},
+			"missing jwks":     {{"issuer": "a", "jwks_file": filepath.Join(dir, "missing")}},
+			"missing pem":      {{"issuer": "a", "public_key_file": filepath.Join(dir, "missing")}},
+			"bad pem":          {{"issuer": "a", "public_key_file": jwksFile}},
+			"bad jwks":         {{"issuer": "a", "jwks": json.RawMessage(`{"keys":[]}`)}},
+			"wildcard subject": {{"issuer": "a", "jwks_file": jwksFile, "subjects": []map[string]any{{"subject": "*", "client_id": "batch"}}}},
+			"missing client":   {{"issuer": "a", "jwks_file": jwksFile, "subjects": []map[string]any{{"subject": "s"}}}},
+			"unknown scope":    {{"issuer": "a", "jwks_file": jwksFile, "subjects": []map[string]any{{"subject": "s", "client_id": "batch", "scopes": []string{"admin"}}}}},
+		} {
+			_, err := load(cfg)
+			require.Error(t, err, name)
+		}
+
+		_, err := LoadTrustedIssuers(filepath.Join(dir, "missing.json"))
+		require.ErrorContains(t, err, "failed to read trusted issuers")
+		_, err = LoadTrustedIssuers(writeTestFile(t, dir, "bad.json", []byte("{")))
+		require.ErrorContains(t, err, "invalid trusted issuers")
+	})
+}
+
+// clientRow 模擬查詢 client 的結果；err 為 nil 時視為 client 存在
+type clientRow struct{ err error }
+
+func (r clientRow) Scan(...any) error { return r.err }
+
+func TestCheckTrustedIssuerClients(t *testing.T) {
+	ctx := context.Background()
+	issuers := []TrustedIssuer{{Issuer: "https://ci.example.com", Subjects: []SubjectMapping{{Subject: "repo:*", ClientID: "ci"}}}}
+	var gotClientID any
+	var rowErr error
+	db := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+		gotClientID = args[0]
+		return clientRow{err: rowErr}
+	}}
+
+	require.NoError(t, CheckTrustedIssuerClients(ctx, db, issuers))
+	require.Equal(t, "ci", gotClientID)
+
+	rowErr = pgx.ErrNoRows
+	require.ErrorContains(t, CheckTrustedIssuerClients(ctx, db, issuers), `client_id "ci" is not registered`)
+
+	rowErr = errors.New("db")
+	require.ErrorContains(t, CheckTrustedIssuerClients(ctx, db, issuers), "failed to retrieve client")
+}
+
+func TestReservedByTrustedIssuer(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	require.False(t, ReservedByTrustedIssuer("ci"))
+
+	UseTrustedIssuers([]TrustedIssuer{{Issuer: "https://ci.example.com", Subjects: []SubjectMapping{{Subject: "repo:*", ClientID: "ci"}}}})
+	require.True(t, ReservedByTrustedIssuer("ci"))
+	require.False(t, ReservedByTrustedIssuer("other"))
+}
+
+func TestParsePublicKeyPEM(t *testing.T) {
+	ecKey := newTestClientKey(t, "ec", jwt.SigningMethodES256)
+	der, err := x509.MarshalPKIXPublicKey(ecKey.signer.Public())
+	require.NoError(t, err)
+	public, err := parsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+	require.Equal(t, ecKey.signer.Public(), public)
+
+	_, err = parsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.Error(t, err)
+	_, err = parsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("bad")}))
+	require.Error(t, err)
+}
+
+func TestVerifyJWTBearerAssertion(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	audiences := []string{"https://auth.example.com", "https://auth.example.com/api/oauth/token"}
+
+	ciKey := newTestClientKey(t, "ci", jwt.SigningMethodES256)
+	otherKey := newTestClientKey(t, "ci", jwt.SigningMethodES256)
+	UseTrustedIssuers([]TrustedIssuer{
+		{
+			Issuer: "https://ci.example.com",
+			Subjects: []SubjectMapping{
+				{Subject: "repo:org/app:ref:refs/heads/main", ClientID: "batch", Username: "deployer"},
+				{Subject: "repo:org/*", ClientID: "batch"},
+			},
+			keys: []clientKey{{kid: "ci", public: ciKey.signer.Public()}},
+		},
+		{
+			Issuer:    "https://custom-aud.example.com",
+			Audiences: []string{"life-is-hard"},
+			Subjects:  []SubjectMapping{{Subject: "job", ClientID: "batch"}},
+			keys:      []clientKey{{public: ciKey.signer.Public()}},
+		},
+	})
+	claims := func(iss, sub, jti string) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Issuer:    iss,
+			Subject:   sub,
+			Audience:  jwt.ClaimStrings{"https://auth.example.com/api/oauth/token"},
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		}
+	}
+	sign := func(c jwt.RegisteredClaims) string {
+		return signAssertion(t, ciKey.method, ciKey.signer, ciKey.kid, c)
+	}
+
+	t.Run("user mapping", func(t *testing.T) {
+		rc := cache.NewMemoryCache(nil)
+		m, err := VerifyJWTBearerAssertion(ctx, rc, "batch", sign(claims("https://ci.example.com", "repo:org/app:ref:refs/heads/main", "j1")), audiences)
+		require.NoError(t, err)
+		require.Equal(t, "deployer", m.Username)
+		require.Equal(t, 5*time.Minute, rc.TTLs["jwt_bearer_jti:https://ci.example.com:j1"])
+	})
+
+	t.Run("prefix mapping", func(t *testing.T) {
+		rc := cache.NewMemoryCache(nil)
+		m, err := VerifyJWTBearerAssertion(ctx, rc, "batch", sign(claims("https://ci.example.com", "repo:org/tools:ref:refs/tags/v1", "j1")), audiences)
+		require.NoError(t, err)
+		require.Empty(t, m.Username)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		rc := cache.NewMemoryCache(nil)
+		assertion := sign(claims("https://ci.example.com", "repo:org/app", "j1"))
+		_, err := VerifyJWTBearerAssertion(ctx, rc, "batch", assertion, audiences)
+		require.NoError(t, err)
+		_, err = VerifyJWTBearerAssertion(ctx, rc, "batch", assertion, audiences)
+		require.ErrorIs(t, err, ErrInvalidJWTBearerAssertion)
+		require.ErrorContains(t, err, "jti already used")
+	})
+
+	t.Run("custom audience", func(t *testing.T) {
+		c := claims("https://custom-aud.example.com", "job", "j1")
+		_, err := VerifyJWTBearerAssertion(ctx, cache.NewMemoryCache(nil), "batch", sign(c), audiences)
+		require.ErrorContains(t, err, "audience mismatch")
+		c.Audience = jwt.ClaimStrings{"life-is-hard"}
+		_, err = VerifyJWTBearerAssertion(ctx, cache.NewMemoryCache(nil), "batch", sign(c), audiences)
+		require.NoError(t, err)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		expired := claims("https://ci.example.com", "repo:org/app", "j1")
+		expired.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Second))
+		noExp := claims("https://ci.example.com", "repo:org/app", "j1")
+		noExp.ExpiresAt = nil
+		future := claims("https://ci.example.com", "repo:org/app", "j1")
+		future.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute))
+		longLived := claims("https://ci.example.com", "repo:org/app", "j1")
+		longLived.ExpiresAt = jwt.NewNumericDate(now.Add(2 * time.Hour))
+		wrongAud := claims("https://ci.example.com", "repo:org/app", "j1")
+		wrongAud.Audience = jwt.ClaimStrings{"https://other.example.com"}
+
+		for name, tc := range map[string]struct {
+			assertion string
+			clientID  string
+		}{
+			"not a jwt":        {"not-a-jwt", "batch"},
+			"untrusted issuer": {sign(claims("https://evil.example.com", "repo:org/app", "j1")), "batch"},
+			"wrong key":        {signAssertion(t, otherKey.method, otherKey.signer, "ci", claims("https://ci.example.com", "repo:org/app", "j1")), "batch"},
+			"hmac":             {signAssertion(t, jwt.SigningMethodHS256, []byte("k"), "ci", claims("https://ci.example.com", "repo:org/app", "j1")), "batch"},
+			"expired":          {sign(expired), "batch"},
+			"no exp":           {sign(noExp), "batch"},
+			"issued in future": {sign(future), "batch"},
+			"too long lived":   {sign(longLived), "batch"},
+			"wrong audience":   {sign(wrongAud), "batch"},
+			"missing sub":      {sign(claims("https://ci.example.com", "", "j1")), "batch"},
+			"unmapped subject": {sign(claims("https://ci.example.com", "repo:evil/app", "j1")), "batch"},
+			"other client":     {sign(claims("https://ci.example.com", "repo:org/app", "j1")), "other"},
+			"missing jti":      {sign(claims("https://ci.example.com", "repo:org/app", "")), "batch"},
+		} {
+			_, err := VerifyJWTBearerAssertion(ctx, cache.NewMemoryCache(nil), tc.clientID, tc.assertion, audiences)
+			require.ErrorIs(t, err, ErrInvalidJWTBearerAssertion, name)
+		}
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		assertion := sign(claims("https://ci.example.com", "repo:org/app", "j1"))
+		rc := cache.NewMemoryCache(nil)
+		rc.FailOn["setnx"] = "jwt_bearer_jti:"
+		_, err := VerifyJWTBearerAssertion(ctx, rc, "batch", assertion, audiences)
+		require.ErrorContains(t, err, "failed to store assertion jti")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		UseTrustedIssuers(nil)
+		_, err := VerifyJWTBearerAssertion(ctx, cache.NewMemoryCache(nil), "batch", sign(claims("https://ci.example.com", "repo:org/app", "j1")), audiences)
+		require.ErrorIs(t, err, ErrInvalidJWTBearerAssertion)
+	})
+}
+
+func TestJWTBearerScopes(t *testing.T) {
+	require.Equal(t, []string{"openid", "users:read"}, JWTBearerScopes(&SubjectMapping{}, []string{"openid", "users:read"}))
+	require.Equal(t, []string{"users:read"}, JWTBearerScopes(&SubjectMapping{Scopes: []string{"users:read", "users:write"}}, []string{"openid", "users:read"}))
+}
@@ -0,0 +1,390 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// TOTP 參數依 RFC 6238 的預設值，相容一般驗證器 App
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew 為前後容許的時間步數，容忍裝置時鐘誤差
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+
+	// 同一使用者在時間窗內 MFA 驗證失敗達上限後暫停驗證，防止暴力猜測驗證碼
+	mfaFailureLimit  = 5
+	mfaFailureWindow = 15 * time.Minute
+
+	defaultMFAIssuer = "life-is-hard"
+	// sealedTOTPPrefix 標示以 AES-GCM 加密儲存的 TOTP 金鑰
+	sealedTOTPPrefix = "enc:"
+)
+
+var (
+	ErrMFANotEnabled          = errors.New("mfa not enabled")
+	ErrMFAAlreadyEnabled      = errors.New("mfa already enabled")
+	ErrTOTPEnrollmentNotFound = errors.New("no pending totp enrollment")
+	ErrInvalidMFACode         = errors.New("invalid mfa code")
+	ErrInvalidMFAChallenge    = errors.New("invalid or expired mfa token")
+
+	errMFAEncryptionKeyMissing = errors.New("no mfa encryption key is configured")
+
+	base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	// mfaIssuer 為驗證器 App 顯示的服務名稱
+	mfaIssuer = defaultMFAIssuer
+	// totpAEAD 用以加密儲存 TOTP 金鑰；未設定時無法註冊 TOTP
+	totpAEAD cipher.AEAD
+	// adminMFARequired 為 true 時管理員須以多重因素登入才能使用管理功能
+	adminMFARequired bool
+)
+
+// UseMFAIssuer 設定 otpauth URI 的 issuer；空白時使用預設值
+func UseMFAIssuer(issuer string) {
+	if issuer == "" {
+		issuer = defaultMFAIssuer
+	}
+	mfaIssuer = issuer
+}
+
+// UseMFAEncryptionKey 設定加密 TOTP 金鑰的 AES-256 金鑰
+func UseMFAEncryptionKey(key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("mfa encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("failed to create cipher: %w", err)
+	}
+	totpAEAD = aead
+	return nil
+}
+
+// UseAdminMFARequired 設定管理員是否須以多重因素登入才能使用管理功能
+func UseAdminMFARequired(required bool) {
+	adminMFARequired = required
+}
+
+// AdminMFASatisfied 回傳 token 是否符合管理員的 MFA 要求；非管理員或未要求時一律符合
+func AdminMFASatisfied(claims *CustomClaims) bool {
+	return !adminMFARequired || !claims.IsAdmin || claims.MultiFactor()
+}
+
+// MFAEnabled 回傳使用者是否已啟用 MFA
+func MFAEnabled(ctx context.Context, db database.DB, userID int) (bool, error) {
+	t, err := store.GetUserTOTP(ctx, db, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.Confirmed(), nil
+}
+
+// TOTPEnrollment 為註冊 TOTP 時交給使用者的金鑰；URI 可轉為 QR code 供驗證器 App 掃描
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// BeginTOTPEnrollment 產生新的 TOTP 金鑰並取代先前未完成的註冊；須以 ConfirmTOTPEnrollment 確認後才會啟用
+func BeginTOTPEnrollment(ctx context.Context, db database.DB, user model.User) (*TOTPEnrollment, error) {
+	b := make([]byte, 20)
+	if _, err := randRead(b); err != nil {
+		return nil, fmt.Errorf("failed to generate totp secret: %w", err)
+	}
+	secret := base32NoPadding.EncodeToString(b)
+	sealed, err := sealTOTPSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := store.SavePendingUserTOTP(ctx, db, user.ID, sealed)
+	if err != nil {
+		return nil, err
+	}
+	if !saved {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	return &TOTPEnrollment{Secret: secret, URI: totpURI(secret, user.Name)}, nil
+}
+
+// ConfirmTOTPEnrollment 以驗證器產生的驗證碼確認註冊並啟用 MFA，回傳只會顯示這一次的 recovery code
+func ConfirmTOTPEnrollment(ctx context.Context, db database.DB, userID int, code string) ([]string, error) {
+	t, err := store.GetUserTOTP(ctx, db, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTOTPEnrollmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.Confirmed() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	step, ok, err := matchTOTP(t.Secret, code, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	// 先保存 recovery code 再啟用，避免啟用後沒有可用的 recovery code
+	codes, err := replaceRecoveryCodes(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	confirmed, err := store.ConfirmUserTOTP(ctx, db, userID, step)
+	if err != nil {
+		return nil, err
+	}
+	if !confirmed {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	return codes, nil
+}
+
+// VerifyMFACode 驗證使用者的 TOTP 驗證碼或 recovery code，兩者皆只能使用一次；
+// 驗證前先計入嘗試次數，時間窗內達上限時回傳 ErrRateLimited，驗證成功則重設計數；
+// 未啟用 MFA 時回傳 ErrMFANotEnabled
+func VerifyMFACode(ctx context.Context, db database.DB, cache cache.Cache, userID int, code string) error {
+	key := fmt.Sprintf("mfa_failures:%d", userID)
+	// 先計數再驗證，併發的猜測也都會被計入
+	ok, err := allowRequest(ctx, cache, key, mfaFailureLimit, mfaFailureWindow)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrRateLimited
+	}
+	ok, err = verifyMFACode(ctx, db, userID, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	if err := cache.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to reset mfa failures: %w", err)
+	}
+	return nil
+}
+
+func verifyMFACode(ctx context.Context, db database.DB, userID int, code string) (bool, error) {
+	t, err := store.GetUserTOTP(ctx, db, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrMFANotEnabled
+	}
+	if err != nil {
+		return false, err
+	}
+	if !t.Confirmed() {
+		return false, ErrMFANotEnabled
+	}
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return store.UseRecoveryCode(ctx, db, userID, hashRecoveryCode(code))
+	}
+	step, ok, err := matchTOTP(t.Secret, code, t.LastUsedStep)
+	if err != nil || !ok {
+		return false, err
+	}
+	// 以資料庫的條件更新確保同一時間步只能使用一次
+	return store.UseUserTOTPStep(ctx, db, userID, step)
+}
+
+// RegenerateRecoveryCodes 驗證 MFA 後以新的 recovery code 取代所有舊的 recovery code
+func RegenerateRecoveryCodes(ctx context.Context, db database.DB, cache cache.Cache, userID int, code string) ([]string, error) {
+	if err := VerifyMFACode(ctx, db, cache, userID, code); err != nil {
+		return nil, err
+	}
+	return replaceRecoveryCodes(ctx, db, userID)
+}
+
+// DisableMFA 驗證 MFA 後刪除使用者的 TOTP 與 recovery code
+func DisableMFA(ctx context.Context, db database.DB, cache cache.Cache, userID int, code string) error {
+	if err := VerifyMFACode(ctx, db, cache, userID, code); err != nil {
+		return err
+	}
+	return store.DeleteUserMFA(ctx, db, userID)
+}
+
+// IssueMFAChallenge 於密碼驗證通過後發行 MFA token，供使用者接著提交第二因素；快取僅保存其 SHA-256 雜湊
+func IssueMFAChallenge(ctx context.Context, cache cache.Cache, userID int, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := randRead(b); err != nil {
+		return "", fmt.Errorf("failed to generate mfa token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if err := cache.Set(ctx, mfaChallengeKey(token), strconv.Itoa(userID), ttl).Err(); err != nil {
+		return "", fmt.Errorf("failed to store mfa token: %w", err)
+	}
+	return token, nil
+}
+
+// MFAChallengeUser 回傳 MFA token 所屬的使用者；token 於 ConsumeMFAChallenge 前可重複驗證
+func MFAChallengeUser(ctx context.Context, cache cache.Cache, token string) (int, error) {
+	val, err := cache.Get(ctx, mfaChallengeKey(token)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, ErrInvalidMFAChallenge
+		}
+		return 0, fmt.Errorf("failed to retrieve mfa token: %w", err)
+	}
+	userID, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse mfa token data: %w", err)
+	}
+	return userID, nil
+}
+
+// ConsumeMFAChallenge 刪除 MFA token，確保同一 token 只能換發一次 access token
+func ConsumeMFAChallenge(ctx context.Context, cache cache.Cache, token string) error {
+	deleted, err := cache.Del(ctx, mfaChallengeKey(token)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete mfa token: %w", err)
+	}
+	if deleted == 0 {
+		return ErrInvalidMFAChallenge
+	}
+	return nil
+}
+
+func mfaChallengeKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("mfa_challenge:%s", hex.EncodeToString(sum[:]))
+}
+
+// totpURI 產生 Key Uri Format 的 otpauth URI
+func totpURI(secret, account string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", mfaIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", strconv.Itoa(totpDigits))
+	q.Set("period", strconv.Itoa(totpPeriod))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + mfaIssuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// totpCode 依 RFC 4226 §5.3 以 HMAC-SHA1 計算時間步 step 的驗證碼
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1000000)
+}
+
+// matchTOTP 比對目前時間前後 totpSkew 個時間步的驗證碼，回傳符合且晚於 after 的時間步
+func matchTOTP(stored, code string, after int64) (int64, bool, error) {
+	secret, err := openTOTPSecret(stored)
+	if err != nil {
+		return 0, false, err
+	}
+	key, err := base32NoPadding.DecodeString(secret)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to decode totp secret: %w", err)
+	}
+	current := timeNow().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > after && hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func sealTOTPSecret(secret string) (string, error) {
+	if totpAEAD == nil {
+		return "", errMFAEncryptionKeyMissing
+	}
+	nonce := make([]byte, totpAEAD.NonceSize())
+	if _, err := randRead(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := totpAEAD.Seal(nonce, nonce, []byte(secret), nil)
+	return sealedTOTPPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openTOTPSecret(stored string) (string, error) {
+	if totpAEAD == nil {
+		return "", errMFAEncryptionKeyMissing
+	}
+	encoded, ok := strings.CutPrefix(stored, sealedTOTPPrefix)
+	if !ok {
+		return "", fmt.Errorf("invalid encrypted totp secret")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < totpAEAD.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted totp secret")
+	}
+	n := totpAEAD.NonceSize()
+	secret, err := totpAEAD.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt totp secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+// replaceRecoveryCodes 產生新的 recovery code 並取代舊的，資料庫僅保存其雜湊
+func replaceRecoveryCodes(ctx context.Context, db database.DB, userID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := randRead(b); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	if err := store.ReplaceRecoveryCodes(ctx, db, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode 忽略大小寫與分隔符號後計算 SHA-256；recovery code 為隨機產生，不需慢速雜湊
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// mfaDB 以記憶體模擬 user_totp 與 user_recovery_codes 資料表
+type mfaDB struct {
+	totp  *model.UserTOTP
+	codes map[string]bool
+	// failOn 為 SQL 含此字串時回傳錯誤
+	failOn string
+}
+
+type totpRow struct {
+	totp *model.UserTOTP
+	err  error
+}
+
+func (r *totpRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int) = r.totp.UserID
+	*dest[1].(*string) = r.totp.Secret
+	*dest[2].(**time.Time) = r.totp.ConfirmedAt
+	*dest[3].(*int64) = r.totp.LastUsedStep
+	*dest[4].(*time.Time) = r.totp.CreatedAt
+	return nil
+}
+
+func (m *mfaDB) fake() *database.FakeDB {
+	affected := func(ok bool) pgconn.CommandTag {
+		if ok {
+			return pgconn.NewCommandTag("UPDATE 1")
+		}
+		return pgconn.NewCommandTag("UPDATE 0")
+	}
+	return &database.FakeDB{
+		QueryRowFn: func(_ context.Context, sql string, _ ...any) pgx.Row {
+			if m.failOn != "" && strings.Contains(sql, m.failOn) {
+				return &totpRow{err: errors.New("db")}
+			}
+			if m.totp == nil {
+				return &totpRow{err: pgx.ErrNoRows}
+			}
+			t := *m.totp
+			return &totpRow{totp: &t}
+		},
+		ExecFn: func(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+			if m.failOn != "" && strings.Contains(sql, m.failOn) {
+				return pgconn.CommandTag{}, errors.New("db")
+			}
+			now := timeNow()
+			switch {
+			case strings.Contains(sql, "INSERT INTO user_totp"):
+				if m.totp != nil && m.totp.Confirmed() {
+					return affected(false), nil
+				}
+				m.totp = &model.UserTOTP{UserID: args[0].(int), Secret: args[1].(string)}
+				return affected(true), nil
+			case strings.Contains(sql, "SET confirmed_at"):
+				if m.totp == nil || m.totp.Confirmed() {
+					return affected(false), nil
+				}
+				m.totp.ConfirmedAt = &now
+				m.totp.LastUsedStep = args[1].(int64)
+				return affected(true), nil
+			case strings.Contains(sql, "SET last_used_step"):
+				step := args[1].(int64)
+				if m.totp == nil || !m.totp.Confirmed() || m.totp.LastUsedStep >= step {
+					return affected(false), nil
+				}
+				m.totp.LastUsedStep = step
+				return affected(true), nil
+			case strings.Contains(sql, "INSERT INTO user_recovery_codes"):
+				m.codes = map[string]bool{}
+				for _, h := range args[1].([]string) {
+					m.codes[h] = false
+				}
+				return affected(true), nil
+			case strings.Contains(sql, "UPDATE user_recovery_codes"):
+				used, ok := m.codes[args[1].(string)]
+				if !ok || used {
+					return affected(false), nil
+				}
+				m.codes[args[1].(string)] = true
+				return affected(true), nil
+			case strings.Contains(sql, "DELETE FROM user_totp"):
+				m.totp, m.codes = nil, nil
+				return affected(true), nil
+			}
+			panic("unexpected sql: " + sql)
+		},
+	}
+}
+
+// currentTOTP 回傳 secret 在目前時間（加上 offset 個時間步）的驗證碼
+func currentTOTP(t *testing.T, secret string, offset int64) string {
+	key, err := base32NoPadding.DecodeString(secret)
+	require.NoError(t, err)
+	return totpCode(key, timeNow().Unix()/totpPeriod+offset)
+}
+
+// enrolledMFA 建立已啟用 MFA 的使用者，回傳 TOTP 金鑰與 recovery code
+func enrolledMFA(t *testing.T, db *database.FakeDB) (string, []string) {
+	ctx := context.Background()
+	require.NoError(t, UseMFAEncryptionKey([]byte("0123456789abcdef0123456789abcdef")))
+	enrollment, err := BeginTOTPEnrollment(ctx, db, model.User{ID: 1, Name: "alice"})
+	require.NoError(t, err)
+	codes, err := ConfirmTOTPEnrollment(ctx, db, 1, currentTOTP(t, enrollment.Secret, -1))
+	require.NoError(t, err)
+	return enrollment.Secret, codes
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 附錄 B 的 SHA1 測試向量（取末 6 碼）
+	key := []byte("12345678901234567890")
+	for ts, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		require.Equal(t, want, totpCode(key, ts/totpPeriod), ts)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	UseMFAIssuer("My App")
+	u, err := url.Parse(totpURI("SECRET", "alice"))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", u.Scheme)
+	require.Equal(t, "totp", u.Host)
+	require.Equal(t, "/My App:alice", u.Path)
+	require.Equal(t, "SECRET", u.Query().Get("secret"))
+	require.Equal(t, "My App", u.Query().Get("issuer"))
+	require.Equal(t, "6", u.Query().Get("digits"))
+
+	UseMFAIssuer("")
+	require.Equal(t, defaultMFAIssuer, mfaIssuer)
+}
+
+func TestTOTPSecretEncryption(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	require.ErrorContains(t, UseMFAEncryptionKey([]byte("short")), "32 bytes")
+	require.ErrorContains(t, UseMFAEncryptionKey(nil), "32 bytes")
+
+	// 未設定金鑰時不以明文儲存
+	_, err := sealTOTPSecret("SECRET")
+	require.ErrorIs(t, err, errMFAEncryptionKeyMissing)
+	_, err = openTOTPSecret("SECRET")
+	require.ErrorIs(t, err, errMFAEncryptionKeyMissing)
+
+	require.NoError(t, UseMFAEncryptionKey([]byte("0123456789abcdef0123456789abcdef")))
+	sealed, err := sealTOTPSecret("SECRET")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(sealed, sealedTOTPPrefix))
+	require.NotContains(t, sealed, "SECRET")
+	opened, err := openTOTPSecret(sealed)
+	require.NoError(t, err)
+	require.Equal(t, "SECRET", opened)
+
+	_, err = openTOTPSecret("SECRET")
+	require.ErrorContains(t, err, "invalid encrypted totp secret")
+
+	_, err = openTOTPSecret(sealedTOTPPrefix + "!!")
+	require.ErrorContains(t, err, "invalid encrypted totp secret")
+	require.NoError(t, UseMFAEncryptionKey([]byte("fedcba9876543210fedcba9876543210")))
+	_, err = openTOTPSecret(sealed)
+	require.ErrorContains(t, err, "failed to decrypt totp secret")
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	require.NoError(t, UseMFAEncryptionKey([]byte("0123456789abcdef0123456789abcdef")))
+	_, err = sealTOTPSecret("SECRET")
+	require.Error(t, err)
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	require.NoError(t, UseMFAEncryptionKey([]byte("0123456789abcdef0123456789abcdef")))
+	m := &mfaDB{}
+	db := m.fake()
+	user := model.User{ID: 1, Name: "alice"}
+
+	_, err := ConfirmTOTPEnrollment(ctx, db, 1, "123456")
+	require.ErrorIs(t, err, ErrTOTPEnrollmentNotFound)
+
+	enrollment, err := BeginTOTPEnrollment(ctx, db, user)
+	require.NoError(t, err)
+	require.Len(t, enrollment.Secret, 32)
+	require.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+	// 資料庫保存加密後的金鑰
+	require.True(t, strings.HasPrefix(m.totp.Secret, sealedTOTPPrefix))
+
+	// 重新開始註冊會取代未完成的金鑰
+	again, err := BeginTOTPEnrollment(ctx, db, user)
+	require.NoError(t, err)
+	require.NotEqual(t, enrollment.Secret, again.Secret)
+	_, err = ConfirmTOTPEnrollment(ctx, db, 1, currentTOTP(t, enrollment.Secret, 0))
+	require.ErrorIs(t, err, ErrInvalidMFACode)
+	enabled, err := MFAEnabled(ctx, db, 1)
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	codes, err := ConfirmTOTPEnrollment(ctx, db, 1, currentTOTP(t, again.Secret, 0))
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodeCount)
+	require.Len(t, m.codes, recoveryCodeCount)
+	enabled, err = MFAEnabled(ctx, db, 1)
+	require.NoError(t, err)
+	require.True(t, enabled)
+
+	_, err = ConfirmTOTPEnrollment(ctx, db, 1, currentTOTP(t, again.Secret, 1))
+	require.ErrorIs(t, err, ErrMFAAlreadyEnabled)
+	_, err = BeginTOTPEnrollment(ctx, db, user)
+	require.ErrorIs(t, err, ErrMFAAlreadyEnabled)
+
+	t.Run("errors", func(t *testing.T) {
+		m := &mfaDB{failOn: "user_totp"}
+		_, err := BeginTOTPEnrollment(ctx, m.fake(), user)
+		require.Error(t, err)
+		_, err = ConfirmTOTPEnrollment(ctx, m.fake(), 1, "123456")
+		require.Error(t, err)
+		_, err = MFAEnabled(ctx, m.fake(), 1)
+		require.Error(t, err)
+
+		m = &mfaDB{}
+		enrollment, err := BeginTOTPEnrollment(ctx, m.fake(), user)
+		require.NoError(t, err)
+		m.failOn = "user_recovery_codes"
+		_, err = ConfirmTOTPEnrollment(ctx, m.fake(), 1, currentTOTP(t, enrollment.Secret, 0))
+		require.Error(t, err)
+		require.False(t, m.totp.Confirmed())
+
+		m.failOn = "SET confirmed_at"
+		_, err = ConfirmTOTPEnrollment(ctx, m.fake(), 1, currentTOTP(t, enrollment.Secret, 0))
+		require.Error(t, err)
+
+		randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+		_, err = BeginTOTPEnrollment(ctx, m.fake(), user)
+		require.ErrorContains(t, err, "failed to generate totp secret")
+	})
+}
+
+func TestVerifyMFACode(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	c := cache.NewMemoryCache(nil)
+	m := &mfaDB{}
+	db := m.fake()
+
+	require.ErrorIs(t, VerifyMFACode(ctx, db, c, 1, "123456"), ErrMFANotEnabled)
+	secret, codes := enrolledMFA(t, db)
+
+	// 確認註冊時用過的驗證碼不可再用
+	require.ErrorIs(t, VerifyMFACode(ctx, db, c, 1, currentTOTP(t, secret, -1)), ErrInvalidMFACode)
+	code := currentTOTP(t, secret, 0)
+	require.NoError(t, VerifyMFACode(ctx, db, c, 1, " "+code+" "))
+	require.ErrorIs(t, VerifyMFACode(ctx, db, c, 1, code), ErrInvalidMFACode)
+	require.NoError(t, VerifyMFACode(ctx, db, c, 1, currentTOTP(t, secret, 1)))
+
+	// recovery code 不分大小寫與分隔符號，且只能使用一次
+	require.NoError(t, VerifyMFACode(ctx, db, c, 1, strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+	require.ErrorIs(t, VerifyMFACode(ctx, db, c, 1, codes[0]), ErrInvalidMFACode)
+	require.Equal(t, "1", c.Data["mfa_failures:1"])
+	require.Equal(t, mfaFailureWindow, c.TTLs["mfa_failures:1"])
+
+	// 嘗試達上限後即使驗證碼正確也暫停驗證，時間窗結束後恢復
+	for i := 1; i < mfaFailureLimit; i++ {
+		require.ErrorIs(t, VerifyMFACode(ctx, db, c, 1, "000000"), ErrInvalidMFACode)
+	}
+	require.ErrorIs(t, VerifyMFACode(ctx, db, c, 1, codes[1]), ErrRateLimited)
+	// 計數於時間窗結束時由快取到期刪除
+	require.NoError(t, c.Del(ctx, "mfa_failures:1").Err())
+	require.NoError(t, VerifyMFACode(ctx, db, c, 1, codes[1]))
+	require.NotContains(t, c.Data, "mfa_failures:1")
+
+	t.Run("errors", func(t *testing.T) {
+		c.FailOn["incr"] = "mfa_failures:"
+		require.ErrorContains(t, VerifyMFACode(ctx, db, c, 1, "000000"), "failed to record rate limit")
+		delete(c.FailOn, "incr")
+		c.FailOn["expire"] = "mfa_failures:"
+		require.ErrorContains(t, VerifyMFACode(ctx, db, c, 1, "000000"), "failed to record rate limit")
+		delete(c.FailOn, "expire")
+		c.FailOn["del"] = "mfa_failures:"
+		require.ErrorContains(t, VerifyMFACode(ctx, db, c, 1, codes[3]), "failed to reset mfa failures")
+		delete(c.FailOn, "del")
+
+		m.failOn = "user_recovery_codes"
+		require.Error(t, VerifyMFACode(ctx, db, c, 1, codes[2]))
+		m.failOn = "FROM user_totp"
+		require.Error(t, VerifyMFACode(ctx, db, c, 1, codes[2]))
+		m.failOn = ""
+
+		m.totp.ConfirmedAt = nil
+		require.ErrorIs(t, VerifyMFACode(ctx, db, c, 1, codes[2]), ErrMFANotEnabled)
+	})
+}
+
+func TestRegenerateRecoveryCodesAndDisableMFA(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	c := cache.NewMemoryCache(nil)
+	m := &mfaDB{}
+	db := m.fake()
+	secret, codes := enrolledMFA(t, db)
+
+	_, err := RegenerateRecoveryCodes(ctx, db, c, 1, "000000")
+	require.ErrorIs(t, err, ErrInvalidMFACode)
+	fresh, err := RegenerateRecoveryCodes(ctx, db, c, 1, codes[0])
+	require.NoError(t, err)
+	require.Len(t, fresh, recoveryCodeCount)
+	// 舊的 recovery code 隨之失效
+	require.ErrorIs(t, VerifyMFACode(ctx, db, c, 1, codes[1]), ErrInvalidMFACode)
+
+	require.ErrorIs(t, DisableMFA(ctx, db, c, 1, "000000"), ErrInvalidMFACode)
+	require.NoError(t, DisableMFA(ctx, db, c, 1, currentTOTP(t, secret, 0)))
+	require.Nil(t, m.totp)
+	enabled, err := MFAEnabled(ctx, db, 1)
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	_, codes = enrolledMFA(t, db)
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	_, err = RegenerateRecoveryCodes(ctx, db, c, 1, codes[0])
+	require.ErrorContains(t, err, "failed to generate recovery code")
+}
+
+func TestMFAChallenge(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	c := cache.NewMemoryCache(nil)
+
+	token, err := IssueMFAChallenge(ctx, c, 7, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, c.Data, 1)
+	for key := range c.Data {
+		require.True(t, strings.HasPrefix(key, "mfa_challenge:"))
+		require.NotContains(t, key, token)
+		require.Equal(t, time.Minute, c.TTLs[key])
+	}
+
+	userID, err := MFAChallengeUser(ctx, c, token)
+	require.NoError(t, err)
+	require.Equal(t, 7, userID)
+	require.NoError(t, ConsumeMFAChallenge(ctx, c, token))
+	require.ErrorIs(t, ConsumeMFAChallenge(ctx, c, token), ErrInvalidMFAChallenge)
+	_, err = MFAChallengeUser(ctx, c, token)
+	require.ErrorIs(t, err, ErrInvalidMFAChallenge)
+
+	t.Run("errors", func(t *testing.T) {
+		c.FailOn["set"] = "mfa_challenge:"
+		_, err := IssueMFAChallenge(ctx, c, 7, time.Minute)
+		require.ErrorContains(t, err, "failed to store mfa token")
+		delete(c.FailOn, "set")
+
+		c.Data[mfaChallengeKey("bad")] = "x"
+		_, err = MFAChallengeUser(ctx, c, "bad")
+		require.ErrorContains(t, err, "failed to parse mfa token data")
+		c.FailOn["get"] = "mfa_challenge:"
+		_, err = MFAChallengeUser(ctx, c, "bad")
+		require.ErrorContains(t, err, "failed to retrieve mfa token")
+		c.FailOn["del"] = "mfa_challenge:"
+		require.ErrorContains(t, ConsumeMFAChallenge(ctx, c, "bad"), "failed to delete mfa token")
+
+		randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+		_, err = IssueMFAChallenge(ctx, c, 7, time.Minute)
+		require.ErrorContains(t, err, "failed to generate mfa token")
+	})
+}
+
+func TestAdminMFASatisfied(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	admin := &CustomClaims{IsAdmin: true, Authentication: PasswordAuthentication()}
+	require.True(t, AdminMFASatisfied(admin))
+
+	UseAdminMFARequired(true)
+	require.False(t, AdminMFASatisfied(admin))
+	require.True(t, AdminMFASatisfied(&CustomClaims{IsAdmin: true, Authentication: MFAAuthentication()}))
+	require.True(t, AdminMFASatisfied(&CustomClaims{}))
+}
+
+func TestAccessTokenAuthentication(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	t.Setenv("JWT_SECRET", "s")
+	ctx := context.Background()
+	token, err := IssueAccessToken(ctx, model.User{ID: 1}, "", "", nil, time.Hour, nil, MFAAuthentication())
+	require.NoError(t, err)
+	claims, err := parseAccessToken(ctx, token)
+	require.NoError(t, err)
+	require.Equal(t, []string{AMRPassword, AMROTP, AMRMultiFactor}, claims.AMR)
+	require.Equal(t, ACRMultiFactor, claims.ACR)
+	require.True(t, claims.MultiFactor())
+
+	// 未知驗證方式的 token 不帶 amr 與 acr
+	token, err = IssueAccessToken(ctx, model.User{ID: 1}, "", "", nil, time.Hour, nil, Authentication{})
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	require.NoError(t, err)
+	require.NotContains(t, parsed.Claims, "amr")
+	require.NotContains(t, parsed.Claims, "acr")
+}
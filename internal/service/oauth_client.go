@@ -0,0 +1,147 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"life-is-hard/internal/model"
+)
+
+// ErrInvalidRedirectURI 表示 redirect_uri 不符合 ValidateRedirectURI 的規則
+var ErrInvalidRedirectURI = errors.New("invalid redirect_uri")
+
+// SupportedGrantTypes 為 token endpoint 支援的 grant_type，亦公開於 discovery 文件；client 只能登記其中的 grant type
+var SupportedGrantTypes = []string{"authorization_code", "refresh_token", "password", "client_credentials", GrantTypeDeviceCode, GrantTypeTokenExchange, GrantTypeJWTBearer}
+
+// ValidateOAuthClient 檢查 client metadata，空的 client_type 會補為 confidential，
+// 空的 token_endpoint_auth_method 依 client_type 補為 client_secret_basic 或 none
+func ValidateOAuthClient(c *model.OAuthClient) error {
+	switch c.ClientType {
+	case "":
+		c.ClientType = model.ClientTypeConfidential
+	case model.ClientTypePublic, model.ClientTypeConfidential:
+	default:
+		return fmt.Errorf("invalid client_type: %s", c.ClientType)
+	}
+
+	switch c.TokenEndpointAuthMethod {
+	case "":
+		c.TokenEndpointAuthMethod = model.ClientAuthMethodSecretBasic
+		if c.IsPublic() {
+			c.TokenEndpointAuthMethod = model.ClientAuthMethodNone
+		}
+	case model.ClientAuthMethodNone:
+		if !c.IsPublic() {
+			return fmt.Errorf("confidential clients must authenticate at the token endpoint")
+		}
+	case model.ClientAuthMethodSecretBasic, model.ClientAuthMethodSecretPost, model.ClientAuthMethodSecretJWT, model.ClientAuthMethodPrivateKey,
+		model.ClientAuthMethodTLS, model.ClientAuthMethodSelfSignedTLS:
+		if c.IsPublic() {
+			return fmt.Errorf("public clients must use token_endpoint_auth_method none")
+		}
+	default:
+		return fmt.Errorf("invalid token_endpoint_auth_method: %s", c.TokenEndpointAuthMethod)
+	}
+	switch c.TokenEndpointAuthMethod {
+	case model.ClientAuthMethodSecretJWT:
+		// HMAC 需要 secret 明文，只能以金鑰庫加密保存
+		if keyStore == nil {
+			return fmt.Errorf("client_secret_jwt requires asymmetric signing keys to be configured")
+		}
+	case model.ClientAuthMethodPrivateKey:
+		if _, err := parseClientJWKS(c.JWKS); err != nil {
+			return err
+		}
+	case model.ClientAuthMethodTLS:
+		if clientCAs == nil {
+			return fmt.Errorf("tls_client_auth requires trusted client CAs to be configured")
+		}
+		if c.TLSClientAuthSubjectDN == "" {
+			return fmt.Errorf("tls_client_auth requires tls_client_auth_subject_dn")
+		}
+	case model.ClientAuthMethodSelfSignedTLS:
+		if err := ValidateCertificateThumbprint(c.TLSClientCertificateThumbprint); err != nil {
+			return err
+		}
+	}
+
+	for _, gt := range c.GrantTypes {
+		if !slices.Contains(SupportedGrantTypes, gt) {
+			return fmt.Errorf("unsupported grant_type: %s", gt)
+		}
+		if gt == "client_credentials" && c.IsPublic() {
+			return fmt.Errorf("public clients cannot use client_credentials")
+		}
+		if gt == "authorization_code" && len(c.RedirectURIs) == 0 {
+			return fmt.Errorf("authorization_code requires at least one redirect_uri")
+		}
+		if gt == GrantTypeTokenExchange && c.IsPublic() {
+			return fmt.Errorf("public clients cannot use token exchange")
+		}
+	}
+
+	for _, aud := range c.TokenExchangeAudiences {
+		if aud == "" || strings.ContainsAny(aud, " \t\n") {
+			return fmt.Errorf("invalid token exchange audience: %q", aud)
+		}
+	}
+	if err := validateTokenLifetimes(*c); err != nil {
+		return err
+	}
+
+	for _, uri := range c.RedirectURIs {
+		if err := ValidateRedirectURI(uri); err != nil {
+			return err
+		}
+	}
+
+	if c.LogoURI != "" {
+		u, err := url.Parse(c.LogoURI)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid logo_uri: must be an absolute https URL")
+		}
+	}
+
+	for _, scope := range c.Scopes {
+		if !IsKnownScope(scope) {
+			return fmt.Errorf("invalid scope: %q", scope)
+		}
+	}
+	return nil
+}
+
+// ValidateRedirectURI 要求絕對網址、不得含 fragment 或萬用字元，
+// 且必須為 https；僅 loopback 位址允許 http
+func ValidateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("%w %q: must be an absolute URL", ErrInvalidRedirectURI, uri)
+	}
+	if u.Fragment != "" || strings.Contains(uri, "#") {
+		return fmt.Errorf("%w %q: must not contain a fragment", ErrInvalidRedirectURI, uri)
+	}
+	if strings.Contains(uri, "*") {
+		return fmt.Errorf("%w %q: wildcards are not allowed", ErrInvalidRedirectURI, uri)
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if isLoopbackHost(u.Hostname()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q: must use https (http only allowed for loopback)", ErrInvalidRedirectURI, uri)
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
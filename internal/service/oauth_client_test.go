@@ -0,0 +1,167 @@
+package service
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+
+	"life-is-hard/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOAuthClient(t *testing.T) {
+	valid := func() *model.OAuthClient {
+		return &model.OAuthClient{
+			GrantTypes:   []string{"authorization_code", "refresh_token"},
+			RedirectURIs: []string{"https://app.example.com/cb"},
+			LogoURI:      "https://app.example.com/logo.png",
+			Scopes:       []string{"users:read"},
+		}
+	}
+
+	c := valid()
+	require.NoError(t, ValidateOAuthClient(c))
+	require.Equal(t, model.ClientTypeConfidential, c.ClientType)
+
+	c = valid()
+	c.ClientType = model.ClientTypePublic
+	require.NoError(t, ValidateOAuthClient(c))
+
+	c = valid()
+	c.ClientType = "other"
+	require.Error(t, ValidateOAuthClient(c))
+
+	c = valid()
+	c.ClientType = model.ClientTypePublic
+	c.GrantTypes = []string{"client_credentials"}
+	require.Error(t, ValidateOAuthClient(c))
+
+	c = valid()
+	c.RedirectURIs = nil
+	require.Error(t, ValidateOAuthClient(c))
+
+	c = valid()
+	c.GrantTypes = []string{"authorization_code", "implicit"}
+	require.ErrorContains(t, ValidateOAuthClient(c), "unsupported grant_type: implicit")
+
+	c = valid()
+	c.ClientType = model.ClientTypePublic
+	c.GrantTypes = []string{GrantTypeTokenExchange}
+	require.Error(t, ValidateOAuthClient(c))
+
+	c = valid()
+	c.TokenExchangeAudiences = []string{"billing-service"}
+	require.NoError(t, ValidateOAuthClient(c))
+
+	for _, aud := range []string{"", "billing service"} {
+		c = valid()
+		c.TokenExchangeAudiences = []string{aud}
+		require.Error(t, ValidateOAuthClient(c), aud)
+	}
+
+	c = valid()
+	c.RedirectURIs = []string{"http://app.example.com/cb"}
+	require.Error(t, ValidateOAuthClient(c))
+
+	c = valid()
+	c.LogoURI = "http://app.example.com/logo.png"
+	require.Error(t, ValidateOAuthClient(c))
+
+	c = valid()
+	c.Scopes = []string{"a b"}
+	require.Error(t, ValidateOAuthClient(c))
+
+	c = valid()
+	c.Scopes = []string{""}
+	require.Error(t, ValidateOAuthClient(c))
+
+	c = valid()
+	c.Scopes = []string{"admin:everything"}
+	require.Error(t, ValidateOAuthClient(c))
+}
+
+func TestValidateOAuthClientAuthMethod(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	validate := func(clientType, method string, jwks json.RawMessage) (*model.OAuthClient, error) {
+		c := &model.OAuthClient{ClientType: clientType, TokenEndpointAuthMethod: method, JWKS: jwks, GrantTypes: []string{"refresh_token"}}
+		return c, ValidateOAuthClient(c)
+	}
+
+	c, err := validate("", "", nil)
+	require.NoError(t, err)
+	require.Equal(t, model.ClientAuthMethodSecretBasic, c.TokenEndpointAuthMethod)
+
+	c, err = validate(model.ClientTypePublic, "", nil)
+	require.NoError(t, err)
+	require.Equal(t, model.ClientAuthMethodNone, c.TokenEndpointAuthMethod)
+
+	_, err = validate(model.ClientTypeConfidential, model.ClientAuthMethodSecretPost, nil)
+	require.NoError(t, err)
+
+	_, err = validate(model.ClientTypeConfidential, model.ClientAuthMethodNone, nil)
+	require.ErrorContains(t, err, "confidential clients must authenticate")
+
+	_, err = validate(model.ClientTypePublic, model.ClientAuthMethodSecretBasic, nil)
+	require.ErrorContains(t, err, "public clients must use")
+
+	_, err = validate(model.ClientTypeConfidential, "spiffe", nil)
+	require.ErrorContains(t, err, "invalid token_endpoint_auth_method")
+
+	_, err = validate(model.ClientTypePublic, model.ClientAuthMethodSelfSignedTLS, nil)
+	require.ErrorContains(t, err, "public clients must use")
+
+	_, err = validate(model.ClientTypeConfidential, model.ClientAuthMethodPrivateKey, nil)
+	require.ErrorContains(t, err, "invalid jwks")
+
+	key := newTestClientKey(t, "k", jwt.SigningMethodEdDSA)
+	_, err = validate(model.ClientTypeConfidential, model.ClientAuthMethodPrivateKey, testJWKS(t, key.jwk()))
+	require.NoError(t, err)
+
+	_, err = validate(model.ClientTypeConfidential, model.ClientAuthMethodSecretJWT, nil)
+	require.ErrorContains(t, err, "requires asymmetric signing keys")
+
+	ks, _, _ := newTestKeyStore(t, SigningAlgES256)
+	UseKeyStore(ks)
+	_, err = validate(model.ClientTypeConfidential, model.ClientAuthMethodSecretJWT, nil)
+	require.NoError(t, err)
+
+	c = &model.OAuthClient{TokenEndpointAuthMethod: model.ClientAuthMethodTLS, TLSClientAuthSubjectDN: "CN=svc"}
+	require.ErrorContains(t, ValidateOAuthClient(c), "requires trusted client CAs")
+	UseClientCAs(x509.NewCertPool())
+	require.NoError(t, ValidateOAuthClient(c))
+	c.TLSClientAuthSubjectDN = ""
+	require.ErrorContains(t, ValidateOAuthClient(c), "requires tls_client_auth_subject_dn")
+
+	c = &model.OAuthClient{TokenEndpointAuthMethod: model.ClientAuthMethodSelfSignedTLS, TLSClientCertificateThumbprint: "bad"}
+	require.ErrorContains(t, ValidateOAuthClient(c), "invalid tls_client_certificate_thumbprint")
+	cert, _ := newTestCertificate(t, "svc", nil, nil, false)
+	c.TLSClientCertificateThumbprint = CertificateThumbprint(cert)
+	require.NoError(t, ValidateOAuthClient(c))
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	for _, uri := range []string{
+		"https://app.example.com/cb",
+		"https://app.example.com/cb?x=1",
+		"http://localhost:8080/cb",
+		"http://127.0.0.1/cb",
+		"http://[::1]:3000/cb",
+	} {
+		require.NoError(t, ValidateRedirectURI(uri), uri)
+	}
+	for _, uri := range []string{
+		"",
+		"/relative",
+		"https://",
+		"http://app.example.com/cb",
+		"https://app.example.com/cb#frag",
+		"https://app.example.com/cb#",
+		"https://*.example.com/cb",
+		"myapp://cb",
+		"://bad",
+	} {
+		require.ErrorIs(t, ValidateRedirectURI(uri), ErrInvalidRedirectURI, uri)
+	}
+}
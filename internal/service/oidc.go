@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IDTokenClaims 為 OpenID Connect Core §2 的 id_token 內容
+type IDTokenClaims struct {
+	Nonce    string           `json:"nonce,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AtHash   string           `json:"at_hash,omitempty"`
+	Authentication
+	jwt.RegisteredClaims
+}
+
+// ErrIDTokenUnavailable 表示未設定非對稱簽章金鑰；client 無法驗證以伺服器 JWT_SECRET 簽署的 id_token，因此不簽發
+var ErrIDTokenUnavailable = errors.New("id_token requires an asymmetric signing key")
+
+// OpenIDAvailable 回傳是否能簽發 id_token，即是否已設定非對稱簽章金鑰
+func OpenIDAvailable() bool {
+	return keyStore != nil
+}
+
+// IDTokenSigningAlgorithms 回傳 id_token 的簽章演算法供 discovery 公開；無法簽發 id_token 時為空
+func IDTokenSigningAlgorithms() []string {
+	if keyStore == nil {
+		return nil
+	}
+	return []string{keyStore.algorithm}
+}
+
+// IssueIDToken 依授權碼內容為 client 簽發 id_token；at_hash 綁定同時核發的 access token。
+// 未設定非對稱簽章金鑰時回傳 ErrIDTokenUnavailable
+func IssueIDToken(ctx context.Context, issuer string, data *AuthorizationCodeData, accessToken string, ttl time.Duration) (string, error) {
+	if !OpenIDAvailable() {
+		return "", ErrIDTokenUnavailable
+	}
+	signer, err := currentSigner(ctx)
+	if err != nil {
+		return "", err
+	}
+	now := timeNow()
+	claims := IDTokenClaims{
+		Nonce:          data.Nonce,
+		AtHash:         tokenHash(signer.method, accessToken),
+		Authentication: data.Authentication,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   fmt.Sprint(data.UserID),
+			Audience:  jwt.ClaimStrings{data.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	if data.AuthTime != 0 {
+		claims.AuthTime = jwt.NewNumericDate(time.Unix(data.AuthTime, 0))
+	}
+	return signer.sign(claims)
+}
+
+// tokenHash 依 OpenID Connect Core §3.1.3.6 計算 at_hash：取簽章演算法對應雜湊的左半部
+func tokenHash(method jwt.SigningMethod, token string) string {
+	var h hash.Hash
+	if method.Alg() == SigningAlgEdDSA {
+		h = sha512.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(token))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
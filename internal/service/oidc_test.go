@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDTokenSigningAlgorithms(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	require.False(t, OpenIDAvailable())
+	require.Empty(t, IDTokenSigningAlgorithms())
+
+	ks, _, _ := newTestKeyStore(t, SigningAlgES256)
+	UseKeyStore(ks)
+	require.True(t, OpenIDAvailable())
+	require.Equal(t, []string{SigningAlgES256}, IDTokenSigningAlgorithms())
+}
+
+func TestIssueIDToken(t *testing.T) {
+	ctx := context.Background()
+	data := &AuthorizationCodeData{UserID: 7, ClientID: "cid", Nonce: "n1"}
+
+	t.Run("no key store", func(t *testing.T) {
+		// 不以 JWT_SECRET 簽署 id_token
+		t.Cleanup(restoreGlobals)
+		t.Setenv("JWT_SECRET", "s")
+		_, err := IssueIDToken(ctx, "https://issuer", data, "at", time.Hour)
+		require.ErrorIs(t, err, ErrIDTokenUnavailable)
+	})
+
+	t.Run("es256", func(t *testing.T) {
+		t.Cleanup(restoreGlobals)
+		ks, _, _ := newTestKeyStore(t, SigningAlgES256)
+		UseKeyStore(ks)
+		now := time.Now().Truncate(time.Second)
+		timeNow = func() time.Time { return now }
+
+		tok, err := IssueIDToken(ctx, "https://issuer", data, "at", time.Hour)
+		require.NoError(t, err)
+		claims := &IDTokenClaims{}
+		_, err = jwt.ParseWithClaims(tok, claims, func(t *jwt.Token) (any, error) {
+			key, err := ks.verificationKey(ctx, t.Header["kid"].(string))
+			if err != nil {
+				return nil, err
+			}
+			return key.public, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "https://issuer", claims.Issuer)
+		require.Equal(t, "7", claims.Subject)
+		require.Equal(t, jwt.ClaimStrings{"cid"}, claims.Audience)
+		require.Equal(t, "n1", claims.Nonce)
+		require.Nil(t, claims.AuthTime)
+		require.Equal(t, now.Add(time.Hour).Unix(), claims.ExpiresAt.Unix())
+		sum := sha256.Sum256([]byte("at"))
+		require.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:16]), claims.AtHash)
+	})
+
+	t.Run("eddsa with auth time", func(t *testing.T) {
+		t.Cleanup(restoreGlobals)
+		ks, table, _ := newTestKeyStore(t, SigningAlgEdDSA)
+		UseKeyStore(ks)
+		withAuth := *data
+		withAuth.AuthTime = 1700000000
+
+		tok, err := IssueIDToken(ctx, "https://issuer", &withAuth, "at", time.Hour)
+		require.NoError(t, err)
+		claims := &IDTokenClaims{}
+		parsed, _, err := jwt.NewParser().ParseUnverified(tok, claims)
+		require.NoError(t, err)
+		require.Equal(t, SigningAlgEdDSA, parsed.Method.Alg())
+		require.Equal(t, table.find("active").KID, parsed.Header["kid"])
+		require.Equal(t, int64(1700000000), claims.AuthTime.Unix())
+		sum := sha512.Sum512([]byte("at"))
+		require.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:32]), claims.AtHash)
+	})
+}
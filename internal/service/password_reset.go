@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/mailer"
+	"life-is-hard/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// passwordResetTTL 為重設密碼 token 的效期
+	passwordResetTTL = time.Hour
+	// passwordResetRateWindow 內同一 Email 與同一 IP 可要求重設密碼的次數上限
+	passwordResetRateWindow = time.Hour
+	passwordResetEmailLimit = 3
+	passwordResetIPLimit    = 10
+)
+
+var (
+	ErrInvalidPasswordResetToken = errors.New("invalid or expired reset token")
+	ErrRateLimited               = errors.New("too many requests")
+
+	// passwordResetURL 為重設密碼信中的連結，token 以查詢參數附加
+	passwordResetURL string
+)
+
+// PasswordResetData 為重設密碼 token 在快取中的資料
+type PasswordResetData struct {
+	UserID int `json:"user_id"`
+	// PasswordFingerprint 為簽發時密碼雜湊的指紋；密碼之後若已變更則 token 失效
+	PasswordFingerprint string `json:"pwd"`
+}
+
+// ValidFor 回傳 token 簽發後使用者的密碼是否未曾變更
+func (d *PasswordResetData) ValidFor(user model.User) bool {
+	return d.UserID == user.ID &&
+		subtle.ConstantTimeCompare([]byte(d.PasswordFingerprint), []byte(passwordFingerprint(user.PasswordHash))) == 1
+}
+
+// UsePasswordResetURL 設定重設密碼信中的連結；空白時信中只附上 token
+func UsePasswordResetURL(u string) {
+	passwordResetURL = u
+}
+
+// AllowPasswordResetRequest 以固定時間窗限制同一 Email 與同一 IP 要求重設密碼的次數，超過時回傳 ErrRateLimited；
+// 不論帳號是否存在都會計數，避免回應差異透露帳號是否存在
+func AllowPasswordResetRequest(ctx context.Context, cache cache.Cache, email, ip string) error {
+	for _, l := range []struct {
+		key   string
+		limit int
+	}{
+		{"password_reset_rate:email:" + strings.ToLower(email), passwordResetEmailLimit},
+		{"password_reset_rate:ip:" + ip, passwordResetIPLimit},
+	} {
+		ok, err := allowRequest(ctx, cache, l.key, l.limit, passwordResetRateWindow)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrRateLimited
+		}
+	}
+	return nil
+}
+
+// IssuePasswordResetToken 產生重設密碼 token；明文只寄給使用者，快取僅保存其 SHA-256 雜湊
+func IssuePasswordResetToken(ctx context.Context, cache cache.Cache, user model.User) (string, error) {
+	b := make([]byte, 32)
+	if _, err := randRead(b); err != nil {
+		return "", fmt.Errorf("failed to generate reset token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	data, err := jsonMarshal(PasswordResetData{UserID: user.ID, PasswordFingerprint: passwordFingerprint(user.PasswordHash)})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal reset token data: %w", err)
+	}
+	if err := cache.Set(ctx, passwordResetKey(token), data, passwordResetTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed to store reset token: %w", err)
+	}
+	return token, nil
+}
+
+// ConsumePasswordResetToken 讀取並刪除重設密碼 token，確保同一 token 只能使用一次
+func ConsumePasswordResetToken(ctx context.Context, cache cache.Cache, token string) (*PasswordResetData, error) {
+	key := passwordResetKey(token)
+	val, err := cache.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrInvalidPasswordResetToken
+		}
+		return nil, fmt.Errorf("failed to retrieve reset token: %w", err)
+	}
+	deleted, err := cache.Del(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete reset token: %w", err)
+	}
+	if deleted == 0 {
+		return nil, ErrInvalidPasswordResetToken
+	}
+	var data PasswordResetData
+	if err := jsonUnmarshal([]byte(val), &data); err != nil {
+		return nil, fmt.Errorf("failed to parse reset token data: %w", err)
+	}
+	return &data, nil
+}
+
+// SendPasswordReset 產生重設密碼 token 並寄送至使用者的 Email
+func SendPasswordReset(ctx context.Context, cache cache.Cache, user model.User) error {
+	if mailSender == nil {
+		return fmt.Errorf("mailer not configured")
+	}
+	token, err := IssuePasswordResetToken(ctx, cache, user)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("%s 您好：\n\n我們收到重設密碼的要求，請在 %d 分鐘內完成。若非您本人操作，請忽略此信。\n\n",
+		user.Name, int(passwordResetTTL.Minutes()))
+	if passwordResetURL != "" {
+		link, err := url.Parse(passwordResetURL)
+		if err != nil {
+			return fmt.Errorf("invalid password reset URL: %w", err)
+		}
+		q := link.Query()
+		q.Set("token", token)
+		link.RawQuery = q.Encode()
+		body += fmt.Sprintf("請開啟以下連結：\n%s\n", link)
+	} else {
+		body += fmt.Sprintf("重設碼：\n%s\n", token)
+	}
+	if err := mailSender.Send(ctx, mailer.Message{To: user.Email, Subject: "重設您的密碼", Body: body}); err != nil {
+		return fmt.Errorf("failed to send password reset mail: %w", err)
+	}
+	return nil
+}
+
+func passwordResetKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("password_reset:%s", hex.EncodeToString(sum[:]))
+}
+
+func passwordFingerprint(passwordHash string) string {
+	sum := sha256.Sum256([]byte(passwordHash))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/mailer"
+	"life-is-hard/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordResetToken(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	user := model.User{ID: 7, Name: "alice", Email: "alice@example.com", PasswordHash: "hash"}
+	c := cache.NewMemoryCache(nil)
+
+	token, err := IssuePasswordResetToken(ctx, c, user)
+	require.NoError(t, err)
+	require.Len(t, token, 43)
+	require.Len(t, c.Data, 1)
+	for key := range c.Data {
+		// 快取只保存 token 的雜湊
+		require.True(t, strings.HasPrefix(key, "password_reset:"))
+		require.NotContains(t, key, token)
+		require.Equal(t, passwordResetTTL, c.TTLs[key])
+	}
+
+	data, err := ConsumePasswordResetToken(ctx, c, token)
+	require.NoError(t, err)
+	require.Equal(t, 7, data.UserID)
+	require.True(t, data.ValidFor(user))
+	require.Empty(t, c.Data)
+
+	// 密碼已變更或使用者不符時 token 失效
+	changed := user
+	changed.PasswordHash = "other"
+	require.False(t, data.ValidFor(changed))
+	other := user
+	other.ID = 8
+	require.False(t, data.ValidFor(other))
+
+	// token 只能使用一次
+	_, err = ConsumePasswordResetToken(ctx, c, token)
+	require.ErrorIs(t, err, ErrInvalidPasswordResetToken)
+	_, err = ConsumePasswordResetToken(ctx, c, "garbage")
+	require.ErrorIs(t, err, ErrInvalidPasswordResetToken)
+
+	t.Run("cache errors", func(t *testing.T) {
+		c := cache.NewMemoryCache(nil)
+		c.FailOn["set"] = "password_reset:"
+		_, err := IssuePasswordResetToken(ctx, c, user)
+		require.ErrorContains(t, err, "failed to store reset token")
+
+		c = cache.NewMemoryCache(nil)
+		token, err := IssuePasswordResetToken(ctx, c, user)
+		require.NoError(t, err)
+		c.FailOn["get"] = "password_reset:"
+		_, err = ConsumePasswordResetToken(ctx, c, token)
+		require.ErrorContains(t, err, "failed to retrieve reset token")
+
+		delete(c.FailOn, "get")
+		c.FailOn["del"] = "password_reset:"
+		_, err = ConsumePasswordResetToken(ctx, c, token)
+		require.ErrorContains(t, err, "failed to delete reset token")
+
+		delete(c.FailOn, "del")
+		for key := range c.Data {
+			c.Data[key] = "bad"
+		}
+		_, err = ConsumePasswordResetToken(ctx, c, token)
+		require.ErrorContains(t, err, "failed to parse reset token data")
+	})
+
+	t.Run("rand error", func(t *testing.T) {
+		randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+		_, err := IssuePasswordResetToken(ctx, c, user)
+		require.ErrorContains(t, err, "failed to generate reset token")
+	})
+}
+
+func TestAllowPasswordResetRequest(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewMemoryCache(nil)
+
+	for i := 0; i < passwordResetEmailLimit; i++ {
+		require.NoError(t, AllowPasswordResetRequest(ctx, c, "Alice@example.com", "1.2.3.4"))
+	}
+	require.Equal(t, "3", c.Data["password_reset_rate:email:alice@example.com"])
+	require.Equal(t, passwordResetRateWindow, c.TTLs["password_reset_rate:email:alice@example.com"])
+	// Email 不分大小寫
+	require.ErrorIs(t, AllowPasswordResetRequest(ctx, c, "alice@example.com", "5.6.7.8"), ErrRateLimited)
+
+	// 同一 IP 換 Email 仍受限
+	for i := 3; i < passwordResetIPLimit; i++ {
+		require.NoError(t, AllowPasswordResetRequest(ctx, c, "user"+string(rune('a'+i))+"@example.com", "1.2.3.4"))
+	}
+	require.ErrorIs(t, AllowPasswordResetRequest(ctx, c, "new@example.com", "1.2.3.4"), ErrRateLimited)
+
+	// 時間窗自第一次計入開始，之後的計入不延長；時間窗結束後由快取到期重新計數
+	require.NoError(t, AllowPasswordResetRequest(ctx, c, "bob@example.com", "9.9.9.9"))
+	require.NoError(t, AllowPasswordResetRequest(ctx, c, "bob@example.com", "9.9.9.9"))
+	require.Equal(t, "2", c.Data["password_reset_rate:email:bob@example.com"])
+	require.Equal(t, passwordResetRateWindow, c.TTLs["password_reset_rate:email:bob@example.com"])
+	require.NoError(t, c.Del(ctx, "password_reset_rate:email:alice@example.com", "password_reset_rate:ip:1.2.3.4").Err())
+	require.NoError(t, AllowPasswordResetRequest(ctx, c, "alice@example.com", "1.2.3.4"))
+	require.Equal(t, "1", c.Data["password_reset_rate:email:alice@example.com"])
+
+	c.FailOn["incr"] = "password_reset_rate:"
+	require.ErrorContains(t, AllowPasswordResetRequest(ctx, c, "x@example.com", "1.1.1.1"), "failed to record rate limit")
+	delete(c.FailOn, "incr")
+	c.FailOn["expire"] = "password_reset_rate:ip:"
+	require.ErrorContains(t, AllowPasswordResetRequest(ctx, c, "x@example.com", "1.1.1.1"), "failed to record rate limit")
+}
+
+func TestSendPasswordReset(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	user := model.User{ID: 7, Name: "alice", Email: "alice@example.com", PasswordHash: "hash"}
+	c := cache.NewMemoryCache(nil)
+
+	require.ErrorContains(t, SendPasswordReset(ctx, c, user), "mailer not configured")
+
+	outbox := mailer.NewOutbox("")
+	UseMailer(outbox)
+	require.NoError(t, SendPasswordReset(ctx, c, user))
+	msg := outbox.Messages()[0]
+	require.Equal(t, "alice@example.com", msg.To)
+	require.Contains(t, msg.Body, "重設碼")
+
+	UsePasswordResetURL("https://app.example.com/reset-password?lang=zh")
+	require.NoError(t, SendPasswordReset(ctx, c, user))
+	body := outbox.Messages()[1].Body
+	start := strings.Index(body, "https://")
+	link, err := url.Parse(strings.TrimSpace(body[start:]))
+	require.NoError(t, err)
+	require.Equal(t, "zh", link.Query().Get("lang"))
+	data, err := ConsumePasswordResetToken(ctx, c, link.Query().Get("token"))
+	require.NoError(t, err)
+	require.Equal(t, 7, data.UserID)
+
+	UsePasswordResetURL("://bad")
+	require.ErrorContains(t, SendPasswordReset(ctx, c, user), "invalid password reset URL")
+
+	UsePasswordResetURL("")
+	UseMailer(failingMailer{})
+	require.ErrorContains(t, SendPasswordReset(ctx, c, user), "smtp down")
+
+	c.FailOn["set"] = "password_reset:"
+	require.ErrorContains(t, SendPasswordReset(ctx, c, user), "failed to store reset token")
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RequestURIPrefix 為 RFC 9126 §2.2 建議的 request_uri 前綴
+const RequestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+var ErrRequestURINotFound = errors.New("request_uri not found or expired")
+
+// PushedAuthorizationData 為經由 PAR 推送、等待 authorize 端點使用的授權請求
+type PushedAuthorizationData struct {
+	ClientID            string `json:"client_id"`
+	ResponseType        string `json:"response_type"`
+	RedirectURI         string `json:"redirect_uri,omitempty"`
+	Scope               string `json:"scope,omitempty"`
+	State               string `json:"state,omitempty"`
+	CodeChallenge       string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+}
+
+// PushAuthorizationRequest 依 RFC 9126 保存授權請求並回傳對應的 request_uri
+func PushAuthorizationRequest(ctx context.Context, cache cache.Cache, data PushedAuthorizationData, ttl time.Duration) (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	bytesData, err := jsonMarshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal pushed authorization request: %w", err)
+	}
+	if err := cache.Set(ctx, pushedAuthorizationKey(id), bytesData, ttl).Err(); err != nil {
+		return "", fmt.Errorf("failed to store pushed authorization request: %w", err)
+	}
+	return RequestURIPrefix + id, nil
+}
+
+// ConsumePushedAuthorizationRequest 讀取並刪除 request_uri 對應的授權請求；
+// request_uri 僅限推送它的 client 使用一次
+func ConsumePushedAuthorizationRequest(ctx context.Context, cache cache.Cache, clientID, requestURI string) (*PushedAuthorizationData, error) {
+	id, ok := strings.CutPrefix(requestURI, RequestURIPrefix)
+	if !ok || id == "" {
+		return nil, ErrRequestURINotFound
+	}
+	key := pushedAuthorizationKey(id)
+	val, err := cache.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrRequestURINotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve pushed authorization request: %w", err)
+	}
+	var data PushedAuthorizationData
+	if err := jsonUnmarshal([]byte(val), &data); err != nil {
+		return nil, fmt.Errorf("failed to parse pushed authorization request: %w", err)
+	}
+	// 其他 client 不可使用或消耗此 request_uri
+	if data.ClientID != clientID {
+		return nil, ErrRequestURINotFound
+	}
+	deleted, err := cache.Del(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete pushed authorization request: %w", err)
+	}
+	if deleted == 0 {
+		return nil, ErrRequestURINotFound
+	}
+	return &data, nil
+}
+
+func pushedAuthorizationKey(id string) string {
+	return fmt.Sprintf("pushed_authorization_request:%s", id)
+}
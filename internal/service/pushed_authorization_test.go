@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushAuthorizationRequest(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	rc := cache.NewMemoryCache(nil)
+	data := PushedAuthorizationData{ClientID: "cid", ResponseType: "code", State: "st"}
+
+	requestURI, err := PushAuthorizationRequest(ctx, rc, data, time.Minute)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(requestURI, RequestURIPrefix))
+	key := "pushed_authorization_request:" + strings.TrimPrefix(requestURI, RequestURIPrefix)
+	require.Equal(t, time.Minute, rc.TTLs[key])
+	require.Contains(t, rc.Data[key], `"state":"st"`)
+
+	rc.FailOn["set"] = "pushed_authorization_request:"
+	_, err = PushAuthorizationRequest(ctx, rc, data, time.Minute)
+	require.ErrorContains(t, err, "failed to store pushed authorization request")
+
+	jsonMarshal = func(any) ([]byte, error) { return nil, errors.New("json") }
+	_, err = PushAuthorizationRequest(ctx, rc, data, time.Minute)
+	require.ErrorContains(t, err, "failed to marshal pushed authorization request")
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+	_, err = PushAuthorizationRequest(ctx, rc, data, time.Minute)
+	require.Error(t, err)
+}
+
+func TestConsumePushedAuthorizationRequest(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	const requestURI = RequestURIPrefix + "abc"
+	const key = "pushed_authorization_request:abc"
+	stored := `{"client_id":"cid","response_type":"code","nonce":"n1"}`
+
+	t.Run("success and single use", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{key: stored})
+		data, err := ConsumePushedAuthorizationRequest(ctx, rc, "cid", requestURI)
+		require.NoError(t, err)
+		require.Equal(t, &PushedAuthorizationData{ClientID: "cid", ResponseType: "code", Nonce: "n1"}, data)
+		require.NotContains(t, rc.Data, key)
+
+		_, err = ConsumePushedAuthorizationRequest(ctx, rc, "cid", requestURI)
+		require.ErrorIs(t, err, ErrRequestURINotFound)
+	})
+
+	t.Run("other client", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{key: stored})
+		_, err := ConsumePushedAuthorizationRequest(ctx, rc, "other", requestURI)
+		require.ErrorIs(t, err, ErrRequestURINotFound)
+		// 不可因其他 client 的請求而失效
+		require.Contains(t, rc.Data, key)
+	})
+
+	t.Run("malformed request_uri", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{key: stored})
+		for _, uri := range []string{"abc", RequestURIPrefix, "https://example.com/abc"} {
+			_, err := ConsumePushedAuthorizationRequest(ctx, rc, "cid", uri)
+			require.ErrorIs(t, err, ErrRequestURINotFound, uri)
+		}
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{key: stored})
+		rc.FailOn["get"] = "pushed_authorization_request:"
+		_, err := ConsumePushedAuthorizationRequest(ctx, rc, "cid", requestURI)
+		require.ErrorContains(t, err, "failed to retrieve pushed authorization request")
+
+		rc = cache.NewMemoryCache(map[string]string{key: stored})
+		rc.FailOn["del"] = "pushed_authorization_request:"
+		_, err = ConsumePushedAuthorizationRequest(ctx, rc, "cid", requestURI)
+		require.ErrorContains(t, err, "failed to delete pushed authorization request")
+	})
+
+	t.Run("concurrent consume", func(t *testing.T) {
+		fc := &cache.FakeCache{
+			GetFn: func(context.Context, string) *redis.StringCmd { return redis.NewStringResult(stored, nil) },
+			DelFn: func(context.Context, ...string) *redis.IntCmd { return redis.NewIntResult(0, nil) },
+		}
+		_, err := ConsumePushedAuthorizationRequest(ctx, fc, "cid", requestURI)
+		require.ErrorIs(t, err, ErrRequestURINotFound)
+	})
+
+	t.Run("corrupt data", func(t *testing.T) {
+		rc := cache.NewMemoryCache(map[string]string{key: "{"})
+		_, err := ConsumePushedAuthorizationRequest(ctx, rc, "cid", requestURI)
+		require.ErrorContains(t, err, "failed to parse pushed authorization request")
+	})
+}
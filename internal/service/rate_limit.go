@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"life-is-hard/internal/cache"
+)
+
+// countRate 在 key 的固定時間窗內計入一次並回傳計入後的次數；以 INCR 原子遞增，
+// 併發請求不會互相覆蓋。時間窗自第一次計入開始，由快取於時間窗結束時到期重設
+func countRate(ctx context.Context, cache cache.Cache, key string, window time.Duration) (int64, error) {
+	count, err := cache.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to record rate limit: %w", err)
+	}
+	if count == 1 {
+		if err := cache.Expire(ctx, key, window).Err(); err != nil {
+			return 0, fmt.Errorf("failed to record rate limit: %w", err)
+		}
+	}
+	return count, nil
+}
+
+// allowRequest 計入一次請求；時間窗內超過 limit 時回傳 false
+func allowRequest(ctx context.Context, cache cache.Cache, key string, limit int, window time.Duration) (bool, error) {
+	count, err := countRate(ctx, cache, key, window)
+	if err != nil {
+		return false, err
+	}
+	return count <= int64(limit), nil
+}
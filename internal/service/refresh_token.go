@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrRefreshTokenBindingMismatch 表示 DPoP 綁定的 refresh token 未以同一把金鑰出示 proof
+	ErrRefreshTokenBindingMismatch = errors.New("refresh token is bound to a different DPoP key")
+)
+
+// RotateRefreshToken 以 refresh token 換發同一 family 的新 token，舊 token 自此失效並記為已輪替；
+// 新 token 保留原授權的 scope，回傳內容的 Scope 則為依 scope 縮減後供 access token 使用的範圍，
+// 超出原授權時回傳 ErrInvalidScope 且不輪替。
+// 已輪替的 token 再次出現代表可能外洩：撤銷整個 family 並回傳 *ReusedRefreshTokenError 供稽核；
+// 不屬於 clientID 的 token 視為不存在；綁定 DPoP 金鑰的 token 須以相同的 jkt 輪替，否則回傳
+// ErrRefreshTokenBindingMismatch 且不輪替。新 token 的效期依 lifetimes 決定，且不超過 family 的絕對到期時間。
+// 舊 token 以刪除成功與否認領，併發輪替同一 token 時僅有一方成功
+func RotateRefreshToken(ctx context.Context, cache cache.Cache, clientID, token, jkt, scope string, lifetimes TokenLifetimes) (*RefreshTokenData, string, error) {
+	data, err := ValidateRefreshToken(ctx, cache, token)
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return nil, "", detectRefreshTokenReuse(ctx, cache, clientID, token)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if data.ClientID != clientID {
+		return nil, "", ErrRefreshTokenNotFound
+	}
+	if data.JKT != "" && data.JKT != jkt {
+		return nil, "", ErrRefreshTokenBindingMismatch
+	}
+	accessScope, err := DownscopeScope(scope, data.Scope)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := timeNow()
+	if data.FamilyExpiresAt != 0 && now.Unix() >= data.FamilyExpiresAt {
+		return nil, "", ErrRefreshTokenNotFound
+	}
+	ttl := lifetimes.refreshTokenTTL(now, data.FamilyExpiresAt)
+	next := *data
+	next.IssuedAt = now.Unix()
+	next.ExpiresAt = now.Add(ttl).Unix()
+	// 記錄 family 起始時間前發出的 token，以原 token 的發行時間代替
+	next.FamilyIssuedAt = familyIssuedAt(data)
+	if next.FamilyID == "" {
+		// 輪替機制上線前發出的 token 沒有 family，自此開始一個新的 family；
+		// 由舊 token 推導，併發輪替時寫入的輪替紀錄才會指向同一個 family
+		sum := sha256.Sum256([]byte(token))
+		next.FamilyID = base64.RawURLEncoding.EncodeToString(sum[:16])
+	}
+
+	// 先寫入輪替紀錄再刪除舊 token：刪除後的重用必定能被偵測，寫入失敗時舊 token 仍然有效。
+	// 紀錄保留至舊 token 原本的到期時間，期間內的重用都能被偵測
+	rotatedTTL := time.Unix(data.ExpiresAt, 0).Sub(now)
+	if data.ExpiresAt == 0 || rotatedTTL <= 0 {
+		rotatedTTL = ttl
+	}
+	data.FamilyID = next.FamilyID
+	rotated, err := jsonMarshal(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal refresh token data: %w", err)
+	}
+	if err := cache.Set(ctx, fmt.Sprintf("rotated_refresh_token:%s", token), rotated, rotatedTTL).Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to mark refresh token rotated: %w", err)
+	}
+	// 僅有成功刪除舊 token 的呼叫者可以輪替，避免併發請求以同一 token 換出多個有效 token；
+	// 落敗者寫入的輪替紀錄與勝出者相同，保留即可
+	deleted, err := cache.Del(ctx, fmt.Sprintf("refresh_token:%s", token)).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	if deleted == 0 {
+		return nil, "", ErrRefreshTokenNotFound
+	}
+	newToken, err := storeRefreshToken(ctx, cache, next, ttl)
+	if err != nil {
+		return nil, "", err
+	}
+	next.Scope = accessScope
+	return &next, newToken, nil
+}
+
+// ReusedRefreshTokenError 攜帶被重用 token 的內容，以 errors.Is 比對時等同 ErrRefreshTokenReused
+type ReusedRefreshTokenError struct {
+	Data *RefreshTokenData
+}
+
+func (e *ReusedRefreshTokenError) Error() string { return ErrRefreshTokenReused.Error() }
+
+func (e *ReusedRefreshTokenError) Unwrap() error { return ErrRefreshTokenReused }
+
+// detectRefreshTokenReuse 檢查找不到的 token 是否為已輪替的 token，是則撤銷其 family
+func detectRefreshTokenReuse(ctx context.Context, cache cache.Cache, clientID, token string) error {
+	val, err := cache.Get(ctx, fmt.Sprintf("rotated_refresh_token:%s", token)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return ErrRefreshTokenNotFound
+		}
+		return fmt.Errorf("failed to retrieve rotated refresh token: %w", err)
+	}
+	var data RefreshTokenData
+	if err := jsonUnmarshal([]byte(val), &data); err != nil {
+		return fmt.Errorf("failed to parse refresh token data: %w", err)
+	}
+	if data.ClientID != clientID {
+		return ErrRefreshTokenNotFound
+	}
+	if err := RevokeRefreshTokenFamily(ctx, cache, data.FamilyID); err != nil {
+		return err
+	}
+	return &ReusedRefreshTokenError{Data: &data}
+}
+
+// RevokeRefreshTokenFamily 撤銷 family 目前有效的 refresh token，使整條輪替鏈失效
+func RevokeRefreshTokenFamily(ctx context.Context, cache cache.Cache, familyID string) error {
+	familyKey := fmt.Sprintf("refresh_token_family:%s", familyID)
+	current, err := cache.Get(ctx, familyKey).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil
+		}
+		return fmt.Errorf("failed to retrieve refresh token family: %w", err)
+	}
+	if err := cache.Del(ctx, fmt.Sprintf("refresh_token:%s", current), familyKey).Err(); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	return nil
+}
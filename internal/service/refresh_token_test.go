@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+
+	stored := func(d RefreshTokenData) string {
+		b, _ := json.Marshal(d)
+		return string(b)
+	}
+	live := RefreshTokenData{UserID: 1, ClientID: "cid", Scope: "openid users:read", IssuedAt: now.Add(-time.Hour).Unix(), ExpiresAt: now.Add(time.Hour).Unix(), FamilyID: "fam"}
+	newCache := func() *cache.MemoryCache {
+		return cache.NewMemoryCache(map[string]string{
+			"refresh_token:old":        stored(live),
+			"refresh_token_family:fam": "old",
+		})
+	}
+
+	t.Run("rotate then reuse", func(t *testing.T) {
+		c := newCache()
+		data, tok, err := RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: 24 * time.Hour})
+		require.NoError(t, err)
+		require.NotEqual(t, "old", tok)
+		require.Equal(t, "fam", data.FamilyID)
+		require.Equal(t, "openid users:read", data.Scope)
+		require.Equal(t, now.Unix(), data.IssuedAt)
+		require.Equal(t, now.Add(24*time.Hour).Unix(), data.ExpiresAt)
+		require.NotContains(t, c.Data, "refresh_token:old")
+		require.Contains(t, c.Data, "refresh_token:"+tok)
+		require.Equal(t, tok, c.Data["refresh_token_family:fam"])
+		require.Equal(t, time.Hour, c.TTLs["rotated_refresh_token:old"])
+
+		// 再次使用舊 token：family 被撤銷
+		_, _, err = RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: 24 * time.Hour})
+		require.ErrorIs(t, err, ErrRefreshTokenReused)
+		var reused *ReusedRefreshTokenError
+		require.ErrorAs(t, err, &reused)
+		require.Equal(t, 1, reused.Data.UserID)
+		require.Equal(t, "fam", reused.Data.FamilyID)
+		require.Equal(t, "refresh token reused", err.Error())
+		require.NotContains(t, c.Data, "refresh_token:"+tok)
+		require.NotContains(t, c.Data, "refresh_token_family:fam")
+
+		_, _, err = RotateRefreshToken(ctx, c, "cid", tok, "", "", TokenLifetimes{RefreshTokenTTL: 24 * time.Hour})
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	})
+
+	t.Run("absolute and idle lifetime", func(t *testing.T) {
+		capped := live
+		capped.FamilyExpiresAt = now.Add(2 * time.Hour).Unix()
+		c := cache.NewMemoryCache(map[string]string{"refresh_token:old": stored(capped)})
+		data, tok, err := RotateRefreshToken(ctx, c, "cid", "old", "", "",
+			TokenLifetimes{RefreshTokenTTL: 24 * time.Hour, RefreshTokenIdleTimeout: 3 * time.Hour})
+		require.NoError(t, err)
+		require.Equal(t, capped.FamilyExpiresAt, data.FamilyExpiresAt)
+		require.Equal(t, capped.FamilyExpiresAt, data.ExpiresAt)
+		require.Equal(t, 2*time.Hour, c.TTLs["refresh_token:"+tok])
+
+		c = cache.NewMemoryCache(map[string]string{"refresh_token:old": stored(live)})
+		_, tok, err = RotateRefreshToken(ctx, c, "cid", "old", "", "",
+			TokenLifetimes{RefreshTokenTTL: 24 * time.Hour, RefreshTokenIdleTimeout: 3 * time.Hour})
+		require.NoError(t, err)
+		require.Equal(t, 3*time.Hour, c.TTLs["refresh_token:"+tok])
+
+		// 超過 family 的絕對效期後不可再輪替
+		capped.FamilyExpiresAt = now.Unix()
+		c = cache.NewMemoryCache(map[string]string{"refresh_token:old": stored(capped)})
+		_, _, err = RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+		require.Contains(t, c.Data, "refresh_token:old")
+	})
+
+	t.Run("dpop-bound token", func(t *testing.T) {
+		bound := live
+		bound.JKT = "jkt"
+		c := cache.NewMemoryCache(map[string]string{"refresh_token:old": stored(bound), "refresh_token_family:fam": "old"})
+		_, _, err := RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.ErrorIs(t, err, ErrRefreshTokenBindingMismatch)
+		_, _, err = RotateRefreshToken(ctx, c, "cid", "old", "other", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.ErrorIs(t, err, ErrRefreshTokenBindingMismatch)
+		require.Contains(t, c.Data, "refresh_token:old")
+
+		data, _, err := RotateRefreshToken(ctx, c, "cid", "old", "jkt", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.NoError(t, err)
+		require.Equal(t, "jkt", data.JKT)
+	})
+
+	t.Run("legacy token without family", func(t *testing.T) {
+		c := cache.NewMemoryCache(map[string]string{"refresh_token:old": stored(RefreshTokenData{UserID: 1, ClientID: "cid"})})
+		data, tok, err := RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: 24 * time.Hour})
+		require.NoError(t, err)
+		require.NotEmpty(t, data.FamilyID)
+		require.Equal(t, tok, c.Data["refresh_token_family:"+data.FamilyID])
+		require.Equal(t, 24*time.Hour, c.TTLs["rotated_refresh_token:old"])
+
+		// 同一個舊 token 的併發輪替得到相同的 family
+		c = cache.NewMemoryCache(map[string]string{"refresh_token:old": stored(RefreshTokenData{UserID: 1, ClientID: "cid"})})
+		again, _, err := RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: 24 * time.Hour})
+		require.NoError(t, err)
+		require.Equal(t, data.FamilyID, again.FamilyID)
+	})
+
+	t.Run("other client", func(t *testing.T) {
+		c := newCache()
+		_, _, err := RotateRefreshToken(ctx, c, "other", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+		require.Contains(t, c.Data, "refresh_token:old")
+
+		c = cache.NewMemoryCache(map[string]string{
+			"rotated_refresh_token:old": stored(live),
+			"refresh_token_family:fam":  "cur",
+			"refresh_token:cur":         stored(live),
+		})
+		_, _, err = RotateRefreshToken(ctx, c, "other", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+		require.Contains(t, c.Data, "refresh_token:cur")
+	})
+
+	t.Run("downscope", func(t *testing.T) {
+		c := newCache()
+		_, _, err := RotateRefreshToken(ctx, c, "cid", "old", "", "openid users:write", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.ErrorIs(t, err, ErrInvalidScope)
+		require.Contains(t, c.Data, "refresh_token:old")
+
+		data, tok, err := RotateRefreshToken(ctx, c, "cid", "old", "", "openid", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.NoError(t, err)
+		require.Equal(t, "openid", data.Scope)
+		var stored RefreshTokenData
+		require.NoError(t, json.Unmarshal([]byte(c.Data["refresh_token:"+tok]), &stored))
+		require.Equal(t, "openid users:read", stored.Scope)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		_, _, err := RotateRefreshToken(ctx, cache.NewMemoryCache(nil), "cid", "x", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	})
+
+	t.Run("concurrently rotated", func(t *testing.T) {
+		// 讀取舊 token 後被另一個請求搶先輪替
+		rc := newCache()
+		c := &cache.FakeCache{
+			GetFn: func(ctx context.Context, key string) *redis.StringCmd {
+				res := rc.Get(ctx, key)
+				delete(rc.Data, key)
+				return res
+			},
+			SetFn: rc.Set,
+			DelFn: rc.Del,
+		}
+		_, _, err := RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.ErrorIs(t, err, ErrRefreshTokenNotFound)
+		require.Contains(t, rc.Data, "rotated_refresh_token:old")
+		require.Equal(t, "old", rc.Data["refresh_token_family:fam"])
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		for _, tc := range []struct{ op, prefix string }{
+			{"get", "refresh_token:"},
+			{"set", "refresh_token:"},
+			{"set", "rotated_refresh_token:"},
+			{"del", "refresh_token:old"},
+		} {
+			c := newCache()
+			c.FailOn[tc.op] = tc.prefix
+			_, _, err := RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+			require.Error(t, err, tc)
+			require.NotErrorIs(t, err, ErrRefreshTokenNotFound)
+		}
+
+		// 輪替紀錄寫入失敗時舊 token 仍可使用
+		c := newCache()
+		c.FailOn["set"] = "rotated_refresh_token:"
+		_, _, err := RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.Error(t, err)
+		require.Contains(t, c.Data, "refresh_token:old")
+		delete(c.FailOn, "set")
+		_, _, err = RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.NoError(t, err)
+	})
+
+	t.Run("marshal error", func(t *testing.T) {
+		t.Cleanup(func() { jsonMarshal = json.Marshal })
+		calls := 0
+		jsonMarshal = func(v any) ([]byte, error) {
+			if calls++; calls > 1 {
+				return nil, errors.New("json")
+			}
+			return json.Marshal(v)
+		}
+		_, _, err := RotateRefreshToken(ctx, newCache(), "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.Error(t, err)
+	})
+
+	t.Run("reuse errors", func(t *testing.T) {
+		rotated := map[string]string{
+			"rotated_refresh_token:old": stored(live),
+			"refresh_token_family:fam":  "cur",
+		}
+		for _, tc := range []struct{ op, prefix string }{
+			{"get", "rotated_refresh_token:"},
+			{"get", "refresh_token_family:"},
+			{"del", "refresh_token:cur"},
+		} {
+			data := map[string]string{}
+			for k, v := range rotated {
+				data[k] = v
+			}
+			c := cache.NewMemoryCache(data)
+			c.FailOn[tc.op] = tc.prefix
+			_, _, err := RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+			require.Error(t, err, tc)
+			require.NotErrorIs(t, err, ErrRefreshTokenReused)
+		}
+
+		c := cache.NewMemoryCache(map[string]string{"rotated_refresh_token:old": "{"})
+		_, _, err := RotateRefreshToken(ctx, c, "cid", "old", "", "", TokenLifetimes{RefreshTokenTTL: time.Hour})
+		require.Error(t, err)
+	})
+}
+
+func TestRevokeRefreshTokenFamily(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewMemoryCache(nil)
+	require.NoError(t, RevokeRefreshTokenFamily(ctx, c, "missing"))
+
+	c = cache.NewMemoryCache(map[string]string{"refresh_token_family:f": "cur", "refresh_token:cur": "{}", "refresh_token:other": "{}"})
+	require.NoError(t, RevokeRefreshTokenFamily(ctx, c, "f"))
+	require.Equal(t, map[string]string{"refresh_token:other": "{}"}, c.Data)
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"life-is-hard/internal/cache"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+func init() {
+	// token 的 iat 精確至毫秒，同一秒內撤銷工作階段後重新登入取得的 token 才不會被誤判為撤銷前發行
+	jwt.TimePrecision = time.Millisecond
+}
+
+// IsAccessTokenRevoked 檢查 access token 的 jti 是否在撤銷清單中，以及代表使用者的 token 是否發行於使用者撤銷所有工作階段之前；
+// 無 jti 的 token 無法個別撤銷
+func IsAccessTokenRevoked(ctx context.Context, cache cache.Cache, claims *CustomClaims) (bool, error) {
+	if claims.ID != "" {
+		key := fmt.Sprintf("revoked_access_token:%s", claims.ID)
+		err := cache.Get(ctx, key).Err()
+		if err == nil {
+			return true, nil
+		}
+		if err != redis.Nil {
+			return false, fmt.Errorf("failed to check token revocation: %w", err)
+		}
+	}
+	// client 自身的 token（sub 為 client_id）不屬於使用者的工作階段
+	if claims.UserID == 0 || claims.Subject != strconv.Itoa(claims.UserID) || claims.IssuedAt == nil {
+		return false, nil
+	}
+	return userSessionsRevoked(ctx, cache, claims.UserID, claims.IssuedAt.Time)
+}
+
+// RevokeUserSessions 記錄使用者撤銷所有工作階段（如重設密碼），此前發行給該使用者的 access token 與 refresh token 自此皆失效；
+// token 未依使用者建立索引，因此以撤銷時間（Unix 毫秒）標記，於驗證 token 時比對。
+// 標記保留至撤銷前發行的 token 皆已到期為止
+func RevokeUserSessions(ctx context.Context, cache cache.Cache, userID int) error {
+	revokedAt := strconv.FormatInt(timeNow().UnixMilli(), 10)
+	if err := cache.Set(ctx, revokedUserSessionsKey(userID), revokedAt, MaxRefreshTokenTTL).Err(); err != nil {
+		return fmt.Errorf("failed to revoke user sessions: %w", err)
+	}
+	return nil
+}
+
+// userSessionsRevoked 回傳於 issuedAt 發行的 token 是否在使用者撤銷所有工作階段之時或之前發行
+func userSessionsRevoked(ctx context.Context, cache cache.Cache, userID int, issuedAt time.Time) (bool, error) {
+	val, err := cache.Get(ctx, revokedUserSessionsKey(userID)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to retrieve session revocation: %w", err)
+	}
+	revokedAt, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse session revocation: %w", err)
+	}
+	return issuedAt.UnixMilli() <= revokedAt, nil
+}
+
+func revokedUserSessionsKey(userID int) string {
+	return fmt.Sprintf("revoked_user_sessions:%d", userID)
+}
+
+// RevokeAccessToken 將 access token 的 jti 加入撤銷清單，保留至 token 原本的到期時間
+func RevokeAccessToken(ctx context.Context, cache cache.Cache, claims *CustomClaims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	ttl := claims.ExpiresAt.Sub(timeNow())
+	if ttl <= 0 {
+		return nil
+	}
+	key := fmt.Sprintf("revoked_access_token:%s", claims.ID)
+	if err := cache.Set(ctx, key, "1", ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+	return nil
+}
+
+// RevokeRefreshToken 直接刪除快取中的 refresh token
+func RevokeRefreshToken(ctx context.Context, cache cache.Cache, token string) error {
+	key := fmt.Sprintf("refresh_token:%s", token)
+	if err := cache.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	return nil
+}
+
+type tokenRevoker func(ctx context.Context, cache cache.Cache, clientID, token string) (bool, error)
+
+// RevokeToken 依 RFC 7009 撤銷屬於 clientID 的 token。hint 僅決定查找順序；
+// 無效、過期或不屬於該 client 的 token 一律視為成功，不透露 token 狀態
+func RevokeToken(ctx context.Context, cache cache.Cache, clientID, token, hint string) error {
+	lookups := []tokenRevoker{
+		revokeRefreshTokenOf,
+		revokeAccessTokenOf,
+	}
+	if hint == "access_token" {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+	for _, lookup := range lookups {
+		found, err := lookup(ctx, cache, clientID, token)
+		if err != nil || found {
+			return err
+		}
+	}
+	return nil
+}
+
+func revokeRefreshTokenOf(ctx context.Context, cache cache.Cache, clientID, token string) (bool, error) {
+	data, err := ValidateRefreshToken(ctx, cache, token)
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if data.ClientID != clientID {
+		return true, nil
+	}
+	return true, RevokeRefreshToken(ctx, cache, token)
+}
+
+func revokeAccessTokenOf(ctx context.Context, cache cache.Cache, clientID, token string) (bool, error) {
+	claims, err := parseAccessToken(ctx, token)
+	if err != nil {
+		return false, nil
+	}
+	if claims.ClientID != clientID {
+		return true, nil
+	}
+	return true, RevokeAccessToken(ctx, cache, claims)
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAccessTokenRevoked(t *testing.T) {
+	ctx := context.Background()
+	c := &cache.FakeCache{}
+
+	revoked, err := IsAccessTokenRevoked(ctx, c, &CustomClaims{})
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	claims := &CustomClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "jti"}}
+	var gotKey string
+	c.GetFn = func(_ context.Context, key string) *redis.StringCmd {
+		gotKey = key
+		return redis.NewStringResult("", redis.Nil)
+	}
+	revoked, err = IsAccessTokenRevoked(ctx, c, claims)
+	require.NoError(t, err)
+	require.False(t, revoked)
+	require.Equal(t, "revoked_access_token:jti", gotKey)
+
+	c.GetFn = func(context.Context, string) *redis.StringCmd { return redis.NewStringResult("", errors.New("get")) }
+	_, err = IsAccessTokenRevoked(ctx, c, claims)
+	require.Error(t, err)
+
+	c.GetFn = func(context.Context, string) *redis.StringCmd { return redis.NewStringResult("1", nil) }
+	revoked, err = IsAccessTokenRevoked(ctx, c, claims)
+	require.NoError(t, err)
+	require.True(t, revoked)
+}
+
+func TestIsAccessTokenRevokedUserSessions(t *testing.T) {
+	ctx := context.Background()
+	sessionsRevokedAt := redis.NewStringResult("", redis.Nil)
+	c := &cache.FakeCache{GetFn: func(_ context.Context, key string) *redis.StringCmd {
+		if key == "revoked_user_sessions:1" {
+			return sessionsRevokedAt
+		}
+		return redis.NewStringResult("", redis.Nil)
+	}}
+	user := func(iat int64) *CustomClaims {
+		return &CustomClaims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{
+			ID: "jti", Subject: "1", IssuedAt: jwt.NewNumericDate(time.UnixMilli(iat)),
+		}}
+	}
+
+	revoked, err := IsAccessTokenRevoked(ctx, c, user(100))
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	sessionsRevokedAt = redis.NewStringResult("100", nil)
+	revoked, err = IsAccessTokenRevoked(ctx, c, user(100))
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	// 撤銷後發行的 token 仍有效，即使與撤銷發生在同一秒
+	revoked, err = IsAccessTokenRevoked(ctx, c, user(101))
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	// client 自身的 token 不受影響
+	clientToken := user(100)
+	clientToken.Subject = "cid"
+	revoked, err = IsAccessTokenRevoked(ctx, c, clientToken)
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	sessionsRevokedAt = redis.NewStringResult("bad", nil)
+	_, err = IsAccessTokenRevoked(ctx, c, user(100))
+	require.ErrorContains(t, err, "failed to parse session revocation")
+
+	sessionsRevokedAt = redis.NewStringResult("", errors.New("get"))
+	_, err = IsAccessTokenRevoked(ctx, c, user(100))
+	require.ErrorContains(t, err, "failed to retrieve session revocation")
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	timeNow = func() time.Time { return time.Unix(1700000000, 0) }
+	var gotKey string
+	var gotVal any
+	var gotTTL time.Duration
+	c := &cache.FakeCache{SetFn: func(_ context.Context, key string, val any, ttl time.Duration) *redis.StatusCmd {
+		gotKey, gotVal, gotTTL = key, val, ttl
+		return redis.NewStatusResult("OK", nil)
+	}}
+	require.NoError(t, RevokeUserSessions(ctx, c, 7))
+	require.Equal(t, "revoked_user_sessions:7", gotKey)
+	require.Equal(t, "1700000000000", gotVal)
+	require.Equal(t, MaxRefreshTokenTTL, gotTTL)
+
+	c.SetFn = func(context.Context, string, any, time.Duration) *redis.StatusCmd {
+		return redis.NewStatusResult("", errors.New("set"))
+	}
+	require.ErrorContains(t, RevokeUserSessions(ctx, c, 7), "failed to revoke user sessions")
+}
+
+func TestRevokeUserSessionsSameSecond(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	t.Setenv("JWT_SECRET", "s")
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	timeNow = func() time.Time { return now }
+	c := cache.NewMemoryCache(nil)
+
+	before, err := IssueAccessToken(ctx, model.User{ID: 1}, "", "", nil, time.Hour, nil, Authentication{})
+	require.NoError(t, err)
+	now = now.Add(time.Millisecond)
+	require.NoError(t, RevokeUserSessions(ctx, c, 1))
+	now = now.Add(time.Millisecond)
+	after, err := IssueAccessToken(ctx, model.User{ID: 1}, "", "", nil, time.Hour, nil, Authentication{})
+	require.NoError(t, err)
+
+	// 同一秒內撤銷前後發行的 token 依毫秒區分
+	for token, want := range map[string]bool{before: true, after: false} {
+		claims, err := parseAccessToken(ctx, token)
+		require.NoError(t, err)
+		revoked, err := IsAccessTokenRevoked(ctx, c, claims)
+		require.NoError(t, err)
+		require.Equal(t, want, revoked)
+	}
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	c := &cache.FakeCache{}
+
+	// 無 jti 或已過期不需寫入
+	require.NoError(t, RevokeAccessToken(ctx, c, &CustomClaims{}))
+	expired := &CustomClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "j", ExpiresAt: jwt.NewNumericDate(now.Add(-time.Second))}}
+	require.NoError(t, RevokeAccessToken(ctx, c, expired))
+
+	claims := &CustomClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "j", ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}}
+	c.SetFn = func(context.Context, string, any, time.Duration) *redis.StatusCmd {
+		return redis.NewStatusResult("", errors.New("set"))
+	}
+	require.Error(t, RevokeAccessToken(ctx, c, claims))
+
+	var gotKey string
+	var gotTTL time.Duration
+	c.SetFn = func(_ context.Context, key string, _ any, ttl time.Duration) *redis.StatusCmd {
+		gotKey, gotTTL = key, ttl
+		return redis.NewStatusResult("OK", nil)
+	}
+	require.NoError(t, RevokeAccessToken(ctx, c, claims))
+	require.Equal(t, "revoked_access_token:j", gotKey)
+	require.Equal(t, time.Hour, gotTTL)
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	c := &cache.FakeCache{DelFn: func(context.Context, ...string) *redis.IntCmd {
+		return redis.NewIntResult(0, errors.New("del"))
+	}}
+	require.Error(t, RevokeRefreshToken(ctx, c, "tok"))
+
+	var gotKeys []string
+	c.DelFn = func(_ context.Context, keys ...string) *redis.IntCmd {
+		gotKeys = keys
+		return redis.NewIntResult(1, nil)
+	}
+	require.NoError(t, RevokeRefreshToken(ctx, c, "tok"))
+	require.Equal(t, []string{"refresh_token:tok"}, gotKeys)
+}
+
+func TestRevokeToken(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	os.Setenv("JWT_SECRET", "s")
+	refreshData, _ := json.Marshal(RefreshTokenData{UserID: 1, ClientID: "cid"})
+	access, err := IssueAccessToken(ctx, model.User{ID: 1}, "cid", "", nil, time.Hour, nil, Authentication{})
+	require.NoError(t, err)
+
+	newCache := func(getVal string, getErr error) (*cache.FakeCache, *[]string, *[]string) {
+		var deleted, set []string
+		return &cache.FakeCache{
+			GetFn: func(_ context.Context, key string) *redis.StringCmd {
+				if strings.HasPrefix(key, "revoked_grant:") || strings.HasPrefix(key, "revoked_user_sessions:") {
+					return redis.NewStringResult("", redis.Nil)
+				}
+				return redis.NewStringResult(getVal, getErr)
+			},
+			DelFn: func(_ context.Context, keys ...string) *redis.IntCmd {
+				deleted = append(deleted, keys...)
+				return redis.NewIntResult(1, nil)
+			},
+			SetFn: func(_ context.Context, key string, _ any, _ time.Duration) *redis.StatusCmd {
+				set = append(set, key)
+				return redis.NewStatusResult("OK", nil)
+			},
+		}, &deleted, &set
+	}
+
+	t.Run("refresh token owned", func(t *testing.T) {
+		c, deleted, _ := newCache(string(refreshData), nil)
+		require.NoError(t, RevokeToken(ctx, c, "cid", "rt", ""))
+		require.Equal(t, []string{"refresh_token:rt"}, *deleted)
+	})
+
+	t.Run("refresh token of other client", func(t *testing.T) {
+		c, deleted, _ := newCache(string(refreshData), nil)
+		require.NoError(t, RevokeToken(ctx, c, "other", "rt", "refresh_token"))
+		require.Empty(t, *deleted)
+	})
+
+	t.Run("refresh lookup error", func(t *testing.T) {
+		c, _, _ := newCache("", errors.New("get"))
+		require.Error(t, RevokeToken(ctx, c, "cid", "rt", ""))
+	})
+
+	t.Run("access token owned", func(t *testing.T) {
+		c, _, set := newCache("", redis.Nil)
+		require.NoError(t, RevokeToken(ctx, c, "cid", access, "access_token"))
+		require.Len(t, *set, 1)
+		require.Contains(t, (*set)[0], "revoked_access_token:")
+	})
+
+	t.Run("access token without hint", func(t *testing.T) {
+		c, _, set := newCache("", redis.Nil)
+		require.NoError(t, RevokeToken(ctx, c, "cid", access, ""))
+		require.Len(t, *set, 1)
+	})
+
+	t.Run("access token of other client", func(t *testing.T) {
+		c, _, set := newCache("", redis.Nil)
+		require.NoError(t, RevokeToken(ctx, c, "other", access, "access_token"))
+		require.Empty(t, *set)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		c, deleted, set := newCache("", redis.Nil)
+		require.NoError(t, RevokeToken(ctx, c, "cid", "garbage", "access_token"))
+		require.Empty(t, *deleted)
+		require.Empty(t, *set)
+	})
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	ScopeOpenID        = "openid"
+	ScopeProfile       = "profile"
+	ScopeEmail         = "email"
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+	ScopeClientsManage = "clients:manage"
+	ScopeKeysManage    = "keys:manage"
+)
+
+var ErrInvalidScope = errors.New("invalid scope")
+
+// scopeRegistry 為伺服器認得的所有 scope 與其說明；client 只能登記其中的 scope
+var scopeRegistry = map[string]string{
+	ScopeOpenID:        "OpenID Connect 登入，核發 id_token",
+	ScopeProfile:       "讀取使用者名稱",
+	ScopeEmail:         "讀取使用者 email",
+	ScopeUsersRead:     "讀取使用者資料",
+	ScopeUsersWrite:    "修改或刪除使用者資料",
+	ScopeClientsManage: "管理使用者的 OAuth client",
+	ScopeKeysManage:    "輪替 JWT 簽章金鑰（僅限管理員）",
+}
+
+// SupportedScopes 依字母順序回傳所有已登記的 scope
+func SupportedScopes() []string {
+	scopes := make([]string, 0, len(scopeRegistry))
+	for s := range scopeRegistry {
+		scopes = append(scopes, s)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// IsKnownScope 回傳 scope 是否在 registry 中
+func IsKnownScope(scope string) bool {
+	_, ok := scopeRegistry[scope]
+	return ok
+}
+
+// HasScope 檢查以空白分隔的 scope 字串是否包含指定 scope
+func HasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveScope 依 RFC 6749 §3.3 決定核發的 scope：未指定時給予 client 登記的全部 scope；
+// 指定時每個 scope 都必須已登記於 registry 且在 client 允許的範圍內
+func ResolveScope(requested string, allowed []string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return normalizeScope(allowed), nil
+	}
+	scopes := strings.Fields(requested)
+	for _, s := range scopes {
+		if !IsKnownScope(s) || !containsScope(allowed, s) {
+			return "", fmt.Errorf("%w: %s", ErrInvalidScope, s)
+		}
+	}
+	return normalizeScope(scopes), nil
+}
+
+// DownscopeScope 依 RFC 6749 §6 縮減 refresh 時的 scope：未指定時沿用原授權，
+// 指定時不得超出原本授權的範圍
+func DownscopeScope(requested, granted string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return granted, nil
+	}
+	allowed := strings.Fields(granted)
+	scopes := strings.Fields(requested)
+	for _, s := range scopes {
+		if !containsScope(allowed, s) {
+			return "", fmt.Errorf("%w: %s", ErrInvalidScope, s)
+		}
+	}
+	return normalizeScope(scopes), nil
+}
+
+// normalizeScope 去除重複並排序後以空白串接
+func normalizeScope(scopes []string) string {
+	seen := make(map[string]bool, len(scopes))
+	out := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return strings.Join(out, " ")
+}
+
+func containsScope(scopes []string, want string) bool {
+	for _, s := range scopes {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportedScopes(t *testing.T) {
+	require.Equal(t, []string{"clients:manage", "email", "keys:manage", "openid", "profile", "users:read", "users:write"}, SupportedScopes())
+	require.True(t, IsKnownScope(ScopeUsersRead))
+	require.False(t, IsKnownScope("users:admin"))
+}
+
+func TestHasScope(t *testing.T) {
+	require.True(t, HasScope("openid email", "openid"))
+	require.True(t, HasScope("  email   openid ", "openid"))
+	require.False(t, HasScope("openidx email", "openid"))
+	require.False(t, HasScope("", "openid"))
+}
+
+func TestResolveScope(t *testing.T) {
+	allowed := []string{ScopeUsersWrite, ScopeUsersRead, ScopeOpenID}
+
+	scope, err := ResolveScope("", allowed)
+	require.NoError(t, err)
+	require.Equal(t, "openid users:read users:write", scope)
+
+	scope, err = ResolveScope(" ", nil)
+	require.NoError(t, err)
+	require.Equal(t, "", scope)
+
+	scope, err = ResolveScope("users:read openid users:read", allowed)
+	require.NoError(t, err)
+	require.Equal(t, "openid users:read", scope)
+
+	_, err = ResolveScope("users:read clients:manage", allowed)
+	require.ErrorIs(t, err, ErrInvalidScope)
+	require.Contains(t, err.Error(), "clients:manage")
+
+	// 未登記於 registry 的 scope 即使 client 登記了也不核發
+	_, err = ResolveScope("legacy", []string{"legacy"})
+	require.ErrorIs(t, err, ErrInvalidScope)
+}
+
+func TestDownscopeScope(t *testing.T) {
+	scope, err := DownscopeScope("", "openid users:read")
+	require.NoError(t, err)
+	require.Equal(t, "openid users:read", scope)
+
+	scope, err = DownscopeScope("users:read", "openid users:read")
+	require.NoError(t, err)
+	require.Equal(t, "users:read", scope)
+
+	_, err = DownscopeScope("users:write", "openid users:read")
+	require.ErrorIs(t, err, ErrInvalidScope)
+}
@@ -0,0 +1,389 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/store"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	SigningAlgRS256 = "RS256"
+	SigningAlgES256 = "ES256"
+	SigningAlgEdDSA = "EdDSA"
+
+	// keyStoreRefreshInterval 為重新載入金鑰的週期，讓其他 process 的輪替能被同步
+	keyStoreRefreshInterval = time.Minute
+	// keyStoreMissReloadInterval 限制遇到未知 kid 時重新載入的頻率
+	keyStoreMissReloadInterval = 10 * time.Second
+)
+
+var (
+	ErrKeyStoreNotConfigured = errors.New("signing key store not configured")
+
+	signingMethods = map[string]jwt.SigningMethod{
+		SigningAlgRS256: jwt.SigningMethodRS256,
+		SigningAlgES256: jwt.SigningMethodES256,
+		SigningAlgEdDSA: jwt.SigningMethodEdDSA,
+	}
+
+	rsaGenerateKey        = rsa.GenerateKey
+	ecdsaGenerateKey      = ecdsa.GenerateKey
+	ed25519GenerateKey    = ed25519.GenerateKey
+	x509MarshalPKCS8      = x509.MarshalPKCS8PrivateKey
+	x509MarshalPKIXPublic = x509.MarshalPKIXPublicKey
+
+	// keyStore 為全域的簽章金鑰來源；未設定時退回以 JWT_SECRET 簽發 HS256
+	keyStore *KeyStore
+)
+
+// UseKeyStore 設定 access token 簽發與驗證所使用的金鑰庫，傳入 nil 則回到 HS256
+func UseKeyStore(ks *KeyStore) {
+	keyStore = ks
+}
+
+// PublicJWKS 回傳目前可驗證 token 的公開金鑰；使用 HS256 時沒有可公開的金鑰
+func PublicJWKS(ctx context.Context) ([]JSONWebKey, error) {
+	if keyStore == nil {
+		return []JSONWebKey{}, nil
+	}
+	return keyStore.JWKS(ctx)
+}
+
+// RotateSigningKeys 輪替全域金鑰庫的簽章金鑰
+func RotateSigningKeys(ctx context.Context, grace time.Duration) error {
+	if keyStore == nil {
+		return ErrKeyStoreNotConfigured
+	}
+	return keyStore.Rotate(ctx, grace)
+}
+
+// JSONWebKey 為 RFC 7517 公開金鑰表示
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type signingKey struct {
+	kid    string
+	status string
+	method jwt.SigningMethod
+	signer crypto.Signer
+	public crypto.PublicKey
+}
+
+// KeyStore 管理存放於資料庫的簽章金鑰：active 用於簽發，next 預先公開以便輪替，
+// retired 在到期前仍接受驗證
+type KeyStore struct {
+	db        database.DB
+	aead      cipher.AEAD
+	algorithm string
+
+	mu       sync.RWMutex
+	keys     []*signingKey
+	loadedAt time.Time
+}
+
+// NewKeyStore 建立金鑰庫；encryptionKey 為 32 bytes 的 AES-256 金鑰，用於加密私鑰
+func NewKeyStore(db database.DB, encryptionKey []byte, algorithm string) (*KeyStore, error) {
+	if len(encryptionKey) != 32 {
+		return nil, fmt.Errorf("signing key encryption key must be 32 bytes")
+	}
+	if _, ok := signingMethods[algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &KeyStore{db: db, aead: aead, algorithm: algorithm}, nil
+}
+
+// Load 從資料庫重新載入可用的金鑰
+func (ks *KeyStore) Load(ctx context.Context) error {
+	rows, err := store.ListSigningKeys(ctx, ks.db)
+	if err != nil {
+		return err
+	}
+	keys := make([]*signingKey, 0, len(rows))
+	for _, row := range rows {
+		key, err := ks.decodeKey(row)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.loadedAt = timeNow()
+	ks.mu.Unlock()
+	return nil
+}
+
+// Ensure 確保 active 與 next 金鑰存在，缺少時產生新金鑰
+func (ks *KeyStore) Ensure(ctx context.Context) error {
+	if err := ks.Load(ctx); err != nil {
+		return err
+	}
+	for _, status := range []string{model.SigningKeyStatusActive, model.SigningKeyStatusNext} {
+		hasStatus := func(k *signingKey) bool { return k.status == status }
+		if ks.find(hasStatus) != nil {
+			continue
+		}
+		if err := ks.createKey(ctx, status); err != nil {
+			// 多個 process 同時啟動時，其他 process 可能已建立同狀態的金鑰
+			if loadErr := ks.Load(ctx); loadErr != nil || ks.find(hasStatus) == nil {
+				return err
+			}
+		}
+	}
+	return ks.Load(ctx)
+}
+
+// Rotate 將 next 升為 active 並產生新的 next；原 active 在 grace 期間內仍接受驗證，
+// grace 應涵蓋 access token 的最長效期
+func (ks *KeyStore) Rotate(ctx context.Context, grace time.Duration) error {
+	if err := ks.Ensure(ctx); err != nil {
+		return err
+	}
+	rotated, err := store.RotateSigningKeys(ctx, ks.db, timeNow().Add(grace))
+	if err != nil {
+		return err
+	}
+	if !rotated {
+		return fmt.Errorf("no next signing key to rotate")
+	}
+	if err := ks.createKey(ctx, model.SigningKeyStatusNext); err != nil {
+		return err
+	}
+	return ks.Load(ctx)
+}
+
+// JWKS 回傳所有可驗證金鑰的公開部分
+func (ks *KeyStore) JWKS(ctx context.Context) ([]JSONWebKey, error) {
+	keys, err := ks.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jwks := make([]JSONWebKey, 0, len(keys))
+	for _, k := range keys {
+		jwks = append(jwks, newJSONWebKey(k))
+	}
+	return jwks, nil
+}
+
+// signingKey 回傳目前用於簽發的 active 金鑰
+func (ks *KeyStore) signingKey(ctx context.Context) (*signingKey, error) {
+	if _, err := ks.current(ctx); err != nil {
+		return nil, err
+	}
+	key := ks.find(func(k *signingKey) bool { return k.status == model.SigningKeyStatusActive })
+	if key == nil {
+		return nil, fmt.Errorf("no active signing key")
+	}
+	return key, nil
+}
+
+// verificationKey 依 kid 取得驗證用金鑰；找不到時重新載入一次以取得其他 process 剛輪替的金鑰
+func (ks *KeyStore) verificationKey(ctx context.Context, kid string) (*signingKey, error) {
+	if _, err := ks.current(ctx); err != nil {
+		return nil, err
+	}
+	match := func(k *signingKey) bool { return k.kid == kid }
+	if key := ks.find(match); key != nil {
+		return key, nil
+	}
+	ks.mu.RLock()
+	stale := timeNow().Sub(ks.loadedAt) >= keyStoreMissReloadInterval
+	ks.mu.RUnlock()
+	if stale {
+		if err := ks.Load(ctx); err != nil {
+			return nil, err
+		}
+		if key := ks.find(match); key != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key: %q", kid)
+}
+
+// current 回傳已載入的金鑰，超過 keyStoreRefreshInterval 時重新載入；
+// 重新載入失敗但仍有舊資料時沿用舊資料，避免資料庫短暫故障影響驗證
+func (ks *KeyStore) current(ctx context.Context) ([]*signingKey, error) {
+	ks.mu.RLock()
+	keys, loadedAt := ks.keys, ks.loadedAt
+	ks.mu.RUnlock()
+	if timeNow().Sub(loadedAt) < keyStoreRefreshInterval {
+		return keys, nil
+	}
+	if err := ks.Load(ctx); err != nil {
+		if len(keys) > 0 {
+			return keys, nil
+		}
+		return nil, err
+	}
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys, nil
+}
+
+func (ks *KeyStore) find(match func(*signingKey) bool) *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, k := range ks.keys {
+		if match(k) {
+			return k
+		}
+	}
+	return nil
+}
+
+func (ks *KeyStore) createKey(ctx context.Context, status string) error {
+	kid, err := newTokenID()
+	if err != nil {
+		return err
+	}
+	signer, err := generateSigningKey(ks.algorithm)
+	if err != nil {
+		return err
+	}
+	privateDER, err := x509MarshalPKCS8(signer)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	publicDER, err := x509MarshalPKIXPublic(signer.Public())
+	if err != nil {
+		return fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	sealed, err := ks.seal(kid, privateDER)
+	if err != nil {
+		return err
+	}
+	return store.CreateSigningKey(ctx, ks.db, &model.SigningKey{
+		KID:        kid,
+		Algorithm:  ks.algorithm,
+		Status:     status,
+		PrivateKey: sealed,
+		PublicKey:  publicDER,
+	})
+}
+
+// decodeKey 還原金鑰；僅 active 金鑰需要解密私鑰
+func (ks *KeyStore) decodeKey(row model.SigningKey) (*signingKey, error) {
+	method, ok := signingMethods[row.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("signing key %s: unsupported algorithm %s", row.KID, row.Algorithm)
+	}
+	public, err := x509.ParsePKIXPublicKey(row.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: invalid public key: %w", row.KID, err)
+	}
+	key := &signingKey{kid: row.KID, status: row.Status, method: method, public: public}
+	if row.Status != model.SigningKeyStatusActive {
+		return key, nil
+	}
+	der, err := ks.open(row.KID, row.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	private, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: invalid private key: %w", row.KID, err)
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s: private key cannot sign", row.KID)
+	}
+	key.signer = signer
+	return key, nil
+}
+
+// seal 以 AES-GCM 加密私鑰，kid 作為 additional data 綁定金鑰列
+func (ks *KeyStore) seal(kid string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, ks.aead.NonceSize())
+	if _, err := randRead(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return ks.aead.Seal(nonce, nonce, plaintext, []byte(kid)), nil
+}
+
+func (ks *KeyStore) open(kid string, sealed []byte) ([]byte, error) {
+	size := ks.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, fmt.Errorf("signing key %s: ciphertext too short", kid)
+	}
+	plaintext, err := ks.aead.Open(nil, sealed[:size], sealed[size:], []byte(kid))
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: failed to decrypt private key: %w", kid, err)
+	}
+	return plaintext, nil
+}
+
+func generateSigningKey(algorithm string) (crypto.Signer, error) {
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch algorithm {
+	case SigningAlgRS256:
+		signer, err = rsaGenerateKey(rand.Reader, 2048)
+	case SigningAlgES256:
+		signer, err = ecdsaGenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		_, signer, err = ed25519GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key: %w", algorithm, err)
+	}
+	return signer, nil
+}
+
+func newJSONWebKey(k *signingKey) JSONWebKey {
+	jwk := JSONWebKey{KeyID: k.kid, Use: "sig", Algorithm: k.method.Alg()}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
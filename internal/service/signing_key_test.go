@@ -0,0 +1,481 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+/* ---------- 假實作 ---------- */
+
+// fakeKeyTable 以記憶體模擬 signing_keys 資料表
+type fakeKeyTable struct {
+	keys     []model.SigningKey
+	listErr  error
+	createEr error
+	execErr  error
+}
+
+func (f *fakeKeyTable) db() *database.FakeDB {
+	return &database.FakeDB{
+		QueryFn: func(context.Context, string, ...any) (pgx.Rows, error) {
+			if f.listErr != nil {
+				return nil, f.listErr
+			}
+			var usable []model.SigningKey
+			for _, k := range f.keys {
+				if k.Status != model.SigningKeyStatusRetired || k.ExpiresAt.After(timeNow()) {
+					usable = append(usable, k)
+				}
+			}
+			return &fakeKeyRows{keys: usable}, nil
+		},
+		QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+			if f.createEr != nil {
+				return &fakeKeyRow{err: f.createEr}
+			}
+			f.keys = append(f.keys, model.SigningKey{
+				KID:        args[0].(string),
+				Algorithm:  args[1].(string),
+				Status:     args[2].(string),
+				PrivateKey: args[3].([]byte),
+				PublicKey:  args[4].([]byte),
+			})
+			return &fakeKeyRow{}
+		},
+		ExecFn: func(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+			if f.execErr != nil {
+				return pgconn.CommandTag{}, f.execErr
+			}
+			if f.find(model.SigningKeyStatusNext) == nil {
+				return pgconn.NewCommandTag("UPDATE 0"), nil
+			}
+			expiresAt := args[0].(time.Time)
+			for i := range f.keys {
+				switch f.keys[i].Status {
+				case model.SigningKeyStatusActive:
+					f.keys[i].Status = model.SigningKeyStatusRetired
+					f.keys[i].ExpiresAt = &expiresAt
+				case model.SigningKeyStatusNext:
+					f.keys[i].Status = model.SigningKeyStatusActive
+				}
+			}
+			return pgconn.NewCommandTag("UPDATE 2"), nil
+		},
+	}
+}
+
+func (f *fakeKeyTable) find(status string) *model.SigningKey {
+	for i := range f.keys {
+		if f.keys[i].Status == status {
+			return &f.keys[i]
+		}
+	}
+	return nil
+}
+
+type fakeKeyRow struct{ err error }
+
+func (r *fakeKeyRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*time.Time) = timeNow()
+	*dest[1].(*time.Time) = timeNow()
+	return nil
+}
+
+type fakeKeyRows struct {
+	keys []model.SigningKey
+	idx  int
+}
+
+func (r *fakeKeyRows) Close()                                       {}
+func (r *fakeKeyRows) Err() error                                   { return nil }
+func (r *fakeKeyRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeKeyRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeKeyRows) Next() bool                                   { return r.idx < len(r.keys) }
+func (r *fakeKeyRows) Values() ([]any, error)                       { return nil, nil }
+func (r *fakeKeyRows) RawValues() [][]byte                          { return nil }
+func (r *fakeKeyRows) Conn() *pgx.Conn                              { return nil }
+func (r *fakeKeyRows) Scan(dest ...any) error {
+	k := r.keys[r.idx]
+	r.idx++
+	*dest[0].(*string) = k.KID
+	*dest[1].(*string) = k.Algorithm
+	*dest[2].(*string) = k.Status
+	*dest[3].(*[]byte) = k.PrivateKey
+	*dest[4].(*[]byte) = k.PublicKey
+	*dest[5].(**time.Time) = k.ExpiresAt
+	*dest[6].(*time.Time) = k.CreatedAt
+	*dest[7].(*time.Time) = k.UpdatedAt
+	return nil
+}
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+// newTestKeyStore 建立已產生 active/next 金鑰的金鑰庫，並以固定時間控制重新載入
+func newTestKeyStore(t *testing.T, alg string) (*KeyStore, *fakeKeyTable, *time.Time) {
+	t.Helper()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	table := &fakeKeyTable{}
+	ks, err := NewKeyStore(table.db(), testEncryptionKey, alg)
+	require.NoError(t, err)
+	require.NoError(t, ks.Ensure(context.Background()))
+	return ks, table, &now
+}
+
+func notRevokedCache() cache.Cache {
+	return &cache.FakeCache{GetFn: func(context.Context, string) *redis.StringCmd {
+		return redis.NewStringResult("", redis.Nil)
+	}}
+}
+
+/* ---------- 測試 ---------- */
+
+func TestNewKeyStore(t *testing.T) {
+	_, err := NewKeyStore(nil, []byte("short"), SigningAlgES256)
+	require.Error(t, err)
+
+	_, err = NewKeyStore(nil, testEncryptionKey, "HS256")
+	require.Error(t, err)
+
+	ks, err := NewKeyStore(nil, testEncryptionKey, SigningAlgES256)
+	require.NoError(t, err)
+	require.Equal(t, SigningAlgES256, ks.algorithm)
+}
+
+func TestKeyStoreSignAndVerify(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		alg string
+		kty string
+	}{
+		{SigningAlgRS256, "RSA"},
+		{SigningAlgES256, "EC"},
+		{SigningAlgEdDSA, "OKP"},
+	} {
+		t.Run(tc.alg, func(t *testing.T) {
+			t.Cleanup(restoreGlobals)
+			ks, table, _ := newTestKeyStore(t, tc.alg)
+			UseKeyStore(ks)
+			require.Len(t, table.keys, 2)
+
+			tok, err := IssueAccessToken(ctx, model.User{ID: 9}, "cid", "", nil, time.Hour, nil, Authentication{})
+			require.NoError(t, err)
+			parsed, _, err := jwt.NewParser().ParseUnverified(tok, &CustomClaims{})
+			require.NoError(t, err)
+			require.Equal(t, tc.alg, parsed.Method.Alg())
+			require.Equal(t, table.find(model.SigningKeyStatusActive).KID, parsed.Header["kid"])
+
+			claims, err := VerifyAccessToken(ctx, notRevokedCache(), tok)
+			require.NoError(t, err)
+			require.Equal(t, 9, claims.UserID)
+
+			jwks, err := PublicJWKS(ctx)
+			require.NoError(t, err)
+			require.Len(t, jwks, 2)
+			for _, jwk := range jwks {
+				require.Equal(t, tc.kty, jwk.KeyType)
+				require.Equal(t, tc.alg, jwk.Algorithm)
+				require.Equal(t, "sig", jwk.Use)
+			}
+		})
+	}
+}
+
+func TestKeyStoreRejectsForeignTokens(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	ks, table, _ := newTestKeyStore(t, SigningAlgES256)
+	UseKeyStore(ks)
+	kid := table.find(model.SigningKeyStatusActive).KID
+
+	// 以 HS256 偽造並帶上合法 kid，不可被接受
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, CustomClaims{UserID: 1})
+	forged.Header["kid"] = kid
+	tok, _ := forged.SignedString([]byte("s"))
+	_, err := VerifyAccessToken(ctx, notRevokedCache(), tok)
+	require.ErrorContains(t, err, "unexpected signing method")
+
+	// 未知 kid
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	unknown := jwt.NewWithClaims(jwt.SigningMethodES256, CustomClaims{UserID: 1})
+	unknown.Header["kid"] = "missing"
+	tok, _ = unknown.SignedString(other)
+	_, err = VerifyAccessToken(ctx, notRevokedCache(), tok)
+	require.ErrorContains(t, err, "unknown signing key")
+}
+
+func TestKeyStoreRotate(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	ks, table, now := newTestKeyStore(t, SigningAlgEdDSA)
+	UseKeyStore(ks)
+	oldKID := table.find(model.SigningKeyStatusActive).KID
+	nextKID := table.find(model.SigningKeyStatusNext).KID
+
+	before, err := IssueAccessToken(ctx, model.User{ID: 1}, "", "", nil, 2*time.Hour, nil, Authentication{})
+	require.NoError(t, err)
+
+	require.NoError(t, RotateSigningKeys(ctx, 2*time.Hour))
+	require.Len(t, table.keys, 3)
+	require.Equal(t, nextKID, table.find(model.SigningKeyStatusActive).KID)
+	require.Equal(t, model.SigningKeyStatusRetired, table.keys[0].Status)
+	require.Equal(t, now.Add(2*time.Hour), *table.keys[0].ExpiresAt)
+
+	after, err := IssueAccessToken(ctx, model.User{ID: 1}, "", "", nil, time.Hour, nil, Authentication{})
+	require.NoError(t, err)
+	parsed, _, _ := jwt.NewParser().ParseUnverified(after, &CustomClaims{})
+	require.Equal(t, nextKID, parsed.Header["kid"])
+
+	// 輪替前簽發的 token 在 grace 期間內仍有效
+	_, err = VerifyAccessToken(ctx, notRevokedCache(), before)
+	require.NoError(t, err)
+	jwks, _ := PublicJWKS(ctx)
+	require.Len(t, jwks, 3)
+
+	// grace 結束後 retired 金鑰不再載入
+	*now = now.Add(3 * time.Hour)
+	jwks, _ = PublicJWKS(ctx)
+	require.Len(t, jwks, 2)
+	for _, jwk := range jwks {
+		require.NotEqual(t, oldKID, jwk.KeyID)
+	}
+}
+
+func TestKeyStoreRotateErrors(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+
+	UseKeyStore(nil)
+	require.ErrorIs(t, RotateSigningKeys(ctx, time.Hour), ErrKeyStoreNotConfigured)
+
+	ks, table, _ := newTestKeyStore(t, SigningAlgES256)
+	table.listErr = errors.New("list")
+	require.Error(t, ks.Rotate(ctx, time.Hour))
+
+	table.listErr = nil
+	table.execErr = errors.New("exec")
+	require.Error(t, ks.Rotate(ctx, time.Hour))
+
+	// 另一個 process 在 Ensure 之後搶先輪替，導致沒有 next 金鑰
+	table.execErr = nil
+	db := table.db()
+	exec := db.ExecFn
+	db.ExecFn = func(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+		table.find(model.SigningKeyStatusNext).Status = model.SigningKeyStatusRetired
+		return exec(ctx, sql, args...)
+	}
+	ks.db = db
+	table.keys[1].ExpiresAt = &time.Time{}
+	require.ErrorContains(t, ks.Rotate(ctx, time.Hour), "no next signing key")
+
+	ks, table, _ = newTestKeyStore(t, SigningAlgES256)
+	db = table.db()
+	exec = db.ExecFn
+	db.ExecFn = func(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+		table.createEr = errors.New("create")
+		return exec(ctx, sql, args...)
+	}
+	ks.db = db
+	require.Error(t, ks.Rotate(ctx, time.Hour))
+}
+
+func TestKeyStoreEnsureErrors(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+
+	table := &fakeKeyTable{listErr: errors.New("list")}
+	ks, _ := NewKeyStore(table.db(), testEncryptionKey, SigningAlgES256)
+	require.Error(t, ks.Ensure(ctx))
+
+	table = &fakeKeyTable{createEr: errors.New("create")}
+	ks, _ = NewKeyStore(table.db(), testEncryptionKey, SigningAlgES256)
+	require.Error(t, ks.Ensure(ctx))
+
+	// 其他 process 搶先建立 active 與 next，建立失敗後重新載入即可
+	winner, table, _ := newTestKeyStore(t, SigningAlgES256)
+	loser, _ := NewKeyStore(table.db(), testEncryptionKey, SigningAlgES256)
+	loser.db = &database.FakeDB{
+		QueryFn: func(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+			if len(table.keys) == 0 || loser.loadedAt.IsZero() {
+				return &fakeKeyRows{}, nil
+			}
+			return winner.db.Query(ctx, sql, args...)
+		},
+		QueryRowFn: func(context.Context, string, ...any) pgx.Row {
+			return &fakeKeyRow{err: errors.New("conflicting key")}
+		},
+	}
+	require.NoError(t, loser.Ensure(ctx))
+	_, err := loser.signingKey(ctx)
+	require.NoError(t, err)
+}
+
+func TestKeyStoreCreateKeyErrors(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	fail := errors.New("fail")
+	newKS := func(alg string) *KeyStore {
+		ks, _ := NewKeyStore((&fakeKeyTable{}).db(), testEncryptionKey, alg)
+		return ks
+	}
+
+	randRead = func([]byte) (int, error) { return 0, fail }
+	require.Error(t, newKS(SigningAlgES256).createKey(ctx, model.SigningKeyStatusActive))
+
+	// kid 產生成功、nonce 產生失敗
+	calls := 0
+	randRead = func(b []byte) (int, error) {
+		calls++
+		if calls > 1 {
+			return 0, fail
+		}
+		return rand.Read(b)
+	}
+	require.Error(t, newKS(SigningAlgES256).createKey(ctx, model.SigningKeyStatusActive))
+	randRead = rand.Read
+
+	rsaGenerateKey = func(io.Reader, int) (*rsa.PrivateKey, error) { return nil, fail }
+	require.Error(t, newKS(SigningAlgRS256).createKey(ctx, model.SigningKeyStatusActive))
+	ecdsaGenerateKey = func(elliptic.Curve, io.Reader) (*ecdsa.PrivateKey, error) { return nil, fail }
+	require.Error(t, newKS(SigningAlgES256).createKey(ctx, model.SigningKeyStatusActive))
+	ed25519GenerateKey = func(io.Reader) (ed25519.PublicKey, ed25519.PrivateKey, error) { return nil, nil, fail }
+	require.Error(t, newKS(SigningAlgEdDSA).createKey(ctx, model.SigningKeyStatusActive))
+	ed25519GenerateKey = ed25519.GenerateKey
+
+	x509MarshalPKCS8 = func(any) ([]byte, error) { return nil, fail }
+	require.Error(t, newKS(SigningAlgEdDSA).createKey(ctx, model.SigningKeyStatusActive))
+	x509MarshalPKCS8 = x509.MarshalPKCS8PrivateKey
+
+	x509MarshalPKIXPublic = func(any) ([]byte, error) { return nil, fail }
+	require.Error(t, newKS(SigningAlgEdDSA).createKey(ctx, model.SigningKeyStatusActive))
+}
+
+func TestKeyStoreDecodeErrors(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ks, table, _ := newTestKeyStore(t, SigningAlgEdDSA)
+	active := *table.find(model.SigningKeyStatusActive)
+	next := *table.find(model.SigningKeyStatusNext)
+
+	sealPKCS8 := func(kid string, key any) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		sealed, err := ks.seal(kid, der)
+		require.NoError(t, err)
+		return sealed
+	}
+	x25519, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	for name, mutate := range map[string]func(k *model.SigningKey){
+		"unsupported algorithm": func(k *model.SigningKey) { k.Algorithm = "HS256" },
+		"invalid public key":    func(k *model.SigningKey) { k.PublicKey = []byte("bad") },
+		"short ciphertext":      func(k *model.SigningKey) { k.PrivateKey = []byte("x") },
+		"wrong kid binding":     func(k *model.SigningKey) { k.PrivateKey = next.PrivateKey },
+		"invalid private key":   func(k *model.SigningKey) { k.PrivateKey, _ = ks.seal(k.KID, []byte("bad")) },
+		"non signing key":       func(k *model.SigningKey) { k.PrivateKey = sealPKCS8(k.KID, x25519) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			k := active
+			mutate(&k)
+			_, err := ks.decodeKey(k)
+			require.Error(t, err)
+		})
+	}
+
+	// 非 active 金鑰不需解密私鑰
+	next.PrivateKey = nil
+	key, err := ks.decodeKey(next)
+	require.NoError(t, err)
+	require.Nil(t, key.signer)
+	var _ crypto.PublicKey = key.public
+
+	table.keys[0].PublicKey = []byte("bad")
+	require.Error(t, ks.Load(context.Background()))
+}
+
+func TestKeyStoreReload(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	ks, table, now := newTestKeyStore(t, SigningAlgES256)
+	UseKeyStore(ks)
+
+	// 另一個 process 輪替後，未知 kid 觸發重新載入
+	other, _ := NewKeyStore(table.db(), testEncryptionKey, SigningAlgES256)
+	require.NoError(t, other.Rotate(ctx, time.Hour))
+	require.NoError(t, other.Rotate(ctx, time.Hour))
+	UseKeyStore(other)
+	tok, err := IssueAccessToken(ctx, model.User{ID: 1}, "", "", nil, time.Hour, nil, Authentication{})
+	require.NoError(t, err)
+	UseKeyStore(ks)
+
+	// 剛載入過，不會立即重新載入
+	_, err = VerifyAccessToken(ctx, notRevokedCache(), tok)
+	require.ErrorContains(t, err, "unknown signing key")
+
+	*now = now.Add(keyStoreMissReloadInterval)
+	_, err = VerifyAccessToken(ctx, notRevokedCache(), tok)
+	require.NoError(t, err)
+
+	// 重新載入失敗
+	*now = now.Add(keyStoreMissReloadInterval)
+	table.listErr = errors.New("list")
+	_, err = ks.verificationKey(ctx, "missing")
+	require.Error(t, err)
+
+	// 超過更新週期且資料庫故障時沿用已載入的金鑰
+	*now = now.Add(keyStoreRefreshInterval)
+	_, err = VerifyAccessToken(ctx, notRevokedCache(), tok)
+	require.NoError(t, err)
+
+	// 從未載入成功時回傳錯誤
+	empty, _ := NewKeyStore(table.db(), testEncryptionKey, SigningAlgES256)
+	_, err = empty.signingKey(ctx)
+	require.Error(t, err)
+	_, err = empty.verificationKey(ctx, "kid")
+	require.Error(t, err)
+	_, err = empty.JWKS(ctx)
+	require.Error(t, err)
+	UseKeyStore(empty)
+	_, err = IssueAccessToken(ctx, model.User{ID: 1}, "", "", nil, time.Hour, nil, Authentication{})
+	require.Error(t, err)
+	_, err = VerifyAccessToken(ctx, notRevokedCache(), tok)
+	require.Error(t, err)
+
+	// 載入成功但沒有 active 金鑰
+	table.listErr = nil
+	table.keys = nil
+	*now = now.Add(keyStoreRefreshInterval)
+	_, err = empty.signingKey(ctx)
+	require.ErrorContains(t, err, "no active signing key")
+}
+
+func TestPublicJWKSWithoutKeyStore(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	UseKeyStore(nil)
+	jwks, err := PublicJWKS(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, jwks)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"life-is-hard/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// TokenTypeAccessToken 為 RFC 8693 §3 的 token type，目前唯一支援交換與核發的類型
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// ErrInvalidTarget 表示 client 不允許換取所要求的 audience（RFC 8693 §2.2.2 invalid_target）
+var ErrInvalidTarget = errors.New("audience not allowed for this client")
+
+// Actor 為 RFC 8693 §4.1 的 act claim，記錄代替 subject 行事的一方；巢狀的 Actor 為更早的代理者
+type Actor struct {
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id,omitempty"`
+	Actor    *Actor `json:"act,omitempty"`
+}
+
+// ResolveTokenExchangeAudience 確認每個要求的 audience 皆在 client 允許的範圍內，回傳去重後的 audience
+func ResolveTokenExchangeAudience(requested, allowed []string) ([]string, error) {
+	out := make([]string, 0, len(requested))
+	for _, aud := range requested {
+		if !slices.Contains(allowed, aud) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTarget, aud)
+		}
+		if !slices.Contains(out, aud) {
+			out = append(out, aud)
+		}
+	}
+	return out, nil
+}
+
+// ResolveTokenExchangeScope 決定交換後的 scope：不得超出 client 登記的 scope，
+// subject token 經由 OAuth client 取得時亦不得超出其原本的 scope
+func ResolveTokenExchangeScope(requested string, subject *CustomClaims, clientScopes []string) (string, error) {
+	allowed := clientScopes
+	if subject.ClientID != "" {
+		granted := strings.Fields(subject.Scope)
+		allowed = make([]string, 0, len(clientScopes))
+		for _, s := range clientScopes {
+			if containsScope(granted, s) {
+				allowed = append(allowed, s)
+			}
+		}
+	}
+	return ResolveScope(requested, allowed)
+}
+
+// VerifyTokenExchangeBinding 確認交換的 token 若綁定 DPoP 金鑰或 client 憑證（cnf），此次請求以同一把金鑰
+// 出示 DPoP proof（jkt）並出示同一張憑證，避免他人以竊得的 sender-constrained token 換出不受綁定的 token
+func VerifyTokenExchangeBinding(claims *CustomClaims, jkt string, chain []*x509.Certificate) error {
+	if claims.Confirmation == nil {
+		return nil
+	}
+	if want := claims.Confirmation.JKT; want != "" && subtle.ConstantTimeCompare([]byte(jkt), []byte(want)) != 1 {
+		return errors.New("DPoP proof does not match token binding")
+	}
+	return VerifyCertificateBinding(claims, chain)
+}
+
+// IssueExchangedAccessToken 依 RFC 8693 以 subject token 為 client 發行限定 audience 的 access token。
+// act 為 actor token 的持有者，未提供 actor token 時為 client 自身；subject token 既有的 act 保留為巢狀的前一代理者。
+// token 效期不超過 subject token，回傳實際的效期；交換後的 token 不帶管理者權限
+func IssueExchangedAccessToken(ctx context.Context, client model.OAuthClient, subject, actor *CustomClaims, audience []string, scope string, ttl time.Duration, cnf *Confirmation) (string, time.Duration, error) {
+	act := &Actor{Subject: client.ClientID, ClientID: client.ClientID}
+	if actor != nil {
+		act = &Actor{Subject: actor.Subject, ClientID: actor.ClientID}
+	}
+	act.Actor = subject.Actor
+
+	now := timeNow()
+	if subject.ExpiresAt != nil {
+		if remaining := subject.ExpiresAt.Sub(now); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	if ttl <= 0 {
+		return "", 0, errors.New("subject token has expired")
+	}
+	jti, err := newTokenID()
+	if err != nil {
+		return "", 0, err
+	}
+	claims := CustomClaims{
+		UserID:       subject.UserID,
+		ClientID:     client.ClientID,
+		Scope:        scope,
+		Actor:        act,
+		Confirmation: cnf,
+		// 交換得到的 token 仍代表使用者當初登入的驗證方式
+		Authentication: subject.Authentication,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   subject.Subject,
+			Audience:  audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	token, err := signClaims(ctx, claims)
+	if err != nil {
+		return "", 0, err
+	}
+	return token, ttl, nil
+}
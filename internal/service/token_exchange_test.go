@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveTokenExchangeAudience(t *testing.T) {
+	allowed := []string{"billing", "reports"}
+	got, err := ResolveTokenExchangeAudience([]string{"reports", "billing", "reports"}, allowed)
+	require.NoError(t, err)
+	require.Equal(t, []string{"reports", "billing"}, got)
+
+	_, err = ResolveTokenExchangeAudience([]string{"billing", "admin"}, allowed)
+	require.ErrorIs(t, err, ErrInvalidTarget)
+	require.ErrorContains(t, err, "admin")
+
+	_, err = ResolveTokenExchangeAudience([]string{"billing"}, nil)
+	require.ErrorIs(t, err, ErrInvalidTarget)
+}
+
+func TestResolveTokenExchangeScope(t *testing.T) {
+	clientScopes := []string{"openid", "users:read", "users:write"}
+
+	// 第一方 token 不受 scope 限制，以 client 登記的 scope 為上限
+	scope, err := ResolveTokenExchangeScope("", &CustomClaims{UserID: 1}, clientScopes)
+	require.NoError(t, err)
+	require.Equal(t, "openid users:read users:write", scope)
+
+	subject := &CustomClaims{UserID: 1, ClientID: "web", Scope: "users:read profile"}
+	scope, err = ResolveTokenExchangeScope("", subject, clientScopes)
+	require.NoError(t, err)
+	require.Equal(t, "users:read", scope)
+
+	_, err = ResolveTokenExchangeScope("users:write", subject, clientScopes)
+	require.ErrorIs(t, err, ErrInvalidScope)
+}
+
+func TestVerifyTokenExchangeBinding(t *testing.T) {
+	cert, _ := newTestCertificate(t, "svc", nil, nil, false)
+	chain := []*x509.Certificate{cert}
+
+	require.NoError(t, VerifyTokenExchangeBinding(&CustomClaims{}, "", nil))
+
+	dpop := &CustomClaims{Confirmation: &Confirmation{JKT: "jkt"}}
+	require.NoError(t, VerifyTokenExchangeBinding(dpop, "jkt", nil))
+	require.Error(t, VerifyTokenExchangeBinding(dpop, "", nil))
+	require.Error(t, VerifyTokenExchangeBinding(dpop, "other", chain))
+
+	mtls := &CustomClaims{Confirmation: CertificateConfirmation(chain)}
+	require.NoError(t, VerifyTokenExchangeBinding(mtls, "", chain))
+	require.Error(t, VerifyTokenExchangeBinding(mtls, "jkt", nil))
+
+	both := &CustomClaims{Confirmation: &Confirmation{JKT: "jkt", X5tS256: mtls.Confirmation.X5tS256}}
+	require.NoError(t, VerifyTokenExchangeBinding(both, "jkt", chain))
+	require.Error(t, VerifyTokenExchangeBinding(both, "jkt", nil))
+}
+
+func TestIssueExchangedAccessToken(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	os.Setenv("JWT_SECRET", "s")
+	client := model.OAuthClient{ClientID: "orders"}
+	subject := &CustomClaims{
+		UserID:  7,
+		IsAdmin: true,
+		Actor:   &Actor{Subject: "gateway", ClientID: "gateway"},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "7",
+			ExpiresAt: jwt.NewNumericDate(now.Add(10 * time.Minute)),
+		},
+	}
+	parse := func(t *testing.T, tok string) *CustomClaims {
+		t.Helper()
+		c := &CustomClaims{}
+		_, err := jwt.ParseWithClaims(tok, c, func(*jwt.Token) (any, error) { return []byte("s"), nil },
+			jwt.WithTimeFunc(func() time.Time { return now }))
+		require.NoError(t, err)
+		return c
+	}
+
+	t.Run("client acts for subject", func(t *testing.T) {
+		tok, ttl, err := IssueExchangedAccessToken(ctx, client, subject, nil, []string{"billing"}, "users:read", time.Hour, nil)
+		require.NoError(t, err)
+		// 效期不超過 subject token
+		require.Equal(t, 10*time.Minute, ttl)
+
+		c := parse(t, tok)
+		require.Equal(t, 7, c.UserID)
+		require.Equal(t, "7", c.Subject)
+		require.Equal(t, "orders", c.ClientID)
+		require.Equal(t, "users:read", c.Scope)
+		require.Equal(t, jwt.ClaimStrings{"billing"}, c.Audience)
+		require.False(t, c.IsAdmin)
+		require.Equal(t, &Actor{Subject: "orders", ClientID: "orders", Actor: &Actor{Subject: "gateway", ClientID: "gateway"}}, c.Actor)
+	})
+
+	t.Run("actor token", func(t *testing.T) {
+		actor := &CustomClaims{ClientID: "batch", RegisteredClaims: jwt.RegisteredClaims{Subject: "batch"}}
+		tok, ttl, err := IssueExchangedAccessToken(ctx, client, &CustomClaims{UserID: 7}, actor, nil, "", time.Minute, &Confirmation{JKT: "k"})
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, ttl)
+		c := parse(t, tok)
+		require.Equal(t, &Actor{Subject: "batch", ClientID: "batch"}, c.Actor)
+		require.Equal(t, "k", c.Confirmation.JKT)
+		require.Empty(t, c.Audience)
+	})
+
+	t.Run("expired subject", func(t *testing.T) {
+		expired := &CustomClaims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now)}}
+		_, _, err := IssueExchangedAccessToken(ctx, client, expired, nil, nil, "", time.Hour, nil)
+		require.ErrorContains(t, err, "subject token has expired")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+		_, _, err := IssueExchangedAccessToken(ctx, client, subject, nil, nil, "", time.Hour, nil)
+		require.Error(t, err)
+		randRead = rand.Read
+
+		os.Unsetenv("JWT_SECRET")
+		_, _, err = IssueExchangedAccessToken(ctx, client, subject, nil, nil, "", time.Hour, nil)
+		require.Error(t, err)
+	})
+}
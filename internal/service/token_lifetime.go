@@ -0,0 +1,167 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"life-is-hard/internal/model"
+)
+
+// TokenLifetimes 為 token 的效期與 access token 的 aud
+type TokenLifetimes struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// RefreshTokenAbsoluteTTL 為 refresh token family 自首次發行起的最長效期，0 表示不限
+	RefreshTokenAbsoluteTTL time.Duration
+	// RefreshTokenIdleTimeout 為 refresh token 未使用即失效的時間，0 表示不限
+	RefreshTokenIdleTimeout time.Duration
+	IDTokenTTL              time.Duration
+	// Audience 為 access token 的 aud；空白表示不限定
+	Audience []string
+}
+
+// DefaultTokenLifetimes 為未設定時的伺服器預設效期
+var DefaultTokenLifetimes = TokenLifetimes{
+	AccessTokenTTL:  24 * time.Hour,
+	RefreshTokenTTL: 30 * 24 * time.Hour,
+	IDTokenTTL:      time.Hour,
+}
+
+// token 效期的上限；簽章金鑰輪替後的寬限期須涵蓋 access token 與 ID token 的最長效期
+const (
+	MaxAccessTokenTTL  = 24 * time.Hour
+	MaxIDTokenTTL      = 24 * time.Hour
+	MaxRefreshTokenTTL = 365 * 24 * time.Hour
+)
+
+var serverTokenLifetimes = DefaultTokenLifetimes
+
+// UseTokenLifetimes 設定伺服器預設的 token 效期與 aud；效期為 0 的欄位沿用 DefaultTokenLifetimes
+func UseTokenLifetimes(l TokenLifetimes) {
+	serverTokenLifetimes = l.withDefaults(DefaultTokenLifetimes)
+}
+
+// ServerTokenLifetimes 回傳伺服器預設的 token 效期，供非經由 OAuth client 發行的 token 使用
+func ServerTokenLifetimes() TokenLifetimes {
+	return serverTokenLifetimes
+}
+
+// ClientTokenLifetimes 回傳 client 的 token 效期；client 未設定的欄位沿用伺服器預設
+func ClientTokenLifetimes(c model.OAuthClient) TokenLifetimes {
+	l := TokenLifetimes{
+		AccessTokenTTL:          seconds(c.AccessTokenTTL),
+		RefreshTokenTTL:         seconds(c.RefreshTokenTTL),
+		RefreshTokenAbsoluteTTL: seconds(c.RefreshTokenAbsoluteTTL),
+		RefreshTokenIdleTimeout: seconds(c.RefreshTokenIdleTimeout),
+		IDTokenTTL:              seconds(c.IDTokenTTL),
+		Audience:                c.AccessTokenAudiences,
+	}
+	return l.withDefaults(serverTokenLifetimes)
+}
+
+// AcceptsAudience 回傳本服務是否接受 aud 為 audience 的 access token：未限定 aud，或 aud 含有伺服器預設的 audience
+func AcceptsAudience(audience []string) bool {
+	if len(audience) == 0 {
+		return true
+	}
+	for _, aud := range serverTokenLifetimes.Audience {
+		if slices.Contains(audience, aud) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 檢查效期不為負數且不超過上限
+func (l TokenLifetimes) Validate() error {
+	for _, f := range []struct {
+		name       string
+		value, max time.Duration
+	}{
+		{"access_token_ttl", l.AccessTokenTTL, MaxAccessTokenTTL},
+		{"refresh_token_ttl", l.RefreshTokenTTL, MaxRefreshTokenTTL},
+		{"refresh_token_absolute_ttl", l.RefreshTokenAbsoluteTTL, MaxRefreshTokenTTL},
+		{"refresh_token_idle_timeout", l.RefreshTokenIdleTimeout, MaxRefreshTokenTTL},
+		{"id_token_ttl", l.IDTokenTTL, MaxIDTokenTTL},
+	} {
+		if f.value < 0 {
+			return fmt.Errorf("invalid %s: must not be negative", f.name)
+		}
+		if f.value > f.max {
+			return fmt.Errorf("invalid %s: must not exceed %s", f.name, f.max)
+		}
+	}
+	return nil
+}
+
+// validateTokenLifetimes 檢查 client 設定的效期不為負數且不超過上限、aud 不含空白
+func validateTokenLifetimes(c model.OAuthClient) error {
+	for _, f := range []struct {
+		name  string
+		value int
+		max   time.Duration
+	}{
+		{"access_token_ttl", c.AccessTokenTTL, MaxAccessTokenTTL},
+		{"refresh_token_ttl", c.RefreshTokenTTL, MaxRefreshTokenTTL},
+		{"refresh_token_absolute_ttl", c.RefreshTokenAbsoluteTTL, MaxRefreshTokenTTL},
+		{"refresh_token_idle_timeout", c.RefreshTokenIdleTimeout, MaxRefreshTokenTTL},
+		{"id_token_ttl", c.IDTokenTTL, MaxIDTokenTTL},
+	} {
+		if f.value < 0 {
+			return fmt.Errorf("invalid %s: must not be negative", f.name)
+		}
+		// 以秒比較，避免過大的值轉為 time.Duration 時溢位
+		if f.value > int(f.max/time.Second) {
+			return fmt.Errorf("invalid %s: must not exceed %d seconds", f.name, int(f.max/time.Second))
+		}
+	}
+	for _, aud := range c.AccessTokenAudiences {
+		if aud == "" || strings.ContainsAny(aud, " \t\n") {
+			return fmt.Errorf("invalid access token audience: %q", aud)
+		}
+	}
+	return nil
+}
+
+func (l TokenLifetimes) withDefaults(d TokenLifetimes) TokenLifetimes {
+	if l.AccessTokenTTL <= 0 {
+		l.AccessTokenTTL = d.AccessTokenTTL
+	}
+	if l.RefreshTokenTTL <= 0 {
+		l.RefreshTokenTTL = d.RefreshTokenTTL
+	}
+	if l.RefreshTokenAbsoluteTTL <= 0 {
+		l.RefreshTokenAbsoluteTTL = d.RefreshTokenAbsoluteTTL
+	}
+	if l.RefreshTokenIdleTimeout <= 0 {
+		l.RefreshTokenIdleTimeout = d.RefreshTokenIdleTimeout
+	}
+	if l.IDTokenTTL <= 0 {
+		l.IDTokenTTL = d.IDTokenTTL
+	}
+	if len(l.Audience) == 0 {
+		l.Audience = d.Audience
+	}
+	return l
+}
+
+// refreshTokenTTL 回傳此刻發行的 refresh token 效期：取 RefreshTokenTTL 與閒置逾時的較短者，
+// 且不超過 family 的絕對到期時間（familyExpiresAt 為 0 表示不限）
+func (l TokenLifetimes) refreshTokenTTL(now time.Time, familyExpiresAt int64) time.Duration {
+	ttl := l.RefreshTokenTTL
+	if l.RefreshTokenIdleTimeout > 0 && l.RefreshTokenIdleTimeout < ttl {
+		ttl = l.RefreshTokenIdleTimeout
+	}
+	if familyExpiresAt != 0 {
+		if remaining := time.Unix(familyExpiresAt, 0).Sub(now); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	return ttl
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenLifetimes(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	require.Equal(t, DefaultTokenLifetimes, ServerTokenLifetimes())
+	require.Equal(t, DefaultTokenLifetimes, ClientTokenLifetimes(model.OAuthClient{}))
+
+	// 伺服器設定未指定的效期沿用內建預設
+	UseTokenLifetimes(TokenLifetimes{AccessTokenTTL: time.Hour, RefreshTokenIdleTimeout: 7 * 24 * time.Hour, Audience: []string{"api"}})
+	server := ServerTokenLifetimes()
+	require.Equal(t, time.Hour, server.AccessTokenTTL)
+	require.Equal(t, DefaultTokenLifetimes.RefreshTokenTTL, server.RefreshTokenTTL)
+	require.Equal(t, 7*24*time.Hour, server.RefreshTokenIdleTimeout)
+	require.Equal(t, DefaultTokenLifetimes.IDTokenTTL, server.IDTokenTTL)
+	require.Equal(t, []string{"api"}, server.Audience)
+
+	// client 設定覆寫伺服器預設
+	got := ClientTokenLifetimes(model.OAuthClient{
+		AccessTokenTTL:          300,
+		RefreshTokenTTL:         3600,
+		RefreshTokenAbsoluteTTL: 86400,
+		IDTokenTTL:              600,
+		AccessTokenAudiences:    []string{"billing"},
+	})
+	require.Equal(t, TokenLifetimes{
+		AccessTokenTTL:          5 * time.Minute,
+		RefreshTokenTTL:         time.Hour,
+		RefreshTokenAbsoluteTTL: 24 * time.Hour,
+		RefreshTokenIdleTimeout: 7 * 24 * time.Hour,
+		IDTokenTTL:              10 * time.Minute,
+		Audience:                []string{"billing"},
+	}, got)
+	require.Equal(t, []string{"api"}, ClientTokenLifetimes(model.OAuthClient{}).Audience)
+}
+
+func TestAcceptsAudience(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	require.True(t, AcceptsAudience(nil))
+	require.False(t, AcceptsAudience([]string{"billing"}))
+
+	UseTokenLifetimes(TokenLifetimes{Audience: []string{"api", "api2"}})
+	require.True(t, AcceptsAudience(nil))
+	require.True(t, AcceptsAudience([]string{"billing", "api2"}))
+	require.False(t, AcceptsAudience([]string{"billing"}))
+}
+
+func TestValidateTokenLifetimes(t *testing.T) {
+	require.NoError(t, validateTokenLifetimes(model.OAuthClient{AccessTokenTTL: 60, AccessTokenAudiences: []string{"api"}}))
+	require.NoError(t, validateTokenLifetimes(model.OAuthClient{AccessTokenTTL: 86400, IDTokenTTL: 86400, RefreshTokenTTL: 365 * 24 * 3600}))
+	require.NoError(t, DefaultTokenLifetimes.Validate())
+	require.ErrorContains(t, TokenLifetimes{AccessTokenTTL: MaxAccessTokenTTL + time.Second}.Validate(), "access_token_ttl")
+	require.ErrorContains(t, TokenLifetimes{RefreshTokenIdleTimeout: -time.Second}.Validate(), "refresh_token_idle_timeout")
+	for _, c := range []model.OAuthClient{
+		{AccessTokenTTL: -1},
+		{RefreshTokenTTL: -1},
+		{RefreshTokenAbsoluteTTL: -1},
+		{RefreshTokenIdleTimeout: -1},
+		{IDTokenTTL: -1},
+		{AccessTokenTTL: 86401},
+		{IDTokenTTL: 86401},
+		{RefreshTokenAbsoluteTTL: 365*24*3600 + 1},
+		{RefreshTokenTTL: math.MaxInt},
+		{AccessTokenAudiences: []string{""}},
+		{AccessTokenAudiences: []string{"a b"}},
+	} {
+		require.Error(t, validateTokenLifetimes(c), c)
+	}
+}
+
+func TestIssueRefreshTokenLifetimes(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+
+	rc := cache.NewMemoryCache(nil)
+	tok, err := IssueRefreshToken(ctx, rc, 1, "cli", false, "", TokenLifetimes{
+		RefreshTokenTTL:         24 * time.Hour,
+		RefreshTokenAbsoluteTTL: 12 * time.Hour,
+	}, "", Authentication{})
+	require.NoError(t, err)
+	var d RefreshTokenData
+	require.NoError(t, json.Unmarshal([]byte(rc.Data["refresh_token:"+tok]), &d))
+	require.Equal(t, now.Add(12*time.Hour).Unix(), d.FamilyExpiresAt)
+	require.Equal(t, now.Add(12*time.Hour).Unix(), d.ExpiresAt)
+	require.Equal(t, 12*time.Hour, rc.TTLs["refresh_token:"+tok])
+
+	tok, err = IssueRefreshToken(ctx, rc, 1, "cli", false, "", TokenLifetimes{
+		RefreshTokenTTL:         24 * time.Hour,
+		RefreshTokenIdleTimeout: time.Hour,
+	}, "", Authentication{})
+	require.NoError(t, err)
+	d = RefreshTokenData{}
+	require.NoError(t, json.Unmarshal([]byte(rc.Data["refresh_token:"+tok]), &d))
+	require.Zero(t, d.FamilyExpiresAt)
+	require.Equal(t, time.Hour, rc.TTLs["refresh_token:"+tok])
+}
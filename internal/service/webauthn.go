@@ -0,0 +1,461 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+	"life-is-hard/internal/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// WebAuthnChallengeTTL 為註冊與登入 ceremony 的期限，亦作為交給瀏覽器的 timeout
+	WebAuthnChallengeTTL = 5 * time.Minute
+
+	// COSE 演算法識別碼（RFC 9053）
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+
+	// authenticator data 的 flags（WebAuthn §6.1）
+	authDataUserPresent  = 0x01
+	authDataUserVerified = 0x04
+	authDataAttested     = 0x40
+	authDataExtensions   = 0x80
+
+	// maxCredentialIDLength 為 WebAuthn §5.8.3 規定的 credential ID 長度上限
+	maxCredentialIDLength = 1023
+	// defaultWebAuthnCredentialName 為註冊時未命名的 credential 名稱
+	defaultWebAuthnCredentialName = "Passkey"
+)
+
+// WebAuthnRelyingParty 為 WebAuthn 的 relying party：ID 為 passkey 綁定的網域，Origins 為允許發起 ceremony 的前端 origin
+type WebAuthnRelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// DefaultWebAuthnRelyingParty 為未設定時供本機開發使用的 relying party
+var DefaultWebAuthnRelyingParty = WebAuthnRelyingParty{
+	ID:      "localhost",
+	Name:    "life-is-hard",
+	Origins: []string{"http://localhost:8080"},
+}
+
+var (
+	ErrInvalidWebAuthnChallenge = errors.New("invalid or expired webauthn challenge")
+	ErrInvalidWebAuthnResponse  = errors.New("invalid webauthn response")
+	ErrWebAuthnCredentialExists = errors.New("webauthn credential already registered")
+
+	webAuthnRP = DefaultWebAuthnRelyingParty
+	// webAuthnAlgorithms 為可註冊的公鑰演算法，依偏好排序
+	webAuthnAlgorithms = []int{coseAlgES256, coseAlgEdDSA, coseAlgRS256}
+)
+
+// UseWebAuthnRelyingParty 設定 relying party；未設定 ID 時使用預設值，未設定 Origins 時僅允許 https://<ID>
+func UseWebAuthnRelyingParty(rp WebAuthnRelyingParty) {
+	if rp.ID == "" {
+		rp.ID = DefaultWebAuthnRelyingParty.ID
+		if len(rp.Origins) == 0 {
+			rp.Origins = DefaultWebAuthnRelyingParty.Origins
+		}
+	}
+	if rp.Name == "" {
+		rp.Name = DefaultWebAuthnRelyingParty.Name
+	}
+	if len(rp.Origins) == 0 {
+		rp.Origins = []string{"https://" + rp.ID}
+	}
+	webAuthnRP = rp
+}
+
+// WebAuthnRP 回傳目前的 relying party
+func WebAuthnRP() WebAuthnRelyingParty {
+	return webAuthnRP
+}
+
+// WebAuthnAlgorithms 回傳可註冊的公鑰演算法（COSE 識別碼）
+func WebAuthnAlgorithms() []int {
+	return webAuthnAlgorithms
+}
+
+// WebAuthnUserHandle 回傳 credential 中代表使用者的 user handle（base64url），不含個人資料
+func WebAuthnUserHandle(userID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(userID)))
+}
+
+// WebAuthnRegistration 為註冊 ceremony 的參數；Exclude 為使用者已註冊的 credential，避免同一 authenticator 重複註冊
+type WebAuthnRegistration struct {
+	Challenge  string
+	UserHandle string
+	Exclude    []model.WebAuthnCredential
+}
+
+// WebAuthnAttestationResponse 為 navigator.credentials.create() 回傳的 credential，二進位欄位皆為 base64url
+type WebAuthnAttestationResponse struct {
+	ID                string
+	ClientDataJSON    string
+	AttestationObject string
+	Transports        []string
+}
+
+// WebAuthnAssertionResponse 為 navigator.credentials.get() 回傳的 credential，二進位欄位皆為 base64url
+type WebAuthnAssertionResponse struct {
+	ID                string
+	ClientDataJSON    string
+	AuthenticatorData string
+	Signature         string
+	UserHandle        string
+}
+
+// BeginWebAuthnRegistration 開始註冊 ceremony，challenge 只能用於該使用者且只能使用一次
+func BeginWebAuthnRegistration(ctx context.Context, db database.DB, cache cache.Cache, userID int) (*WebAuthnRegistration, error) {
+	existing, err := store.ListWebAuthnCredentials(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := newWebAuthnChallenge(ctx, cache, "webauthn_registration", strconv.Itoa(userID))
+	if err != nil {
+		return nil, err
+	}
+	return &WebAuthnRegistration{Challenge: challenge, UserHandle: WebAuthnUserHandle(userID), Exclude: existing}, nil
+}
+
+// FinishWebAuthnRegistration 依 WebAuthn §7.1 驗證 attestation 並保存 credential。僅接受 none attestation，
+// 且 authenticator 須驗證使用者（UV），使 passkey 可單獨作為登入方式
+func FinishWebAuthnRegistration(ctx context.Context, db database.DB, cache cache.Cache, userID int, name string, resp WebAuthnAttestationResponse) (*model.WebAuthnCredential, error) {
+	clientData, _, err := parseClientData(resp.ClientDataJSON, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+	owner, err := consumeWebAuthnChallenge(ctx, cache, "webauthn_registration", clientData.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	if owner != strconv.Itoa(userID) {
+		return nil, ErrInvalidWebAuthnChallenge
+	}
+
+	attestationObject, err := decodeBase64URL(resp.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid attestationObject", ErrInvalidWebAuthnResponse)
+	}
+	authData, err := parseAttestationObject(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := parseAuthenticatorData(authData, true)
+	if err != nil {
+		return nil, err
+	}
+	rawID, err := decodeBase64URL(resp.ID)
+	if err != nil || !bytes.Equal(rawID, auth.credentialID) {
+		return nil, fmt.Errorf("%w: credential id mismatch", ErrInvalidWebAuthnResponse)
+	}
+	if _, _, err := parseCOSEKey(auth.publicKey); err != nil {
+		return nil, err
+	}
+
+	if name = strings.TrimSpace(name); name == "" {
+		name = defaultWebAuthnCredentialName
+	}
+	transports := resp.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	cred := &model.WebAuthnCredential{
+		ID:         base64.RawURLEncoding.EncodeToString(auth.credentialID),
+		UserID:     userID,
+		Name:       name,
+		PublicKey:  auth.publicKey,
+		SignCount:  int64(auth.signCount),
+		Transports: transports,
+		CreatedAt:  timeNow(),
+	}
+	created, err := store.CreateWebAuthnCredential(ctx, db, cred)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, ErrWebAuthnCredentialExists
+	}
+	return cred, nil
+}
+
+// BeginWebAuthnLogin 開始登入 ceremony；不限定 credential，由瀏覽器列出可發現的 passkey 供使用者選擇
+func BeginWebAuthnLogin(ctx context.Context, cache cache.Cache) (string, error) {
+	return newWebAuthnChallenge(ctx, cache, "webauthn_login", "1")
+}
+
+// FinishWebAuthnLogin 依 WebAuthn §7.2 驗證 assertion 並回傳 credential 所屬的使用者；
+// 簽章計數未遞增時視為 authenticator 可能遭複製而拒絕
+func FinishWebAuthnLogin(ctx context.Context, db database.DB, cache cache.Cache, resp WebAuthnAssertionResponse) (int, error) {
+	clientData, clientDataJSON, err := parseClientData(resp.ClientDataJSON, "webauthn.get")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := consumeWebAuthnChallenge(ctx, cache, "webauthn_login", clientData.Challenge); err != nil {
+		return 0, err
+	}
+
+	rawID, err := decodeBase64URL(resp.ID)
+	if err != nil || len(rawID) == 0 {
+		return 0, fmt.Errorf("%w: invalid credential id", ErrInvalidWebAuthnResponse)
+	}
+	cred, err := store.GetWebAuthnCredential(ctx, db, base64.RawURLEncoding.EncodeToString(rawID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("%w: unknown credential", ErrInvalidWebAuthnResponse)
+	}
+	if err != nil {
+		return 0, err
+	}
+	// 未指定 allowCredentials 時 authenticator 必須回傳 user handle
+	userHandle, err := decodeBase64URL(resp.UserHandle)
+	if err != nil || string(userHandle) != strconv.Itoa(cred.UserID) {
+		return 0, fmt.Errorf("%w: user handle mismatch", ErrInvalidWebAuthnResponse)
+	}
+
+	authData, err := decodeBase64URL(resp.AuthenticatorData)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid authenticatorData", ErrInvalidWebAuthnResponse)
+	}
+	auth, err := parseAuthenticatorData(authData, false)
+	if err != nil {
+		return 0, err
+	}
+	alg, public, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	signature, err := decodeBase64URL(resp.Signature)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid signature", ErrInvalidWebAuthnResponse)
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if !verifyWebAuthnSignature(alg, public, slices.Concat(authData, clientDataHash[:]), signature) {
+		return 0, fmt.Errorf("%w: invalid signature", ErrInvalidWebAuthnResponse)
+	}
+
+	// 不支援簽章計數的 authenticator 恆回傳 0
+	signCount := int64(auth.signCount)
+	if (signCount != 0 || cred.SignCount != 0) && signCount <= cred.SignCount {
+		return 0, fmt.Errorf("%w: sign count did not increase", ErrInvalidWebAuthnResponse)
+	}
+	used, err := store.UseWebAuthnCredential(ctx, db, cred.ID, cred.SignCount, signCount)
+	if err != nil {
+		return 0, err
+	}
+	if !used {
+		return 0, fmt.Errorf("%w: sign count did not increase", ErrInvalidWebAuthnResponse)
+	}
+	return cred.UserID, nil
+}
+
+// newWebAuthnChallenge 產生 challenge 並以其 SHA-256 雜湊為鍵保存 value
+func newWebAuthnChallenge(ctx context.Context, cache cache.Cache, prefix, value string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := randRead(b); err != nil {
+		return "", fmt.Errorf("failed to generate webauthn challenge: %w", err)
+	}
+	challenge := base64.RawURLEncoding.EncodeToString(b)
+	if err := cache.Set(ctx, webAuthnChallengeKey(prefix, challenge), value, WebAuthnChallengeTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed to store webauthn challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// consumeWebAuthnChallenge 取出並刪除 challenge 保存的 value，確保 challenge 只能使用一次
+func consumeWebAuthnChallenge(ctx context.Context, cache cache.Cache, prefix, challenge string) (string, error) {
+	key := webAuthnChallengeKey(prefix, challenge)
+	value, err := cache.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", ErrInvalidWebAuthnChallenge
+		}
+		return "", fmt.Errorf("failed to retrieve webauthn challenge: %w", err)
+	}
+	deleted, err := cache.Del(ctx, key).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to delete webauthn challenge: %w", err)
+	}
+	if deleted == 0 {
+		return "", ErrInvalidWebAuthnChallenge
+	}
+	return value, nil
+}
+
+func webAuthnChallengeKey(prefix, challenge string) string {
+	sum := sha256.Sum256([]byte(challenge))
+	return fmt.Sprintf("%s:%s", prefix, hex.EncodeToString(sum[:]))
+}
+
+// collectedClientData 為 WebAuthn §5.8.1 的 client data
+type collectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// parseClientData 解析 clientDataJSON 並檢查 ceremony 類型與 origin，回傳解析結果與原始 JSON
+func parseClientData(encoded, typ string) (*collectedClientData, []byte, error) {
+	raw, err := decodeBase64URL(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid clientDataJSON", ErrInvalidWebAuthnResponse)
+	}
+	var cd collectedClientData
+	if err := jsonUnmarshal(raw, &cd); err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid clientDataJSON", ErrInvalidWebAuthnResponse)
+	}
+	if cd.Type != typ {
+		return nil, nil, fmt.Errorf("%w: unexpected type %q", ErrInvalidWebAuthnResponse, cd.Type)
+	}
+	if !slices.Contains(webAuthnRP.Origins, cd.Origin) || cd.CrossOrigin {
+		return nil, nil, fmt.Errorf("%w: origin %q is not allowed", ErrInvalidWebAuthnResponse, cd.Origin)
+	}
+	return &cd, raw, nil
+}
+
+// parseAttestationObject 解析 attestation object 並回傳 authenticator data；僅接受 none attestation
+func parseAttestationObject(b []byte) ([]byte, error) {
+	v, rest, err := decodeCBOR(b)
+	m, ok := v.(map[any]any)
+	if err != nil || len(rest) != 0 || !ok {
+		return nil, fmt.Errorf("%w: invalid attestationObject", ErrInvalidWebAuthnResponse)
+	}
+	if format, _ := m["fmt"].(string); format != "none" {
+		return nil, fmt.Errorf("%w: unsupported attestation format %q", ErrInvalidWebAuthnResponse, format)
+	}
+	authData, ok := m["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing authData", ErrInvalidWebAuthnResponse)
+	}
+	return authData, nil
+}
+
+// authenticatorData 為 WebAuthn §6.1 的 authenticator data；credentialID 與 publicKey 僅於註冊時存在
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData 解析 authenticator data，檢查 RP ID 雜湊並要求使用者在場且已驗證；
+// attested 為 true 時另解析 attested credential data
+func parseAuthenticatorData(b []byte, attested bool) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrInvalidWebAuthnResponse)
+	}
+	rpIDHash := sha256.Sum256([]byte(webAuthnRP.ID))
+	if !bytes.Equal(b[:32], rpIDHash[:]) {
+		return nil, fmt.Errorf("%w: rp id mismatch", ErrInvalidWebAuthnResponse)
+	}
+	d := &authenticatorData{flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+	if d.flags&authDataUserPresent == 0 {
+		return nil, fmt.Errorf("%w: user not present", ErrInvalidWebAuthnResponse)
+	}
+	if d.flags&authDataUserVerified == 0 {
+		return nil, fmt.Errorf("%w: user not verified", ErrInvalidWebAuthnResponse)
+	}
+	if !attested {
+		return d, nil
+	}
+
+	if d.flags&authDataAttested == 0 {
+		return nil, fmt.Errorf("%w: missing attested credential data", ErrInvalidWebAuthnResponse)
+	}
+	// aaguid (16 bytes) 之後為 2 bytes 的 credential ID 長度
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("%w: invalid attested credential data", ErrInvalidWebAuthnResponse)
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || idLen > maxCredentialIDLength || len(rest) < idLen {
+		return nil, fmt.Errorf("%w: invalid credential id", ErrInvalidWebAuthnResponse)
+	}
+	d.credentialID, rest = rest[:idLen], rest[idLen:]
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid credential public key", ErrInvalidWebAuthnResponse)
+	}
+	d.publicKey = rest[:len(rest)-len(after)]
+	if len(after) != 0 && d.flags&authDataExtensions == 0 {
+		return nil, fmt.Errorf("%w: unexpected trailing data", ErrInvalidWebAuthnResponse)
+	}
+	return d, nil
+}
+
+// parseCOSEKey 將 RFC 9052 §7 的 COSE 公鑰轉為 JWK 後還原為 Go 的公鑰型別，回傳其演算法
+func parseCOSEKey(b []byte) (int, crypto.PublicKey, error) {
+	v, rest, err := decodeCBOR(b)
+	m, ok := v.(map[any]any)
+	if err != nil || len(rest) != 0 || !ok {
+		return 0, nil, fmt.Errorf("%w: invalid credential public key", ErrInvalidWebAuthnResponse)
+	}
+	param := func(label int64) string {
+		b, _ := m[label].([]byte)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	var jwk JSONWebKey
+	switch {
+	case kty == 2 && alg == coseAlgES256 && m[int64(-1)] == int64(1):
+		jwk = JSONWebKey{KeyType: "EC", Curve: "P-256", X: param(-2), Y: param(-3)}
+	case kty == 1 && alg == coseAlgEdDSA && m[int64(-1)] == int64(6):
+		jwk = JSONWebKey{KeyType: "OKP", Curve: "Ed25519", X: param(-2)}
+	case kty == 3 && alg == coseAlgRS256:
+		jwk = JSONWebKey{KeyType: "RSA", N: param(-1), E: param(-2)}
+	default:
+		return 0, nil, fmt.Errorf("%w: unsupported credential public key (kty %d, alg %d)", ErrInvalidWebAuthnResponse, kty, alg)
+	}
+	public, err := parsePublicJWK(jwk)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrInvalidWebAuthnResponse, err)
+	}
+	return int(alg), public, nil
+}
+
+// verifyWebAuthnSignature 驗證 assertion 簽章；ECDSA 簽章為 ASN.1 DER 格式
+func verifyWebAuthnSignature(alg int, public crypto.PublicKey, data, signature []byte) bool {
+	digest := sha256.Sum256(data)
+	switch alg {
+	case coseAlgES256:
+		pk, ok := public.(*ecdsa.PublicKey)
+		return ok && ecdsa.VerifyASN1(pk, digest[:], signature)
+	case coseAlgEdDSA:
+		pk, ok := public.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pk, data, signature)
+	case coseAlgRS256:
+		pk, ok := public.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pk, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+// decodeBase64URL 解碼 base64url，容許帶有 padding
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,539 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/cache"
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// softAuthenticator 以軟體模擬支援 resident key 與使用者驗證的 authenticator
+type softAuthenticator struct {
+	alg          int
+	signer       crypto.Signer
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	// 以下欄位可覆寫產生的回應以模擬異常的 client 或 authenticator
+	rpID   string
+	origin string
+	flags  byte
+	format string
+	// noCounter 模擬不支援簽章計數、恆回傳 0 的 authenticator
+	noCounter bool
+}
+
+func newSoftAuthenticator(t *testing.T, alg int) *softAuthenticator {
+	t.Helper()
+	a := &softAuthenticator{
+		alg:          alg,
+		credentialID: make([]byte, 16),
+		rpID:         webAuthnRP.ID,
+		origin:       webAuthnRP.Origins[0],
+		flags:        authDataUserPresent | authDataUserVerified,
+		format:       "none",
+	}
+	_, _ = rand.Read(a.credentialID)
+	var err error
+	switch alg {
+	case coseAlgES256:
+		a.signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case coseAlgEdDSA:
+		_, a.signer, err = ed25519.GenerateKey(rand.Reader)
+	case coseAlgRS256:
+		a.signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	require.NoError(t, err)
+	return a
+}
+
+// coseKey 回傳 RFC 9052 格式的公鑰
+func (a *softAuthenticator) coseKey() []byte {
+	var key map[any]any
+	switch pk := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		key = map[any]any{int64(1): int64(2), int64(3): int64(coseAlgES256), int64(-1): int64(1),
+			int64(-2): pk.X.FillBytes(make([]byte, 32)), int64(-3): pk.Y.FillBytes(make([]byte, 32))}
+	case ed25519.PublicKey:
+		key = map[any]any{int64(1): int64(1), int64(3): int64(coseAlgEdDSA), int64(-1): int64(6), int64(-2): []byte(pk)}
+	case *rsa.PublicKey:
+		key = map[any]any{int64(1): int64(3), int64(3): int64(coseAlgRS256),
+			int64(-1): pk.N.Bytes(), int64(-2): big.NewInt(int64(pk.E)).Bytes()}
+	}
+	return encodeCBOR(key)
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	b := append(rpIDHash[:], flags)
+	return append(binary.BigEndian.AppendUint32(b, a.signCount), attested...)
+}
+
+func (a *softAuthenticator) clientData(typ, challenge string) string {
+	b, _ := json.Marshal(collectedClientData{Type: typ, Challenge: challenge, Origin: a.origin})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// create 模擬 navigator.credentials.create()
+func (a *softAuthenticator) create(reg *WebAuthnRegistration) WebAuthnAttestationResponse {
+	a.userHandle, _ = decodeBase64URL(reg.UserHandle)
+	attested := make([]byte, 16) // aaguid
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(append(attested, a.credentialID...), a.coseKey()...)
+	attestationObject := encodeCBOR(map[any]any{
+		"fmt":      a.format,
+		"attStmt":  map[any]any{},
+		"authData": a.authData(a.flags|authDataAttested, attested),
+	})
+	return WebAuthnAttestationResponse{
+		ID:                base64.RawURLEncoding.EncodeToString(a.credentialID),
+		ClientDataJSON:    a.clientData("webauthn.create", reg.Challenge),
+		AttestationObject: base64.RawURLEncoding.EncodeToString(attestationObject),
+		Transports:        []string{"internal"},
+	}
+}
+
+// get 模擬 navigator.credentials.get()，每次簽章遞增計數
+func (a *softAuthenticator) get(challenge string) WebAuthnAssertionResponse {
+	if !a.noCounter {
+		a.signCount++
+	}
+	authData := a.authData(a.flags, nil)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataJSON, _ := decodeBase64URL(clientData)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	data := slices.Concat(authData, clientDataHash[:])
+	var signature []byte
+	if a.alg == coseAlgEdDSA {
+		signature, _ = a.signer.Sign(rand.Reader, data, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(data)
+		signature, _ = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	return WebAuthnAssertionResponse{
+		ID:                base64.RawURLEncoding.EncodeToString(a.credentialID),
+		ClientDataJSON:    clientData,
+		AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+		Signature:         base64.RawURLEncoding.EncodeToString(signature),
+		UserHandle:        base64.RawURLEncoding.EncodeToString(a.userHandle),
+	}
+}
+
+// webauthnDB 以記憶體模擬 webauthn_credentials 資料表
+type webauthnDB struct {
+	creds map[string]model.WebAuthnCredential
+	// failOn 為 SQL 含此字串時回傳錯誤
+	failOn string
+}
+
+type webauthnRow struct {
+	cred model.WebAuthnCredential
+	err  error
+}
+
+func (r *webauthnRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*string) = r.cred.ID
+	*dest[1].(*int) = r.cred.UserID
+	*dest[2].(*string) = r.cred.Name
+	*dest[3].(*[]byte) = r.cred.PublicKey
+	*dest[4].(*int64) = r.cred.SignCount
+	*dest[5].(*[]string) = r.cred.Transports
+	*dest[6].(*time.Time) = r.cred.CreatedAt
+	*dest[7].(**time.Time) = r.cred.LastUsedAt
+	return nil
+}
+
+type webauthnRows struct {
+	creds []model.WebAuthnCredential
+	idx   int
+}
+
+func (r *webauthnRows) Close()                                       {}
+func (r *webauthnRows) Err() error                                   { return nil }
+func (r *webauthnRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *webauthnRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *webauthnRows) Next() bool                                   { return r.idx < len(r.creds) }
+func (r *webauthnRows) Values() ([]any, error)                       { return nil, nil }
+func (r *webauthnRows) RawValues() [][]byte                          { return nil }
+func (r *webauthnRows) Conn() *pgx.Conn                              { return nil }
+func (r *webauthnRows) Scan(dest ...any) error {
+	r.idx++
+	return (&webauthnRow{cred: r.creds[r.idx-1]}).Scan(dest...)
+}
+
+func (w *webauthnDB) fake() *database.FakeDB {
+	affected := func(ok bool) pgconn.CommandTag {
+		if ok {
+			return pgconn.NewCommandTag("UPDATE 1")
+		}
+		return pgconn.NewCommandTag("UPDATE 0")
+	}
+	return &database.FakeDB{
+		QueryRowFn: func(_ context.Context, sql string, args ...any) pgx.Row {
+			if w.failOn != "" && strings.Contains(sql, w.failOn) {
+				return &webauthnRow{err: errors.New("db")}
+			}
+			c, ok := w.creds[args[0].(string)]
+			if !ok {
+				return &webauthnRow{err: pgx.ErrNoRows}
+			}
+			return &webauthnRow{cred: c}
+		},
+		QueryFn: func(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+			if w.failOn != "" && strings.Contains(sql, w.failOn) {
+				return nil, errors.New("db")
+			}
+			var creds []model.WebAuthnCredential
+			for _, c := range w.creds {
+				if c.UserID == args[0].(int) {
+					creds = append(creds, c)
+				}
+			}
+			return &webauthnRows{creds: creds}, nil
+		},
+		ExecFn: func(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+			if w.failOn != "" && strings.Contains(sql, w.failOn) {
+				return pgconn.CommandTag{}, errors.New("db")
+			}
+			id := args[0].(string)
+			switch {
+			case strings.Contains(sql, "INSERT INTO webauthn_credentials"):
+				if _, ok := w.creds[id]; ok {
+					return affected(false), nil
+				}
+				w.creds[id] = model.WebAuthnCredential{
+					ID: id, UserID: args[1].(int), Name: args[2].(string), PublicKey: args[3].([]byte),
+					SignCount: args[4].(int64), Transports: args[5].([]string), CreatedAt: timeNow(),
+				}
+				return affected(true), nil
+			case strings.Contains(sql, "SET sign_count"):
+				c, ok := w.creds[id]
+				if !ok || c.SignCount != args[1].(int64) {
+					return affected(false), nil
+				}
+				now := timeNow()
+				c.SignCount, c.LastUsedAt = args[2].(int64), &now
+				w.creds[id] = c
+				return affected(true), nil
+			}
+			return affected(false), errors.New("unexpected sql")
+		},
+	}
+}
+
+func TestUseWebAuthnRelyingParty(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+
+	UseWebAuthnRelyingParty(WebAuthnRelyingParty{})
+	require.Equal(t, DefaultWebAuthnRelyingParty, WebAuthnRP())
+
+	UseWebAuthnRelyingParty(WebAuthnRelyingParty{ID: "example.com"})
+	require.Equal(t, WebAuthnRelyingParty{ID: "example.com", Name: "life-is-hard", Origins: []string{"https://example.com"}}, WebAuthnRP())
+
+	rp := WebAuthnRelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://login.example.com"}}
+	UseWebAuthnRelyingParty(rp)
+	require.Equal(t, rp, WebAuthnRP())
+
+	require.Equal(t, []int{coseAlgES256, coseAlgEdDSA, coseAlgRS256}, WebAuthnAlgorithms())
+	require.Equal(t, "NDI", WebAuthnUserHandle(42))
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+
+	for name, alg := range map[string]int{"ES256": coseAlgES256, "EdDSA": coseAlgEdDSA, "RS256": coseAlgRS256} {
+		t.Run(name, func(t *testing.T) {
+			db := &webauthnDB{creds: map[string]model.WebAuthnCredential{}}
+			c := cache.NewMemoryCache(nil)
+			authenticator := newSoftAuthenticator(t, alg)
+
+			reg, err := BeginWebAuthnRegistration(ctx, db.fake(), c, 7)
+			require.NoError(t, err)
+			require.Equal(t, WebAuthnUserHandle(7), reg.UserHandle)
+			require.Empty(t, reg.Exclude)
+			require.Len(t, c.Data, 1)
+			for _, ttl := range c.TTLs {
+				require.Equal(t, WebAuthnChallengeTTL, ttl)
+			}
+
+			cred, err := FinishWebAuthnRegistration(ctx, db.fake(), c, 7, "  ", authenticator.create(reg))
+			require.NoError(t, err)
+			require.Equal(t, base64.RawURLEncoding.EncodeToString(authenticator.credentialID), cred.ID)
+			require.Equal(t, 7, cred.UserID)
+			require.Equal(t, defaultWebAuthnCredentialName, cred.Name)
+			require.Equal(t, []string{"internal"}, cred.Transports)
+			require.Contains(t, db.creds, cred.ID)
+			require.Empty(t, c.Data, "registration challenge must be consumed")
+
+			reg, err = BeginWebAuthnRegistration(ctx, db.fake(), c, 7)
+			require.NoError(t, err)
+			require.Len(t, reg.Exclude, 1)
+
+			challenge, err := BeginWebAuthnLogin(ctx, c)
+			require.NoError(t, err)
+			assertion := authenticator.get(challenge)
+			userID, err := FinishWebAuthnLogin(ctx, db.fake(), c, assertion)
+			require.NoError(t, err)
+			require.Equal(t, 7, userID)
+			require.Equal(t, int64(1), db.creds[cred.ID].SignCount)
+			require.NotNil(t, db.creds[cred.ID].LastUsedAt)
+
+			// 同一 assertion 不可重放
+			_, err = FinishWebAuthnLogin(ctx, db.fake(), c, assertion)
+			require.ErrorIs(t, err, ErrInvalidWebAuthnChallenge)
+		})
+	}
+}
+
+func TestFinishWebAuthnRegistrationErrors(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name   string
+		userID int
+		setup  func(*softAuthenticator)
+		edit   func(*softAuthenticator, *WebAuthnAttestationResponse)
+		err    error
+	}{
+		{name: "wrong origin", userID: 7, setup: func(a *softAuthenticator) { a.origin = "https://evil.example" }, err: ErrInvalidWebAuthnResponse},
+		{name: "wrong rp id", userID: 7, setup: func(a *softAuthenticator) { a.rpID = "evil.example" }, err: ErrInvalidWebAuthnResponse},
+		{name: "user not verified", userID: 7, setup: func(a *softAuthenticator) { a.flags = authDataUserPresent }, err: ErrInvalidWebAuthnResponse},
+		{name: "user not present", userID: 7, setup: func(a *softAuthenticator) { a.flags = authDataUserVerified }, err: ErrInvalidWebAuthnResponse},
+		{name: "packed attestation", userID: 7, setup: func(a *softAuthenticator) { a.format = "packed" }, err: ErrInvalidWebAuthnResponse},
+		{name: "other user's challenge", userID: 8, err: ErrInvalidWebAuthnChallenge},
+		{name: "credential id mismatch", userID: 7, edit: func(_ *softAuthenticator, r *WebAuthnAttestationResponse) { r.ID = "b3RoZXI" }, err: ErrInvalidWebAuthnResponse},
+		{name: "login ceremony type", userID: 7, edit: func(a *softAuthenticator, r *WebAuthnAttestationResponse) {
+			r.ClientDataJSON = a.clientData("webauthn.get", "x")
+		}, err: ErrInvalidWebAuthnResponse},
+		{name: "unknown challenge", userID: 7, edit: func(a *softAuthenticator, r *WebAuthnAttestationResponse) {
+			r.ClientDataJSON = a.clientData("webauthn.create", "unknown")
+		}, err: ErrInvalidWebAuthnChallenge},
+		{name: "invalid client data", userID: 7, edit: func(_ *softAuthenticator, r *WebAuthnAttestationResponse) { r.ClientDataJSON = "!" }, err: ErrInvalidWebAuthnResponse},
+		{name: "invalid attestation object", userID: 7, edit: func(_ *softAuthenticator, r *WebAuthnAttestationResponse) { r.AttestationObject = "AA" }, err: ErrInvalidWebAuthnResponse},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &webauthnDB{creds: map[string]model.WebAuthnCredential{}}
+			c := cache.NewMemoryCache(nil)
+			authenticator := newSoftAuthenticator(t, coseAlgES256)
+			if tc.setup != nil {
+				tc.setup(authenticator)
+			}
+			reg, err := BeginWebAuthnRegistration(ctx, db.fake(), c, 7)
+			require.NoError(t, err)
+			resp := authenticator.create(reg)
+			if tc.edit != nil {
+				tc.edit(authenticator, &resp)
+			}
+			_, err = FinishWebAuthnRegistration(ctx, db.fake(), c, tc.userID, "Laptop", resp)
+			require.ErrorIs(t, err, tc.err)
+			require.Empty(t, db.creds)
+		})
+	}
+
+	t.Run("duplicate credential", func(t *testing.T) {
+		db := &webauthnDB{creds: map[string]model.WebAuthnCredential{}}
+		c := cache.NewMemoryCache(nil)
+		authenticator := newSoftAuthenticator(t, coseAlgES256)
+		for i, want := range []error{nil, ErrWebAuthnCredentialExists} {
+			reg, err := BeginWebAuthnRegistration(ctx, db.fake(), c, 7)
+			require.NoError(t, err)
+			_, err = FinishWebAuthnRegistration(ctx, db.fake(), c, 7, "Laptop", authenticator.create(reg))
+			require.ErrorIs(t, err, want, i)
+		}
+		require.Len(t, db.creds, 1)
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		c := cache.NewMemoryCache(nil)
+		db := &webauthnDB{creds: map[string]model.WebAuthnCredential{}, failOn: "ORDER BY created_at"}
+		_, err := BeginWebAuthnRegistration(ctx, db.fake(), c, 7)
+		require.ErrorContains(t, err, "ListWebAuthnCredentials")
+
+		db.failOn = "INSERT"
+		reg, err := BeginWebAuthnRegistration(ctx, db.fake(), c, 7)
+		require.NoError(t, err)
+		_, err = FinishWebAuthnRegistration(ctx, db.fake(), c, 7, "", newSoftAuthenticator(t, coseAlgES256).create(reg))
+		require.ErrorContains(t, err, "CreateWebAuthnCredential")
+	})
+
+	t.Run("cache errors", func(t *testing.T) {
+		db := &webauthnDB{creds: map[string]model.WebAuthnCredential{}}
+		c := cache.NewMemoryCache(nil)
+		c.FailOn["set"] = "webauthn_registration:"
+		_, err := BeginWebAuthnRegistration(ctx, db.fake(), c, 7)
+		require.ErrorContains(t, err, "failed to store webauthn challenge")
+
+		delete(c.FailOn, "set")
+		for op, msg := range map[string]string{"get": "retrieve", "del": "delete"} {
+			reg, err := BeginWebAuthnRegistration(ctx, db.fake(), c, 7)
+			require.NoError(t, err)
+			c.FailOn[op] = "webauthn_registration:"
+			_, err = FinishWebAuthnRegistration(ctx, db.fake(), c, 7, "", newSoftAuthenticator(t, coseAlgES256).create(reg))
+			require.ErrorContains(t, err, msg)
+			delete(c.FailOn, op)
+		}
+
+		randRead = func([]byte) (int, error) { return 0, errors.New("rand") }
+		_, err = BeginWebAuthnRegistration(ctx, db.fake(), c, 7)
+		require.ErrorContains(t, err, "failed to generate webauthn challenge")
+	})
+}
+
+func TestFinishWebAuthnLoginErrors(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	ctx := context.Background()
+
+	// register 建立已註冊 ES256 passkey 的使用者 7
+	register := func(t *testing.T) (*webauthnDB, *cache.MemoryCache, *softAuthenticator) {
+		db := &webauthnDB{creds: map[string]model.WebAuthnCredential{}}
+		c := cache.NewMemoryCache(nil)
+		authenticator := newSoftAuthenticator(t, coseAlgES256)
+		reg, err := BeginWebAuthnRegistration(ctx, db.fake(), c, 7)
+		require.NoError(t, err)
+		_, err = FinishWebAuthnRegistration(ctx, db.fake(), c, 7, "", authenticator.create(reg))
+		require.NoError(t, err)
+		return db, c, authenticator
+	}
+
+	for _, tc := range []struct {
+		name  string
+		setup func(*softAuthenticator)
+		edit  func(*softAuthenticator, *WebAuthnAssertionResponse)
+		err   error
+	}{
+		{name: "unknown credential", edit: func(_ *softAuthenticator, r *WebAuthnAssertionResponse) { r.ID = "b3RoZXI" }, err: ErrInvalidWebAuthnResponse},
+		{name: "user handle mismatch", edit: func(_ *softAuthenticator, r *WebAuthnAssertionResponse) { r.UserHandle = WebAuthnUserHandle(8) }, err: ErrInvalidWebAuthnResponse},
+		{name: "missing user handle", edit: func(_ *softAuthenticator, r *WebAuthnAssertionResponse) { r.UserHandle = "" }, err: ErrInvalidWebAuthnResponse},
+		{name: "bad signature", edit: func(_ *softAuthenticator, r *WebAuthnAssertionResponse) { r.Signature = "AAAA" }, err: ErrInvalidWebAuthnResponse},
+		{name: "user not verified", setup: func(a *softAuthenticator) { a.flags = authDataUserPresent }, err: ErrInvalidWebAuthnResponse},
+		{name: "wrong rp id", setup: func(a *softAuthenticator) { a.rpID = "evil.example" }, err: ErrInvalidWebAuthnResponse},
+		{name: "wrong origin", setup: func(a *softAuthenticator) { a.origin = "https://evil.example" }, err: ErrInvalidWebAuthnResponse},
+		// 計數回到已保存的值，表示 authenticator 可能遭複製
+		{name: "sign count not increased", setup: func(a *softAuthenticator) { a.signCount = 0 }, err: ErrInvalidWebAuthnResponse},
+		{name: "registration ceremony type", edit: func(a *softAuthenticator, r *WebAuthnAssertionResponse) {
+			r.ClientDataJSON = a.clientData("webauthn.create", "x")
+		}, err: ErrInvalidWebAuthnResponse},
+		{name: "unknown challenge", edit: func(a *softAuthenticator, r *WebAuthnAssertionResponse) {
+			r.ClientDataJSON = a.clientData("webauthn.get", "unknown")
+		}, err: ErrInvalidWebAuthnChallenge},
+		{name: "invalid credential id", edit: func(_ *softAuthenticator, r *WebAuthnAssertionResponse) { r.ID = "" }, err: ErrInvalidWebAuthnResponse},
+		{name: "invalid authenticator data", edit: func(_ *softAuthenticator, r *WebAuthnAssertionResponse) { r.AuthenticatorData = "AA" }, err: ErrInvalidWebAuthnResponse},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, c, authenticator := register(t)
+			// 先以一次成功登入使簽章計數大於 0
+			challenge, err := BeginWebAuthnLogin(ctx, c)
+			require.NoError(t, err)
+			_, err = FinishWebAuthnLogin(ctx, db.fake(), c, authenticator.get(challenge))
+			require.NoError(t, err)
+
+			if tc.setup != nil {
+				tc.setup(authenticator)
+			}
+			challenge, err = BeginWebAuthnLogin(ctx, c)
+			require.NoError(t, err)
+			assertion := authenticator.get(challenge)
+			if tc.edit != nil {
+				tc.edit(authenticator, &assertion)
+			}
+			_, err = FinishWebAuthnLogin(ctx, db.fake(), c, assertion)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	t.Run("zero sign count", func(t *testing.T) {
+		db, c, authenticator := register(t)
+		authenticator.noCounter = true
+		// 不支援計數的 authenticator 恆回傳 0，仍可重複登入
+		for range 2 {
+			challenge, err := BeginWebAuthnLogin(ctx, c)
+			require.NoError(t, err)
+			userID, err := FinishWebAuthnLogin(ctx, db.fake(), c, authenticator.get(challenge))
+			require.NoError(t, err)
+			require.Equal(t, 7, userID)
+		}
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		db, c, authenticator := register(t)
+		for _, failOn := range []string{"WHERE id = $1", "SET sign_count"} {
+			db.failOn = failOn
+			challenge, err := BeginWebAuthnLogin(ctx, c)
+			require.NoError(t, err)
+			_, err = FinishWebAuthnLogin(ctx, db.fake(), c, authenticator.get(challenge))
+			require.Error(t, err)
+			require.NotErrorIs(t, err, ErrInvalidWebAuthnResponse)
+		}
+	})
+
+	t.Run("concurrent use", func(t *testing.T) {
+		db, c, authenticator := register(t)
+		challenge, err := BeginWebAuthnLogin(ctx, c)
+		require.NoError(t, err)
+		assertion := authenticator.get(challenge)
+		// 驗證期間另一個登入已更新計數
+		fake := db.fake()
+		getRow := fake.QueryRowFn
+		fake.QueryRowFn = func(ctx context.Context, sql string, args ...any) pgx.Row {
+			row := getRow(ctx, sql, args...)
+			cred := db.creds[args[0].(string)]
+			cred.SignCount = 5
+			db.creds[cred.ID] = cred
+			return row
+		}
+		_, err = FinishWebAuthnLogin(ctx, fake, c, assertion)
+		require.ErrorIs(t, err, ErrInvalidWebAuthnResponse)
+	})
+}
+
+func TestParseCOSEKey(t *testing.T) {
+	for name, key := range map[string]map[any]any{
+		"unknown kty":     {int64(1): int64(4), int64(3): int64(coseAlgES256)},
+		"alg mismatch":    {int64(1): int64(2), int64(3): int64(coseAlgRS256), int64(-1): int64(1)},
+		"unsupported crv": {int64(1): int64(2), int64(3): int64(coseAlgES256), int64(-1): int64(2)},
+		"invalid point":   {int64(1): int64(2), int64(3): int64(coseAlgES256), int64(-1): int64(1), int64(-2): []byte{1}, int64(-3): []byte{2}},
+		"weak rsa":        {int64(1): int64(3), int64(3): int64(coseAlgRS256), int64(-1): []byte{0xff}, int64(-2): []byte{1, 0, 1}},
+	} {
+		_, _, err := parseCOSEKey(encodeCBOR(key))
+		require.ErrorIs(t, err, ErrInvalidWebAuthnResponse, name)
+	}
+	_, _, err := parseCOSEKey(append(encodeCBOR(map[any]any{}), 0x00))
+	require.ErrorIs(t, err, ErrInvalidWebAuthnResponse)
+
+	require.False(t, verifyWebAuthnSignature(0, nil, nil, nil))
+}
+
+func TestPasskeyAuthentication(t *testing.T) {
+	t.Cleanup(restoreGlobals)
+	authn := PasskeyAuthentication()
+	require.Equal(t, []string{AMRHardwareKey, AMRMultiFactor}, authn.AMR)
+	require.True(t, authn.MultiFactor())
+	adminMFARequired = true
+	require.True(t, AdminMFASatisfied(&CustomClaims{IsAdmin: true, Authentication: authn}))
+}
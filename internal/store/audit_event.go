@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+)
+
+func CreateAuditEvent(ctx context.Context, db database.DB, e *model.AuditEvent) error {
+	details := e.Details
+	if details == nil {
+		details = map[string]any{}
+	}
+	row := db.QueryRow(ctx,
+		`INSERT INTO audit_events (event_type, user_id, client_id, ip_address, details)
+         VALUES ($1, NULLIF($2, 0), $3, $4, $5)
+         RETURNING id, created_at`,
+		e.EventType,
+		e.UserID,
+		e.ClientID,
+		e.IPAddress,
+		details,
+	)
+	if err := row.Scan(
+		&e.ID,
+		&e.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("CreateAuditEvent: %w", err)
+	}
+	return nil
+}
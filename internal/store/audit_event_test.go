@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+/* ---------- 假實作 ---------- */
+
+// fakeAuditEventRow 實作 pgx.Row，模擬 CreateAuditEvent 的 RETURNING。
+type fakeAuditEventRow struct {
+	scanErr   error
+	id        int64
+	createdAt time.Time
+}
+
+func (r *fakeAuditEventRow) Scan(dest ...any) error {
+	if r.scanErr != nil {
+		return r.scanErr
+	}
+	*dest[0].(*int64) = r.id
+	*dest[1].(*time.Time) = r.createdAt
+	return nil
+}
+
+/* ---------- 完整測試 ---------- */
+
+func TestAuditEventRepository(t *testing.T) {
+	now := time.Now().UTC()
+
+	t.Run("Create ok", func(t *testing.T) {
+		var gotArgs []any
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+				gotArgs = args
+				return &fakeAuditEventRow{id: 7, createdAt: now}
+			},
+		}
+		e := model.AuditEvent{EventType: model.AuditEventRefreshTokenReuse, UserID: 1, ClientID: "cid", IPAddress: "1.2.3.4"}
+		require.NoError(t, CreateAuditEvent(context.Background(), p, &e))
+		require.Equal(t, int64(7), e.ID)
+		require.Equal(t, now, e.CreatedAt)
+		require.Equal(t, []any{model.AuditEventRefreshTokenReuse, 1, "cid", "1.2.3.4", map[string]any{}}, gotArgs)
+	})
+
+	t.Run("Create with details", func(t *testing.T) {
+		var gotDetails any
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+				gotDetails = args[4]
+				return &fakeAuditEventRow{id: 8, createdAt: now}
+			},
+		}
+		e := model.AuditEvent{EventType: "x", Details: map[string]any{"family_id": "f"}}
+		require.NoError(t, CreateAuditEvent(context.Background(), p, &e))
+		require.Equal(t, map[string]any{"family_id": "f"}, gotDetails)
+	})
+
+	t.Run("Create err", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
+				return &fakeAuditEventRow{scanErr: errors.New("db")}
+			},
+		}
+		require.Error(t, CreateAuditEvent(context.Background(), p, &model.AuditEvent{}))
+	})
+}
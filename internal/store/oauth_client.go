@@ -10,7 +10,15 @@ import (
 
 func GetOAuthClientByClientID(ctx context.Context, db database.DB, clientID string) (*model.OAuthClient, error) {
 	row := db.QueryRow(ctx,
-		`SELECT client_id, client_secret, user_id, grant_types, created_at, updated_at
+		`SELECT client_id, client_secret, COALESCE(user_id, 0), client_type, client_name, logo_uri,
+                grant_types, redirect_uris, scopes, created_at, updated_at,
+                previous_client_secret, previous_client_secret_expires_at,
+                token_endpoint_auth_method, jwks, client_secret_sealed,
+                tls_client_auth_subject_dn, tls_client_certificate_thumbprint,
+                tls_client_certificate_bound_access_tokens, registration_access_token,
+                require_pushed_authorization_requests, token_exchange_audiences,
+                access_token_ttl, refresh_token_ttl, refresh_token_absolute_ttl,
+                refresh_token_idle_timeout, id_token_ttl, access_token_audiences, first_party
          FROM oauth_clients
          WHERE client_id = $1`,
 		clientID,
@@ -20,9 +28,32 @@ func GetOAuthClientByClientID(ctx context.Context, db database.DB, clientID stri
 		&c.ClientID,
 		&c.ClientSecret,
 		&c.UserID,
+		&c.ClientType,
+		&c.ClientName,
+		&c.LogoURI,
 		&c.GrantTypes,
+		&c.RedirectURIs,
+		&c.Scopes,
 		&c.CreatedAt,
 		&c.UpdatedAt,
+		&c.PreviousClientSecret,
+		&c.PreviousClientSecretExpiresAt,
+		&c.TokenEndpointAuthMethod,
+		&c.JWKS,
+		&c.SealedClientSecret,
+		&c.TLSClientAuthSubjectDN,
+		&c.TLSClientCertificateThumbprint,
+		&c.TLSClientCertificateBoundAccessTokens,
+		&c.RegistrationAccessToken,
+		&c.RequirePushedAuthorizationRequests,
+		&c.TokenExchangeAudiences,
+		&c.AccessTokenTTL,
+		&c.RefreshTokenTTL,
+		&c.RefreshTokenAbsoluteTTL,
+		&c.RefreshTokenIdleTimeout,
+		&c.IDTokenTTL,
+		&c.AccessTokenAudiences,
+		&c.FirstParty,
 	); err != nil {
 		return nil, fmt.Errorf("GetOAuthClientByClientID: %w", err)
 	}
@@ -31,13 +62,42 @@ func GetOAuthClientByClientID(ctx context.Context, db database.DB, clientID stri
 
 func CreateOAuthClient(ctx context.Context, db database.DB, c *model.OAuthClient) error {
 	row := db.QueryRow(ctx,
-		`INSERT INTO oauth_clients (client_id, client_secret, user_id, grant_types)
-         VALUES ($1, $2, $3, $4)
+		`INSERT INTO oauth_clients (client_id, client_secret, user_id, client_type, client_name, logo_uri,
+                                    grant_types, redirect_uris, scopes,
+                                    token_endpoint_auth_method, jwks, client_secret_sealed,
+                                    tls_client_auth_subject_dn, tls_client_certificate_thumbprint,
+                                    tls_client_certificate_bound_access_tokens, registration_access_token,
+                                    require_pushed_authorization_requests, token_exchange_audiences,
+                                    access_token_ttl, refresh_token_ttl, refresh_token_absolute_ttl,
+                                    refresh_token_idle_timeout, id_token_ttl, access_token_audiences, first_party)
+         VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18,
+                 $19, $20, $21, $22, $23, $24, $25)
          RETURNING client_id, created_at, updated_at`,
 		c.ClientID,
 		c.ClientSecret,
 		c.UserID,
+		c.ClientType,
+		c.ClientName,
+		c.LogoURI,
 		c.GrantTypes,
+		c.RedirectURIs,
+		c.Scopes,
+		c.TokenEndpointAuthMethod,
+		c.JWKS,
+		c.SealedClientSecret,
+		c.TLSClientAuthSubjectDN,
+		c.TLSClientCertificateThumbprint,
+		c.TLSClientCertificateBoundAccessTokens,
+		c.RegistrationAccessToken,
+		c.RequirePushedAuthorizationRequests,
+		c.TokenExchangeAudiences,
+		c.AccessTokenTTL,
+		c.RefreshTokenTTL,
+		c.RefreshTokenAbsoluteTTL,
+		c.RefreshTokenIdleTimeout,
+		c.IDTokenTTL,
+		c.AccessTokenAudiences,
+		c.FirstParty,
 	)
 	if err := row.Scan(
 		&c.ClientID,
@@ -49,15 +109,42 @@ func CreateOAuthClient(ctx context.Context, db database.DB, c *model.OAuthClient
 	return nil
 }
 
+// UpdateOAuthClient 更新 client metadata；secret 僅能透過 UpdateOAuthClientSecret 變更
 func UpdateOAuthClient(ctx context.Context, db database.DB, c *model.OAuthClient) error {
 	row := db.QueryRow(ctx,
 		`UPDATE oauth_clients
-         SET client_secret = $1, owner_id = $2, grant_types = $3, updated_at = now()
-         WHERE client_id = $4
+         SET user_id = NULLIF($1, 0), client_type = $2, client_name = $3, logo_uri = $4,
+             grant_types = $5, redirect_uris = $6, scopes = $7,
+             token_endpoint_auth_method = $8, jwks = $9,
+             tls_client_auth_subject_dn = $10, tls_client_certificate_thumbprint = $11,
+             tls_client_certificate_bound_access_tokens = $12,
+             require_pushed_authorization_requests = $13, token_exchange_audiences = $14,
+             access_token_ttl = $15, refresh_token_ttl = $16, refresh_token_absolute_ttl = $17,
+             refresh_token_idle_timeout = $18, id_token_ttl = $19, access_token_audiences = $20,
+             first_party = $21, updated_at = now()
+         WHERE client_id = $22
          RETURNING updated_at`,
-		c.ClientSecret,
 		c.UserID,
+		c.ClientType,
+		c.ClientName,
+		c.LogoURI,
 		c.GrantTypes,
+		c.RedirectURIs,
+		c.Scopes,
+		c.TokenEndpointAuthMethod,
+		c.JWKS,
+		c.TLSClientAuthSubjectDN,
+		c.TLSClientCertificateThumbprint,
+		c.TLSClientCertificateBoundAccessTokens,
+		c.RequirePushedAuthorizationRequests,
+		c.TokenExchangeAudiences,
+		c.AccessTokenTTL,
+		c.RefreshTokenTTL,
+		c.RefreshTokenAbsoluteTTL,
+		c.RefreshTokenIdleTimeout,
+		c.IDTokenTTL,
+		c.AccessTokenAudiences,
+		c.FirstParty,
 		c.ClientID,
 	)
 	if err := row.Scan(
@@ -68,6 +155,28 @@ func UpdateOAuthClient(ctx context.Context, db database.DB, c *model.OAuthClient
 	return nil
 }
 
+// UpdateOAuthClientSecret 寫入輪替後的 secret 雜湊、加密的 secret 與寬限期內仍有效的舊 secret
+func UpdateOAuthClientSecret(ctx context.Context, db database.DB, c *model.OAuthClient) error {
+	row := db.QueryRow(ctx,
+		`UPDATE oauth_clients
+         SET client_secret = $1, previous_client_secret = $2, previous_client_secret_expires_at = $3,
+             client_secret_sealed = $4, updated_at = now()
+         WHERE client_id = $5
+         RETURNING updated_at`,
+		c.ClientSecret,
+		c.PreviousClientSecret,
+		c.PreviousClientSecretExpiresAt,
+		c.SealedClientSecret,
+		c.ClientID,
+	)
+	if err := row.Scan(
+		&c.UpdatedAt,
+	); err != nil {
+		return fmt.Errorf("UpdateOAuthClientSecret: %w", err)
+	}
+	return nil
+}
+
 func DeleteOAuthClient(ctx context.Context, db database.DB, clientID string) error {
 	_, err := db.Exec(ctx,
 		`DELETE FROM oauth_clients WHERE client_id = $1`,
@@ -81,7 +190,15 @@ func DeleteOAuthClient(ctx context.Context, db database.DB, clientID string) err
 
 func ListOAuthClients(ctx context.Context, db database.DB, userID int) ([]model.OAuthClient, error) {
 	rows, err := db.Query(ctx,
-		`SELECT client_id, client_secret, owner_id, grant_types, created_at, updated_at
+		`SELECT client_id, client_secret, COALESCE(user_id, 0), client_type, client_name, logo_uri,
+                grant_types, redirect_uris, scopes, created_at, updated_at,
+                previous_client_secret, previous_client_secret_expires_at,
+                token_endpoint_auth_method, jwks, client_secret_sealed,
+                tls_client_auth_subject_dn, tls_client_certificate_thumbprint,
+                tls_client_certificate_bound_access_tokens, registration_access_token,
+                require_pushed_authorization_requests, token_exchange_audiences,
+                access_token_ttl, refresh_token_ttl, refresh_token_absolute_ttl,
+                refresh_token_idle_timeout, id_token_ttl, access_token_audiences, first_party
          FROM oauth_clients
 		 WHERE user_id = $1`,
 		userID,
@@ -97,9 +214,33 @@ func ListOAuthClients(ctx context.Context, db database.DB, userID int) ([]model.
 			&c.ClientID,
 			&c.ClientSecret,
 			&c.UserID,
+			&c.ClientType,
+			&c.ClientName,
+			&c.LogoURI,
 			&c.GrantTypes,
+			&c.RedirectURIs,
+			&c.Scopes,
 			&c.CreatedAt,
 			&c.UpdatedAt,
+			&c.PreviousClientSecret,
+			&c.PreviousClientSecretExpiresAt,
+			&c.TokenEndpointAuthMethod,
+			&c.JWKS,
+			&c.SealedClientSecret,
+			&c.TLSClientAuthSubjectDN,
+			&c.TLSClientCertificateThumbprint,
+			&c.TLSClientCertificateBoundAccessTokens,
+			&c.RegistrationAccessToken,
+			&c.RequirePushedAuthorizationRequests,
+			&c.TokenExchangeAudiences,
+			&c.AccessTokenTTL,
+			&c.RefreshTokenTTL,
+			&c.RefreshTokenAbsoluteTTL,
+			&c.RefreshTokenIdleTimeout,
+			&c.IDTokenTTL,
+			&c.AccessTokenAudiences,
+			&c.FirstParty,
+			&c.FirstParty,
 		); err != nil {
 			return nil, fmt.Errorf("scan OAuthClient: %w", err)
 		}
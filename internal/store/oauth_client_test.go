@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"testing"
 	"time"
@@ -28,21 +29,52 @@ func (r *fakeRow) Scan(dest ...any) error {
 	}
 	c := r.client
 	switch len(dest) {
-	case 6:
-		// GetOAuthClientByClientID: client_id, client_secret, user_id, grant_types, created_at, updated_at
+	case 29:
+		// GetOAuthClientByClientID: client_id, client_secret, user_id, client_type, client_name, logo_uri,
+		// grant_types, redirect_uris, scopes, created_at, updated_at,
+		// previous_client_secret, previous_client_secret_expires_at,
+		// token_endpoint_auth_method, jwks, client_secret_sealed,
+		// tls_client_auth_subject_dn, tls_client_certificate_thumbprint,
+		// tls_client_certificate_bound_access_tokens, registration_access_token,
+		// require_pushed_authorization_requests, token_exchange_audiences,
+		// access_token_ttl, refresh_token_ttl, refresh_token_absolute_ttl,
+		// refresh_token_idle_timeout, id_token_ttl, access_token_audiences, first_party
 		*dest[0].(*string) = c.ClientID
 		*dest[1].(*string) = c.ClientSecret
 		*dest[2].(*int) = c.UserID
-		*dest[3].(*[]string) = c.GrantTypes
-		*dest[4].(*time.Time) = c.CreatedAt
-		*dest[5].(*time.Time) = c.UpdatedAt
+		*dest[3].(*string) = c.ClientType
+		*dest[4].(*string) = c.ClientName
+		*dest[5].(*string) = c.LogoURI
+		*dest[6].(*[]string) = c.GrantTypes
+		*dest[7].(*[]string) = c.RedirectURIs
+		*dest[8].(*[]string) = c.Scopes
+		*dest[9].(*time.Time) = c.CreatedAt
+		*dest[10].(*time.Time) = c.UpdatedAt
+		*dest[11].(*string) = c.PreviousClientSecret
+		*dest[12].(**time.Time) = c.PreviousClientSecretExpiresAt
+		*dest[13].(*string) = c.TokenEndpointAuthMethod
+		*dest[14].(*json.RawMessage) = c.JWKS
+		*dest[15].(*[]byte) = c.SealedClientSecret
+		*dest[16].(*string) = c.TLSClientAuthSubjectDN
+		*dest[17].(*string) = c.TLSClientCertificateThumbprint
+		*dest[18].(*bool) = c.TLSClientCertificateBoundAccessTokens
+		*dest[19].(*string) = c.RegistrationAccessToken
+		*dest[20].(*bool) = c.RequirePushedAuthorizationRequests
+		*dest[21].(*[]string) = c.TokenExchangeAudiences
+		*dest[22].(*int) = c.AccessTokenTTL
+		*dest[23].(*int) = c.RefreshTokenTTL
+		*dest[24].(*int) = c.RefreshTokenAbsoluteTTL
+		*dest[25].(*int) = c.RefreshTokenIdleTimeout
+		*dest[26].(*int) = c.IDTokenTTL
+		*dest[27].(*[]string) = c.AccessTokenAudiences
+		*dest[28].(*bool) = c.FirstParty
 	case 3:
 		// CreateOAuthClient: client_id, created_at, updated_at
 		*dest[0].(*string) = c.ClientID
 		*dest[1].(*time.Time) = c.CreatedAt
 		*dest[2].(*time.Time) = c.UpdatedAt
 	case 1:
-		// UpdateOAuthClient: updated_at
+		// UpdateOAuthClient, UpdateOAuthClientSecret: updated_at
 		*dest[0].(*time.Time) = c.UpdatedAt
 	default:
 		panic("fakeRow.Scan: unexpected number of dest")
@@ -72,9 +104,32 @@ func (r *fakeRows) Scan(dest ...any) error {
 	*dest[0].(*string) = c.ClientID
 	*dest[1].(*string) = c.ClientSecret
 	*dest[2].(*int) = c.UserID
-	*dest[3].(*[]string) = c.GrantTypes
-	*dest[4].(*time.Time) = c.CreatedAt
-	*dest[5].(*time.Time) = c.UpdatedAt
+	*dest[3].(*string) = c.ClientType
+	*dest[4].(*string) = c.ClientName
+	*dest[5].(*string) = c.LogoURI
+	*dest[6].(*[]string) = c.GrantTypes
+	*dest[7].(*[]string) = c.RedirectURIs
+	*dest[8].(*[]string) = c.Scopes
+	*dest[9].(*time.Time) = c.CreatedAt
+	*dest[10].(*time.Time) = c.UpdatedAt
+	*dest[11].(*string) = c.PreviousClientSecret
+	*dest[12].(**time.Time) = c.PreviousClientSecretExpiresAt
+	*dest[13].(*string) = c.TokenEndpointAuthMethod
+	*dest[14].(*json.RawMessage) = c.JWKS
+	*dest[15].(*[]byte) = c.SealedClientSecret
+	*dest[16].(*string) = c.TLSClientAuthSubjectDN
+	*dest[17].(*string) = c.TLSClientCertificateThumbprint
+	*dest[18].(*bool) = c.TLSClientCertificateBoundAccessTokens
+	*dest[19].(*string) = c.RegistrationAccessToken
+	*dest[20].(*bool) = c.RequirePushedAuthorizationRequests
+	*dest[21].(*[]string) = c.TokenExchangeAudiences
+	*dest[22].(*int) = c.AccessTokenTTL
+	*dest[23].(*int) = c.RefreshTokenTTL
+	*dest[24].(*int) = c.RefreshTokenAbsoluteTTL
+	*dest[25].(*int) = c.RefreshTokenIdleTimeout
+	*dest[26].(*int) = c.IDTokenTTL
+	*dest[27].(*[]string) = c.AccessTokenAudiences
+	*dest[28].(*bool) = c.FirstParty
 	return nil
 }
 func (r *fakeRows) Values() ([]any, error) { return nil, nil }
@@ -85,13 +140,19 @@ func (r *fakeRows) Conn() *pgx.Conn        { return nil }
 
 func TestOAuthClientRepository(t *testing.T) {
 	now := time.Now().UTC()
+	grace := now.Add(time.Hour)
 	sample := model.OAuthClient{
-		ClientID:     "cid",
-		ClientSecret: "sec",
-		UserID:       1,
-		GrantTypes:   []string{"password"},
-		CreatedAt:    now,
-		UpdatedAt:    now,
+		ClientID:                      "cid",
+		ClientSecret:                  "hash",
+		UserID:                        1,
+		GrantTypes:                    []string{"password"},
+		CreatedAt:                     now,
+		UpdatedAt:                     now,
+		PreviousClientSecret:          "old-hash",
+		PreviousClientSecretExpiresAt: &grace,
+		TokenEndpointAuthMethod:       model.ClientAuthMethodSecretJWT,
+		JWKS:                          json.RawMessage(`{"keys":[]}`),
+		SealedClientSecret:            []byte("sealed"),
 	}
 
 	/* GetOAuthClientByClientID */
@@ -103,7 +164,7 @@ func TestOAuthClientRepository(t *testing.T) {
 		}
 		got, err := GetOAuthClientByClientID(context.Background(), p, "cid")
 		require.NoError(t, err)
-		require.Equal(t, sample.ClientID, got.ClientID)
+		require.Equal(t, sample, *got)
 	})
 
 	t.Run("Get err", func(t *testing.T) {
@@ -158,6 +219,29 @@ func TestOAuthClientRepository(t *testing.T) {
 		require.Error(t, UpdateOAuthClient(context.Background(), p, &sample))
 	})
 
+	/* UpdateOAuthClientSecret */
+	t.Run("UpdateSecret ok", func(t *testing.T) {
+		var gotArgs []any
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+				gotArgs = args
+				return &fakeRow{client: &sample}
+			},
+		}
+		c := sample
+		require.NoError(t, UpdateOAuthClientSecret(context.Background(), p, &c))
+		require.Equal(t, []any{"hash", "old-hash", &grace, []byte("sealed"), "cid"}, gotArgs)
+	})
+
+	t.Run("UpdateSecret err", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
+				return &fakeRow{scanErr: errors.New("fail update")}
+			},
+		}
+		require.Error(t, UpdateOAuthClientSecret(context.Background(), p, &sample))
+	})
+
 	/* DeleteOAuthClient */
 	t.Run("Delete ok", func(t *testing.T) {
 		p := &database.FakeDB{
@@ -187,7 +271,7 @@ func TestOAuthClientRepository(t *testing.T) {
 		}
 		list, err := ListOAuthClients(context.Background(), p, 1)
 		require.NoError(t, err)
-		require.Len(t, list, 2)
+		require.Equal(t, []model.OAuthClient{sample, sample}, list)
 	})
 
 	t.Run("List query err", func(t *testing.T) {
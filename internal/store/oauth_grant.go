@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+)
+
+func GetOAuthGrant(ctx context.Context, db database.DB, userID int, clientID string) (*model.OAuthGrant, error) {
+	row := db.QueryRow(ctx,
+		`SELECT user_id, client_id, scopes, granted_at
+         FROM oauth_grants
+         WHERE user_id = $1 AND client_id = $2`,
+		userID,
+		clientID,
+	)
+	var g model.OAuthGrant
+	if err := row.Scan(
+		&g.UserID,
+		&g.ClientID,
+		&g.Scopes,
+		&g.GrantedAt,
+	); err != nil {
+		return nil, fmt.Errorf("GetOAuthGrant: %w", err)
+	}
+	return &g, nil
+}
+
+// SaveOAuthGrant 新增或取代使用者對 client 的同意紀錄，granted_at 更新為此次同意的時間
+func SaveOAuthGrant(ctx context.Context, db database.DB, g *model.OAuthGrant) error {
+	row := db.QueryRow(ctx,
+		`INSERT INTO oauth_grants (user_id, client_id, scopes)
+         VALUES ($1, $2, $3)
+         ON CONFLICT (user_id, client_id)
+         DO UPDATE SET scopes = EXCLUDED.scopes, granted_at = now()
+         RETURNING granted_at`,
+		g.UserID,
+		g.ClientID,
+		g.Scopes,
+	)
+	if err := row.Scan(
+		&g.GrantedAt,
+	); err != nil {
+		return fmt.Errorf("SaveOAuthGrant: %w", err)
+	}
+	return nil
+}
+
+func ListOAuthGrants(ctx context.Context, db database.DB, userID int) ([]model.OAuthGrant, error) {
+	rows, err := db.Query(ctx,
+		`SELECT g.user_id, g.client_id, g.scopes, g.granted_at, c.client_name, c.logo_uri
+         FROM oauth_grants g
+         JOIN oauth_clients c ON c.client_id = g.client_id
+         WHERE g.user_id = $1
+         ORDER BY g.granted_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ListOAuthGrants: %w", err)
+	}
+	defer rows.Close()
+	var grants []model.OAuthGrant
+	for rows.Next() {
+		var g model.OAuthGrant
+		if err := rows.Scan(
+			&g.UserID,
+			&g.ClientID,
+			&g.Scopes,
+			&g.GrantedAt,
+			&g.ClientName,
+			&g.LogoURI,
+		); err != nil {
+			return nil, fmt.Errorf("scan OAuthGrant: %w", err)
+		}
+		grants = append(grants, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return grants, nil
+}
+
+// DeleteOAuthGrant 刪除同意紀錄，回傳是否有紀錄被刪除
+func DeleteOAuthGrant(ctx context.Context, db database.DB, userID int, clientID string) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`DELETE FROM oauth_grants WHERE user_id = $1 AND client_id = $2`,
+		userID,
+		clientID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("DeleteOAuthGrant: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+/* ---------- 假實作 ---------- */
+
+// fakeGrantRow 實作 pgx.Row，模擬 GetOAuthGrant 與 SaveOAuthGrant 的掃描。
+type fakeGrantRow struct {
+	scanErr error
+	grant   *model.OAuthGrant
+}
+
+func (r *fakeGrantRow) Scan(dest ...any) error {
+	if r.scanErr != nil {
+		return r.scanErr
+	}
+	g := r.grant
+	switch len(dest) {
+	case 4:
+		// GetOAuthGrant: user_id, client_id, scopes, granted_at
+		*dest[0].(*int) = g.UserID
+		*dest[1].(*string) = g.ClientID
+		*dest[2].(*[]string) = g.Scopes
+		*dest[3].(*time.Time) = g.GrantedAt
+	case 1:
+		// SaveOAuthGrant: granted_at
+		*dest[0].(*time.Time) = g.GrantedAt
+	default:
+		panic("fakeGrantRow.Scan: unexpected number of dest")
+	}
+	return nil
+}
+
+// fakeGrantRows 實作 pgx.Rows，模擬 ListOAuthGrants 的多筆掃描。
+type fakeGrantRows struct {
+	fakeRows
+	grants []model.OAuthGrant
+}
+
+func (r *fakeGrantRows) Next() bool { return r.idx < len(r.grants) }
+func (r *fakeGrantRows) Scan(dest ...any) error {
+	if r.scanErr != nil {
+		return r.scanErr
+	}
+	g := r.grants[r.idx]
+	r.idx++
+	*dest[0].(*int) = g.UserID
+	*dest[1].(*string) = g.ClientID
+	*dest[2].(*[]string) = g.Scopes
+	*dest[3].(*time.Time) = g.GrantedAt
+	*dest[4].(*string) = g.ClientName
+	*dest[5].(*string) = g.LogoURI
+	return nil
+}
+
+/* ---------- 完整測試 ---------- */
+
+func TestOAuthGrantRepository(t *testing.T) {
+	now := time.Now().UTC()
+	sample := model.OAuthGrant{UserID: 1, ClientID: "cid", Scopes: []string{"openid", "profile"}, GrantedAt: now}
+
+	/* GetOAuthGrant */
+	t.Run("Get ok", func(t *testing.T) {
+		var gotArgs []any
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+				gotArgs = args
+				return &fakeGrantRow{grant: &sample}
+			},
+		}
+		g, err := GetOAuthGrant(context.Background(), p, 1, "cid")
+		require.NoError(t, err)
+		require.Equal(t, sample, *g)
+		require.Equal(t, []any{1, "cid"}, gotArgs)
+	})
+
+	t.Run("Get not found", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
+				return &fakeGrantRow{scanErr: pgx.ErrNoRows}
+			},
+		}
+		_, err := GetOAuthGrant(context.Background(), p, 1, "cid")
+		require.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	/* SaveOAuthGrant */
+	t.Run("Save ok", func(t *testing.T) {
+		var gotArgs []any
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+				gotArgs = args
+				return &fakeGrantRow{grant: &sample}
+			},
+		}
+		g := model.OAuthGrant{UserID: 1, ClientID: "cid", Scopes: []string{"openid"}}
+		require.NoError(t, SaveOAuthGrant(context.Background(), p, &g))
+		require.Equal(t, now, g.GrantedAt)
+		require.Equal(t, []any{1, "cid", []string{"openid"}}, gotArgs)
+	})
+
+	t.Run("Save err", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
+				return &fakeGrantRow{scanErr: errors.New("fk")}
+			},
+		}
+		require.Error(t, SaveOAuthGrant(context.Background(), p, &model.OAuthGrant{}))
+	})
+
+	/* ListOAuthGrants */
+	listed := sample
+	listed.ClientName = "My App"
+	listed.LogoURI = "https://app/logo.png"
+	t.Run("List ok", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryFn: func(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+				return &fakeGrantRows{grants: []model.OAuthGrant{listed, listed}}, nil
+			},
+		}
+		grants, err := ListOAuthGrants(context.Background(), p, 1)
+		require.NoError(t, err)
+		require.Equal(t, []model.OAuthGrant{listed, listed}, grants)
+	})
+
+	t.Run("List query err", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryFn: func(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+				return nil, errors.New("database fail")
+			},
+		}
+		_, err := ListOAuthGrants(context.Background(), p, 1)
+		require.Error(t, err)
+	})
+
+	t.Run("List scan err", func(t *testing.T) {
+		rows := &fakeGrantRows{grants: []model.OAuthGrant{sample}}
+		rows.scanErr = errors.New("scan fail")
+		p := &database.FakeDB{
+			QueryFn: func(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+				return rows, nil
+			},
+		}
+		_, err := ListOAuthGrants(context.Background(), p, 1)
+		require.Error(t, err)
+	})
+
+	t.Run("List rows err", func(t *testing.T) {
+		rows := &fakeGrantRows{}
+		rows.err = errors.New("iteration error")
+		p := &database.FakeDB{
+			QueryFn: func(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+				return rows, nil
+			},
+		}
+		_, err := ListOAuthGrants(context.Background(), p, 1)
+		require.Error(t, err)
+	})
+
+	/* DeleteOAuthGrant */
+	t.Run("Delete ok", func(t *testing.T) {
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+				return pgconn.NewCommandTag("DELETE 1"), nil
+			},
+		}
+		deleted, err := DeleteOAuthGrant(context.Background(), p, 1, "cid")
+		require.NoError(t, err)
+		require.True(t, deleted)
+	})
+
+	t.Run("Delete missing", func(t *testing.T) {
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+				return pgconn.NewCommandTag("DELETE 0"), nil
+			},
+		}
+		deleted, err := DeleteOAuthGrant(context.Background(), p, 1, "cid")
+		require.NoError(t, err)
+		require.False(t, deleted)
+	})
+
+	t.Run("Delete err", func(t *testing.T) {
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+				return pgconn.CommandTag{}, errors.New("fail")
+			},
+		}
+		_, err := DeleteOAuthGrant(context.Background(), p, 1, "cid")
+		require.Error(t, err)
+	})
+}
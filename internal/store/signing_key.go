@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+)
+
+// ListSigningKeys 回傳仍可用於驗證的金鑰：next、active，以及尚未到期的 retired 金鑰
+func ListSigningKeys(ctx context.Context, db database.DB) ([]model.SigningKey, error) {
+	rows, err := db.Query(ctx,
+		`SELECT kid, algorithm, status, private_key, public_key, expires_at, created_at, updated_at
+         FROM signing_keys
+         WHERE status <> 'retired' OR expires_at > now()
+         ORDER BY created_at`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ListSigningKeys: %w", err)
+	}
+	defer rows.Close()
+	var keys []model.SigningKey
+	for rows.Next() {
+		var k model.SigningKey
+		if err := rows.Scan(
+			&k.KID,
+			&k.Algorithm,
+			&k.Status,
+			&k.PrivateKey,
+			&k.PublicKey,
+			&k.ExpiresAt,
+			&k.CreatedAt,
+			&k.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan SigningKey: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return keys, nil
+}
+
+func CreateSigningKey(ctx context.Context, db database.DB, k *model.SigningKey) error {
+	row := db.QueryRow(ctx,
+		`INSERT INTO signing_keys (kid, algorithm, status, private_key, public_key)
+         VALUES ($1, $2, $3, $4, $5)
+         RETURNING created_at, updated_at`,
+		k.KID,
+		k.Algorithm,
+		k.Status,
+		k.PrivateKey,
+		k.PublicKey,
+	)
+	if err := row.Scan(
+		&k.CreatedAt,
+		&k.UpdatedAt,
+	); err != nil {
+		return fmt.Errorf("CreateSigningKey: %w", err)
+	}
+	return nil
+}
+
+// RotateSigningKeys 將 next 升為 active、原 active 改為 retired 並保留驗證至 expiresAt；
+// 沒有 next 金鑰時不做任何變更並回傳 false
+func RotateSigningKeys(ctx context.Context, db database.DB, expiresAt time.Time) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`UPDATE signing_keys
+         SET status = CASE status WHEN 'active' THEN 'retired' ELSE 'active' END,
+             expires_at = CASE status WHEN 'active' THEN $1 ELSE expires_at END,
+             updated_at = now()
+         WHERE status IN ('next', 'active')
+           AND EXISTS (SELECT 1 FROM signing_keys WHERE status = 'next')`,
+		expiresAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("RotateSigningKeys: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
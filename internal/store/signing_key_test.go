@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+/* ---------- 假實作 ---------- */
+
+// fakeSigningKeyRow 實作 pgx.Row，模擬 CreateSigningKey 的 RETURNING。
+type fakeSigningKeyRow struct {
+	scanErr error
+	key     *model.SigningKey
+}
+
+func (r *fakeSigningKeyRow) Scan(dest ...any) error {
+	if r.scanErr != nil {
+		return r.scanErr
+	}
+	*dest[0].(*time.Time) = r.key.CreatedAt
+	*dest[1].(*time.Time) = r.key.UpdatedAt
+	return nil
+}
+
+// fakeSigningKeyRows 實作 pgx.Rows，模擬 ListSigningKeys 的多筆掃描。
+type fakeSigningKeyRows struct {
+	fakeRows
+	keys []model.SigningKey
+}
+
+func (r *fakeSigningKeyRows) Next() bool { return r.idx < len(r.keys) }
+func (r *fakeSigningKeyRows) Scan(dest ...any) error {
+	if r.scanErr != nil {
+		return r.scanErr
+	}
+	k := r.keys[r.idx]
+	r.idx++
+	*dest[0].(*string) = k.KID
+	*dest[1].(*string) = k.Algorithm
+	*dest[2].(*string) = k.Status
+	*dest[3].(*[]byte) = k.PrivateKey
+	*dest[4].(*[]byte) = k.PublicKey
+	*dest[5].(**time.Time) = k.ExpiresAt
+	*dest[6].(*time.Time) = k.CreatedAt
+	*dest[7].(*time.Time) = k.UpdatedAt
+	return nil
+}
+
+/* ---------- 完整測試 ---------- */
+
+func TestSigningKeyRepository(t *testing.T) {
+	now := time.Now().UTC()
+	sample := model.SigningKey{
+		KID:        "kid",
+		Algorithm:  "ES256",
+		Status:     model.SigningKeyStatusActive,
+		PrivateKey: []byte("enc"),
+		PublicKey:  []byte("pub"),
+		ExpiresAt:  &now,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	/* ListSigningKeys */
+	t.Run("List ok", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryFn: func(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+				return &fakeSigningKeyRows{keys: []model.SigningKey{sample, sample}}, nil
+			},
+		}
+		keys, err := ListSigningKeys(context.Background(), p)
+		require.NoError(t, err)
+		require.Equal(t, []model.SigningKey{sample, sample}, keys)
+	})
+
+	t.Run("List query err", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryFn: func(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+				return nil, errors.New("database fail")
+			},
+		}
+		_, err := ListSigningKeys(context.Background(), p)
+		require.Error(t, err)
+	})
+
+	t.Run("List scan err", func(t *testing.T) {
+		rows := &fakeSigningKeyRows{keys: []model.SigningKey{sample}}
+		rows.scanErr = errors.New("scan fail")
+		p := &database.FakeDB{
+			QueryFn: func(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+				return rows, nil
+			},
+		}
+		_, err := ListSigningKeys(context.Background(), p)
+		require.Error(t, err)
+	})
+
+	t.Run("List rows err", func(t *testing.T) {
+		rows := &fakeSigningKeyRows{}
+		rows.err = errors.New("iteration error")
+		p := &database.FakeDB{
+			QueryFn: func(_ context.Context, _ string, _ ...any) (pgx.Rows, error) {
+				return rows, nil
+			},
+		}
+		_, err := ListSigningKeys(context.Background(), p)
+		require.Error(t, err)
+	})
+
+	/* CreateSigningKey */
+	t.Run("Create ok", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
+				return &fakeSigningKeyRow{key: &sample}
+			},
+		}
+		k := model.SigningKey{KID: "new"}
+		require.NoError(t, CreateSigningKey(context.Background(), p, &k))
+		require.Equal(t, now, k.CreatedAt)
+	})
+
+	t.Run("Create err", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
+				return &fakeSigningKeyRow{scanErr: errors.New("dup")}
+			},
+		}
+		require.Error(t, CreateSigningKey(context.Background(), p, &model.SigningKey{}))
+	})
+
+	/* RotateSigningKeys */
+	t.Run("Rotate ok", func(t *testing.T) {
+		var gotArgs []any
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+				gotArgs = args
+				return pgconn.NewCommandTag("UPDATE 2"), nil
+			},
+		}
+		rotated, err := RotateSigningKeys(context.Background(), p, now)
+		require.NoError(t, err)
+		require.True(t, rotated)
+		require.Equal(t, []any{now}, gotArgs)
+	})
+
+	t.Run("Rotate without next", func(t *testing.T) {
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+				return pgconn.NewCommandTag("UPDATE 0"), nil
+			},
+		}
+		rotated, err := RotateSigningKeys(context.Background(), p, now)
+		require.NoError(t, err)
+		require.False(t, rotated)
+	})
+
+	t.Run("Rotate err", func(t *testing.T) {
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+				return pgconn.CommandTag{}, errors.New("fail")
+			},
+		}
+		_, err := RotateSigningKeys(context.Background(), p, now)
+		require.Error(t, err)
+	})
+}
@@ -3,6 +3,8 @@ package store
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"life-is-hard/internal/database"
 	"life-is-hard/internal/model"
@@ -10,7 +12,7 @@ import (
 
 func GetUserByID(ctx context.Context, db database.DB, userID int) (*model.User, error) {
 	row := db.QueryRow(ctx,
-		`SELECT id, name, email, password_hash, created_at, is_admin
+		`SELECT id, name, email, password_hash, created_at, is_admin, email_verified
 		 FROM users WHERE id = $1`,
 		userID,
 	)
@@ -22,6 +24,7 @@ func GetUserByID(ctx context.Context, db database.DB, userID int) (*model.User,
 		&u.PasswordHash,
 		&u.CreatedAt,
 		&u.IsAdmin,
+		&u.EmailVerified,
 	); err != nil {
 		return nil, fmt.Errorf("GetUserByID: %w", err)
 	}
@@ -30,7 +33,7 @@ func GetUserByID(ctx context.Context, db database.DB, userID int) (*model.User,
 
 func GetUserByName(ctx context.Context, db database.DB, userName string) (*model.User, error) {
 	row := db.QueryRow(ctx,
-		`SELECT id, name, email, password_hash, created_at, is_admin
+		`SELECT id, name, email, password_hash, created_at, is_admin, email_verified
 		 FROM users WHERE name = $1`,
 		userName,
 	)
@@ -42,12 +45,34 @@ func GetUserByName(ctx context.Context, db database.DB, userName string) (*model
 		&u.PasswordHash,
 		&u.CreatedAt,
 		&u.IsAdmin,
+		&u.EmailVerified,
 	); err != nil {
 		return nil, fmt.Errorf("GetUserByName: %w", err)
 	}
 	return u, nil
 }
 
+func GetUserByEmail(ctx context.Context, db database.DB, email string) (*model.User, error) {
+	row := db.QueryRow(ctx,
+		`SELECT id, name, email, password_hash, created_at, is_admin, email_verified
+		 FROM users WHERE email = $1`,
+		email,
+	)
+	u := &model.User{}
+	if err := row.Scan(
+		&u.ID,
+		&u.Name,
+		&u.Email,
+		&u.PasswordHash,
+		&u.CreatedAt,
+		&u.IsAdmin,
+		&u.EmailVerified,
+	); err != nil {
+		return nil, fmt.Errorf("GetUserByEmail: %w", err)
+	}
+	return u, nil
+}
+
 func CreateUser(ctx context.Context, db database.DB, u *model.User) (*model.User, error) {
 	row := db.QueryRow(ctx,
 		`INSERT INTO users (name, email, password_hash, is_admin)
@@ -64,9 +89,11 @@ func CreateUser(ctx context.Context, db database.DB, u *model.User) (*model.User
 	return u, nil
 }
 
+// UpdateUser 更新使用者資料；Email 變更時重設為未驗證
 func UpdateUser(ctx context.Context, db database.DB, u *model.User) error {
 	_, err := db.Exec(ctx,
-		`UPDATE users SET name = $1, email = $2, is_admin = $3
+		`UPDATE users SET name = $1, email = $2, is_admin = $3,
+		        email_verified = email_verified AND email = $2
 		 WHERE id = $4`,
 		u.Name,
 		u.Email,
@@ -93,6 +120,20 @@ func UpdateUserPassword(ctx context.Context, db database.DB, userID int, passwor
 	return nil
 }
 
+// VerifyUserEmail 將使用者標記為已驗證；Email 已變更時不更新並回傳 false
+func VerifyUserEmail(ctx context.Context, db database.DB, userID int, email string) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`UPDATE users SET email_verified = TRUE
+		 WHERE id = $1 AND email = $2`,
+		userID,
+		email,
+	)
+	if err != nil {
+		return false, fmt.Errorf("VerifyUserEmail: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
 func DeleteUser(ctx context.Context, db database.DB, ID int) error {
 	_, err := db.Exec(ctx,
 		`DELETE FROM users WHERE id = $1`,
@@ -103,3 +144,135 @@ func DeleteUser(ctx context.Context, db database.DB, ID int) error {
 	}
 	return nil
 }
+
+// userSortColumns 為 ListUsers 可排序的欄位
+var userSortColumns = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
+// UserListOptions 為 ListUsers 的篩選、排序與 keyset 分頁條件
+type UserListOptions struct {
+	IsAdmin *bool
+	// EmailDomain 比對 email 的 @ 之後的部分
+	EmailDomain string
+	NamePrefix  string
+	// CreatedAfter、CreatedBefore 為建立時間區間 [after, before)，零值表示不限
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Sort 為排序欄位（id、name、email、created_at），空白為 id；同值時再依 id 排序
+	Sort string
+	Desc bool
+	// After 為上一頁的最後一筆，下一頁自其排序欄位與 id 之後開始
+	After *model.User
+	Limit int
+}
+
+// ListUsers 依條件列出使用者（不含密碼雜湊），並回傳不計分頁的符合總數
+func ListUsers(ctx context.Context, db database.DB, opts UserListOptions) ([]model.User, int, error) {
+	sort := opts.Sort
+	if sort == "" {
+		sort = "id"
+	}
+	column, ok := userSortColumns[sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("ListUsers: invalid sort field %q", opts.Sort)
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if opts.IsAdmin != nil {
+		where = append(where, "is_admin = "+arg(*opts.IsAdmin))
+	}
+	if opts.EmailDomain != "" {
+		where = append(where, "split_part(email, '@', 2) = "+arg(strings.ToLower(opts.EmailDomain)))
+	}
+	if opts.NamePrefix != "" {
+		where = append(where, "name LIKE "+arg(escapeLike(opts.NamePrefix)+"%"))
+	}
+	if !opts.CreatedAfter.IsZero() {
+		where = append(where, "created_at >= "+arg(opts.CreatedAfter))
+	}
+	if !opts.CreatedBefore.IsZero() {
+		where = append(where, "created_at < "+arg(opts.CreatedBefore))
+	}
+
+	var total int
+	if err := db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM users`+whereClause(where),
+		args...,
+	).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("ListUsers: %w", err)
+	}
+
+	dir, cmp := "ASC", ">"
+	if opts.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if a := opts.After; a != nil {
+		var v any
+		switch column {
+		case "name":
+			v = a.Name
+		case "email":
+			v = a.Email
+		case "created_at":
+			v = a.CreatedAt
+		}
+		if v == nil {
+			where = append(where, "id "+cmp+" "+arg(a.ID))
+		} else {
+			where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", column, cmp, arg(v), arg(a.ID)))
+		}
+	}
+	order := fmt.Sprintf(" ORDER BY %s %s", column, dir)
+	if column != "id" {
+		order += ", id " + dir
+	}
+	rows, err := db.Query(ctx,
+		`SELECT id, name, email, created_at, is_admin, email_verified
+         FROM users`+whereClause(where)+order+" LIMIT "+arg(opts.Limit),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ListUsers: %w", err)
+	}
+	defer rows.Close()
+	var users []model.User
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(
+			&u.ID,
+			&u.Name,
+			&u.Email,
+			&u.CreatedAt,
+			&u.IsAdmin,
+			&u.EmailVerified,
+		); err != nil {
+			return nil, 0, fmt.Errorf("scan User: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("rows error: %w", err)
+	}
+	return users, total, nil
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// escapeLike 跳脫 LIKE 的萬用字元，讓前綴依字面比對
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+)
+
+func GetUserTOTP(ctx context.Context, db database.DB, userID int) (*model.UserTOTP, error) {
+	row := db.QueryRow(ctx,
+		`SELECT user_id, secret, confirmed_at, last_used_step, created_at
+         FROM user_totp
+         WHERE user_id = $1`,
+		userID,
+	)
+	var t model.UserTOTP
+	if err := row.Scan(
+		&t.UserID,
+		&t.Secret,
+		&t.ConfirmedAt,
+		&t.LastUsedStep,
+		&t.CreatedAt,
+	); err != nil {
+		return nil, fmt.Errorf("GetUserTOTP: %w", err)
+	}
+	return &t, nil
+}
+
+// SavePendingUserTOTP 保存尚未確認的 TOTP 金鑰，取代先前未完成的註冊；已啟用時不變更並回傳 false
+func SavePendingUserTOTP(ctx context.Context, db database.DB, userID int, secret string) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`INSERT INTO user_totp (user_id, secret)
+         VALUES ($1, $2)
+         ON CONFLICT (user_id)
+         DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = now()
+         WHERE user_totp.confirmed_at IS NULL`,
+		userID,
+		secret,
+	)
+	if err != nil {
+		return false, fmt.Errorf("SavePendingUserTOTP: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ConfirmUserTOTP 啟用尚未確認的 TOTP，並記錄確認時使用的時間步；已啟用或不存在時回傳 false
+func ConfirmUserTOTP(ctx context.Context, db database.DB, userID int, step int64) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`UPDATE user_totp SET confirmed_at = now(), last_used_step = $2
+         WHERE user_id = $1 AND confirmed_at IS NULL`,
+		userID,
+		step,
+	)
+	if err != nil {
+		return false, fmt.Errorf("ConfirmUserTOTP: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// UseUserTOTPStep 記錄已使用的時間步；step 不晚於上次使用的時間步時視為重送並回傳 false
+func UseUserTOTPStep(ctx context.Context, db database.DB, userID int, step int64) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`UPDATE user_totp SET last_used_step = $2
+         WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2`,
+		userID,
+		step,
+	)
+	if err != nil {
+		return false, fmt.Errorf("UseUserTOTPStep: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ReplaceRecoveryCodes 以新的 recovery code 雜湊取代使用者所有的 recovery code
+func ReplaceRecoveryCodes(ctx context.Context, db database.DB, userID int, codeHashes []string) error {
+	_, err := db.Exec(ctx,
+		`WITH deleted AS (DELETE FROM user_recovery_codes WHERE user_id = $1)
+         INSERT INTO user_recovery_codes (user_id, code_hash)
+         SELECT $1, unnest($2::text[])`,
+		userID,
+		codeHashes,
+	)
+	if err != nil {
+		return fmt.Errorf("ReplaceRecoveryCodes: %w", err)
+	}
+	return nil
+}
+
+// UseRecoveryCode 將尚未使用的 recovery code 標記為已使用；不存在或已使用時回傳 false
+func UseRecoveryCode(ctx context.Context, db database.DB, userID int, codeHash string) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`UPDATE user_recovery_codes SET used_at = now()
+         WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID,
+		codeHash,
+	)
+	if err != nil {
+		return false, fmt.Errorf("UseRecoveryCode: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DeleteUserMFA 刪除使用者的 TOTP 與所有 recovery code
+func DeleteUserMFA(ctx context.Context, db database.DB, userID int) error {
+	_, err := db.Exec(ctx,
+		`WITH deleted AS (DELETE FROM user_recovery_codes WHERE user_id = $1)
+         DELETE FROM user_totp WHERE user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("DeleteUserMFA: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+/* ---------- 假實作 ---------- */
+
+// fakeTOTPRow 實作 pgx.Row，模擬 GetUserTOTP 的掃描。
+type fakeTOTPRow struct {
+	scanErr error
+	totp    *model.UserTOTP
+}
+
+func (r *fakeTOTPRow) Scan(dest ...any) error {
+	if r.scanErr != nil {
+		return r.scanErr
+	}
+	t := r.totp
+	*dest[0].(*int) = t.UserID
+	*dest[1].(*string) = t.Secret
+	*dest[2].(**time.Time) = t.ConfirmedAt
+	*dest[3].(*int64) = t.LastUsedStep
+	*dest[4].(*time.Time) = t.CreatedAt
+	return nil
+}
+
+/* ---------- 完整測試 ---------- */
+
+func TestUserMFARepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	sample := model.UserTOTP{UserID: 1, Secret: "secret", ConfirmedAt: &now, LastUsedStep: 42, CreatedAt: now}
+
+	/* GetUserTOTP */
+	t.Run("Get ok", func(t *testing.T) {
+		var gotArgs []any
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+				gotArgs = args
+				return &fakeTOTPRow{totp: &sample}
+			},
+		}
+		got, err := GetUserTOTP(ctx, p, 1)
+		require.NoError(t, err)
+		require.Equal(t, sample, *got)
+		require.True(t, got.Confirmed())
+		require.Equal(t, []any{1}, gotArgs)
+	})
+
+	t.Run("Get not found", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
+				return &fakeTOTPRow{scanErr: pgx.ErrNoRows}
+			},
+		}
+		_, err := GetUserTOTP(ctx, p, 1)
+		require.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	/* 以 Exec 回報是否有資料列受影響的操作 */
+	for name, exec := range map[string]func(database.DB) (bool, error){
+		"SavePendingUserTOTP": func(db database.DB) (bool, error) { return SavePendingUserTOTP(ctx, db, 1, "secret") },
+		"ConfirmUserTOTP":     func(db database.DB) (bool, error) { return ConfirmUserTOTP(ctx, db, 1, 42) },
+		"UseUserTOTPStep":     func(db database.DB) (bool, error) { return UseUserTOTPStep(ctx, db, 1, 42) },
+		"UseRecoveryCode":     func(db database.DB) (bool, error) { return UseRecoveryCode(ctx, db, 1, "hash") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			tag := pgconn.NewCommandTag("UPDATE 1")
+			p := &database.FakeDB{
+				ExecFn: func(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+					require.Equal(t, 1, args[0])
+					return tag, nil
+				},
+			}
+			ok, err := exec(p)
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			tag = pgconn.NewCommandTag("UPDATE 0")
+			ok, err = exec(p)
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			p.ExecFn = func(context.Context, string, ...any) (pgconn.CommandTag, error) {
+				return pgconn.CommandTag{}, errors.New("db")
+			}
+			_, err = exec(p)
+			require.ErrorContains(t, err, name)
+		})
+	}
+
+	/* ReplaceRecoveryCodes */
+	t.Run("ReplaceRecoveryCodes", func(t *testing.T) {
+		var gotArgs []any
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+				gotArgs = args
+				return pgconn.NewCommandTag("INSERT 0 2"), nil
+			},
+		}
+		require.NoError(t, ReplaceRecoveryCodes(ctx, p, 1, []string{"a", "b"}))
+		require.Equal(t, []any{1, []string{"a", "b"}}, gotArgs)
+
+		p.ExecFn = func(context.Context, string, ...any) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag{}, errors.New("db")
+		}
+		require.Error(t, ReplaceRecoveryCodes(ctx, p, 1, nil))
+	})
+
+	/* DeleteUserMFA */
+	t.Run("DeleteUserMFA", func(t *testing.T) {
+		var gotArgs []any
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+				gotArgs = args
+				return pgconn.NewCommandTag("DELETE 1"), nil
+			},
+		}
+		require.NoError(t, DeleteUserMFA(ctx, p, 1))
+		require.Equal(t, []any{1}, gotArgs)
+
+		p.ExecFn = func(context.Context, string, ...any) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag{}, errors.New("db")
+		}
+		require.Error(t, DeleteUserMFA(ctx, p, 1))
+	})
+}
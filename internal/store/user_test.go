@@ -17,7 +17,7 @@ import (
 /* ---------- 假實作 ---------- */
 
 // fakeUserRow 支援兩種 Scan 呼叫場景：
-// 1) len(dest)==6 → GetUserByID / GetUserByName
+// 1) len(dest)==7 → GetUserByID / GetUserByName / GetUserByEmail
 // 2) len(dest)==2 → CreateUser (id, created_at)
 type fakeUserRow struct {
 	scanErr error
@@ -30,13 +30,14 @@ func (r *fakeUserRow) Scan(dest ...any) error {
 	}
 	u := r.user
 	switch len(dest) {
-	case 6:
+	case 7:
 		*dest[0].(*int) = u.ID
 		*dest[1].(*string) = u.Name
 		*dest[2].(*string) = u.Email
 		*dest[3].(*string) = u.PasswordHash
 		*dest[4].(*time.Time) = u.CreatedAt
 		*dest[5].(*bool) = u.IsAdmin
+		*dest[6].(*bool) = u.EmailVerified
 	case 2:
 		*dest[0].(*int) = u.ID
 		*dest[1].(*time.Time) = u.CreatedAt
@@ -46,6 +47,42 @@ func (r *fakeUserRow) Scan(dest ...any) error {
 	return nil
 }
 
+// fakeCountRow 實作 pgx.Row，模擬 COUNT(*) 查詢
+type fakeCountRow struct {
+	scanErr error
+	count   int
+}
+
+func (r *fakeCountRow) Scan(dest ...any) error {
+	if r.scanErr != nil {
+		return r.scanErr
+	}
+	*dest[0].(*int) = r.count
+	return nil
+}
+
+// fakeUserRows 實作 pgx.Rows，模擬 ListUsers 的多筆掃描
+type fakeUserRows struct {
+	fakeRows
+	users []model.User
+}
+
+func (r *fakeUserRows) Next() bool { return r.idx < len(r.users) }
+func (r *fakeUserRows) Scan(dest ...any) error {
+	if r.scanErr != nil {
+		return r.scanErr
+	}
+	u := r.users[r.idx]
+	r.idx++
+	*dest[0].(*int) = u.ID
+	*dest[1].(*string) = u.Name
+	*dest[2].(*string) = u.Email
+	*dest[3].(*time.Time) = u.CreatedAt
+	*dest[4].(*bool) = u.IsAdmin
+	*dest[5].(*bool) = u.EmailVerified
+	return nil
+}
+
 /* ---------- 完整測試 ---------- */
 
 func TestUserRepository(t *testing.T) {
@@ -106,6 +143,32 @@ func TestUserRepository(t *testing.T) {
 		require.Nil(t, u)
 	})
 
+	/* --- GetUserByEmail --- */
+	t.Run("GetUserByEmail success", func(t *testing.T) {
+		var gotArgs []any
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+				gotArgs = args
+				return &fakeUserRow{user: sample}
+			},
+		}
+		u, err := GetUserByEmail(context.Background(), p, "alice@example.com")
+		require.NoError(t, err)
+		require.Equal(t, 7, u.ID)
+		require.Equal(t, []any{"alice@example.com"}, gotArgs)
+	})
+
+	t.Run("GetUserByEmail not found", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
+				return &fakeUserRow{scanErr: pgx.ErrNoRows}
+			},
+		}
+		u, err := GetUserByEmail(context.Background(), p, "bob@example.com")
+		require.ErrorIs(t, err, pgx.ErrNoRows)
+		require.Nil(t, u)
+	})
+
 	/* --- CreateUser --- */
 	t.Run("CreateUser success", func(t *testing.T) {
 		newUser := &model.User{Name: "Bob", Email: "bob@example.com", PasswordHash: "pwdhash", IsAdmin: false}
@@ -175,6 +238,38 @@ func TestUserRepository(t *testing.T) {
 		require.Error(t, err)
 	})
 
+	/* --- VerifyUserEmail --- */
+	t.Run("VerifyUserEmail", func(t *testing.T) {
+		var gotArgs []any
+		tag := pgconn.NewCommandTag("UPDATE 1")
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+				gotArgs = args
+				return tag, nil
+			},
+		}
+		ok, err := VerifyUserEmail(context.Background(), p, 7, "alice@example.com")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []any{7, "alice@example.com"}, gotArgs)
+
+		// Email 已變更
+		tag = pgconn.NewCommandTag("UPDATE 0")
+		ok, err = VerifyUserEmail(context.Background(), p, 7, "old@example.com")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("VerifyUserEmail error", func(t *testing.T) {
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, _ ...any) (pgconn.CommandTag, error) {
+				return pgconn.CommandTag{}, errors.New("verify failed")
+			},
+		}
+		_, err := VerifyUserEmail(context.Background(), p, 7, "alice@example.com")
+		require.Error(t, err)
+	})
+
 	/* --- DeleteUser --- */
 	t.Run("DeleteUser success", func(t *testing.T) {
 		p := &database.FakeDB{
@@ -195,4 +290,104 @@ func TestUserRepository(t *testing.T) {
 		err := DeleteUser(context.Background(), p, 7)
 		require.Error(t, err)
 	})
+
+	/* --- ListUsers --- */
+	t.Run("ListUsers defaults", func(t *testing.T) {
+		var countSQL, listSQL string
+		var countArgs, listArgs []any
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, sql string, args ...any) pgx.Row {
+				countSQL, countArgs = sql, args
+				return &fakeCountRow{count: 2}
+			},
+			QueryFn: func(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+				listSQL, listArgs = sql, args
+				return &fakeUserRows{users: []model.User{*sample, {ID: 8, Name: "Bob"}}}, nil
+			},
+		}
+		users, total, err := ListUsers(context.Background(), p, UserListOptions{Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, 2, total)
+		require.Len(t, users, 2)
+		require.Equal(t, "alice@example.com", users[0].Email)
+		require.Empty(t, users[0].PasswordHash)
+		require.NotContains(t, countSQL, "WHERE")
+		require.Empty(t, countArgs)
+		require.Contains(t, listSQL, "ORDER BY id ASC LIMIT $1")
+		require.Equal(t, []any{10}, listArgs)
+	})
+
+	t.Run("ListUsers filters and cursor", func(t *testing.T) {
+		isAdmin := true
+		after := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		var countSQL, listSQL string
+		var countArgs, listArgs []any
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, sql string, args ...any) pgx.Row {
+				countSQL, countArgs = sql, args
+				return &fakeCountRow{count: 5}
+			},
+			QueryFn: func(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+				listSQL, listArgs = sql, args
+				return &fakeUserRows{}, nil
+			},
+		}
+		users, total, err := ListUsers(context.Background(), p, UserListOptions{
+			IsAdmin:      &isAdmin,
+			EmailDomain:  "Example.COM",
+			NamePrefix:   "a_%",
+			CreatedAfter: after,
+			Sort:         "created_at",
+			Desc:         true,
+			After:        &model.User{ID: 7, CreatedAt: now},
+			Limit:        3,
+		})
+		require.NoError(t, err)
+		require.Empty(t, users)
+		require.Equal(t, 5, total)
+		require.Contains(t, countSQL, "WHERE is_admin = $1 AND split_part(email, '@', 2) = $2 AND name LIKE $3 AND created_at >= $4")
+		require.Equal(t, []any{true, "example.com", `a\_\%%`, after}, countArgs)
+		require.Contains(t, listSQL, "AND (created_at, id) < ($5, $6) ORDER BY created_at DESC, id DESC LIMIT $7")
+		require.Equal(t, append(countArgs, now, 7, 3), listArgs)
+	})
+
+	t.Run("ListUsers id cursor", func(t *testing.T) {
+		var listSQL string
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row { return &fakeCountRow{} },
+			QueryFn: func(_ context.Context, sql string, _ ...any) (pgx.Rows, error) {
+				listSQL = sql
+				return &fakeUserRows{}, nil
+			},
+		}
+		_, _, err := ListUsers(context.Background(), p, UserListOptions{After: &model.User{ID: 7}, Limit: 3})
+		require.NoError(t, err)
+		require.Contains(t, listSQL, "WHERE id > $1 ORDER BY id ASC LIMIT $2")
+	})
+
+	t.Run("ListUsers errors", func(t *testing.T) {
+		_, _, err := ListUsers(context.Background(), &database.FakeDB{}, UserListOptions{Sort: "password_hash"})
+		require.ErrorContains(t, err, "invalid sort field")
+
+		countErr := &database.FakeDB{QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
+			return &fakeCountRow{scanErr: errors.New("count")}
+		}}
+		_, _, err = ListUsers(context.Background(), countErr, UserListOptions{})
+		require.ErrorContains(t, err, "count")
+
+		for name, rows := range map[string]func() (pgx.Rows, error){
+			"query": func() (pgx.Rows, error) { return nil, errors.New("query") },
+			"scan": func() (pgx.Rows, error) {
+				return &fakeUserRows{fakeRows: fakeRows{scanErr: errors.New("scan")}, users: []model.User{*sample}}, nil
+			},
+			"rows": func() (pgx.Rows, error) { return &fakeUserRows{fakeRows: fakeRows{err: errors.New("rows")}}, nil },
+		} {
+			p := &database.FakeDB{
+				QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row { return &fakeCountRow{} },
+				QueryFn:    func(_ context.Context, _ string, _ ...any) (pgx.Rows, error) { return rows() },
+			}
+			_, _, err := ListUsers(context.Background(), p, UserListOptions{})
+			require.ErrorContains(t, err, name)
+		}
+	})
 }
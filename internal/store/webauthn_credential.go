@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+)
+
+// CreateWebAuthnCredential 新增 credential；相同 credential ID 已註冊時不變更並回傳 false
+func CreateWebAuthnCredential(ctx context.Context, db database.DB, c *model.WebAuthnCredential) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`INSERT INTO webauthn_credentials (id, user_id, name, public_key, sign_count, transports)
+         VALUES ($1, $2, $3, $4, $5, $6)
+         ON CONFLICT (id) DO NOTHING`,
+		c.ID,
+		c.UserID,
+		c.Name,
+		c.PublicKey,
+		c.SignCount,
+		c.Transports,
+	)
+	if err != nil {
+		return false, fmt.Errorf("CreateWebAuthnCredential: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func GetWebAuthnCredential(ctx context.Context, db database.DB, id string) (*model.WebAuthnCredential, error) {
+	row := db.QueryRow(ctx,
+		`SELECT id, user_id, name, public_key, sign_count, transports, created_at, last_used_at
+         FROM webauthn_credentials
+         WHERE id = $1`,
+		id,
+	)
+	var c model.WebAuthnCredential
+	if err := row.Scan(
+		&c.ID,
+		&c.UserID,
+		&c.Name,
+		&c.PublicKey,
+		&c.SignCount,
+		&c.Transports,
+		&c.CreatedAt,
+		&c.LastUsedAt,
+	); err != nil {
+		return nil, fmt.Errorf("GetWebAuthnCredential: %w", err)
+	}
+	return &c, nil
+}
+
+func ListWebAuthnCredentials(ctx context.Context, db database.DB, userID int) ([]model.WebAuthnCredential, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, user_id, name, public_key, sign_count, transports, created_at, last_used_at
+         FROM webauthn_credentials
+         WHERE user_id = $1
+         ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ListWebAuthnCredentials: %w", err)
+	}
+	defer rows.Close()
+	var creds []model.WebAuthnCredential
+	for rows.Next() {
+		var c model.WebAuthnCredential
+		if err := rows.Scan(
+			&c.ID,
+			&c.UserID,
+			&c.Name,
+			&c.PublicKey,
+			&c.SignCount,
+			&c.Transports,
+			&c.CreatedAt,
+			&c.LastUsedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan WebAuthnCredential: %w", err)
+		}
+		creds = append(creds, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return creds, nil
+}
+
+// UseWebAuthnCredential 記錄 credential 的使用並更新簽章計數；sign_count 已非 prevSignCount（併發使用）時回傳 false
+func UseWebAuthnCredential(ctx context.Context, db database.DB, id string, prevSignCount, signCount int64) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`UPDATE webauthn_credentials SET sign_count = $3, last_used_at = now()
+         WHERE id = $1 AND sign_count = $2`,
+		id,
+		prevSignCount,
+		signCount,
+	)
+	if err != nil {
+		return false, fmt.Errorf("UseWebAuthnCredential: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RenameWebAuthnCredential 變更使用者自己的 credential 名稱，回傳是否有 credential 被更新
+func RenameWebAuthnCredential(ctx context.Context, db database.DB, userID int, id, name string) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`UPDATE webauthn_credentials SET name = $3 WHERE user_id = $1 AND id = $2`,
+		userID,
+		id,
+		name,
+	)
+	if err != nil {
+		return false, fmt.Errorf("RenameWebAuthnCredential: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DeleteWebAuthnCredential 刪除使用者自己的 credential，回傳是否有 credential 被刪除
+func DeleteWebAuthnCredential(ctx context.Context, db database.DB, userID int, id string) (bool, error) {
+	tag, err := db.Exec(ctx,
+		`DELETE FROM webauthn_credentials WHERE user_id = $1 AND id = $2`,
+		userID,
+		id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("DeleteWebAuthnCredential: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
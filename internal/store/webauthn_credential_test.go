@@ -0,0 +1,191 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"life-is-hard/internal/database"
+	"life-is-hard/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+/* ---------- 假實作 ---------- */
+
+func scanWebAuthnCredential(c model.WebAuthnCredential, dest []any) {
+	*dest[0].(*string) = c.ID
+	*dest[1].(*int) = c.UserID
+	*dest[2].(*string) = c.Name
+	*dest[3].(*[]byte) = c.PublicKey
+	*dest[4].(*int64) = c.SignCount
+	*dest[5].(*[]string) = c.Transports
+	*dest[6].(*time.Time) = c.CreatedAt
+	*dest[7].(**time.Time) = c.LastUsedAt
+}
+
+// fakeWebAuthnRow 實作 pgx.Row，模擬 GetWebAuthnCredential 的掃描。
+type fakeWebAuthnRow struct {
+	scanErr error
+	cred    *model.WebAuthnCredential
+}
+
+func (r *fakeWebAuthnRow) Scan(dest ...any) error {
+	if r.scanErr != nil {
+		return r.scanErr
+	}
+	scanWebAuthnCredential(*r.cred, dest)
+	return nil
+}
+
+// fakeWebAuthnRows 實作 pgx.Rows，模擬 ListWebAuthnCredentials 的多筆掃描。
+type fakeWebAuthnRows struct {
+	fakeRows
+	creds []model.WebAuthnCredential
+}
+
+func (r *fakeWebAuthnRows) Next() bool { return r.idx < len(r.creds) }
+func (r *fakeWebAuthnRows) Scan(dest ...any) error {
+	if r.scanErr != nil {
+		return r.scanErr
+	}
+	scanWebAuthnCredential(r.creds[r.idx], dest)
+	r.idx++
+	return nil
+}
+
+/* ---------- 完整測試 ---------- */
+
+func TestWebAuthnCredentialRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	sample := model.WebAuthnCredential{
+		ID:         "Y3JlZC0x",
+		UserID:     1,
+		Name:       "YubiKey",
+		PublicKey:  []byte{0xa5},
+		SignCount:  7,
+		Transports: []string{"usb"},
+		CreatedAt:  now,
+		LastUsedAt: &now,
+	}
+
+	/* CreateWebAuthnCredential */
+	t.Run("Create", func(t *testing.T) {
+		var gotArgs []any
+		tag := pgconn.NewCommandTag("INSERT 0 1")
+		p := &database.FakeDB{
+			ExecFn: func(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+				gotArgs = args
+				return tag, nil
+			},
+		}
+		ok, err := CreateWebAuthnCredential(ctx, p, &sample)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []any{"Y3JlZC0x", 1, "YubiKey", []byte{0xa5}, int64(7), []string{"usb"}}, gotArgs)
+
+		tag = pgconn.NewCommandTag("INSERT 0 0")
+		ok, err = CreateWebAuthnCredential(ctx, p, &sample)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		p.ExecFn = func(context.Context, string, ...any) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag{}, errors.New("db")
+		}
+		_, err = CreateWebAuthnCredential(ctx, p, &sample)
+		require.ErrorContains(t, err, "CreateWebAuthnCredential")
+	})
+
+	/* GetWebAuthnCredential */
+	t.Run("Get ok", func(t *testing.T) {
+		var gotArgs []any
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, args ...any) pgx.Row {
+				gotArgs = args
+				return &fakeWebAuthnRow{cred: &sample}
+			},
+		}
+		got, err := GetWebAuthnCredential(ctx, p, "Y3JlZC0x")
+		require.NoError(t, err)
+		require.Equal(t, sample, *got)
+		require.Equal(t, []any{"Y3JlZC0x"}, gotArgs)
+	})
+
+	t.Run("Get not found", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryRowFn: func(_ context.Context, _ string, _ ...any) pgx.Row {
+				return &fakeWebAuthnRow{scanErr: pgx.ErrNoRows}
+			},
+		}
+		_, err := GetWebAuthnCredential(ctx, p, "x")
+		require.ErrorIs(t, err, pgx.ErrNoRows)
+	})
+
+	/* ListWebAuthnCredentials */
+	t.Run("List ok", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryFn: func(_ context.Context, _ string, args ...any) (pgx.Rows, error) {
+				require.Equal(t, []any{1}, args)
+				return &fakeWebAuthnRows{creds: []model.WebAuthnCredential{sample, sample}}, nil
+			},
+		}
+		got, err := ListWebAuthnCredentials(ctx, p, 1)
+		require.NoError(t, err)
+		require.Equal(t, []model.WebAuthnCredential{sample, sample}, got)
+	})
+
+	t.Run("List errors", func(t *testing.T) {
+		p := &database.FakeDB{
+			QueryFn: func(context.Context, string, ...any) (pgx.Rows, error) { return nil, errors.New("q") },
+		}
+		_, err := ListWebAuthnCredentials(ctx, p, 1)
+		require.ErrorContains(t, err, "ListWebAuthnCredentials")
+
+		p.QueryFn = func(context.Context, string, ...any) (pgx.Rows, error) {
+			return &fakeWebAuthnRows{fakeRows: fakeRows{scanErr: errors.New("scan")}, creds: []model.WebAuthnCredential{sample}}, nil
+		}
+		_, err = ListWebAuthnCredentials(ctx, p, 1)
+		require.ErrorContains(t, err, "scan WebAuthnCredential")
+
+		p.QueryFn = func(context.Context, string, ...any) (pgx.Rows, error) {
+			return &fakeWebAuthnRows{fakeRows: fakeRows{err: errors.New("rows")}}, nil
+		}
+		_, err = ListWebAuthnCredentials(ctx, p, 1)
+		require.ErrorContains(t, err, "rows error")
+	})
+
+	/* 以 Exec 回報是否有資料列受影響的操作 */
+	for name, exec := range map[string]func(database.DB) (bool, error){
+		"UseWebAuthnCredential":    func(db database.DB) (bool, error) { return UseWebAuthnCredential(ctx, db, "Y3JlZC0x", 7, 8) },
+		"RenameWebAuthnCredential": func(db database.DB) (bool, error) { return RenameWebAuthnCredential(ctx, db, 1, "Y3JlZC0x", "Laptop") },
+		"DeleteWebAuthnCredential": func(db database.DB) (bool, error) { return DeleteWebAuthnCredential(ctx, db, 1, "Y3JlZC0x") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			tag := pgconn.NewCommandTag("UPDATE 1")
+			p := &database.FakeDB{
+				ExecFn: func(_ context.Context, _ string, args ...any) (pgconn.CommandTag, error) {
+					require.Contains(t, args, "Y3JlZC0x")
+					return tag, nil
+				},
+			}
+			ok, err := exec(p)
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			tag = pgconn.NewCommandTag("UPDATE 0")
+			ok, err = exec(p)
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			p.ExecFn = func(context.Context, string, ...any) (pgconn.CommandTag, error) {
+				return pgconn.CommandTag{}, errors.New("db")
+			}
+			_, err = exec(p)
+			require.ErrorContains(t, err, name)
+		})
+	}
+}